@@ -0,0 +1,369 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// BakeFile is the path, relative to the primary repository root, of the
+// Dockerfile snippet applied on top of the user image when starting a
+// container. md bake appends to it; it may also be edited by hand. It must
+// not contain a FROM instruction.
+const BakeFile = ".md/bake.Dockerfile"
+
+// bakeFilesDir is the directory, relative to the .md build context, holding
+// files captured by Bake and copied by BakeFile.
+const bakeFilesDir = "bake"
+
+// Bake appends Dockerfile instructions reproducing changes to the primary
+// repository's [BakeFile], so the next container starts with them. Package
+// installs (apt, npm, go, cargo, python) become RUN instructions; dotfiles,
+// /etc files and binaries are copied from the container into the .md
+// directory and become COPY instructions. Returns the instructions that were
+// added. With dryRun, nothing is written.
+//
+// Changes in the system and other categories can't be reproduced reliably
+// and are rejected; install the package that owns them instead.
+func (c *Container) Bake(ctx context.Context, stdout, stderr io.Writer, changes []FSChange, dryRun bool) ([]string, error) {
+	if len(c.Repos) == 0 {
+		return nil, errors.New("no repository to store the bake file in")
+	}
+	mdDir := filepath.Join(c.Repos[0].GitRoot, filepath.Dir(BakeFile))
+	var goMods, crates map[string]string
+	for _, ch := range changes {
+		switch ch.Category {
+		case FSGo:
+			if goMods == nil {
+				goMods = c.goInstalledModules(ctx, changes)
+			}
+		case FSCargo:
+			if crates == nil {
+				out, _ := runCmd(ctx, "", c.SSHCommand(c.Name, "cat ~/.cargo/.crates.toml"))
+				crates = parseCratesToml(out)
+			}
+		}
+	}
+	lines, copies, err := bakeInstructions(changes, goMods, crates)
+	if err != nil {
+		return nil, err
+	}
+	bakePath := filepath.Join(c.Repos[0].GitRoot, filepath.FromSlash(BakeFile))
+	existing, err := os.ReadFile(bakePath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	var added []string
+	for _, l := range lines {
+		if !slices.Contains(strings.Split(string(existing), "\n"), l) {
+			added = append(added, l)
+		}
+	}
+	if dryRun || len(added) == 0 {
+		return added, nil
+	}
+	for _, p := range copies {
+		dst := filepath.Join(mdDir, bakeFilesDir, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return nil, err
+		}
+		if err := os.RemoveAll(dst); err != nil {
+			return nil, err
+		}
+		if err := runCmdOut(ctx, "", []string{c.Runtime, "cp", c.Name + ":" + p, dst}, stdout, stderr); err != nil {
+			return nil, fmt.Errorf("copying %s from container: %w", p, err)
+		}
+	}
+	if err := os.MkdirAll(mdDir, 0o755); err != nil {
+		return nil, err
+	}
+	content := existing
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		content = append(content, '\n')
+	}
+	if len(content) == 0 {
+		content = []byte("# Applied on top of the md user image by md start. Generated by md bake;\n# edit freely. Instructions run as root.\n")
+	}
+	for _, l := range added {
+		content = append(content, l+"\n"...)
+	}
+	if err := os.WriteFile(bakePath, content, 0o644); err != nil {
+		return nil, err
+	}
+	return added, nil
+}
+
+// bakeNameRes are the names bakeInstructions accepts by category: package
+// names, "module@version" for go and "crate@version" for cargo. They are
+// written unquoted in shell commands, so anything else is rejected rather
+// than risk running a name crafted in the container on the host's next build.
+var bakeNameRes = map[FSChangeCategory]*regexp.Regexp{
+	FSApt:    regexp.MustCompile(`^[a-z0-9][a-z0-9.+-]*(:[a-z0-9-]+)?$`),
+	FSNpm:    regexp.MustCompile(`^(@[A-Za-z0-9][A-Za-z0-9._~-]*/)?[A-Za-z0-9._~-]+$`),
+	FSGo:     regexp.MustCompile(`^[A-Za-z0-9._~/-]+@[A-Za-z0-9._+~-]+$`),
+	FSCargo:  regexp.MustCompile(`^[A-Za-z0-9_-]+@[A-Za-z0-9.+-]+$`),
+	FSPython: regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._-]*[A-Za-z0-9])?$`),
+}
+
+// bakePathRe matches the container paths bakeInstructions copies or removes.
+// Dockerfile instructions end at a newline and COPY expands wildcards and
+// variables, so paths with other characters are rejected.
+var bakePathRe = regexp.MustCompile(`^(/[A-Za-z0-9._+@,=~-]+)+$`)
+
+// checkBakeName returns an error when name, from a change in category,
+// doesn't match bakeNameRes.
+func checkBakeName(category FSChangeCategory, name string) error {
+	if !bakeNameRes[category].MatchString(name) {
+		return fmt.Errorf("can't bake %s package %q: unexpected characters in its name", category, name)
+	}
+	return nil
+}
+
+// checkBakePath returns an error when p isn't a clean absolute path matching
+// bakePathRe.
+func checkBakePath(p string) error {
+	if !bakePathRe.MatchString(p) || path.Clean(p) != p {
+		return fmt.Errorf("can't bake %q: unexpected characters in the path", p)
+	}
+	return nil
+}
+
+// bakeInstructions converts changes into Dockerfile instructions and the
+// container paths that must be captured for COPY instructions. goMods maps a
+// go binary name to "module@version"; crates maps a cargo binary name to
+// "crate@version".
+func bakeInstructions(changes []FSChange, goMods, crates map[string]string) (lines, copies []string, err error) {
+	var apt, npm, goPkgs, cargo, uvTools, pip []string
+	for _, ch := range changes {
+		if ch.Kind == "deleted" {
+			switch ch.Category {
+			case FSDotfile, FSConfig, FSBin:
+				if err := checkBakePath(ch.Path); err != nil {
+					return nil, nil, err
+				}
+				lines = append(lines, "RUN rm -rf "+shellQuote(ch.Path))
+				continue
+			default:
+				return nil, nil, fmt.Errorf("can't bake removal of %s %s", ch.Category, ch.Item)
+			}
+		}
+		switch ch.Category {
+		case FSApt, FSNpm, FSPython:
+			if err := checkBakeName(ch.Category, ch.Item); err != nil {
+				return nil, nil, err
+			}
+		}
+		switch ch.Category {
+		case FSApt:
+			apt = append(apt, ch.Item)
+		case FSNpm:
+			npm = append(npm, ch.Item)
+		case FSGo:
+			m, ok := goMods[ch.Item]
+			if !ok {
+				return nil, nil, fmt.Errorf("can't determine the module of go binary %s", ch.Item)
+			}
+			if err := checkBakeName(FSGo, m); err != nil {
+				return nil, nil, err
+			}
+			goPkgs = append(goPkgs, m)
+		case FSCargo:
+			cr, ok := crates[ch.Item]
+			if !ok {
+				return nil, nil, fmt.Errorf("can't determine the crate of cargo binary %s", ch.Item)
+			}
+			if err := checkBakeName(FSCargo, cr); err != nil {
+				return nil, nil, err
+			}
+			if !slices.Contains(cargo, cr) {
+				cargo = append(cargo, cr)
+			}
+		case FSPython:
+			if strings.HasPrefix(ch.Path, "/home/user/.local/share/uv/tools/") {
+				uvTools = append(uvTools, ch.Item)
+			} else {
+				pip = append(pip, ch.Item)
+			}
+		case FSDotfile, FSConfig, FSBin:
+			if err := checkBakePath(ch.Path); err != nil {
+				return nil, nil, err
+			}
+			copies = append(copies, ch.Path)
+			chown := ""
+			if strings.HasPrefix(ch.Path, "/home/user/") {
+				chown = "--chown=user:user "
+			}
+			lines = append(lines, fmt.Sprintf("COPY %s%s %s", chown, path.Join(bakeFilesDir, ch.Path), ch.Path))
+		default:
+			return nil, nil, fmt.Errorf("can't bake %s change %s; install the package that owns it instead", ch.Category, ch.Item)
+		}
+	}
+	// Package installs come first so copied config can override defaults.
+	var pkgs []string
+	if len(apt) > 0 {
+		pkgs = append(pkgs, "RUN apt-get update && apt-get install -y --no-install-recommends "+strings.Join(apt, " ")+" && rm -rf /var/lib/apt/lists/*")
+	}
+	// User-level installs rely on the PATH set up by BASH_ENV, which su
+	// keeps when it runs user's login shell (bash).
+	user := func(cmd string) string {
+		return "RUN su user -c " + shellQuote(cmd)
+	}
+	if len(npm) > 0 {
+		pkgs = append(pkgs, user("npm install -g "+strings.Join(npm, " ")))
+	}
+	for _, m := range goPkgs {
+		pkgs = append(pkgs, user("go install "+m))
+	}
+	for _, cr := range cargo {
+		pkgs = append(pkgs, user("cargo install --locked "+cr))
+	}
+	for _, t := range uvTools {
+		pkgs = append(pkgs, user("uv tool install "+t))
+	}
+	if len(pip) > 0 {
+		pkgs = append(pkgs, user("pip install --user --break-system-packages "+strings.Join(pip, " ")))
+	}
+	return append(pkgs, lines...), copies, nil
+}
+
+// goInstalledModules maps go binaries among changes to "module@version" by
+// reading their embedded build info.
+func (c *Container) goInstalledModules(ctx context.Context, changes []FSChange) map[string]string {
+	mods := map[string]string{}
+	for _, ch := range changes {
+		if ch.Category != FSGo {
+			continue
+		}
+		out, err := runCmd(ctx, "", c.SSHCommand(c.Name, "go version -m "+shellQuote(ch.Path)))
+		if err != nil {
+			continue
+		}
+		if m := parseGoVersionM(out); m != "" {
+			mods[ch.Item] = m
+		}
+	}
+	return mods
+}
+
+// parseGoVersionM extracts "package@version" from "go version -m" output.
+func parseGoVersionM(out string) string {
+	var pkg, version string
+	for line := range strings.SplitSeq(out, "\n") {
+		f := strings.Fields(line)
+		if len(f) >= 2 && f[0] == "path" {
+			pkg = f[1]
+		}
+		if len(f) >= 3 && f[0] == "mod" {
+			version = f[2]
+		}
+	}
+	if pkg == "" {
+		return ""
+	}
+	if version == "" || version == "(devel)" {
+		version = "latest"
+	}
+	return pkg + "@" + version
+}
+
+// parseCratesToml maps installed binary names to "crate@version" from
+// ~/.cargo/.crates.toml, whose lines look like:
+//
+//	"ripgrep 14.1.0 (registry+https://github.com/rust-lang/crates.io-index)" = ["rg"]
+func parseCratesToml(s string) map[string]string {
+	m := map[string]string{}
+	for line := range strings.SplitSeq(s, "\n") {
+		k, v, ok := strings.Cut(line, " = ")
+		if !ok {
+			continue
+		}
+		f := strings.Fields(strings.Trim(k, `"`))
+		if len(f) < 2 {
+			continue
+		}
+		for b := range strings.SplitSeq(strings.Trim(strings.TrimSpace(v), "[]"), ",") {
+			if b = strings.Trim(strings.TrimSpace(b), `"`); b != "" {
+				m[b] = f[0] + "@" + f[1]
+			}
+		}
+	}
+	return m
+}
+
+// ensureBakedImage layers the primary repository's [BakeFile] on top of
+// imageName. It returns imageName unchanged when there is no bake file. The
+// result is tagged md-baked-<hash> over the base image ID and the .md
+// directory contents, so it is rebuilt only when either changes.
+func (c *Container) ensureBakedImage(ctx context.Context, stdout, stderr io.Writer, imageName string, quiet bool) (string, error) {
+	if len(c.Repos) == 0 {
+		return imageName, nil
+	}
+	mdDir := filepath.Join(c.Repos[0].GitRoot, filepath.Dir(BakeFile))
+	snippet, err := os.ReadFile(filepath.Join(c.Repos[0].GitRoot, filepath.FromSlash(BakeFile)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return imageName, nil
+	} else if err != nil {
+		return "", err
+	}
+	base, err := inspectImage(ctx, c.Runtime, imageName)
+	if err != nil {
+		return "", fmt.Errorf("inspecting %s: %w", imageName, err)
+	}
+	h := sha256.New()
+	_, _ = io.WriteString(h, base.ID+"\x00")
+	err = filepath.WalkDir(mdDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(mdDir, p)
+		_, _ = fmt.Fprintf(h, "%s\x00%d\x00", filepath.ToSlash(rel), len(data))
+		_, _ = h.Write(data)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	baked := "md-baked-" + hex.EncodeToString(h.Sum(nil)[:16])
+	if _, err := inspectImage(ctx, c.Runtime, baked); err == nil {
+		return baked, nil
+	}
+	if !quiet {
+		_, _ = fmt.Fprintf(stdout, "- Applying %s on top of the image ...\n", BakeFile)
+	}
+	tmp, err := os.MkdirTemp("", "md-bake-")
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = os.RemoveAll(tmp) }()
+	df := "FROM " + imageName + "\n" + string(snippet) + "\nUSER root\n"
+	dfPath := filepath.Join(tmp, "Dockerfile")
+	if err := os.WriteFile(dfPath, []byte(df), 0o644); err != nil {
+		return "", err
+	}
+	args := append([]string{c.Runtime, "build", "--label", buildLabel, "-f", dfPath, "-t", baked}, c.Proxy.buildArgs()...)
+	if quiet {
+		args = append(args, "-q")
+	}
+	if err := runBuildCmd(ctx, append(args, mdDir), stdout, stderr); err != nil {
+		return "", fmt.Errorf("applying %s: %w", BakeFile, err)
+	}
+	return baked, nil
+}
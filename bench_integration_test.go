@@ -0,0 +1,49 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+//go:build bench
+
+package md
+
+import (
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// BenchmarkFlows runs the flows of [Client.Bench] against the real container
+// runtime, reporting each flow as a metric. Run with:
+//
+//	go test -tags bench -run '^$' -bench Flows -benchtime 3x .
+func BenchmarkFlows(b *testing.B) {
+	ctx := b.Context()
+	c, err := New(io.Discard)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if _, err := c.Warmup(ctx, io.Discard, io.Discard, &WarmupOpts{Quiet: true}); err != nil {
+		b.Fatal(err)
+	}
+	dir := filepath.Join(b.TempDir(), "mdbench")
+	if err := newBenchRepo(ctx, dir, 1000, 4096); err != nil {
+		b.Fatal(err)
+	}
+	samples := map[string][]time.Duration{}
+	for b.Loop() {
+		ct := c.Container(Repo{GitRoot: dir, Branch: "main", DefaultRemote: "origin", DefaultBranch: "main"})
+		err := benchRun(ctx, ct, &StartOpts{Quiet: true}, func(flow string, d time.Duration) {
+			samples[flow] = append(samples[flow], d)
+		})
+		if perr := ct.Purge(ctx, io.Discard, io.Discard); perr != nil && err == nil {
+			err = perr
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	for _, flow := range BenchFlows {
+		b.ReportMetric(float64(median(samples[flow]).Milliseconds()), "ms/"+flow)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caic-xyz/md/gitutil"
+)
+
+func TestCompareBench(t *testing.T) {
+	results := []BenchTiming{{"cold_start", 3 * time.Second}, {"push", 1100 * time.Millisecond}, {"diff", time.Second}}
+	baseline := []BenchTiming{{"cold_start", 2 * time.Second}, {"push", time.Second}}
+	want := []BenchComparison{
+		{Name: "cold_start", Median: 3 * time.Second, Baseline: 2 * time.Second, Change: 50, OverBudget: true},
+		{Name: "push", Median: 1100 * time.Millisecond, Baseline: time.Second, Change: 10},
+		{Name: "diff", Median: time.Second},
+	}
+	got := CompareBench(results, baseline, 20)
+	for i := range got {
+		// Avoid float rounding noise.
+		got[i].Change = float64(int(got[i].Change + 0.5))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestBenchBaseline(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "md", "bench.json")
+	if got, err := LoadBenchBaseline(p); err != nil || got != nil {
+		t.Fatalf("LoadBenchBaseline() = %v, %v", got, err)
+	}
+	want := []BenchTiming{{"cold_start", 2 * time.Second}, {"diff", 50 * time.Millisecond}}
+	if err := SaveBenchBaseline(p, want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadBenchBaseline(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestMedian(t *testing.T) {
+	for _, tc := range []struct {
+		in   []time.Duration
+		want time.Duration
+	}{
+		{nil, 0},
+		{[]time.Duration{3, 1, 2}, 2},
+		{[]time.Duration{4, 1, 3, 2}, 2},
+	} {
+		if got := median(tc.in); got != tc.want {
+			t.Errorf("median(%v) = %v, want %v", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestNewBenchRepo(t *testing.T) {
+	ctx := t.Context()
+	dir := filepath.Join(t.TempDir(), "repo")
+	if err := newBenchRepo(ctx, dir, 40, 100); err != nil {
+		t.Fatal(err)
+	}
+	out, err := gitutil.RunGit(ctx, dir, "ls-files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(out, "\n") + 1; got != 40 {
+		t.Errorf("got %d files, want 40", got)
+	}
+	if err := benchCommit(ctx, dir); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := gitutil.RunGit(ctx, dir, "rev-list", "--count", "main"); err != nil || out != "2" {
+		t.Errorf("rev-list = %q, %v", out, err)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/caic-xyz/md/gitutil"
+)
+
+// BranchVars are the variables of a branch name template, e.g.
+// "agent/{{.Agent}}/{{.IssueNumber}}-{{.Slug}}".
+type BranchVars struct {
+	// Agent is the agent's name, e.g. "claude" for "claude -p".
+	Agent string
+	// IssueNumber is the GitHub issue worked on, 0 without one.
+	IssueNumber int
+	// Slug is the issue's title, or the source branch of a fork, in lowercase
+	// words joined by dashes.
+	Slug string
+	// Branch is the source branch of a fork.
+	Branch string
+	// Repo is the repository's directory name.
+	Repo string
+}
+
+// ParseBranchTemplate parses the branch name template tmpl. A variable not in
+// [BranchVars] is an error.
+func ParseBranchTemplate(tmpl string) (*template.Template, error) {
+	t, err := template.New("branch").Parse(tmpl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid branch template %q: %w", tmpl, err)
+	}
+	if err := t.Execute(io.Discard, &BranchVars{}); err != nil {
+		return nil, fmt.Errorf("invalid branch template %q: %w", tmpl, err)
+	}
+	return t, nil
+}
+
+// RenderBranch returns the branch name tmpl gives for v, checked against
+// git's rules for branch names.
+func RenderBranch(tmpl string, v *BranchVars) (string, error) {
+	t, err := ParseBranchTemplate(tmpl)
+	if err != nil {
+		return "", err
+	}
+	return renderBranch(t, v)
+}
+
+func renderBranch(t *template.Template, v *BranchVars) (string, error) {
+	var b strings.Builder
+	if err := t.Execute(&b, v); err != nil {
+		return "", err
+	}
+	name := strings.TrimSpace(b.String())
+	if err := gitutil.CheckBranchName(name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// UniqueBranch returns name, or the first of name-2, name-3, ... for which
+// taken is false.
+func UniqueBranch(name string, taken func(string) bool) string {
+	cand := name
+	for n := 2; taken(cand); n++ {
+		cand = name + "-" + strconv.Itoa(n)
+	}
+	return cand
+}
+
+// AgentName returns the name of the agent command line agent, for
+// [BranchVars.Agent]: its program's base name.
+func AgentName(agent string) string {
+	f := strings.Fields(agent)
+	if len(f) == 0 {
+		return ""
+	}
+	return filepath.Base(f[0])
+}
+
+// Slugify returns s in lowercase ASCII letters and digits, runs of other
+// characters replaced by a dash, cut at about 40 characters.
+func Slugify(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+			if b.Len() >= 40 {
+				break
+			}
+		} else {
+			dash = true
+		}
+	}
+	return strings.TrimRight(b.String(), "-")
+}
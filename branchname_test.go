@@ -0,0 +1,51 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"testing"
+)
+
+func TestRenderBranch(t *testing.T) {
+	v := &BranchVars{Agent: AgentName("/usr/bin/claude -p"), IssueNumber: 42, Slug: Slugify("Crash on empty input!"), Repo: "md"}
+	got, err := RenderBranch("agent/{{.Agent}}/{{.IssueNumber}}-{{.Slug}}", v)
+	if err != nil || got != "agent/claude/42-crash-on-empty-input" {
+		t.Errorf("got %q, %v", got, err)
+	}
+	if got, err := RenderBranch("{{if .IssueNumber}}fix{{else}}wip{{end}}/{{.Repo}}", &BranchVars{Repo: "md"}); err != nil || got != "wip/md" {
+		t.Errorf("got %q, %v", got, err)
+	}
+	for _, tmpl := range []string{"{{.Nope}}", "{{.Agent", "{{.Agent}}..x", "{{.Slug}}"} {
+		if _, err := RenderBranch(tmpl, &BranchVars{Agent: "claude"}); err == nil {
+			t.Errorf("%q: expected error", tmpl)
+		}
+	}
+	if _, err := ParseBranchTemplate("{{.Nope}}"); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestUniqueBranch(t *testing.T) {
+	taken := map[string]bool{"fix": true, "fix-2": true}
+	if got := UniqueBranch("fix", func(b string) bool { return taken[b] }); got != "fix-3" {
+		t.Errorf("got %q", got)
+	}
+	if got := UniqueBranch("new", func(b string) bool { return taken[b] }); got != "new" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	for in, want := range map[string]string{
+		"Crash on empty input!": "crash-on-empty-input",
+		"feature/login_page":    "feature-login-page",
+		"  --  ":                "",
+		"A very long title that goes on and on about the parser": "a-very-long-title-that-goes-on-and-on-ab",
+	} {
+		if got := Slugify(in); got != want {
+			t.Errorf("Slugify(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// cdpVersion is the subset of the Chrome DevTools Protocol /json/version
+// response we care about.
+type cdpVersion struct {
+	Browser              string `json:"Browser"`
+	WebSocketDebuggerURL string `json:"webSocketDebuggerUrl"`
+}
+
+// cdpWebSocketURL queries the DevTools HTTP endpoint on host:port and
+// returns the browser-level ws:// endpoint.
+//
+// The host part of the returned URL is derived by the browser from the Host
+// header of the request, so it already points at the host-side mapped port.
+func cdpWebSocketURL(ctx context.Context, host string, port int32) (string, error) {
+	u := fmt.Sprintf("http://%s/json/version", net.JoinHostPort(host, strconv.Itoa(int(port))))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, http.NoBody)
+	if err != nil {
+		return "", err
+	}
+	client := &http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("reading response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("DevTools endpoint returned %d", resp.StatusCode)
+	}
+	var v cdpVersion
+	if err := json.Unmarshal(body, &v); err != nil {
+		return "", fmt.Errorf("parsing DevTools version: %w", err)
+	}
+	if v.WebSocketDebuggerURL == "" {
+		return "", errors.New("no webSocketDebuggerUrl in DevTools response")
+	}
+	return v.WebSocketDebuggerURL, nil
+}
+
+// waitForCDP polls the DevTools endpoint until the browser answers or the
+// deadline is exceeded.
+func waitForCDP(ctx context.Context, host string, port int32, deadline time.Time) (string, error) {
+	for {
+		ws, err := cdpWebSocketURL(ctx, host, port)
+		if err == nil {
+			return ws, nil
+		}
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("timed out waiting for browser DevTools: %w", err)
+		}
+		if err := sleepCtx(ctx, 100*time.Millisecond); err != nil {
+			return "", err
+		}
+	}
+}
+
+// BrowserWSURL returns the Chrome DevTools Protocol ws:// endpoint of the
+// container's browser, or "" if unavailable.
+func (c *Container) BrowserWSURL(ctx context.Context) string {
+	if !c.Browser || c.State != "running" {
+		return ""
+	}
+	port := c.CDPPort
+	if port == 0 {
+		var err error
+		if port, err = getHostPort(ctx, c.Runtime, c.Name, "9222/tcp"); err != nil || port == 0 {
+			return ""
+		}
+	}
+	ws, err := cdpWebSocketURL(ctx, dialHost(c.Bind), port)
+	if err != nil {
+		return ""
+	}
+	return ws
+}
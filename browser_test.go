@@ -0,0 +1,38 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestCDPWebSocketURL(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/json/version", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"Browser":"Chrome/130.0","webSocketDebuggerUrl":"ws://` + r.Host + `/devtools/browser/abc"}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	_, portStr, err := net.SplitHostPort(srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := cdpWebSocketURL(t.Context(), "127.0.0.1", int32(port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "ws://127.0.0.1:" + portStr + "/devtools/browser/abc"
+	if got != want {
+		t.Errorf("cdpWebSocketURL() = %q, want %q", got, want)
+	}
+}
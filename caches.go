@@ -0,0 +1,148 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// CacheMarkers maps [WellKnownCaches] names to the files, relative to a
+// repository's root, showing that it uses the tool. [DetectCaches] looks for
+// them.
+var CacheMarkers = map[string][]string{
+	"android-keys": {"app/src/main/AndroidManifest.xml", "AndroidManifest.xml"},
+	"bun":          {"bun.lock", "bun.lockb"},
+	"cargo":        {"Cargo.toml"},
+	"go-mod":       {"go.mod"},
+	"gradle":       {"build.gradle", "build.gradle.kts", "settings.gradle", "settings.gradle.kts"},
+	"maven":        {"pom.xml"},
+	"npm":          {"package.json"},
+	"pip":          {"requirements.txt", "pyproject.toml", "setup.py"},
+	"pnpm":         {"pnpm-lock.yaml", "pnpm-workspace.yaml"},
+	"uv":           {"uv.lock"},
+}
+
+// builtinCaches are the names of md's own well-known caches, which
+// configured cache sets can't replace.
+var builtinCaches = func() map[string]struct{} {
+	m := make(map[string]struct{}, len(WellKnownCaches))
+	for name := range WellKnownCaches {
+		m[name] = struct{}{}
+	}
+	return m
+}()
+
+var reCacheName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// RegisterCache adds the cache set name to [WellKnownCaches], so it is
+// accepted by --cache and --no-cache and included by default like the
+// built-in ones, with markers, if any, added to [CacheMarkers]. mounts'
+// HostPath may start with "~/". It must be called before the caches are
+// resolved; it isn't safe for concurrent use.
+func RegisterCache(name string, mounts []CacheMount, markers []string) error {
+	if !reCacheName.MatchString(name) {
+		return fmt.Errorf("invalid cache name %q: use lowercase letters, digits, '-' and '_'", name)
+	}
+	if _, ok := WellKnownCaches[name]; ok {
+		return fmt.Errorf("cache %q already exists", name)
+	}
+	if len(mounts) == 0 {
+		return fmt.Errorf("cache %q has no paths", name)
+	}
+	for _, m := range mounts {
+		if m.Name == "" || m.HostPath == "" || !strings.HasPrefix(m.ContainerPath, "/") {
+			return fmt.Errorf("cache %q: invalid mount %+v", name, m)
+		}
+	}
+	WellKnownCaches[name] = slices.Clone(mounts)
+	if len(markers) > 0 {
+		CacheMarkers[name] = slices.Clone(markers)
+	}
+	return nil
+}
+
+// DetectCaches returns the sorted names of the [WellKnownCaches] the
+// repository at gitRoot uses, according to [CacheMarkers]. Caches without
+// markers are never detected. The result is empty, not nil, when none is.
+func DetectCaches(gitRoot string) []string {
+	names := []string{}
+	for name, markers := range CacheMarkers {
+		if _, ok := WellKnownCaches[name]; !ok {
+			continue
+		}
+		for _, m := range markers {
+			if _, err := os.Stat(filepath.Join(gitRoot, filepath.FromSlash(m))); err == nil {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// ParseCacheSpec parses a custom cache, "host:container" or
+// "host:container:ro". It is named after the host directory.
+func ParseCacheSpec(spec string) (CacheMount, error) {
+	parts := strings.SplitN(spec, ":", 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return CacheMount{}, errors.New("want host:container[:ro]")
+	}
+	cm := CacheMount{
+		Name:          filepath.Base(parts[0]),
+		HostPath:      parts[0],
+		ContainerPath: parts[1],
+	}
+	if len(parts) == 3 {
+		if parts[2] != "ro" {
+			return CacheMount{}, errors.New("only the ':ro' modifier is supported")
+		}
+		cm.ReadOnly = true
+	}
+	return cm, nil
+}
+
+// CacheSetConfig is a named cache set of the configuration, registered with
+// [RegisterCache].
+type CacheSetConfig struct {
+	// Description is shown in md's help.
+	Description string `toml:"description"`
+	// Paths are the directories, "host:container[:ro]", the host one
+	// possibly starting with "~/".
+	Paths []string `toml:"paths"`
+	// Detect are the files, relative to the repository's root, whose
+	// presence enables the set with --detect-caches.
+	Detect []string `toml:"detect"`
+}
+
+// Mounts returns the cache mounts of the set name.
+func (s *CacheSetConfig) Mounts(name string) ([]CacheMount, error) {
+	mounts := make([]CacheMount, 0, len(s.Paths))
+	for i, p := range s.Paths {
+		cm, err := ParseCacheSpec(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path %q: %w", p, err)
+		}
+		if !strings.HasPrefix(cm.ContainerPath, "/") {
+			return nil, fmt.Errorf("invalid path %q: the container path must be absolute", p)
+		}
+		cm.Description = s.Description
+		if cm.Description == "" {
+			cm.Description = name
+		}
+		cm.Name = name
+		if len(s.Paths) > 1 {
+			cm.Name = fmt.Sprintf("%s-%d", name, i+1)
+		}
+		mounts = append(mounts, cm)
+	}
+	return mounts, nil
+}
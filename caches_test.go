@@ -0,0 +1,84 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestDetectCaches(t *testing.T) {
+	dir := t.TempDir()
+	if got := DetectCaches(dir); got == nil || len(got) != 0 {
+		t.Errorf("empty: %q", got)
+	}
+	for _, f := range []string{"go.mod", "package.json", "pnpm-lock.yaml", "app/src/main/AndroidManifest.xml"} {
+		p := filepath.Join(dir, filepath.FromSlash(f))
+		if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := DetectCaches(dir), []string{"android-keys", "go-mod", "npm", "pnpm"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	for name := range CacheMarkers {
+		if _, ok := WellKnownCaches[name]; !ok {
+			t.Errorf("CacheMarkers[%q] isn't a well-known cache", name)
+		}
+	}
+}
+
+func TestRegisterCache(t *testing.T) {
+	set := CacheSetConfig{Paths: []string{"~/.cache/bazel:/home/user/.cache/bazel", "~/.bazelisk:/home/user/.bazelisk:ro"}, Detect: []string{"MODULE.bazel"}}
+	mounts, err := set.Mounts("bazel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []CacheMount{
+		{Name: "bazel-1", Description: "bazel", HostPath: "~/.cache/bazel", ContainerPath: "/home/user/.cache/bazel"},
+		{Name: "bazel-2", Description: "bazel", HostPath: "~/.bazelisk", ContainerPath: "/home/user/.bazelisk", ReadOnly: true},
+	}
+	if !slices.Equal(mounts, want) {
+		t.Errorf("got %+v", mounts)
+	}
+	t.Cleanup(func() {
+		delete(WellKnownCaches, "bazel")
+		delete(CacheMarkers, "bazel")
+	})
+	if err := RegisterCache("bazel", mounts, set.Detect); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "MODULE.bazel"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if got := DetectCaches(dir); !slices.Equal(got, []string{"bazel"}) {
+		t.Errorf("got %q", got)
+	}
+	for _, name := range []string{"bazel", "go-mod", "Bad Name"} {
+		if err := RegisterCache(name, mounts, nil); err == nil {
+			t.Errorf("%q: expected error", name)
+		}
+	}
+	if err := RegisterCache("empty", nil, nil); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestParseCacheSpec(t *testing.T) {
+	if got, err := ParseCacheSpec("/host/ccache:/home/user/.ccache:ro"); err != nil || got != (CacheMount{Name: "ccache", HostPath: "/host/ccache", ContainerPath: "/home/user/.ccache", ReadOnly: true}) {
+		t.Errorf("got %+v, %v", got, err)
+	}
+	for _, spec := range []string{"ccache", ":/x", "/x:", "/x:/y:rw"} {
+		if _, err := ParseCacheSpec(spec); err == nil {
+			t.Errorf("%q: expected error", spec)
+		}
+	}
+}
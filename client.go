@@ -18,6 +18,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -27,6 +28,9 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/caic-xyz/md/gitutil"
+	"github.com/maruel/genai"
 )
 
 // Client holds global MD tool state (paths, image config, SSH keys).
@@ -42,7 +46,40 @@ type Client struct {
 	UserKeyPath string // ~/.ssh/md
 
 	// Container runtime.
-	Runtime string // "docker" or "podman"; auto-detected by New().
+	Runtime string // "docker" or "podman"; $MD_ENGINE, Config.Runtime or auto-detected by New().
+	// Kube, when set, runs containers as pods on a Kubernetes cluster;
+	// Runtime still builds the images.
+	Kube *Kube
+	// RemoteHost is the ssh destination of the machine running Runtime when
+	// $DOCKER_HOST or the docker context points at another machine; empty
+	// when it runs locally. Set by New(). The containers' published ports
+	// are on that machine, so ssh reaches them through it with ProxyJump.
+	RemoteHost string
+	// Proxy is the HTTP(S) proxy passed to image builds and containers and
+	// used by md's own requests. New() sets it from Config.Proxy and the
+	// environment.
+	Proxy ProxyConfig
+
+	// Config is the user configuration loaded by New() from
+	// ~/.config/md/config.toml. Use [LoadRepoConfig] to apply a repository's
+	// overrides.
+	Config *Config
+	// Hooks are the commands run at the lifecycle hook points. New() sets
+	// them from Config.Hooks; set them from the configuration merged with
+	// the repository's to run its container hooks too.
+	Hooks HooksConfig
+	// SignCommits signs the container's commits on the host during
+	// [Container.Pull]. New() sets it from Config.SignCommits.
+	SignCommits bool
+	// ConventionalCommits makes the commit messages md generates, in
+	// [Container.Pull] and [Container.GerritPush], follow the Conventional
+	// Commits specification. New() sets it from Config.ConventionalCommits.
+	ConventionalCommits bool
+
+	// Offline skips md's network accesses: the base image isn't pulled nor
+	// compared against its registry, and no Tailscale auth key is generated.
+	// When false, md detects it per server with a quick probe.
+	Offline bool
 
 	// ControlMaster enables SSH ControlMaster connection multiplexing.
 	// When true, SSH connections are shared via a persistent socket,
@@ -53,6 +90,7 @@ type Client struct {
 	// Tokens.
 	GithubToken string // GitHub API token for Docker build secrets.
 	// TailscaleAPIKey is the Tailscale API key for auth key generation and device deletion.
+	// New() sets it from $TAILSCALE_API_KEY or Config.Tailscale.APIKey.
 	//
 	// It is necessary to setup ephemeral nodes. The key must be rotated every 90 days.
 	//
@@ -77,7 +115,7 @@ type Client struct {
 	// image tag.
 	buildMu sync.Mutex
 
-	// mu protects digestCache and imageBuildCache.
+	// mu protects digestCache, imageBuildCache and reachCache.
 	mu sync.Mutex
 	// digestCache caches remote image digest queries to avoid repeated
 	// registry network round-trips. Entries expire after DigestCacheTTL.
@@ -86,6 +124,9 @@ type Client struct {
 	// back-to-back checks (e.g. Warmup then Launch) skip redundant
 	// docker inspect calls. Protected by mu; invalidated on successful build.
 	imageBuildCache *imageBuildCacheEntry
+	// reachCache caches whether servers answer, keyed by URL, see
+	// [Client.reachable].
+	reachCache map[string]reachEntry
 }
 
 // New creates a Client with global MD tool config and initialises SSH
@@ -96,6 +137,10 @@ func New(stdout io.Writer) (*Client, error) {
 		return nil, err
 	}
 	xdgConfigHome := envOr("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	cfg, err := LoadConfig(ConfigPath(xdgConfigHome))
+	if err != nil {
+		return nil, err
+	}
 	c := &Client{
 		Home:           home,
 		XDGConfigHome:  xdgConfigHome,
@@ -104,9 +149,22 @@ func New(stdout io.Writer) (*Client, error) {
 		HostKeyPath:    filepath.Join(xdgConfigHome, "md", "ssh_host_ed25519_key"),
 		UserKeyPath:    filepath.Join(home, ".ssh", "md"),
 		Runtime:        detectRuntime(),
+		Config:         cfg,
+		Hooks:          cfg.Hooks,
 		DigestCacheTTL: 12 * time.Hour,
 		digestCache:    make(map[string]remoteDigestEntry),
 	}
+	if cfg.Runtime != "" && os.Getenv("MD_ENGINE") == "" {
+		c.Runtime = cfg.Runtime
+	}
+	c.TailscaleAPIKey = envOr("TAILSCALE_API_KEY", cfg.Tailscale.APIKey)
+	c.RemoteHost = remoteEngineHost(context.Background(), c.Runtime, home)
+	c.Proxy = resolveProxy(cfg.Proxy, os.Getenv)
+	c.SignCommits = cfg.SignCommits != nil && *cfg.SignCommits
+	c.ConventionalCommits = cfg.ConventionalCommits != nil && *cfg.ConventionalCommits
+	if k := cfg.Kubernetes; k.Context != "" {
+		c.Kube = &Kube{Context: k.Context, Namespace: k.Namespace, Registry: k.Registry}
+	}
 	c.keysDir = filepath.Join(c.XDGConfigHome, "md")
 	if err := c.setupSSH(stdout); err != nil {
 		return nil, err
@@ -152,9 +210,17 @@ func (c *Client) setupSSH(stdout io.Writer) error {
 }
 
 // detectRuntime returns the container runtime to use.
-// Checks for docker, then podman in PATH.
+// $MD_ENGINE wins when set to a valid runtime. Otherwise checks for docker,
+// then podman in PATH. A docker command that is podman's compatibility shim
+// (podman-docker) is reported as podman so podman-specific flags apply.
 func detectRuntime() string {
-	if _, err := exec.LookPath("docker"); err == nil {
+	if e := os.Getenv("MD_ENGINE"); ValidateRuntime(e) == nil {
+		return e
+	}
+	if p, err := exec.LookPath("docker"); err == nil {
+		if isPodmanShim(p) {
+			return "podman"
+		}
 		return "docker"
 	}
 	if _, err := exec.LookPath("podman"); err == nil {
@@ -163,6 +229,29 @@ func detectRuntime() string {
 	return "docker"
 }
 
+// ValidateRuntime returns an error unless rt is "docker" or "podman".
+func ValidateRuntime(rt string) error {
+	_, err := LookupEngine(rt)
+	return err
+}
+
+// isPodmanShim reports whether the docker executable at p is podman: either
+// a symlink to podman or the podman-docker wrapper script.
+func isPodmanShim(p string) bool {
+	if r, err := filepath.EvalSymlinks(p); err == nil && strings.TrimSuffix(filepath.Base(r), ".exe") == "podman" {
+		return true
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return false
+	}
+	defer func() { _ = f.Close() }()
+	var buf [4096]byte
+	n, _ := io.ReadFull(f, buf[:])
+	head := buf[:n]
+	return bytes.HasPrefix(head, []byte("#!")) && bytes.Contains(head, []byte("podman"))
+}
+
 // Container returns a Container handle for the given repos.
 // The first repo is the primary; the rest are pushed alongside it at
 // /home/user/src/<basename> inside the container. When called with no repos,
@@ -209,7 +298,22 @@ func (c *Client) SCPCommand(extraArgs ...string) []string {
 
 // List returns running md containers sorted by name.
 func (c *Client) List(ctx context.Context) ([]*Container, error) {
-	out, err := runCmd(ctx, "", []string{c.Runtime, "ps", "--all", "--no-trunc", "--format", "{{json .}}"})
+	if c.Kube != nil {
+		containers, err := c.Kube.listPods(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, ct := range containers {
+			ct.Client = c
+			ct.LastUsed = ct.CreatedAt
+			if fi, err := os.Stat(lastUsedPath(filepath.Join(c.Home, ".ssh", "config.d"), ct.Name)); err == nil {
+				ct.LastUsed = fi.ModTime()
+			}
+		}
+		sort.Slice(containers, func(i, j int) bool { return containers[i].Name < containers[j].Name })
+		return containers, nil
+	}
+	out, err := runCmdRetry(ctx, "", []string{c.Runtime, "ps", "--all", "--no-trunc", "--format", "{{json .}}"})
 	if err != nil {
 		return nil, err
 	}
@@ -226,6 +330,10 @@ func (c *Client) List(ctx context.Context) ([]*Container, error) {
 		}
 		if strings.HasPrefix(ct.Name, "md-") {
 			ct.Client = c
+			ct.LastUsed = ct.CreatedAt
+			if fi, err := os.Stat(lastUsedPath(filepath.Join(c.Home, ".ssh", "config.d"), ct.Name)); err == nil {
+				ct.LastUsed = fi.ModTime()
+			}
 			containers = append(containers, &ct)
 		}
 	}
@@ -236,12 +344,96 @@ func (c *Client) List(ctx context.Context) ([]*Container, error) {
 	return containers, nil
 }
 
-// BuildImage builds the base Docker images locally: first md-root-local,
-// then md-user-local on top of it.
-func (c *Client) BuildImage(ctx context.Context, stdout, stderr io.Writer) (retErr error) {
+// BuildImageOpts configures BuildImage.
+type BuildImageOpts struct {
+	// Builder is the name of a buildx builder to delegate the build to, e.g.
+	// a remote BuildKit daemon registered with "docker buildx create --driver
+	// remote tcp://host:1234" or a Docker Build Cloud builder. Empty builds
+	// with the local daemon.
+	Builder string
+	// Push is an image repository (e.g. "ghcr.io/me/md") the images are
+	// pushed to, tagged "root" and "latest", so any machine can start from
+	// <Push>:latest. Required with Builder: a remote builder can't read
+	// images from the local daemon, so the user image is built FROM the
+	// pushed root image.
+	Push string
+	// Tag versions the images: md-root-local:<Tag> and md-user-local:<Tag>,
+	// pushed as <Push>:root-<Tag> and <Push>:<Tag>. Defaults to "latest",
+	// pushed as <Push>:root and <Push>:latest.
+	Tag string
+	// Platform is the target platform(s), e.g. "linux/amd64". Defaults to
+	// the host architecture. Several comma-separated platforms require
+	// Builder.
+	Platform string
+	// BuildArgs are KEY=VALUE build arguments passed to both Dockerfiles.
+	BuildArgs []string
+	// NoCache disables the build cache.
+	NoCache bool
+	// ContextDir replaces the build contexts embedded in md with an on-disk
+	// tree laid out like rsc/: ContextDir/root and ContextDir/user. Meant to
+	// iterate on the Dockerfiles and setup scripts without recompiling md.
+	ContextDir string
+}
+
+// Images returns the root and user images BuildImage produces: the local
+// ones and, with Push, the pushed ones; only the pushed ones with Builder.
+func (o *BuildImageOpts) Images() []string {
+	rootLocal, userLocal, rootRemote, userRemote := o.imageNames()
+	if o.Builder != "" {
+		return []string{rootRemote, userRemote}
+	}
+	if o.Push != "" {
+		return []string{rootLocal, userLocal, rootRemote, userRemote}
+	}
+	return []string{rootLocal, userLocal}
+}
+
+// imageNames returns the local and, with Push, remote names of the root and
+// user images.
+func (o *BuildImageOpts) imageNames() (rootLocal, userLocal, rootRemote, userRemote string) {
+	tag := o.Tag
+	if tag == "" {
+		tag = "latest"
+	}
+	rootLocal = "md-root-local:" + tag
+	userLocal = "md-user-local:" + tag
+	if o.Push != "" {
+		rootRemote = o.Push + ":root"
+		if tag != "latest" {
+			rootRemote += "-" + tag
+		}
+		userRemote = o.Push + ":" + tag
+	}
+	return rootLocal, userLocal, rootRemote, userRemote
+}
+
+// BuildImage builds the base Docker images: first md-root-local, then
+// md-user-local on top of it, both tagged opts.Tag. With opts.Builder, the build runs on that
+// buildx builder and the images are pushed to opts.Push instead of being
+// loaded locally.
+func (c *Client) BuildImage(ctx context.Context, stdout, stderr io.Writer, opts *BuildImageOpts) (retErr error) {
 	c.buildMu.Lock()
 	defer c.buildMu.Unlock()
-	arch := runtime.GOARCH
+	platform := opts.Platform
+	if platform == "" {
+		platform = "linux/" + runtime.GOARCH
+	}
+	if strings.Contains(platform, ",") && opts.Builder == "" {
+		return errors.New("building for several platforms requires a remote builder")
+	}
+	for _, a := range opts.BuildArgs {
+		if k, _, ok := strings.Cut(a, "="); !ok || k == "" {
+			return fmt.Errorf("invalid build arg %q: use KEY=VALUE", a)
+		}
+	}
+	if opts.Builder != "" {
+		if !c.Engine().remoteBuilders() {
+			return fmt.Errorf("remote builders require docker buildx, not %s", c.Runtime)
+		}
+		if opts.Push == "" {
+			return errors.New("a remote builder requires an image repository to push to")
+		}
+	}
 
 	if c.GithubToken == "" {
 		_, _ = fmt.Fprintln(stdout, "WARNING: GITHUB_TOKEN not found. Some tools (neovim, rust-analyzer, etc) might fail to install or hit rate limits.")
@@ -250,60 +442,147 @@ func (c *Client) BuildImage(ctx context.Context, stdout, stderr io.Writer) (retE
 		_, _ = fmt.Fprintln(stdout, "  export GITHUB_TOKEN=...")
 	}
 
-	// Step 1: build the root image.
-	_, _ = fmt.Fprintln(stdout, "- Building root Docker image from rsc/root/Dockerfile ...")
-	rootCtx, err := prepareRootBuildContext()
+	rootCtx, userCtx, contextSHA, err := buildImageContexts(opts.ContextDir)
 	if err != nil {
 		return err
 	}
-	defer func() { retErr = errors.Join(retErr, os.RemoveAll(rootCtx)) }()
-	rootCmd := []string{
-		c.Runtime, "build",
-		"--platform", "linux/" + arch,
-		"-f", filepath.Join(rootCtx, "Dockerfile"),
-		"-t", "md-root-local",
+	if opts.ContextDir != "" {
+		_, _ = fmt.Fprintf(stdout, "- WARNING: building from %s instead of the build context embedded in md; the resulting environment is non-standard.\n", opts.ContextDir)
+	} else {
+		defer func() { retErr = errors.Join(retErr, os.RemoveAll(rootCtx), os.RemoveAll(userCtx)) }()
+	}
+
+	// buildCmd returns the build command prefix, tagged with local and,
+	// when pushing, remote names.
+	buildCmd := func(local, remote string) []string {
+		var args []string
+		if opts.Builder != "" {
+			// Plain progress streams the remote builder's logs line by line.
+			args = []string{c.Runtime, "buildx", "build", "--builder", opts.Builder, "--progress", "plain", "--label", buildLabel, "-t", remote, "--push"}
+		} else {
+			args = []string{c.Runtime, "build", "--label", buildLabel, "-t", local}
+			if remote != "" {
+				args = append(args, "-t", remote)
+			}
+		}
+		args = append(args, "--platform", platform, "--label", "md.context_sha="+contextSHA)
+		if opts.NoCache {
+			args = append(args, "--no-cache")
+		}
+		args = append(args, c.Proxy.buildArgs()...)
+		for _, a := range opts.BuildArgs {
+			args = append(args, "--build-arg", a)
+		}
+		return args
+	}
+	rootLocal, userLocal, rootRemote, userRemote := opts.imageNames()
+
+	// Step 1: build the root image.
+	if opts.Builder != "" {
+		_, _ = fmt.Fprintf(stdout, "- Building root Docker image from rsc/root/Dockerfile on builder %s ...\n", opts.Builder)
+	} else {
+		_, _ = fmt.Fprintln(stdout, "- Building root Docker image from rsc/root/Dockerfile ...")
 	}
+	rootCmd := append(buildCmd(rootLocal, rootRemote), "-f", filepath.Join(rootCtx, "Dockerfile"))
 	if c.GithubToken != "" {
 		rootCmd = append(rootCmd, "--secret", "id=github_token,env=GITHUB_TOKEN")
 	}
 	rootCmd = append(rootCmd, rootCtx)
-	if err := runCmdOut(ctx, "", rootCmd, stdout, stderr); err != nil {
+	if err := runBuildCmd(ctx, rootCmd, stdout, stderr); err != nil {
 		return err
 	}
-	_, _ = fmt.Fprintln(stdout, "- Root image built as 'md-root-local'.")
+	rootImage := rootLocal
+	if opts.Builder != "" {
+		rootImage = rootRemote
+		_, _ = fmt.Fprintf(stdout, "- Root image pushed as '%s'.\n", rootRemote)
+	} else {
+		_, _ = fmt.Fprintf(stdout, "- Root image built as '%s'.\n", rootLocal)
+	}
 
 	// Step 2: build the user image on top of the root image.
 	_, _ = fmt.Fprintln(stdout, "- Building user Docker image from rsc/user/Dockerfile ...")
-	userCtx, err := prepareBuildContext()
-	if err != nil {
-		return err
-	}
-	defer func() { retErr = errors.Join(retErr, os.RemoveAll(userCtx)) }()
-	userCmd := []string{
-		c.Runtime, "build",
-		"--platform", "linux/" + arch,
+	userCmd := append(buildCmd(userLocal, userRemote),
 		"-f", filepath.Join(userCtx, "Dockerfile"),
-		"--build-arg", "BASE_ROOT_IMAGE=md-root-local",
-		"-t", "md-user-local",
-	}
+		"--build-arg", "BASE_ROOT_IMAGE="+rootImage,
+	)
 	if c.GithubToken != "" {
 		userCmd = append(userCmd, "--secret", "id=github_token,env=GITHUB_TOKEN")
 	}
 	userCmd = append(userCmd, userCtx)
-	if err := runCmdOut(ctx, "", userCmd, stdout, stderr); err != nil {
+	if err := runBuildCmd(ctx, userCmd, stdout, stderr); err != nil {
 		return err
 	}
-	_, _ = fmt.Fprintln(stdout, "- User image built as 'md-user-local'.")
-	c.invalidateImageBuildCache()
-	// Clean up BuildKit cache (--mount=type=cache volumes from Dockerfiles).
-	// These are only useful during the build itself; pruning avoids leaving
-	// orphaned resources on disk.
-	if _, err := runCmd(ctx, "", []string{c.Runtime, "builder", "prune", "-f"}); err != nil {
-		_, _ = fmt.Fprintf(stdout, "- Warning: pruning build cache: %v\n", err)
+	if opts.Builder != "" {
+		_, _ = fmt.Fprintf(stdout, "- User image pushed as '%s'. Start from it with: md start --image %s\n", userRemote, userRemote)
+		// The builder's cache lives remotely; there is nothing to prune here.
+		return nil
 	}
+	_, _ = fmt.Fprintf(stdout, "- User image built as '%s'.\n", userLocal)
+	if opts.Push != "" {
+		for _, img := range []string{rootRemote, userRemote} {
+			if err := runCmdOut(ctx, "", []string{c.Runtime, "push", img}, stdout, stderr); err != nil {
+				return fmt.Errorf("pushing %s: %w", img, err)
+			}
+		}
+		_, _ = fmt.Fprintf(stdout, "- Pushed '%s'. Start from it with: md start --image %s\n", userRemote, userRemote)
+	}
+	// The BuildKit cache (layers and --mount=type=cache volumes) is kept so
+	// the next build only redoes changed steps; md prune reclaims it.
+	c.invalidateImageBuildCache()
 	return nil
 }
 
+// buildImageContexts returns the root and user build contexts for
+// BuildImage and the hash of the tree they come from. Without contextDir, the
+// embedded trees are extracted to temporary directories the caller must
+// remove.
+func buildImageContexts(contextDir string) (rootCtx, userCtx, sha string, err error) {
+	if contextDir != "" {
+		for _, sub := range []string{"root", "user"} {
+			if _, err := os.Stat(filepath.Join(contextDir, sub, "Dockerfile")); err != nil {
+				return "", "", "", fmt.Errorf("context dir %s must contain root/Dockerfile and user/Dockerfile: %w", contextDir, err)
+			}
+		}
+		if sha, err = contextTreeSHA(os.DirFS(contextDir), "."); err != nil {
+			return "", "", "", err
+		}
+		return filepath.Join(contextDir, "root"), filepath.Join(contextDir, "user"), sha, nil
+	}
+	if sha, err = contextTreeSHA(rscFS, "rsc"); err != nil {
+		return "", "", "", err
+	}
+	if rootCtx, err = prepareRootBuildContext(); err != nil {
+		return "", "", "", err
+	}
+	if userCtx, err = prepareBuildContext(); err != nil {
+		return "", "", "", errors.Join(err, os.RemoveAll(rootCtx))
+	}
+	return rootCtx, userCtx, sha, nil
+}
+
+// buildLabel marks every image built by md, so images left dangling by an
+// interrupted or superseded build can be found by PruneImages.
+const buildLabel = "md.build=1"
+
+// runBuildCmd runs an image build like runCmdOut. On cancellation it
+// interrupts the build client rather than killing it, so BuildKit stops
+// cleanly and keeps the steps completed so far in its cache: running the same
+// build again resumes from there.
+func runBuildCmd(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	slog.DebugContext(ctx, "md", "msg", "exec", "cmd", args)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = append(os.Environ(), "LANG=C")
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Cancel = func() error { return cmd.Process.Signal(os.Interrupt) }
+	cmd.WaitDelay = 10 * time.Second
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return fmt.Errorf("build interrupted; completed steps are cached, run it again to resume: %w", ctx.Err())
+	}
+	return err
+}
+
 // WarmupOpts configures base image warmup.
 type WarmupOpts struct {
 	// BaseImage is the full Docker image reference. When empty,
@@ -311,6 +590,11 @@ type WarmupOpts struct {
 	BaseImage string
 	// Caches lists host directories to COPY into the image at build time.
 	Caches []CacheMount
+	// Sudo is the sudo policy of the image. See [StartOpts.Sudo].
+	Sudo SudoPolicy
+	// NoPull and Rebuild are as in [StartOpts].
+	NoPull  bool
+	Rebuild bool
 	// Quiet suppresses informational output.
 	Quiet bool
 }
@@ -320,30 +604,61 @@ type WarmupOpts struct {
 func (c *Client) Warmup(ctx context.Context, stdout, stderr io.Writer, opts *WarmupOpts) (bool, error) {
 	c.buildMu.Lock()
 	defer c.buildMu.Unlock()
-	baseImage := opts.BaseImage
-	if baseImage == "" {
-		baseImage = DefaultBaseImage + ":latest"
+	o := *opts
+	if o.BaseImage == "" {
+		o.BaseImage = DefaultBaseImage + ":latest"
 	}
-	imageName := userImageName(baseImage, activeCacheKey(opts.Caches, c.Home))
-	if !c.imageBuildNeeded(ctx, c.Runtime, imageName, baseImage, c.keysDir, c.Home, opts.Caches) {
+	imageName, reason := c.whyRebuild(ctx, &o)
+	if reason == nil {
 		if !opts.Quiet {
 			_, _ = fmt.Fprintf(stdout, "- Docker image %s is up to date, skipping build.\n", imageName)
 		}
 		return false, nil
 	}
-	if err := buildSpecializedImage(ctx, stdout, stderr, c.Runtime, c.keysDir, imageName, baseImage, c.Home, opts.Caches, agentContainerPaths(), opts.Quiet); err != nil {
+	if !opts.Quiet {
+		_, _ = fmt.Fprintf(stdout, "- Rebuilding %s because %s\n", imageName, reason)
+	}
+	if err := buildSpecializedImage(ctx, stdout, stderr, c.Runtime, c.keysDir, imageName, o.BaseImage, c.Home, o.Caches, o.Sudo, agentContainerPaths(), &c.Proxy, c.registryOffline(ctx, o.BaseImage), o.NoPull, o.Quiet); err != nil {
 		return false, err
 	}
 	c.invalidateImageBuildCache()
 	return true, nil
 }
 
-// PruneImages removes md-specialized-* and md-fork-* images that are not used by any container.
-// Returns the list of removed image names.
+// WhyRebuild returns the name of the user image for opts and why Warmup or
+// starting a container would rebuild it, nil when it is up to date. Nothing
+// is built.
+func (c *Client) WhyRebuild(ctx context.Context, opts *WarmupOpts) (string, *RebuildReason) {
+	o := *opts
+	if o.BaseImage == "" {
+		o.BaseImage = DefaultBaseImage + ":latest"
+	}
+	return c.whyRebuild(ctx, &o)
+}
+
+// whyRebuild implements WhyRebuild; opts.BaseImage must be set.
+func (c *Client) whyRebuild(ctx context.Context, opts *WarmupOpts) (string, *RebuildReason) {
+	imageName := userImageName(opts.BaseImage, sudoImageKey(activeCacheKey(opts.Caches, c.Home), opts.Sudo))
+	if opts.Rebuild {
+		return imageName, &RebuildReason{RebuildRequested, "a rebuild was requested"}
+	}
+	return imageName, c.imageBuildNeeded(ctx, c.Runtime, imageName, opts.BaseImage, c.keysDir, c.Home, opts.Caches, opts.Sudo, opts.NoPull)
+}
+
+// PruneImages removes md-specialized-*, md-baked-* and md-fork-* images that are not used by any container,
+// dangling images left by md builds, and the BuildKit build cache.
+// Returns the list of removed image names. See [Client.Prune] to also remove
+// the other leftovers of removed containers.
 func (c *Client) PruneImages(ctx context.Context, stdout, stderr io.Writer) ([]string, error) {
+	return c.pruneImages(ctx, stdout, false)
+}
+
+// pruneImages implements PruneImages. With dryRun, it returns the images it
+// would remove and leaves the build cache alone.
+func (c *Client) pruneImages(ctx context.Context, stdout io.Writer, dryRun bool) ([]string, error) {
 	// List all md-specialized-* and md-fork-* images.
 	allImages := make(map[string]struct{})
-	for _, prefix := range []string{"md-specialized-*", "md-fork-*"} {
+	for _, prefix := range []string{"md-specialized-*", "md-baked-*", "md-fork-*"} {
 		out, err := runCmd(ctx, "", []string{
 			c.Runtime, "images", "--format", "{{.Repository}}", "--filter", "reference=" + prefix,
 		})
@@ -356,9 +671,6 @@ func (c *Client) PruneImages(ctx context.Context, stdout, stderr io.Writer) ([]s
 			}
 		}
 	}
-	if len(allImages) == 0 {
-		return nil, nil
-	}
 
 	// Find images used by running md containers.
 	containerOut, err := runCmd(ctx, "", []string{
@@ -382,6 +694,10 @@ func (c *Client) PruneImages(ctx context.Context, stdout, stderr io.Writer) ([]s
 		if _, used := inUse[img]; used {
 			continue
 		}
+		if dryRun {
+			removed = append(removed, img)
+			continue
+		}
 		if _, err := runCmd(ctx, "", []string{c.Runtime, "rmi", img}); err != nil {
 			_, _ = fmt.Fprintf(stdout, "- Warning: failed to remove %s: %v\n", img, err)
 			continue
@@ -390,19 +706,52 @@ func (c *Client) PruneImages(ctx context.Context, stdout, stderr io.Writer) ([]s
 	}
 	sort.Strings(removed)
 
-	// Clean up BuildKit build cache.
+	// Remove dangling images left by interrupted or superseded md builds
+	// (e.g. the previous md-root-local after a rebuild).
+	dangling, err := runCmd(ctx, "", []string{
+		c.Runtime, "images", "-q", "--no-trunc", "--filter", "dangling=true", "--filter", "label=" + buildLabel,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing dangling images: %w", err)
+	}
+	for id := range strings.SplitSeq(dangling, "\n") {
+		if id == "" {
+			continue
+		}
+		if dryRun {
+			removed = append(removed, "dangling image "+shortImageID(id))
+			continue
+		}
+		if _, err := runCmd(ctx, "", []string{c.Runtime, "rmi", id}); err != nil {
+			_, _ = fmt.Fprintf(stdout, "- Warning: failed to remove dangling image %s: %v\n", shortImageID(id), err)
+			continue
+		}
+		removed = append(removed, "dangling image "+shortImageID(id))
+	}
+
+	if dryRun {
+		return removed, nil
+	}
+	// Clean up BuildKit build cache, including partial builds kept for
+	// resumption.
 	if _, err := runCmd(ctx, "", []string{c.Runtime, "builder", "prune", "-f"}); err != nil {
 		_, _ = fmt.Fprintf(stdout, "- Warning: pruning build cache: %v\n", err)
 	}
 	return removed, nil
 }
 
+// shortImageID returns the 12 hex digit form of an image ID.
+func shortImageID(id string) string {
+	id = strings.TrimPrefix(id, "sha256:")
+	return id[:min(12, len(id))]
+}
+
 // gatherGitMetadata runs SSH commands to collect branch, stat, and log from
 // the container. This data is always small.
 func (c *Client) gatherGitMetadata(ctx context.Context, containerName, repo string) string {
 	r := shellQuote(repo)
 	cmd := "cd ~/src/" + r + " && echo '=== Branch ===' && git rev-parse --abbrev-ref HEAD && echo && echo '=== Files Changed ===' && git diff --stat --cached base -- . && echo && echo '=== Recent Commits ===' && git log -5 base -- ."
-	out, _ := runCmd(ctx, "", c.SSHCommand(containerName, cmd))
+	out, _ := runCmdRetry(ctx, "", c.SSHCommand(containerName, cmd))
 	return out
 }
 
@@ -410,10 +759,19 @@ func (c *Client) gatherGitMetadata(ctx context.Context, containerName, repo stri
 func (c *Client) gatherGitDiff(ctx context.Context, containerName, repo string) string {
 	r := shellQuote(repo)
 	cmd := "cd ~/src/" + r + " && git diff --patience -U10 --cached base -- ."
-	out, _ := runCmd(ctx, "", c.SSHCommand(containerName, cmd))
+	out, _ := runCmdRetry(ctx, "", c.SSHCommand(containerName, cmd))
 	return out
 }
 
+// generateCommitMsg generates a commit message with p, following
+// Conventional Commits when c.ConventionalCommits is set.
+func (c *Client) generateCommitMsg(ctx context.Context, p genai.Provider, metadata, diff string) (string, error) {
+	if c.ConventionalCommits {
+		return gitutil.GenerateConventionalCommitMsg(ctx, p, metadata, diff, nil)
+	}
+	return gitutil.GenerateCommitMsg(ctx, p, metadata, diff, nil)
+}
+
 // Harness identifies an agent harness whose config directories are mounted
 // into a container.
 type Harness string
@@ -148,6 +148,7 @@ func TestClient(t *testing.T) {
 }
 
 func TestDetectRuntime(t *testing.T) {
+	t.Setenv("MD_ENGINE", "")
 	t.Run("fallback_to_docker", func(t *testing.T) {
 		// Use empty PATH to test fallback when neither docker nor podman is found.
 		t.Setenv("PATH", t.TempDir())
@@ -170,6 +171,34 @@ func TestDetectRuntime(t *testing.T) {
 			t.Errorf("detectRuntime() = %q, want %q", got, "podman")
 		}
 	})
+	t.Run("podman_docker_shim", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("shell script shim")
+		}
+		dir := t.TempDir()
+		shim := "#!/bin/sh\nexec /usr/bin/podman \"$@\"\n"
+		if err := os.WriteFile(filepath.Join(dir, "docker"), []byte(shim), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("PATH", dir)
+		if got := detectRuntime(); got != "podman" {
+			t.Errorf("detectRuntime() = %q, want %q", got, "podman")
+		}
+	})
+	t.Run("md_engine", func(t *testing.T) {
+		t.Setenv("PATH", t.TempDir())
+		t.Setenv("MD_ENGINE", "podman")
+		if got := detectRuntime(); got != "podman" {
+			t.Errorf("detectRuntime() = %q, want %q", got, "podman")
+		}
+	})
+	t.Run("md_engine_invalid", func(t *testing.T) {
+		t.Setenv("PATH", t.TempDir())
+		t.Setenv("MD_ENGINE", "lxc")
+		if got := detectRuntime(); got != "docker" {
+			t.Errorf("detectRuntime() = %q, want %q", got, "docker")
+		}
+	})
 }
 
 func TestIsRootlessPodman(t *testing.T) {
@@ -0,0 +1,320 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caic-xyz/md"
+)
+
+// readOnlyOps are the operations of md mcp and md serve that don't change
+// the containers or the host's repositories, always approved.
+var readOnlyOps = []string{"list_containers", "status", "diff", "logs"}
+
+// approvalOps are the operations needing the user's approval unless listed
+// in approval.auto_approve.
+var approvalOps = []string{"start", "run_command_in_container", "push", "pull", "kill", "stop", "resume"}
+
+// approvalTimeout is how long the user has to answer before the operation
+// is denied.
+const approvalTimeout = 2 * time.Minute
+
+// approver asks the user to confirm the operations agents run through md
+// mcp and md serve, and records each decision in the audit log. A nil
+// approver approves everything without a record.
+type approver struct {
+	// source is the command the operations come through, "mcp" or "serve".
+	source string
+	// auto are the operations approved without asking.
+	auto map[string]bool
+	// auditPath is the JSON lines file the decisions are appended to.
+	auditPath string
+	// ask asks the user question, returning whether they approved.
+	ask func(ctx context.Context, question string) (bool, error)
+
+	// askMu serializes the prompts; mu the audit log writes.
+	askMu sync.Mutex
+	mu    sync.Mutex
+}
+
+// newApprover returns the approver of cfg for the operations of source.
+func newApprover(c *md.Client, source string, cfg md.ApprovalConfig) (*approver, error) {
+	a := &approver{
+		source:    source,
+		auto:      map[string]bool{},
+		auditPath: auditLogPath(c),
+	}
+	for _, op := range readOnlyOps {
+		a.auto[op] = true
+	}
+	for _, op := range cfg.AutoApprove {
+		switch {
+		case op == "all":
+			for _, op := range approvalOps {
+				a.auto[op] = true
+			}
+		case slices.Contains(approvalOps, op):
+			a.auto[op] = true
+		default:
+			return nil, fmt.Errorf("approval.auto_approve: unknown operation %q: use %s or all", op, strings.Join(approvalOps, ", "))
+		}
+	}
+	switch cfg.Prompt {
+	case "", md.ApprovalPromptAuto:
+		a.ask = askAuto
+	case md.ApprovalPromptTerminal:
+		a.ask = askTerminal
+	case md.ApprovalPromptDialog:
+		a.ask = askDialog
+	case md.ApprovalPromptDeny:
+	default:
+		return nil, fmt.Errorf("approval.prompt: invalid prompt %q", cfg.Prompt)
+	}
+	return a, nil
+}
+
+// auditLogPath returns the audit log of md mcp and md serve.
+func auditLogPath(c *md.Client) string {
+	return filepath.Join(c.XDGStateHome, "md", "audit.jsonl")
+}
+
+// auditEntry is a line of the audit log.
+type auditEntry struct {
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"`
+	// Operation is one of readOnlyOps or approvalOps.
+	Operation string `json:"operation"`
+	Container string `json:"container,omitempty"`
+	// Details are the operation's arguments, e.g. the command to run.
+	Details string `json:"details,omitempty"`
+	// Decision is "approved" or "denied".
+	Decision string `json:"decision"`
+	// By is what decided: "rule" for auto-approved operations, "user",
+	// "timeout", "policy" when approval.prompt is deny, or "error" when the
+	// user couldn't be asked.
+	By    string `json:"by"`
+	Error string `json:"error,omitempty"`
+}
+
+// check returns nil when the user approves op on container, with details
+// shown to them, and a 403 error otherwise.
+func (a *approver) check(ctx context.Context, op, container, details string) error {
+	if a == nil {
+		return nil
+	}
+	e := &auditEntry{Source: a.source, Operation: op, Container: container, Details: details}
+	switch {
+	case a.auto[op]:
+		e.Decision, e.By = "approved", "rule"
+	case a.ask == nil:
+		e.Decision, e.By = "denied", "policy"
+	default:
+		a.askMu.Lock()
+		actx, cancel := context.WithTimeout(ctx, approvalTimeout)
+		ok, err := a.ask(actx, a.question(op, container, details))
+		timedOut := errors.Is(actx.Err(), context.DeadlineExceeded)
+		cancel()
+		a.askMu.Unlock()
+		switch {
+		case err != nil && timedOut:
+			e.Decision, e.By = "denied", "timeout"
+		case err != nil:
+			e.Decision, e.By, e.Error = "denied", "error", err.Error()
+		case ok:
+			e.Decision, e.By = "approved", "user"
+		default:
+			e.Decision, e.By = "denied", "user"
+		}
+	}
+	e.Time = time.Now().UTC().Truncate(time.Second)
+	if err := a.audit(e); err != nil {
+		return fmt.Errorf("writing the audit log: %w", err)
+	}
+	if e.Decision == "denied" {
+		msg := fmt.Sprintf("%s was denied (%s)", op, e.By)
+		if e.Error != "" {
+			msg += ": " + e.Error
+		}
+		return &apiError{http.StatusForbidden, errors.New(msg)}
+	}
+	return nil
+}
+
+// question returns the text the user is asked. details come from the agent
+// and are quoted so control characters can't rewrite the prompt.
+func (a *approver) question(op, container, details string) string {
+	q := fmt.Sprintf("An agent using md %s wants to run %s", a.source, op)
+	if container != "" {
+		q += " on " + container
+	}
+	if details != "" {
+		q += ": " + strconv.Quote(details)
+	}
+	return q + ". Allow?"
+}
+
+// audit appends e to the audit log.
+func (a *approver) audit(e *auditEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(a.auditPath), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(a.auditPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(b, '\n'))
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// recentAuditEntries returns the last n entries of the audit log at path
+// about container, oldest first. A missing log has none.
+func recentAuditEntries(path, container string, n int) ([]auditEntry, error) {
+	f, err := os.Open(path) //nolint:gosec // path is md's own audit log.
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	var entries []auditEntry
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		var e auditEntry
+		if json.Unmarshal(s.Bytes(), &e) != nil || e.Container != container {
+			continue
+		}
+		entries = append(entries, e)
+		if len(entries) > n {
+			entries = entries[1:]
+		}
+	}
+	return entries, s.Err()
+}
+
+// askAuto asks on the terminal when md has one, else in a desktop dialog.
+func askAuto(ctx context.Context, question string) (bool, error) {
+	if tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0); err == nil {
+		_ = tty.Close()
+		return askTerminal(ctx, question)
+	}
+	return askDialog(ctx, question)
+}
+
+// askTerminal asks on md's controlling terminal: md mcp's stdin and stdout
+// carry the protocol.
+func askTerminal(ctx context.Context, question string) (bool, error) {
+	if runtime.GOOS == "windows" {
+		return false, errors.New("terminal prompts aren't supported on Windows; set approval.prompt to dialog")
+	}
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return false, fmt.Errorf("no terminal to ask on: %w", err)
+	}
+	defer func() { _ = tty.Close() }()
+	if _, err := fmt.Fprintf(tty, "\a%s [y/N] ", question); err != nil {
+		return false, err
+	}
+	answer := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(tty).ReadString('\n')
+		answer <- strings.ToLower(strings.TrimSpace(line))
+	}()
+	select {
+	case a := <-answer:
+		return a == "y" || a == "yes", nil
+	case <-ctx.Done():
+		_, _ = fmt.Fprintln(tty, "\nNo answer, denied.")
+		return false, ctx.Err()
+	}
+}
+
+// askDialog asks in a desktop dialog: osascript on macOS, PowerShell on
+// Windows, zenity or kdialog elsewhere.
+func askDialog(ctx context.Context, question string) (bool, error) {
+	dialog := ""
+	if runtime.GOOS != "darwin" && runtime.GOOS != "windows" {
+		if _, err := exec.LookPath("zenity"); err == nil {
+			dialog = "zenity"
+		} else if _, err := exec.LookPath("kdialog"); err == nil {
+			dialog = "kdialog"
+		} else {
+			return false, errors.New("no terminal nor dialog program (zenity or kdialog) to ask with")
+		}
+	}
+	args, env := dialogCommand(runtime.GOOS, dialog, question)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.WaitDelay = time.Second
+	out, err := cmd.Output()
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	}
+	switch runtime.GOOS {
+	case "darwin":
+		// Deny, the cancel button, exits 1.
+		return err == nil && strings.Contains(string(out), "button returned:Allow"), nil
+	case "windows":
+		if err != nil {
+			return false, err
+		}
+		return strings.TrimSpace(string(out)) == "Yes", nil
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// dialogCommand returns the command asking question on goos with dialog,
+// zenity or kdialog outside macOS and Windows, and the environment variables
+// to add to it. PowerShell reads the question from $env:MD_QUESTION: it
+// accepts several Unicode quotes as string delimiters, so the question never
+// goes in the script. zenity renders Pango markup unless told not to.
+func dialogCommand(goos, dialog, question string) (args, env []string) {
+	switch goos {
+	case "darwin":
+		return []string{"osascript", "-e", "display dialog " + appleScriptQuote(question) +
+			` with title "md" buttons {"Deny", "Allow"} default button "Deny"`}, nil
+	case "windows":
+		return []string{"powershell", "-NoProfile", "-Command", "Add-Type -AssemblyName PresentationFramework; " +
+			"[System.Windows.MessageBox]::Show($env:MD_QUESTION, 'md', 'YesNo', 'Question', 'No')"}, []string{"MD_QUESTION=" + question}
+	}
+	if dialog == "zenity" {
+		return []string{"zenity", "--question", "--no-markup", "--title=md", "--text=" + question, "--ok-label=Allow", "--cancel-label=Deny", "--default-cancel"}, nil
+	}
+	return []string{"kdialog", "--title", "md", "--yesno", question}, nil
+}
+
+// appleScriptQuote returns s as an AppleScript string literal.
+func appleScriptQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,161 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/caic-xyz/md"
+)
+
+func TestApprovalOps(t *testing.T) {
+	// Every MCP tool is an operation, read-only or needing approval.
+	for _, tool := range mcpTools {
+		if got := slices.Contains(readOnlyOps, tool.name); got != tool.readOnly {
+			t.Errorf("%s: read-only %t, listed %t", tool.name, tool.readOnly, got)
+		}
+		if !tool.readOnly && !slices.Contains(approvalOps, tool.name) {
+			t.Errorf("%s isn't in approvalOps", tool.name)
+		}
+	}
+}
+
+func TestNewApprover(t *testing.T) {
+	c := &md.Client{XDGStateHome: t.TempDir()}
+	a, err := newApprover(c, "mcp", md.ApprovalConfig{AutoApprove: []string{"push"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !a.auto["push"] || !a.auto["diff"] || a.auto["pull"] || a.ask == nil {
+		t.Errorf("got %+v", a)
+	}
+	if a, err = newApprover(c, "mcp", md.ApprovalConfig{AutoApprove: []string{"all"}, Prompt: md.ApprovalPromptDeny}); err != nil {
+		t.Fatal(err)
+	}
+	if !a.auto["kill"] || a.ask != nil {
+		t.Errorf("got %+v", a)
+	}
+	if _, err := newApprover(c, "mcp", md.ApprovalConfig{AutoApprove: []string{"rm"}}); err == nil || !strings.Contains(err.Error(), "unknown operation") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestApproverCheck(t *testing.T) {
+	c := &md.Client{XDGStateHome: t.TempDir()}
+	a, err := newApprover(c, "mcp", md.ApprovalConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var answer bool
+	var askErr error
+	var asked []string
+	a.ask = func(_ context.Context, q string) (bool, error) {
+		asked = append(asked, q)
+		return answer, askErr
+	}
+	ctx := t.Context()
+	if err := a.check(ctx, "diff", "md-a-main", ""); err != nil {
+		t.Errorf("diff: %v", err)
+	}
+	answer = true
+	if err := a.check(ctx, "run_command_in_container", "md-a-main", "make test"); err != nil {
+		t.Errorf("approved: %v", err)
+	}
+	answer = false
+	err = a.check(ctx, "kill", "md-a-main", "")
+	var ae *apiError
+	if !errors.As(err, &ae) || ae.code != http.StatusForbidden {
+		t.Errorf("denied: %v", err)
+	}
+	askErr = errors.New("no display")
+	if err := a.check(ctx, "pull", "md-a-main", ""); err == nil || !strings.Contains(err.Error(), "no display") {
+		t.Errorf("error: %v", err)
+	}
+	if want := []string{`An agent using md mcp wants to run run_command_in_container on md-a-main: "make test". Allow?`}; !slices.Equal(asked[:1], want) || len(asked) != 3 {
+		t.Errorf("asked %q", asked)
+	}
+
+	b, err := os.ReadFile(auditLogPath(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for line := range strings.Lines(string(b)) {
+		var e auditEntry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Source != "mcp" || e.Container != "md-a-main" || e.Time.IsZero() {
+			t.Errorf("entry %+v", e)
+		}
+		got = append(got, e.Operation+" "+e.Decision+" "+e.By)
+	}
+	want := []string{"diff approved rule", "run_command_in_container approved user", "kill denied user", "pull denied error"}
+	if !slices.Equal(got, want) {
+		t.Errorf("audit log:\ngot  %q\nwant %q", got, want)
+	}
+	if err := a.check(ctx, "diff", "md-b-main", ""); err != nil {
+		t.Fatal(err)
+	}
+	recent, err := recentAuditEntries(auditLogPath(c), "md-a-main", 2)
+	if err != nil || len(recent) != 2 || recent[0].Operation != "kill" || recent[1].Operation != "pull" {
+		t.Errorf("recent: got %+v, %v", recent, err)
+	}
+	if recent, err := recentAuditEntries(auditLogPath(c)+".missing", "md-a-main", 2); err != nil || recent != nil {
+		t.Errorf("missing log: got %+v, %v", recent, err)
+	}
+
+	// A nil approver approves everything.
+	if err := (*approver)(nil).check(ctx, "kill", "md-a-main", ""); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestApproverQuestion(t *testing.T) {
+	a := &approver{source: "serve"}
+	// Cursor movements, line erasure and carriage returns would let the agent
+	// replace the question shown on the terminal.
+	got := a.question("run_command_in_container", "md-a-main", "rm -rf ~\r\x1b[2K\x1b[1Aecho hi")
+	if strings.ContainsAny(got, "\r\x1b") {
+		t.Errorf("control characters in %q", got)
+	}
+	if want := `An agent using md serve wants to run run_command_in_container on md-a-main: "rm -rf ~\r\x1b[2K\x1b[1Aecho hi". Allow?`; got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+}
+
+func TestDialogCommand(t *testing.T) {
+	q := "Allow? \u2019); Remove-Item -Recurse C:\\ #<b>ok</b>"
+	args, env := dialogCommand("windows", "", q)
+	if slices.ContainsFunc(args, func(s string) bool { return strings.Contains(s, "Remove-Item") }) {
+		t.Errorf("question in the PowerShell script: %q", args)
+	}
+	if !slices.Equal(env, []string{"MD_QUESTION=" + q}) {
+		t.Errorf("env %q", env)
+	}
+	args, env = dialogCommand("linux", "zenity", q)
+	if !slices.Contains(args, "--no-markup") || !slices.Contains(args, "--text="+q) || env != nil {
+		t.Errorf("zenity: %q %q", args, env)
+	}
+	if args, _ = dialogCommand("linux", "kdialog", q); args[0] != "kdialog" || args[len(args)-1] != q {
+		t.Errorf("kdialog: %q", args)
+	}
+	if args, _ = dialogCommand("darwin", "", `"`+q); args[0] != "osascript" || !strings.Contains(args[2], appleScriptQuote(`"`+q)) {
+		t.Errorf("osascript: %q", args)
+	}
+}
+
+func TestAppleScriptQuote(t *testing.T) {
+	if got := appleScriptQuote(`run "a\b"`); got != `"run \"a\\b\""` {
+		t.Errorf("got %s", got)
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"slices"
+)
+
+// command is an md subcommand.
+type command struct {
+	name string
+	// aliases are the other names of the command.
+	aliases []string
+	// ops are the operations of a command taking one as first argument, as
+	// md port add.
+	ops []string
+	// args completes the positional arguments.
+	args completer
+	// hidden commands are left out of completion.
+	hidden bool
+	run    func(ctx context.Context, args []string) error
+}
+
+// commandTable lists the subcommands. It is set by init since
+// md completion refers to it.
+var commandTable []*command
+
+func init() {
+	withoutCtx := func(f func([]string) error) func(context.Context, []string) error {
+		return func(_ context.Context, args []string) error { return f(args) }
+	}
+	commandTable = []*command{
+		{name: "start", run: cmdStart},
+		{name: "run", run: cmdRun},
+		{name: "exec", run: cmdExec},
+		{name: "list", run: cmdList},
+		{name: "ssh", run: withoutCtx(cmdSSH), hidden: true},
+		{name: "purge", aliases: []string{"kill"}, args: completeContainers, run: cmdPurge},
+		{name: "stop", args: completeContainers, run: cmdStop},
+		{name: "resume", args: completeContainers, run: cmdResume},
+		{name: "push", run: cmdPush},
+		{name: "pull", run: cmdPull},
+		{name: "fetch", run: cmdFetch},
+		{name: "sync", run: cmdSync},
+		{name: "deepen", run: cmdDeepen},
+		{name: "diff", run: cmdDiff},
+		{name: "gerrit", ops: []string{"push"}, run: cmdGerrit},
+		{name: "review", run: cmdReview},
+		{name: "pr", run: cmdPR},
+		{name: "changelog", run: cmdChangelog},
+		{name: "export-review", run: cmdExportReview},
+		{name: "timeline", args: completeContainers, run: cmdTimeline},
+		{name: "fsdiff", run: cmdFSDiff},
+		{name: "bake", run: cmdBake},
+		{name: "fork", run: cmdFork},
+		{name: "clone", args: completeBranches, run: cmdClone},
+		{name: "snapshot", run: cmdSnapshot},
+		{name: "restore", run: cmdRestore},
+		{name: "handoff", run: cmdHandoff},
+		{name: "status", run: cmdStatus},
+		{name: "inspect", args: completeContainers, run: cmdInspect},
+		{name: "verify", run: cmdVerify},
+		{name: "services", args: completeContainers, run: cmdServices},
+		{name: "tailscale", ops: []string{"status"}, args: completeContainers, run: cmdTailscale},
+		{name: "logs", run: cmdLogs},
+		{name: "ui", run: cmdUI},
+		{name: "serve", run: cmdServe},
+		{name: "mcp", run: cmdMCP},
+		{name: "vnc", run: cmdVNC},
+		{name: "rdp", run: cmdRDP},
+		{name: "build-image", run: cmdBuildImage},
+		{name: "config", ops: []string{"validate"}, run: cmdConfig},
+		{name: "ws", aliases: []string{"workspace"}, ops: []string{"start", "status", "push", "kill"}, args: completeWorkspaces, run: cmdWorkspace},
+		{name: "images", run: cmdImages},
+		{name: "prune", run: cmdPrune},
+		{name: "gc", run: cmdGC},
+		{name: "doctor", run: cmdDoctor},
+		{name: "bench", run: cmdBench},
+		{name: "debug", ops: []string{"bundle"}, run: cmdDebug},
+		{name: "task", ops: []string{"from-issue"}, run: cmdTask},
+		{name: "port", ops: []string{"list", "add", "remove"}, run: cmdPort},
+		{name: "env", ops: []string{"list", "set", "unset"}, run: cmdEnv},
+		{name: "cache", ops: []string{"warm"}, run: cmdCache},
+		{name: "completion", args: fixedValues("bash", "zsh", "fish"), run: withoutCtx(cmdCompletion)},
+		{name: "__complete", hidden: true, run: cmdComplete},
+		{name: "version", run: withoutCtx(cmdVersion)},
+	}
+}
+
+// findCommand returns the command named name, nil if there is none.
+func findCommand(name string) *command {
+	for _, c := range commandTable {
+		if c.name == name || slices.Contains(c.aliases, name) {
+			return c
+		}
+	}
+	return nil
+}
+
+// describing collects the flag sets created while describeFlags runs a
+// command.
+var describing *[]*flag.FlagSet
+
+// newFlagSet returns the flag set of a subcommand. While describeFlags runs,
+// the set reports -h as an error instead of printing the usage and exiting,
+// so the command returns as soon as it parses its flags.
+func newFlagSet(name string) *flag.FlagSet {
+	if describing == nil {
+		return flag.NewFlagSet(name, flag.ExitOnError)
+	}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	*describing = append(*describing, fs)
+	return fs
+}
+
+// describeFlags returns the flags of c, or of its operation op when c has
+// operations. Each command declares its flags in its own flag set, so c is
+// run with -h and returns once it has declared them. It isn't safe for
+// concurrent use.
+func describeFlags(ctx context.Context, c *command, op string) []*flag.Flag {
+	var sets []*flag.FlagSet
+	describing = &sets
+	defer func() { describing = nil }()
+	args := []string{"-h"}
+	if op != "" {
+		args = []string{op, "-h"}
+	}
+	_ = c.run(ctx, args)
+	var flags []*flag.Flag
+	for _, fs := range sets {
+		fs.VisitAll(func(f *flag.Flag) { flags = append(flags, f) })
+	}
+	return flags
+}
@@ -0,0 +1,266 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/caic-xyz/md"
+	"github.com/caic-xyz/md/gitutil"
+)
+
+// completer returns the candidate values of an argument.
+type completer func(ctx context.Context) []string
+
+// flagValues completes the values of the flags, by name, of every command.
+var flagValues = map[string]completer{
+	"b":         completeBranches,
+	"branch":    completeBranches,
+	"cache":     completeCaches,
+	"no-cache":  completeCaches,
+	"mount-src": fixedValues("rw", "ro", "overlay"),
+	"runtime":   fixedValues("docker", "podman"),
+	"engine":    fixedValues("docker", "podman"),
+	"s":         completeContainers,
+	"source":    completeContainers,
+	"sudo":      fixedValues("full", "limited", "none"),
+}
+
+// fixedValues returns a completer of values.
+func fixedValues(values ...string) completer {
+	return func(context.Context) []string { return values }
+}
+
+// completeContainers returns the names of the md containers.
+func completeContainers(ctx context.Context) []string {
+	c, err := newClient()
+	if err != nil {
+		return nil
+	}
+	containers, err := c.List(ctx)
+	if err != nil {
+		return nil
+	}
+	names := make([]string, len(containers))
+	for i, ct := range containers {
+		names[i] = ct.Name
+	}
+	return names
+}
+
+// completeBranches returns the local branches of the current repository.
+func completeBranches(ctx context.Context) []string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil
+	}
+	branches, err := gitutil.ListBranches(ctx, wd, "")
+	if err != nil {
+		return nil
+	}
+	names := make([]string, len(branches))
+	for i, b := range branches {
+		names[i] = b[0]
+	}
+	return names
+}
+
+// completeCaches returns the well-known cache names.
+func completeCaches(context.Context) []string {
+	return slices.Sorted(maps.Keys(md.WellKnownCaches))
+}
+
+// completeWorkspaces returns the configured workspaces.
+func completeWorkspaces(context.Context) []string {
+	return slices.Sorted(maps.Keys(config.Workspaces))
+}
+
+// globalFlags are the flags accepted before the command, see mainImpl.
+var globalFlags = []string{"--verbose", "--runtime", "--engine", "--control-master", "--kube-context", "--no-network"}
+
+// complete returns the completions of the last of words, the command line
+// after "md" up to the word being completed, which may be empty.
+func complete(ctx context.Context, words []string) []string {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	cur := words[len(words)-1]
+	prev := words[:len(words)-1]
+	// Skip the global flags to find the command.
+	i := 0
+	for i < len(prev) && strings.HasPrefix(prev[i], "-") {
+		if !strings.Contains(prev[i], "=") && slices.Contains([]string{"-runtime", "--runtime", "-engine", "--engine", "-kube-context", "--kube-context"}, prev[i]) {
+			i++
+		}
+		i++
+	}
+	if i >= len(prev) {
+		if i > len(prev) {
+			// cur is the value of a global flag.
+			return filterPrefix(completeFlagValue(ctx, strings.TrimLeft(prev[len(prev)-1], "-")), cur)
+		}
+		if strings.HasPrefix(cur, "-") {
+			return filterPrefix(globalFlags, cur)
+		}
+		var names []string
+		for _, c := range commandTable {
+			if !c.hidden {
+				names = append(names, c.name)
+			}
+		}
+		return filterPrefix(names, cur)
+	}
+	c := findCommand(prev[i])
+	if c == nil {
+		return nil
+	}
+	args := prev[i+1:]
+	op := ""
+	if len(c.ops) != 0 {
+		if len(args) == 0 {
+			return filterPrefix(c.ops, cur)
+		}
+		op, args = args[0], args[1:]
+	}
+	flags := describeFlags(ctx, c, op)
+
+	// bash splits --flag=value at the "=".
+	if n := len(args); n >= 2 && args[n-1] == "=" && strings.HasPrefix(args[n-2], "-") {
+		return filterPrefix(completeFlagValue(ctx, strings.TrimLeft(args[n-2], "-")), cur)
+	}
+	if name, value, ok := strings.Cut(cur, "="); ok && strings.HasPrefix(name, "-") {
+		var out []string
+		for _, v := range filterPrefix(completeFlagValue(ctx, strings.TrimLeft(name, "-")), value) {
+			out = append(out, name+"="+v)
+		}
+		return out
+	}
+	if n := len(args); n != 0 && strings.HasPrefix(args[n-1], "-") && !strings.Contains(args[n-1], "=") {
+		if f := lookupFlag(flags, strings.TrimLeft(args[n-1], "-")); f != nil && !isBoolFlag(f) {
+			return filterPrefix(completeFlagValue(ctx, f.Name), cur)
+		}
+	}
+	if strings.HasPrefix(cur, "-") {
+		var names []string
+		for _, f := range flags {
+			if len(f.Name) == 1 {
+				names = append(names, "-"+f.Name)
+			} else {
+				names = append(names, "--"+f.Name)
+			}
+		}
+		slices.Sort(names)
+		return filterPrefix(slices.Compact(names), cur)
+	}
+	if c.args != nil {
+		return filterPrefix(c.args(ctx), cur)
+	}
+	return nil
+}
+
+// completeFlagValue returns the candidate values of the flag name.
+func completeFlagValue(ctx context.Context, name string) []string {
+	if f := flagValues[name]; f != nil {
+		return f(ctx)
+	}
+	return nil
+}
+
+// lookupFlag returns the flag name in flags, nil if there is none.
+func lookupFlag(flags []*flag.Flag, name string) *flag.Flag {
+	for _, f := range flags {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// isBoolFlag reports whether f takes no value.
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// filterPrefix returns the values starting with prefix.
+func filterPrefix(values []string, prefix string) []string {
+	var out []string
+	for _, v := range values {
+		if strings.HasPrefix(v, prefix) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// cmdComplete implements the hidden md __complete command the completion
+// scripts call with the words of the command line.
+func cmdComplete(ctx context.Context, args []string) error {
+	for _, c := range complete(ctx, args) {
+		fmt.Println(c)
+	}
+	return nil
+}
+
+func cmdCompletion(args []string) error {
+	const usage = "usage: md completion bash|zsh|fish"
+	fs := newFlagSet("completion")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New(usage)
+	}
+	script, ok := completionScripts[fs.Arg(0)]
+	if !ok {
+		return fmt.Errorf("unsupported shell %q; %s", fs.Arg(0), usage)
+	}
+	_, err := fmt.Print(script)
+	return err
+}
+
+// completionScripts are the completion scripts by shell. They call
+// md __complete, falling back to file names when it has no candidate.
+var completionScripts = map[string]string{
+	"bash": `# bash completion for md; load with: source <(md completion bash)
+_md() {
+	local IFS=$'\n'
+	COMPREPLY=($(md __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null))
+}
+complete -o default -F _md md
+`,
+	"zsh": `#compdef md
+# zsh completion for md; load with: source <(md completion zsh)
+_md() {
+	local -a candidates
+	candidates=(${(f)"$(md __complete "${(@)words[2,CURRENT]}" 2>/dev/null)"})
+	if (( ${#candidates} )); then
+		compadd -Q -- "${candidates[@]}"
+	else
+		_files
+	fi
+}
+compdef _md md
+`,
+	"fish": `# fish completion for md; load with: md completion fish | source
+function __md_complete
+	set -l tokens (commandline -opc) (commandline -ct)
+	set -l candidates (md __complete $tokens[2..-1] 2>/dev/null)
+	if test (count $candidates) -eq 0
+		__fish_complete_path (commandline -ct)
+	else
+		printf '%s\n' $candidates
+	end
+end
+complete -c md -f -a '(__md_complete)'
+`,
+}
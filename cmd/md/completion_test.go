@@ -0,0 +1,55 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestComplete(t *testing.T) {
+	for _, tc := range []struct {
+		words []string
+		want  []string
+	}{
+		{[]string{"st"}, []string{"start", "stop", "status"}},
+		{[]string{"-v", "re"}, []string{"resume", "review", "restore"}},
+		{[]string{"--runtime", "p"}, []string{"podman"}},
+		{[]string{"--runtime", "docker", "ver"}, []string{"verify", "version"}},
+		{[]string{"--k"}, []string{"--kube-context"}},
+		{[]string{"start", "--disp"}, []string{"--display", "--display-size", "--displays"}},
+		{[]string{"start", "--cache", "go"}, []string{"go-mod"}},
+		{[]string{"start", "--cache", "=", "go"}, []string{"go-mod"}},
+		{[]string{"start", "--mount-src=r"}, []string{"--mount-src=rw", "--mount-src=ro"}},
+		{[]string{"start", "--display", "--no-cache", "gr"}, []string{"gradle"}},
+		{[]string{"ws", ""}, []string{"start", "status", "push", "kill"}},
+		{[]string{"workspace", "push", "-"}, []string{"--verbose", "-j", "-v"}},
+		{[]string{"port", "add", "--re"}, []string{"--repo"}},
+		{[]string{"completion", ""}, []string{"bash", "zsh", "fish"}},
+		{[]string{"__comp"}, nil},
+		{[]string{"nope", ""}, nil},
+	} {
+		if got := complete(t.Context(), tc.words); !slices.Equal(got, tc.want) {
+			t.Errorf("complete(%q) = %q, want %q", tc.words, got, tc.want)
+		}
+	}
+	if describing != nil {
+		t.Error("describing was not reset")
+	}
+}
+
+func TestCommandTable(t *testing.T) {
+	// Every command usable in the [args] configuration table is registered.
+	for _, name := range commands {
+		if findCommand(name) == nil {
+			t.Errorf("%s is not in commandTable", name)
+		}
+	}
+	for _, name := range []string{"help", "completion", "__complete"} {
+		if slices.Contains(commands, name) {
+			t.Errorf("%s accepts no configured args", name)
+		}
+	}
+}
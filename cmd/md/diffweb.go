@@ -0,0 +1,437 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/caic-xyz/md"
+	"github.com/caic-xyz/md/gitutil"
+)
+
+// serveDiffWeb serves the diff of the repos indices of ct as a side-by-side
+// HTML page on a loopback port and opens it in the browser, until ctx is
+// canceled. The diff is taken again at each page load.
+func serveDiffWeb(ctx context.Context, ct *md.Container, indices []int, opts *md.DiffOpts, gitArgs []string) error {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		page := &webPage{Container: ct.Name, Time: time.Now()}
+		for _, i := range indices {
+			var stdout, stderr bytes.Buffer
+			args := append([]string{"--no-color", "--no-ext-diff"}, gitArgs...)
+			if err := ct.DiffWith(r.Context(), &stdout, &stderr, i, opts, args); err != nil {
+				http.Error(w, fmt.Sprintf("%v\n%s", err, stderr.String()), http.StatusInternalServerError)
+				return
+			}
+			page.Files = append(page.Files, parseWebDiff(ct.Repos[i].Name(), stdout.String())...)
+		}
+		page.Tree = webTree(page.Files, len(indices) > 1)
+		var buf bytes.Buffer
+		if err := webDiffTmpl.Execute(&buf, page); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write(buf.Bytes())
+	})
+	srv := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+	go func() {
+		<-ctx.Done()
+		sctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(sctx)
+	}()
+	u := "http://" + ln.Addr().String() + "/"
+	fmt.Printf("Serving the diff of %s on %s; reload to refresh, Ctrl-C to stop\n", ct.Name, u)
+	if err := openBrowser(u); err != nil {
+		fmt.Fprintf(os.Stderr, "md: opening the browser: %v\n", err)
+	}
+	if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// openBrowser opens u in the default browser.
+func openBrowser(u string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("open", u).Run()
+	case "windows":
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", u).Run()
+	default:
+		return exec.Command("xdg-open", u).Run()
+	}
+}
+
+// webPage is the data of the md diff -web page.
+type webPage struct {
+	Container string
+	Time      time.Time
+	Files     []*webFile
+	Tree      []webTreeEntry
+}
+
+// webFile is a file of the diff.
+type webFile struct {
+	Repo string
+	Path string
+	// OldPath is set for renames.
+	OldPath string
+	// Status is added, deleted, renamed or modified.
+	Status  string
+	Added   int
+	Deleted int
+	Binary  bool
+	// Generated files, per gitutil.IsGeneratedFile, are collapsed.
+	Generated bool
+	Hunks     []webHunk
+}
+
+// Anchor returns the id of the file's section in the page.
+func (f *webFile) Anchor() string {
+	return "f-" + strconv.Itoa(int(hashString(f.Repo+"/"+f.Path)))
+}
+
+// webHunk is a hunk of a file, as side-by-side rows.
+type webHunk struct {
+	Header string
+	Rows   []webRow
+}
+
+// webRow is a line of the side-by-side view. A zero line number is an empty
+// cell.
+type webRow struct {
+	OldNum, NewNum   int
+	Old, New         template.HTML
+	OldKind, NewKind string
+}
+
+// webTreeEntry is a directory or a file of the file tree.
+type webTreeEntry struct {
+	Name  string
+	Depth int
+	// File is nil for directories.
+	File *webFile
+}
+
+// parseWebDiff parses the unified diff out of repo.
+func parseWebDiff(repo, out string) []*webFile {
+	var files []*webFile
+	var f *webFile
+	var h *webHunk
+	var oldNum, newNum int
+	var dels, adds []string
+	lang := ""
+	// flush pairs the pending deleted and added lines.
+	flush := func() {
+		for i := range max(len(dels), len(adds)) {
+			var r webRow
+			if i < len(dels) {
+				r.OldNum, r.Old, r.OldKind = oldNum, highlight(lang, dels[i]), "del"
+				oldNum++
+			}
+			if i < len(adds) {
+				r.NewNum, r.New, r.NewKind = newNum, highlight(lang, adds[i]), "add"
+				newNum++
+			}
+			h.Rows = append(h.Rows, r)
+		}
+		dels, adds = dels[:0], adds[:0]
+	}
+	for line := range strings.SplitSeq(out, "\n") {
+		if strings.HasPrefix(line, "diff --git ") {
+			if h != nil {
+				flush()
+			}
+			f, h = &webFile{Repo: repo, Status: "modified"}, nil
+			// a/<path> b/<path>, refined by the ---/+++ lines.
+			if i := strings.LastIndex(line, " b/"); i >= 0 {
+				f.Path = line[i+3:]
+			}
+			files = append(files, f)
+			continue
+		}
+		if f == nil {
+			continue
+		}
+		if h == nil {
+			switch {
+			case strings.HasPrefix(line, "new file mode"):
+				f.Status = "added"
+			case strings.HasPrefix(line, "deleted file mode"):
+				f.Status = "deleted"
+			case strings.HasPrefix(line, "rename from "):
+				f.Status, f.OldPath = "renamed", diffPath(strings.TrimPrefix(line, "rename from "))
+			case strings.HasPrefix(line, "rename to "):
+				f.Path = diffPath(strings.TrimPrefix(line, "rename to "))
+			case strings.HasPrefix(line, "Binary files "):
+				f.Binary = true
+			case strings.HasPrefix(line, "+++ "):
+				if p := diffPath(strings.TrimPrefix(line, "+++ ")); p != "/dev/null" {
+					f.Path = strings.TrimPrefix(p, "b/")
+				}
+			case strings.HasPrefix(line, "--- "):
+				if p := diffPath(strings.TrimPrefix(line, "--- ")); p != "/dev/null" && f.Path == "" {
+					f.Path = strings.TrimPrefix(p, "a/")
+				}
+			}
+			f.Generated = gitutil.IsGeneratedFile(f.Path)
+			lang = strings.TrimPrefix(path.Ext(f.Path), ".")
+		}
+		if strings.HasPrefix(line, "@@ ") {
+			if h != nil {
+				flush()
+			}
+			f.Hunks = append(f.Hunks, webHunk{Header: line})
+			h = &f.Hunks[len(f.Hunks)-1]
+			oldNum, newNum = parseHunkHeader(line)
+			continue
+		}
+		if h == nil || line == "" {
+			continue
+		}
+		switch line[0] {
+		case '-':
+			if len(adds) != 0 {
+				flush()
+			}
+			dels = append(dels, line[1:])
+			f.Deleted++
+		case '+':
+			adds = append(adds, line[1:])
+			f.Added++
+		case ' ':
+			flush()
+			hl := highlight(lang, line[1:])
+			h.Rows = append(h.Rows, webRow{OldNum: oldNum, NewNum: newNum, Old: hl, New: hl, OldKind: "ctx", NewKind: "ctx"})
+			oldNum++
+			newNum++
+		}
+	}
+	if h != nil {
+		flush()
+	}
+	return files
+}
+
+// diffPath unquotes a path git quoted for its special characters.
+func diffPath(p string) string {
+	if strings.HasPrefix(p, `"`) {
+		if u, err := strconv.Unquote(p); err == nil {
+			return u
+		}
+	}
+	return p
+}
+
+// parseHunkHeader returns the first old and new line numbers of the hunk
+// header "@@ -a,b +c,d @@".
+func parseHunkHeader(line string) (oldNum, newNum int) {
+	f := strings.Fields(line)
+	if len(f) < 3 {
+		return 0, 0
+	}
+	o, _, _ := strings.Cut(strings.TrimPrefix(f[1], "-"), ",")
+	n, _, _ := strings.Cut(strings.TrimPrefix(f[2], "+"), ",")
+	oldNum, _ = strconv.Atoi(o)
+	newNum, _ = strconv.Atoi(n)
+	return oldNum, newNum
+}
+
+// webTree returns the file tree of files, sorted by path, under a node per
+// repo with repos.
+func webTree(files []*webFile, repos bool) []webTreeEntry {
+	sorted := slices.Clone(files)
+	slices.SortStableFunc(sorted, func(a, b *webFile) int {
+		return strings.Compare(a.Repo+"/"+a.Path, b.Repo+"/"+b.Path)
+	})
+	var tree []webTreeEntry
+	var prev []string
+	for _, f := range sorted {
+		parts := strings.Split(f.Path, "/")
+		if repos {
+			parts = append([]string{f.Repo}, parts...)
+		}
+		dirs := parts[:len(parts)-1]
+		common := 0
+		for common < len(dirs) && common < len(prev) && dirs[common] == prev[common] {
+			common++
+		}
+		for d := common; d < len(dirs); d++ {
+			tree = append(tree, webTreeEntry{Name: dirs[d] + "/", Depth: d})
+		}
+		tree = append(tree, webTreeEntry{Name: parts[len(parts)-1], Depth: len(dirs), File: f})
+		prev = dirs
+	}
+	return tree
+}
+
+// hashString is FNV-1a, for stable anchors.
+func hashString(s string) uint32 {
+	h := uint32(2166136261)
+	for i := range len(s) {
+		h ^= uint32(s[i])
+		h *= 16777619
+	}
+	return h
+}
+
+// webLang is how highlight colors a language: its line comment marker and
+// keywords.
+type webLang struct {
+	comment  string
+	keywords []string
+}
+
+var (
+	cLikeLang = webLang{"//", strings.Fields("break case catch class const continue default do else enum export extends false finally for function if import in interface let new null private protected public return static struct super switch this throw true try typeof var void while")}
+	webLangs  = map[string]webLang{
+		"go":   {"//", strings.Fields("break case chan const continue default defer else fallthrough for func go goto if import interface map nil package range return select struct switch type var true false")},
+		"rs":   {"//", strings.Fields("as async await break const continue crate else enum extern false fn for if impl in let loop match mod move mut pub ref return self Self static struct super trait true type unsafe use where while")},
+		"py":   {"#", strings.Fields("and as assert async await break class continue def del elif else except False finally for from global if import in is lambda None nonlocal not or pass raise return True try while with yield")},
+		"sh":   {"#", strings.Fields("case do done elif else esac fi for function if in local return then until while")},
+		"rb":   {"#", strings.Fields("begin class def do else elsif end ensure false if module nil return self then true unless until while yield")},
+		"yaml": {"#", nil},
+		"toml": {"#", nil},
+	}
+)
+
+func init() {
+	for _, ext := range []string{"c", "h", "cc", "cpp", "hpp", "java", "js", "jsx", "ts", "tsx", "mjs", "kt", "swift", "cs", "dart", "scala", "proto"} {
+		webLangs[ext] = cLikeLang
+	}
+	webLangs["bash"] = webLangs["sh"]
+	webLangs["yml"] = webLangs["yaml"]
+}
+
+// highlight returns line of a file with extension ext as HTML, its
+// keywords, strings, numbers and comments in spans. Constructs spanning lines
+// aren't tracked.
+func highlight(ext, line string) template.HTML {
+	l, ok := webLangs[ext]
+	if !ok {
+		return template.HTML(html.EscapeString(line)) //nolint:gosec // escaped
+	}
+	var b strings.Builder
+	span := func(class, s string) {
+		b.WriteString(`<span class="` + class + `">` + html.EscapeString(s) + `</span>`)
+	}
+	for i := 0; i < len(line); {
+		c := line[i]
+		switch {
+		case strings.HasPrefix(line[i:], l.comment):
+			span("c", line[i:])
+			i = len(line)
+		case c == '"' || c == '\'' || c == '`':
+			j := i + 1
+			for j < len(line) && line[j] != c {
+				if line[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			j = min(j+1, len(line))
+			span("s", line[i:j])
+			i = j
+		case isWordByte(c):
+			j := i
+			for j < len(line) && isWordByte(line[j]) {
+				j++
+			}
+			switch w := line[i:j]; {
+			case unicode.IsDigit(rune(c)):
+				span("n", w)
+			case slices.Contains(l.keywords, w):
+				span("k", w)
+			default:
+				b.WriteString(html.EscapeString(w))
+			}
+			i = j
+		default:
+			b.WriteString(html.EscapeString(line[i : i+1]))
+			i++
+		}
+	}
+	return template.HTML(b.String()) //nolint:gosec // escaped
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= 0x80 || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c))
+}
+
+var webDiffTmpl = template.Must(template.New("diff").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>md diff {{.Container}}</title>
+<style>
+:root { color-scheme: light dark; --add: #e6ffec; --del: #ffebe9; --hunk: #ddf4ff; --muted: #656d76; --border: #d0d7de; }
+@media (prefers-color-scheme: dark) { :root { --add: #12261e; --del: #25171c; --hunk: #121d2f; --muted: #8d96a0; --border: #30363d; } }
+body { margin: 0; font: 14px system-ui, sans-serif; display: flex; }
+nav { position: sticky; top: 0; height: 100vh; overflow: auto; width: 280px; flex-shrink: 0; border-right: 1px solid var(--border); padding: 8px; box-sizing: border-box; font-size: 13px; }
+nav a { text-decoration: none; color: inherit; }
+nav div { white-space: nowrap; overflow: hidden; text-overflow: ellipsis; }
+nav .dir { color: var(--muted); }
+main { flex-grow: 1; min-width: 0; padding: 8px 16px; }
+details { border: 1px solid var(--border); border-radius: 6px; margin-bottom: 16px; }
+summary { padding: 6px 8px; cursor: pointer; font-family: monospace; border-bottom: 1px solid var(--border); }
+.stat { color: var(--muted); margin-left: 8px; }
+.added { color: #1a7f37; } .deleted { color: #cf222e; } .renamed, .generated { color: var(--muted); }
+table { border-collapse: collapse; width: 100%; table-layout: fixed; font: 12px monospace; }
+td { vertical-align: top; padding: 0 6px; white-space: pre-wrap; word-break: break-all; }
+td.num { width: 44px; text-align: right; color: var(--muted); user-select: none; }
+td.add { background: var(--add); } td.del { background: var(--del); }
+tr.hunk td { background: var(--hunk); color: var(--muted); }
+.k { color: #cf222e; } .s { color: #0a3069; } .n { color: #0550ae; } .c { color: var(--muted); font-style: italic; }
+@media (prefers-color-scheme: dark) { .k { color: #ff7b72; } .s { color: #a5d6ff; } .n { color: #79c0ff; } }
+</style>
+</head>
+<body>
+<nav>
+<div><b>{{.Container}}</b></div>
+<div class="dir">{{len .Files}} file(s), {{.Time.Format "15:04:05"}}</div>
+<hr>
+{{range .Tree}}<div style="padding-left: {{.Depth}}em">{{if .File}}<a href="#{{.File.Anchor}}" class="{{.File.Status}}">{{.Name}}</a>{{else}}<span class="dir">{{.Name}}</span>{{end}}</div>
+{{end}}
+</nav>
+<main>
+{{if not .Files}}<p>No changes.</p>{{end}}
+{{range .Files}}<details id="{{.Anchor}}"{{if not .Generated}} open{{end}}>
+<summary>{{if .OldPath}}{{.OldPath}} → {{end}}{{.Path}}<span class="stat"><span class="{{.Status}}">{{.Status}}</span>{{if .Generated}} <span class="generated">generated</span>{{end}} <span class="added">+{{.Added}}</span> <span class="deleted">-{{.Deleted}}</span></span></summary>
+{{if .Binary}}<p class="stat">Binary file</p>{{else}}<table>
+{{range .Hunks}}<tr class="hunk"><td colspan="4">{{.Header}}</td></tr>
+{{range .Rows}}<tr><td class="num">{{if .OldNum}}{{.OldNum}}{{end}}</td><td class="{{.OldKind}}">{{.Old}}</td><td class="num">{{if .NewNum}}{{.NewNum}}{{end}}</td><td class="{{.NewKind}}">{{.New}}</td></tr>
+{{end}}{{end}}</table>{{end}}
+</details>
+{{end}}
+</main>
+</body>
+</html>
+`))
@@ -0,0 +1,103 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseWebDiff(t *testing.T) {
+	const out = `diff --git a/main.go b/main.go
+index 1111111..2222222 100644
+--- a/main.go
++++ b/main.go
+@@ -10,4 +10,5 @@ func main() {
+ 	a := 1
+-	b := "old"
++	b := "new"
++	c := 3
+ 	return
+diff --git a/go.sum b/go.sum
+new file mode 100644
+index 0000000..3333333
+--- /dev/null
++++ b/go.sum
+@@ -0,0 +1 @@
++example.com/x v1.0.0 h1:abc=
+diff --git a/old.txt b/docs/new.txt
+similarity index 100%
+rename from old.txt
+rename to docs/new.txt
+diff --git a/logo.png b/logo.png
+deleted file mode 100644
+Binary files a/logo.png and /dev/null differ
+`
+	files := parseWebDiff("md", out)
+	if len(files) != 4 {
+		t.Fatalf("got %d files", len(files))
+	}
+	f := files[0]
+	if f.Path != "main.go" || f.Status != "modified" || f.Added != 2 || f.Deleted != 1 || f.Generated || len(f.Hunks) != 1 {
+		t.Fatalf("got %+v", f)
+	}
+	rows := f.Hunks[0].Rows
+	if len(rows) != 4 {
+		t.Fatalf("got %d rows", len(rows))
+	}
+	// The deletion is paired with the first addition, the second one has no
+	// old line.
+	if r := rows[1]; r.OldNum != 11 || r.NewNum != 11 || r.OldKind != "del" || r.NewKind != "add" {
+		t.Errorf("row 1: %+v", r)
+	}
+	if r := rows[2]; r.OldNum != 0 || r.NewNum != 12 || r.NewKind != "add" {
+		t.Errorf("row 2: %+v", r)
+	}
+	if r := rows[3]; r.OldNum != 12 || r.NewNum != 13 || r.OldKind != "ctx" {
+		t.Errorf("row 3: %+v", r)
+	}
+	if !strings.Contains(string(rows[1].New), `<span class="s">&#34;new&#34;</span>`) {
+		t.Errorf("highlight: %s", rows[1].New)
+	}
+	if f := files[1]; f.Path != "go.sum" || f.Status != "added" || !f.Generated {
+		t.Errorf("got %+v", f)
+	}
+	if f := files[2]; f.Path != "docs/new.txt" || f.OldPath != "old.txt" || f.Status != "renamed" {
+		t.Errorf("got %+v", f)
+	}
+	if f := files[3]; f.Path != "logo.png" || f.Status != "deleted" || !f.Binary {
+		t.Errorf("got %+v", f)
+	}
+
+	tree := webTree(files, false)
+	var names []string
+	for _, e := range tree {
+		names = append(names, strings.Repeat(" ", e.Depth)+e.Name)
+	}
+	if got := strings.Join(names, "|"); got != "docs/| new.txt|go.sum|logo.png|main.go" {
+		t.Errorf("tree %q", got)
+	}
+	var buf bytes.Buffer
+	if err := webDiffTmpl.Execute(&buf, &webPage{Container: "md-x", Files: files, Tree: tree}); err != nil {
+		t.Fatal(err)
+	}
+	// Generated files are collapsed.
+	if !strings.Contains(buf.String(), `<details id="`+files[1].Anchor()+`">`) || !strings.Contains(buf.String(), `<details id="`+files[0].Anchor()+`" open>`) {
+		t.Error("generated file not collapsed")
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	for _, tt := range []struct{ ext, line, want string }{
+		{"go", `return x // <done>`, `<span class="k">return</span> x <span class="c">// &lt;done&gt;</span>`},
+		{"py", `n = 42 # 'x'`, `n = <span class="n">42</span> <span class="c"># &#39;x&#39;</span>`},
+		{"txt", `if <a>`, `if &lt;a&gt;`},
+	} {
+		if got := string(highlight(tt.ext, tt.line)); got != tt.want {
+			t.Errorf("highlight(%q, %q) = %q, want %q", tt.ext, tt.line, got, tt.want)
+		}
+	}
+}
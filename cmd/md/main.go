@@ -7,21 +7,28 @@
 package main
 
 import (
+	"bytes"
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log/slog"
 	"maps"
+	"net"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
 	"runtime"
 	"runtime/debug"
+	"runtime/pprof"
+	"runtime/trace"
 	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -31,14 +38,32 @@ import (
 	"github.com/maruel/genai"
 	"github.com/maruel/genai/providers"
 	"golang.org/x/sync/errgroup"
+	"golang.org/x/term"
 )
 
 // runtimeOverride is set by --runtime and applied in newClient/cmdList.
 var runtimeOverride string
 
+// config is the user configuration merged with the current directory's
+// repository .md.toml; loaded by mainImpl.
+var config = &md.Config{}
+
 // controlMasterEnabled is set by --control-master and applied in newClient.
 var controlMasterEnabled bool
 
+// kubeContext is set by --kube-context and applied in newClient/cmdList.
+var kubeContext string
+
+// noNetwork is set by --no-network and applied in newClient.
+var noNetwork bool
+
+// kubeCommands are the commands available when the containers run on
+// Kubernetes.
+var kubeCommands = []string{
+	"start", "exec", "list", "ssh", "purge", "kill", "push", "pull", "fetch", "sync", "diff", "timeline", "status", "verify", "logs", "ui", "serve", "mcp", "gc",
+	"build-image", "prune", "config", "env", "cache", "debug", "completion", "__complete", "version", "help",
+}
+
 func main() {
 	if err := mainImpl(); err != nil {
 		var ec *exitCodeError
@@ -66,17 +91,94 @@ func initLogging(verbose bool) {
 	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
 }
 
-func mainImpl() error {
+// startProfiling starts writing a CPU profile to cpuprofile and an execution
+// trace to tracePath when they are set. The returned function stops them.
+func startProfiling(cpuprofile, tracePath string) (func(), error) {
+	var stops []func()
+	stop := func() {
+		for _, f := range slices.Backward(stops) {
+			f()
+		}
+	}
+	if cpuprofile != "" {
+		f, err := os.Create(cpuprofile)
+		if err != nil {
+			return nil, err
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+		stops = append(stops, func() {
+			pprof.StopCPUProfile()
+			_ = f.Close()
+		})
+	}
+	if tracePath != "" {
+		f, err := os.Create(tracePath)
+		if err != nil {
+			stop()
+			return nil, err
+		}
+		if err := trace.Start(f); err != nil {
+			_ = f.Close()
+			stop()
+			return nil, err
+		}
+		stops = append(stops, func() {
+			trace.Stop()
+			_ = f.Close()
+		})
+	}
+	return stop, nil
+}
+
+// recordTiming records the command's duration for md debug bundle. Failures
+// are only logged.
+func recordTiming(cmd string, start time.Time, err error) {
+	p, err2 := md.DefaultTimingsPath()
+	if err2 == nil {
+		err2 = md.RecordTiming(p, md.Timing{Time: start.UTC(), Command: cmd, Duration: time.Since(start), Failed: err != nil})
+	}
+	if err2 != nil {
+		slog.Debug("md", "msg", "recording timing", "err", err2)
+	}
+}
+
+func mainImpl() (retErr error) {
 	// Pre-parse to support flags before the subcommand (e.g. "md -v start").
 	pre := flag.NewFlagSet("md", flag.ContinueOnError)
 	preVerbose := addVerboseFlag(pre)
-	preRuntime := pre.String("runtime", "", "Container runtime: docker or podman (default: auto-detect)")
+	preRuntime := pre.String("runtime", "", "Container runtime: docker or podman (default: $MD_ENGINE or auto-detect)")
+	pre.StringVar(preRuntime, "engine", "", "Alias for --runtime")
 	preControlMaster := pre.Bool("control-master", false, "Enable SSH ControlMaster connection multiplexing")
+	preKubeContext := pre.String("kube-context", "", "Run the containers on this Kubernetes context (default: kubernetes.context in the config)")
+	// Not --offline: md start --offline blocks the container's egress.
+	preNoNetwork := pre.Bool("no-network", false, "Work offline: use the local base image as is and skip the Tailscale auth key (default: detected)")
+	// Hidden: profile a slow command for a bug report.
+	preCPUProfile := pre.String("cpuprofile", "", "Write a CPU profile of the command to this file")
+	preTrace := pre.String("trace", "", "Write an execution trace of the command to this file")
 	// Ignore errors: unknown flags here are subcommand flags, parsed later.
 	_ = pre.Parse(os.Args[1:])
 	initLogging(*preVerbose)
+	stopProfiling, err := startProfiling(*preCPUProfile, *preTrace)
+	if err != nil {
+		return err
+	}
+	defer stopProfiling()
 	runtimeOverride = *preRuntime
+	if runtimeOverride != "" {
+		if err := md.ValidateRuntime(runtimeOverride); err != nil {
+			return err
+		}
+	} else if e := os.Getenv("MD_ENGINE"); e != "" {
+		if err := md.ValidateRuntime(e); err != nil {
+			return fmt.Errorf("$MD_ENGINE: %w", err)
+		}
+	}
 	controlMasterEnabled = *preControlMaster && runtime.GOOS != "windows"
+	kubeContext = *preKubeContext
+	noNetwork = *preNoNetwork
 	remaining := pre.Args()
 
 	if len(remaining) == 0 {
@@ -87,42 +189,37 @@ func mainImpl() error {
 	defer stop()
 	cmd := remaining[0]
 	args := remaining[1:]
+	// Completion runs on every key press; it isn't worth recording.
+	if cmd != "help" && cmd != "version" && cmd != "__complete" {
+		start := time.Now()
+		defer func() { recordTiming(cmd, start, retErr) }()
+	}
+	// md config validate must work with a broken configuration.
+	if cmd != "help" && cmd != "version" && cmd != "config" {
+		cfg, err := loadConfig(ctx)
+		if err != nil {
+			return err
+		}
+		config = cfg
+		if err := registerCacheSets(config.CacheSets); err != nil {
+			return err
+		}
+		// Config args come first so command line flags override them.
+		args = append(slices.Clone(config.Args[cmd]), args...)
+		if (kubeContext != "" || config.Kubernetes.Context != "") && !slices.Contains(kubeCommands, cmd) {
+			return fmt.Errorf("md %s is not available on Kubernetes", cmd)
+		}
+	}
 	switch cmd {
-	case "start":
-		return cmdStart(ctx, args)
-	case "run":
-		return cmdRun(ctx, args)
-	case "list":
-		return cmdList(ctx, args)
-	case "ssh":
-		return cmdSSH(args)
-	case "purge", "kill":
-		return cmdPurge(ctx, args)
-	case "stop":
-		return cmdStop(ctx, args)
-	case "push":
-		return cmdPush(ctx, args)
-	case "pull":
-		return cmdPull(ctx, args)
-	case "diff":
-		return cmdDiff(ctx, args)
-	case "fork":
-		return cmdFork(ctx, args)
-	case "vnc":
-		return cmdVNC(ctx, args)
-	case "build-image":
-		return cmdBuildImage(ctx, args)
-	case "prune":
-		return cmdPrune(ctx, args)
-	case "version":
-		return cmdVersion(args)
 	case "help", "-h", "-help", "--help":
 		usage()
 		return nil
-	default:
-		usage()
-		return fmt.Errorf("unknown command: %s", cmd)
 	}
+	if c := findCommand(cmd); c != nil {
+		return c.run(ctx, args)
+	}
+	usage()
+	return fmt.Errorf("unknown command: %s", cmd)
 }
 
 func usage() {
@@ -130,21 +227,63 @@ func usage() {
 		"\n"+
 		"Global flags:\n"+
 		"  -v, -verbose       Enable debug logging\n"+
-		"  --runtime <name>   Container runtime: docker or podman (default: auto-detect)\n"+
+		"  --runtime <name>   Container runtime: docker or podman (default: $MD_ENGINE or auto-detect)\n"+
+		"  --engine <name>    Alias for --runtime\n"+
+		"  --kube-context <name> Run the containers as pods on this Kubernetes context\n"+
+		"  --no-network       Work offline: use the local base image as is, no Tailscale auth key (default: detected)\n"+
 		"\n"+
 		"Commands:\n"+
 		"  start       Pull base image, rebuild if needed, start container, open shell\n"+
-		"  run <cmd>   Start a temporary container, run a command, then clean up\n"+
-		"  list        List running md containers\n"+
-		"  stop        Stop the container (preserves filesystem for later revival)\n"+
+		"  run <cmd>   Start a temporary container, run a command, then clean up (--detach; run list|logs|attach|wait <id>)\n"+
+		"  exec <cmd>  Run a command in the existing container (a single argument is a shell command line)\n"+
+		"  list        List md containers, running and stopped\n"+
+		"  stop        Stop the container (preserves filesystem, SSH config and git remote)\n"+
+		"  resume      Restart a stopped container\n"+
 		"  purge       Stop and remove the container permanently\n"+
 		"  push        Force-push current repo state into the running container\n"+
-		"  pull        Pull changes from container back to local branch\n"+
-		"  diff        Show differences between base and current changes\n"+
+		"  pull        Pull changes from container back to local branch (--strategy rebase|merge|squash, --continue, --abort)\n"+
+		"  fetch       Fetch the container's changes into a remote-tracking branch without integrating them\n"+
+		"  sync        Copy uncommitted changes into the container, continuously with --watch\n"+
+		"  deepen      Fetch more history into a shallow or partial clone (--depth 0 for all of it)\n"+
+		"  diff        Show differences between base and current changes (--web for a side-by-side view in the browser)\n"+
+		"  review      Ask the AI provider to review the container's changes (--pr <n> to post on GitHub)\n"+
+		"  pr          Push the container's branch to origin and open a GitHub pull request described by the AI provider\n"+
+		"  changelog [from [to]] Write release notes of the local commits since the last tag with the AI provider\n"+
+		"  export-review Write the diff, a summary, test results and metadata for a review tool\n"+
+		"  gerrit push Upload the container's changes as a Gerrit change\n"+
+		"  timeline    Report the container's history: pushes, pulls, commits and shell commands\n"+
+		"  fsdiff      Show non-git filesystem changes (installed packages, dotfiles)\n"+
+		"  bake [item] Record filesystem changes in .md/bake.Dockerfile for the next start\n"+
 		"  fork        Snapshot container and create a new one on forked branches\n"+
+		"  clone <branch> Duplicate the container, working tree included, onto a new branch\n"+
+		"  snapshot    Save the container as an image to restore later (-list, -rm <tag>)\n"+
+		"  restore <tag> Recreate the container from a snapshot\n"+
+		"  handoff <host> Move the container, working tree included, to another machine running md\n"+
+		"  status      Show the container, repo sync and service state for the current branch\n"+
+		"  inspect [container] Dump what md knows about the container for a bug report (--json)\n"+
+		"  verify      Smoke test the running container: SSH, git remote both ways, branches, display, Tailscale\n"+
+		"  services    Show the user services and the sidecars of .md/compose.yaml\n"+
+		"  tailscale status Show the container's Tailscale login URL or name (-wait to wait for it)\n"+
+		"  logs        Show the container's startup output or a service's log (--service <name>)\n"+
+		"  ui          Interactive dashboard of the containers: state, usage, logs, ssh, diff, pull, vnc, kill\n"+
+		"  serve       Serve an HTTP+JSON API to start, list, pull, diff and kill containers on a unix socket\n"+
+		"  mcp         Serve the md tools to agents over the Model Context Protocol (stdio, or SSE with -sse)\n"+
+		"  port list|add|remove [port] Manage port forwards to the running container\n"+
+		"  env list|set|unset [KEY[=VALUE]] Manage the secrets injected into containers\n"+
+		"  cache warm [name...] Download the repo's dependencies into the host caches before starting\n"+
 		"  vnc         Open VNC connection to the container\n"+
+		"  rdp         Open RDP connection to the container\n"+
 		"  build-image Build the base Docker image locally\n"+
-		"  prune       Remove unused md-specialized-* and md-fork-* images\n"+
+		"  images      List the images md built, their base and the containers using them\n"+
+		"  prune       Remove unused md images and leftovers of removed containers\n"+
+		"  gc          Stop (or --remove) containers idle for --idle or their --ttl, warn about disk usage\n"+
+		"  doctor      Diagnose the host setup and stale md state, with fixes\n"+
+		"  bench       Time start, push, pull and diff against the recorded baseline\n"+
+		"  debug bundle Write a tarball of logs, redacted configs and engine info for a bug report\n"+
+		"  task from-issue <n> Start a container on a branch for a GitHub issue and run the agent on it\n"+
+		"  config validate [file...] Check config.toml and .md.toml (--schema prints a JSON Schema)\n"+
+		"  ws start|status|push|kill <workspace> Operate on the containers of a configured workspace\n"+
+		"  completion bash|zsh|fish Print the shell completion script\n"+
 		"  version     Print version information\n")
 }
 
@@ -156,12 +295,69 @@ func newClient() (*md.Client, error) {
 	if runtimeOverride != "" {
 		c.Runtime = runtimeOverride
 	}
+	applyKubeContext(c)
 	c.ControlMaster = controlMasterEnabled
+	c.Offline = noNetwork
 	c.GithubToken = os.Getenv("GITHUB_TOKEN")
-	c.TailscaleAPIKey = os.Getenv("TAILSCALE_API_KEY")
+	// Include the hooks of the current repository's .md.toml.
+	c.Hooks = config.Hooks
+	if config.SignCommits != nil {
+		c.SignCommits = *config.SignCommits
+	}
+	if config.ConventionalCommits != nil {
+		c.ConventionalCommits = *config.ConventionalCommits
+	}
 	return c, nil
 }
 
+// applyKubeContext switches c to the Kubernetes context selected by
+// --kube-context, if any.
+func applyKubeContext(c *md.Client) {
+	if kubeContext != "" {
+		k := c.Config.Kubernetes
+		c.Kube = &md.Kube{Context: kubeContext, Namespace: k.Namespace, Registry: k.Registry}
+	}
+}
+
+// loadConfig loads the user configuration and the overrides of the
+// repository containing the current directory, if any.
+func loadConfig(ctx context.Context) (*md.Config, error) {
+	p, err := md.DefaultConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := md.LoadConfig(p)
+	if err != nil {
+		return nil, err
+	}
+	if root, err := gitutil.RootDir(ctx, "."); err == nil {
+		return md.LoadRepoConfig(cfg, root)
+	}
+	if root := gitutil.HgRoot("."); root != "" {
+		return md.LoadRepoConfig(cfg, root)
+	}
+	return cfg, nil
+}
+
+// repoRoot returns the root of the git repository holding path. For a
+// Mercurial repository, it is the root of its git mirror, refreshed from hg
+// (see gitutil.HgMirror).
+func repoRoot(ctx context.Context, path string) (string, error) {
+	root, err := gitutil.RootDir(ctx, path)
+	if err == nil {
+		return root, nil
+	}
+	if hgRoot := gitutil.HgRoot(path); hgRoot != "" {
+		return gitutil.HgMirror(ctx, hgRoot)
+	}
+	return "", err
+}
+
+// withConfig returns the configured values followed by the command line ones.
+func withConfig(configured, values []string) []string {
+	return append(slices.Clone(configured), values...)
+}
+
 // containerFlags holds the common flags for commands that target a container.
 type containerFlags struct {
 	image  *string
@@ -187,7 +383,8 @@ func addContainerFlags(fs *flag.FlagSet, image bool) *containerFlags {
 
 // baseImage returns the resolved base image from --image and --tag flags.
 // --image takes precedence; --tag expands to DefaultBaseImage+":<tag>".
-// Returns empty string when neither is set (caller should use DefaultBaseImage).
+// Falls back to the configured image; returns empty string when nothing is set
+// (caller should use DefaultBaseImage).
 func (cf *containerFlags) baseImage() (string, error) {
 	hasImage := cf.image != nil && *cf.image != ""
 	hasTag := cf.tag != nil && *cf.tag != ""
@@ -200,7 +397,89 @@ func (cf *containerFlags) baseImage() (string, error) {
 	if hasTag {
 		return md.DefaultBaseImage + ":" + *cf.tag, nil
 	}
-	return "", nil
+	return config.Image, nil
+}
+
+// limitFlags holds the resource limit flags of start and run.
+type limitFlags struct {
+	cpus      *int
+	memory    *string
+	pidsLimit *int
+	shmSize   *string
+}
+
+// addLimitFlags registers -cpus, -memory, -pids-limit and -shm-size on fs,
+// defaulting to the [limits] config.
+func addLimitFlags(fs *flag.FlagSet) *limitFlags {
+	return &limitFlags{
+		cpus:      fs.Int("cpus", defaultCPUs(), "Max CPU cores for the container (0=no limit)"),
+		memory:    fs.String("memory", config.Limits.Memory, "Memory limit for the container, e.g. 8g (default: no limit)"),
+		pidsLimit: fs.Int("pids-limit", config.Limits.PidsLimit, "Max number of processes in the container (0=no limit)"),
+		shmSize:   fs.String("shm-size", config.Limits.ShmSize, "Size of /dev/shm, e.g. 1g (default: the runtime's, 64m for docker)"),
+	}
+}
+
+func (lf *limitFlags) limits() md.Limits {
+	return md.Limits{MaxCPUs: *lf.cpus, Memory: *lf.memory, PidsLimit: *lf.pidsLimit, ShmSize: *lf.shmSize}
+}
+
+func (lf *limitFlags) apply(opts *md.StartOpts) {
+	opts.MaxCPUs = *lf.cpus
+	opts.Memory = *lf.memory
+	opts.PidsLimit = *lf.pidsLimit
+	opts.ShmSize = *lf.shmSize
+}
+
+// envFlags are the md env files injected in the container.
+type envFlags struct {
+	files  *stringSlice
+	inject *string
+}
+
+// addEnvFlags registers -env-file and -env-inject on fs, defaulting to the
+// env_files and env_inject config.
+func addEnvFlags(fs *flag.FlagSet) *envFlags {
+	ef := &envFlags{files: &stringSlice{}}
+	fs.Var(ef.files, "env-file", "md env file to inject after the default one (see 'md env'); may be repeated")
+	ef.inject = fs.String("env-inject", cmp.Or(config.EnvInject, md.EnvInjectFile), "How the md env files reach the container: file (~/.env, mode 0600) or engine (docker run --env-file, not visible to SSH sessions)")
+	return ef
+}
+
+// resolve returns the variables of the md env files to add to the
+// container's ~/.env, or, with the engine injection, the run arguments
+// passing them. cleanup removes the temporary file of the latter once the
+// container is created.
+func (ef *envFlags) resolve(ctx context.Context, xdgStateHome string) (env, runArgs []string, cleanup func(), err error) {
+	return resolveEnvFiles(ctx, xdgStateHome, withConfig(config.EnvFiles, ef.files.values), *ef.inject)
+}
+
+// resolveEnvFiles implements envFlags.resolve for the default env file and
+// files, injected as inject.
+func resolveEnvFiles(ctx context.Context, xdgStateHome string, files []string, inject string) (env, runArgs []string, cleanup func(), err error) {
+	cleanup = func() {}
+	if err := md.ValidateEnvInject(inject); err != nil {
+		return nil, nil, cleanup, err
+	}
+	vars, err := md.NewEnvStore(xdgStateHome).Resolve(ctx, append([]string{md.DefaultEnvFile}, files...))
+	if err != nil || len(vars) == 0 {
+		return nil, nil, cleanup, err
+	}
+	if inject == md.EnvInjectFile {
+		return md.EnvLines(vars), nil, cleanup, nil
+	}
+	p, err := md.WriteEngineEnvFile(vars)
+	if err != nil {
+		return nil, nil, cleanup, err
+	}
+	return nil, []string{"--env-file", p}, func() { _ = os.Remove(p) }, nil
+}
+
+// defaultCPUs returns the configured CPU limit, md.DefaultMaxCPUs if unset.
+func defaultCPUs() int {
+	if config.Limits.CPUs != nil {
+		return *config.Limits.CPUs
+	}
+	return md.DefaultMaxCPUs()
 }
 
 // findContainerAndRepo searches all containers for one that contains the
@@ -221,7 +500,7 @@ func findContainerAndRepo(ctx context.Context, cf *containerFlags) (*md.Containe
 			return nil, 0, err
 		}
 	}
-	gitRoot, err := gitutil.RootDir(ctx, searchPath)
+	gitRoot, err := repoRoot(ctx, searchPath)
 	if err != nil {
 		return nil, 0, fmt.Errorf("not in a git repository: %w", err)
 	}
@@ -233,7 +512,7 @@ func findContainerAndRepo(ctx context.Context, cf *containerFlags) (*md.Containe
 	// disambiguator so that two containers on different branches of the same
 	// repo are resolved automatically.
 	if branch == "" {
-		branch, _ = gitutil.RunGit(ctx, gitRoot, "branch", "--show-current")
+		branch, _ = gitutil.CurrentBranch(ctx, gitRoot)
 	}
 	containers, err := c.List(ctx)
 	if err != nil {
@@ -264,6 +543,236 @@ func findContainerAndRepo(ctx context.Context, cf *containerFlags) (*md.Containe
 	}
 }
 
+// findRepo is findContainerAndRepo with the repo selected by name, for the
+// commands accepting -repo-name.
+func findRepo(ctx context.Context, cf *containerFlags, repoName string, all bool) (*md.Container, int, error) {
+	if repoName != "" && all {
+		return nil, 0, errors.New("-repo-name and -all are mutually exclusive")
+	}
+	ct, repoIdx, err := findContainerAndRepo(ctx, cf)
+	if err != nil || repoName == "" {
+		return ct, repoIdx, err
+	}
+	repoIdx, err = ct.RepoIndex(repoName)
+	return ct, repoIdx, err
+}
+
+// parseHostPorts parses the --host-port specs, the configured ones first. A
+// later spec for the same port replaces an earlier one, so a flag overrides
+// the config.
+func parseHostPorts(specs []string) ([]md.HostPort, error) {
+	var ports []md.HostPort
+	for _, spec := range specs {
+		p, err := md.ParseHostPort(spec)
+		if err != nil {
+			return nil, err
+		}
+		if i := slices.IndexFunc(ports, func(q md.HostPort) bool { return q.Port == p.Port }); i >= 0 {
+			ports[i] = p
+		} else {
+			ports = append(ports, p)
+		}
+	}
+	return ports, nil
+}
+
+// loadDevContainer loads the devcontainer.json of ct's primary repository for
+// md start --devcontainer and merges its ports and environment in ports and
+// env. Command line flags win: -p over its forwardPorts, -image and -tag over
+// its image.
+func loadDevContainer(fs *flag.FlagSet, ct *md.Container, ports *[]md.PortMapping, env *[]string, quiet bool) (*md.DevContainer, error) {
+	dc, err := md.LoadDevContainer(ct.Repos[0].GitRoot)
+	if err != nil {
+		return nil, err
+	}
+	if dc == nil {
+		return nil, fmt.Errorf("-devcontainer: no %s in %s", strings.Join(md.DevContainerFiles, " or "), ct.Repos[0].GitRoot)
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "image" || f.Name == "tag" {
+			dc.Image = ""
+		}
+	})
+	dcPorts, skipped := dc.Ports()
+	for _, p := range dcPorts {
+		if !slices.ContainsFunc(*ports, func(q md.PortMapping) bool { return q.Host == p.Host }) {
+			*ports = append(*ports, p)
+		}
+	}
+	if len(skipped) > 0 && !quiet {
+		fmt.Fprintf(os.Stderr, "- Skipping devcontainer forwardPorts %s: only local ports can be published\n", strings.Join(skipped, ", "))
+	}
+	*env = append(*env, dc.Env()...)
+	return dc, nil
+}
+
+// bulkFlags holds the flags of commands that can operate on the repos of
+// every running container.
+type bulkFlags struct {
+	allContainers *bool
+	jobs          *int
+}
+
+func addBulkFlags(fs *flag.FlagSet) *bulkFlags {
+	return &bulkFlags{
+		allContainers: fs.Bool("all-containers", false, "Operate on every running container; with -repo (and -branch), only on that repo in the containers mapping it"),
+		jobs:          fs.Int("j", 4, "With -all-containers, maximum number of repos operated on concurrently"),
+	}
+}
+
+// repoOp is an operation on the repo at index i of ct, as run by md push and
+// md pull. It writes its output to w and returns a short result and what
+// changed, when known.
+type repoOp func(ctx context.Context, ct *md.Container, i int, w io.Writer) (string, *md.DiffStat, error)
+
+// bulkResult is the outcome of an operation on one repo of one container.
+type bulkResult struct {
+	Container string       `json:"container"`
+	Repo      string       `json:"repo"`
+	Result    string       `json:"result,omitempty"`
+	Stat      *md.DiffStat `json:"stat,omitempty"`
+	Error     string       `json:"error,omitempty"`
+	// out collects the operation's output, shown when it fails.
+	out bytes.Buffer
+}
+
+// bulkResults are the outcomes of md push or md pull, one per repo.
+type bulkResults []*bulkResult
+
+func (b bulkResults) porcelain() [][]string {
+	lines := make([][]string, len(b))
+	for i, r := range b {
+		if r.Error != "" {
+			lines[i] = []string{r.Container, r.Repo, "error", r.Error}
+		} else {
+			lines[i] = []string{r.Container, r.Repo, "ok", r.Result}
+		}
+	}
+	return lines
+}
+
+// failed returns an error counting the failed operations, if any.
+func (b bulkResults) failed() error {
+	failed := 0
+	for _, r := range b {
+		if r.Error != "" {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d failed", failed, len(b))
+	}
+	return nil
+}
+
+// containerRepoOp runs op on the repos at indices of ct concurrently and
+// prints the results in the machine-readable format selected by out.
+// Operation output goes to stderr.
+func containerRepoOp(ctx context.Context, out *output, ct *md.Container, indices []int, op repoOp) error {
+	results := make(bulkResults, len(indices))
+	var wg sync.WaitGroup
+	for j, i := range indices {
+		results[j] = &bulkResult{Container: ct.Name, Repo: ct.Repos[i].Name()}
+		wg.Go(func() {
+			if result, stat, err := op(ctx, ct, i, os.Stderr); err != nil {
+				results[j].Error = err.Error()
+			} else {
+				results[j].Result, results[j].Stat = result, stat
+			}
+		})
+	}
+	wg.Wait()
+	if err := out.print(results, nil); err != nil {
+		return err
+	}
+	return results.failed()
+}
+
+// bulkRepoOp runs op on the repos of every running container, filtered by
+// the -repo and -branch flags when set. Operations on the same host
+// repository run one at a time since they share its git state; others run
+// concurrently, at most bf.jobs at a time.
+func bulkRepoOp(ctx context.Context, cf *containerFlags, bf *bulkFlags, out *output, op repoOp) error {
+	if *bf.jobs < 1 {
+		return errors.New("-j must be at least 1")
+	}
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	gitRoot := ""
+	if *cf.repo != "" {
+		if gitRoot, err = repoRoot(ctx, *cf.repo); err != nil {
+			return fmt.Errorf("repo %s: %w", *cf.repo, err)
+		}
+	}
+	containers, err := c.List(ctx)
+	if err != nil {
+		return err
+	}
+	type task struct {
+		ct  *md.Container
+		i   int
+		res *bulkResult
+	}
+	results := bulkResults{}
+	byRoot := map[string][]task{}
+	for _, ct := range containers {
+		if ct.State != "running" {
+			continue
+		}
+		for i, r := range ct.Repos {
+			if (gitRoot != "" && r.GitRoot != gitRoot) || (*cf.branch != "" && r.Branch != *cf.branch) {
+				continue
+			}
+			res := &bulkResult{Container: ct.Name, Repo: r.Name()}
+			results = append(results, res)
+			byRoot[r.GitRoot] = append(byRoot[r.GitRoot], task{ct, i, res})
+		}
+	}
+	eg, ctx2 := errgroup.WithContext(ctx)
+	eg.SetLimit(*bf.jobs)
+	for _, tasks := range byRoot {
+		eg.Go(func() error {
+			for _, t := range tasks {
+				result, stat, err := op(ctx2, t.ct, t.i, &t.res.out)
+				if err != nil {
+					t.res.Error = err.Error()
+				} else {
+					t.res.Result, t.res.Stat = result, stat
+				}
+			}
+			// Errors are reported per repo, they don't stop the others.
+			return nil
+		})
+	}
+	_ = eg.Wait()
+	err = out.print(results, func() {
+		if len(results) == 0 {
+			fmt.Println("No running md container matches")
+			return
+		}
+		fmt.Printf("%-40s %-20s %s\n", "Container", "Repo", "Result")
+		fmt.Println(strings.Repeat("-", 80))
+		for _, r := range results {
+			if r.Error == "" {
+				fmt.Printf("%-40s %-20s %s\n", r.Container, r.Repo, r.Result)
+				continue
+			}
+			fmt.Printf("%-40s %-20s error: %s\n", r.Container, r.Repo, r.Error)
+			for line := range strings.SplitSeq(strings.TrimRight(r.out.String(), "\n"), "\n") {
+				if line != "" {
+					fmt.Printf("  | %s\n", line)
+				}
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return results.failed()
+}
+
 // newContainer resolves a Container from flags. extraRepoSpecs holds
 // additional "path[:branch]" strings (e.g. from -extra-repo in cmdStart).
 func newContainer(ctx context.Context, cf *containerFlags, extraRepoSpecs []string) (*md.Container, error) {
@@ -282,11 +791,12 @@ func newContainer(ctx context.Context, cf *containerFlags, extraRepoSpecs []stri
 			return nil, err
 		}
 	}
-	gitRoot, gitErr := gitutil.RootDir(ctx, primaryPath)
+	gitRoot, gitErr := repoRoot(ctx, primaryPath)
 	if gitErr == nil {
 		// Chdir so that relative paths in subsequent flag resolution (e.g.
-		// -extra-repo) resolve from the git root. Safe because the CLI is serial.
-		if err := os.Chdir(gitRoot); err != nil {
+		// -extra-repo) resolve from the git root, or the Mercurial root for a
+		// mirror. Safe because the CLI is serial.
+		if err := os.Chdir(cmp.Or(gitutil.HgSource(ctx, gitRoot), gitRoot)); err != nil {
 			return nil, err
 		}
 		var branch string
@@ -295,6 +805,9 @@ func newContainer(ctx context.Context, cf *containerFlags, extraRepoSpecs []stri
 		} else {
 			branch, err = gitutil.CurrentBranch(ctx, gitRoot)
 			if err != nil {
+				if gitutil.IsJJ(gitRoot) {
+					return nil, fmt.Errorf("%s: %w", gitRoot, err)
+				}
 				return nil, fmt.Errorf("detached HEAD in %s: check out a named branch or use -b to specify one", gitRoot)
 			}
 		}
@@ -318,7 +831,7 @@ func resolveRepoSpecs(ctx context.Context, specs []string) ([]md.Repo, error) {
 	repos := make([]md.Repo, 0, len(specs))
 	for _, spec := range specs {
 		path, branch, _ := strings.Cut(spec, ":")
-		gitRoot, err := gitutil.RootDir(ctx, path)
+		gitRoot, err := repoRoot(ctx, path)
 		if err != nil {
 			return nil, fmt.Errorf("extra repo %s: %w", path, err)
 		}
@@ -359,17 +872,37 @@ func resolveGithubToken(c *md.Client, github bool) (string, error) {
 }
 
 func cmdStart(ctx context.Context, args []string) error {
-	fs := flag.NewFlagSet("start", flag.ExitOnError)
+	fs := newFlagSet("start")
 	verbose := addVerboseFlag(fs)
 	display := fs.Bool("display", false, "Enable X11/VNC display")
 	fs.BoolVar(display, "d", false, "Enable X11/VNC display")
-	tailscale := fs.Bool("tailscale", false, "Enable Tailscale networking")
+	displaySize := fs.String("display-size", "", "Virtual display geometry WIDTHxHEIGHT (default: 1920x1080); implies --display")
+	displays := fs.Int("displays", 1, fmt.Sprintf("Number of virtual displays, each with its own VNC port (max %d); implies --display when > 1", md.MaxDisplays))
+	rdp := fs.Bool("rdp", false, "Enable an RDP server (port 3389) in front of the display; implies --display")
+	browser := fs.Bool("browser", false, "Start Chrome with the DevTools protocol (CDP) published for browser automation")
+	creds := fs.String("creds", os.Getenv("MD_CREDENTIALS"), "Comma-separated host credentials to copy read-only into the container ("+wellKnownCredentialList()+"); defaults to $MD_CREDENTIALS")
+	mountSpecs := &stringSlice{}
+	fs.Var(mountSpecs, "mount", "Bind-mount a host path: host:container[:ro|:rw], read-only by default; may be repeated")
+	mountSrc := &sourceMountFlag{}
+	fs.Var(mountSrc, "mount-src", "Bind-mount the host checkout instead of cloning it: rw (the default), ro, or overlay to keep the container's writes off the host")
+	depth := fs.Int("depth", 0, "Clone only the last N commits of the repos into the container; md deepen fetches more")
+	sharedObjects := fs.Bool("shared-objects", false, "Have the repos' clones borrow the host repository's objects, like a git worktree, instead of copying its history: faster to start and shared by the containers of the repo")
+	filter := fs.String("filter", "", "Partial clone filter for the repos cloned into the container, e.g. blob:none to fetch file contents on demand")
+	credsScoped := fs.Bool("creds-scoped", false, "Share scoped or short-lived credentials generated on the host instead of copying the credential files")
+	tailscale := fs.Bool("tailscale", config.Tailscale.Enabled != nil && *config.Tailscale.Enabled, "Enable Tailscale networking")
 	usb := fs.Bool("usb", false, "Pass through USB devices (/dev/bus/usb)")
 	cf := addContainerFlags(fs, true)
 	extraRepos := &stringSlice{}
 	fs.Var(extraRepos, "extra-repo", "Additional git repository path[:branch] to map; may be repeated")
 	fs.Var(extraRepos, "e", "Additional git repository path[:branch] to map; may be repeated")
+	fs.Var(extraRepos, "with-repo", "Alias for -extra-repo")
 	noSSH := fs.Bool("no-ssh", false, "Don't SSH into the container after starting")
+	noSetup := fs.Bool("no-setup", false, "Don't run the setup command or the repos' "+md.SetupScript)
+	noSidecars := fs.Bool("no-sidecars", false, "Don't start the services of "+md.ComposeFile)
+	nested := fs.String("nested", "", "With dirty submodules or nested repositories in the working tree: include copies their files into the container, exclude starts without them")
+	network := fs.String("network", "", "Network to join: bridge (the engine's default), none, host or an existing network's name (default: a private network for the container and its sidecars)")
+	offline := fs.Bool("offline", false, "Block the container's outbound connections, but to its sidecars and DNS")
+	allowHosts := fs.String("allow-hosts", "", "Comma separated host names, IPs or CIDR ranges: the only destinations of the container's outbound connections, e.g. github.com,proxy.golang.org")
 	quiet := fs.Bool("q", false, "Suppress informational messages")
 	labels := &stringSlice{}
 	fs.Var(labels, "label", "Set Docker container label (key=value); can be repeated")
@@ -379,10 +912,25 @@ func cmdStart(ctx context.Context, args []string) error {
 	noCacheSpecs := &stringSlice{}
 	fs.Var(noCacheSpecs, "no-cache", "Exclude a default well-known cache by name; may be repeated")
 	noCaches := fs.Bool("no-caches", false, "Disable all default caches")
+	detectCaches := fs.Bool("detect-caches", config.DetectCaches != nil && *config.DetectCaches, "Include by default only the caches of the tools the repository uses (go.mod, package.json, Cargo.toml, ...)")
 	github := fs.Bool("github", false, "Inject GitHub token into container")
-	cpus := fs.Int("cpus", md.DefaultMaxCPUs(), "Max CPU cores for the container (0=no limit)")
+	lf := addLimitFlags(fs)
+	ef := addEnvFlags(fs)
 	dockerFlags := &shellSplitSlice{}
 	fs.Var(dockerFlags, "docker-flag", "Extra flag passed verbatim to docker/podman run; may be repeated")
+	ttl := fs.Duration("ttl", 0, "Stop the container once idle this long (e.g. 48h); enforced by md gc and by later md start")
+	portSpecs := &stringSlice{}
+	fs.Var(portSpecs, "publish", "Publish a container port on 127.0.0.1: port or host:container; may be repeated")
+	fs.Var(portSpecs, "p", "Publish a container port on 127.0.0.1: port or host:container; may be repeated")
+	hostPortSpecs := &stringSlice{}
+	fs.Var(hostPortSpecs, "host-port", "Make a service listening on the host reachable at "+md.HostGateway+": port, port=ENV to set $ENV to its URL, or ollama; may be repeated")
+	bind := fs.String("bind", "", "Bind the SSH, VNC, RDP, DevTools and published ports to this host IP instead of 127.0.0.1, e.g. 0.0.0.0 to expose them to other machines on purpose")
+	sudo := fs.String("sudo", "", "Sudo policy of the container's user: full, limited to installing packages, or none; baked into the image")
+	devcontainer := fs.Bool("devcontainer", false, "Apply the repo's devcontainer.json: image or Dockerfile, forwardPorts, containerEnv and postCreateCommand")
+	whyRebuild := fs.Bool("why-rebuild", false, "Print whether and why the image would be rebuilt, without building it or starting the container")
+	noPull := fs.Bool("no-pull", false, "Use the local base image even if the registry has a newer one: faster but possibly stale")
+	rebuild := fs.Bool("rebuild", false, "Rebuild the image even if it is up to date")
+	out := addOutputFlags(fs)
 	fs.Usage = func() { printSubcommandUsage(fs) }
 	if err := fs.Parse(args); err != nil {
 		return err
@@ -391,6 +939,23 @@ func cmdStart(ctx context.Context, args []string) error {
 	if err := checkArgs(fs, 0); err != nil {
 		return err
 	}
+	policy, err := networkPolicy(*offline, *allowHosts)
+	if err != nil {
+		return err
+	}
+	if err := out.check(); err != nil {
+		return err
+	}
+	if *ttl < 0 {
+		return errors.New("-ttl must be positive")
+	}
+	if err := md.ValidateDisplays(*displays, *displaySize); err != nil {
+		return err
+	}
+	sudoPolicy, err := md.ParseSudoPolicy(*sudo)
+	if err != nil {
+		return err
+	}
 
 	ct, err := newContainer(ctx, cf, extraRepos.values)
 	if err != nil {
@@ -400,39 +965,128 @@ func cmdStart(ctx context.Context, args []string) error {
 	if err != nil {
 		return err
 	}
-	caches, err := resolveCaches(cacheSpecs.values, noCacheSpecs.values, *noCaches)
+	caches, err := resolveCaches(withConfig(config.Caches, cacheSpecs.values), withConfig(config.NoCaches, noCacheSpecs.values), *noCaches, detectedCaches(ct, *detectCaches))
 	if err != nil {
 		return err
 	}
+	if *whyRebuild {
+		image, reason := ct.WhyRebuild(ctx, &md.WarmupOpts{BaseImage: baseImage, Caches: caches, Sudo: sudoPolicy, NoPull: *noPull, Rebuild: *rebuild})
+		r := &rebuildResult{Image: image, Rebuild: reason}
+		return out.print(r, func() {
+			if reason == nil {
+				fmt.Printf("%s is up to date\n", image)
+			} else {
+				fmt.Printf("%s would be rebuilt because %s\n", image, reason)
+			}
+		})
+	}
 	githubToken, err := resolveGithubToken(ct.Client, *github)
 	if err != nil {
 		return err
 	}
+	credentials, err := resolveCredentials(*creds)
+	if err != nil {
+		return err
+	}
+	mounts := make([]md.BindMount, 0, len(mountSpecs.values))
+	for _, spec := range mountSpecs.values {
+		m, err := md.ParseBindMount(spec)
+		if err != nil {
+			return err
+		}
+		mounts = append(mounts, m)
+	}
+	ports := make([]md.PortMapping, 0, len(portSpecs.values))
+	for _, spec := range portSpecs.values {
+		p, err := md.ParsePortMapping(spec)
+		if err != nil {
+			return err
+		}
+		ports = append(ports, p)
+	}
+	hostPorts, err := parseHostPorts(withConfig(config.HostPorts, hostPortSpecs.values))
+	if err != nil {
+		return err
+	}
 	var extraEnv []string
+	var dc *md.DevContainer
+	if *devcontainer {
+		if dc, err = loadDevContainer(fs, ct, &ports, &extraEnv, *quiet); err != nil {
+			return err
+		}
+	}
 	if githubToken != "" {
 		extraEnv = append(extraEnv, "GITHUB_TOKEN="+githubToken)
 	}
-	opts := md.StartOpts{
-		BaseImage:        baseImage,
-		Display:          *display,
-		Tailscale:        *tailscale,
-		USB:              *usb,
-		TailscaleAuthKey: os.Getenv("TAILSCALE_AUTHKEY"),
-		Caches:           caches,
-		Labels:           labels.values,
-		Quiet:            *quiet,
-		AgentPaths:       slices.Collect(maps.Values(md.HarnessMounts)),
-		ExtraEnv:         extraEnv,
-		MaxCPUs:          *cpus,
-		ExtraRunArgs:     dockerFlags.values,
+	storeEnv, envArgs, cleanupEnv, err := ef.resolve(ctx, ct.XDGStateHome)
+	if err != nil {
+		return err
 	}
-	if err := ct.Launch(ctx, os.Stdout, os.Stderr, &opts); err != nil {
+	defer cleanupEnv()
+	extraEnv = append(extraEnv, storeEnv...)
+	opts := md.StartOpts{
+		BaseImage:         baseImage,
+		DevContainer:      dc,
+		Display:           *display || *displaySize != "" || *displays > 1 || *rdp,
+		DisplaySize:       *displaySize,
+		Displays:          *displays,
+		RDP:               *rdp,
+		Browser:           *browser,
+		Credentials:       credentials,
+		ScopedCredentials: *credsScoped,
+		Mounts:            mounts,
+		MountSource:       mountSrc.mode,
+		CloneDepth:        *depth,
+		CloneFilter:       *filter,
+		SharedObjects:     *sharedObjects,
+		PublishPorts:      ports,
+		HostPorts:         hostPorts,
+		Bind:              *bind,
+		Sudo:              sudoPolicy,
+		Tailscale:         *tailscale,
+		USB:               *usb,
+		TailscaleAuthKey:  os.Getenv("TAILSCALE_AUTHKEY"),
+		Caches:            caches,
+		NoPull:            *noPull,
+		Rebuild:           *rebuild,
+		Labels:            withConfig(config.Labels, labels.values),
+		Quiet:             *quiet,
+		AgentPaths:        config.AgentPaths(),
+		ExtraEnv:          extraEnv,
+		ExtraRunArgs:      slices.Concat(dockerFlags.values, envArgs),
+		TTL:               *ttl,
+		Setup:             config.Setup,
+		NoSetup:           *noSetup,
+		NoSidecars:        *noSidecars,
+		Nested:            *nested,
+		NetworkMode:       *network,
+		NetworkPolicy:     policy,
+	}
+	lf.apply(&opts)
+	reapExpired(ctx, ct.Client, *quiet || out.machine())
+	if err := ct.Launch(ctx, out.progress(), os.Stderr, &opts); err != nil {
 		return err
 	}
-	result, err := ct.Connect(ctx, os.Stdout, os.Stderr, &opts)
+	result, err := ct.Connect(ctx, out.progress(), os.Stderr, &opts)
 	if err != nil {
 		return err
 	}
+	if result.TailscaleAuthPending {
+		// The login URL usually shows up within seconds; md tailscale status
+		// gets it later otherwise.
+		waitCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		if a, err := ct.WaitTailscaleAuth(waitCtx); err == nil {
+			result.TailscaleAuthURL = a.URL
+			result.TailscaleFQDN = a.FQDN
+			result.TailscaleAuthPending = a.FQDN == ""
+		}
+		cancel()
+	}
+	notify(ctx, md.NewEvent(md.EventStart, ct))
+	if out.machine() {
+		// The result is for a script: there is no terminal to open a shell in.
+		return out.print(newStartResult(ct, result), nil)
+	}
 	if !*quiet {
 		printStartSummary(ct, result)
 	}
@@ -447,48 +1101,288 @@ func cmdStart(ctx context.Context, args []string) error {
 	return nil
 }
 
-func printStartSummary(ct *md.Container, r *md.StartResult) {
-	fmt.Println("- Cool facts:")
-	fmt.Println("  > Remote access:")
-	fmt.Printf("  >  SSH: `ssh %s`\n", ct.Name)
-	if ct.VNCPort != 0 {
-		fmt.Printf("  >  VNC: connect to localhost:%d with a VNC client or: `md vnc`\n", ct.VNCPort)
-	} else {
-		fmt.Println("  >  Next time pass --display to have a virtual display")
+// reapExpired stops the containers whose md start --ttl elapsed, so TTLs are
+// enforced without a scheduled md gc.
+func reapExpired(ctx context.Context, c *md.Client, quiet bool) {
+	actions, err := c.GC(ctx, os.Stdout, os.Stderr, &md.GCOpts{})
+	if err != nil {
+		slog.WarnContext(ctx, "md", "msg", "reaping idle containers", "err", err)
+		return
+	}
+	reaped(ctx, c, actions)
+	for _, a := range actions {
+		if a.Err != nil {
+			slog.WarnContext(ctx, "md", "msg", "stopping idle container", "name", a.Name, "err", a.Err)
+		} else if !quiet {
+			fmt.Printf("- Stopped %s, idle for %s\n", a.Name, a.Idle)
+		}
 	}
-	if r.TailscaleFQDN != "" {
-		fmt.Printf("  >  Tailscale FQDN: %s\n", r.TailscaleFQDN)
+}
+
+// notify sends ev to the configured webhooks and commands. Failures are
+// logged, not returned: notifications never fail a command.
+func notify(ctx context.Context, ev *md.Event) {
+	if err := config.Notifier().Notify(ctx, ev); err != nil {
+		slog.WarnContext(ctx, "md", "msg", "sending notification", "event", ev.Event, "err", err)
 	}
-	if r.TailscaleAuthURL != "" {
-		fmt.Printf("  >  Tailscale auth: %s\n", r.TailscaleAuthURL)
+}
+
+// agentFinished sends [md.EventAgentFinished] for command. With summarize,
+// the event describes the changes in the container's primary repository.
+func agentFinished(ctx context.Context, ct *md.Container, command []string, exitCode int, err error, summarize bool) {
+	n := config.Notifier()
+	if !n.Wants(md.EventAgentFinished) {
+		return
+	}
+	ev := md.NewEvent(md.EventAgentFinished, ct)
+	ev.Command = command
+	if err != nil {
+		ev.Error = err.Error()
+	} else {
+		ev.ExitCode = &exitCode
+		if summarize && len(ct.Repos) > 0 {
+			p, _ := newProvider(ctx, os.Getenv("ASK_PROVIDER"), os.Getenv("ASK_MODEL"))
+			ct.Summarize(ctx, ev, 0, p)
+		}
 	}
-	if len(ct.Repos) > 0 {
-		fmt.Printf("  > Host branch '%s' is mapped in the container as 'base'\n", ct.Repos[0].Branch)
-		fmt.Println("  > See changes (in container): `git diff base`")
-		fmt.Println("  > See changes    (on host)  : `md diff`")
+	if err := n.Notify(ctx, ev); err != nil {
+		slog.WarnContext(ctx, "md", "msg", "sending notification", "event", ev.Event, "err", err)
 	}
-	fmt.Println("  > Stop container (on host)  : `md stop`")
-	fmt.Println("  > Purge container (on host) : `md purge`")
 }
 
-func cmdRun(ctx context.Context, args []string) error {
-	fs := flag.NewFlagSet("run", flag.ExitOnError)
-	verbose := addVerboseFlag(fs)
-	cf := addContainerFlags(fs, true)
-	cacheSpecs := &stringSlice{}
-	fs.Var(cacheSpecs, "cache", "Add a cache: well-known name or host:container[:ro]; may be repeated")
-	noCacheSpecs := &stringSlice{}
+// reaped sends [md.EventIdle] for the containers md gc stopped or removed.
+func reaped(ctx context.Context, c *md.Client, actions []md.GCAction) {
+	for _, a := range actions {
+		if a.Err != nil {
+			continue
+		}
+		ev := md.NewEvent(md.EventIdle, &md.Container{Client: c, Name: a.Name, Repos: a.Repos})
+		ev.Action, ev.Idle = a.Action, a.Idle.String()
+		notify(ctx, ev)
+	}
+}
+
+// pulled sends [md.EventPull] for the repo at index i of ct.
+func pulled(ctx context.Context, ct *md.Container, i int) {
+	ev := md.NewEvent(md.EventPull, ct)
+	ev.Repo, ev.Branch = ct.Repos[i].Name(), ct.Repos[i].Branch
+	notify(ctx, ev)
+}
+
+// withStat appends what changed, when known, to the result of md push or md
+// pull.
+func withStat(result string, stat *md.DiffStat) string {
+	if stat == nil {
+		return result
+	}
+	return result + "; " + stat.String()
+}
+
+// purge removes ct, sends [md.EventKill] and prints the result in the format
+// selected by out.
+func purge(ctx context.Context, out *output, ct *md.Container) error {
+	if err := ct.Purge(ctx, out.progress(), os.Stderr); err != nil {
+		return err
+	}
+	notify(ctx, md.NewEvent(md.EventKill, ct))
+	return out.print(&killResult{Container: ct.Name}, nil)
+}
+
+// killResult is md kill's result with -json or -porcelain.
+type killResult struct {
+	Container string `json:"container"`
+}
+
+func (r *killResult) porcelain() [][]string {
+	return [][]string{{"container", r.Container}}
+}
+
+// networkPolicy returns the egress restrictions of --offline and
+// --allow-hosts.
+func networkPolicy(offline bool, allowHosts string) (*md.NetworkPolicy, error) {
+	switch {
+	case offline && allowHosts != "":
+		return nil, errors.New("use either --offline or --allow-hosts")
+	case offline:
+		return &md.NetworkPolicy{}, nil
+	case allowHosts != "":
+		var hosts []string
+		for h := range strings.SplitSeq(allowHosts, ",") {
+			if h = strings.TrimSpace(h); h != "" {
+				hosts = append(hosts, h)
+			}
+		}
+		return &md.NetworkPolicy{AllowHosts: hosts}, nil
+	}
+	return nil, nil
+}
+
+// startResult is md start's result with -json or -porcelain.
+// rebuildResult is md start -why-rebuild's result with -json or -porcelain.
+type rebuildResult struct {
+	Image string `json:"image"`
+	// Rebuild is nil when the image is up to date.
+	Rebuild *md.RebuildReason `json:"rebuild,omitempty"`
+}
+
+func (r *rebuildResult) porcelain() [][]string {
+	if r.Rebuild == nil {
+		return [][]string{{"image", r.Image}, {"rebuild", "no"}}
+	}
+	return [][]string{{"image", r.Image}, {"rebuild", r.Rebuild.Code}, {"detail", r.Rebuild.Detail}}
+}
+
+type startResult struct {
+	Container            string           `json:"container"`
+	Repos                []md.Repo        `json:"repos,omitempty"`
+	VNCPorts             []int32          `json:"vnc_ports,omitempty"`
+	RDPPort              int32            `json:"rdp_port,omitempty"`
+	CDPPort              int32            `json:"cdp_port,omitempty"`
+	BrowserWS            string           `json:"browser_ws,omitempty"`
+	Ports                []md.PortMapping `json:"ports,omitempty"`
+	TailscaleFQDN        string           `json:"tailscale_fqdn,omitempty"`
+	TailscaleAuthURL     string           `json:"tailscale_auth_url,omitempty"`
+	TailscaleAuthPending bool             `json:"tailscale_auth_pending,omitempty"`
+}
+
+func newStartResult(ct *md.Container, r *md.StartResult) *startResult {
+	res := &startResult{
+		Container:            ct.Name,
+		Repos:                ct.Repos,
+		VNCPorts:             r.VNCPorts,
+		RDPPort:              ct.RDPPort,
+		CDPPort:              ct.CDPPort,
+		BrowserWS:            r.BrowserWSURL,
+		Ports:                ct.PublishedPorts,
+		TailscaleFQDN:        r.TailscaleFQDN,
+		TailscaleAuthURL:     r.TailscaleAuthURL,
+		TailscaleAuthPending: r.TailscaleAuthPending,
+	}
+	if len(res.VNCPorts) == 0 && ct.VNCPort != 0 {
+		res.VNCPorts = []int32{ct.VNCPort}
+	}
+	return res
+}
+
+func (r *startResult) porcelain() [][]string {
+	lines := [][]string{{"container", r.Container}}
+	for _, repo := range r.Repos {
+		lines = append(lines, []string{"repo", repo.Name(), repo.Branch})
+	}
+	for i, p := range r.VNCPorts {
+		lines = append(lines, []string{"vnc", strconv.Itoa(i + 1), strconv.Itoa(int(p))})
+	}
+	if r.RDPPort != 0 {
+		lines = append(lines, []string{"rdp", strconv.Itoa(int(r.RDPPort))})
+	}
+	if r.CDPPort != 0 {
+		lines = append(lines, []string{"cdp", strconv.Itoa(int(r.CDPPort))})
+	}
+	if r.BrowserWS != "" {
+		lines = append(lines, []string{"browser_ws", r.BrowserWS})
+	}
+	for _, p := range r.Ports {
+		lines = append(lines, []string{"port", strconv.Itoa(int(p.Container)), strconv.Itoa(int(p.Host))})
+	}
+	if r.TailscaleFQDN != "" {
+		lines = append(lines, []string{"tailscale_fqdn", r.TailscaleFQDN})
+	}
+	if r.TailscaleAuthURL != "" {
+		lines = append(lines, []string{"tailscale_auth_url", r.TailscaleAuthURL})
+	}
+	if r.TailscaleAuthPending {
+		lines = append(lines, []string{"tailscale_auth_pending"})
+	}
+	return lines
+}
+
+func printStartSummary(ct *md.Container, r *md.StartResult) {
+	fmt.Println("- Cool facts:")
+	fmt.Println("  > Remote access:")
+	fmt.Printf("  >  SSH: `ssh %s`\n", ct.Name)
+	if len(r.VNCPorts) > 1 {
+		for i, p := range r.VNCPorts {
+			fmt.Printf("  >  VNC :%d: connect to localhost:%d with a VNC client or: `md vnc --display %d`\n", i+1, p, i+1)
+		}
+	} else if ct.VNCPort != 0 {
+		fmt.Printf("  >  VNC: connect to localhost:%d with a VNC client or: `md vnc`\n", ct.VNCPort)
+	} else {
+		fmt.Println("  >  Next time pass --display to have a virtual display")
+	}
+	if ct.RDPPort != 0 {
+		fmt.Printf("  >  RDP: connect to localhost:%d with an RDP client or: `md rdp`\n", ct.RDPPort)
+	}
+	if r.BrowserWSURL != "" {
+		fmt.Printf("  >  CDP: %s\n", r.BrowserWSURL)
+	} else if ct.CDPPort != 0 {
+		fmt.Printf("  >  CDP: http://localhost:%d (browser not ready yet)\n", ct.CDPPort)
+	}
+	for _, p := range ct.PublishedPorts {
+		fmt.Printf("  >  Port %d: http://127.0.0.1:%d\n", p.Container, p.Host)
+	}
+	if ct.Bind != "" {
+		fmt.Printf("  >  Ports are bound to %s\n", ct.Bind)
+	}
+	if ct.Sudo != md.SudoDefault {
+		fmt.Printf("  >  Sudo: %s\n", ct.Sudo)
+	}
+	if p := ct.NetworkPolicy; p != nil {
+		if len(p.AllowHosts) == 0 {
+			fmt.Println("  >  Egress: offline")
+		} else {
+			fmt.Printf("  >  Egress: only to %s\n", strings.Join(p.AllowHosts, ", "))
+		}
+	}
+	if r.TailscaleFQDN != "" {
+		fmt.Printf("  >  Tailscale FQDN: %s\n", r.TailscaleFQDN)
+	}
+	if r.TailscaleAuthURL != "" {
+		fmt.Printf("  >  Tailscale auth: %s\n", r.TailscaleAuthURL)
+	} else if r.TailscaleAuthPending {
+		fmt.Println("  >  Tailscale auth: pending, get the URL with `md tailscale status -wait 1m`")
+	}
+	if len(ct.Repos) > 0 && (ct.MountSource == md.SourceReadWrite || ct.MountSource == md.SourceReadOnly) {
+		fmt.Printf("  > Host checkout is mounted in the container (%s); there is nothing to push or pull\n", ct.MountSource)
+		fmt.Println("  > See changes    (on host)  : `md diff`")
+	} else if len(ct.Repos) > 0 {
+		fmt.Printf("  > Host branch '%s' is mapped in the container as 'base'\n", ct.Repos[0].Branch)
+		fmt.Println("  > See changes (in container): `git diff base`")
+		fmt.Println("  > See changes    (on host)  : `md diff`")
+	}
+	fmt.Println("  > Stop container (on host)  : `md stop`")
+	fmt.Println("  > Purge container (on host) : `md purge`")
+}
+
+func cmdRun(ctx context.Context, args []string) (retErr error) {
+	if len(args) > 0 && slices.Contains(runJobCommands, args[0]) {
+		return cmdRunJob(ctx, args[0], args[1:])
+	}
+	fs := newFlagSet("run")
+	verbose := addVerboseFlag(fs)
+	cf := addContainerFlags(fs, true)
+	cacheSpecs := &stringSlice{}
+	fs.Var(cacheSpecs, "cache", "Add a cache: well-known name or host:container[:ro]; may be repeated")
+	noCacheSpecs := &stringSlice{}
 	fs.Var(noCacheSpecs, "no-cache", "Exclude a default well-known cache by name; may be repeated")
 	noCaches := fs.Bool("no-caches", false, "Disable all default caches")
+	detectCaches := fs.Bool("detect-caches", config.DetectCaches != nil && *config.DetectCaches, "Include by default only the caches of the tools the repository uses (go.mod, package.json, Cargo.toml, ...)")
 	github := fs.Bool("github", false, "Inject GitHub token into container")
-	cpus := fs.Int("cpus", md.DefaultMaxCPUs(), "Max CPU cores for the container (0=no limit)")
+	lf := addLimitFlags(fs)
+	ef := addEnvFlags(fs)
 	dockerFlags := &shellSplitSlice{}
 	fs.Var(dockerFlags, "docker-flag", "Extra flag passed verbatim to docker/podman run; may be repeated")
+	commitResults := &commitResultsFlag{}
+	fs.Var(commitResults, "commit-results", "Keep the command's changes, even when it fails, on a new local branch; named after the container unless a name is given")
+	detach := fs.Bool("detach", false, "Run in the background and print the run's ID, for md run attach, logs and wait")
+	out := addOutputFlags(fs)
 	fs.Usage = func() { printSubcommandUsage(fs) }
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
 	initLogging(*verbose)
+	if err := out.check(); err != nil {
+		return err
+	}
 	extra := fs.Args()
 	if len(extra) == 0 {
 		return errors.New("no command specified")
@@ -501,10 +1395,22 @@ func cmdRun(ctx context.Context, args []string) error {
 	if err != nil {
 		return err
 	}
-	caches, err := resolveCaches(cacheSpecs.values, noCacheSpecs.values, *noCaches)
+	caches, err := resolveCaches(withConfig(config.Caches, cacheSpecs.values), withConfig(config.NoCaches, noCacheSpecs.values), *noCaches, detectedCaches(ct, *detectCaches))
+	if err != nil {
+		return err
+	}
+	// The md process of a detached run finds its ID in the environment.
+	id := os.Getenv(runIDEnv)
+	_ = os.Unsetenv(runIDEnv)
+	if id == "" && *detach {
+		return detachRun(out, ct, extra)
+	}
+	job, err := startRunJob(ct, id, extra)
 	if err != nil {
 		return err
 	}
+	defer func() { job.close(retErr) }()
+	stdout, stderr := job.writers(out)
 	githubToken, err := resolveGithubToken(ct.Client, *github)
 	if err != nil {
 		return err
@@ -513,7 +1419,85 @@ func cmdRun(ctx context.Context, args []string) error {
 	if githubToken != "" {
 		extraEnv = append(extraEnv, "GITHUB_TOKEN="+githubToken)
 	}
-	exitCode, err := ct.Run(ctx, os.Stdout, os.Stderr, baseImage, extra, caches, extraEnv, *cpus, dockerFlags.values)
+	storeEnv, envArgs, cleanupEnv, err := ef.resolve(ctx, ct.XDGStateHome)
+	if err != nil {
+		return err
+	}
+	defer cleanupEnv()
+	extraEnv = append(extraEnv, storeEnv...)
+	var results *md.CommitResults
+	if commitResults.set {
+		results = &md.CommitResults{Branch: commitResults.branch}
+		if results.Provider, err = newProvider(ctx, os.Getenv("ASK_PROVIDER"), os.Getenv("ASK_MODEL")); err != nil {
+			slog.WarnContext(ctx, "md", "msg", "failed to initialize provider", "err", err)
+		}
+	}
+	start := time.Now()
+	res, err := ct.Run(ctx, stdout, stderr, baseImage, extra, caches, extraEnv, lf.limits(), slices.Concat(dockerFlags.values, envArgs), results, job.log.ID())
+	job.finish(ctx, res, err)
+	agentFinished(ctx, ct, extra, res.ExitCode, err, false)
+	if err != nil {
+		return err
+	}
+	r := &runResult{ID: job.log.ID(), Container: res.Name, ExitCode: res.ExitCode, Duration: time.Since(start).Round(time.Millisecond).String(), Branch: res.Branch}
+	if err := out.print(r, func() {
+		if res.Branch != "" {
+			fmt.Printf("Results committed to branch %s\n", res.Branch)
+		} else if results != nil {
+			fmt.Println("No changes to commit")
+		}
+	}); err != nil {
+		return err
+	}
+	if res.ExitCode != 0 {
+		return &exitCodeError{code: res.ExitCode}
+	}
+	return nil
+}
+
+// runResult is md run's result with -json or -porcelain.
+type runResult struct {
+	ID        string `json:"id"`
+	Container string `json:"container"`
+	ExitCode  int    `json:"exit_code"`
+	Duration  string `json:"duration"`
+	Branch    string `json:"branch,omitempty"`
+}
+
+func (r *runResult) porcelain() [][]string {
+	lines := [][]string{
+		{"id", r.ID},
+		{"container", r.Container},
+		{"exit_code", strconv.Itoa(r.ExitCode)},
+		{"duration", r.Duration},
+	}
+	if r.Branch != "" {
+		lines = append(lines, []string{"branch", r.Branch})
+	}
+	return lines
+}
+
+func cmdExec(ctx context.Context, args []string) error {
+	fs := newFlagSet("exec")
+	verbose := addVerboseFlag(fs)
+	cf := addContainerFlags(fs, false)
+	tty := fs.Bool("tty", false, "Allocate a pseudo-terminal (for interactive programs)")
+	fs.BoolVar(tty, "t", false, "Allocate a pseudo-terminal (for interactive programs)")
+	fs.Usage = func() { printSubcommandUsage(fs) }
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	initLogging(*verbose)
+	command := fs.Args()
+	if len(command) == 0 {
+		return errors.New("no command specified")
+	}
+	ct, err := newContainer(ctx, cf, nil)
+	if err != nil {
+		return err
+	}
+	exitCode, err := ct.Exec(ctx, os.Stdin, os.Stdout, os.Stderr, command, *tty)
+	agentFinished(ctx, ct, command, exitCode, err, true)
 	if err != nil {
 		return err
 	}
@@ -529,17 +1513,73 @@ type containerListEntry struct {
 	State     string             `json:"state"`
 	Uptime    string             `json:"uptime"`
 	Display   bool               `json:"display,omitempty"`
+	Displays  int                `json:"displays,omitempty"`
+	RDP       bool               `json:"rdp,omitempty"`
+	Browser   bool               `json:"browser,omitempty"`
+	BrowserWS string             `json:"browser_ws,omitempty"`
+	Creds     []string           `json:"credentials,omitempty"`
+	Mounts    []string           `json:"mounts,omitempty"`
+	Ports     []string           `json:"ports,omitempty"`
 	Tailscale bool               `json:"tailscale,omitempty"`
 	FQDN      string             `json:"fqdn,omitempty"`
 	USB       bool               `json:"usb,omitempty"`
+	LastUsed  time.Time          `json:"last_used"`
+	TTL       string             `json:"ttl,omitempty"`
+	CPUs      int                `json:"cpus,omitempty"`
+	Memory    string             `json:"memory,omitempty"`
+	PidsLimit int                `json:"pids_limit,omitempty"`
+	ShmSize   string             `json:"shm_size,omitempty"`
 	Stats     *md.ContainerStats `json:"stats,omitempty"`
+	// DiskWarnings are set when Stats shows a disk usage threshold crossed.
+	DiskWarnings []md.DiskWarning `json:"disk_warnings,omitempty"`
+}
+
+// newContainerListEntry returns the JSON description of ct. stats may be
+// nil.
+func newContainerListEntry(ctx context.Context, ct *md.Container, stats *md.ContainerStats) containerListEntry {
+	e := containerListEntry{
+		Name:      ct.Name,
+		State:     ct.State,
+		Uptime:    time.Since(ct.CreatedAt).Truncate(time.Second).String(),
+		Display:   ct.Display,
+		Displays:  ct.Displays,
+		RDP:       ct.RDP,
+		Browser:   ct.Browser,
+		Creds:     ct.Credentials,
+		Tailscale: ct.Tailscale,
+		USB:       ct.USB,
+		LastUsed:  ct.LastUsed,
+		CPUs:      ct.MaxCPUs,
+		Memory:    ct.Memory,
+		PidsLimit: ct.PidsLimit,
+		ShmSize:   ct.ShmSize,
+		Stats:     stats,
+	}
+	e.DiskWarnings = statsDiskWarnings(ct, stats)
+	if ct.TTL > 0 {
+		e.TTL = ct.TTL.String()
+	}
+	for _, m := range ct.Mounts {
+		e.Mounts = append(e.Mounts, m.String())
+	}
+	for _, p := range ct.PublishedPorts {
+		e.Ports = append(e.Ports, p.String())
+	}
+	if ct.Browser {
+		e.BrowserWS = ct.BrowserWSURL(ctx)
+	}
+	if ct.Tailscale {
+		e.FQDN = ct.TailscaleFQDN(ctx)
+	}
+	return e
 }
 
 func cmdList(ctx context.Context, args []string) error {
-	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	fs := newFlagSet("list")
 	verbose := addVerboseFlag(fs)
 	jsonOut := fs.Bool("json", false, "Output in JSON format")
-	showStats := fs.Bool("stats", false, "Include resource usage stats (CPU, mem, net, disk) for running containers")
+	showStats := fs.Bool("stats", false, "Include resource usage stats (CPU, mem, net, disk, volumes) for running containers")
+	sortBy := fs.String("sort", "", "Sort by resource usage, highest first: cpu, mem or disk; implies -stats")
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
@@ -547,6 +1587,13 @@ func cmdList(ctx context.Context, args []string) error {
 	if err := checkArgs(fs, 0); err != nil {
 		return err
 	}
+	switch *sortBy {
+	case "":
+	case "cpu", "mem", "disk":
+		*showStats = true
+	default:
+		return fmt.Errorf("invalid -sort %q: use cpu, mem or disk", *sortBy)
+	}
 	c, err := md.New(os.Stdout)
 	if err != nil {
 		return err
@@ -554,6 +1601,10 @@ func cmdList(ctx context.Context, args []string) error {
 	if runtimeOverride != "" {
 		c.Runtime = runtimeOverride
 	}
+	applyKubeContext(c)
+	if c.Kube != nil && *showStats {
+		return errors.New("stats are not available on Kubernetes")
+	}
 	containers, err := c.List(ctx)
 	if err != nil {
 		return err
@@ -571,39 +1622,46 @@ func cmdList(ctx context.Context, args []string) error {
 		if statsErr != nil {
 			slog.WarnContext(ctx, "md", "msg", "fetching container stats", "err", statsErr)
 		}
+		if *sortBy != "" {
+			sortByUsage(containers, allStats, *sortBy)
+		}
 	}
 
 	if *jsonOut {
 		entries := make([]containerListEntry, len(containers))
 		for i, ct := range containers {
-			entries[i] = containerListEntry{
-				Name:      ct.Name,
-				State:     ct.State,
-				Uptime:    time.Since(ct.CreatedAt).Truncate(time.Second).String(),
-				Display:   ct.Display,
-				Tailscale: ct.Tailscale,
-				USB:       ct.USB,
-				Stats:     allStats[ct.Name],
-			}
-			if ct.Tailscale {
-				entries[i].FQDN = ct.TailscaleFQDN(ctx)
-			}
+			entries[i] = newContainerListEntry(ctx, ct, allStats[ct.Name])
 		}
 		enc := json.NewEncoder(os.Stdout)
 		enc.SetIndent("", "  ")
 		return enc.Encode(entries)
 	}
 	if len(containers) == 0 {
-		fmt.Println("No running md containers")
+		fmt.Println("No md containers")
 		return nil
 	}
 	fmt.Printf("%-30s %-10s %12s  %s\n", "Container", "Status", "Uptime", "Features")
 	fmt.Println(strings.Repeat("-", 80))
+	stopped := 0
 	for _, ct := range containers {
 		var features []string
-		if ct.Display {
+		if ct.Displays > 1 {
+			features = append(features, fmt.Sprintf("display:%d", ct.Displays))
+		} else if ct.Display {
 			features = append(features, "display")
 		}
+		if ct.RDP {
+			features = append(features, "rdp")
+		}
+		if ct.Browser {
+			features = append(features, "browser")
+		}
+		if len(ct.Credentials) > 0 {
+			features = append(features, "creds:"+strings.Join(ct.Credentials, "+"))
+		}
+		if len(ct.Mounts) > 0 {
+			features = append(features, fmt.Sprintf("mounts:%d", len(ct.Mounts)))
+		}
 		if ct.Tailscale {
 			if fqdn := ct.TailscaleFQDN(ctx); fqdn != "" {
 				features = append(features, "tailscale:"+fqdn)
@@ -614,29 +1672,90 @@ func cmdList(ctx context.Context, args []string) error {
 		if ct.USB {
 			features = append(features, "usb")
 		}
-		fmt.Printf("%-30s %-10s %12s  %s\n", ct.Name, ct.State, time.Since(ct.CreatedAt).Truncate(time.Second), strings.Join(features, ","))
+		if len(ct.PublishedPorts) > 0 {
+			features = append(features, fmt.Sprintf("ports:%d", len(ct.PublishedPorts)))
+		}
+		if ct.TTL > 0 {
+			features = append(features, "ttl:"+ct.TTL.String())
+		}
+		state, uptime := ct.State, time.Since(ct.CreatedAt).Truncate(time.Second).String()
+		if state == "exited" || state == "created" {
+			state, uptime = "stopped", "-"
+			stopped++
+		}
+		fmt.Printf("%-30s %-10s %12s  %s\n", ct.Name, state, uptime, strings.Join(features, ","))
 		if s := allStats[ct.Name]; s != nil {
 			if ct.State == "running" {
 				fmt.Printf("  CPU: %.1f%%  Mem: %s/%s (%.1f%%)  PIDs: %d\n",
 					s.CPUPerc,
 					md.FormatBytes(int64(s.MemUsed)), md.FormatBytes(int64(s.MemLimit)),
 					s.MemPerc, s.PIDs)
-				diskStr := "n/a"
-				if s.DiskUsed >= 0 {
-					diskStr = md.FormatBytes(s.DiskUsed)
-				}
 				fmt.Printf("  Net: rx=%s tx=%s  Block: r=%s w=%s  Disk: %s\n",
 					md.FormatBytes(int64(s.NetRx)), md.FormatBytes(int64(s.NetTx)),
 					md.FormatBytes(int64(s.BlockRead)), md.FormatBytes(int64(s.BlockWrite)),
-					diskStr)
+					diskUsage(s))
 			} else if s.DiskUsed >= 0 {
-				fmt.Printf("  Disk: %s\n", md.FormatBytes(s.DiskUsed))
+				fmt.Printf("  Disk: %s\n", diskUsage(s))
+			}
+			for _, w := range statsDiskWarnings(ct, s) {
+				fmt.Printf("  Warning: %s\n", w.Message)
 			}
 		}
 	}
+	if stopped > 0 {
+		fmt.Println("\nStopped containers keep their state; restart one with: md resume <name>")
+	}
 	return nil
 }
 
+// statsDiskWarnings returns the disk usage thresholds ct crossed according
+// to s, which may be nil.
+func statsDiskWarnings(ct *md.Container, s *md.ContainerStats) []md.DiskWarning {
+	if s == nil || s.FS == nil || ct.Client == nil {
+		return nil
+	}
+	t := ct.Config.DiskThresholds()
+	return t.Check(s.FS, s.DiskUsed)
+}
+
+// diskUsage formats the writable layer size and, when the container has
+// volumes, their size.
+func diskUsage(s *md.ContainerStats) string {
+	out := "n/a"
+	if s.DiskUsed >= 0 {
+		out = md.FormatBytes(s.DiskUsed)
+	}
+	switch {
+	case s.VolumesUsed > 0:
+		out += " + volumes " + md.FormatBytes(s.VolumesUsed)
+	case s.VolumesUsed < 0:
+		out += " + volumes n/a"
+	}
+	return out
+}
+
+// sortByUsage sorts containers by the resource named by key, highest first.
+// Containers without stats come last.
+func sortByUsage(containers []*md.Container, stats map[string]*md.ContainerStats, key string) {
+	usage := func(ct *md.Container) float64 {
+		s := stats[ct.Name]
+		if s == nil {
+			return -1
+		}
+		switch key {
+		case "cpu":
+			return s.CPUPerc
+		case "mem":
+			return float64(s.MemUsed)
+		default:
+			return float64(max(s.DiskUsed, 0) + max(s.VolumesUsed, 0))
+		}
+	}
+	slices.SortStableFunc(containers, func(a, b *md.Container) int {
+		return cmp.Compare(usage(b), usage(a))
+	})
+}
+
 func cmdSSH(args []string) error {
 	if err := noArgs("ssh", args); err != nil {
 		return err
@@ -645,7 +1764,7 @@ func cmdSSH(args []string) error {
 }
 
 func cmdStop(ctx context.Context, args []string) error {
-	fs := flag.NewFlagSet("stop", flag.ExitOnError)
+	fs := newFlagSet("stop")
 	verbose := addVerboseFlag(fs)
 	cf := addContainerFlags(fs, false)
 	if err := fs.Parse(args); err != nil {
@@ -655,33 +1774,68 @@ func cmdStop(ctx context.Context, args []string) error {
 	if err := checkArgs(fs, 1); err != nil {
 		return err
 	}
-	if name := fs.Arg(0); name != "" {
-		c, err := newClient()
-		if err != nil {
-			return err
-		}
-		containers, err := c.List(ctx)
-		if err != nil {
-			return err
-		}
-		for _, ct := range containers {
-			if ct.Name == name {
-				return ct.Stop(ctx)
-			}
-		}
-		return fmt.Errorf("no container named %s", name)
+	ct, err := findContainerByArg(ctx, cf, fs.Arg(0))
+	if err != nil {
+		return err
 	}
-	ct, _, err := findContainerAndRepo(ctx, cf)
+	if err := ct.Stop(ctx); err != nil {
+		return err
+	}
+	fmt.Printf("Stopped %s; resume it with: md resume %s\n", ct.Name, ct.Name)
+	return nil
+}
+
+func cmdResume(ctx context.Context, args []string) error {
+	fs := newFlagSet("resume")
+	verbose := addVerboseFlag(fs)
+	cf := addContainerFlags(fs, false)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	initLogging(*verbose)
+	if err := checkArgs(fs, 1); err != nil {
+		return err
+	}
+	ct, err := findContainerByArg(ctx, cf, fs.Arg(0))
 	if err != nil {
 		return err
 	}
-	return ct.Stop(ctx)
+	fmt.Printf("- Resuming %s ...\n", ct.Name)
+	if err := ct.Resume(ctx, os.Stdout, os.Stderr); err != nil {
+		return err
+	}
+	printStartSummary(ct, &md.StartResult{})
+	return nil
+}
+
+// findContainerByArg returns the container named name, or the current
+// repository's container when name is empty.
+func findContainerByArg(ctx context.Context, cf *containerFlags, name string) (*md.Container, error) {
+	if name == "" {
+		ct, _, err := findContainerAndRepo(ctx, cf)
+		return ct, err
+	}
+	c, err := newClient()
+	if err != nil {
+		return nil, err
+	}
+	containers, err := c.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, ct := range containers {
+		if ct.Name == name {
+			return ct, nil
+		}
+	}
+	return nil, fmt.Errorf("no container named %s", name)
 }
 
 func cmdPurge(ctx context.Context, args []string) error {
-	fs := flag.NewFlagSet("purge", flag.ExitOnError)
+	fs := newFlagSet("purge")
 	verbose := addVerboseFlag(fs)
 	cf := addContainerFlags(fs, false)
+	out := addOutputFlags(fs)
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
@@ -689,6 +1843,9 @@ func cmdPurge(ctx context.Context, args []string) error {
 	if err := checkArgs(fs, 1); err != nil {
 		return err
 	}
+	if err := out.check(); err != nil {
+		return err
+	}
 	// A bare container name may be passed as a positional argument for
 	// repo-less containers, which have no git root to search by.
 	if name := fs.Arg(0); name != "" {
@@ -702,7 +1859,7 @@ func cmdPurge(ctx context.Context, args []string) error {
 		}
 		for _, ct := range containers {
 			if ct.Name == name {
-				return ct.Purge(ctx, os.Stdout, os.Stderr)
+				return purge(ctx, out, ct)
 			}
 		}
 		return fmt.Errorf("no container named %s", name)
@@ -711,14 +1868,16 @@ func cmdPurge(ctx context.Context, args []string) error {
 	if err != nil {
 		return err
 	}
-	return ct.Purge(ctx, os.Stdout, os.Stderr)
+	return purge(ctx, out, ct)
 }
 
-func cmdPush(ctx context.Context, args []string) error {
-	fs := flag.NewFlagSet("push", flag.ExitOnError)
+func cmdGC(ctx context.Context, args []string) error {
+	fs := newFlagSet("gc")
 	verbose := addVerboseFlag(fs)
-	cf := addContainerFlags(fs, false)
-	all := fs.Bool("all", false, "Operate on all repos, not just the current one")
+	idle := fs.Duration("idle", 0, "Reap containers not used for this long (e.g. 48h); default: each container's -ttl")
+	remove := fs.Bool("remove", false, "Remove idle containers, stopped ones included, instead of stopping them")
+	dryRun := fs.Bool("dry-run", false, "Show what would be done")
+	fs.Usage = func() { printSubcommandUsage(fs) }
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
@@ -726,46 +1885,65 @@ func cmdPush(ctx context.Context, args []string) error {
 	if err := checkArgs(fs, 0); err != nil {
 		return err
 	}
-	ct, repoIdx, err := findContainerAndRepo(ctx, cf)
+	if *idle < 0 {
+		return errors.New("-idle must be positive")
+	}
+	c, err := newClient()
 	if err != nil {
 		return err
 	}
-	var mu sync.Mutex
-	printBackup := func(i int, backup string) {
-		repoName := ct.Repos[i].Name()
-		mu.Lock()
-		fmt.Printf("- %s: previous state saved as git branch: %s\n", repoName, backup)
-		mu.Unlock()
+	actions, err := c.GC(ctx, os.Stdout, os.Stderr, &md.GCOpts{Idle: *idle, Remove: *remove, DryRun: *dryRun})
+	if err != nil {
+		return err
 	}
-	if !*all {
-		backup, err := ct.Push(ctx, os.Stdout, os.Stderr, repoIdx)
-		if err != nil {
-			return err
+	if !*dryRun {
+		reaped(ctx, c, actions)
+	}
+	if len(actions) == 0 {
+		fmt.Println("No idle containers")
+	}
+	var errs []error
+	for _, a := range actions {
+		verb := map[string]string{"stop": "Stopped", "remove": "Removed"}[a.Action]
+		if *dryRun {
+			verb = "Would " + a.Action
 		}
-		printBackup(repoIdx, backup)
-		return nil
+		if a.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", a.Name, a.Err))
+			continue
+		}
+		fmt.Printf("%s %s, idle for %s\n", verb, a.Name, a.Idle)
 	}
-	eg, ctx2 := errgroup.WithContext(ctx)
-	for i := range ct.Repos {
-		eg.Go(func() error {
-			backup, err := ct.Push(ctx2, os.Stdout, os.Stderr, i)
-			if err != nil {
-				return err
-			}
-			if backup != "" {
-				printBackup(i, backup)
-			}
-			return nil
-		})
+	// Check the containers left running, so md gc run from cron warns
+	// before an agent runs out of disk.
+	alerts, err := c.CheckDisk(ctx, *dryRun)
+	if err != nil {
+		return errors.Join(append(errs, err)...)
 	}
-	return eg.Wait()
+	for _, a := range alerts {
+		for _, w := range a.Warnings {
+			fmt.Printf("Warning: %s: %s\n", a.Container.Name, w.Message)
+		}
+		if a.New && !*dryRun {
+			ev := md.NewEvent(md.EventDisk, a.Container)
+			ev.DiskWarnings = a.Warnings
+			notify(ctx, ev)
+		}
+	}
+	return errors.Join(errs...)
 }
 
-func cmdPull(ctx context.Context, args []string) error {
-	fs := flag.NewFlagSet("pull", flag.ExitOnError)
+func cmdBench(ctx context.Context, args []string) error {
+	fs := newFlagSet("bench")
 	verbose := addVerboseFlag(fs)
-	cf := addContainerFlags(fs, false)
-	all := fs.Bool("all", false, "Operate on all repos, not just the current one")
+	image := fs.String("image", "", "Full base Docker image (default: "+md.DefaultBaseImage+":latest)")
+	runs := fs.Int("n", 3, "Number of runs; the median of each flow is reported")
+	files := fs.Int("files", 1000, "Number of files in the synthetic repository")
+	baselinePath := fs.String("baseline", "", "Baseline file (default: $XDG_STATE_HOME/md/bench.json)")
+	save := fs.Bool("save", false, "Record the results as the new baseline")
+	budget := fs.Float64("budget", 20, "Fail when a flow is more than this percentage slower than its baseline")
+	jsonOut := fs.Bool("json", false, "Output in JSON format")
+	fs.Usage = func() { printSubcommandUsage(fs) }
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
@@ -773,114 +1951,1838 @@ func cmdPull(ctx context.Context, args []string) error {
 	if err := checkArgs(fs, 0); err != nil {
 		return err
 	}
-	ct, repoIdx, err := findContainerAndRepo(ctx, cf)
+	c, err := newClient()
 	if err != nil {
 		return err
 	}
-	p, err := newProvider(ctx, os.Getenv("ASK_PROVIDER"), os.Getenv("ASK_MODEL"))
+	if *baselinePath == "" {
+		*baselinePath = filepath.Join(c.XDGStateHome, "md", "bench.json")
+	}
+	baseline, err := md.LoadBenchBaseline(*baselinePath)
 	if err != nil {
-		slog.WarnContext(ctx, "md", "msg", "failed to initialize provider", "err", err)
+		return err
 	}
-	if !*all {
-		return ct.Pull(ctx, os.Stdout, os.Stderr, repoIdx, p)
+	progress := io.Writer(os.Stdout)
+	if *jsonOut {
+		progress = os.Stderr
 	}
-	eg, ctx2 := errgroup.WithContext(ctx)
-	for i := range ct.Repos {
-		eg.Go(func() error {
-			return ct.Pull(ctx2, os.Stdout, os.Stderr, i, p)
+	opts := &md.BenchOpts{Files: *files, Runs: *runs, BaseImage: *image}
+	if opts.BaseImage == "" {
+		opts.BaseImage = config.Image
+	}
+	results, err := c.Bench(ctx, progress, os.Stderr, opts)
+	if err != nil {
+		return err
+	}
+	comparison := md.CompareBench(results, baseline, *budget)
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(comparison); err != nil {
+			return err
+		}
+	} else {
+		fmt.Printf("%-12s %10s %10s %8s\n", "Flow", "Median", "Baseline", "Change")
+		for _, r := range comparison {
+			base, change := "-", "-"
+			if r.Baseline != 0 {
+				base = r.Baseline.Round(time.Millisecond).String()
+				change = fmt.Sprintf("%+.0f%%", r.Change)
+				if r.OverBudget {
+					change += " !"
+				}
+			}
+			fmt.Printf("%-12s %10s %10s %8s\n", r.Name, r.Median.Round(time.Millisecond), base, change)
+		}
+	}
+	if *save {
+		if err := md.SaveBenchBaseline(*baselinePath, results); err != nil {
+			return err
+		}
+		if !*jsonOut {
+			fmt.Printf("Saved baseline to %s\n", *baselinePath)
+		}
+		return nil
+	}
+	if slices.ContainsFunc(comparison, func(r md.BenchComparison) bool { return r.OverBudget }) {
+		_, _ = fmt.Fprintf(os.Stderr, "md bench: over the %.0f%% budget\n", *budget)
+		return &exitCodeError{code: 1}
+	}
+	return nil
+}
+
+func cmdDebug(ctx context.Context, args []string) error {
+	const usage = "usage: md debug bundle [-o file]"
+	if len(args) == 0 || args[0] != "bundle" {
+		return errors.New(usage)
+	}
+	fs := newFlagSet("debug bundle")
+	verbose := addVerboseFlag(fs)
+	out := fs.String("o", "", "Output file (default: md-debug-<time>.tar.gz in the current directory)")
+	lines := fs.Int("lines", 1000, "Trailing lines of each container's output to include")
+	fs.Usage = func() { printSubcommandUsage(fs) }
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	initLogging(*verbose)
+	if err := checkArgs(fs, 0); err != nil {
+		return err
+	}
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		*out = "md-debug-" + time.Now().Format("20060102-150405") + ".tar.gz"
+	}
+	opts := &md.DebugBundleOpts{Version: version(), LogLines: *lines}
+	if root, err := gitutil.RootDir(ctx, "."); err == nil {
+		opts.GitRoot = root
+	}
+	f, err := os.OpenFile(*out, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := c.DebugBundle(ctx, f, opts); err != nil {
+		_ = f.Close()
+		_ = os.Remove(*out)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %s; review it before attaching it to a bug report\n", *out)
+	return nil
+}
+
+func cmdDoctor(ctx context.Context, args []string) error {
+	fs := newFlagSet("doctor")
+	verbose := addVerboseFlag(fs)
+	jsonOut := fs.Bool("json", false, "Output in JSON format")
+	offline := fs.Bool("offline", false, "Skip the checks needing network access")
+	fs.Usage = func() { printSubcommandUsage(fs) }
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	initLogging(*verbose)
+	if err := checkArgs(fs, 0); err != nil {
+		return err
+	}
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	opts := &md.DoctorOpts{Offline: *offline || noNetwork}
+	if root, err := gitutil.RootDir(ctx, "."); err == nil {
+		opts.GitRoot = root
+	}
+	return printChecks(c.Doctor(ctx, opts), *jsonOut)
+}
+
+// printChecks prints the checks of md doctor and md verify, as JSON with
+// jsonOut. It returns an exit code of 1 when any check failed.
+func printChecks(checks []md.Check, jsonOut bool) error {
+	if jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(checks); err != nil {
+			return err
+		}
+	} else {
+		for _, chk := range checks {
+			line := fmt.Sprintf("%-4s  %s", chk.Status, chk.Name)
+			if chk.Detail != "" {
+				line += ": " + chk.Detail
+			}
+			fmt.Println(line)
+			if chk.Fix != "" {
+				fmt.Printf("      fix: %s\n", chk.Fix)
+			}
+		}
+	}
+	if slices.ContainsFunc(checks, func(chk md.Check) bool { return chk.Status == md.CheckFail }) {
+		return &exitCodeError{code: 1}
+	}
+	return nil
+}
+
+func cmdVerify(ctx context.Context, args []string) error {
+	fs := newFlagSet("verify")
+	verbose := addVerboseFlag(fs)
+	cf := addContainerFlags(fs, false)
+	jsonOut := fs.Bool("json", false, "Output in JSON format")
+	fs.Usage = func() { printSubcommandUsage(fs) }
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	initLogging(*verbose)
+	if err := checkArgs(fs, 0); err != nil {
+		return err
+	}
+	ct, _, err := findContainerAndRepo(ctx, cf)
+	if err != nil {
+		return err
+	}
+	return printChecks(ct.Verify(ctx), *jsonOut)
+}
+
+func cmdTask(ctx context.Context, args []string) error {
+	const usage = "usage: md task from-issue [flags] <number|issue URL>"
+	if len(args) == 0 || args[0] != "from-issue" {
+		return errors.New(usage)
+	}
+	fs := newFlagSet("task from-issue")
+	verbose := addVerboseFlag(fs)
+	cf := addContainerFlags(fs, true)
+	agent := fs.String("agent", cmp.Or(config.Agent, md.DefaultAgent), "Command run in the container with the task prompt as last argument")
+	noAgent := fs.Bool("no-agent", false, "Only start the container, with the prompt in ~/task.md")
+	branchTemplate := fs.String("branch-template", config.BranchTemplate, "Template of the branch name, e.g. agent/{{.Agent}}/{{.IssueNumber}}-{{.Slug}} (default: issue-<n>-<slug>)")
+	fs.Usage = func() { printSubcommandUsage(fs) }
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	initLogging(*verbose)
+	if fs.NArg() != 1 {
+		return errors.New(usage)
+	}
+	if *cf.branch != "" {
+		return errors.New("-b can't be used: the branch is named after the issue")
+	}
+	owner, repo, number, err := md.ParseIssueRef(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	wd := *cf.repo
+	if wd == "" {
+		if wd, err = os.Getwd(); err != nil {
+			return err
+		}
+	}
+	gitRoot, err := gitutil.RootDir(ctx, wd)
+	if err != nil {
+		return fmt.Errorf("not in a git repository: %w", err)
+	}
+	if owner == "" {
+		if owner, repo, err = md.ParseGitHubRepo(gitutil.RemoteOriginURL(ctx, gitRoot)); err != nil {
+			return err
+		}
+	}
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	ensureGithubToken(c)
+	issue, err := md.FetchIssue(ctx, c.GithubToken, owner, repo, number)
+	if err != nil {
+		return err
+	}
+	branch := issue.Branch()
+	if *branchTemplate != "" {
+		if branch, err = md.RenderBranch(*branchTemplate, &md.BranchVars{Agent: md.AgentName(*agent), IssueNumber: number, Slug: md.Slugify(issue.Title), Repo: filepath.Base(gitRoot)}); err != nil {
+			return err
+		}
+	}
+	// The issue's branch is reused to resume the task, unless another
+	// container works on it.
+	containers, err := c.List(ctx)
+	if err != nil {
+		return err
+	}
+	branch = md.UniqueBranch(branch, func(b string) bool {
+		return slices.ContainsFunc(containers, func(ct *md.Container) bool {
+			return slices.ContainsFunc(ct.Repos, func(r md.Repo) bool { return r.GitRoot == gitRoot && r.Branch == b })
+		})
+	})
+	if _, err := gitutil.RunGit(ctx, gitRoot, "rev-parse", "--verify", "refs/heads/"+branch); err == nil {
+		fmt.Printf("- Reusing branch %s\n", branch)
+	} else {
+		start := "HEAD"
+		if remote, err := gitutil.DefaultRemote(ctx, gitRoot); err == nil {
+			if def, err := gitutil.DefaultBranch(ctx, gitRoot, remote); err == nil {
+				start = remote + "/" + def
+			}
+		}
+		if err := gitutil.CreateBranch(ctx, gitRoot, branch, start); err != nil {
+			return err
+		}
+		fmt.Printf("- Created branch %s from %s\n", branch, start)
+	}
+	ct := c.Container(md.Repo{GitRoot: gitRoot, Branch: branch})
+	opts := workspaceStartOpts()
+	opts.Quiet = false
+	if opts.BaseImage, err = cf.baseImage(); err != nil {
+		return err
+	}
+	if err := ct.Launch(ctx, os.Stdout, os.Stderr, &opts); err != nil {
+		return err
+	}
+	if _, err := ct.Connect(ctx, os.Stdout, os.Stderr, &opts); err != nil {
+		return err
+	}
+	notify(ctx, md.NewEvent(md.EventStart, ct))
+	if _, err := ct.Exec(ctx, strings.NewReader(issue.Prompt()), io.Discard, os.Stderr, []string{"cat > ~/task.md"}, false); err != nil {
+		return fmt.Errorf("writing the task prompt: %w", err)
+	}
+	fmt.Printf("- %s/%s#%d is in ~/task.md in %s\n", owner, repo, number, ct.Name)
+	if *noAgent {
+		fmt.Printf("  > Connect with: ssh %s\n", ct.Name)
+		return nil
+	}
+	fmt.Printf("- Running %s ...\n", *agent)
+	exitCode, err := ct.Exec(ctx, os.Stdin, os.Stdout, os.Stderr, []string{*agent + ` "$(cat ~/task.md)"`}, false)
+	agentFinished(ctx, ct, []string{*agent}, exitCode, err, true)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("- Review with: md diff -b %s; keep with: md pull -b %s\n", branch, branch)
+	if exitCode != 0 {
+		return &exitCodeError{code: exitCode}
+	}
+	return nil
+}
+
+func cmdPush(ctx context.Context, args []string) error {
+	fs := newFlagSet("push")
+	verbose := addVerboseFlag(fs)
+	cf := addContainerFlags(fs, false)
+	all := fs.Bool("all", false, "Operate on all repos, not just the current one")
+	repoName := fs.String("repo-name", "", "Operate on this repo of the container (its directory name in ~/src) instead of the current one")
+	includeUntracked := fs.Bool("include-untracked", false, "Also send the uncommitted changes, untracked files included, left uncommitted in the container")
+	nested := fs.String("nested", "", "With dirty submodules or nested repositories in the working tree: include copies their files into the container, exclude pushes without them")
+	bf := addBulkFlags(fs)
+	out := addOutputFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	initLogging(*verbose)
+	if err := checkArgs(fs, 0); err != nil {
+		return err
+	}
+	if err := out.check(); err != nil {
+		return err
+	}
+	opts := &md.PushOpts{IncludeUntracked: *includeUntracked, Nested: *nested}
+	op := func(ctx context.Context, ct *md.Container, i int, w io.Writer) (string, *md.DiffStat, error) {
+		res, err := ct.Push(ctx, w, w, i, opts)
+		if err != nil {
+			return "", nil, err
+		}
+		return withStat("pushed; previous state in "+res.Backup, res.Stat), res.Stat, nil
+	}
+	if *bf.allContainers {
+		if *all || *repoName != "" {
+			return errors.New("-all-containers can't be combined with -all or -repo-name")
+		}
+		return bulkRepoOp(ctx, cf, bf, out, op)
+	}
+	ct, repoIdx, err := findRepo(ctx, cf, *repoName, *all)
+	if err != nil {
+		return err
+	}
+	if out.machine() {
+		return containerRepoOp(ctx, out, ct, repoIndices(ct, repoIdx, *all), op)
+	}
+	var mu sync.Mutex
+	printBackup := func(i int, backup string) {
+		repoName := ct.Repos[i].Name()
+		mu.Lock()
+		fmt.Printf("- %s: previous state saved as git branch: %s\n", repoName, backup)
+		mu.Unlock()
+	}
+	if !*all {
+		res, err := ct.Push(ctx, os.Stdout, os.Stderr, repoIdx, opts)
+		if err != nil {
+			return err
+		}
+		printBackup(repoIdx, res.Backup)
+		return nil
+	}
+	eg, ctx2 := errgroup.WithContext(ctx)
+	for i := range ct.Repos {
+		eg.Go(func() error {
+			res, err := ct.Push(ctx2, os.Stdout, os.Stderr, i, opts)
+			if err != nil {
+				return err
+			}
+			printBackup(i, res.Backup)
+			return nil
+		})
+	}
+	return eg.Wait()
+}
+
+func cmdPull(ctx context.Context, args []string) error {
+	fs := newFlagSet("pull")
+	verbose := addVerboseFlag(fs)
+	cf := addContainerFlags(fs, false)
+	all := fs.Bool("all", false, "Operate on all repos, not just the current one")
+	repoName := fs.String("repo-name", "", "Operate on this repo of the container (its directory name in ~/src) instead of the current one")
+	strategy := fs.String("strategy", md.PullRebase, "How to integrate the container's branch: "+strings.Join(md.PullStrategies, ", "))
+	resolve := fs.Bool("resolve", false, "On conflicts, write the AI provider's suggested resolutions in the working tree for review")
+	cont := fs.Bool("continue", false, "Finish a pull stopped on conflicts once they are resolved and staged")
+	abort := fs.Bool("abort", false, "Undo a pull stopped on conflicts")
+	bf := addBulkFlags(fs)
+	out := addOutputFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	initLogging(*verbose)
+	if err := checkArgs(fs, 0); err != nil {
+		return err
+	}
+	if err := out.check(); err != nil {
+		return err
+	}
+	if *cont && *abort {
+		return errors.New("-continue and -abort are mutually exclusive")
+	}
+	if (*cont || *abort) && (*all || *bf.allContainers) {
+		return errors.New("-continue and -abort act on a single repo: they can't be combined with -all or -all-containers")
+	}
+	if *abort {
+		ct, repoIdx, err := findRepo(ctx, cf, *repoName, false)
+		if err != nil {
+			return err
+		}
+		return ct.PullAbort(ctx, os.Stdout, os.Stderr, repoIdx)
+	}
+	p, err := newProvider(ctx, os.Getenv("ASK_PROVIDER"), os.Getenv("ASK_MODEL"))
+	if err != nil {
+		slog.WarnContext(ctx, "md", "msg", "failed to initialize provider", "err", err)
+	}
+	opts := &md.PullOpts{Provider: p, Strategy: *strategy, Resolve: *resolve}
+	if *cont {
+		// The pull in progress has its own strategy.
+		opts.Strategy = ""
+		ct, repoIdx, err := findRepo(ctx, cf, *repoName, false)
+		if err != nil {
+			return err
+		}
+		if _, err := ct.PullContinue(ctx, os.Stdout, os.Stderr, repoIdx, opts); err != nil {
+			return err
+		}
+		pulled(ctx, ct, repoIdx)
+		return nil
+	}
+	op := func(ctx context.Context, ct *md.Container, i int, w io.Writer) (string, *md.DiffStat, error) {
+		res, err := ct.Pull(ctx, w, w, i, opts)
+		if err != nil {
+			return "", nil, err
+		}
+		pulled(ctx, ct, i)
+		return withStat("pulled", res.Stat), res.Stat, nil
+	}
+	if *bf.allContainers {
+		if *all || *repoName != "" {
+			return errors.New("-all-containers can't be combined with -all or -repo-name")
+		}
+		return bulkRepoOp(ctx, cf, bf, out, op)
+	}
+	ct, repoIdx, err := findRepo(ctx, cf, *repoName, *all)
+	if err != nil {
+		return err
+	}
+	if out.machine() {
+		return containerRepoOp(ctx, out, ct, repoIndices(ct, repoIdx, *all), op)
+	}
+	if !*all {
+		if _, err := ct.Pull(ctx, os.Stdout, os.Stderr, repoIdx, opts); err != nil {
+			return err
+		}
+		pulled(ctx, ct, repoIdx)
+		return nil
+	}
+	eg, ctx2 := errgroup.WithContext(ctx)
+	for i := range ct.Repos {
+		eg.Go(func() error {
+			if _, err := ct.Pull(ctx2, os.Stdout, os.Stderr, i, opts); err != nil {
+				return err
+			}
+			pulled(ctx2, ct, i)
+			return nil
 		})
 	}
 	return eg.Wait()
 }
 
-func cmdDiff(ctx context.Context, args []string) error {
-	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+func cmdFetch(ctx context.Context, args []string) error {
+	fs := newFlagSet("fetch")
+	verbose := addVerboseFlag(fs)
+	cf := addContainerFlags(fs, false)
+	noCommit := fs.Bool("no-commit", false, "Don't commit the container's uncommitted changes: only fetch its commits")
+	repoName := fs.String("repo-name", "", "Operate on this repo of the container (its directory name in ~/src) instead of the current one")
+	bf := &bulkFlags{
+		allContainers: fs.Bool("all", false, "Fetch from every running md container of this repo, whatever its branch"),
+		jobs:          fs.Int("j", 4, "With -all, maximum number of containers fetched from concurrently"),
+	}
+	out := addOutputFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	initLogging(*verbose)
+	if err := checkArgs(fs, 0); err != nil {
+		return err
+	}
+	if err := out.check(); err != nil {
+		return err
+	}
+	opts := &md.FetchOpts{NoCommit: *noCommit}
+	if !*noCommit {
+		p, err := newProvider(ctx, os.Getenv("ASK_PROVIDER"), os.Getenv("ASK_MODEL"))
+		if err != nil {
+			slog.WarnContext(ctx, "md", "msg", "failed to initialize provider", "err", err)
+		}
+		opts.Provider = p
+	}
+	op := func(ctx context.Context, ct *md.Container, i int, w io.Writer) (string, *md.DiffStat, error) {
+		res, err := ct.Fetch(ctx, w, w, i, opts)
+		if err != nil {
+			return "", nil, err
+		}
+		return fetchSummary(res, ct.Repos[i].Branch), nil, nil
+	}
+	if *bf.allContainers {
+		if *repoName != "" {
+			return errors.New("-all can't be combined with -repo-name")
+		}
+		if *cf.repo == "" {
+			*cf.repo = "."
+		}
+		return bulkRepoOp(ctx, cf, bf, out, op)
+	}
+	ct, repoIdx, err := findRepo(ctx, cf, *repoName, false)
+	if err != nil {
+		return err
+	}
+	if out.machine() {
+		return containerRepoOp(ctx, out, ct, []int{repoIdx}, op)
+	}
+	res, err := ct.Fetch(ctx, os.Stdout, os.Stderr, repoIdx, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("- Fetched %s\n", fetchSummary(res, ct.Repos[repoIdx].Branch))
+	return nil
+}
+
+func cmdSync(ctx context.Context, args []string) error {
+	fs := newFlagSet("sync")
+	verbose := addVerboseFlag(fs)
+	cf := addContainerFlags(fs, false)
+	repoName := fs.String("repo-name", "", "Operate on this repo of the container (its directory name in ~/src) instead of the current one")
+	watch := fs.Bool("watch", false, "Keep syncing the changes until interrupted")
+	interval := fs.Duration("interval", md.DefaultSyncInterval, "With -watch, how often to scan the working tree")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	initLogging(*verbose)
+	if err := checkArgs(fs, 0); err != nil {
+		return err
+	}
+	ct, repoIdx, err := findRepo(ctx, cf, *repoName, false)
+	if err != nil {
+		return err
+	}
+	if *watch {
+		fmt.Fprintf(os.Stderr, "Syncing %s into %s; press Ctrl-C to stop\n", ct.Repos[repoIdx].Name(), ct.Name)
+	}
+	return ct.SyncFiles(ctx, os.Stdout, os.Stderr, repoIdx, &md.SyncFilesOpts{Watch: *watch, Interval: *interval})
+}
+
+// fetchSummary describes where md fetch put the container's branch
+// compared to the host's branch.
+func fetchSummary(res *md.FetchResult, branch string) string {
+	return fmt.Sprintf("%s: %d ahead, %d behind %s", res.Ref, res.Ahead, res.Behind, branch)
+}
+
+func cmdDeepen(ctx context.Context, args []string) error {
+	fs := newFlagSet("deepen")
+	verbose := addVerboseFlag(fs)
+	cf := addContainerFlags(fs, false)
+	all := fs.Bool("all", false, "Operate on all repos, not just the current one")
+	repoName := fs.String("repo-name", "", "Operate on this repo of the container (its directory name in ~/src) instead of the current one")
+	depth := fs.Int("depth", 0, "Number of commits of history to add; 0 fetches all of it and the file contents a partial clone lacks")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	initLogging(*verbose)
+	if err := checkArgs(fs, 0); err != nil {
+		return err
+	}
+	ct, repoIdx, err := findRepo(ctx, cf, *repoName, *all)
+	if err != nil {
+		return err
+	}
+	for _, i := range repoIndices(ct, repoIdx, *all) {
+		if err := ct.Deepen(ctx, os.Stdout, os.Stderr, i, *depth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func cmdDiff(ctx context.Context, args []string) error {
+	fs := newFlagSet("diff")
+	verbose := addVerboseFlag(fs)
+	cf := addContainerFlags(fs, false)
+	all := fs.Bool("all", false, "Operate on all repos, not just the current one")
+	repoName := fs.String("repo-name", "", "Operate on this repo of the container (its directory name in ~/src) instead of the current one")
+	since := fs.String("since", "", "Diff against the container's state at a past point instead of base: push#N (before the Nth push, push#-1 the latest), a duration like \"2h ago\" or a time like \"2006-01-02 15:04\"")
+	base := fs.String("base", "", "Diff against this ref of the container's repository instead of base, e.g. HEAD~3")
+	from := fs.String("from", "", "Diff from the working tree of this other container of the same repository, by name or branch")
+	stat := fs.Bool("stat", false, "Show a diffstat; with -json or -porcelain, the added and deleted lines per file, the default")
+	nameStatus := fs.Bool("name-status", false, "Show the names and status of the changed files; with -json or -porcelain, add the status to each file")
+	web := fs.Bool("web", false, "Serve the diff as a side-by-side HTML page on a local port and open it in the browser")
+	out := addOutputFlags(fs)
+	// Separate md-own flags from git passthrough args.
+	// Flags defined on fs go to mdArgs; everything else (e.g. --stat,
+	// --name-only) is forwarded to git diff. "--" explicitly ends md flag
+	// parsing; everything after goes to git.
+	var mdArgs, gitArgs []string
+	for i := 0; i < len(args); i++ {
+		if a := args[i]; a != "--" && strings.HasPrefix(a, "-") {
+			name := strings.TrimLeft(a, "-")
+			// Handle -flag=value form.
+			if eq := strings.IndexByte(name, '='); eq >= 0 {
+				name = name[:eq]
+			}
+			if f := fs.Lookup(name); f != nil {
+				mdArgs = append(mdArgs, a)
+				// Consume the next arg as value for non-bool flags without inline =.
+				type isBool interface{ IsBoolFlag() bool }
+				if _, isBoolFlag := f.Value.(isBool); !isBoolFlag && !strings.Contains(a, "=") {
+					i++
+					if i < len(args) {
+						mdArgs = append(mdArgs, args[i])
+					}
+				}
+				continue
+			}
+		}
+		gitArgs = append(gitArgs, args[i:]...)
+		break
+	}
+	if err := fs.Parse(mdArgs); err != nil {
+		return err
+	}
+	initLogging(*verbose)
+	if err := out.check(); err != nil {
+		return err
+	}
+	if *from != "" && *all {
+		return errors.New("-from and -all are mutually exclusive")
+	}
+	if *web && (out.machine() || *stat || *nameStatus) {
+		return errors.New("-web can't be combined with -json, -porcelain, -stat or -name-status")
+	}
+	ct, repoIdx, err := findRepo(ctx, cf, *repoName, *all)
+	if err != nil {
+		return err
+	}
+	opts := &md.DiffOpts{Since: *since, Base: *base}
+	if *from != "" {
+		if opts.From, err = findDiffFrom(ctx, ct, repoIdx, *from); err != nil {
+			return err
+		}
+	}
+	indices := repoIndices(ct, repoIdx, *all)
+	if *web {
+		return serveDiffWeb(ctx, ct, indices, opts, gitArgs)
+	}
+	if out.machine() {
+		// The output is parsed: drop the formats git would print instead.
+		gitArgs = slices.DeleteFunc(slices.Clone(gitArgs), func(a string) bool {
+			return slices.Contains([]string{"--stat", "--numstat", "--shortstat", "--name-only", "--name-status", "--raw"}, a)
+		})
+		gitArgs = append(gitArgs, "--numstat")
+		if *nameStatus {
+			// Renames are reported as a deletion and an addition so both
+			// formats have the same paths.
+			gitArgs = append(gitArgs, "--raw", "--no-renames")
+		}
+		stats := diffStats{}
+		for _, i := range indices {
+			var buf bytes.Buffer
+			if err := ct.DiffWith(ctx, &buf, os.Stderr, i, opts, gitArgs); err != nil {
+				return err
+			}
+			stats = append(stats, parseNumstat(ct.Repos[i].Name(), buf.String())...)
+		}
+		return out.print(stats, nil)
+	}
+	if *stat {
+		gitArgs = append([]string{"--stat"}, gitArgs...)
+	}
+	if *nameStatus {
+		gitArgs = append([]string{"--name-status"}, gitArgs...)
+	}
+	for _, i := range indices {
+		if *all && len(ct.Repos) > 1 {
+			fmt.Printf("=== %s ===\n", filepath.Base(ct.Repos[i].GitRoot))
+		}
+		if err := ct.DiffWith(ctx, os.Stdout, os.Stderr, i, opts, gitArgs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findDiffFrom returns the container md diff -from names, by name or by the
+// branch of Repos[repoIdx] of ct's repository it works on.
+func findDiffFrom(ctx context.Context, ct *md.Container, repoIdx int, name string) (*md.Container, error) {
+	containers, err := ct.Client.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	gitRoot := ct.Repos[repoIdx].GitRoot
+	for _, other := range containers {
+		if other.Name == name {
+			return other, nil
+		}
+	}
+	for _, other := range containers {
+		if other.Name != ct.Name && slices.ContainsFunc(other.Repos, func(r md.Repo) bool { return r.GitRoot == gitRoot && r.Branch == name }) {
+			return other, nil
+		}
+	}
+	return nil, fmt.Errorf("no container named %s or on branch %s of %s", name, name, gitRoot)
+}
+
+// repoIndices returns the indices of the repos of ct to operate on: all of
+// them or only repoIdx.
+func repoIndices(ct *md.Container, repoIdx int, all bool) []int {
+	if !all {
+		return []int{repoIdx}
+	}
+	indices := make([]int, len(ct.Repos))
+	for i := range ct.Repos {
+		indices[i] = i
+	}
+	return indices
+}
+
+// diffStat is the change to one file reported by md diff -json.
+type diffStat struct {
+	Repo    string `json:"repo"`
+	Path    string `json:"path"`
+	Added   int    `json:"added"`
+	Deleted int    `json:"deleted"`
+	Binary  bool   `json:"binary,omitempty"`
+	// Status is git's status letter with md diff -name-status, e.g. M for
+	// modified, A for added, D for deleted.
+	Status string `json:"status,omitempty"`
+}
+
+// diffStats are the files changed in the repos md diff operates on.
+type diffStats []diffStat
+
+func (d diffStats) porcelain() [][]string {
+	lines := make([][]string, len(d))
+	for i, st := range d {
+		added, deleted := strconv.Itoa(st.Added), strconv.Itoa(st.Deleted)
+		if st.Binary {
+			added, deleted = "-", "-"
+		}
+		if st.Status != "" {
+			lines[i] = []string{st.Repo, st.Status, added, deleted, st.Path}
+		} else {
+			lines[i] = []string{st.Repo, added, deleted, st.Path}
+		}
+	}
+	return lines
+}
+
+// parseNumstat parses the output of git diff --numstat for repo. Binary
+// files are reported with "-" counts. With --raw, the status of each file is
+// taken from its raw line.
+func parseNumstat(repo, out string) []diffStat {
+	var stats []diffStat
+	status := map[string]string{}
+	for line := range strings.SplitSeq(out, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.HasPrefix(line, ":") {
+			// :<mode> <mode> <sha> <sha> <status>\t<path>
+			meta, path, ok := strings.Cut(line, "\t")
+			if f := strings.Fields(meta); ok && len(f) == 5 {
+				status[path] = f[4][:1]
+			}
+			continue
+		}
+		added, rest, ok := strings.Cut(line, "\t")
+		if !ok {
+			continue
+		}
+		deleted, path, ok := strings.Cut(rest, "\t")
+		if !ok {
+			continue
+		}
+		st := diffStat{Repo: repo, Path: path, Binary: added == "-", Status: status[path]}
+		st.Added, _ = strconv.Atoi(added)
+		st.Deleted, _ = strconv.Atoi(deleted)
+		stats = append(stats, st)
+	}
+	return stats
+}
+
+func cmdGerrit(ctx context.Context, args []string) error {
+	if len(args) == 0 || args[0] != "push" {
+		return errors.New("usage: md gerrit push [flags]")
+	}
+	fs := newFlagSet("gerrit push")
+	verbose := addVerboseFlag(fs)
+	cf := addContainerFlags(fs, false)
+	repoName := fs.String("repo-name", "", "Operate on this repo of the container (its directory name in ~/src) instead of the current one")
+	remote := fs.String("remote", "", "Gerrit remote (default: the repository's default remote)")
+	target := fs.String("target", "", "Branch the change is for (default: the remote's default branch)")
+	topic := fs.String("topic", "", "Gerrit topic")
+	fs.Usage = func() { printSubcommandUsage(fs) }
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	initLogging(*verbose)
+	if err := checkArgs(fs, 0); err != nil {
+		return err
+	}
+	ct, repoIdx, err := findRepo(ctx, cf, *repoName, false)
+	if err != nil {
+		return err
+	}
+	p, err := newProvider(ctx, os.Getenv("ASK_PROVIDER"), os.Getenv("ASK_MODEL"))
+	if err != nil {
+		slog.WarnContext(ctx, "md", "msg", "failed to initialize provider", "err", err)
+	}
+	res, err := ct.GerritPush(ctx, os.Stdout, os.Stderr, repoIdx, &md.GerritOpts{Remote: *remote, Branch: *target, Topic: *topic, Provider: p})
+	if err != nil {
+		return err
+	}
+	fmt.Print(res.Output)
+	fmt.Printf("- Uploaded %s as %s\n", res.Commit[:min(12, len(res.Commit))], res.ChangeID)
+	return nil
+}
+
+func cmdExportReview(ctx context.Context, args []string) error {
+	fs := newFlagSet("export-review")
+	verbose := addVerboseFlag(fs)
+	cf := addContainerFlags(fs, false)
+	repoName := fs.String("repo-name", "", "Operate on this repo of the container (its directory name in ~/src) instead of the current one")
+	out := fs.String("o", "", "Output directory, or a .tar.gz/.tgz file (default: <container>-review)")
+	test := fs.String("test", "", "Shell command run in the repo whose output and exit code are included, e.g. \"go test ./...\"")
+	noSummary := fs.Bool("no-summary", false, "Don't generate an AI summary")
+	fs.Usage = func() { printSubcommandUsage(fs) }
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	initLogging(*verbose)
+	if err := checkArgs(fs, 0); err != nil {
+		return err
+	}
+	ct, repoIdx, err := findRepo(ctx, cf, *repoName, false)
+	if err != nil {
+		return err
+	}
+	opts := md.ReviewOpts{TestCommand: *test}
+	if !*noSummary {
+		if opts.Provider, err = newProvider(ctx, os.Getenv("ASK_PROVIDER"), os.Getenv("ASK_MODEL")); err != nil {
+			slog.WarnContext(ctx, "md", "msg", "skipping the summary", "err", err)
+		}
+	}
+	b, err := ct.ExportReview(ctx, repoIdx, &opts)
+	if err != nil {
+		return err
+	}
+	dst := cmp.Or(*out, ct.Name+"-review")
+	if base, ok := strings.CutSuffix(dst, ".tar.gz"); ok || strings.HasSuffix(dst, ".tgz") {
+		base = strings.TrimSuffix(base, ".tgz")
+		f, err := os.Create(dst)
+		if err != nil {
+			return err
+		}
+		if err := b.WriteTarball(f, filepath.Base(base)); err != nil {
+			_ = f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	} else if err := b.WriteDir(dst); err != nil {
+		return err
+	}
+	fmt.Printf("- Wrote %s: %s\n", dst, strings.Join(append(b.Metadata.Files, md.ReviewMetadataFile), ", "))
+	if c := b.Metadata.TestExitCode; c != nil && *c != 0 {
+		fmt.Printf("  Tests failed with exit code %d\n", *c)
+	}
+	return nil
+}
+
+func cmdReview(ctx context.Context, args []string) error {
+	fs := newFlagSet("review")
+	verbose := addVerboseFlag(fs)
+	cf := addContainerFlags(fs, false)
+	repoName := fs.String("repo-name", "", "Operate on this repo of the container (its directory name in ~/src) instead of the current one")
+	base := fs.String("base", "", "Review the changes since this ref of the container's repository instead of base")
+	pr := fs.String("pr", "", "Post the findings as a review of this GitHub pull request, by number or URL")
+	out := addOutputFlags(fs)
+	fs.Usage = func() { printSubcommandUsage(fs) }
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	initLogging(*verbose)
+	if err := checkArgs(fs, 0); err != nil {
+		return err
+	}
+	if err := out.check(); err != nil {
+		return err
+	}
+	ct, repoIdx, err := findRepo(ctx, cf, *repoName, false)
+	if err != nil {
+		return err
+	}
+	// Resolve the pull request first so a mistake doesn't waste a review.
+	var owner, repo string
+	var number int
+	if *pr != "" {
+		if owner, repo, number, err = md.ParsePullRef(*pr); err != nil {
+			return err
+		}
+		if owner == "" {
+			if owner, repo, err = md.ParseGitHubRepo(gitutil.RemoteOriginURL(ctx, ct.Repos[repoIdx].GitRoot)); err != nil {
+				return err
+			}
+		}
+		if !ensureGithubToken(ct.Client) {
+			return errors.New("posting a review needs a GitHub token: set GITHUB_TOKEN or log in with gh")
+		}
+	}
+	p, err := newProvider(ctx, os.Getenv("ASK_PROVIDER"), os.Getenv("ASK_MODEL"))
+	if err != nil {
+		return err
+	}
+	findings, err := ct.Review(ctx, repoIdx, &md.DiffOpts{Base: *base}, p)
+	if err != nil {
+		return err
+	}
+	if err := out.print(reviewFindings(findings), func() { printFindings(findings) }); err != nil {
+		return err
+	}
+	if *pr == "" {
+		return nil
+	}
+	u, err := md.PostReview(ctx, ct.GithubToken, owner, repo, number, findings)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "- Posted the review: %s\n", u)
+	return nil
+}
+
+func printFindings(findings []gitutil.ReviewFinding) {
+	if len(findings) == 0 {
+		fmt.Println("No findings")
+		return
+	}
+	for _, f := range findings {
+		loc := f.File
+		if f.Line > 0 {
+			loc += ":" + strconv.Itoa(f.Line)
+		}
+		fmt.Printf("%s: %s: %s\n", loc, f.Severity, f.Comment)
+	}
+}
+
+// reviewFindings is md review's result with -json or -porcelain.
+type reviewFindings []gitutil.ReviewFinding
+
+func (r reviewFindings) porcelain() [][]string {
+	lines := make([][]string, len(r))
+	for i, f := range r {
+		lines[i] = []string{f.File, strconv.Itoa(f.Line), f.Severity, f.Comment}
+	}
+	return lines
+}
+
+func cmdPR(ctx context.Context, args []string) error {
+	fs := newFlagSet("pr")
+	verbose := addVerboseFlag(fs)
+	cf := addContainerFlags(fs, false)
+	repoName := fs.String("repo-name", "", "Operate on this repo of the container (its directory name in ~/src) instead of the current one")
+	head := fs.String("head", "", "Push to this branch of origin instead of the container's branch")
+	base := fs.String("base", "", "Branch the pull request targets (default: origin's default branch)")
+	title := fs.String("title", "", "Pull request title, instead of asking the AI provider")
+	body := fs.String("body", "", "Pull request body, with -title")
+	draft := fs.Bool("draft", false, "Open a draft pull request")
+	force := fs.Bool("f", false, "Force-push the branch to origin")
+	out := addOutputFlags(fs)
+	fs.Usage = func() { printSubcommandUsage(fs) }
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	initLogging(*verbose)
+	if err := checkArgs(fs, 0); err != nil {
+		return err
+	}
+	if err := out.check(); err != nil {
+		return err
+	}
+	if *body != "" && *title == "" {
+		return errors.New("-body requires -title")
+	}
+	ct, repoIdx, err := findRepo(ctx, cf, *repoName, false)
+	if err != nil {
+		return err
+	}
+	if !ensureGithubToken(ct.Client) {
+		return errors.New("opening a pull request needs a GitHub token: set GITHUB_TOKEN or log in with gh")
+	}
+	p, err := newProvider(ctx, os.Getenv("ASK_PROVIDER"), os.Getenv("ASK_MODEL"))
+	if err != nil {
+		slog.WarnContext(ctx, "md", "msg", "failed to initialize provider", "err", err)
+	}
+	opts := &md.PROpts{Provider: p, Title: *title, Body: *body, Head: *head, Base: *base, Draft: *draft, Force: *force}
+	w := io.Writer(os.Stdout)
+	if out.machine() {
+		w = os.Stderr
+	}
+	res, err := ct.OpenPR(ctx, w, os.Stderr, repoIdx, opts)
+	if err != nil {
+		return err
+	}
+	return out.print((*prResult)(res), func() { fmt.Println(res.URL) })
+}
+
+// prResult is md pr's result with -json or -porcelain.
+type prResult md.PRResult
+
+func (r *prResult) porcelain() [][]string {
+	return [][]string{{r.URL, r.Head, r.Base, r.Title}}
+}
+
+func cmdChangelog(ctx context.Context, args []string) error {
+	fs := newFlagSet("changelog")
+	verbose := addVerboseFlag(fs)
+	dst := fs.String("o", "", "Write the release notes to this file instead of stdout")
+	fs.Usage = func() { printSubcommandUsage(fs) }
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	initLogging(*verbose)
+	if fs.NArg() > 2 {
+		return errors.New("usage: md changelog [from [to]]")
+	}
+	root, err := repoRoot(ctx, ".")
+	if err != nil {
+		return err
+	}
+	p, err := newProvider(ctx, os.Getenv("ASK_PROVIDER"), os.Getenv("ASK_MODEL"))
+	if err != nil {
+		return err
+	}
+	notes, err := gitutil.GenerateChangelog(ctx, p, root, fs.Arg(0), fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	if *dst == "" {
+		fmt.Println(notes)
+		return nil
+	}
+	return os.WriteFile(*dst, []byte(notes+"\n"), 0o644)
+}
+
+func cmdTimeline(ctx context.Context, args []string) error {
+	fs := newFlagSet("timeline")
+	verbose := addVerboseFlag(fs)
+	cf := addContainerFlags(fs, false)
+	html := fs.Bool("html", false, "Write an HTML page instead of markdown")
+	dst := fs.String("o", "", "Write the report to this file instead of stdout")
+	out := addOutputFlags(fs)
+	fs.Usage = func() { printSubcommandUsage(fs) }
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	initLogging(*verbose)
+	if err := out.check(); err != nil {
+		return err
+	}
+	if *html && out.machine() {
+		return errors.New("-html is mutually exclusive with -json and -porcelain")
+	}
+	if fs.NArg() > 1 {
+		return errors.New("usage: md timeline [container]")
+	}
+	ct, err := findContainerByArg(ctx, cf, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	t, err := ct.Timeline(ctx)
+	if err != nil {
+		return err
+	}
+	if out.machine() {
+		return out.print((*timelineResult)(t), nil)
+	}
+	w := io.Writer(os.Stdout)
+	var f *os.File
+	if *dst != "" {
+		if f, err = os.Create(*dst); err != nil {
+			return err
+		}
+		w = f
+	}
+	if *html {
+		err = t.WriteHTML(w)
+	} else {
+		err = t.WriteMarkdown(w)
+	}
+	if f != nil {
+		if err2 := f.Close(); err == nil {
+			err = err2
+		}
+		if err == nil {
+			fmt.Fprintf(os.Stderr, "- Wrote %s: %d events\n", *dst, len(t.Events))
+		}
+	}
+	return err
+}
+
+// timelineResult is the result of md timeline.
+type timelineResult md.Timeline
+
+func (r *timelineResult) porcelain() [][]string {
+	lines := make([][]string, len(r.Events))
+	for i, e := range r.Events {
+		lines[i] = []string{e.Time.UTC().Format(time.RFC3339), e.Repo, e.Kind, e.Commit, e.Message}
+	}
+	return lines
+}
+
+func cmdFSDiff(ctx context.Context, args []string) error {
+	fs := newFlagSet("fsdiff")
+	verbose := addVerboseFlag(fs)
+	cf := addContainerFlags(fs, false)
+	all := fs.Bool("all", false, "Include repos, caches, logs and temporary files")
+	jsonOut := fs.Bool("json", false, "Output in JSON format")
+	fs.Usage = func() { printSubcommandUsage(fs) }
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	initLogging(*verbose)
+	if err := checkArgs(fs, 0); err != nil {
+		return err
+	}
+	ct, err := newContainer(ctx, cf, nil)
+	if err != nil {
+		return err
+	}
+	changes, err := ct.FSDiff(ctx, *all)
+	if err != nil {
+		return err
+	}
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(changes)
+	}
+	if len(changes) == 0 {
+		fmt.Println("No filesystem changes outside git")
+		return nil
+	}
+	fmt.Printf("%-8s %-8s %6s  %s\n", "Category", "Kind", "Paths", "Item")
+	fmt.Println(strings.Repeat("-", 80))
+	for _, c := range changes {
+		fmt.Printf("%-8s %-8s %6d  %s\n", c.Category, c.Kind, c.Paths, c.Item)
+	}
+	return nil
+}
+
+func cmdSnapshot(ctx context.Context, args []string) error {
+	fs := newFlagSet("snapshot")
+	verbose := addVerboseFlag(fs)
+	cf := addContainerFlags(fs, false)
+	tag := fs.String("tag", "", "Snapshot tag (default: the current time, e.g. 20260102-150405)")
+	list := fs.Bool("list", false, "List the snapshots of all containers")
+	rm := fs.String("rm", "", "Remove the snapshot with this tag or image name")
+	jsonOut := fs.Bool("json", false, "With -list, output in JSON format")
+	fs.Usage = func() { printSubcommandUsage(fs) }
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	initLogging(*verbose)
+	if err := checkArgs(fs, 0); err != nil {
+		return err
+	}
+	if *list {
+		c, err := newClient()
+		if err != nil {
+			return err
+		}
+		snaps, err := c.Snapshots(ctx)
+		if err != nil {
+			return err
+		}
+		if *jsonOut {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(snaps)
+		}
+		if len(snaps) == 0 {
+			fmt.Println("No snapshots")
+			return nil
+		}
+		fmt.Printf("%-60s %-40s %s\n", "Image", "Container", "Created")
+		fmt.Println(strings.Repeat("-", 120))
+		for _, s := range snaps {
+			fmt.Printf("%-60s %-40s %s\n", s.Image, s.Container, s.Created.Local().Format(time.DateTime))
+		}
+		return nil
+	}
+	if *rm != "" {
+		c, image, err := snapshotImage(ctx, cf, *rm)
+		if err != nil {
+			return err
+		}
+		if err := c.RemoveSnapshot(ctx, image); err != nil {
+			return err
+		}
+		fmt.Printf("Removed %s\n", image)
+		return nil
+	}
+	ct, _, err := findContainerAndRepo(ctx, cf)
+	if err != nil {
+		return err
+	}
+	snap, err := ct.Snapshot(ctx, *tag)
+	if err != nil {
+		return err
+	}
+	_, t, _ := strings.Cut(snap.Image, ":")
+	fmt.Printf("Saved %s; restore it with 'md restore -replace %s'\n", snap.Image, t)
+	return nil
+}
+
+func cmdRestore(ctx context.Context, args []string) error {
+	fs := newFlagSet("restore")
+	verbose := addVerboseFlag(fs)
+	cf := addContainerFlags(fs, false)
+	replace := fs.Bool("replace", false, "Remove the container first if it still exists")
+	quiet := fs.Bool("q", false, "Suppress informational messages")
+	noSSH := fs.Bool("no-ssh", false, "Don't SSH into the restored container")
+	github := fs.Bool("github", false, "Inject GitHub token into container")
+	fs.Usage = func() { printSubcommandUsage(fs) }
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	initLogging(*verbose)
+	if err := checkArgs(fs, 1); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: md restore [flags] <tag|image>; list snapshots with 'md snapshot -list'")
+	}
+	c, image, err := snapshotImage(ctx, cf, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	githubToken, err := resolveGithubToken(c, *github)
+	if err != nil {
+		return err
+	}
+	var extraEnv []string
+	if githubToken != "" {
+		extraEnv = append(extraEnv, "GITHUB_TOKEN="+githubToken)
+	}
+	storeEnv, envArgs, cleanupEnv, err := resolveEnvFiles(ctx, c.XDGStateHome, config.EnvFiles, cmp.Or(config.EnvInject, md.EnvInjectFile))
+	if err != nil {
+		return err
+	}
+	defer cleanupEnv()
+	ct, err := c.Restore(ctx, os.Stdout, os.Stderr, image, &md.RestoreOpts{
+		Replace:      *replace,
+		Quiet:        *quiet,
+		AgentPaths:   config.AgentPaths(),
+		ExtraEnv:     append(extraEnv, storeEnv...),
+		ExtraRunArgs: envArgs,
+	})
+	if err != nil {
+		return err
+	}
+	if !*quiet {
+		fmt.Printf("- Restored %s from %s\n", ct.Name, image)
+	}
+	if !*noSSH {
+		sshArgs := ct.SSHCommand(ct.Name)
+		cmd := exec.CommandContext(ctx, sshArgs[0], sshArgs[1:]...)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		return cmd.Run()
+	}
+	return nil
+}
+
+// snapshotImage resolves the argument of md restore and md snapshot -rm: an
+// image name, or a tag of the current repository and branch's container.
+func snapshotImage(ctx context.Context, cf *containerFlags, arg string) (*md.Client, string, error) {
+	if strings.Contains(arg, ":") {
+		c, err := newClient()
+		return c, arg, err
+	}
+	ct, err := newContainer(ctx, cf, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	return ct.Client, md.SnapshotImage(ct.Name, arg), nil
+}
+
+func cmdHandoff(ctx context.Context, args []string) error {
+	fs := newFlagSet("handoff")
+	verbose := addVerboseFlag(fs)
+	cf := addContainerFlags(fs, false)
+	remoteRoots := &stringSlice{}
+	fs.Var(remoteRoots, "remote-root", "Path of the repository on the other machine, in -extra-repo order; may be repeated (default: the same path under its home directory)")
+	remoteMD := fs.String("remote-md", "md", "md command on the other machine")
+	kill := fs.Bool("kill", false, "Remove the local container once it runs on the other machine")
+	quiet := fs.Bool("q", false, "Suppress informational messages")
+	out := addOutputFlags(fs)
+	fs.Usage = func() { printSubcommandUsage(fs) }
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	initLogging(*verbose)
+	if err := checkArgs(fs, 1); err != nil {
+		return err
+	}
+	if err := out.check(); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: md handoff [flags] <[user@]host>")
+	}
+	ct, _, err := findContainerAndRepo(ctx, cf)
+	if err != nil {
+		return err
+	}
+	res, err := ct.Handoff(ctx, out.progress(), os.Stderr, &md.HandoffOpts{
+		Host:        fs.Arg(0),
+		RemoteRoots: remoteRoots.values,
+		RemoteMD:    *remoteMD,
+		Quiet:       *quiet,
+	})
+	if err != nil {
+		return err
+	}
+	if *kill {
+		if err := ct.Purge(ctx, out.progress(), os.Stderr); err != nil {
+			return err
+		}
+	}
+	return out.print(&handoffResult{HandoffResult: res, Killed: *kill}, func() {
+		fmt.Printf("- %s runs on %s; connect with 'ssh %s', then 'ssh %s' there\n", res.Container, res.Host, res.Host, res.Container)
+		if !*kill {
+			fmt.Println("  The local container is left as is: 'md purge' removes it")
+		}
+	})
+}
+
+// handoffResult is md handoff's result with -json or -porcelain.
+type handoffResult struct {
+	*md.HandoffResult
+	Killed bool `json:"killed"`
+}
+
+func (r *handoffResult) porcelain() [][]string {
+	lines := [][]string{
+		{"container", r.Container},
+		{"host", r.Host},
+		{"image", r.Image},
+	}
+	for _, repo := range r.Repos {
+		lines = append(lines, []string{"repo", repo.GitRoot, repo.Branch})
+	}
+	return append(lines, []string{"killed", strconv.FormatBool(r.Killed)})
+}
+
+func cmdStatus(ctx context.Context, args []string) error {
+	fs := newFlagSet("status")
+	verbose := addVerboseFlag(fs)
+	cf := addContainerFlags(fs, false)
+	jsonOut := fs.Bool("json", false, "Output in JSON format")
+	fs.Usage = func() { printSubcommandUsage(fs) }
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	initLogging(*verbose)
+	if err := checkArgs(fs, 0); err != nil {
+		return err
+	}
+	ct, _, err := findContainerAndRepo(ctx, cf)
+	if err != nil {
+		return err
+	}
+	st, err := ct.Status(ctx)
+	if err != nil {
+		return err
+	}
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(st)
+	}
+	printStatus(st)
+	return nil
+}
+
+// printStatus prints st as tables for md status.
+func printStatus(st *md.Status) {
+	fmt.Printf("Container: %s (%s)\n", st.Name, st.State)
+	if st.SSHPort != 0 {
+		fmt.Printf("SSH:       ssh %s (port %d)\n", st.Name, st.SSHPort)
+	}
+	if st.VNCPort != 0 {
+		fmt.Printf("VNC:       localhost:%d\n", st.VNCPort)
+	}
+	if st.TailscaleFQDN != "" {
+		fmt.Printf("Tailscale: %s\n", st.TailscaleFQDN)
+	}
+	for _, h := range st.HostPorts {
+		state := "reachable"
+		if !h.Reachable {
+			state = "unreachable: is it listening on the host's gateway address, not only 127.0.0.1?"
+		}
+		if h.Env != "" {
+			fmt.Printf("Host port: %s ($%s), %s\n", h.Addr, h.Env, state)
+		} else {
+			fmt.Printf("Host port: %s, %s\n", h.Addr, state)
+		}
+	}
+	if st.State != "running" {
+		return
+	}
+	if len(st.Repos) > 0 {
+		// Local compares the host branch with base, Container the container's
+		// HEAD with base: "+ahead -behind".
+		fmt.Println()
+		fmt.Printf("%-20s %-20s %-9s %-9s %s\n", "Repo", "Branch", "Local", "Container", "Uncommitted")
+		fmt.Println(strings.Repeat("-", 80))
+		for _, r := range st.Repos {
+			fmt.Printf("%-20s %-20s %-9s %-9s %d staged, %d unstaged, %d untracked\n", r.Name, r.Branch,
+				fmt.Sprintf("+%d -%d", r.LocalAhead, r.LocalBehind),
+				fmt.Sprintf("+%d -%d", r.ContainerAhead, r.ContainerBehind),
+				r.Staged, r.Unstaged, r.Untracked)
+		}
+	}
+	if d := st.Disk; d != nil {
+		fmt.Printf("\nDisk:      %s used, %s free (%.0f%%)", md.FormatBytes(d.Used), md.FormatBytes(d.Avail), d.UsedPercent())
+		if d.Inodes > 0 {
+			fmt.Printf(", inodes %.0f%%", d.InodesPercent())
+		}
+		if d.Src >= 0 {
+			fmt.Printf(", ~/src %s", md.FormatBytes(d.Src))
+		}
+		fmt.Println()
+	}
+	for _, w := range st.DiskWarnings {
+		fmt.Printf("Warning:   %s\n", w.Message)
+	}
+	fmt.Println()
+	printServices(st.Services, st.Sidecars)
+}
+
+// maxInspectAudit is the number of audit log entries md inspect reports.
+const maxInspectAudit = 20
+
+// inspectResult is what md inspect reports: [md.Inspection] and what only
+// the command line tool knows.
+type inspectResult struct {
+	Version string `json:"version"`
+	*md.Inspection
+	// AuditEvents are the last decisions of md mcp and md serve about the
+	// container.
+	AuditEvents []auditEntry `json:"audit_events,omitempty"`
+}
+
+func cmdInspect(ctx context.Context, args []string) error {
+	fs := newFlagSet("inspect")
+	verbose := addVerboseFlag(fs)
+	cf := addContainerFlags(fs, false)
+	jsonOut := fs.Bool("json", false, "Output in JSON format")
+	fs.Usage = func() { printSubcommandUsage(fs) }
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	initLogging(*verbose)
+	if fs.NArg() > 1 {
+		return errors.New("usage: md inspect [container]")
+	}
+	ct, err := findContainerByArg(ctx, cf, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	in, err := ct.Inspect(ctx)
+	if err != nil {
+		return err
+	}
+	res := &inspectResult{Version: version(), Inspection: in}
+	if res.AuditEvents, err = recentAuditEntries(auditLogPath(ct.Client), ct.Name, maxInspectAudit); err != nil {
+		in.Errors = append(in.Errors, "reading the audit log: "+err.Error())
+	}
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(res)
+	}
+	printInspect(res)
+	return nil
+}
+
+// printInspect prints res for md inspect.
+func printInspect(res *inspectResult) {
+	fmt.Printf("md:        %s\n", res.Version)
+	if res.Status == nil {
+		// printStatus prints it otherwise.
+		fmt.Printf("Container: %s (%s)\n", res.Name, res.State)
+	}
+	fmt.Printf("Image:     %s (%s)\n", res.Image, res.ImageID)
+	for _, port := range slices.Sorted(maps.Keys(res.Ports)) {
+		fmt.Printf("Port:      %s -> %s\n", port, strings.Join(res.Ports[port], ", "))
+	}
+	for _, r := range res.Remotes {
+		url := r.URL
+		if url == "" {
+			url = "(missing)"
+		}
+		fmt.Printf("Remote:    %s: %s\n", r.Repo, url)
+	}
+	d := res.Drift
+	for _, x := range []struct {
+		set bool
+		msg string
+	}{
+		{d.ImageSuperseded, "the image was rebuilt since the container was created"},
+		{d.BaseChanged, "the base image changed since the image was built"},
+		{d.KeysChanged, "the SSH keys changed since the image was built"},
+		{d.SSHConfigPort != 0, fmt.Sprintf("the SSH config has port %d, not the published one", d.SSHConfigPort)},
+		{d.SSHConfigMissing, "the SSH config is missing"},
+		{d.RemoteMissing, "a git remote is missing"},
+	} {
+		if x.set {
+			fmt.Printf("Drift:     %s\n", x.msg)
+		}
+	}
+	for _, e := range res.Errors {
+		fmt.Printf("Error:     %s\n", e)
+	}
+	fmt.Println("\nLabels:")
+	for _, k := range slices.Sorted(maps.Keys(res.Labels)) {
+		fmt.Printf("  %s=%s\n", k, res.Labels[k])
+	}
+	if len(res.ImageLabels) != 0 {
+		fmt.Println("\nImage labels:")
+		for _, k := range slices.Sorted(maps.Keys(res.ImageLabels)) {
+			fmt.Printf("  %s=%s\n", k, res.ImageLabels[k])
+		}
+	}
+	if res.SSHConfig != "" {
+		fmt.Printf("\nSSH config:\n%s", res.SSHConfig)
+	}
+	if len(res.AuditEvents) != 0 {
+		fmt.Println("\nAudit log:")
+		for _, e := range res.AuditEvents {
+			fmt.Printf("  %s %s %s %s (%s) %s\n", e.Time.Format(time.RFC3339), e.Source, e.Operation, e.Decision, e.By, e.Details)
+		}
+	}
+	if res.Status != nil {
+		fmt.Println()
+		printStatus(res.Status)
+	}
+}
+
+// printServices prints the user services and the sidecars as tables.
+func printServices(services []md.ServiceStatus, sidecars []md.Sidecar) {
+	if len(services) == 0 {
+		fmt.Printf("No services (declare them in %s)\n", md.ServicesFile)
+	} else {
+		fmt.Printf("%-20s %-12s %8s %8s %12s\n", "Service", "State", "PID", "Restarts", "Since")
+		fmt.Println(strings.Repeat("-", 60))
+		for _, s := range services {
+			pid, since := "-", "-"
+			if s.PID != 0 {
+				pid = strconv.Itoa(s.PID)
+			}
+			if !s.Since.IsZero() {
+				since = time.Since(s.Since).Truncate(time.Second).String()
+			}
+			state := s.State
+			if state == "exited" || state == "backoff" {
+				state += fmt.Sprintf("(%d)", s.ExitCode)
+			}
+			fmt.Printf("%-20s %-12s %8s %8d %12s\n", s.Name, state, pid, s.Restarts, since)
+		}
+	}
+	if len(sidecars) == 0 {
+		return
+	}
+	fmt.Println()
+	fmt.Printf("%-20s %-12s %-10s %s\n", "Sidecar", "State", "Health", "Status")
+	fmt.Println(strings.Repeat("-", 60))
+	for _, s := range sidecars {
+		fmt.Printf("%-20s %-12s %-10s %s\n", s.Service, s.State, cmp.Or(s.Health, "-"), s.Status)
+	}
+}
+
+// servicesResult is the result of md services.
+type servicesResult struct {
+	Services []md.ServiceStatus `json:"services"`
+	Sidecars []md.Sidecar       `json:"sidecars"`
+}
+
+func (r *servicesResult) porcelain() [][]string {
+	var lines [][]string
+	for _, s := range r.Services {
+		lines = append(lines, []string{"service", s.Name, s.State, strconv.Itoa(s.Restarts)})
+	}
+	for _, s := range r.Sidecars {
+		lines = append(lines, []string{"sidecar", s.Service, s.State, s.Health})
+	}
+	return lines
+}
+
+// tailscaleResult is the result of md tailscale status.
+type tailscaleResult struct {
+	Container string `json:"container"`
+	*md.TailscaleAuth
+}
+
+func (r *tailscaleResult) porcelain() [][]string {
+	lines := [][]string{{"state", r.State}}
+	if r.URL != "" {
+		lines = append(lines, []string{"url", r.URL})
+	}
+	if r.FQDN != "" {
+		lines = append(lines, []string{"fqdn", r.FQDN})
+	}
+	return lines
+}
+
+func cmdTailscale(ctx context.Context, args []string) error {
+	if len(args) == 0 || args[0] != "status" {
+		return errors.New("usage: md tailscale status [-wait <duration>] [container]")
+	}
+	fs := newFlagSet("tailscale status")
+	verbose := addVerboseFlag(fs)
+	cf := addContainerFlags(fs, false)
+	out := addOutputFlags(fs)
+	wait := fs.Duration("wait", 0, "Wait up to this long (e.g. 1m) for the login URL or the login")
+	fs.Usage = func() { printSubcommandUsage(fs) }
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	initLogging(*verbose)
+	if err := out.check(); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		return errors.New("usage: md tailscale status [-wait <duration>] [container]")
+	}
+	ct, err := findContainerByArg(ctx, cf, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	var a *md.TailscaleAuth
+	if *wait > 0 {
+		waitCtx, cancel := context.WithTimeout(ctx, *wait)
+		defer cancel()
+		a, err = ct.WaitTailscaleAuth(waitCtx)
+	} else {
+		a, err = ct.TailscaleStatus(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	r := &tailscaleResult{Container: ct.Name, TailscaleAuth: a}
+	return out.print(r, func() {
+		switch {
+		case a.FQDN != "":
+			fmt.Printf("- %s is on the tailnet as %s\n", ct.Name, a.FQDN)
+		case a.URL != "":
+			fmt.Printf("- Log %s in to Tailscale: %s\n", ct.Name, a.URL)
+		default:
+			fmt.Printf("- %s: Tailscale is %s; the login URL isn't known yet\n", ct.Name, cmp.Or(a.State, "starting"))
+		}
+	})
+}
+
+func cmdServices(ctx context.Context, args []string) error {
+	fs := newFlagSet("services")
+	verbose := addVerboseFlag(fs)
+	cf := addContainerFlags(fs, false)
+	out := addOutputFlags(fs)
+	fs.Usage = func() { printSubcommandUsage(fs) }
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	initLogging(*verbose)
+	if err := out.check(); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		return errors.New("usage: md services [container]")
+	}
+	ct, err := findContainerByArg(ctx, cf, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	r := &servicesResult{}
+	if ct.State == "running" {
+		if r.Services, err = ct.Services(ctx); err != nil {
+			return err
+		}
+	}
+	if r.Sidecars, err = ct.SidecarStatus(ctx); err != nil {
+		return err
+	}
+	return out.print(r, func() { printServices(r.Services, r.Sidecars) })
+}
+
+func cmdLogs(ctx context.Context, args []string) error {
+	fs := newFlagSet("logs")
 	verbose := addVerboseFlag(fs)
 	cf := addContainerFlags(fs, false)
-	all := fs.Bool("all", false, "Operate on all repos, not just the current one")
-	// Separate md-own flags from git passthrough args.
-	// Flags defined on fs go to mdArgs; everything else (e.g. --stat,
-	// --name-only) is forwarded to git diff. "--" explicitly ends md flag
-	// parsing; everything after goes to git.
-	var mdArgs, gitArgs []string
-	for i := 0; i < len(args); i++ {
-		if a := args[i]; a != "--" && strings.HasPrefix(a, "-") {
-			name := strings.TrimLeft(a, "-")
-			// Handle -flag=value form.
-			if eq := strings.IndexByte(name, '='); eq >= 0 {
-				name = name[:eq]
-			}
-			if f := fs.Lookup(name); f != nil {
-				mdArgs = append(mdArgs, a)
-				// Consume the next arg as value for non-bool flags without inline =.
-				type isBool interface{ IsBoolFlag() bool }
-				if _, isBoolFlag := f.Value.(isBool); !isBoolFlag && !strings.Contains(a, "=") {
-					i++
-					if i < len(args) {
-						mdArgs = append(mdArgs, args[i])
-					}
-				}
-				continue
-			}
-		}
-		gitArgs = append(gitArgs, args[i:]...)
-		break
+	service := fs.String("service", "", "Service whose log to show: a service from md status or one of "+strings.Join(slices.Sorted(maps.Keys(md.SystemServiceLogs)), ", ")+" (default: the container's startup output)")
+	follow := fs.Bool("follow", false, "Keep streaming new log lines")
+	fs.BoolVar(follow, "f", false, "Keep streaming new log lines")
+	lines := fs.Int("n", 100, "Number of trailing lines to show; -1 for all")
+	since := fs.String("since", "", "Only show the container output since a duration (e.g. 10m) or timestamp")
+	fs.Usage = func() { printSubcommandUsage(fs) }
+	if err := fs.Parse(args); err != nil {
+		return err
 	}
-	if err := fs.Parse(mdArgs); err != nil {
+	initLogging(*verbose)
+	if err := checkArgs(fs, 0); err != nil {
+		return err
+	}
+	ct, err := newContainer(ctx, cf, nil)
+	if err != nil {
+		return err
+	}
+	err = ct.Logs(ctx, os.Stdout, os.Stderr, &md.LogsOpts{Service: *service, Lines: *lines, Since: *since, Follow: *follow})
+	if *follow && ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+func cmdBake(ctx context.Context, args []string) error {
+	fs := newFlagSet("bake")
+	verbose := addVerboseFlag(fs)
+	cf := addContainerFlags(fs, false)
+	categories := fs.String("category", "apt,npm,go,cargo,python,bin", "Comma-separated categories to bake when no item is named (dotfile and config are opt-in since they may hold secrets)")
+	dryRun := fs.Bool("n", false, "Print the instructions without writing "+md.BakeFile)
+	fs.Usage = func() { printSubcommandUsage(fs) }
+	if err := fs.Parse(args); err != nil {
 		return err
 	}
 	initLogging(*verbose)
-	ct, repoIdx, err := findContainerAndRepo(ctx, cf)
+	ct, err := newContainer(ctx, cf, nil)
 	if err != nil {
 		return err
 	}
-	indices := []int{repoIdx}
-	if *all {
-		indices = make([]int, len(ct.Repos))
-		for i := range ct.Repos {
-			indices[i] = i
-		}
+	changes, err := ct.FSDiff(ctx, false)
+	if err != nil {
+		return err
 	}
-	for _, i := range indices {
-		if *all && len(ct.Repos) > 1 {
-			fmt.Printf("=== %s ===\n", filepath.Base(ct.Repos[i].GitRoot))
+	selected, err := selectBakeChanges(changes, strings.Split(*categories, ","), fs.Args())
+	if err != nil {
+		return err
+	}
+	if len(selected) == 0 {
+		fmt.Println("Nothing to bake")
+		return nil
+	}
+	added, err := ct.Bake(ctx, os.Stdout, os.Stderr, selected, *dryRun)
+	if err != nil {
+		return err
+	}
+	for _, l := range added {
+		fmt.Println(l)
+	}
+	if !*dryRun && len(added) > 0 {
+		fmt.Printf("- Added %d instruction(s) to %s; they apply on the next `md start`\n", len(added), md.BakeFile)
+	}
+	return nil
+}
+
+// selectBakeChanges picks the changes to bake: those whose item is named in
+// items, or when items is empty, every change in one of categories.
+func selectBakeChanges(changes []md.FSChange, categories, items []string) ([]md.FSChange, error) {
+	var out []md.FSChange
+	if len(items) == 0 {
+		for _, c := range changes {
+			if slices.Contains(categories, string(c.Category)) {
+				out = append(out, c)
+			}
 		}
-		if err := ct.Diff(ctx, os.Stdout, os.Stderr, i, gitArgs); err != nil {
-			return err
+		return out, nil
+	}
+	for _, item := range items {
+		i := slices.IndexFunc(changes, func(c md.FSChange) bool { return c.Item == item })
+		if i < 0 {
+			return nil, fmt.Errorf("%q is not a change reported by md fsdiff", item)
 		}
+		out = append(out, changes[i])
 	}
-	return nil
+	return out, nil
 }
 
 func cmdFork(ctx context.Context, args []string) error {
-	fs := flag.NewFlagSet("fork", flag.ExitOnError)
+	return forkContainer(ctx, "fork", args)
+}
+
+func cmdClone(ctx context.Context, args []string) error {
+	return forkContainer(ctx, "clone", args)
+}
+
+// forkContainer implements md fork and md clone, which takes the destination
+// branch as argument.
+func forkContainer(ctx context.Context, name string, args []string) error {
+	fs := newFlagSet(name)
 	verbose := addVerboseFlag(fs)
 	cf := addContainerFlags(fs, false)
 	source := fs.String("source", "", "Name of the source container (default: auto-detect from repo)")
 	fs.StringVar(source, "s", "", "Name of the source container (default: auto-detect from repo)")
 	display := fs.Bool("display", false, "Enable X11/VNC display")
-	tailscale := fs.Bool("tailscale", false, "Enable Tailscale networking")
+	tailscale := fs.Bool("tailscale", config.Tailscale.Enabled != nil && *config.Tailscale.Enabled, "Enable Tailscale networking")
 	usb := fs.Bool("usb", false, "Pass through USB devices (/dev/bus/usb)")
 	quiet := fs.Bool("q", false, "Suppress informational messages")
 	noSSH := fs.Bool("no-ssh", false, "Don't SSH into the forked container after starting")
 	github := fs.Bool("github", false, "Inject GitHub token into container")
-	cpus := fs.Int("cpus", md.DefaultMaxCPUs(), "Max CPU cores for the container (0=no limit)")
+	cpus := fs.Int("cpus", defaultCPUs(), "Max CPU cores for the container (0=no limit)")
 	dockerFlags := &shellSplitSlice{}
 	fs.Var(dockerFlags, "docker-flag", "Extra flag passed verbatim to docker/podman run; may be repeated")
 	extraRepos := &stringSlice{}
 	fs.Var(extraRepos, "extra-repo", "Additional git repository path[:branch] to map; may be repeated")
 	fs.Var(extraRepos, "e", "Additional git repository path[:branch] to map; may be repeated")
+	fs.Var(extraRepos, "with-repo", "Alias for -extra-repo")
 	labels := &stringSlice{}
 	fs.Var(labels, "label", "Set Docker container label (key=value); can be repeated")
 	fs.Var(labels, "l", "Set Docker container label (key=value); can be repeated")
+	var branchTemplate *string
+	if name == "fork" {
+		branchTemplate = fs.String("branch-template", config.BranchTemplate, "Template of the primary repo's new branch, e.g. agent/{{.Agent}}/{{.Slug}} (default: <branch>-<n>)")
+	}
 	fs.Usage = func() { printSubcommandUsage(fs) }
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
 	initLogging(*verbose)
-	if err := checkArgs(fs, 0); err != nil {
+	maxArgs := 0
+	if name == "clone" {
+		maxArgs = 1
+	}
+	if err := checkArgs(fs, maxArgs); err != nil {
 		return err
 	}
+	if fs.NArg() != maxArgs {
+		return errors.New("usage: md clone [flags] <branch>")
+	}
 
 	// Find the source container: by name if -source is given, otherwise
 	// auto-detect from the repo like push does.
@@ -918,6 +3820,12 @@ func cmdFork(ctx context.Context, args []string) error {
 	if githubToken != "" {
 		extraEnv = append(extraEnv, "GITHUB_TOKEN="+githubToken)
 	}
+	storeEnv, envArgs, cleanupEnv, err := resolveEnvFiles(ctx, sourceCt.XDGStateHome, config.EnvFiles, cmp.Or(config.EnvInject, md.EnvInjectFile))
+	if err != nil {
+		return err
+	}
+	defer cleanupEnv()
+	extraEnv = append(extraEnv, storeEnv...)
 	resolved, err := resolveRepoSpecs(ctx, extraRepos.values)
 	if err != nil {
 		return err
@@ -927,14 +3835,23 @@ func cmdFork(ctx context.Context, args []string) error {
 		Display:      *display,
 		Tailscale:    *tailscale,
 		USB:          *usb,
-		Labels:       labels.values,
+		Labels:       withConfig(config.Labels, labels.values),
 		Quiet:        *quiet,
-		AgentPaths:   slices.Collect(maps.Values(md.HarnessMounts)),
+		AgentPaths:   config.AgentPaths(),
 		ExtraEnv:     extraEnv,
 		MaxCPUs:      *cpus,
-		ExtraRunArgs: dockerFlags.values,
+		ExtraRunArgs: slices.Concat(dockerFlags.values, envArgs),
+	}
+	if branchTemplate != nil {
+		opts.BranchTemplate = *branchTemplate
+		opts.Agent = md.AgentName(cmp.Or(config.Agent, md.DefaultAgent))
+	}
+	var fork *md.Container
+	if name == "clone" {
+		fork, err = sourceCt.CloneTo(ctx, os.Stdout, os.Stderr, fs.Arg(0), &opts)
+	} else {
+		fork, err = sourceCt.Fork(ctx, os.Stdout, os.Stderr, &opts)
 	}
-	fork, err := sourceCt.Fork(ctx, os.Stdout, os.Stderr, &opts)
 	if err != nil {
 		return err
 	}
@@ -956,11 +3873,337 @@ func cmdFork(ctx context.Context, args []string) error {
 		cmd.Stderr = os.Stderr
 		return cmd.Run()
 	}
-	return nil
+	return nil
+}
+
+func cmdPort(ctx context.Context, args []string) error {
+	const usage = "usage: md port list|add|remove [flags] [host:container]"
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	op := args[0]
+	fs := newFlagSet("port " + op)
+	verbose := addVerboseFlag(fs)
+	cf := addContainerFlags(fs, false)
+	fs.Usage = func() { printSubcommandUsage(fs) }
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	initLogging(*verbose)
+	want := 1
+	if op == "list" {
+		want = 0
+	}
+	if fs.NArg() != want {
+		return errors.New(usage)
+	}
+	ct, _, err := findContainerAndRepo(ctx, cf)
+	if err != nil {
+		return err
+	}
+	switch op {
+	case "list":
+		forwards, err := ct.Forwards(ctx)
+		if err != nil {
+			return err
+		}
+		if len(ct.PublishedPorts) == 0 && len(forwards) == 0 {
+			fmt.Printf("No ports published or forwarded to %s\n", ct.Name)
+			return nil
+		}
+		fmt.Printf("%-22s %-10s %s\n", "Host", "Container", "Via")
+		for _, p := range ct.PublishedPorts {
+			fmt.Printf("%-22s %-10d %s\n", net.JoinHostPort(cmp.Or(ct.Bind, "127.0.0.1"), strconv.Itoa(int(p.Host))), p.Container, "publish")
+		}
+		for _, p := range forwards {
+			fmt.Printf("127.0.0.1:%-12d %-10d %s\n", p.Host, p.Container, "ssh")
+		}
+		return nil
+	case "add":
+		p, err := md.ParsePortMapping(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		if err := ct.AddForward(ctx, p); err != nil {
+			return err
+		}
+		fmt.Printf("Forwarding http://127.0.0.1:%d to port %d in %s\n", p.Host, p.Container, ct.Name)
+		return nil
+	case "remove", "rm":
+		p, err := md.ParsePortMapping(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		return ct.RemoveForward(ctx, p.Host)
+	default:
+		return errors.New(usage)
+	}
+}
+
+// warmResults is the result of md cache warm.
+type warmResults []md.WarmResult
+
+func (r warmResults) porcelain() [][]string {
+	lines := make([][]string, 0, len(r))
+	for _, w := range r {
+		lines = append(lines, []string{w.Cache, w.Status, strconv.FormatFloat(w.Duration.Seconds(), 'f', 1, 64), w.Reason})
+	}
+	return lines
+}
+
+func cmdCache(ctx context.Context, args []string) error {
+	if len(args) == 0 || args[0] != "warm" {
+		return errors.New("usage: md cache warm [flags] [name...]")
+	}
+	fs := newFlagSet("cache warm")
+	verbose := addVerboseFlag(fs)
+	quiet := fs.Bool("q", false, "Suppress informational messages and the tools' output")
+	out := addOutputFlags(fs)
+	fs.Usage = func() { printSubcommandUsage(fs) }
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	initLogging(*verbose)
+	if err := out.check(); err != nil {
+		return err
+	}
+	root, err := gitutil.RootDir(ctx, ".")
+	if err != nil {
+		return err
+	}
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	progress, toolOut := out.progress(), io.Writer(os.Stderr)
+	if *quiet {
+		progress, toolOut = io.Discard, io.Discard
+	}
+	res, err := c.WarmCaches(ctx, progress, toolOut, root, fs.Args())
+	if err != nil {
+		return err
+	}
+	if err := out.print(warmResults(res), func() {
+		if len(res) == 0 && !*quiet {
+			fmt.Printf("No cache to warm in %s; supported: %s\n", root, strings.Join(md.WarmableCaches(), ", "))
+		}
+	}); err != nil {
+		return err
+	}
+	if slices.ContainsFunc(res, func(w md.WarmResult) bool { return w.Status == md.WarmFailed }) {
+		return &exitCodeError{code: 1}
+	}
+	return nil
+}
+
+// envVar is a variable listed by md env list.
+type envVar struct {
+	File string `json:"file"`
+	md.EnvVar
+}
+
+// envList is the result of md env list.
+type envList []envVar
+
+func (l envList) porcelain() [][]string {
+	lines := make([][]string, 0, len(l))
+	for _, v := range l {
+		source := "file"
+		if v.Keychain {
+			source = "keychain"
+		}
+		lines = append(lines, []string{v.File, v.Key, source, v.Value})
+	}
+	return lines
+}
+
+func cmdEnv(ctx context.Context, args []string) error {
+	const usage = "usage: md env list|set|unset [flags] [KEY[=VALUE]]"
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	op := args[0]
+	fs := newFlagSet("env " + op)
+	verbose := addVerboseFlag(fs)
+	file := fs.String("f", "", "md env file (default: "+md.DefaultEnvFile+", or all of them for list); the default one is always injected, the others with --env-file or env_files")
+	var keychain, show *bool
+	var out *output
+	switch op {
+	case "set":
+		keychain = fs.Bool("keychain", false, "Keep the value in the OS keychain (macOS Keychain or Secret Service) instead of the file")
+	case "list":
+		show = fs.Bool("show", false, "Show the values, reading those kept in the keychain")
+		out = addOutputFlags(fs)
+	}
+	fs.Usage = func() { printSubcommandUsage(fs) }
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	initLogging(*verbose)
+	store, err := md.DefaultEnvStore()
+	if err != nil {
+		return err
+	}
+	switch op {
+	case "list":
+		if err := out.check(); err != nil {
+			return err
+		}
+		if err := checkArgs(fs, 0); err != nil {
+			return err
+		}
+		files := []string{*file}
+		if *file == "" {
+			if files, err = store.Files(); err != nil {
+				return err
+			}
+		}
+		var res envList
+		for _, f := range files {
+			vars, err := store.List(f)
+			if err != nil {
+				return err
+			}
+			if *show {
+				if vars, err = store.Resolve(ctx, []string{f}); err != nil {
+					return err
+				}
+			}
+			for _, v := range vars {
+				res = append(res, envVar{File: f, EnvVar: v})
+			}
+		}
+		return out.print(res, func() {
+			if len(res) == 0 {
+				fmt.Println("No variables; set one with 'md env set KEY=VALUE'")
+				return
+			}
+			for _, v := range res {
+				value := "***"
+				switch {
+				case *show:
+					value = v.Value
+				case v.Keychain:
+					value = "(keychain)"
+				}
+				fmt.Printf("%-12s %s=%s\n", v.File, v.Key, value)
+			}
+		})
+	case "set":
+		if fs.NArg() != 1 {
+			return errors.New("usage: md env set [-f file] [-keychain] KEY[=VALUE]; without a value, it is read from stdin")
+		}
+		key, value, ok := strings.Cut(fs.Arg(0), "=")
+		if !ok {
+			if value, err = readSecret(key); err != nil {
+				return err
+			}
+		}
+		return store.Set(ctx, cmp.Or(*file, md.DefaultEnvFile), key, value, *keychain)
+	case "unset":
+		if fs.NArg() != 1 {
+			return errors.New("usage: md env unset [-f file] KEY")
+		}
+		return store.Unset(ctx, cmp.Or(*file, md.DefaultEnvFile), fs.Arg(0))
+	default:
+		return errors.New(usage)
+	}
+}
+
+// readSecret reads the value of key from stdin, prompting without echo on a
+// terminal so it stays out of the shell history.
+func readSecret(key string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		fmt.Fprintf(os.Stderr, "%s: ", key)
+		b, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		return string(b), err
+	}
+	b, err := io.ReadAll(os.Stdin)
+	return strings.TrimSuffix(strings.TrimSuffix(string(b), "\n"), "\r"), err
+}
+
+func cmdVNC(ctx context.Context, args []string) error {
+	fs := newFlagSet("vnc")
+	verbose := addVerboseFlag(fs)
+	cf := addContainerFlags(fs, false)
+	displayNum := fs.Int("display", 1, "Virtual display to connect to (1-based)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	initLogging(*verbose)
+	if err := checkArgs(fs, 0); err != nil {
+		return err
+	}
+	if *displayNum < 1 || *displayNum > md.MaxDisplays {
+		return fmt.Errorf("--display must be between 1 and %d", md.MaxDisplays)
+	}
+	ct, err := newContainer(ctx, cf, nil)
+	if err != nil {
+		return err
+	}
+	return openVNC(ctx, ct, *displayNum)
+}
+
+// openVNC opens a VNC client on the display displayNum of ct.
+func openVNC(ctx context.Context, ct *md.Container, displayNum int) error {
+	vncAddr, err := ct.GetHostAddr(ctx, md.VNCContainerPort(displayNum))
+	if err != nil {
+		return err
+	}
+	if vncAddr == "" && displayNum > 1 {
+		return fmt.Errorf("VNC port for display :%d not found for %s. Did you start it with --displays %d?", displayNum, ct.Name, displayNum)
+	}
+	if vncAddr == "" {
+		return fmt.Errorf("VNC port not found for %s. Did you start it with --display?\nTo enable display, run:\n  md purge\n  md start --display", ct.Name)
+	}
+	password, err := ct.VNCPassword()
+	if err != nil {
+		return err
+	}
+	// The password stays out of the viewer's arguments, which other users
+	// of the host can read: the viewer asks for it.
+	vncURL := "vnc://" + vncAddr
+	fmt.Printf("VNC connection: %s\n", vncURL)
+	if password != "" {
+		fmt.Printf("VNC password: %s\n", password)
+	}
+
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("open", vncURL).Run()
+	case "linux":
+		if err := exec.Command("xdg-open", vncURL).Run(); err == nil {
+			return nil
+		}
+		// TigerVNC's viewer reads the password from its environment, private
+		// to the user.
+		viewer := exec.Command("vncviewer", vncAddr)
+		if password != "" {
+			viewer.Env = append(os.Environ(), "VNC_PASSWORD="+password)
+		}
+		if err := viewer.Run(); err == nil {
+			return nil
+		}
+		host, port, _ := net.SplitHostPort(vncAddr)
+		fmt.Println("\nNo VNC client found. Connect manually:")
+		fmt.Printf("  Address: %s\n", host)
+		fmt.Printf("  Port: %s\n", port)
+		fmt.Println("\nInstall a VNC client:")
+		fmt.Println("  Ubuntu/Debian: sudo apt install tigervnc-viewer")
+		fmt.Println("  Fedora/RHEL: sudo dnf install tigervnc")
+		fmt.Println("  Or use any remote desktop client (Remmina, RealVNC, TigerVNC, etc.)")
+		return nil
+	case "windows":
+		return exec.Command("cmd", "/c", "start", vncURL).Run()
+	default:
+		return fmt.Errorf("unsupported platform: %s", runtime.GOOS)
+	}
 }
 
-func cmdVNC(ctx context.Context, args []string) error {
-	fs := flag.NewFlagSet("vnc", flag.ExitOnError)
+func cmdRDP(ctx context.Context, args []string) error {
+	fs := newFlagSet("rdp")
 	verbose := addVerboseFlag(fs)
 	cf := addContainerFlags(fs, false)
 	if err := fs.Parse(args); err != nil {
@@ -974,44 +4217,65 @@ func cmdVNC(ctx context.Context, args []string) error {
 	if err != nil {
 		return err
 	}
-	vncPort, err := ct.GetHostPort(ctx, "5901/tcp")
+	addr, err := ct.GetHostAddr(ctx, "3389/tcp")
 	if err != nil {
 		return err
 	}
-	if vncPort == 0 {
-		return fmt.Errorf("VNC port not found for %s. Did you start it with --display?\nTo enable display, run:\n  md purge\n  md start --display", ct.Name)
+	if addr == "" {
+		return fmt.Errorf("RDP port not found for %s. Did you start it with --rdp?\nTo enable RDP, run:\n  md purge\n  md start --rdp", ct.Name)
+	}
+	fmt.Printf("RDP connection: %s\n", addr)
+	password, err := ct.VNCPassword()
+	if err != nil {
+		return err
+	}
+	if password != "" {
+		fmt.Printf("Password, if the login screen asks for it: %s\n", password)
 	}
-	vncURL := fmt.Sprintf("vnc://127.0.0.1:%d", vncPort)
-	fmt.Printf("VNC connection: %s\n", vncURL)
 
 	switch runtime.GOOS {
 	case "darwin":
-		return exec.Command("open", vncURL).Run()
+		// Microsoft Remote Desktop (Windows App) registers the rdp:// scheme.
+		return exec.Command("open", "rdp://full%20address=s:"+addr).Run()
 	case "linux":
-		if err := exec.Command("xdg-open", vncURL).Run(); err == nil {
-			return nil
-		}
-		if err := exec.Command("vncviewer", fmt.Sprintf("127.0.0.1:%d", vncPort)).Run(); err == nil {
-			return nil
+		for _, c := range [][]string{
+			{"xfreerdp3", "/v:" + addr, "/cert:ignore"},
+			{"xfreerdp", "/v:" + addr, "/cert:ignore"},
+			{"remmina", "-c", "rdp://" + addr},
+		} {
+			if _, err := exec.LookPath(c[0]); err != nil {
+				continue
+			}
+			return exec.Command(c[0], c[1:]...).Run()
 		}
-		fmt.Println("\nNo VNC client found. Connect manually:")
-		fmt.Println("  Address: 127.0.0.1")
-		fmt.Printf("  Port: %d\n", vncPort)
-		fmt.Println("\nInstall a VNC client:")
-		fmt.Println("  Ubuntu/Debian: sudo apt install tigervnc-viewer")
-		fmt.Println("  Fedora/RHEL: sudo dnf install tigervnc")
-		fmt.Println("  Or use any remote desktop client (Remmina, RealVNC, TigerVNC, etc.)")
+		host, port, _ := net.SplitHostPort(addr)
+		fmt.Println("\nNo RDP client found. Connect manually:")
+		fmt.Printf("  Address: %s\n", host)
+		fmt.Printf("  Port: %s\n", port)
+		fmt.Println("\nInstall an RDP client:")
+		fmt.Println("  Ubuntu/Debian: sudo apt install freerdp3-x11")
+		fmt.Println("  Fedora/RHEL: sudo dnf install freerdp")
+		fmt.Println("  Or use any remote desktop client (Remmina, etc.)")
 		return nil
 	case "windows":
-		return exec.Command("cmd", "/c", "start", vncURL).Run()
+		return exec.Command("mstsc", "/v:"+addr).Run()
 	default:
 		return fmt.Errorf("unsupported platform: %s", runtime.GOOS)
 	}
 }
 
 func cmdBuildImage(ctx context.Context, args []string) error {
-	fs := flag.NewFlagSet("build-image", flag.ExitOnError)
+	fs := newFlagSet("build-image")
 	verbose := addVerboseFlag(fs)
+	builder := fs.String("builder", os.Getenv("MD_BUILDER"), "Delegate the build to this docker buildx builder, e.g. a remote BuildKit or Docker Build Cloud builder (default: $MD_BUILDER); requires --push")
+	push := fs.String("push", os.Getenv("MD_BUILD_PUSH"), "Push the images to this repository as :root and :latest (default: $MD_BUILD_PUSH)")
+	tag := fs.String("tag", "latest", "Tag for md-root-local and md-user-local (pushed as :root-<tag> and :<tag>)")
+	platform := fs.String("platform", "", "Target platform, e.g. linux/amd64 (default: host architecture); several comma-separated ones require --builder")
+	buildArgs := &stringSlice{}
+	fs.Var(buildArgs, "build-arg", "Build argument KEY=VALUE passed to both Dockerfiles; may be repeated")
+	noCache := fs.Bool("no-cache", false, "Build without the build cache")
+	contextDir := fs.String("context-dir", config.ContextDir, "Build from this directory, laid out like md's rsc/ (root/ and user/), instead of the embedded build context")
+	out := addOutputFlags(fs)
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
@@ -1019,17 +4283,55 @@ func cmdBuildImage(ctx context.Context, args []string) error {
 	if err := checkArgs(fs, 0); err != nil {
 		return err
 	}
+	if err := out.check(); err != nil {
+		return err
+	}
 	c, err := newClient()
 	if err != nil {
 		return err
 	}
+	if *contextDir != "" {
+		if *contextDir, err = filepath.Abs(*contextDir); err != nil {
+			return err
+		}
+	}
 	ensureGithubToken(c)
-	return c.BuildImage(ctx, os.Stdout, os.Stderr)
+	opts := &md.BuildImageOpts{
+		Builder:    *builder,
+		Push:       *push,
+		Tag:        *tag,
+		Platform:   *platform,
+		BuildArgs:  buildArgs.values,
+		NoCache:    *noCache,
+		ContextDir: *contextDir,
+	}
+	start := time.Now()
+	if err := c.BuildImage(ctx, out.progress(), os.Stderr, opts); err != nil {
+		return err
+	}
+	return out.print(&buildResult{Images: opts.Images(), Duration: time.Since(start).Round(time.Millisecond).String()}, nil)
+}
+
+// buildResult is md build-image's result with -json or -porcelain.
+type buildResult struct {
+	Images   []string `json:"images"`
+	Duration string   `json:"duration"`
+}
+
+func (r *buildResult) porcelain() [][]string {
+	lines := make([][]string, 0, len(r.Images)+1)
+	for _, img := range r.Images {
+		lines = append(lines, []string{"image", img})
+	}
+	return append(lines, []string{"duration", r.Duration})
 }
 
 func cmdPrune(ctx context.Context, args []string) error {
-	fs := flag.NewFlagSet("prune", flag.ExitOnError)
+	fs := newFlagSet("prune")
 	verbose := addVerboseFlag(fs)
+	dryRun := fs.Bool("dry-run", false, "Only report what would be removed")
+	roots := &stringSlice{}
+	fs.Var(roots, "root", "Directory searched for git repositories with remotes of removed containers (default: the current repository or directory); may be repeated")
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
@@ -1041,32 +4343,396 @@ func cmdPrune(ctx context.Context, args []string) error {
 	if err != nil {
 		return err
 	}
-	removed, err := c.PruneImages(ctx, os.Stdout, os.Stderr)
+	opts := md.PruneOpts{DryRun: *dryRun, Roots: roots.values}
+	if len(opts.Roots) == 0 {
+		wd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		if gitRoot, err := repoRoot(ctx, wd); err == nil {
+			wd = gitRoot
+		}
+		opts.Roots = []string{wd}
+	}
+	removed, err := c.Prune(ctx, os.Stdout, os.Stderr, &opts)
 	if err != nil {
 		return err
 	}
 	if len(removed) == 0 {
-		fmt.Println("No unused md images to remove")
+		fmt.Println("Nothing to remove")
 		return nil
 	}
+	verb := "Removed"
+	if *dryRun {
+		verb = "Would remove"
+	}
 	for _, name := range removed {
-		fmt.Printf("Removed %s\n", name)
+		fmt.Printf("%s %s\n", verb, name)
+	}
+	return nil
+}
+
+func cmdImages(ctx context.Context, args []string) error {
+	fs := newFlagSet("images")
+	verbose := addVerboseFlag(fs)
+	out := addOutputFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	initLogging(*verbose)
+	if err := checkArgs(fs, 0); err != nil {
+		return err
+	}
+	if err := out.check(); err != nil {
+		return err
+	}
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	images, err := c.Images(ctx)
+	if err != nil {
+		return err
+	}
+	return out.print(imageList(images), func() { printImages(images) })
+}
+
+func printImages(images []md.ImageUsage) {
+	if len(images) == 0 {
+		fmt.Println("No md images")
+		return
+	}
+	fmt.Printf("%-40s  %-16s  %9s  %11s  %-7s  %-7s  %s\n", "Image", "Created", "Size", "Reclaimable", "Base", "Keys", "Containers")
+	fmt.Println(strings.Repeat("-", 120))
+	var total int64
+	for _, u := range images {
+		id := strings.TrimPrefix(u.ID, "sha256:")
+		name := "(superseded) " + id[:min(12, len(id))]
+		if len(u.Tags) != 0 {
+			name = strings.TrimSuffix(u.Tags[0], ":latest")
+		}
+		base, keys := imageState(u.BaseImage != "", u.BaseCurrent), imageState(u.ContextSHA != "", u.KeysCurrent)
+		fmt.Printf("%-40s  %-16s  %9s  %11s  %-7s  %-7s  %s\n", name, u.Created.Local().Format("2006-01-02 15:04"), md.FormatBytes(u.Size), md.FormatBytes(u.Reclaimable), base, keys, strings.Join(u.Containers, ", "))
+		total += u.Reclaimable
+	}
+	if total != 0 {
+		fmt.Printf("\n%s reclaimable with md prune\n", md.FormatBytes(total))
+	}
+}
+
+// imageState describes whether an image's base or keys are the current ones.
+func imageState(known, current bool) string {
+	switch {
+	case !known:
+		return "-"
+	case current:
+		return "current"
+	default:
+		return "stale"
+	}
+}
+
+// imageList is md images' result with -json or -porcelain.
+type imageList []md.ImageUsage
+
+func (l imageList) porcelain() [][]string {
+	lines := make([][]string, len(l))
+	for i, u := range l {
+		lines[i] = []string{
+			u.ID, strings.Join(u.Tags, ","), strconv.FormatInt(u.Size, 10), strconv.FormatInt(u.Reclaimable, 10),
+			imageState(u.BaseImage != "", u.BaseCurrent), imageState(u.ContextSHA != "", u.KeysCurrent), strings.Join(u.Containers, ","),
+		}
+	}
+	return lines
+}
+
+// commands lists the subcommands, for the [args] configuration table.
+var commands = []string{
+	"start", "run", "exec", "list", "stop", "resume", "purge", "kill", "push", "pull", "fetch", "sync", "deepen", "diff", "review", "pr", "changelog", "export-review", "timeline", "gerrit", "fsdiff", "bake",
+	"fork", "clone", "snapshot", "restore", "handoff", "status", "inspect", "services", "tailscale", "verify", "logs", "ui", "serve", "mcp", "port", "env", "cache", "vnc", "rdp", "build-image", "images", "prune", "gc", "doctor", "bench", "debug", "task", "config", "ws", "workspace",
+}
+
+func cmdConfig(ctx context.Context, args []string) error {
+	if len(args) == 0 || args[0] != "validate" {
+		return errors.New("usage: md config validate [--schema] [--json] [file...]")
+	}
+	fs := newFlagSet("config validate")
+	verbose := addVerboseFlag(fs)
+	schema := fs.Bool("schema", false, "Print the JSON Schema of the configuration files and exit")
+	jsonOut := fs.Bool("json", false, "Output in JSON format")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	initLogging(*verbose)
+	if *schema {
+		b, err := md.ConfigSchema()
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Printf("%s\n", b)
+		return err
+	}
+	// Files named on the command line are checked as user configuration,
+	// unless named like a repository one.
+	files := fs.Args()
+	if len(files) == 0 {
+		p, err := md.DefaultConfigPath()
+		if err != nil {
+			return err
+		}
+		files = append(files, p)
+		if root, err := gitutil.RootDir(ctx, "."); err == nil {
+			files = append(files, filepath.Join(root, md.RepoConfigFile))
+		}
+	}
+	type fileIssues struct {
+		Path   string           `json:"path"`
+		Issues []md.ConfigIssue `json:"issues"`
+	}
+	var results []fileIssues
+	failed := false
+	for _, p := range files {
+		issues, err := md.CheckConfigFile(p, filepath.Base(p) == md.RepoConfigFile, commands)
+		if errors.Is(err, os.ErrNotExist) && len(fs.Args()) == 0 {
+			continue
+		} else if err != nil {
+			return err
+		}
+		results = append(results, fileIssues{Path: p, Issues: issues})
+		for _, i := range issues {
+			failed = failed || !i.Warning
+		}
+	}
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			return err
+		}
+	} else {
+		if len(results) == 0 {
+			fmt.Println("No configuration file found")
+		}
+		for _, r := range results {
+			if len(r.Issues) == 0 {
+				fmt.Printf("%s: ok\n", r.Path)
+			}
+			for _, i := range r.Issues {
+				severity := "error"
+				if i.Warning {
+					severity = "warning"
+				}
+				fmt.Printf("%s:%d:%d: %s: %s\n", r.Path, i.Line, i.Col, severity, i.Message)
+			}
+		}
+	}
+	if failed {
+		return &exitCodeError{code: 1}
+	}
+	return nil
+}
+
+// wsMember is a repository of a workspace and its container.
+type wsMember struct {
+	spec string
+	// ct is the listed container for the repository or, when it doesn't
+	// exist, the one to start.
+	ct *md.Container
+	// state is the existing container's state, empty when it doesn't exist.
+	state string
+	// out collects the operation's output, shown when it fails.
+	out    bytes.Buffer
+	result string
+	err    error
+}
+
+func cmdWorkspace(ctx context.Context, args []string) error {
+	const usage = "usage: md ws start|status|push|kill [-j N] <workspace>"
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	op := args[0]
+	if !slices.Contains([]string{"start", "status", "push", "kill"}, op) {
+		return fmt.Errorf("unknown ws operation %q; %s", op, usage)
+	}
+	fs := newFlagSet("ws " + op)
+	verbose := addVerboseFlag(fs)
+	jobs := fs.Int("j", 4, "Maximum number of containers operated on concurrently")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	initLogging(*verbose)
+	if fs.NArg() != 1 {
+		return errors.New(usage)
+	}
+	if *jobs < 1 {
+		return errors.New("-j must be at least 1")
+	}
+	name := fs.Arg(0)
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	specs, err := config.Workspace(name, c.Home)
+	if err != nil {
+		return err
+	}
+	containers, err := c.List(ctx)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]*md.Container, len(containers))
+	for _, ct := range containers {
+		existing[ct.Name] = ct
+	}
+	members := make([]*wsMember, len(specs))
+	for i, spec := range specs {
+		m := &wsMember{spec: spec}
+		members[i] = m
+		repos, err := resolveRepoSpecs(ctx, []string{spec})
+		if err != nil {
+			m.err = err
+			continue
+		}
+		m.ct = c.Container(repos...)
+		if ct := existing[m.ct.Name]; ct != nil {
+			m.ct, m.state = ct, ct.State
+		}
+	}
+	if op != "status" {
+		opts := workspaceStartOpts()
+		eg, ctx2 := errgroup.WithContext(ctx)
+		eg.SetLimit(*jobs)
+		for _, m := range members {
+			if m.err != nil {
+				continue
+			}
+			eg.Go(func() error {
+				// Errors are reported per member, they don't stop the others.
+				m.result, m.err = workspaceOp(ctx2, op, m, opts)
+				return nil
+			})
+		}
+		_ = eg.Wait()
+	}
+	failed := 0
+	fmt.Printf("%-40s %-10s %s\n", "Container", "State", "Result")
+	fmt.Println(strings.Repeat("-", 80))
+	for _, m := range members {
+		ctName, state, result := m.spec, m.state, m.result
+		if m.ct != nil {
+			ctName = m.ct.Name
+			state = m.ct.State
+		}
+		if state == "" {
+			state = "absent"
+		} else if state == "exited" || state == "created" {
+			state = "stopped"
+		}
+		if m.err != nil {
+			failed++
+			result = "error: " + m.err.Error()
+		}
+		fmt.Printf("%-40s %-10s %s\n", ctName, state, result)
+		if m.err != nil && m.out.Len() > 0 {
+			for line := range strings.SplitSeq(strings.TrimRight(m.out.String(), "\n"), "\n") {
+				fmt.Printf("  | %s\n", line)
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("ws %s %s: %d of %d failed", op, name, failed, len(members))
 	}
 	return nil
 }
 
+// workspaceOp runs op on a workspace member and returns a one-line result.
+func workspaceOp(ctx context.Context, op string, m *wsMember, opts md.StartOpts) (string, error) {
+	ct := m.ct
+	switch op {
+	case "start":
+		switch m.state {
+		case "running":
+			return "already running", nil
+		case "":
+			if err := ct.Launch(ctx, &m.out, &m.out, &opts); err != nil {
+				return "", err
+			}
+			if _, err := ct.Connect(ctx, &m.out, &m.out, &opts); err != nil {
+				return "", err
+			}
+			notify(ctx, md.NewEvent(md.EventStart, ct))
+			return "started; ssh " + ct.Name, nil
+		default:
+			if err := ct.Resume(ctx, &m.out, &m.out); err != nil {
+				return "", err
+			}
+			return "resumed; ssh " + ct.Name, nil
+		}
+	case "push":
+		if m.state != "running" {
+			return "", errors.New("not running")
+		}
+		res, err := ct.Push(ctx, &m.out, &m.out, 0, nil)
+		if err != nil {
+			return "", err
+		}
+		return withStat("pushed; previous state in "+res.Backup, res.Stat), nil
+	case "kill":
+		if m.state == "" {
+			return "not found", nil
+		}
+		if err := ct.Purge(ctx, &m.out, &m.out); err != nil {
+			return "", err
+		}
+		notify(ctx, md.NewEvent(md.EventKill, ct))
+		ct.State = ""
+		return "removed", nil
+	}
+	return "", nil
+}
+
+// workspaceStartOpts returns the options for containers started by md ws
+// start and md serve: the configured defaults, as md start without flags.
+func workspaceStartOpts() md.StartOpts {
+	caches, err := resolveCaches(config.Caches, config.NoCaches, false, nil)
+	if err != nil {
+		slog.Warn("md", "msg", "ignoring configured caches", "err", err)
+		caches = nil
+	}
+	return md.StartOpts{
+		BaseImage:        config.Image,
+		Tailscale:        config.Tailscale.Enabled != nil && *config.Tailscale.Enabled,
+		TailscaleAuthKey: os.Getenv("TAILSCALE_AUTHKEY"),
+		Caches:           caches,
+		Labels:           config.Labels,
+		Quiet:            true,
+		AgentPaths:       config.AgentPaths(),
+		MaxCPUs:          defaultCPUs(),
+		Memory:           config.Limits.Memory,
+		PidsLimit:        config.Limits.PidsLimit,
+		ShmSize:          config.Limits.ShmSize,
+		Setup:            config.Setup,
+	}
+}
+
 func cmdVersion(args []string) error {
-	fs := flag.NewFlagSet("version", flag.ExitOnError)
+	fs := newFlagSet("version")
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
 	if fs.NArg() > 0 {
 		return fmt.Errorf("version: unexpected arguments: %s", strings.Join(fs.Args(), " "))
 	}
+	_, err := fmt.Printf("md %s\n", version())
+	return err
+}
+
+// version returns md's version from the build info.
+func version() string {
 	info, ok := debug.ReadBuildInfo()
 	if !ok {
-		_, err := fmt.Println("md (unknown version; no build info)")
-		return err
+		return "(unknown version; no build info)"
 	}
 	settings := make(map[string]string, len(info.Settings))
 	for _, s := range info.Settings {
@@ -1077,8 +4743,7 @@ func cmdVersion(args []string) error {
 		// No module version stamped; build from VCS info.
 		rev := settings["vcs.revision"]
 		if rev == "" {
-			_, err := fmt.Println("md (unknown version; no VCS info)")
-			return err
+			return "(unknown version; no VCS info)"
 		}
 		if len(rev) > 12 {
 			rev = rev[:12]
@@ -1091,8 +4756,7 @@ func cmdVersion(args []string) error {
 			version += " " + t
 		}
 	}
-	_, err := fmt.Printf("md %s\n", version)
-	return err
+	return version
 }
 
 func noArgs(cmd string, args []string) error {
@@ -1109,6 +4773,83 @@ func checkArgs(fs *flag.FlagSet, maxArgs int) error {
 	return nil
 }
 
+// output is the result format of the commands scripts consume, selected
+// with -json or -porcelain. In both machine-readable formats, progress goes
+// to stderr so stdout only holds the result.
+type output struct {
+	json      *bool
+	porcelain *bool
+}
+
+// addOutputFlags registers -json and -porcelain on fs.
+func addOutputFlags(fs *flag.FlagSet) *output {
+	return &output{
+		json:      fs.Bool("json", false, "Print the result as JSON"),
+		porcelain: fs.Bool("porcelain", false, "Print the result as stable tab-separated lines"),
+	}
+}
+
+// check rejects conflicting format flags.
+func (o *output) check() error {
+	if *o.json && *o.porcelain {
+		return errors.New("-json and -porcelain are mutually exclusive")
+	}
+	return nil
+}
+
+// machine reports whether a machine-readable format is selected.
+func (o *output) machine() bool {
+	return *o.json || *o.porcelain
+}
+
+// progress returns the writer for progress and other messages meant for
+// humans.
+func (o *output) progress() io.Writer {
+	if o.machine() {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// result is a command result printable with -porcelain.
+type result interface {
+	// porcelain returns one line per record, as fields. Single-object results
+	// use key and value fields.
+	porcelain() [][]string
+}
+
+// porcelainEscaper keeps each porcelain field on its line and column.
+var porcelainEscaper = strings.NewReplacer("\t", " ", "\n", " ")
+
+// print writes r in the selected format, calling text, if not nil, for the
+// human one.
+func (o *output) print(r result, text func()) error {
+	switch {
+	case *o.json:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	case *o.porcelain:
+		var b strings.Builder
+		for _, fields := range r.porcelain() {
+			for i, f := range fields {
+				if i > 0 {
+					b.WriteByte('\t')
+				}
+				b.WriteString(porcelainEscaper.Replace(f))
+			}
+			b.WriteByte('\n')
+		}
+		_, err := os.Stdout.WriteString(b.String())
+		return err
+	default:
+		if text != nil {
+			text()
+		}
+		return nil
+	}
+}
+
 // exitCodeError is returned when a subcommand needs to exit with a specific
 // non-zero code without printing an error message.
 type exitCodeError struct {
@@ -1148,14 +4889,40 @@ func wellKnownCacheList() string {
 	return strings.Join(names, ", ")
 }
 
+// registerCacheSets registers the configured cache sets as well-known
+// caches.
+func registerCacheSets(sets map[string]md.CacheSetConfig) error {
+	for _, name := range slices.Sorted(maps.Keys(sets)) {
+		set := sets[name]
+		mounts, err := set.Mounts(name)
+		if err != nil {
+			return fmt.Errorf("cache set %q: %w", name, err)
+		}
+		if err := md.RegisterCache(name, mounts, set.Detect); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// detectedCaches returns the well-known caches the container's primary
+// repository uses when detect is set, nil otherwise.
+func detectedCaches(ct *md.Container, detect bool) []string {
+	if !detect || len(ct.Repos) == 0 {
+		return nil
+	}
+	return md.DetectCaches(ct.Repos[0].GitRoot)
+}
+
 // resolveCaches builds the list of CacheMounts to bake into the image.
 //
-// By default all well-known caches are included (sorted by name).
+// By default all well-known caches are included (sorted by name), or only
+// the detected ones when detected isn't nil.
 // excluded names remove specific well-known caches from that default set.
 // noAll disables all defaults; only caches from customSpecs are included.
 // customSpecs accepts well-known names (to re-add an excluded cache when used
 // with noAll) or "host:container[:ro]" custom paths.
-func resolveCaches(customSpecs, excluded []string, noAll bool) ([]md.CacheMount, error) {
+func resolveCaches(customSpecs, excluded []string, noAll bool, detected []string) ([]md.CacheMount, error) {
 	result := make([]md.CacheMount, 0)
 
 	if !noAll {
@@ -1175,6 +4942,9 @@ func resolveCaches(customSpecs, excluded []string, noAll bool) ([]md.CacheMount,
 		}
 		sort.Strings(names)
 		for _, name := range names {
+			if detected != nil && !slices.Contains(detected, name) {
+				continue
+			}
 			if _, excluded := excl[name]; !excluded {
 				result = append(result, md.WellKnownCaches[name]...)
 			}
@@ -1199,27 +4969,43 @@ func resolveCaches(customSpecs, excluded []string, noAll bool) ([]md.CacheMount,
 			continue
 		}
 		// Custom spec: host:container or host:container:ro.
-		parts := strings.SplitN(spec, ":", 3)
-		if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
-			return nil, fmt.Errorf("invalid --cache %q: use a well-known name (%s) or host:container[:ro]",
-				spec, wellKnownCacheList())
-		}
-		cm := md.CacheMount{
-			Name:          filepath.Base(parts[0]),
-			HostPath:      parts[0],
-			ContainerPath: parts[1],
-		}
-		if len(parts) == 3 {
-			if parts[2] != "ro" {
-				return nil, fmt.Errorf("invalid --cache %q: only ':ro' modifier is supported", spec)
+		cm, err := md.ParseCacheSpec(spec)
+		if err != nil {
+			if !strings.Contains(spec, ":") {
+				return nil, fmt.Errorf("invalid --cache %q: use a well-known name (%s) or host:container[:ro]", spec, wellKnownCacheList())
 			}
-			cm.ReadOnly = true
+			return nil, fmt.Errorf("invalid --cache %q: %w", spec, err)
 		}
 		result = append(result, cm)
 	}
 	return result, nil
 }
 
+// wellKnownCredentialList returns a sorted comma-separated list of shareable
+// credential names for use in help and error messages.
+func wellKnownCredentialList() string {
+	names := slices.Sorted(maps.Keys(md.WellKnownCredentials))
+	return strings.Join(names, ", ")
+}
+
+// resolveCredentials parses a comma-separated --creds value into credential
+// names, validating each against md.WellKnownCredentials. Duplicates are
+// dropped.
+func resolveCredentials(spec string) ([]string, error) {
+	var names []string
+	for n := range strings.SplitSeq(spec, ",") {
+		n = strings.TrimSpace(n)
+		if n == "" || slices.Contains(names, n) {
+			continue
+		}
+		if _, ok := md.WellKnownCredentials[n]; !ok {
+			return nil, fmt.Errorf("unknown --creds name %q; valid names: %s", n, wellKnownCredentialList())
+		}
+		names = append(names, n)
+	}
+	return names, nil
+}
+
 // stringSlice implements flag.Value for repeatable string flags.
 type stringSlice struct {
 	values []string
@@ -1234,6 +5020,50 @@ func (s *stringSlice) Set(v string) error {
 	return nil
 }
 
+// sourceMountFlag implements flag.Value for --mount-src. It is a boolean flag
+// that also accepts a mode: --mount-src alone mounts read-write.
+type sourceMountFlag struct {
+	mode md.SourceMount
+}
+
+func (f *sourceMountFlag) String() string {
+	return string(f.mode)
+}
+
+func (f *sourceMountFlag) Set(v string) error {
+	mode, err := md.ParseSourceMount(v)
+	f.mode = mode
+	return err
+}
+
+func (f *sourceMountFlag) IsBoolFlag() bool {
+	return true
+}
+
+// commitResultsFlag implements flag.Value for --commit-results. It is a
+// boolean flag that also accepts the branch name.
+type commitResultsFlag struct {
+	set    bool
+	branch string
+}
+
+func (f *commitResultsFlag) String() string {
+	return f.branch
+}
+
+func (f *commitResultsFlag) Set(v string) error {
+	f.set = v != "false"
+	f.branch = ""
+	if v != "true" && v != "false" {
+		f.branch = v
+	}
+	return nil
+}
+
+func (f *commitResultsFlag) IsBoolFlag() bool {
+	return true
+}
+
 // shellSplitSlice implements flag.Value for repeatable flags whose values are
 // shell-split into individual arguments. e.g. --docker-flag="--memory 4g"
 // produces ["--memory", "4g"].
@@ -5,6 +5,13 @@
 package main
 
 import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/caic-xyz/md"
@@ -20,7 +27,7 @@ func TestResolveCaches(t *testing.T) {
 	}
 
 	t.Run("default_includes_all_well_known", func(t *testing.T) {
-		got, err := resolveCaches(nil, nil, false)
+		got, err := resolveCaches(nil, nil, false, nil)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -43,7 +50,7 @@ func TestResolveCaches(t *testing.T) {
 	})
 
 	t.Run("no_caches_returns_empty_non_nil", func(t *testing.T) {
-		got, err := resolveCaches(nil, nil, true)
+		got, err := resolveCaches(nil, nil, true, nil)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -56,7 +63,7 @@ func TestResolveCaches(t *testing.T) {
 	})
 
 	t.Run("no_cache_excludes_named", func(t *testing.T) {
-		got, err := resolveCaches(nil, []string{"go-mod"}, false)
+		got, err := resolveCaches(nil, []string{"go-mod"}, false, nil)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -83,14 +90,14 @@ func TestResolveCaches(t *testing.T) {
 	})
 
 	t.Run("no_cache_unknown_name_errors", func(t *testing.T) {
-		_, err := resolveCaches(nil, []string{"nonexistent"}, false)
+		_, err := resolveCaches(nil, []string{"nonexistent"}, false, nil)
 		if err == nil {
 			t.Fatal("expected error for unknown --no-cache name")
 		}
 	})
 
 	t.Run("custom_cache_added", func(t *testing.T) {
-		got, err := resolveCaches([]string{"/host/path:/container/path"}, nil, true)
+		got, err := resolveCaches([]string{"/host/path:/container/path"}, nil, true, nil)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -100,7 +107,7 @@ func TestResolveCaches(t *testing.T) {
 	})
 
 	t.Run("no_caches_plus_cache_readds_well_known", func(t *testing.T) {
-		got, err := resolveCaches([]string{"go-mod"}, nil, true)
+		got, err := resolveCaches([]string{"go-mod"}, nil, true, nil)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -127,7 +134,7 @@ func TestResolveCaches(t *testing.T) {
 	})
 
 	t.Run("no_duplicate_when_cache_already_default", func(t *testing.T) {
-		got, err := resolveCaches([]string{"go-mod"}, nil, false)
+		got, err := resolveCaches([]string{"go-mod"}, nil, false, nil)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -143,7 +150,7 @@ func TestResolveCaches(t *testing.T) {
 	})
 
 	t.Run("custom_cache_ro", func(t *testing.T) {
-		got, err := resolveCaches([]string{"/host:/cnt:ro"}, nil, true)
+		got, err := resolveCaches([]string{"/host:/cnt:ro"}, nil, true, nil)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -152,14 +159,79 @@ func TestResolveCaches(t *testing.T) {
 		}
 	})
 
+	t.Run("detected_only", func(t *testing.T) {
+		got, err := resolveCaches([]string{"npm"}, []string{"cargo"}, false, []string{"cargo", "go-mod"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if names := allNames(got); !slices.Equal(names, []string{"go-mod", "npm"}) {
+			t.Errorf("got %q", names)
+		}
+		if got, err := resolveCaches(nil, nil, false, []string{}); err != nil || len(got) != 0 {
+			t.Errorf("none detected: %+v, %v", got, err)
+		}
+	})
+
 	t.Run("invalid_custom_spec_errors", func(t *testing.T) {
-		_, err := resolveCaches([]string{"notapath"}, nil, true)
+		_, err := resolveCaches([]string{"notapath"}, nil, true, nil)
 		if err == nil {
 			t.Fatal("expected error for invalid custom spec")
 		}
 	})
 }
 
+func TestResolveCredentials(t *testing.T) {
+	t.Run("empty", func(t *testing.T) {
+		got, err := resolveCredentials("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 0 {
+			t.Errorf("got %v, want empty", got)
+		}
+	})
+
+	t.Run("dedup_and_trim", func(t *testing.T) {
+		got, err := resolveCredentials("kube, aws,kube")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 2 || got[0] != "kube" || got[1] != "aws" {
+			t.Errorf("got %v, want [kube aws]", got)
+		}
+	})
+
+	t.Run("unknown_errors", func(t *testing.T) {
+		if _, err := resolveCredentials("kube,ssh"); err == nil {
+			t.Fatal("expected error for unknown credential")
+		}
+	})
+}
+
+func TestSelectBakeChanges(t *testing.T) {
+	changes := []md.FSChange{
+		{Category: md.FSApt, Item: "htop"},
+		{Category: md.FSDotfile, Item: "~/.gitconfig"},
+	}
+	got, err := selectBakeChanges(changes, []string{"apt", "npm"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Item != "htop" {
+		t.Errorf("by category: got %v", got)
+	}
+	got, err = selectBakeChanges(changes, []string{"apt"}, []string{"~/.gitconfig"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Item != "~/.gitconfig" {
+		t.Errorf("by item: got %v", got)
+	}
+	if _, err := selectBakeChanges(changes, nil, []string{"nope"}); err == nil {
+		t.Error("expected error for unknown item")
+	}
+}
+
 func TestShellSplit(t *testing.T) {
 	t.Run("simple", func(t *testing.T) {
 		got, err := shellSplit("--memory 4g")
@@ -246,3 +318,224 @@ func TestShellSplit(t *testing.T) {
 		}
 	})
 }
+
+func TestSortByUsage(t *testing.T) {
+	containers := []*md.Container{{Name: "a"}, {Name: "b"}, {Name: "c"}}
+	stats := map[string]*md.ContainerStats{
+		"a": {CPUPerc: 5, MemUsed: 300, DiskUsed: 10, VolumesUsed: -1},
+		"c": {CPUPerc: 50, MemUsed: 100, DiskUsed: 10, VolumesUsed: 100},
+	}
+	for key, want := range map[string]string{"cpu": "cab", "mem": "acb", "disk": "cab"} {
+		sortByUsage(containers, stats, key)
+		got := ""
+		for _, ct := range containers {
+			got += ct.Name
+		}
+		if got != want {
+			t.Errorf("sort %s: got %s, want %s", key, got, want)
+		}
+	}
+}
+
+func TestParseNumstat(t *testing.T) {
+	const out = "3\t1\tmain.go\n-\t-\tlogo.png\n0\t5\told.go => new.go\n\n"
+	got := parseNumstat("md", out)
+	want := []diffStat{
+		{Repo: "md", Path: "main.go", Added: 3, Deleted: 1},
+		{Repo: "md", Path: "logo.png", Binary: true},
+		{Repo: "md", Path: "old.go => new.go", Deleted: 5},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %+v", got)
+	}
+	lines := diffStats(got).porcelain()
+	if !slices.Equal(lines[1], []string{"md", "-", "-", "logo.png"}) {
+		t.Errorf("porcelain: %q", lines[1])
+	}
+}
+
+func TestParseNumstatRaw(t *testing.T) {
+	const out = ":100644 100644 6178079 8ba3a16 M\tmain.go\n:000000 100644 0000000 8ba3a16 A\tnew.go\n:100644 000000 6178079 0000000 D\told.go\n" +
+		"3\t1\tmain.go\n2\t0\tnew.go\n0\t5\told.go\n"
+	got := parseNumstat("md", out)
+	want := []diffStat{
+		{Repo: "md", Path: "main.go", Added: 3, Deleted: 1, Status: "M"},
+		{Repo: "md", Path: "new.go", Added: 2, Status: "A"},
+		{Repo: "md", Path: "old.go", Deleted: 5, Status: "D"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %+v", got)
+	}
+	if lines := diffStats(got).porcelain(); !slices.Equal(lines[1], []string{"md", "A", "2", "0", "new.go"}) {
+		t.Errorf("porcelain: %q", lines[1])
+	}
+}
+
+func TestNetworkPolicy(t *testing.T) {
+	if p, err := networkPolicy(false, ""); p != nil || err != nil {
+		t.Errorf("got %+v, %v", p, err)
+	}
+	if p, err := networkPolicy(true, ""); p == nil || len(p.AllowHosts) != 0 || err != nil {
+		t.Errorf("got %+v, %v", p, err)
+	}
+	if p, err := networkPolicy(false, "github.com, proxy.golang.org,"); err != nil || !slices.Equal(p.AllowHosts, []string{"github.com", "proxy.golang.org"}) {
+		t.Errorf("got %+v, %v", p, err)
+	}
+	if _, err := networkPolicy(true, "github.com"); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestCommitResultsFlag(t *testing.T) {
+	for _, tt := range []struct {
+		args   []string
+		set    bool
+		branch string
+	}{
+		{nil, false, ""},
+		{[]string{"-commit-results"}, true, ""},
+		{[]string{"-commit-results=fix-lint"}, true, "fix-lint"},
+		{[]string{"-commit-results=false"}, false, ""},
+	} {
+		fs := newFlagSet("run")
+		f := &commitResultsFlag{}
+		fs.Var(f, "commit-results", "")
+		if err := fs.Parse(append(tt.args, "make")); err != nil {
+			t.Fatal(err)
+		}
+		if f.set != tt.set || f.branch != tt.branch || !slices.Equal(fs.Args(), []string{"make"}) {
+			t.Errorf("%q: got %+v, %q", tt.args, f, fs.Args())
+		}
+	}
+}
+
+func TestResolveEnvFiles(t *testing.T) {
+	ctx := t.Context()
+	state := t.TempDir()
+	store := md.NewEnvStore(state)
+	if err := store.Set(ctx, md.DefaultEnvFile, "A", "a b", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set(ctx, "work", "B", "b", false); err != nil {
+		t.Fatal(err)
+	}
+	env, runArgs, cleanup, err := resolveEnvFiles(ctx, state, []string{"work"}, md.EnvInjectFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleanup()
+	if !slices.Equal(env, []string{"A='a b'", "B=b"}) || runArgs != nil {
+		t.Errorf("file: %q, %q", env, runArgs)
+	}
+	env, runArgs, cleanup, err = resolveEnvFiles(ctx, state, nil, md.EnvInjectEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env != nil || len(runArgs) != 2 || runArgs[0] != "--env-file" {
+		t.Fatalf("engine: %q, %q", env, runArgs)
+	}
+	if data, _ := os.ReadFile(runArgs[1]); string(data) != "A=a b\n" {
+		t.Errorf("engine file: %q", data)
+	}
+	cleanup()
+	if _, err := os.Stat(runArgs[1]); !os.IsNotExist(err) {
+		t.Errorf("not removed: %v", err)
+	}
+	if _, _, _, err := resolveEnvFiles(ctx, state, nil, "docker"); err == nil {
+		t.Error("expected error")
+	}
+	if env, _, _, err := resolveEnvFiles(ctx, t.TempDir(), nil, md.EnvInjectFile); err != nil || env != nil {
+		t.Errorf("empty store: %q, %v", env, err)
+	}
+}
+
+func TestCmdExec(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell")
+	}
+	tmp := t.TempDir()
+	home := filepath.Join(tmp, "home")
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	t.Setenv("XDG_DATA_HOME", filepath.Join(home, ".local", "share"))
+	t.Setenv("XDG_STATE_HOME", filepath.Join(home, ".local", "state"))
+	t.Setenv("MD_ENGINE", "docker")
+	t.Setenv("DOCKER_HOST", "")
+	t.Setenv("DOCKER_CONTEXT", "")
+	// docker finds the container; ssh records its arguments and stdin, then
+	// exits with 3.
+	out := filepath.Join(tmp, "ssh.out")
+	bin := filepath.Join(tmp, "bin")
+	for name, script := range map[string]string{
+		"docker": "#!/bin/sh\nexit 0\n",
+		"ssh":    "#!/bin/sh\nprintf '%s\\n' \"$@\" > " + out + "\ncat >> " + out + "\nexit 3\n",
+	} {
+		if err := os.MkdirAll(bin, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(bin, name), []byte(script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	repo := filepath.Join(tmp, "repo")
+	for _, args := range [][]string{
+		{"init", "-q", "--initial-branch=main", repo},
+		{"-C", repo, "remote", "add", "md-repo-main", "x"},
+	} {
+		if b, err := exec.CommandContext(t.Context(), "git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, b)
+		}
+	}
+	sshConfigDir := filepath.Join(home, ".ssh", "config.d")
+	if err := os.MkdirAll(sshConfigDir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(sshConfigDir, "md-repo-main.conf"), []byte("Host md-repo-main\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	stdin := filepath.Join(tmp, "stdin")
+	if err := os.WriteFile(stdin, []byte("input\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	oldStdin := os.Stdin
+	os.Stdin = f
+	t.Cleanup(func() { os.Stdin = oldStdin })
+	t.Chdir(repo)
+
+	err = cmdExec(t.Context(), []string{"-t", "make", "a b"})
+	var ee *exitCodeError
+	if !errors.As(err, &ee) || ee.code != 3 {
+		t.Fatalf("got %v, want exit code 3", err)
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "-t\nmd-repo-main\ncd ~/src/repo && make 'a b'\ninput\n"
+	if got := string(b); got != want {
+		t.Errorf("ssh got:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestCmdStartDisplayValidation(t *testing.T) {
+	// The engine must not be reached: validation happens before any build.
+	t.Setenv("MD_ENGINE", "/nonexistent/engine")
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	for _, args := range [][]string{
+		{"--displays", "9"},
+		{"--displays", "0"},
+		{"--display-size", "1920"},
+	} {
+		err := cmdStart(t.Context(), args)
+		if err == nil || !strings.Contains(err.Error(), "display") {
+			t.Errorf("cmdStart(%q) = %v, want a display error", args, err)
+		}
+	}
+}
@@ -0,0 +1,675 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caic-xyz/md"
+)
+
+// md mcp serves the Model Context Protocol, JSON-RPC 2.0 messages, so an
+// agent can manage md containers through typed tools. Over stdio the
+// messages are one per line; over SSE the client receives them as events of
+// a GET stream and sends its own as POSTs to the endpoint the stream names.
+//
+// https://modelcontextprotocol.io/specification
+
+// mcpProtocolVersions are the protocol versions md mcp speaks, the latest
+// first.
+var mcpProtocolVersions = []string{"2025-06-18", "2025-03-26", "2024-11-05"}
+
+func cmdMCP(ctx context.Context, args []string) error {
+	fs := newFlagSet("mcp")
+	verbose := addVerboseFlag(fs)
+	sse := fs.String("sse", "", "Serve over HTTP with server-sent events on this loopback address, e.g. 127.0.0.1:8765, instead of stdin/stdout; requests need the bearer token it prints")
+	allow := fs.String("allow", "", "Comma separated tools to expose besides the read-only ones, or all: "+strings.Join(mcpToolNames(false), ", "))
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	initLogging(*verbose)
+	if err := checkArgs(fs, 0); err != nil {
+		return err
+	}
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	m, err := newMCPServer(c, *allow)
+	if err != nil {
+		return err
+	}
+	if m.approve, err = newApprover(c, "mcp", config.Approval); err != nil {
+		return err
+	}
+	if *sse != "" {
+		return m.serveSSE(ctx, *sse)
+	}
+	return m.serveStdio(ctx, os.Stdin, os.Stdout)
+}
+
+// mcpTool is a tool of md mcp.
+type mcpTool struct {
+	name        string
+	description string
+	// schema is the JSON schema of the arguments.
+	schema string
+	// readOnly tools don't change the containers or the host's repositories;
+	// they are always exposed.
+	readOnly bool
+	call     func(ctx context.Context, m *mcpServer, args json.RawMessage) (any, error)
+}
+
+// mcpContainerSchema is the schema of the tools acting on a container.
+const mcpContainerSchema = `{"type": "object", "properties": {"container": {"type": "string", "description": "Container name, as returned by list_containers"}}, "required": ["container"]}`
+
+// mcpRepoSchema is the schema of the tools acting on a repository of a
+// container.
+const mcpRepoSchema = `{"type": "object", "properties": {
+	"container": {"type": "string", "description": "Container name, as returned by list_containers"},
+	"repo": {"type": "string", "description": "Repository name, the primary one by default"}
+}, "required": ["container"]}`
+
+var mcpTools = []*mcpTool{
+	{
+		name:        "list_containers",
+		description: "Lists the md containers with their state, like md list.",
+		schema:      `{"type": "object", "properties": {}}`,
+		readOnly:    true,
+		call: func(ctx context.Context, m *mcpServer, _ json.RawMessage) (any, error) {
+			if err := m.approve.check(ctx, "list_containers", "", ""); err != nil {
+				return nil, err
+			}
+			containers, err := m.c.List(ctx)
+			if err != nil {
+				return nil, err
+			}
+			entries := make([]containerListEntry, len(containers))
+			for i, ct := range containers {
+				entries[i] = newContainerListEntry(ctx, ct, nil)
+			}
+			return entries, nil
+		},
+	},
+	{
+		name:        "status",
+		description: "Returns a container's status: repositories, commits ahead and behind, changed files, services.",
+		schema:      mcpContainerSchema,
+		readOnly:    true,
+		call: withMCPContainer("status", func(ctx context.Context, ct *md.Container, _ *mcpArgs) (any, error) {
+			return ct.Status(ctx)
+		}),
+	},
+	{
+		name:        "diff",
+		description: "Returns the patch of a container's repository against its base, like md diff.",
+		schema: `{"type": "object", "properties": {
+	"container": {"type": "string", "description": "Container name, as returned by list_containers"},
+	"repo": {"type": "string", "description": "Repository name, the primary one by default"},
+	"since": {"type": "string", "description": "Diff against this past state instead of base, like md diff --since: a duration, a time or a snapshot"}
+}, "required": ["container"]}`,
+		readOnly: true,
+		call: withMCPContainer("diff", func(ctx context.Context, ct *md.Container, a *mcpArgs) (any, error) {
+			i, err := repoByName(ct, a.Repo)
+			if err != nil {
+				return nil, err
+			}
+			var out, errOut bytes.Buffer
+			if err := ct.DiffSince(ctx, &out, &errOut, i, a.Since, nil); err != nil {
+				return nil, opError(err, &errOut)
+			}
+			return out.String(), nil
+		}),
+	},
+	{
+		name:        "logs",
+		description: "Returns the last lines of a container's output or of one of its services.",
+		schema: `{"type": "object", "properties": {
+	"container": {"type": "string", "description": "Container name, as returned by list_containers"},
+	"service": {"type": "string", "description": "Service name; the container's output by default"},
+	"lines": {"type": "integer", "description": "Number of lines, 100 by default, -1 for all"}
+}, "required": ["container"]}`,
+		readOnly: true,
+		call: withMCPContainer("logs", func(ctx context.Context, ct *md.Container, a *mcpArgs) (any, error) {
+			lines := 100
+			if a.Lines != nil {
+				lines = *a.Lines
+			}
+			var out bytes.Buffer
+			if err := ct.Logs(ctx, &out, &out, &md.LogsOpts{Service: a.Service, Lines: lines}); err != nil {
+				return nil, opError(err, &out)
+			}
+			return out.String(), nil
+		}),
+	},
+	{
+		name:        "start",
+		description: "Starts a container for host repositories, each cloned on its branch. The configured defaults apply.",
+		schema: `{"type": "object", "properties": {
+	"repos": {"type": "array", "description": "Repositories, the primary first", "items": {"type": "object", "properties": {
+		"path": {"type": "string", "description": "Absolute path in the repository on the host"},
+		"branch": {"type": "string", "description": "Branch, the repository's current one by default"}
+	}, "required": ["path"]}},
+	"display": {"type": "boolean", "description": "Start a virtual display"},
+	"browser": {"type": "boolean", "description": "Start a browser the agent can drive"},
+	"sudo": {"type": "string", "enum": ["full", "limited", "none"], "description": "Sudo policy"}
+}, "required": ["repos"]}`,
+		call: func(ctx context.Context, m *mcpServer, args json.RawMessage) (any, error) {
+			var req startRequest
+			if err := decodeMCPArgs(args, &req); err != nil {
+				return nil, err
+			}
+			var out bytes.Buffer
+			ct, err := m.startContainer(ctx, &req, &out)
+			if err != nil {
+				return nil, err
+			}
+			e := newContainerListEntry(ctx, ct, nil)
+			return &opResult{Container: &e, Output: out.String()}, nil
+		},
+	},
+	{
+		name:        "run_command_in_container",
+		description: "Runs a shell command in a running container, from its primary repository, and returns its exit code and combined output.",
+		schema: `{"type": "object", "properties": {
+	"container": {"type": "string", "description": "Container name, as returned by list_containers"},
+	"command": {"type": "string", "description": "Shell command, which may contain pipes and redirections"}
+}, "required": ["container", "command"]}`,
+		call: withMCPContainer("run_command_in_container", func(ctx context.Context, ct *md.Container, a *mcpArgs) (any, error) {
+			if a.Command == "" {
+				return nil, errors.New("command is required")
+			}
+			var out bytes.Buffer
+			code, err := ct.Exec(ctx, nil, &out, &out, []string{a.Command}, false)
+			agentFinished(ctx, ct, []string{a.Command}, code, err, false)
+			if err != nil {
+				return nil, opError(err, &out)
+			}
+			return &mcpRunResult{ExitCode: code, Output: out.String()}, nil
+		}),
+	},
+	{
+		name:        "push",
+		description: "Pushes the host's branch into a container's repository, like md push.",
+		schema:      mcpRepoSchema,
+		call: withMCPContainer("push", func(ctx context.Context, ct *md.Container, a *mcpArgs) (any, error) {
+			i, err := repoByName(ct, a.Repo)
+			if err != nil {
+				return nil, err
+			}
+			var out bytes.Buffer
+			res, err := ct.Push(ctx, &out, &out, i, nil)
+			if err != nil {
+				return nil, opError(err, &out)
+			}
+			return &opResult{Backup: res.Backup, Stat: res.Stat, Output: out.String()}, nil
+		}),
+	},
+	{
+		name:        "pull",
+		description: "Pulls a container repository's changes into the host's branch, like md pull.",
+		schema:      mcpRepoSchema,
+		call: withMCPContainer("pull", func(ctx context.Context, ct *md.Container, a *mcpArgs) (any, error) {
+			i, err := repoByName(ct, a.Repo)
+			if err != nil {
+				return nil, err
+			}
+			p, err := newProvider(ctx, os.Getenv("ASK_PROVIDER"), os.Getenv("ASK_MODEL"))
+			if err != nil {
+				slog.WarnContext(ctx, "md", "msg", "failed to initialize provider", "err", err)
+			}
+			var out bytes.Buffer
+			res, err := ct.Pull(ctx, &out, &out, i, &md.PullOpts{Provider: p})
+			if err != nil {
+				return nil, opError(err, &out)
+			}
+			pulled(ctx, ct, i)
+			return &opResult{Stat: res.Stat, Output: out.String()}, nil
+		}),
+	},
+	{
+		name:        "kill",
+		description: "Deletes a container and its unpulled changes, like md kill.",
+		schema:      mcpContainerSchema,
+		call: withMCPContainer("kill", func(ctx context.Context, ct *md.Container, _ *mcpArgs) (any, error) {
+			var out bytes.Buffer
+			if err := ct.Purge(ctx, &out, &out); err != nil {
+				return nil, opError(err, &out)
+			}
+			notify(ctx, md.NewEvent(md.EventKill, ct))
+			return &opResult{Output: out.String()}, nil
+		}),
+	},
+}
+
+// mcpToolNames returns the names of the tools that are readOnly or not.
+func mcpToolNames(readOnly bool) []string {
+	var names []string
+	for _, t := range mcpTools {
+		if t.readOnly == readOnly {
+			names = append(names, t.name)
+		}
+	}
+	return names
+}
+
+// mcpArgs are the arguments of the tools acting on a container.
+type mcpArgs struct {
+	Container string `json:"container"`
+	Repo      string `json:"repo"`
+	Since     string `json:"since"`
+	Service   string `json:"service"`
+	Lines     *int   `json:"lines"`
+	Command   string `json:"command"`
+}
+
+// mcpRunResult is the result of run_command_in_container.
+type mcpRunResult struct {
+	ExitCode int    `json:"exit_code"`
+	Output   string `json:"output"`
+}
+
+// mcpDetails describes the arguments of an operation to approve.
+func mcpDetails(a *mcpArgs) string {
+	var d []string
+	if a.Repo != "" {
+		d = append(d, "repo "+a.Repo)
+	}
+	if a.Command != "" {
+		d = append(d, a.Command)
+	}
+	return strings.Join(d, ", ")
+}
+
+// decodeMCPArgs decodes a tool's arguments into v, rejecting unknown ones.
+func decodeMCPArgs(args json.RawMessage, v any) error {
+	if len(args) == 0 {
+		args = json.RawMessage("{}")
+	}
+	dec := json.NewDecoder(bytes.NewReader(args))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("invalid arguments: %w", err)
+	}
+	return nil
+}
+
+// withMCPContainer returns a tool call resolving the container argument,
+// locking it for the duration of f and having the operation op approved.
+func withMCPContainer(op string, f func(ctx context.Context, ct *md.Container, a *mcpArgs) (any, error)) func(context.Context, *mcpServer, json.RawMessage) (any, error) {
+	return func(ctx context.Context, m *mcpServer, args json.RawMessage) (any, error) {
+		var a mcpArgs
+		if err := decodeMCPArgs(args, &a); err != nil {
+			return nil, err
+		}
+		if a.Container == "" {
+			return nil, errors.New("container is required")
+		}
+		defer m.lock(a.Container)()
+		ct, err := m.find(ctx, a.Container)
+		if err != nil {
+			return nil, err
+		}
+		if err := m.approve.check(ctx, op, a.Container, mcpDetails(&a)); err != nil {
+			return nil, err
+		}
+		return f(ctx, ct, &a)
+	}
+}
+
+// mcpServer handles the MCP messages. The tools share md serve's
+// implementation, per-container locks included.
+type mcpServer struct {
+	*server
+	// tools are the exposed tools, by name.
+	tools map[string]*mcpTool
+}
+
+// newMCPServer returns the server exposing the read-only tools plus the
+// comma separated allow list, "all" allowing them all.
+func newMCPServer(c *md.Client, allow string) (*mcpServer, error) {
+	m := &mcpServer{server: newServer(c), tools: map[string]*mcpTool{}}
+	byName := map[string]*mcpTool{}
+	for _, t := range mcpTools {
+		byName[t.name] = t
+		if t.readOnly || allow == "all" {
+			m.tools[t.name] = t
+		}
+	}
+	if allow == "all" {
+		return m, nil
+	}
+	for name := range strings.SplitSeq(allow, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		t := byName[name]
+		if t == nil {
+			return nil, fmt.Errorf("unknown tool %q: use %s or all", name, strings.Join(slices.Sorted(maps.Keys(byName)), ", "))
+		}
+		m.tools[name] = t
+	}
+	return m, nil
+}
+
+// JSON-RPC 2.0 error codes.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+)
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// handle answers the JSON-RPC message msg. It returns nil for notifications,
+// which have no response.
+func (m *mcpServer) handle(ctx context.Context, msg []byte) *rpcResponse {
+	var req rpcRequest
+	if err := json.Unmarshal(msg, &req); err != nil {
+		return &rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{rpcParseError, err.Error()}}
+	}
+	if len(req.ID) == 0 {
+		// Notifications, like notifications/initialized, need no action.
+		return nil
+	}
+	resp := &rpcResponse{JSONRPC: "2.0", ID: req.ID}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		resp.Error = &rpcError{rpcInvalidRequest, "not a JSON-RPC 2.0 request"}
+		return resp
+	}
+	var err *rpcError
+	resp.Result, err = m.call(ctx, req.Method, req.Params)
+	if err != nil {
+		resp.Result = nil
+		resp.Error = err
+	}
+	return resp
+}
+
+// call runs the method with params.
+func (m *mcpServer) call(ctx context.Context, method string, params json.RawMessage) (any, *rpcError) {
+	switch method {
+	case "initialize":
+		var p struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		}
+		_ = json.Unmarshal(params, &p)
+		v := mcpProtocolVersions[0]
+		if slices.Contains(mcpProtocolVersions, p.ProtocolVersion) {
+			v = p.ProtocolVersion
+		}
+		return map[string]any{
+			"protocolVersion": v,
+			"capabilities":    map[string]any{"tools": map[string]any{}},
+			"serverInfo":      map[string]string{"name": "md", "version": version()},
+		}, nil
+	case "ping":
+		return map[string]any{}, nil
+	case "tools/list":
+		tools := []map[string]any{}
+		for _, t := range mcpTools {
+			if m.tools[t.name] == nil {
+				continue
+			}
+			tools = append(tools, map[string]any{
+				"name":        t.name,
+				"description": t.description,
+				"inputSchema": json.RawMessage(t.schema),
+				"annotations": map[string]bool{"readOnlyHint": t.readOnly, "destructiveHint": !t.readOnly},
+			})
+		}
+		return map[string]any{"tools": tools}, nil
+	case "tools/call":
+		var p struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &rpcError{rpcInvalidParams, err.Error()}
+		}
+		t := m.tools[p.Name]
+		if t == nil {
+			if slices.ContainsFunc(mcpTools, func(t *mcpTool) bool { return t.name == p.Name }) {
+				return nil, &rpcError{rpcInvalidParams, fmt.Sprintf("tool %s is not allowed: start md mcp with -allow %s", p.Name, p.Name)}
+			}
+			return nil, &rpcError{rpcInvalidParams, fmt.Sprintf("unknown tool %s", p.Name)}
+		}
+		return mcpToolResult(t.call(ctx, m, p.Arguments)), nil
+	}
+	return nil, &rpcError{rpcMethodNotFound, fmt.Sprintf("unknown method %s", method)}
+}
+
+// mcpToolResult returns the tools/call result of a tool's value or error.
+// Strings are returned as is, other values as JSON.
+func mcpToolResult(v any, err error) map[string]any {
+	var text string
+	switch {
+	case err != nil:
+		text = err.Error()
+	case v == nil:
+	default:
+		if s, ok := v.(string); ok {
+			text = s
+		} else if b, err2 := json.MarshalIndent(v, "", "  "); err2 != nil {
+			err, text = err2, err2.Error()
+		} else {
+			text = string(b)
+		}
+	}
+	return map[string]any{
+		"content": []map[string]string{{"type": "text", "text": text}},
+		"isError": err != nil,
+	}
+}
+
+// serveStdio serves the messages read from r, one per line, writing the
+// responses to w. Requests are handled concurrently, a start doesn't block a
+// list.
+func (m *mcpServer) serveStdio(ctx context.Context, r io.Reader, w io.Writer) error {
+	dec := json.NewDecoder(r)
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		var msg json.RawMessage
+		if err := dec.Decode(&msg); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("reading MCP messages: %w", err)
+		}
+		wg.Go(func() {
+			if resp := m.handle(ctx, msg); resp != nil {
+				mu.Lock()
+				defer mu.Unlock()
+				if err := enc.Encode(resp); err != nil {
+					slog.WarnContext(ctx, "md", "msg", "writing MCP response", "err", err)
+				}
+			}
+		})
+	}
+}
+
+// serveSSE serves the MCP messages over HTTP on the loopback address addr:
+// GET /sse streams the responses as server-sent events, the first, named
+// endpoint, giving the URL to POST the requests to. Any local user can
+// connect to a loopback port, so every request must carry the bearer token
+// generated for this run and printed once.
+func (m *mcpServer) serveSSE(ctx context.Context, addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("-sse %s: only loopback addresses are allowed", addr)
+	}
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return err
+	}
+	token := hex.EncodeToString(b[:])
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{
+		Handler:           checkLoopbackHost(checkBearer(token, m.sseHandler(ctx))),
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+	go func() {
+		<-ctx.Done()
+		sctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(sctx)
+	}()
+	fmt.Printf("Serving MCP on http://%s/sse\nSend the header: Authorization: Bearer %s\n", ln.Addr(), token)
+	if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// sseHandler returns the routes of the SSE transport. The messages are
+// handled with ctx: the POST that carried one returns before its response is
+// sent on the stream.
+func (m *mcpServer) sseHandler(ctx context.Context) http.Handler {
+	type session struct {
+		ch chan *rpcResponse
+		// done is closed when the stream ends.
+		done chan struct{}
+	}
+	var mu sync.Mutex
+	sessions := map[string]*session{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /sse", func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+		var b [16]byte
+		_, _ = rand.Read(b[:])
+		id := hex.EncodeToString(b[:])
+		sess := &session{ch: make(chan *rpcResponse), done: make(chan struct{})}
+		mu.Lock()
+		sessions[id] = sess
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			delete(sessions, id)
+			mu.Unlock()
+			close(sess.done)
+		}()
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		_, _ = fmt.Fprintf(w, "event: endpoint\ndata: /message?session=%s\n\n", id)
+		flusher.Flush()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case resp := <-sess.ch:
+				b, err := json.Marshal(resp)
+				if err != nil {
+					continue
+				}
+				_, _ = fmt.Fprintf(w, "event: message\ndata: %s\n\n", b)
+				flusher.Flush()
+			}
+		}
+	})
+	mux.HandleFunc("POST /message", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		sess := sessions[r.URL.Query().Get("session")]
+		mu.Unlock()
+		if sess == nil {
+			http.Error(w, "unknown session", http.StatusNotFound)
+			return
+		}
+		msg, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		go func() {
+			if resp := m.handle(ctx, msg); resp != nil {
+				select {
+				case sess.ch <- resp:
+				case <-sess.done:
+				}
+			}
+		}()
+	})
+	return mux
+}
+
+// checkBearer rejects the requests without the header
+// "Authorization: Bearer <token>".
+func checkBearer(token string, h http.Handler) http.Handler {
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "missing or invalid bearer token", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// checkLoopbackHost rejects the requests whose Host header isn't a loopback
+// address, so a web page can't reach the server through DNS rebinding.
+func checkLoopbackHost(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			http.Error(w, "forbidden host "+strconv.Quote(r.Host), http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,147 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/caic-xyz/md"
+)
+
+func TestMCPToolSchemas(t *testing.T) {
+	for _, tool := range mcpTools {
+		var v map[string]any
+		if err := json.Unmarshal([]byte(tool.schema), &v); err != nil || v["type"] != "object" {
+			t.Errorf("%s: invalid schema: %v", tool.name, err)
+		}
+	}
+}
+
+func TestNewMCPServer(t *testing.T) {
+	m, err := newMCPServer(&md.Client{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.tools["list_containers"] == nil || m.tools["start"] != nil {
+		t.Errorf("default tools: %v", m.tools)
+	}
+	if m, err = newMCPServer(&md.Client{}, "pull, start"); err != nil {
+		t.Fatal(err)
+	}
+	if m.tools["start"] == nil || m.tools["pull"] == nil || m.tools["kill"] != nil {
+		t.Errorf("allowed tools: %v", m.tools)
+	}
+	if m, err = newMCPServer(&md.Client{}, "all"); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.tools) != len(mcpTools) {
+		t.Errorf("all tools: %v", m.tools)
+	}
+	if _, err := newMCPServer(&md.Client{}, "rm"); err == nil || !strings.Contains(err.Error(), "unknown tool") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestMCPHandle(t *testing.T) {
+	m, err := newMCPServer(&md.Client{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		req  string
+		want string
+	}{
+		{`{"jsonrpc": "2.0", "id": 1, "method": "initialize", "params": {"protocolVersion": "2025-03-26"}}`, `"protocolVersion":"2025-03-26"`},
+		{`{"jsonrpc": "2.0", "id": 2, "method": "initialize", "params": {"protocolVersion": "1999-01-01"}}`, `"protocolVersion":"` + mcpProtocolVersions[0] + `"`},
+		{`{"jsonrpc": "2.0", "id": 3, "method": "ping"}`, `"result":{}`},
+		{`{"jsonrpc": "2.0", "id": 4, "method": "tools/list"}`, `"name":"list_containers"`},
+		{`{"jsonrpc": "2.0", "id": 5, "method": "tools/call", "params": {"name": "start", "arguments": {}}}`, "start md mcp with -allow start"},
+		{`{"jsonrpc": "2.0", "id": 6, "method": "tools/call", "params": {"name": "rm"}}`, "unknown tool rm"},
+		{`{"jsonrpc": "2.0", "id": 7, "method": "tools/call", "params": {"name": "status", "arguments": {}}}`, `"isError":true`},
+		{`{"jsonrpc": "2.0", "id": 8, "method": "tools/call", "params": {"name": "status", "arguments": {"bogus": 1}}}`, "invalid arguments"},
+		{`{"jsonrpc": "2.0", "id": "x", "method": "resources/list"}`, `"code":-32601`},
+		{`{"jsonrpc": "1.0", "id": 9, "method": "ping"}`, `"code":-32600`},
+		{`{`, `"code":-32700`},
+	} {
+		resp := m.handle(t.Context(), []byte(tc.req))
+		b, err := json.Marshal(resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(b), tc.want) {
+			t.Errorf("%s:\ngot  %s\nwant %s", tc.req, b, tc.want)
+		}
+	}
+	if resp := m.handle(t.Context(), []byte(`{"jsonrpc": "2.0", "method": "notifications/initialized"}`)); resp != nil {
+		t.Errorf("notification answered: %+v", resp)
+	}
+	// Only the exposed tools are listed.
+	b, _ := json.Marshal(m.handle(t.Context(), []byte(`{"jsonrpc": "2.0", "id": 1, "method": "tools/list"}`)))
+	if strings.Contains(string(b), `"name":"kill"`) {
+		t.Errorf("kill listed: %s", b)
+	}
+}
+
+func TestMCPServeStdio(t *testing.T) {
+	m, err := newMCPServer(&md.Client{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := strings.NewReader(`{"jsonrpc": "2.0", "id": 1, "method": "ping"}` + "\n" +
+		`{"jsonrpc": "2.0", "method": "notifications/initialized"}` + "\n")
+	var out bytes.Buffer
+	if err := m.serveStdio(t.Context(), in, &out); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != `{"jsonrpc":"2.0","id":1,"result":{}}`+"\n" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestCheckLoopbackHost(t *testing.T) {
+	h := checkLoopbackHost(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	for host, want := range map[string]int{
+		"127.0.0.1:8765":    http.StatusOK,
+		"localhost:8765":    http.StatusOK,
+		"[::1]:8765":        http.StatusOK,
+		"evil.example:8765": http.StatusForbidden,
+		"192.168.1.2":       http.StatusForbidden,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/sse", nil)
+		req.Host = host
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf("%s: got %d, want %d", host, w.Code, want)
+		}
+	}
+}
+
+func TestCheckBearer(t *testing.T) {
+	h := checkBearer("secret", http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	for auth, want := range map[string]int{
+		"Bearer secret":  http.StatusOK,
+		"":               http.StatusUnauthorized,
+		"Bearer":         http.StatusUnauthorized,
+		"Bearer secret2": http.StatusUnauthorized,
+		"Bearer SECRET":  http.StatusUnauthorized,
+		"Basic secret":   http.StatusUnauthorized,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/message?session=x", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf("%q: got %d, want %d", auth, w.Code, want)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caic-xyz/md"
+)
+
+// runJobCommands are the md run subcommands acting on recorded runs.
+var runJobCommands = []string{"list", "logs", "attach", "wait"}
+
+// runIDEnv passes the run's ID to the md process of a detached run.
+const runIDEnv = "MD_RUN_ID"
+
+// runJob is the record of the md run in progress in this process.
+type runJob struct {
+	log *md.RunLog
+	// output is the run's output.log.
+	output *os.File
+	// detached is set in the md process of a detached run, whose stdout and
+	// stderr are output.
+	detached bool
+	finished bool
+}
+
+// startRunJob records the run of command in ct, or opens the record of
+// the detached run id created by detachRun.
+func startRunJob(ct *md.Container, id string, command []string) (*runJob, error) {
+	j := &runJob{detached: id != ""}
+	var err error
+	if j.detached {
+		if j.log, err = md.OpenRunLog(ct.XDGStateHome, id); err == nil {
+			err = j.log.Update(func(r *md.RunRecord) { r.PID = os.Getpid() })
+		}
+	} else {
+		id = md.NewRunID()
+		j.log, err = md.CreateRunLog(ct.XDGStateHome, &md.RunRecord{ID: id, Command: command, Container: ct.RunName(id), PID: os.Getpid()})
+	}
+	if err != nil {
+		return nil, err
+	}
+	if j.output, err = os.OpenFile(j.log.OutputPath(), os.O_WRONLY|os.O_APPEND, 0); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// writers returns the run's stdout and stderr, which also go to its
+// output.log.
+func (j *runJob) writers(out *output) (stdout, stderr io.Writer) {
+	if j.detached {
+		return out.progress(), os.Stderr
+	}
+	return io.MultiWriter(out.progress(), j.output), io.MultiWriter(os.Stderr, j.output)
+}
+
+// finish records the end of the run.
+func (j *runJob) finish(ctx context.Context, res *md.RunResult, err error) {
+	j.finished = true
+	if err := j.log.Finish(res, err); err != nil {
+		slog.WarnContext(ctx, "md", "msg", "recording the run", "id", j.log.ID(), "err", err)
+	}
+}
+
+// close records err as the run's failure unless it finished.
+func (j *runJob) close(err error) {
+	if !j.finished {
+		if err == nil {
+			err = errors.New("md exited before running the command")
+		}
+		_ = j.log.Finish(nil, err)
+	}
+	_ = j.output.Close()
+}
+
+// detachRun starts the md process of a detached run of command in ct, its
+// output going to the run's output.log, and prints the run's ID.
+func detachRun(out *output, ct *md.Container, command []string) error {
+	id := md.NewRunID()
+	log, err := md.CreateRunLog(ct.XDGStateHome, &md.RunRecord{ID: id, Command: command, Container: ct.RunName(id), Detached: true})
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(log.OutputPath(), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	// The same command line, global flags included; -detach is ignored with
+	// the ID set.
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), runIDEnv+"="+id)
+	cmd.Stdout, cmd.Stderr = f, f
+	detachProcess(cmd)
+	if err := cmd.Start(); err != nil {
+		_ = log.Finish(nil, err)
+		return err
+	}
+	if err := log.Update(func(r *md.RunRecord) { r.PID = cmd.Process.Pid }); err != nil {
+		return err
+	}
+	_ = cmd.Process.Release()
+	r := &runDetached{ID: id, Container: ct.RunName(id), Output: log.OutputPath()}
+	return out.print(r, func() {
+		fmt.Println(id)
+		fmt.Fprintf(os.Stderr, "Running in %s; follow with md run attach %s\n", r.Container, id)
+	})
+}
+
+// runDetached is md run -detach's result with -json or -porcelain.
+type runDetached struct {
+	ID        string `json:"id"`
+	Container string `json:"container"`
+	Output    string `json:"output"`
+}
+
+func (r *runDetached) porcelain() [][]string {
+	return [][]string{{"id", r.ID}, {"container", r.Container}, {"output", r.Output}}
+}
+
+// cmdRunJob implements md run list, logs, attach and wait.
+func cmdRunJob(ctx context.Context, name string, args []string) error {
+	fs := newFlagSet("run " + name)
+	verbose := addVerboseFlag(fs)
+	var out *output
+	if name == "list" || name == "wait" {
+		out = addOutputFlags(fs)
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	initLogging(*verbose)
+	n := 1
+	if name == "list" {
+		n = 0
+	}
+	if err := checkArgs(fs, n); err != nil {
+		return err
+	}
+	if out != nil {
+		if err := out.check(); err != nil {
+			return err
+		}
+	}
+	c, err := md.New(os.Stderr)
+	if err != nil {
+		return err
+	}
+	switch name {
+	case "list":
+		recs, err := md.ListRuns(c.XDGStateHome)
+		if err != nil {
+			return err
+		}
+		return out.print(runList(recs), func() { printRuns(recs) })
+	case "logs":
+		_, err := md.FollowRun(ctx, c.XDGStateHome, fs.Arg(0), os.Stdout, false)
+		return err
+	case "attach":
+		rec, err := md.FollowRun(ctx, c.XDGStateHome, fs.Arg(0), os.Stdout, true)
+		if err != nil {
+			return err
+		}
+		return runOutcome(rec)
+	default:
+		rec, err := md.FollowRun(ctx, c.XDGStateHome, fs.Arg(0), io.Discard, true)
+		if err != nil {
+			return err
+		}
+		if err := out.print((*runRecord)(rec), func() { fmt.Printf("%s: %s\n", rec.ID, runSummary(rec)) }); err != nil {
+			return err
+		}
+		return runOutcome(rec)
+	}
+}
+
+// runOutcome returns the error md run returned, or would have, for rec.
+func runOutcome(rec *md.RunRecord) error {
+	switch rec.State {
+	case md.RunExited:
+		if rec.ExitCode != 0 {
+			return &exitCodeError{code: rec.ExitCode}
+		}
+		return nil
+	case md.RunFailed:
+		return errors.New(rec.Error)
+	default:
+		return fmt.Errorf("md stopped before recording the end of run %s; its container %s may be left: check md list", rec.ID, rec.Container)
+	}
+}
+
+// runSummary describes rec's state for humans.
+func runSummary(rec *md.RunRecord) string {
+	switch rec.State {
+	case md.RunExited:
+		s := "exit code " + strconv.Itoa(rec.ExitCode)
+		if rec.Branch != "" {
+			s += ", results on branch " + rec.Branch
+		}
+		return s
+	case md.RunFailed:
+		return "failed: " + rec.Error
+	case md.RunLost:
+		return "lost, container " + rec.Container + " may be left"
+	}
+	return "running for " + time.Since(rec.Started).Round(time.Second).String()
+}
+
+func printRuns(recs []*md.RunRecord) {
+	if len(recs) == 0 {
+		fmt.Println("No md runs")
+		return
+	}
+	fmt.Printf("%-8s  %-16s  %-30s  %s\n", "ID", "Started", "Command", "State")
+	fmt.Println(strings.Repeat("-", 80))
+	for _, r := range recs {
+		cmd := strings.Join(r.Command, " ")
+		if len(cmd) > 30 {
+			cmd = cmd[:27] + "..."
+		}
+		fmt.Printf("%-8s  %-16s  %-30s  %s\n", r.ID, r.Started.Local().Format("2006-01-02 15:04"), cmd, runSummary(r))
+	}
+}
+
+// runRecord is md run wait's result with -json or -porcelain.
+type runRecord md.RunRecord
+
+func (r *runRecord) porcelain() [][]string {
+	lines := [][]string{
+		{"id", r.ID},
+		{"container", r.Container},
+		{"state", r.State},
+		{"exit_code", strconv.Itoa(r.ExitCode)},
+	}
+	if r.Branch != "" {
+		lines = append(lines, []string{"branch", r.Branch})
+	}
+	if r.Error != "" {
+		lines = append(lines, []string{"error", r.Error})
+	}
+	return lines
+}
+
+// runList is md run list's result with -json or -porcelain.
+type runList []*md.RunRecord
+
+func (l runList) porcelain() [][]string {
+	lines := make([][]string, len(l))
+	for i, r := range l {
+		lines[i] = []string{r.ID, r.State, strconv.Itoa(r.ExitCode), r.Container, strings.Join(r.Command, " ")}
+	}
+	return lines
+}
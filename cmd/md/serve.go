@@ -0,0 +1,458 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caic-xyz/md"
+	"github.com/caic-xyz/md/gitutil"
+)
+
+// serveSocket returns the default socket of md serve.
+func serveSocket(c *md.Client) string {
+	return filepath.Join(c.XDGStateHome, "md", "md.sock")
+}
+
+func cmdServe(ctx context.Context, args []string) error {
+	fs := newFlagSet("serve")
+	verbose := addVerboseFlag(fs)
+	socket := fs.String("socket", "", "Unix socket to listen on (default: $XDG_STATE_HOME/md/md.sock)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	initLogging(*verbose)
+	if err := checkArgs(fs, 0); err != nil {
+		return err
+	}
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	path := *socket
+	if path == "" {
+		path = serveSocket(c)
+	}
+	ln, err := listenUnix(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(path) }()
+	s := newServer(c)
+	if s.approve, err = newApprover(c, "serve", config.Approval); err != nil {
+		return err
+	}
+	srv := &http.Server{
+		Handler:           s.handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+	go func() {
+		<-ctx.Done()
+		sctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(sctx)
+	}()
+	fmt.Printf("Serving the md API on %s\n", path)
+	if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// listenUnix listens on the unix socket path, which only the user can
+// connect to: its directory is private and the socket is created without
+// access for others (listenPrivate), then set to mode 0600. A socket left by
+// a dead server is replaced.
+func listenUnix(path string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("md serve is already listening on %s", path)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	ln, err := listenPrivate(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		_ = ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// server implements the md serve API.
+type server struct {
+	c *md.Client
+	// approve confirms the operations with the user. Nil approves them all.
+	approve *approver
+
+	mu sync.Mutex
+	// locks serializes the operations on each container, by name.
+	locks map[string]*sync.Mutex
+}
+
+func newServer(c *md.Client) *server {
+	return &server{c: c, locks: map[string]*sync.Mutex{}}
+}
+
+// handler returns the API's routes.
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/containers", s.list)
+	mux.HandleFunc("POST /v1/containers", s.start)
+	mux.HandleFunc("GET /v1/containers/{name}", s.withContainer("status", s.status))
+	mux.HandleFunc("DELETE /v1/containers/{name}", s.withContainer("kill", s.purge))
+	mux.HandleFunc("POST /v1/containers/{name}/stop", s.withContainer("stop", s.stop))
+	mux.HandleFunc("POST /v1/containers/{name}/resume", s.withContainer("resume", s.resume))
+	mux.HandleFunc("POST /v1/containers/{name}/push", s.withContainer("push", s.push))
+	mux.HandleFunc("POST /v1/containers/{name}/pull", s.withContainer("pull", s.pull))
+	mux.HandleFunc("GET /v1/containers/{name}/diff", s.withContainer("diff", s.diff))
+	mux.HandleFunc("GET /v1/containers/{name}/logs", s.withContainer("logs", s.logs))
+	return mux
+}
+
+// lock locks the container name and returns the unlock function.
+func (s *server) lock(name string) func() {
+	s.mu.Lock()
+	l := s.locks[name]
+	if l == nil {
+		l = &sync.Mutex{}
+		s.locks[name] = l
+	}
+	s.mu.Unlock()
+	l.Lock()
+	return l.Unlock
+}
+
+// apiError is an error with its HTTP status.
+type apiError struct {
+	code int
+	err  error
+}
+
+func (e *apiError) Error() string { return e.err.Error() }
+
+// opResult is the result of an operation: the output md prints on the
+// command line and, for md start and push, the container or backup branch.
+type opResult struct {
+	Container *containerListEntry `json:"container,omitempty"`
+	Backup    string              `json:"backup,omitempty"`
+	Stat      *md.DiffStat        `json:"stat,omitempty"`
+	Output    string              `json:"output"`
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	var ae *apiError
+	if errors.As(err, &ae) {
+		code = ae.code
+	}
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+// withContainer resolves the {name} of the route, locks it, has the
+// operation op approved and calls f.
+func (s *server) withContainer(op string, f func(http.ResponseWriter, *http.Request, *md.Container) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		defer s.lock(name)()
+		ct, err := s.find(r.Context(), name)
+		if err == nil {
+			err = s.approve.check(r.Context(), op, name, r.URL.RawQuery)
+		}
+		if err == nil {
+			err = f(w, r, ct)
+		}
+		if err != nil {
+			writeError(w, err)
+		}
+	}
+}
+
+// find returns the container name, a 404 error if there is none.
+func (s *server) find(ctx context.Context, name string) (*md.Container, error) {
+	containers, err := s.c.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, ct := range containers {
+		if ct.Name == name {
+			return ct, nil
+		}
+	}
+	return nil, &apiError{http.StatusNotFound, fmt.Errorf("no container named %s", name)}
+}
+
+// repoIndex returns the index of the repository in the "repo" query
+// parameter, the primary one by default.
+func repoIndex(r *http.Request, ct *md.Container) (int, error) {
+	i, err := repoByName(ct, r.URL.Query().Get("repo"))
+	if err != nil {
+		return 0, &apiError{http.StatusBadRequest, err}
+	}
+	return i, nil
+}
+
+// repoByName returns the index of ct's repository name, the primary one when
+// name is empty.
+func repoByName(ct *md.Container, name string) (int, error) {
+	if name == "" {
+		if len(ct.Repos) == 0 {
+			return 0, fmt.Errorf("%s has no repos", ct.Name)
+		}
+		return 0, nil
+	}
+	return ct.RepoIndex(name)
+}
+
+func (s *server) list(w http.ResponseWriter, r *http.Request) {
+	if err := s.approve.check(r.Context(), "list_containers", "", ""); err != nil {
+		writeError(w, err)
+		return
+	}
+	containers, err := s.c.List(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	entries := make([]containerListEntry, len(containers))
+	for i, ct := range containers {
+		entries[i] = newContainerListEntry(r.Context(), ct, nil)
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// startRequest is the body of POST /v1/containers. The configured defaults
+// apply, as for md ws start.
+type startRequest struct {
+	// Repos are the repositories, the primary first. Path is an absolute
+	// path in the repository; Branch defaults to its current branch.
+	Repos []struct {
+		Path   string `json:"path"`
+		Branch string `json:"branch,omitempty"`
+	} `json:"repos"`
+	Display bool   `json:"display,omitempty"`
+	Browser bool   `json:"browser,omitempty"`
+	Sudo    string `json:"sudo,omitempty"`
+}
+
+func (s *server) start(w http.ResponseWriter, r *http.Request) {
+	if err := s.startImpl(w, r); err != nil {
+		writeError(w, err)
+	}
+}
+
+func (s *server) startImpl(w http.ResponseWriter, r *http.Request) error {
+	var req startRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		return &apiError{http.StatusBadRequest, fmt.Errorf("invalid request: %w", err)}
+	}
+	var out bytes.Buffer
+	ct, err := s.startContainer(r.Context(), &req, &out)
+	if err != nil {
+		return err
+	}
+	e := newContainerListEntry(r.Context(), ct, nil)
+	writeJSON(w, http.StatusCreated, &opResult{Container: &e, Output: out.String()})
+	return nil
+}
+
+// startContainer starts the container of req, writing md's progress output
+// to out.
+func (s *server) startContainer(ctx context.Context, req *startRequest, out *bytes.Buffer) (*md.Container, error) {
+	var repos []md.Repo
+	for _, rr := range req.Repos {
+		if !filepath.IsAbs(rr.Path) {
+			return nil, &apiError{http.StatusBadRequest, fmt.Errorf("repo path %q must be absolute", rr.Path)}
+		}
+		root, err := repoRoot(ctx, rr.Path)
+		if err != nil {
+			return nil, &apiError{http.StatusBadRequest, fmt.Errorf("repo %s: %w", rr.Path, err)}
+		}
+		branch := rr.Branch
+		if branch == "" {
+			if branch, err = gitutil.CurrentBranch(ctx, root); err != nil {
+				return nil, &apiError{http.StatusBadRequest, fmt.Errorf("repo %s: %w", root, err)}
+			}
+		}
+		repos = append(repos, md.Repo{GitRoot: root, Branch: branch})
+	}
+	sudo, err := md.ParseSudoPolicy(req.Sudo)
+	if err != nil {
+		return nil, &apiError{http.StatusBadRequest, err}
+	}
+	ct := s.c.Container(repos...)
+	defer s.lock(ct.Name)()
+	if _, err := s.find(ctx, ct.Name); err == nil {
+		return nil, &apiError{http.StatusConflict, fmt.Errorf("%s already exists", ct.Name)}
+	}
+	if err := s.approve.check(ctx, "start", ct.Name, startDetails(repos)); err != nil {
+		return nil, err
+	}
+	opts := workspaceStartOpts()
+	opts.Display = req.Display
+	opts.Browser = req.Browser
+	opts.Sudo = sudo
+	if err := ct.Launch(ctx, out, out, &opts); err != nil {
+		return nil, opError(err, out)
+	}
+	if _, err := ct.Connect(ctx, out, out, &opts); err != nil {
+		return nil, opError(err, out)
+	}
+	notify(ctx, md.NewEvent(md.EventStart, ct))
+	return ct, nil
+}
+
+// startDetails describes the repositories of a start to approve.
+func startDetails(repos []md.Repo) string {
+	s := make([]string, len(repos))
+	for i, r := range repos {
+		s[i] = r.GitRoot + ":" + r.Branch
+	}
+	return strings.Join(s, " ")
+}
+
+// opError adds the output of the failed operation to err.
+func opError(err error, out *bytes.Buffer) error {
+	if out.Len() == 0 {
+		return err
+	}
+	return fmt.Errorf("%w\n%s", err, out.String())
+}
+
+func (s *server) status(w http.ResponseWriter, r *http.Request, ct *md.Container) error {
+	st, err := ct.Status(r.Context())
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, st)
+	return nil
+}
+
+func (s *server) purge(w http.ResponseWriter, r *http.Request, ct *md.Container) error {
+	var out bytes.Buffer
+	if err := ct.Purge(r.Context(), &out, &out); err != nil {
+		return opError(err, &out)
+	}
+	notify(r.Context(), md.NewEvent(md.EventKill, ct))
+	writeJSON(w, http.StatusOK, &opResult{Output: out.String()})
+	return nil
+}
+
+func (s *server) stop(w http.ResponseWriter, r *http.Request, ct *md.Container) error {
+	if err := ct.Stop(r.Context()); err != nil {
+		return &apiError{http.StatusConflict, err}
+	}
+	writeJSON(w, http.StatusOK, &opResult{})
+	return nil
+}
+
+func (s *server) resume(w http.ResponseWriter, r *http.Request, ct *md.Container) error {
+	var out bytes.Buffer
+	if err := ct.Resume(r.Context(), &out, &out); err != nil {
+		return opError(err, &out)
+	}
+	writeJSON(w, http.StatusOK, &opResult{Output: out.String()})
+	return nil
+}
+
+func (s *server) push(w http.ResponseWriter, r *http.Request, ct *md.Container) error {
+	i, err := repoIndex(r, ct)
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	res, err := ct.Push(r.Context(), &out, &out, i, nil)
+	if err != nil {
+		return opError(err, &out)
+	}
+	writeJSON(w, http.StatusOK, &opResult{Backup: res.Backup, Stat: res.Stat, Output: out.String()})
+	return nil
+}
+
+func (s *server) pull(w http.ResponseWriter, r *http.Request, ct *md.Container) error {
+	ctx := r.Context()
+	i, err := repoIndex(r, ct)
+	if err != nil {
+		return err
+	}
+	p, err := newProvider(ctx, os.Getenv("ASK_PROVIDER"), os.Getenv("ASK_MODEL"))
+	if err != nil {
+		slog.WarnContext(ctx, "md", "msg", "failed to initialize provider", "err", err)
+	}
+	var out bytes.Buffer
+	res, err := ct.Pull(ctx, &out, &out, i, &md.PullOpts{Provider: p})
+	if err != nil {
+		return opError(err, &out)
+	}
+	pulled(ctx, ct, i)
+	writeJSON(w, http.StatusOK, &opResult{Stat: res.Stat, Output: out.String()})
+	return nil
+}
+
+// diff returns the patch as text, like md diff; the "since" query parameter
+// is md diff --since.
+func (s *server) diff(w http.ResponseWriter, r *http.Request, ct *md.Container) error {
+	i, err := repoIndex(r, ct)
+	if err != nil {
+		return err
+	}
+	var out, errOut bytes.Buffer
+	if err := ct.DiffSince(r.Context(), &out, &errOut, i, r.URL.Query().Get("since"), nil); err != nil {
+		return opError(err, &errOut)
+	}
+	w.Header().Set("Content-Type", "text/x-diff; charset=utf-8")
+	_, err = io.Copy(w, &out)
+	return err
+}
+
+// logs returns the container's output or the log of the "service" query
+// parameter as text, the last "n" lines (default 100, -1 for all).
+func (s *server) logs(w http.ResponseWriter, r *http.Request, ct *md.Container) error {
+	q := r.URL.Query()
+	lines := 100
+	if v := q.Get("n"); v != "" {
+		var err error
+		if lines, err = strconv.Atoi(v); err != nil {
+			return &apiError{http.StatusBadRequest, fmt.Errorf("invalid n %q", v)}
+		}
+	}
+	// The container's stderr is part of its output.
+	var out bytes.Buffer
+	if err := ct.Logs(r.Context(), &out, &out, &md.LogsOpts{Service: q.Get("service"), Lines: lines}); err != nil {
+		return opError(err, &out)
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, err := io.Copy(w, &out)
+	return err
+}
@@ -0,0 +1,99 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/caic-xyz/md"
+)
+
+func TestListenUnix(t *testing.T) {
+	// Keep the path short: unix socket paths are limited to about 100 bytes.
+	dir, err := os.MkdirTemp("", "md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	path := filepath.Join(dir, "s", "md.sock")
+	ln, err := listenUnix(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" {
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != 0o600 {
+			t.Errorf("socket mode %v", fi.Mode().Perm())
+		}
+	}
+	if _, err := listenUnix(path); err == nil || !strings.Contains(err.Error(), "already listening") {
+		t.Errorf("got %v", err)
+	}
+	// A socket left by a dead server is replaced.
+	ln.(interface{ SetUnlinkOnClose(bool) }).SetUnlinkOnClose(false)
+	_ = ln.Close()
+	ln, err = listenUnix(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = ln.Close()
+}
+
+func TestServeStartValidation(t *testing.T) {
+	h := newServer(&md.Client{}).handler()
+	for _, tc := range []struct {
+		body string
+		want string
+	}{
+		{`{"repos": [{"path": "relative"}]}`, "must be absolute"},
+		{`{"nope": 1}`, "unknown field"},
+		{`{"sudo": "root"}`, "invalid sudo policy"},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/containers", strings.NewReader(tc.body)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: code %d", tc.body, w.Code)
+		}
+		var resp map[string]string
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(resp["error"], tc.want) {
+			t.Errorf("%s: error %q, want %q", tc.body, resp["error"], tc.want)
+		}
+	}
+}
+
+func TestRepoIndex(t *testing.T) {
+	ct := &md.Container{Name: "md-a-main", Repos: []md.Repo{{GitRoot: "/src/a", Branch: "main"}, {GitRoot: "/src/b", Branch: "main"}}}
+	for _, tc := range []struct {
+		query string
+		want  int
+		code  int
+	}{
+		{"", 0, 0},
+		{"?repo=b", 1, 0},
+		{"?repo=c", 0, http.StatusBadRequest},
+	} {
+		got, err := repoIndex(httptest.NewRequest(http.MethodGet, "/"+tc.query, nil), ct)
+		code := 0
+		if err != nil {
+			code = err.(*apiError).code
+		}
+		if got != tc.want || code != tc.code {
+			t.Errorf("%q: got %d, %v", tc.query, got, err)
+		}
+	}
+}
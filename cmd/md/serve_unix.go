@@ -0,0 +1,22 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+//go:build !windows
+
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+// listenPrivate listens on the unix socket path with a umask of 077, so the
+// socket is never accessible to other users, even before listenUnix
+// restricts it to 0600. The umask is process-wide; md serve has nothing else
+// creating files while it starts.
+func listenPrivate(path string) (net.Listener, error) {
+	old := syscall.Umask(0o077)
+	defer syscall.Umask(old)
+	return net.Listen("unix", path)
+}
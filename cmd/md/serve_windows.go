@@ -0,0 +1,13 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package main
+
+import "net"
+
+// listenPrivate listens on the unix socket path. Windows has no umask; the
+// socket's access is that of its directory.
+func listenPrivate(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
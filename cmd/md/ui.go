@@ -0,0 +1,544 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/caic-xyz/md"
+	"golang.org/x/term"
+)
+
+// uiLogLines is the number of log lines md ui keeps for the selected
+// container.
+const uiLogLines = 200
+
+// dashboard is what md ui shows.
+type dashboard struct {
+	containers []*md.Container
+	stats      map[string]*md.ContainerStats
+	// status is the selected container's, nil until fetched.
+	status *md.Status
+	// selected is the name of the selected container.
+	selected string
+	logs     *logBuffer
+	showLogs bool
+	// confirmKill is the name of the container waiting for the kill
+	// confirmation.
+	confirmKill string
+	message     string
+	refreshed   time.Time
+}
+
+// selectedContainer returns the selected container, nil if there is none.
+func (d *dashboard) selectedContainer() *md.Container {
+	for _, ct := range d.containers {
+		if ct.Name == d.selected {
+			return ct
+		}
+	}
+	return nil
+}
+
+// move selects the container delta rows away from the selected one.
+func (d *dashboard) move(delta int) {
+	if len(d.containers) == 0 {
+		return
+	}
+	i := 0
+	for j, ct := range d.containers {
+		if ct.Name == d.selected {
+			i = j
+		}
+	}
+	i = min(max(i+delta, 0), len(d.containers)-1)
+	d.selected = d.containers[i].Name
+}
+
+// render returns the screen, lines ending with "\r\n" since the terminal is
+// in raw mode.
+func (d *dashboard) render(width, height int) string {
+	var top []string
+	header := fmt.Sprintf("md ui  %d containers", len(d.containers))
+	if !d.refreshed.IsZero() {
+		header += "  refreshed " + d.refreshed.Format(time.TimeOnly)
+	}
+	top = append(top, "\x1b[1m"+truncate(header, width)+"\x1b[0m")
+	top = append(top, truncate(fmt.Sprintf("  %-30s %-9s %12s %7s %20s %5s", "CONTAINER", "STATE", "UPTIME", "CPU", "MEM", "PIDS"), width))
+	if len(d.containers) == 0 {
+		top = append(top, "  No md containers")
+	}
+	for _, ct := range d.containers {
+		state, uptime := ct.State, time.Since(ct.CreatedAt).Truncate(time.Second).String()
+		if state == "exited" || state == "created" {
+			state, uptime = "stopped", "-"
+		}
+		row := fmt.Sprintf("%-30s %-9s %12s", ct.Name, state, uptime)
+		if s := d.stats[ct.Name]; s != nil && ct.State == "running" {
+			row += fmt.Sprintf(" %6.1f%% %20s %5d", s.CPUPerc, md.FormatBytes(int64(s.MemUsed))+"/"+md.FormatBytes(int64(s.MemLimit)), s.PIDs)
+		}
+		if ct.Name == d.selected {
+			row = truncate("> "+row, width)
+			top = append(top, "\x1b[7m"+row+strings.Repeat(" ", max(width-utf8.RuneCountInString(row), 0))+"\x1b[0m")
+		} else {
+			top = append(top, truncate("  "+row, width))
+		}
+	}
+	if st := d.status; st != nil && st.Name == d.selected && st.State == "running" {
+		top = append(top, "")
+		for _, r := range st.Repos {
+			top = append(top, truncate(fmt.Sprintf("%s %s: local +%d -%d, container +%d -%d, %d staged, %d unstaged, %d untracked",
+				r.Name, r.Branch, r.LocalAhead, r.LocalBehind, r.ContainerAhead, r.ContainerBehind, r.Staged, r.Unstaged, r.Untracked), width))
+		}
+	}
+
+	bottom := []string{
+		truncate(d.message, width),
+		truncate("[enter/s] ssh  [d] diff  [p] pull  [v] vnc  [K] kill  [l] logs  [↑/↓] select  [q] quit", width),
+	}
+	lines := top
+	if d.showLogs && d.selected != "" && d.logs != nil {
+		lines = append(lines, truncate("── logs of "+d.selected+" "+strings.Repeat("─", width), width))
+		if n := height - len(top) - len(bottom) - 1; n > 0 {
+			logs := d.logs.lines()
+			logs = logs[max(len(logs)-n, 0):]
+			for _, l := range logs {
+				lines = append(lines, truncate(l, width))
+			}
+		}
+	}
+	// Keep the key help at the bottom.
+	for len(lines)+len(bottom) < height {
+		lines = append(lines, "")
+	}
+	lines = append(lines, bottom...)
+	if len(lines) > height {
+		lines = lines[len(lines)-height:]
+	}
+	return strings.Join(lines, "\r\n")
+}
+
+// truncate cuts s to width runes.
+func truncate(s string, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	return string([]rune(s)[:width])
+}
+
+// ansiEscape matches the terminal control sequences a log may contain.
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]`)
+
+// logBuffer keeps the last lines written to it.
+type logBuffer struct {
+	mu      sync.Mutex
+	buf     []string
+	partial string
+	max     int
+	changed bool
+}
+
+func newLogBuffer(max int) *logBuffer {
+	return &logBuffer{max: max}
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := b.partial + string(p)
+	parts := strings.Split(s, "\n")
+	b.partial = parts[len(parts)-1]
+	for _, l := range parts[:len(parts)-1] {
+		l = ansiEscape.ReplaceAllString(strings.TrimSuffix(l, "\r"), "")
+		l = strings.Map(func(r rune) rune {
+			if r == '\t' {
+				return ' '
+			}
+			if r < ' ' || r == 0x7f {
+				return -1
+			}
+			return r
+		}, l)
+		b.buf = append(b.buf, l)
+	}
+	if len(b.buf) > b.max {
+		b.buf = b.buf[len(b.buf)-b.max:]
+	}
+	b.changed = true
+	return len(p), nil
+}
+
+// lines returns the complete lines.
+func (b *logBuffer) lines() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.changed = false
+	return append([]string(nil), b.buf...)
+}
+
+// hasChanged reports whether lines were written since the last call to
+// lines.
+func (b *logBuffer) hasChanged() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.changed
+}
+
+// parseKeys returns the keys in the bytes read from the terminal in raw
+// mode. Arrows are returned as "up" and "down", Enter as "enter" and
+// Ctrl-C as "q".
+func parseKeys(b []byte) []string {
+	var keys []string
+	for len(b) > 0 {
+		switch {
+		case len(b) >= 3 && b[0] == 0x1b && (b[1] == '[' || b[1] == 'O'):
+			switch b[2] {
+			case 'A':
+				keys = append(keys, "up")
+			case 'B':
+				keys = append(keys, "down")
+			}
+			b = b[3:]
+		case b[0] == '\r' || b[0] == '\n':
+			keys = append(keys, "enter")
+			b = b[1:]
+		case b[0] == 0x03:
+			keys = append(keys, "q")
+			b = b[1:]
+		default:
+			r, n := utf8.DecodeRune(b)
+			keys = append(keys, string(r))
+			b = b[n:]
+		}
+	}
+	return keys
+}
+
+// refreshResult is the outcome of a refresh.
+type refreshResult struct {
+	containers []*md.Container
+	stats      map[string]*md.ContainerStats
+	status     *md.Status
+	err        error
+}
+
+// ui runs md ui.
+type ui struct {
+	c        *md.Client
+	d        *dashboard
+	fd       int
+	oldState *term.State
+	// logsOf is the container whose logs are followed.
+	logsOf string
+	// stopLogs stops following its logs.
+	stopLogs context.CancelFunc
+}
+
+func cmdUI(ctx context.Context, args []string) error {
+	fs := newFlagSet("ui")
+	verbose := addVerboseFlag(fs)
+	interval := fs.Duration("interval", 2*time.Second, "How often to refresh the containers and their resource usage")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	initLogging(*verbose)
+	if err := checkArgs(fs, 0); err != nil {
+		return err
+	}
+	if *interval <= 0 {
+		return errors.New("-interval must be positive")
+	}
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return errors.New("md ui needs a terminal; use md list or md status in scripts")
+	}
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	u := &ui{c: c, d: &dashboard{showLogs: true}, fd: fd}
+	return u.run(ctx, *interval)
+}
+
+func (u *ui) run(ctx context.Context, interval time.Duration) error {
+	if err := u.enter(); err != nil {
+		return err
+	}
+	defer func() {
+		u.followLogs(ctx, "")
+		u.leave()
+	}()
+	results := make(chan refreshResult, 1)
+	refreshing := true
+	go u.refresh(ctx, u.d.selected, results)
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	redraw := true
+	buf := make([]byte, 64)
+	for {
+		select {
+		case r := <-results:
+			refreshing = false
+			u.apply(ctx, r)
+			redraw = true
+		case <-tick.C:
+			if !refreshing {
+				refreshing = true
+				go u.refresh(ctx, u.d.selected, results)
+			}
+		default:
+		}
+		if redraw || (u.d.logs != nil && u.d.logs.hasChanged()) {
+			u.draw()
+			redraw = false
+		}
+		ready, err := waitInput(u.fd, 100*time.Millisecond)
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		if !ready {
+			continue
+		}
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return err
+		}
+		for _, k := range parseKeys(buf[:n]) {
+			quit, refresh := u.handle(ctx, k)
+			if quit {
+				return nil
+			}
+			if refresh && !refreshing {
+				refreshing = true
+				go u.refresh(ctx, u.d.selected, results)
+			}
+		}
+		redraw = true
+	}
+}
+
+// refresh lists the containers, their resource usage and the state of the
+// selected one.
+func (u *ui) refresh(ctx context.Context, selected string, results chan<- refreshResult) {
+	var r refreshResult
+	if r.containers, r.err = u.c.List(ctx); r.err == nil {
+		var names []string
+		for _, ct := range r.containers {
+			if ct.State == "running" {
+				names = append(names, ct.Name)
+			}
+			if ct.Name == selected {
+				if st, err := ct.Status(ctx); err == nil {
+					r.status = st
+				}
+			}
+		}
+		if u.c.Kube == nil && len(names) > 0 {
+			var err error
+			if r.stats, err = md.StatsAll(ctx, u.c.Runtime, names); err != nil {
+				slog.DebugContext(ctx, "md", "msg", "fetching container stats", "err", err)
+			}
+		}
+	}
+	select {
+	case results <- r:
+	case <-ctx.Done():
+	}
+}
+
+// apply updates the dashboard with a refresh.
+func (u *ui) apply(ctx context.Context, r refreshResult) {
+	d := u.d
+	if r.err != nil {
+		d.message = "Listing containers: " + r.err.Error()
+		return
+	}
+	d.containers, d.stats, d.status, d.refreshed = r.containers, r.stats, r.status, time.Now()
+	if d.selectedContainer() == nil {
+		d.selected = ""
+		if len(d.containers) > 0 {
+			d.selected = d.containers[0].Name
+		}
+	}
+	u.followLogs(ctx, d.selected)
+}
+
+// followLogs streams the logs of the container name into the dashboard,
+// stopping the previous stream. An empty name only stops it.
+func (u *ui) followLogs(ctx context.Context, name string) {
+	if name == u.logsOf {
+		return
+	}
+	if u.stopLogs != nil {
+		u.stopLogs()
+		u.stopLogs = nil
+	}
+	u.logsOf = name
+	u.d.logs = nil
+	ct := u.d.selectedContainer()
+	if name == "" || ct == nil {
+		return
+	}
+	lctx, cancel := context.WithCancel(ctx)
+	u.stopLogs = cancel
+	lb := newLogBuffer(uiLogLines)
+	u.d.logs = lb
+	go func() {
+		if err := ct.Logs(lctx, lb, lb, &md.LogsOpts{Lines: uiLogLines, Follow: true}); err != nil && lctx.Err() == nil {
+			_, _ = fmt.Fprintf(lb, "md: %v\n", err)
+		}
+	}()
+}
+
+// handle runs the action of key k. It returns whether to quit and whether
+// the containers changed.
+func (u *ui) handle(ctx context.Context, k string) (quit, refresh bool) {
+	d := u.d
+	if name := d.confirmKill; name != "" {
+		d.confirmKill = ""
+		d.message = "Kill canceled"
+		if k == "y" {
+			if ct := d.selectedContainer(); ct != nil && ct.Name == name {
+				u.suspend(true, func() error {
+					if err := ct.Purge(ctx, os.Stdout, os.Stderr); err != nil {
+						return err
+					}
+					notify(ctx, md.NewEvent(md.EventKill, ct))
+					return nil
+				})
+				d.message = "Killed " + name
+				return false, true
+			}
+		}
+		return false, false
+	}
+	d.message = ""
+	switch k {
+	case "q":
+		return true, false
+	case "up", "k":
+		d.move(-1)
+	case "down", "j":
+		d.move(1)
+	case "l":
+		d.showLogs = !d.showLogs
+	case "enter", "s", "d", "p", "v", "K":
+		ct := d.selectedContainer()
+		if ct == nil {
+			d.message = "No container selected"
+			break
+		}
+		if k == "K" {
+			d.confirmKill = ct.Name
+			d.message = "Kill " + ct.Name + "? Its uncommitted work is lost. Press y to confirm"
+			break
+		}
+		if ct.State != "running" {
+			d.message = ct.Name + " is stopped; resume it with: md resume " + ct.Name
+			break
+		}
+		switch k {
+		case "enter", "s":
+			u.suspend(false, func() error {
+				cmd := exec.CommandContext(ctx, "ssh", ct.Name)
+				cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+				return cmd.Run()
+			})
+		case "d":
+			u.suspend(true, func() error {
+				for i := range ct.Repos {
+					if err := ct.Diff(ctx, os.Stdout, os.Stderr, i, nil); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		case "p":
+			u.suspend(true, func() error {
+				p, err := newProvider(ctx, os.Getenv("ASK_PROVIDER"), os.Getenv("ASK_MODEL"))
+				if err != nil {
+					slog.WarnContext(ctx, "md", "msg", "failed to initialize provider", "err", err)
+				}
+				for i := range ct.Repos {
+					if _, err := ct.Pull(ctx, os.Stdout, os.Stderr, i, &md.PullOpts{Provider: p}); err != nil {
+						return err
+					}
+					pulled(ctx, ct, i)
+				}
+				return nil
+			})
+			return false, true
+		case "v":
+			u.suspend(true, func() error { return openVNC(ctx, ct, 1) })
+		}
+	}
+	// Follow the logs of the newly selected container.
+	u.followLogs(ctx, d.selected)
+	return false, false
+}
+
+// suspend restores the terminal to run f, then waits for Enter when wait is
+// set or f failed.
+func (u *ui) suspend(wait bool, f func() error) {
+	u.leave()
+	err := f()
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "md: %v\n", err)
+	}
+	if wait || err != nil {
+		fmt.Print("\nPress Enter to return to md ui")
+		_, _ = bufio.NewReader(os.Stdin).ReadString('\n')
+	}
+	if err := u.enter(); err != nil {
+		u.d.message = err.Error()
+	}
+}
+
+// enter switches the terminal to raw mode on the alternate screen.
+func (u *ui) enter() error {
+	s, err := term.MakeRaw(u.fd)
+	if err != nil {
+		return err
+	}
+	u.oldState = s
+	_, err = io.WriteString(os.Stdout, "\x1b[?1049h\x1b[?25l")
+	return err
+}
+
+// leave restores the terminal.
+func (u *ui) leave() {
+	_, _ = io.WriteString(os.Stdout, "\x1b[?25h\x1b[?1049l")
+	if u.oldState != nil {
+		_ = term.Restore(u.fd, u.oldState)
+		u.oldState = nil
+	}
+}
+
+// draw writes the dashboard over the screen.
+func (u *ui) draw() {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		width, height = 80, 24
+	}
+	_, _ = io.WriteString(os.Stdout, "\x1b[H\x1b[2J"+u.d.render(width, height))
+}
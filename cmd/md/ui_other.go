@@ -0,0 +1,15 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+//go:build !linux && !darwin
+
+package main
+
+import "time"
+
+// waitInput reports input as ready: without poll(2), md ui blocks on the
+// keyboard and only refreshes after a key press.
+func waitInput(int, time.Duration) (bool, error) {
+	return true, nil
+}
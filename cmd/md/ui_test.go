@@ -0,0 +1,90 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caic-xyz/md"
+)
+
+func TestParseKeys(t *testing.T) {
+	got := parseKeys([]byte("j\x1b[A\x1b[Bs\rK\x03é"))
+	want := []string{"j", "up", "down", "s", "enter", "K", "q", "é"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestLogBuffer(t *testing.T) {
+	b := newLogBuffer(2)
+	_, _ = fmt.Fprint(b, "one\ntw")
+	if got := b.lines(); !slices.Equal(got, []string{"one"}) {
+		t.Errorf("got %q", got)
+	}
+	if b.hasChanged() {
+		t.Error("unexpected change")
+	}
+	_, _ = fmt.Fprint(b, "o\r\n\x1b[32mthree\x1b[0m\tok\n")
+	if !b.hasChanged() {
+		t.Error("expected change")
+	}
+	if got, want := b.lines(), []string{"two", "three ok"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestDashboard(t *testing.T) {
+	now := time.Now()
+	d := &dashboard{
+		containers: []*md.Container{
+			{Name: "md-a-main", State: "running", CreatedAt: now},
+			{Name: "md-b-main", State: "exited", CreatedAt: now},
+		},
+		stats:    map[string]*md.ContainerStats{"md-a-main": {CPUPerc: 12.5, PIDs: 7}},
+		selected: "md-a-main",
+		logs:     newLogBuffer(10),
+		showLogs: true,
+		message:  "hello",
+	}
+	_, _ = fmt.Fprint(d.logs, "first\nsecond\n")
+	d.move(5)
+	if d.selected != "md-b-main" {
+		t.Errorf("selected %q", d.selected)
+	}
+	d.move(-1)
+	if d.selected != "md-a-main" {
+		t.Errorf("selected %q", d.selected)
+	}
+	lines := strings.Split(d.render(100, 12), "\r\n")
+	if len(lines) != 12 {
+		t.Fatalf("got %d lines:\n%s", len(lines), strings.Join(lines, "\n"))
+	}
+	for i, want := range map[int]string{
+		2:  "\x1b[7m> md-a-main",
+		3:  "  md-b-main                      stopped",
+		4:  "── logs of md-a-main",
+		5:  "first",
+		6:  "second",
+		10: "hello",
+		11: "[enter/s] ssh",
+	} {
+		if !strings.HasPrefix(lines[i], want) {
+			t.Errorf("line %d = %q, want prefix %q", i, lines[i], want)
+		}
+	}
+	if !strings.Contains(lines[2], "12.5%") {
+		t.Errorf("missing CPU in %q", lines[2])
+	}
+	for i, l := range lines {
+		if n := len([]rune(strings.NewReplacer("\x1b[7m", "", "\x1b[1m", "", "\x1b[0m", "").Replace(l))); n > 100 {
+			t.Errorf("line %d is %d wide", i, n)
+		}
+	}
+}
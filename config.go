@@ -0,0 +1,1034 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// RepoConfigFile is the per-repository configuration file, at the git root.
+// It overrides the user configuration ([ConfigPath]).
+const RepoConfigFile = ".md.toml"
+
+// Config holds user defaults for md. It is loaded from
+// ~/.config/md/config.toml and, per repository, from [RepoConfigFile].
+//
+// Precedence, from highest to lowest: command line flags, environment
+// variables ($MD_ENGINE, $TAILSCALE_API_KEY, ...), the repository's .md.toml,
+// ~/.config/md/config.toml, built-in defaults.
+//
+// Example:
+//
+//	image = "ghcr.io/me/md:latest"
+//	caches = ["go-mod", "/data/models:/home/user/models"]
+//	labels = ["team=infra"]
+//	harnesses = ["claude", "codex"]
+//
+//	[tailscale]
+//	enabled = true
+//
+//	[args]
+//	start = ["--display", "--cpus=8"]
+//
+//	[workspaces]
+//	backend = ["~/src/api", "~/src/worker:dev"]
+//
+//	[notify]
+//	webhooks = ["https://example.com/md-events"]
+//	events = ["start", "agent_finished"]
+//
+//	[limits]
+//	memory = "16g"
+//	pids_limit = 4096
+type Config struct {
+	// Runtime is the container engine, "docker" or "podman". User config
+	// only.
+	Runtime string `toml:"runtime"`
+	// Image is the full base image reference used by start, run and fork.
+	Image string `toml:"image"`
+	// Caches are added to the default caches, like --cache. A repository
+	// config may only name well-known caches.
+	Caches []string `toml:"caches"`
+	// NoCaches excludes default well-known caches by name, like --no-cache.
+	NoCaches []string `toml:"no_caches"`
+	// DetectCaches includes by default only the well-known caches of the
+	// tools the repository uses, like --detect-caches. See [DetectCaches].
+	DetectCaches *bool `toml:"detect_caches"`
+	// CacheSets are named caches behaving like [WellKnownCaches] ones. User
+	// config only.
+	CacheSets map[string]CacheSetConfig `toml:"cache_sets"`
+	// Labels are container labels (key=value), like --label.
+	Labels []string `toml:"labels"`
+	// HostPorts are the host services reachable from the containers, like
+	// --host-port. See [ParseHostPort]. User config only.
+	HostPorts []string `toml:"host_ports"`
+	// Harnesses limits the agent config directories mounted in the container
+	// to these [HarnessMounts] entries. Empty mounts all of them.
+	Harnesses []string `toml:"harnesses"`
+	// Agent is the command line md task runs in the container, with the task
+	// prompt appended as its last argument. Defaults to [DefaultAgent].
+	Agent string `toml:"agent"`
+	// BranchTemplate names the branches md task from-issue and md fork
+	// create, as a text/template of [BranchVars], e.g.
+	// "agent/{{.Agent}}/{{.IssueNumber}}-{{.Slug}}".
+	BranchTemplate string `toml:"branch_template"`
+	// Setup is the shell command md start runs in the primary repository once
+	// it is in the container, instead of its [SetupScript].
+	Setup string `toml:"setup"`
+	// ContextDir replaces the build context embedded in md for md build-image,
+	// like --context-dir. User config only.
+	ContextDir string `toml:"context_dir"`
+	// Tailscale holds Tailscale defaults.
+	Tailscale TailscaleConfig `toml:"tailscale"`
+	// Args maps a subcommand to default arguments inserted before the ones
+	// given on the command line, so flags still override them.
+	Args map[string][]string `toml:"args"`
+	// Workspaces maps a workspace name to the repositories md ws operates on,
+	// one container each, as "path[:branch]" with an absolute or ~/ path.
+	// User config only.
+	Workspaces map[string][]string `toml:"workspaces"`
+	// Notify configures lifecycle event notifications.
+	Notify NotifyConfig `toml:"notify"`
+	// Limits holds the default resource limits of md start and md run.
+	Limits LimitsConfig `toml:"limits"`
+	// Disk holds the disk usage thresholds md warns about.
+	Disk DiskConfig `toml:"disk"`
+	// Hooks are commands run at lifecycle hook points.
+	Hooks HooksConfig `toml:"hooks"`
+	// Kubernetes runs the containers on a cluster instead of the local
+	// engine. User config only.
+	Kubernetes KubernetesConfig `toml:"kubernetes"`
+	// Proxy is the HTTP(S) proxy, defaulting to $HTTP_PROXY, $HTTPS_PROXY
+	// and $NO_PROXY. User config only.
+	Proxy ProxyConfig `toml:"proxy"`
+	// SignCommits signs the container's commits on the host, with its git
+	// signing setup, when md pull brings them in. See [Client.SignCommits].
+	SignCommits *bool `toml:"sign_commits"`
+	// ConventionalCommits makes the commit messages md generates follow the
+	// Conventional Commits specification. See [Client.ConventionalCommits].
+	ConventionalCommits *bool `toml:"conventional_commits"`
+	// EnvFiles are the [EnvStore] files injected into the containers after
+	// [DefaultEnvFile], like --env-file. User config only.
+	EnvFiles []string `toml:"env_files"`
+	// EnvInject is how the env files reach the containers, [EnvInjectFile]
+	// (the default) or [EnvInjectEngine], like --env-inject.
+	EnvInject string `toml:"env_inject"`
+	// Approval is how the operations agents run through md mcp and md serve
+	// are confirmed. User config only.
+	Approval ApprovalConfig `toml:"approval"`
+}
+
+// ApprovalConfig configures the confirmation of the operations agents run
+// through md mcp and md serve. The read-only ones are always approved.
+type ApprovalConfig struct {
+	// AutoApprove are the operations run without asking, like "push"; "all"
+	// asks for none.
+	AutoApprove []string `toml:"auto_approve"`
+	// Prompt is how the user is asked, one of [ApprovalPrompts]. Empty means
+	// [ApprovalPromptAuto].
+	Prompt string `toml:"prompt"`
+}
+
+// Ways of asking the user to approve an operation.
+const (
+	// ApprovalPromptAuto asks on the terminal when there is one, else in a
+	// desktop dialog.
+	ApprovalPromptAuto = "auto"
+	// ApprovalPromptTerminal asks on md's controlling terminal.
+	ApprovalPromptTerminal = "terminal"
+	// ApprovalPromptDialog asks in a desktop dialog.
+	ApprovalPromptDialog = "dialog"
+	// ApprovalPromptDeny refuses without asking.
+	ApprovalPromptDeny = "deny"
+)
+
+// ApprovalPrompts lists the valid [ApprovalConfig.Prompt] values.
+var ApprovalPrompts = []string{ApprovalPromptAuto, ApprovalPromptTerminal, ApprovalPromptDialog, ApprovalPromptDeny}
+
+// KubernetesConfig selects the [Kube] backend.
+type KubernetesConfig struct {
+	// Context is the kubeconfig context the containers run on, like
+	// --kube-context. Setting it enables the backend.
+	Context string `toml:"context"`
+	// Namespace is the namespace of the pods. Empty uses the context's.
+	Namespace string `toml:"namespace"`
+	// Registry is the private image repository the cluster pulls md's
+	// images from.
+	Registry string `toml:"registry"`
+}
+
+// LimitsConfig holds the default resource limits of containers, overridden
+// by --cpus, --memory, --pids-limit and --shm-size.
+type LimitsConfig struct {
+	// CPUs is the number of CPU cores; zero means no limit. Defaults to
+	// [DefaultMaxCPUs].
+	CPUs *int `toml:"cpus"`
+	// Memory is the memory limit, e.g. "8g".
+	Memory string `toml:"memory"`
+	// PidsLimit is the maximum number of processes.
+	PidsLimit int `toml:"pids_limit"`
+	// ShmSize is the size of /dev/shm, e.g. "1g".
+	ShmSize string `toml:"shm_size"`
+}
+
+// DiskConfig holds the disk usage thresholds of [DiskThresholds]. Unset
+// percentages default to [DefaultDiskThresholds], zero disables a check.
+type DiskConfig struct {
+	// UsedPercent warns when the filesystem holding the containers'
+	// writable layers is this full.
+	UsedPercent *int `toml:"used_percent"`
+	// InodesPercent warns when that filesystem used this share of its
+	// inodes.
+	InodesPercent *int `toml:"inodes_percent"`
+	// Layer warns when a container's writable layer grows past this size,
+	// e.g. "50g".
+	Layer string `toml:"layer"`
+	// Src warns when a container's ~/src grows past this size.
+	Src string `toml:"src"`
+}
+
+// DiskThresholds returns the thresholds set by Disk. c may be nil.
+func (c *Config) DiskThresholds() DiskThresholds {
+	t := DefaultDiskThresholds
+	if c == nil {
+		return t
+	}
+	if p := c.Disk.UsedPercent; p != nil {
+		t.UsedPercent = float64(*p)
+	}
+	if p := c.Disk.InodesPercent; p != nil {
+		t.InodesPercent = float64(*p)
+	}
+	// check validated the sizes.
+	t.Layer, _ = parseSize(c.Disk.Layer)
+	t.Src, _ = parseSize(c.Disk.Src)
+	return t
+}
+
+// NotifyConfig configures the [Notifier] md uses for lifecycle events.
+type NotifyConfig struct {
+	// Webhooks are http(s) URLs each [Event] is POSTed to as JSON. User
+	// config only.
+	Webhooks []string `toml:"webhooks"`
+	// Commands are shell commands run with the JSON [Event] on stdin. User
+	// config only.
+	Commands []string `toml:"commands"`
+	// Slack are Slack incoming webhook URLs a readable message is posted to.
+	// User config only.
+	Slack []string `toml:"slack"`
+	// Matrix is a room a readable message is posted to.
+	Matrix MatrixConfig `toml:"matrix"`
+	// Events limits notifications to these [EventTypes]. Empty sends all.
+	Events []string `toml:"events"`
+}
+
+// MatrixConfig configures the Matrix room notifications are posted to.
+type MatrixConfig struct {
+	// Homeserver is the client API base URL, e.g. "https://matrix.org".
+	Homeserver string `toml:"homeserver"`
+	// Room is the room ID, e.g. "!abc123:matrix.org".
+	Room string `toml:"room"`
+	// Token is the access token, used when $MATRIX_TOKEN is not set. User
+	// config only.
+	Token string `toml:"token"`
+}
+
+// Notifier returns the notifier configured by Notify.
+func (c *Config) Notifier() *Notifier {
+	n := &Notifier{Webhooks: c.Notify.Webhooks, Commands: c.Notify.Commands, Slack: c.Notify.Slack, Events: c.Notify.Events}
+	if m := c.Notify.Matrix; m.Homeserver != "" && m.Room != "" {
+		n.Matrix = &MatrixRoom{Homeserver: m.Homeserver, Room: m.Room, Token: envOr("MATRIX_TOKEN", m.Token)}
+	}
+	return n
+}
+
+// TailscaleConfig holds Tailscale defaults.
+type TailscaleConfig struct {
+	// Enabled makes md start join the tailnet by default, like --tailscale.
+	Enabled *bool `toml:"enabled"`
+	// APIKey is used when $TAILSCALE_API_KEY is not set. User config only.
+	APIKey string `toml:"api_key"`
+}
+
+var workspaceNameRe = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]*$`)
+
+// Workspace returns the repository specs of the workspace name, with ~/
+// expanded to home.
+func (c *Config) Workspace(name, home string) ([]string, error) {
+	specs, ok := c.Workspaces[name]
+	if !ok {
+		names := slices.Sorted(maps.Keys(c.Workspaces))
+		if len(names) == 0 {
+			return nil, fmt.Errorf("unknown workspace %q; define workspaces in %s", name, ConfigPath("~/.config"))
+		}
+		return nil, fmt.Errorf("unknown workspace %q; workspaces: %s", name, strings.Join(names, ", "))
+	}
+	out := make([]string, len(specs))
+	for i, s := range specs {
+		if rest, ok := strings.CutPrefix(s, "~/"); ok {
+			s = filepath.Join(home, rest)
+		}
+		out[i] = s
+	}
+	return out, nil
+}
+
+// repoAllowedArgs are the flags a repository config can set in [args]. It
+// is an allowlist so a new flag can't let a cloned repository reach host
+// files, repositories, secrets, services, privileges or the network until it
+// is reviewed and added: --cache, --extra-repo, --host-port, --bind,
+// --network, --allow-hosts, --tailscale, --usb or --sudo, for example, stay
+// user only.
+var repoAllowedArgs = []string{
+	// Output.
+	"v", "verbose", "q", "json", "porcelain", "stat", "name-status", "html", "web",
+	// Container selection.
+	"b", "branch", "repo-name",
+	// Image.
+	"image", "tag", "platform", "rebuild", "no-pull", "why-rebuild", "no-cache", "devcontainer", "label", "l",
+	// Resources and features confined to the container or the loopback
+	// interface.
+	"cpus", "memory", "pids-limit", "shm-size", "display", "d", "display-size", "displays", "rdp", "browser",
+	"p", "publish", "ttl", "offline", "env-inject", "detect-caches", "no-caches", "shared-objects", "depth", "filter",
+	"no-agent", "no-setup", "no-sidecars", "no-ssh", "no-summary", "agent", "branch-template",
+	// Synchronization.
+	"strategy", "nested", "no-commit", "resolve", "dry-run", "draft", "topic", "target", "base", "category",
+	// Logs.
+	"follow", "n", "lines", "since", "interval", "watch", "sort", "wait",
+}
+
+// ConfigPath returns the user configuration file path.
+func ConfigPath(xdgConfigHome string) string {
+	return filepath.Join(xdgConfigHome, "md", "config.toml")
+}
+
+// DefaultConfigPath returns [ConfigPath] for the current user, honoring
+// $XDG_CONFIG_HOME.
+func DefaultConfigPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return ConfigPath(envOr("XDG_CONFIG_HOME", filepath.Join(home, ".config"))), nil
+}
+
+// LoadConfig reads the user configuration at p. A missing file yields an
+// empty configuration.
+func LoadConfig(p string) (*Config, error) {
+	cfg := &Config{}
+	if err := decodeConfig(p, cfg); err != nil {
+		return nil, err
+	}
+	if errs := cfg.check(false); len(errs) > 0 {
+		return nil, fmt.Errorf("%s: %w", p, errs[0])
+	}
+	return cfg, nil
+}
+
+// LoadRepoConfig reads [RepoConfigFile] in gitRoot and returns base
+// overridden by it. A missing file returns a copy of base.
+//
+// Since the file comes with the repository, it can't select the runtime,
+// replace the build context, hold the Tailscale API key, add host caches or
+// set flags outside repoAllowedArgs.
+func LoadRepoConfig(base *Config, gitRoot string) (*Config, error) {
+	repo := &Config{}
+	p := filepath.Join(gitRoot, RepoConfigFile)
+	if err := decodeConfig(p, repo); err != nil {
+		return nil, err
+	}
+	if errs := repo.check(true); len(errs) > 0 {
+		return nil, fmt.Errorf("%s: %w", p, errs[0])
+	}
+	return base.merge(repo), nil
+}
+
+// merge returns c overridden by o: scalars set in o win, lists of caches,
+// labels, webhooks and notification commands are appended, harnesses,
+// notified events and per-command args are replaced.
+func (c *Config) merge(o *Config) *Config {
+	out := *c
+	if o.Runtime != "" {
+		out.Runtime = o.Runtime
+	}
+	if o.Image != "" {
+		out.Image = o.Image
+	}
+	if o.Agent != "" {
+		out.Agent = o.Agent
+	}
+	if o.BranchTemplate != "" {
+		out.BranchTemplate = o.BranchTemplate
+	}
+	if o.Setup != "" {
+		out.Setup = o.Setup
+	}
+	if o.ContextDir != "" {
+		out.ContextDir = o.ContextDir
+	}
+	out.Caches = append(slices.Clip(c.Caches), o.Caches...)
+	out.NoCaches = append(slices.Clip(c.NoCaches), o.NoCaches...)
+	if o.DetectCaches != nil {
+		out.DetectCaches = o.DetectCaches
+	}
+	if len(o.CacheSets) > 0 {
+		out.CacheSets = make(map[string]CacheSetConfig, len(c.CacheSets)+len(o.CacheSets))
+		maps.Copy(out.CacheSets, c.CacheSets)
+		maps.Copy(out.CacheSets, o.CacheSets)
+	}
+	out.Labels = append(slices.Clip(c.Labels), o.Labels...)
+	out.HostPorts = append(slices.Clip(c.HostPorts), o.HostPorts...)
+	if o.SignCommits != nil {
+		out.SignCommits = o.SignCommits
+	}
+	if o.ConventionalCommits != nil {
+		out.ConventionalCommits = o.ConventionalCommits
+	}
+	out.EnvFiles = append(slices.Clip(c.EnvFiles), o.EnvFiles...)
+	if o.EnvInject != "" {
+		out.EnvInject = o.EnvInject
+	}
+	if len(o.Harnesses) > 0 {
+		out.Harnesses = o.Harnesses
+	}
+	if o.Tailscale.Enabled != nil {
+		out.Tailscale.Enabled = o.Tailscale.Enabled
+	}
+	if o.Tailscale.APIKey != "" {
+		out.Tailscale.APIKey = o.Tailscale.APIKey
+	}
+	out.Notify.Webhooks = append(slices.Clip(c.Notify.Webhooks), o.Notify.Webhooks...)
+	out.Notify.Commands = append(slices.Clip(c.Notify.Commands), o.Notify.Commands...)
+	out.Notify.Slack = append(slices.Clip(c.Notify.Slack), o.Notify.Slack...)
+	if o.Notify.Matrix.Homeserver != "" {
+		out.Notify.Matrix.Homeserver = o.Notify.Matrix.Homeserver
+	}
+	if o.Notify.Matrix.Room != "" {
+		out.Notify.Matrix.Room = o.Notify.Matrix.Room
+	}
+	if o.Notify.Matrix.Token != "" {
+		out.Notify.Matrix.Token = o.Notify.Matrix.Token
+	}
+	if len(o.Notify.Events) > 0 {
+		out.Notify.Events = o.Notify.Events
+	}
+	if o.Limits.CPUs != nil {
+		out.Limits.CPUs = o.Limits.CPUs
+	}
+	if o.Limits.Memory != "" {
+		out.Limits.Memory = o.Limits.Memory
+	}
+	if o.Limits.PidsLimit != 0 {
+		out.Limits.PidsLimit = o.Limits.PidsLimit
+	}
+	if o.Limits.ShmSize != "" {
+		out.Limits.ShmSize = o.Limits.ShmSize
+	}
+	if o.Disk.UsedPercent != nil {
+		out.Disk.UsedPercent = o.Disk.UsedPercent
+	}
+	if o.Disk.InodesPercent != nil {
+		out.Disk.InodesPercent = o.Disk.InodesPercent
+	}
+	if o.Disk.Layer != "" {
+		out.Disk.Layer = o.Disk.Layer
+	}
+	if o.Disk.Src != "" {
+		out.Disk.Src = o.Disk.Src
+	}
+	for _, p := range HookPoints {
+		h, oh := out.Hooks.commands(p), o.Hooks.commands(p)
+		h.Host = append(slices.Clip(h.Host), oh.Host...)
+		h.Container = append(slices.Clip(h.Container), oh.Container...)
+	}
+	if o.Kubernetes.Context != "" {
+		out.Kubernetes.Context = o.Kubernetes.Context
+	}
+	if o.Kubernetes.Namespace != "" {
+		out.Kubernetes.Namespace = o.Kubernetes.Namespace
+	}
+	if o.Kubernetes.Registry != "" {
+		out.Kubernetes.Registry = o.Kubernetes.Registry
+	}
+	if o.Proxy.HTTP != "" {
+		out.Proxy.HTTP = o.Proxy.HTTP
+	}
+	if o.Proxy.HTTPS != "" {
+		out.Proxy.HTTPS = o.Proxy.HTTPS
+	}
+	if o.Proxy.NoProxy != "" {
+		out.Proxy.NoProxy = o.Proxy.NoProxy
+	}
+	if len(o.Approval.AutoApprove) > 0 {
+		out.Approval.AutoApprove = o.Approval.AutoApprove
+	}
+	if o.Approval.Prompt != "" {
+		out.Approval.Prompt = o.Approval.Prompt
+	}
+	if len(o.Workspaces) > 0 {
+		out.Workspaces = make(map[string][]string, len(c.Workspaces)+len(o.Workspaces))
+		maps.Copy(out.Workspaces, c.Workspaces)
+		maps.Copy(out.Workspaces, o.Workspaces)
+	}
+	if len(o.Args) > 0 {
+		out.Args = make(map[string][]string, len(c.Args)+len(o.Args))
+		maps.Copy(out.Args, c.Args)
+		maps.Copy(out.Args, o.Args)
+	}
+	return &out
+}
+
+// configError is a configuration problem attributed to a key, so md config
+// validate can point at it.
+type configError struct {
+	key string
+	msg string
+}
+
+func (e *configError) Error() string {
+	return e.msg
+}
+
+// check returns the problems in c, in key order. repo applies the
+// restrictions of [LoadRepoConfig].
+func (c *Config) check(repo bool) []*configError {
+	var errs []*configError
+	add := func(key, format string, args ...any) {
+		errs = append(errs, &configError{key: key, msg: fmt.Sprintf(format, args...)})
+	}
+	userOnly := ConfigPath("~/.config")
+	if c.Runtime != "" {
+		if repo {
+			add("runtime", "runtime can only be set in %s", userOnly)
+		} else if err := ValidateRuntime(c.Runtime); err != nil {
+			add("runtime", "%v", err)
+		}
+	}
+	if repo {
+		if c.ContextDir != "" {
+			add("context_dir", "context_dir can only be set in %s", userOnly)
+		}
+		if len(c.Workspaces) > 0 {
+			add("workspaces", "workspaces can only be set in %s", userOnly)
+		}
+		if len(c.CacheSets) > 0 {
+			add("cache_sets", "cache_sets can only be set in %s", userOnly)
+		}
+		if c.Tailscale.APIKey != "" {
+			add("tailscale.api_key", "tailscale.api_key can only be set in %s", userOnly)
+		}
+		if c.Kubernetes != (KubernetesConfig{}) {
+			add("kubernetes", "kubernetes can only be set in %s", userOnly)
+		}
+		if c.Proxy != (ProxyConfig{}) {
+			add("proxy", "proxy can only be set in %s", userOnly)
+		}
+		if len(c.EnvFiles) > 0 {
+			add("env_files", "env_files can only be set in %s", userOnly)
+		}
+		// Each host port is let through the egress policy to the gateway.
+		if len(c.HostPorts) > 0 {
+			add("host_ports", "host_ports can only be set in %s", userOnly)
+		}
+		if len(c.Approval.AutoApprove) > 0 || c.Approval.Prompt != "" {
+			add("approval", "approval can only be set in %s", userOnly)
+		}
+		if len(c.Notify.Commands) > 0 {
+			add("notify.commands", "notify.commands can only be set in %s", userOnly)
+		}
+		// Events carry branch names, diffstats and summaries: a repository
+		// can't pick where they go.
+		if len(c.Notify.Webhooks) > 0 {
+			add("notify.webhooks", "notify.webhooks can only be set in %s", userOnly)
+		}
+		if len(c.Notify.Slack) > 0 {
+			add("notify.slack", "notify.slack can only be set in %s", userOnly)
+		}
+		if c.Notify.Matrix.Token != "" {
+			add("notify.matrix.token", "notify.matrix.token can only be set in %s", userOnly)
+		}
+		// The token, from the user config or $MATRIX_TOKEN, is sent to the
+		// homeserver: a repository can't pick where it goes.
+		if c.Notify.Matrix.Homeserver != "" {
+			add("notify.matrix.homeserver", "notify.matrix.homeserver can only be set in %s", userOnly)
+		}
+		if c.Notify.Matrix.Room != "" {
+			add("notify.matrix.room", "notify.matrix.room can only be set in %s", userOnly)
+		}
+		for _, p := range HookPoints {
+			if len(c.Hooks.commands(p).Host) > 0 {
+				add("hooks."+p+".host", "hooks.%s.host can only be set in %s", p, userOnly)
+			}
+		}
+		for _, cache := range c.Caches {
+			if strings.Contains(cache, ":") {
+				add("caches", "cache %q: only well-known caches can be set per repository", cache)
+			}
+		}
+	}
+	if l := c.Limits; l.CPUs != nil && *l.CPUs < 0 {
+		add("limits.cpus", "invalid cpus %d", *l.CPUs)
+	}
+	if c.Limits.Memory != "" {
+		if err := ValidateSize("memory", c.Limits.Memory); err != nil {
+			add("limits.memory", "%v", err)
+		}
+	}
+	if c.Limits.PidsLimit < 0 {
+		add("limits.pids_limit", "invalid pids_limit %d", c.Limits.PidsLimit)
+	}
+	if c.Limits.ShmSize != "" {
+		if err := ValidateSize("shm_size", c.Limits.ShmSize); err != nil {
+			add("limits.shm_size", "%v", err)
+		}
+	}
+	if p := c.Disk.UsedPercent; p != nil && (*p < 0 || *p > 100) {
+		add("disk.used_percent", "invalid used_percent %d: use a percentage between 0 and 100", *p)
+	}
+	if p := c.Disk.InodesPercent; p != nil && (*p < 0 || *p > 100) {
+		add("disk.inodes_percent", "invalid inodes_percent %d: use a percentage between 0 and 100", *p)
+	}
+	if c.Disk.Layer != "" {
+		if err := ValidateSize("layer", c.Disk.Layer); err != nil {
+			add("disk.layer", "%v", err)
+		}
+	}
+	if c.Disk.Src != "" {
+		if err := ValidateSize("src", c.Disk.Src); err != nil {
+			add("disk.src", "%v", err)
+		}
+	}
+	if len(c.Hooks.PreBuild.Container) > 0 {
+		add("hooks.pre_build.container", "hooks.pre_build.container: the container doesn't exist before the build; use host")
+	}
+	if k := c.Kubernetes; k.Context != "" && k.Registry == "" {
+		add("kubernetes.registry", "kubernetes.registry is required to run on Kubernetes")
+	}
+	for _, name := range c.EnvFiles {
+		if !reEnvFile.MatchString(name) {
+			add("env_files", "invalid env file name %q", name)
+		}
+	}
+	if c.BranchTemplate != "" {
+		if _, err := ParseBranchTemplate(c.BranchTemplate); err != nil {
+			add("branch_template", "%v", err)
+		}
+	}
+	if c.EnvInject != "" {
+		if err := ValidateEnvInject(c.EnvInject); err != nil {
+			add("env_inject", "%v", err)
+		}
+	}
+	if c.Approval.Prompt != "" && !slices.Contains(ApprovalPrompts, c.Approval.Prompt) {
+		add("approval.prompt", "invalid prompt %q: use %s", c.Approval.Prompt, strings.Join(ApprovalPrompts, ", "))
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.CacheSets)) {
+		set := c.CacheSets[name]
+		if !reCacheName.MatchString(name) {
+			add("cache_sets", "invalid cache set name %q: use lowercase letters, digits, '-' and '_'", name)
+		} else if _, ok := builtinCaches[name]; ok {
+			add("cache_sets", "cache set %q is a well-known cache", name)
+		}
+		if len(set.Paths) == 0 {
+			add("cache_sets."+name+".paths", "cache set %q has no paths", name)
+		} else if _, err := set.Mounts(name); err != nil {
+			add("cache_sets."+name+".paths", "cache set %q: %v", name, err)
+		}
+	}
+	for _, h := range c.Harnesses {
+		if _, ok := HarnessMounts[Harness(h)]; !ok {
+			add("harnesses", "unknown harness %q", h)
+		}
+	}
+	for _, l := range c.Labels {
+		if k, _, ok := strings.Cut(l, "="); !ok || k == "" {
+			add("labels", "invalid label %q: use key=value", l)
+		}
+	}
+	for _, spec := range c.HostPorts {
+		if _, err := ParseHostPort(spec); err != nil {
+			add("host_ports", "%v", err)
+		}
+	}
+	for _, u := range c.Notify.Webhooks {
+		if !isHTTPURL(u) {
+			add("notify.webhooks", "invalid webhook %q: use an http or https URL", u)
+		}
+	}
+	for _, u := range c.Notify.Slack {
+		if !isHTTPURL(u) {
+			add("notify.slack", "invalid Slack webhook %q: use an http or https URL", u)
+		}
+	}
+	if m := c.Notify.Matrix; m.Homeserver != "" || m.Room != "" {
+		if !isHTTPURL(m.Homeserver) {
+			add("notify.matrix.homeserver", "invalid Matrix homeserver %q: use an http or https URL", m.Homeserver)
+		}
+		if !strings.HasPrefix(m.Room, "!") || !strings.Contains(m.Room, ":") {
+			add("notify.matrix.room", "invalid Matrix room %q: use a room ID like !abc123:matrix.org", m.Room)
+		}
+	}
+	for _, e := range c.Notify.Events {
+		if !slices.Contains(EventTypes, e) {
+			add("notify.events", "unknown event %q; use one of %s", e, strings.Join(EventTypes, ", "))
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.Workspaces)) {
+		if !workspaceNameRe.MatchString(name) {
+			add("workspaces."+name, "invalid workspace name %q", name)
+		}
+		if len(c.Workspaces[name]) == 0 {
+			add("workspaces."+name, "workspace %q has no repositories", name)
+		}
+		for _, spec := range c.Workspaces[name] {
+			if p, _, _ := strings.Cut(spec, ":"); !filepath.IsAbs(p) && !strings.HasPrefix(p, "~/") {
+				add("workspaces."+name, "workspace %q: repository path %q must be absolute or start with ~/", name, p)
+			}
+		}
+	}
+	if repo {
+		for _, cmd := range slices.Sorted(maps.Keys(c.Args)) {
+			for _, a := range c.Args[cmd] {
+				name, _, _ := strings.Cut(strings.TrimLeft(a, "-"), "=")
+				if strings.HasPrefix(a, "-") && !slices.Contains(repoAllowedArgs, name) {
+					add("args."+cmd, "args.%s: %s can't be set per repository", cmd, a)
+				}
+			}
+		}
+	}
+	return errs
+}
+
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// AgentPaths returns the harness mounts selected by Harnesses, or all of
+// them when empty.
+func (c *Config) AgentPaths() []AgentPaths {
+	if len(c.Harnesses) == 0 {
+		return slices.Collect(maps.Values(HarnessMounts))
+	}
+	out := make([]AgentPaths, 0, len(c.Harnesses))
+	for _, h := range c.Harnesses {
+		out = append(out, HarnessMounts[Harness(h)])
+	}
+	return out
+}
+
+// decodeConfig decodes the TOML file p into cfg, rejecting unknown keys. A
+// missing file leaves cfg untouched.
+func decodeConfig(p string, cfg *Config) error {
+	md, err := toml.DecodeFile(p, cfg)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("parsing %s: %w", p, err)
+	}
+	if u := md.Undecoded(); len(u) > 0 {
+		keys := make([]string, len(u))
+		for i, k := range u {
+			keys[i] = k.String()
+		}
+		return fmt.Errorf("%s: unknown keys: %s", p, strings.Join(keys, ", "))
+	}
+	return nil
+}
+
+// ConfigIssue is a problem found by [CheckConfigFile].
+type ConfigIssue struct {
+	// Line and Col locate the problem, starting at 1. Zero when unknown.
+	Line int `json:"line,omitempty"`
+	Col  int `json:"col,omitempty"`
+	// Key is the dotted key the problem is about, if any.
+	Key     string `json:"key,omitempty"`
+	Message string `json:"message"`
+	// Warning is set for suspicious but accepted settings. Other issues make
+	// md refuse to load the file.
+	Warning bool `json:"warning,omitempty"`
+}
+
+var tomlErrRe = regexp.MustCompile(`^toml: (?:line (\d+) )?\(last key "([^"]*)"\): (.*)$`)
+
+// CheckConfigFile lints the configuration file p: syntax and type errors,
+// unknown keys, invalid values and, with repo, settings a [RepoConfigFile]
+// can't hold. commands, when not nil, lists the subcommands [Config.Args]
+// may refer to. It returns an error only when p can't be read.
+func CheckConfigFile(p string, repo bool, commands []string) ([]ConfigIssue, error) {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	src := string(data)
+	cfg := &Config{}
+	meta, err := toml.Decode(src, cfg)
+	if err != nil {
+		var pe toml.ParseError
+		if errors.As(err, &pe) {
+			return []ConfigIssue{{Line: pe.Position.Line, Col: pe.Position.Col, Key: pe.LastKey, Message: pe.Message}}, nil
+		}
+		if m := tomlErrRe.FindStringSubmatch(err.Error()); m != nil {
+			line, col := keyPosition(src, m[2])
+			return []ConfigIssue{{Line: line, Col: col, Key: m[2], Message: m[3]}}, nil
+		}
+		return []ConfigIssue{{Message: err.Error()}}, nil
+	}
+	var issues []ConfigIssue
+	add := func(key, msg string, warning bool) {
+		line, col := keyPosition(src, key)
+		issues = append(issues, ConfigIssue{Line: line, Col: col, Key: key, Message: msg, Warning: warning})
+	}
+	known := configKeys(reflect.TypeFor[Config](), "")
+	for _, k := range meta.Undecoded() {
+		msg := "unknown key " + k.String()
+		if s := closest(k.String(), known); s != "" {
+			msg += "; did you mean " + s + "?"
+		}
+		add(k.String(), msg, false)
+	}
+	for _, e := range cfg.check(repo) {
+		add(e.key, e.msg, false)
+	}
+	for _, c := range cfg.Caches {
+		if slices.Contains(cfg.NoCaches, c) {
+			add("no_caches", fmt.Sprintf("cache %q is both added and excluded", c), true)
+		}
+	}
+	if commands != nil {
+		for _, cmd := range slices.Sorted(maps.Keys(cfg.Args)) {
+			if !slices.Contains(commands, cmd) {
+				add("args."+cmd, fmt.Sprintf("args.%s: unknown command %q, ignored", cmd, cmd), true)
+			}
+		}
+	}
+	if !repo && cfg.Tailscale.APIKey != "" && runtime.GOOS != "windows" {
+		if fi, err := os.Stat(p); err == nil && fi.Mode().Perm()&0o077 != 0 {
+			add("tailscale.api_key", fmt.Sprintf("the file holds an API key but has mode %s; chmod 600 it", fi.Mode().Perm()), true)
+		}
+	}
+	slices.SortStableFunc(issues, func(a, b ConfigIssue) int { return a.Line - b.Line })
+	return issues, nil
+}
+
+// keyPosition returns the line and column where the dotted key is defined in
+// the TOML document src, or zeros when it can't be found. It understands
+// [table] headers and key = value lines, which is what config files use.
+func keyPosition(src, key string) (int, int) {
+	table, name := "", key
+	if i := strings.LastIndexByte(key, '.'); i >= 0 {
+		table, name = key[:i], key[i+1:]
+	}
+	current := ""
+	for i, line := range strings.Split(src, "\n") {
+		t := strings.TrimSpace(line)
+		col := len(line) - len(strings.TrimLeft(line, " \t")) + 1
+		if strings.HasPrefix(t, "[") {
+			current = strings.TrimSpace(strings.Trim(t, "[]"))
+			if current == key {
+				return i + 1, col
+			}
+			continue
+		}
+		k, _, ok := strings.Cut(t, "=")
+		if !ok {
+			continue
+		}
+		k = strings.Trim(strings.TrimSpace(k), `"`)
+		if (current == table && k == name) || (current == "" && k == key) {
+			return i + 1, col
+		}
+	}
+	return 0, 0
+}
+
+// configKeys returns the dotted keys of the struct type t, per toml tags.
+func configKeys(t reflect.Type, prefix string) []string {
+	var keys []string
+	for i := range t.NumField() {
+		f := t.Field(i)
+		k := prefix + f.Tag.Get("toml")
+		keys = append(keys, k)
+		if f.Type.Kind() == reflect.Struct {
+			keys = append(keys, configKeys(f.Type, k+".")...)
+		}
+	}
+	return keys
+}
+
+// closest returns the candidate within an edit distance of 2 of s, if any.
+func closest(s string, candidates []string) string {
+	best, bestD := "", 3
+	for _, c := range candidates {
+		if d := editDistance(s, c); d < bestD {
+			best, bestD = c, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// configDescriptions documents the keys in [ConfigSchema].
+var configDescriptions = map[string]string{
+	"runtime":                    "Container engine. User config only.",
+	"image":                      "Full base image reference used by start, run and fork.",
+	"caches":                     "Caches added to the defaults, like --cache: a well-known name or host:container. A repository config may only name well-known caches.",
+	"no_caches":                  "Well-known default caches to exclude, like --no-cache.",
+	"labels":                     "Container labels (key=value), like --label.",
+	"host_ports":                 "Host services reachable from the containers at host.docker.internal, like --host-port: port, port=ENV or ollama. User config only.",
+	"harnesses":                  "Agent harnesses whose config directories are mounted. Empty mounts all of them.",
+	"agent":                      "Command line md task runs in the container, with the task prompt appended as its last argument. Default: claude -p.",
+	"branch_template":            "Template of the branches md task from-issue and md fork create, with {{.Agent}}, {{.IssueNumber}}, {{.Slug}}, {{.Branch}} and {{.Repo}}, e.g. agent/{{.Agent}}/{{.IssueNumber}}-{{.Slug}}. A taken name gets a -2, -3... suffix.",
+	"setup":                      "Shell command md start runs in the primary repository once it is in the container, before the agent connects, instead of .md/setup.sh. A failure fails the start.",
+	"context_dir":                "Directory laid out like md's rsc/ used by md build-image instead of the embedded build context. User config only.",
+	"detect_caches":              "Include by default only the well-known caches of the tools the repository uses (go.mod, package.json, Cargo.toml, ...), like --detect-caches.",
+	"cache_sets":                 "Named caches usable like the well-known ones with --cache and --no-cache, and included by default. User config only.",
+	"cache_sets.*.description":   "Description shown in md's help.",
+	"cache_sets.*.paths":         "Directories to cache, host:container[:ro]; the host one may start with ~/.",
+	"cache_sets.*.detect":        "Files, relative to the repository's root, whose presence enables the set with detect_caches.",
+	"tailscale":                  "Tailscale defaults.",
+	"tailscale.enabled":          "Join the tailnet by default, like --tailscale.",
+	"tailscale.api_key":          "Used when $TAILSCALE_API_KEY is not set. User config only.",
+	"args":                       "Default arguments per subcommand, inserted before the command line ones.",
+	"notify":                     "Lifecycle event notifications.",
+	"notify.webhooks":            "http(s) URLs each event is POSTed to as JSON. User config only.",
+	"notify.commands":            "Shell commands run with the JSON event on stdin and $MD_EVENT set to its type. User config only.",
+	"notify.slack":               "Slack incoming webhook URLs a readable message is posted to. User config only.",
+	"notify.matrix":              "Matrix room a readable message is posted to. User config only.",
+	"notify.matrix.homeserver":   "Matrix client API base URL, e.g. https://matrix.org. User config only.",
+	"notify.matrix.room":         "Matrix room ID, e.g. !abc123:matrix.org. User config only.",
+	"notify.matrix.token":        "Matrix access token, used when $MATRIX_TOKEN is not set. User config only.",
+	"notify.events":              "Event types to send: start, kill, pull, agent_finished, idle. Empty sends all.",
+	"limits":                     "Default resource limits of md start and md run, overridden by their flags.",
+	"limits.cpus":                "CPU cores, like --cpus. 0 means no limit. Default: the host's cores minus 2, at least 2.",
+	"limits.memory":              "Memory limit, like --memory, e.g. 16g.",
+	"limits.pids_limit":          "Maximum number of processes, like --pids-limit.",
+	"limits.shm_size":            "Size of /dev/shm, like --shm-size, e.g. 1g.",
+	"disk":                       "Disk usage thresholds md list --stats, md status and md gc warn about.",
+	"disk.used_percent":          "Warn when the filesystem holding the containers is this full, in percent. Default: 90. 0 disables.",
+	"disk.inodes_percent":        "Warn when that filesystem used this share of its inodes, in percent. Default: 90. 0 disables.",
+	"disk.layer":                 "Warn when a container's writable layer grows past this size, e.g. 50g.",
+	"disk.src":                   "Warn when a container's ~/src grows past this size, e.g. 20g.",
+	"hooks":                      "Commands run at lifecycle hook points. A failing pre_* command aborts the operation.",
+	"hooks.pre_build":            "Run before building a container's specialized image.",
+	"hooks.pre_build.host":       "Shell commands run on the host in the repository's root. User config only.",
+	"hooks.pre_build.container":  "Not supported: the container doesn't exist yet.",
+	"hooks.post_start":           "Run once a started container has its repositories.",
+	"hooks.post_start.host":      "Shell commands run on the host in the repository's root. User config only.",
+	"hooks.post_start.container": "Shell commands run in the container in ~/src/<repo>, e.g. to install the repository's tools.",
+	"hooks.pre_push":             "Run before md push sends a repository to the container.",
+	"hooks.pre_push.host":        "Shell commands run on the host in the repository's root. User config only.",
+	"hooks.pre_push.container":   "Shell commands run in the container in ~/src/<repo>.",
+	"hooks.post_pull":            "Run after md pull brought a repository's changes back.",
+	"hooks.post_pull.host":       "Shell commands run on the host in the repository's root. User config only.",
+	"hooks.post_pull.container":  "Shell commands run in the container in ~/src/<repo>.",
+	"hooks.pre_kill":             "Run before md kill removes a container. A failure is reported and the container is removed anyway.",
+	"hooks.pre_kill.host":        "Shell commands run on the host in the repository's root. User config only.",
+	"hooks.pre_kill.container":   "Shell commands run in the container in ~/src/<repo>, when it is running.",
+	"kubernetes":                 "Run the containers as pods on a Kubernetes cluster instead of the local engine. User config only.",
+	"kubernetes.context":         "kubeconfig context the containers run on, like --kube-context. Setting it enables the backend.",
+	"kubernetes.namespace":       "Namespace of the pods. Default: the context's.",
+	"kubernetes.registry":        "Private image repository the cluster pulls md's images from, e.g. registry.example.com/md.",
+	"proxy":                      "HTTP(S) proxy passed to image builds and containers and used by md. User config only.",
+	"proxy.http":                 "Proxy URL of http:// requests. Default: $HTTP_PROXY.",
+	"proxy.https":                "Proxy URL of https:// requests. Default: $HTTPS_PROXY.",
+	"proxy.no_proxy":             "Comma-separated hosts, domains, IP addresses and CIDR ranges reached directly. Default: $NO_PROXY.",
+	"sign_commits":               "Sign the container's commits on the host, with its git signing setup, when md pull brings them in.",
+	"conventional_commits":       "Generate commit messages following Conventional Commits, type(scope): description, in md pull and md gerrit push.",
+	"env_files":                  "md env files injected into the containers after the default one, like --env-file. User config only.",
+	"env_inject":                 "How the md env files reach the containers: file (~/.env, the default) or engine (the engine's --env-file).",
+	"approval":                   "Confirmation of the operations agents run through md mcp and md serve; the read-only ones are always approved. User config only.",
+	"approval.auto_approve":      "Operations run without asking, like push or pull; all asks for none.",
+	"approval.prompt":            "How the user is asked: auto (the terminal when there is one, else a desktop dialog), terminal, dialog or deny.",
+	"workspaces":                 "Named sets of repositories (\"path[:branch]\", absolute or ~/) for md ws, one container each. User config only.",
+}
+
+// ConfigSchema returns a JSON Schema describing the configuration files, for
+// editor completion and validation (e.g. with taplo or Even Better TOML).
+func ConfigSchema() ([]byte, error) {
+	schema := configSchema(reflect.TypeFor[Config](), "")
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "md configuration (config.toml, .md.toml)"
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+func configSchema(t reflect.Type, key string) map[string]any {
+	var s map[string]any
+	switch t.Kind() {
+	case reflect.Pointer:
+		return configSchema(t.Elem(), key)
+	case reflect.Bool:
+		s = map[string]any{"type": "boolean"}
+	case reflect.Int:
+		s = map[string]any{"type": "integer", "minimum": 0}
+	case reflect.String:
+		s = map[string]any{"type": "string"}
+		if key == "runtime" {
+			s["enum"] = []string{"docker", "podman"}
+		}
+	case reflect.Slice:
+		items := configSchema(t.Elem(), "")
+		switch key {
+		case "harnesses":
+			items["enum"] = slices.Sorted(maps.Keys(HarnessMounts))
+		case "notify.events":
+			items["enum"] = EventTypes
+		}
+		s = map[string]any{"type": "array", "items": items}
+	case reflect.Map:
+		s = map[string]any{"type": "object", "additionalProperties": configSchema(t.Elem(), key+".*")}
+	case reflect.Struct:
+		props := map[string]any{}
+		for i := range t.NumField() {
+			f := t.Field(i)
+			name := f.Tag.Get("toml")
+			k := name
+			if key != "" {
+				k = key + "." + name
+			}
+			props[name] = configSchema(f.Type, k)
+		}
+		s = map[string]any{"type": "object", "properties": props, "additionalProperties": false}
+	default:
+		panic("unsupported config field type " + t.String())
+	}
+	if d := configDescriptions[key]; d != "" {
+		s["description"] = d
+	}
+	return s
+}
@@ -0,0 +1,357 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, p, content string) {
+	t.Helper()
+	if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Run("missing", func(t *testing.T) {
+		cfg, err := LoadConfig(filepath.Join(t.TempDir(), "config.toml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Image != "" || len(cfg.AgentPaths()) != len(HarnessMounts) {
+			t.Errorf("got %+v", cfg)
+		}
+	})
+	t.Run("valid", func(t *testing.T) {
+		p := filepath.Join(t.TempDir(), "config.toml")
+		writeFile(t, p, `runtime = "podman"
+image = "ghcr.io/me/md:latest"
+caches = ["go-mod"]
+harnesses = ["claude"]
+
+[tailscale]
+enabled = true
+api_key = "tskey-api-x"
+
+[args]
+start = ["--display"]
+`)
+		cfg, err := LoadConfig(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Runtime != "podman" || cfg.Image != "ghcr.io/me/md:latest" || cfg.Tailscale.Enabled == nil || !*cfg.Tailscale.Enabled || cfg.Tailscale.APIKey != "tskey-api-x" {
+			t.Errorf("got %+v", cfg)
+		}
+		if !slices.Equal(cfg.Args["start"], []string{"--display"}) {
+			t.Errorf("Args = %v", cfg.Args)
+		}
+		if ap := cfg.AgentPaths(); len(ap) != 1 || ap[0].Description != HarnessMounts[HarnessClaude].Description {
+			t.Errorf("AgentPaths() = %+v", ap)
+		}
+	})
+	for name, content := range map[string]string{
+		"syntax":          `image = `,
+		"unknown_key":     `imagee = "x"`,
+		"invalid_runtime": `runtime = "lxc"`,
+		"unknown_harness": `harnesses = ["nope"]`,
+		"invalid_label":   `labels = ["novalue"]`,
+		"bad_host_port":   `host_ports = ["llama"]`,
+		"bad_prompt":      "[approval]\nprompt = \"yes\"",
+		"relative_ws":     "[workspaces]\nbackend = [\"src/api\"]",
+		"empty_ws":        "[workspaces]\nbackend = []",
+		"bad_webhook":     "[notify]\nwebhooks = [\"ftp://x\"]",
+		"bad_event":       "[notify]\nevents = [\"started\"]",
+		"bad_room":        "[notify.matrix]\nhomeserver = \"https://matrix.org\"\nroom = \"#md:matrix.org\"",
+	} {
+		t.Run(name, func(t *testing.T) {
+			p := filepath.Join(t.TempDir(), "config.toml")
+			writeFile(t, p, content)
+			if _, err := LoadConfig(p); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestConfigWorkspace(t *testing.T) {
+	cfg := &Config{Workspaces: map[string][]string{"backend": {"~/src/api", "/srv/worker:dev"}}}
+	got, err := cfg.Workspace("backend", "/home/me")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{filepath.Join("/home/me", "src/api"), "/srv/worker:dev"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := cfg.Workspace("frontend", "/home/me"); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestLoadRepoConfig(t *testing.T) {
+	enabled := true
+	base := &Config{
+		Image:     "base",
+		Caches:    []string{"go-mod"},
+		Labels:    []string{"a=1"},
+		Tailscale: TailscaleConfig{Enabled: &enabled, APIKey: "key"},
+		Args:      map[string][]string{"start": {"--display"}, "run": {"--cpus=2"}},
+		Limits:    LimitsConfig{Memory: "8g", PidsLimit: 256},
+		Hooks:     HooksConfig{PostStart: HookCommands{Host: []string{"notify-send started"}, Container: []string{"setup-dotfiles"}}},
+	}
+	t.Run("missing", func(t *testing.T) {
+		cfg, err := LoadRepoConfig(base, t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Image != "base" || cfg.Tailscale.APIKey != "key" {
+			t.Errorf("got %+v", cfg)
+		}
+	})
+	t.Run("merge", func(t *testing.T) {
+		dir := t.TempDir()
+		writeFile(t, filepath.Join(dir, RepoConfigFile), `image = "repo"
+caches = ["npm"]
+labels = ["b=2"]
+setup = "npm ci"
+
+[tailscale]
+enabled = false
+
+[args]
+start = ["--browser"]
+
+[limits]
+memory = "4g"
+
+[hooks.post_start]
+container = ["make tools"]
+`)
+		cfg, err := LoadRepoConfig(base, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Image != "repo" || cfg.Setup != "npm ci" {
+			t.Errorf("Image = %q, Setup = %q", cfg.Image, cfg.Setup)
+		}
+		if !slices.Equal(cfg.Caches, []string{"go-mod", "npm"}) || !slices.Equal(cfg.Labels, []string{"a=1", "b=2"}) {
+			t.Errorf("Caches = %v, Labels = %v", cfg.Caches, cfg.Labels)
+		}
+		if *cfg.Tailscale.Enabled || cfg.Tailscale.APIKey != "key" {
+			t.Errorf("Tailscale = %+v", cfg.Tailscale)
+		}
+		if !slices.Equal(cfg.Args["start"], []string{"--browser"}) || !slices.Equal(cfg.Args["run"], []string{"--cpus=2"}) {
+			t.Errorf("Args = %v", cfg.Args)
+		}
+		if cfg.Limits.Memory != "4g" || cfg.Limits.PidsLimit != 256 {
+			t.Errorf("Limits = %+v", cfg.Limits)
+		}
+		if h := cfg.Hooks.PostStart; !slices.Equal(h.Host, []string{"notify-send started"}) || !slices.Equal(h.Container, []string{"setup-dotfiles", "make tools"}) {
+			t.Errorf("Hooks = %+v", cfg.Hooks)
+		}
+		if !slices.Equal(base.Caches, []string{"go-mod"}) || !slices.Equal(base.Args["start"], []string{"--display"}) {
+			t.Error("base was modified")
+		}
+	})
+	for name, content := range map[string]string{
+		"runtime":      `runtime = "docker"`,
+		"api_key":      "[tailscale]\napi_key = \"x\"",
+		"context_dir":  `context_dir = "/tmp/rsc"`,
+		"workspaces":   "[workspaces]\nx = [\"/src/a\"]",
+		"notify_cmds":  "[notify]\ncommands = [\"curl x\"]",
+		"webhooks":     "[notify]\nwebhooks = [\"https://evil.example.com/hook\"]",
+		"slack":        "[notify]\nslack = [\"https://hooks.slack.com/services/x\"]",
+		"matrix_token": "[notify.matrix]\ntoken = \"syt_x\"",
+		"matrix_room":  "[notify.matrix]\nhomeserver = \"https://evil.example.com\"\nroom = \"!x:evil.example.com\"",
+		"host_cache":   `caches = ["/home/me/.ssh:/home/user/.ssh"]`,
+		"docker_flag":  "[args]\nstart = [\"--docker-flag=--privileged\"]",
+		"mount":        "[args]\nstart = [\"--mount\", \"/:/host\"]",
+		"mount_src":    "[args]\nstart = [\"--mount-src\"]",
+		"creds":        "[args]\nstart = [\"-creds=aws\"]",
+		"host_ports":   `host_ports = ["2375"]`,
+		"host_port":    "[args]\nstart = [\"--host-port=5432\"]",
+		"bind":         "[args]\nstart = [\"--bind=0.0.0.0\"]",
+		"network":      "[args]\nstart = [\"--network=host\"]",
+		"kubernetes":   "[kubernetes]\ncontext = \"prod\"\nregistry = \"r.example.com/md\"",
+		"host_hook":    "[hooks.pre_push]\nhost = [\"make lint\"]",
+	} {
+		t.Run("denied_"+name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFile(t, filepath.Join(dir, RepoConfigFile), content)
+			if _, err := LoadRepoConfig(base, dir); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestCheckConfigFile(t *testing.T) {
+	check := func(t *testing.T, name, content string, repo bool) []ConfigIssue {
+		p := filepath.Join(t.TempDir(), name)
+		writeFile(t, p, content)
+		issues, err := CheckConfigFile(p, repo, []string{"start"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return issues
+	}
+	t.Run("valid", func(t *testing.T) {
+		if issues := check(t, "config.toml", "image = \"x\"\n[args]\nstart = [\"--display\"]\n", false); len(issues) != 0 {
+			t.Errorf("got %+v", issues)
+		}
+	})
+	t.Run("syntax", func(t *testing.T) {
+		issues := check(t, "config.toml", "image = \"x\"\nlabels = [\n", false)
+		if len(issues) != 1 || issues[0].Line != 2 || issues[0].Key != "labels" || issues[0].Warning {
+			t.Errorf("got %+v", issues)
+		}
+	})
+	t.Run("type", func(t *testing.T) {
+		issues := check(t, "config.toml", "\n  image = 3\n", false)
+		if len(issues) != 1 || issues[0].Line != 2 || issues[0].Col != 3 || issues[0].Key != "image" {
+			t.Errorf("got %+v", issues)
+		}
+	})
+	t.Run("limits", func(t *testing.T) {
+		issues := check(t, "config.toml", "[limits]\ncpus = 4\nmemory = \"8 gigs\"\npids_limit = 512\nshm_size = \"1g\"\n", false)
+		want := []ConfigIssue{{Line: 3, Col: 1, Key: "limits.memory", Message: `invalid memory "8 gigs": use a number of bytes with an optional unit, e.g. 512m or 8g`}}
+		if !slices.Equal(issues, want) {
+			t.Errorf("got:\n%+v\nwant:\n%+v", issues, want)
+		}
+	})
+	t.Run("disk", func(t *testing.T) {
+		issues := check(t, "config.toml", "[disk]\nused_percent = 95\ninodes_percent = 120\nsrc = \"20g\"\n", false)
+		want := []ConfigIssue{{Line: 3, Col: 1, Key: "disk.inodes_percent", Message: "invalid inodes_percent 120: use a percentage between 0 and 100"}}
+		if !slices.Equal(issues, want) {
+			t.Errorf("got:\n%+v\nwant:\n%+v", issues, want)
+		}
+	})
+	t.Run("hooks", func(t *testing.T) {
+		issues := check(t, RepoConfigFile, "[hooks.pre_build]\ncontainer = [\"make\"]\n[hooks.post_start]\nhost = [\"make\"]\ncontainer = [\"make tools\"]\n", true)
+		want := []ConfigIssue{
+			{Line: 2, Col: 1, Key: "hooks.pre_build.container", Message: "hooks.pre_build.container: the container doesn't exist before the build; use host"},
+			{Line: 4, Col: 1, Key: "hooks.post_start.host", Message: "hooks.post_start.host can only be set in ~/.config/md/config.toml"},
+		}
+		if !slices.Equal(issues, want) {
+			t.Errorf("got:\n%+v\nwant:\n%+v", issues, want)
+		}
+	})
+	t.Run("kubernetes", func(t *testing.T) {
+		issues := check(t, "config.toml", "[kubernetes]\ncontext = \"dev\"\n", false)
+		want := []ConfigIssue{{Key: "kubernetes.registry", Message: "kubernetes.registry is required to run on Kubernetes"}}
+		if !slices.Equal(issues, want) {
+			t.Errorf("got:\n%+v\nwant:\n%+v", issues, want)
+		}
+	})
+	t.Run("env", func(t *testing.T) {
+		issues := check(t, RepoConfigFile, `env_files = ["work"]
+env_inject = "docker"
+[args]
+start = ["--env-file=work"]
+`, true)
+		want := []ConfigIssue{
+			{Line: 1, Col: 1, Key: "env_files", Message: "env_files can only be set in ~/.config/md/config.toml"},
+			{Line: 2, Col: 1, Key: "env_inject", Message: `invalid env injection "docker": use file or engine`},
+			{Line: 4, Col: 1, Key: "args.start", Message: `args.start: --env-file=work can't be set per repository`},
+		}
+		if !slices.Equal(issues, want) {
+			t.Errorf("got:\n%+v\nwant:\n%+v", issues, want)
+		}
+	})
+	t.Run("approval", func(t *testing.T) {
+		issues := check(t, RepoConfigFile, "[approval]\nauto_approve = [\"all\"]\n", true)
+		want := []ConfigIssue{{Line: 1, Col: 1, Key: "approval", Message: "approval can only be set in ~/.config/md/config.toml"}}
+		if !slices.Equal(issues, want) {
+			t.Errorf("got:\n%+v\nwant:\n%+v", issues, want)
+		}
+	})
+	t.Run("cache_sets", func(t *testing.T) {
+		issues := check(t, "config.toml", `[cache_sets.bazel]
+paths = ["~/.cache/bazel:/home/user/.cache/bazel"]
+detect = ["MODULE.bazel"]
+[cache_sets.npm]
+paths = ["~/x:/home/user/x"]
+[cache_sets.ccache]
+paths = ["~/.ccache:relative"]
+`, false)
+		want := []ConfigIssue{
+			{Key: "cache_sets", Message: `cache set "npm" is a well-known cache`},
+			{Line: 7, Col: 1, Key: "cache_sets.ccache.paths", Message: `cache set "ccache": invalid path "~/.ccache:relative": the container path must be absolute`},
+		}
+		if !slices.Equal(issues, want) {
+			t.Errorf("got:\n%+v\nwant:\n%+v", issues, want)
+		}
+	})
+	t.Run("args", func(t *testing.T) {
+		issues := check(t, RepoConfigFile, `[args]
+start = ["--display", "-cpus=4", "--cache=/home/me/.ssh:/x", "-e", "../other", "--extra-repo=../a", "--with-repo", "../b", "--", "x"]
+`, true)
+		want := []ConfigIssue{
+			{Line: 2, Col: 1, Key: "args.start", Message: "args.start: --cache=/home/me/.ssh:/x can't be set per repository"},
+			{Line: 2, Col: 1, Key: "args.start", Message: "args.start: -e can't be set per repository"},
+			{Line: 2, Col: 1, Key: "args.start", Message: "args.start: --extra-repo=../a can't be set per repository"},
+			{Line: 2, Col: 1, Key: "args.start", Message: "args.start: --with-repo can't be set per repository"},
+			{Line: 2, Col: 1, Key: "args.start", Message: "args.start: -- can't be set per repository"},
+		}
+		if !slices.Equal(issues, want) {
+			t.Errorf("got:\n%+v\nwant:\n%+v", issues, want)
+		}
+	})
+	t.Run("all", func(t *testing.T) {
+		issues := check(t, RepoConfigFile, `imag = "x"
+runtime = "docker"
+harnesses = ["nope"]
+
+[tailscale]
+api_key = "k"
+
+[args]
+strat = ["--display"]
+start = ["--mount=/:/host"]
+`, true)
+		want := []ConfigIssue{
+			{Line: 1, Col: 1, Key: "imag", Message: "unknown key imag; did you mean image?"},
+			{Line: 2, Col: 1, Key: "runtime", Message: "runtime can only be set in ~/.config/md/config.toml"},
+			{Line: 3, Col: 1, Key: "harnesses", Message: `unknown harness "nope"`},
+			{Line: 6, Col: 1, Key: "tailscale.api_key", Message: "tailscale.api_key can only be set in ~/.config/md/config.toml"},
+			{Line: 9, Col: 1, Key: "args.strat", Message: `args.strat: unknown command "strat", ignored`, Warning: true},
+			{Line: 10, Col: 1, Key: "args.start", Message: "args.start: --mount=/:/host can't be set per repository"},
+		}
+		if !slices.Equal(issues, want) {
+			t.Errorf("got:\n%+v\nwant:\n%+v", issues, want)
+		}
+	})
+}
+
+func TestConfigSchema(t *testing.T) {
+	b, err := ConfigSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema struct {
+		Properties map[string]json.RawMessage `json:"properties"`
+	}
+	if err := json.Unmarshal(b, &schema); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range configKeys(reflect.TypeFor[Config](), "") {
+		if strings.Contains(k, ".") {
+			continue
+		}
+		if _, ok := schema.Properties[k]; !ok {
+			t.Errorf("missing %s", k)
+		}
+		if configDescriptions[k] == "" {
+			t.Errorf("%s has no description", k)
+		}
+	}
+}
@@ -7,7 +7,6 @@ package md
 import (
 	"bytes"
 	"context"
-	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -15,6 +14,7 @@ import (
 	"io"
 	"log/slog"
 	"maps"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -43,6 +43,16 @@ type Repo struct {
 	DefaultRemote string `json:"default_remote,omitempty"`
 	// DefaultBranch is the default branch for DefaultRemote.
 	DefaultBranch string `json:"default_branch,omitempty"`
+	// VCS operates on the host repository. Nil means git.
+	VCS gitutil.VCS `json:"-"`
+}
+
+// vcs returns the backend operating on the host repository.
+func (r *Repo) vcs() gitutil.VCS {
+	if r.VCS == nil {
+		return gitutil.Git{}
+	}
+	return r.VCS
 }
 
 // StartOpts configures container startup.
@@ -53,6 +63,50 @@ type StartOpts struct {
 	BaseImage string
 	// Display enables X11/VNC virtual display (port 5901).
 	Display bool
+	// DisplaySize is the virtual display geometry as WIDTHxHEIGHT (e.g.
+	// "2560x1440"). Empty uses the container default of 1920x1080. Ignored
+	// unless Display is true.
+	DisplaySize string
+	// Displays is the number of virtual displays to start. Display :N listens
+	// on VNC port 5900+N. Zero or one starts only :1. Ignored unless Display
+	// is true. At most [MaxDisplays].
+	Displays int
+	// RDP enables an xrdp gateway (port 3389) in front of the VNC display so
+	// RDP clients can connect. Requires Display.
+	RDP bool
+	// Browser starts Chrome (Chromium on arm64) inside the container with
+	// the DevTools protocol published on port 9222 for browser automation
+	// tools such as Playwright or Puppeteer. The browser is headed on
+	// display :1 when Display is set, headless otherwise.
+	Browser bool
+	// Credentials lists host credentials to share with the container by name
+	// from [WellKnownCredentials]. Files are copied read-only; they are never
+	// bind-mounted.
+	Credentials []string
+	// ScopedCredentials generates narrower or short-lived credentials on the
+	// host (e.g. an AWS session, a gcloud access token, the current kube
+	// context only) instead of copying the credential files.
+	ScopedCredentials bool
+	// Mounts are host paths bind-mounted into the container, read-only unless
+	// ReadWrite is set. Validated against a security policy at launch.
+	Mounts []BindMount
+	// MountSource bind-mounts the host checkouts at /home/user/src/<name>
+	// instead of pushing them into clones. Fork doesn't apply, nor push and
+	// pull unless the mount is an overlay. See [SourceMount].
+	MountSource SourceMount
+	// CloneDepth makes the repositories' clones shallow, with that many
+	// commits of history, instead of pushing their whole history. Zero means
+	// full clones. See [Container.Deepen].
+	CloneDepth int
+	// CloneFilter makes the repositories' clones partial, e.g. "blob:none"
+	// to leave out the file contents of past commits. Empty means complete
+	// clones.
+	CloneFilter string
+	// SharedObjects makes the repositories' clones borrow the host
+	// repository's objects, like a git worktree, instead of receiving a copy:
+	// containers of the same repository share one object store and start
+	// without transferring its history.
+	SharedObjects bool
 	// Tailscale enables Tailscale networking inside the container.
 	//
 	// It is recommended to set Client.TailscaleAPIKey to enable ephemeral nodes. If Client.TailscaleAPIKey is
@@ -72,10 +126,20 @@ type StartOpts struct {
 	// Use well-known names from [WellKnownCaches] or construct [CacheMount]
 	// values directly. Paths that do not exist on the host are silently skipped.
 	Caches []CacheMount
+	// NoPull uses the local copy of the base image as is, even when the
+	// registry has a newer one. It is pulled only when missing.
+	NoPull bool
+	// Rebuild rebuilds the user image even when it is up to date.
+	Rebuild bool
 	// Labels are additional Docker labels (key=value) applied to the container.
 	Labels []string
 	// Quiet suppresses informational output during startup.
 	Quiet bool
+	// Nested is how to handle nested repositories in the working tree of a
+	// repository whose branch is checked out: dirty submodules, untracked
+	// repositories and gitlinks not in .gitmodules. Empty refuses to start
+	// with a [NestedError]; see [NestedInclude] and [NestedExclude].
+	Nested string
 	// AgentPaths specifies which agent config directories to mount. Pass one
 	// entry per harness using values from [HarnessMounts]. Always-mounted
 	// directories (~/.config/agents, ~/.config/md) are added automatically.
@@ -89,18 +153,76 @@ type StartOpts struct {
 	// Passed as --cpus to docker/podman. Zero means no limit.
 	// Use [DefaultMaxCPUs] for a sensible default.
 	MaxCPUs int
+	// Memory limits the container's memory, e.g. "8g", so a runaway build
+	// can't make the host swap. Passed as --memory. Empty means no limit.
+	Memory string
+	// PidsLimit limits the number of processes in the container, e.g.
+	// against fork bombs. Passed as --pids-limit. Zero means no limit.
+	PidsLimit int
+	// ShmSize is the size of /dev/shm, e.g. "1g" for browsers. Passed as
+	// --shm-size. Empty keeps the runtime's default (64 MiB for docker).
+	ShmSize string
 	// ExtraRunArgs are additional arguments passed verbatim to the
 	// container runtime's "run" command. Not portable across runtimes.
 	ExtraRunArgs []string
+	// PublishPorts publishes container ports on the host's loopback
+	// interface, so services started in the container are reachable from the
+	// host. The host ports are fixed and survive Stop and Resume.
+	PublishPorts []PortMapping
+	// HostPorts makes services listening on the host, like a local model
+	// server, reachable from the container at [HostGateway], and points
+	// their environment variables to them.
+	HostPorts []HostPort
+	// Bind is the host IP address the SSH, VNC, RDP, DevTools and published
+	// ports are bound to instead of 127.0.0.1. Anything else than a loopback
+	// address exposes them to other machines on purpose, which disables the
+	// exposure warning. See [Container.Launch].
+	Bind string
+	// Sudo is what the container's user may run with sudo. It is baked into
+	// the specialized image; see [SudoPolicy].
+	Sudo SudoPolicy
+	// TTL makes the container eligible for [Client.GC] once it has been idle
+	// that long. Zero means it is only reaped by an explicit idle threshold.
+	TTL time.Duration
+	// DevContainer applies the primary repository's devcontainer.json: its
+	// md-based image or Dockerfile when building the image, and its
+	// postCreateCommand once the repos are pushed. Its ports and environment
+	// are for the caller to merge in PublishPorts and ExtraEnv. See
+	// [LoadDevContainer].
+	DevContainer *DevContainer
+	// Setup is the shell command run in the primary repository once the
+	// repos are in the container, instead of its [SetupScript].
+	Setup string
+	// NoSetup skips Setup and the repositories' [SetupScript].
+	NoSetup bool
+	// NoSidecars doesn't start the services of the primary repository's
+	// [ComposeFile].
+	NoSidecars bool
+	// NetworkMode is the network the container joins: one of the Network
+	// constants, [NetworkPrivate] by default, or an existing network's name.
+	NetworkMode string
+	// NetworkPolicy, when set, restricts the container's outbound
+	// connections.
+	NetworkPolicy *NetworkPolicy
 }
 
 // StartResult contains Tailscale information from Connect. Port information
 // is available on Container directly (SSHPort, VNCPort) after Launch returns.
 type StartResult struct {
+	// VNCPorts are the host ports mapped to each virtual display, in display
+	// order (VNCPorts[0] is :1). Empty if display is disabled.
+	VNCPorts []int32
+	// BrowserWSURL is the Chrome DevTools Protocol ws:// endpoint of the
+	// container's browser. Empty if browser is disabled or did not start.
+	BrowserWSURL string
 	// TailscaleFQDN is the Tailscale FQDN assigned to the container, if any.
 	TailscaleFQDN string
-	// TailscaleAuthURL is the Tailscale auth URL when no pre-auth key was provided.
+	// TailscaleAuthURL is the Tailscale auth URL when no pre-auth key was
+	// provided. Connect doesn't wait for it: see [Container.WaitTailscaleAuth].
 	TailscaleAuthURL string
+	// TailscaleAuthPending is set when the Tailscale node waits for the user
+	// to log in.
+	TailscaleAuthPending bool
 }
 
 // Container holds state for a single container instance.
@@ -122,22 +244,124 @@ type Container struct {
 	// Display indicates the container was started with X11/VNC enabled.
 	// Label: md.display
 	Display bool
+	// Displays is the number of virtual displays when more than one was
+	// requested; zero otherwise.
+	// Label: md.displays
+	Displays int
+	// DisplaySize is the virtual display geometry (e.g. "2560x1440"), empty
+	// for the default.
+	// Label: md.display_size
+	DisplaySize string
+	// RDP indicates the container was started with the xrdp gateway.
+	// Label: md.rdp
+	RDP bool
+	// Browser indicates the container was started with a DevTools-enabled browser.
+	// Label: md.browser
+	Browser bool
+	// Credentials lists the host credentials shared with the container.
+	// Label: md.credentials
+	Credentials []string
+	// ScopedCredentials indicates Credentials were shared in scoped form.
+	// Label: md.credentials_scoped
+	ScopedCredentials bool
+	// Mounts are the host paths bind-mounted into the container.
+	// Label: md.mounts (base64-encoded JSON)
+	Mounts []BindMount
+	// MountSource is how the repos are mounted when the container works on
+	// the host checkouts instead of clones; empty for clones.
+	// Label: md.mount_src
+	MountSource SourceMount
+	// CloneDepth and CloneFilter are how shallow and partial the clones of
+	// the repos were made; zero and empty for complete ones.
+	// Labels: md.clone_depth, md.clone_filter
+	CloneDepth  int
+	CloneFilter string
+	// SharedObjects is whether the clones borrow the host repository's
+	// objects.
+	//
+	// Label: md.shared_objects
+	SharedObjects bool
 	// Tailscale indicates the container was started with Tailscale networking.
 	// Label: md.tailscale
 	Tailscale bool
 	// USB indicates the container was started with USB passthrough.
 	// Label: md.usb
 	USB bool
-
-	// SSHPort is the host port mapped to the container's SSH port.
+	// PublishedPorts are the container ports published on the host's
+	// loopback interface.
+	// Label: md.ports
+	PublishedPorts []PortMapping
+	// HostPorts are the host services reachable from the container.
+	// Label: md.host_ports
+	HostPorts []HostPort
+	// Bind is the host address the ports are bound to; empty for 127.0.0.1.
+	// Label: md.bind
+	Bind string
+	// Sudo is the sudo policy the image was built with; empty for the base
+	// image's.
+	// Label: md.sudo
+	Sudo SudoPolicy
+	// TTL is how long the container may stay idle before md gc reaps it; zero
+	// when unset.
+	// Label: md.ttl
+	TTL time.Duration
+	// MaxCPUs, Memory, PidsLimit and ShmSize are the resource limits the
+	// container was started with; zero when unlimited. See [StartOpts].
+	// Labels: md.cpus, md.memory, md.pids_limit, md.shm_size
+	MaxCPUs   int
+	Memory    string
+	PidsLimit int
+	ShmSize   string
+	// Sidecars indicates the container was started with the services of the
+	// primary repository's [ComposeFile].
+	// Label: md.sidecars
+	Sidecars bool
+	// Network is the network the container is on: its private one, one of
+	// the Network constants or a custom network. Empty for containers started
+	// before md chose their network. See [StartOpts.NetworkMode].
+	// Label: md.network
+	Network string
+	// NetworkPolicy restricts the container's outbound connections; nil when
+	// unrestricted.
+	// Label: md.egress
+	NetworkPolicy *NetworkPolicy
+	// LastUsed is when the container was last started, resumed, connected to
+	// over SSH or used by push, pull, diff or exec. Set by List; CreatedAt
+	// when unknown.
+	LastUsed time.Time
+
+	// SSHPort is the host port mapped to the container's SSH port, the port
+	// sshd listens on with [NetworkHost] and zero with [NetworkNone].
 	// Set by Launch; available immediately after Launch returns.
+	// Label: md.ssh_port, with NetworkHost only.
 	SSHPort int32
 	// VNCPort is the host port mapped to the container's VNC port, if display is enabled.
 	// Set by Launch; available immediately after Launch returns. Zero if display is disabled.
 	VNCPort int32
+	// VNCPorts are the host ports mapped to each virtual display, in display
+	// order. VNCPorts[0] equals VNCPort. Empty if display is disabled.
+	VNCPorts []int32
+	// RDPPort is the host port mapped to the container's RDP port, if RDP is enabled.
+	// Set by Launch; available immediately after Launch returns. Zero if RDP is disabled.
+	RDPPort int32
+	// CDPPort is the host port mapped to the container's DevTools port, if
+	// browser is enabled. Zero if browser is disabled.
+	CDPPort int32
 
 	// tailscaleEphemeral is set by Launch and consumed by Connect.
 	tailscaleEphemeral bool
+	// credentials is collected by Launch and consumed by Connect.
+	credentials *credentialBundle
+	// services is loaded by Launch and started by Connect.
+	services []Service
+	// composeFile is found by Launch and its services started by
+	// launchContainer.
+	composeFile string
+	// nested is found by Launch, by repo index, and copied by Connect.
+	nested map[int][]gitutil.NestedRepo
+	// checkpoint is the last step Connect completed, reported when it is
+	// interrupted.
+	checkpoint string
 }
 
 // Name returns the repository's base directory name, stripping any .git suffix.
@@ -145,17 +369,42 @@ func (r Repo) Name() string {
 	return strings.TrimSuffix(filepath.Base(r.GitRoot), ".git")
 }
 
+// RepoIndex returns the index in Repos of the repository named name, as
+// mapped in the container under ~/src/<name>.
+func (c *Container) RepoIndex(name string) (int, error) {
+	names := make([]string, len(c.Repos))
+	for i, r := range c.Repos {
+		if r.Name() == name {
+			return i, nil
+		}
+		names[i] = r.Name()
+	}
+	return 0, fmt.Errorf("no repository %q in %s; repositories: %s", name, c.Name, strings.Join(names, ", "))
+}
+
+// checkRepoNames ensures each repository maps to a distinct ~/src/<name>.
+func checkRepoNames(repos []Repo) error {
+	seen := make(map[string]string, len(repos))
+	for _, r := range repos {
+		if prev, ok := seen[r.Name()]; ok {
+			return fmt.Errorf("repositories %s and %s would both be cloned in ~/src/%s", prev, r.GitRoot, r.Name())
+		}
+		seen[r.Name()] = r.GitRoot
+	}
+	return nil
+}
+
 // resolveDefaults populates DefaultRemote and DefaultBranch if not already set.
 func (r *Repo) resolveDefaults(ctx context.Context) error {
 	if r.DefaultRemote == "" {
-		remote, err := gitutil.DefaultRemote(ctx, r.GitRoot)
+		remote, err := r.vcs().DefaultRemote(ctx, r.GitRoot)
 		if err != nil {
 			return err
 		}
 		r.DefaultRemote = remote
 	}
 	if r.DefaultBranch == "" {
-		branch, err := gitutil.DefaultBranch(ctx, r.GitRoot, r.DefaultRemote)
+		branch, err := r.vcs().DefaultBranch(ctx, r.GitRoot, r.DefaultRemote)
 		if err != nil {
 			return err
 		}
@@ -213,18 +462,25 @@ func (c *Container) prepare(paths []AgentPaths) error {
 // container's repos have their branches set (e.g. after concurrent branch
 // allocation).
 func (c *Container) Launch(ctx context.Context, stdout, stderr io.Writer, opts *StartOpts) (retErr error) {
+	if err := checkRepoNames(c.Repos); err != nil {
+		return err
+	}
 	if err := c.prepare(opts.AgentPaths); err != nil {
 		return err
 	}
 	// Check if container already exists.
-	if _, err := runCmd(ctx, "", []string{c.Runtime, "inspect", c.Name}); err == nil {
+	if c.exists(ctx) {
 		return fmt.Errorf("container %s already exists. SSH in with 'ssh %s' or clean it up via 'md purge' first",
 			c.Name, c.Name)
 	}
 
 	// Generate Tailscale auth key if needed.
-	if opts.Tailscale && opts.TailscaleAuthKey == "" {
-		key, err := generateTailscaleAuthKey(ctx, c.TailscaleAPIKey)
+	if opts.Tailscale && opts.TailscaleAuthKey == "" && !c.reachable(ctx, tailscaleAPIURL) {
+		if !opts.Quiet {
+			_, _ = fmt.Fprintf(stdout, "- Offline: no Tailscale auth key, Tailscale will use browser auth once the network is back\n")
+		}
+	} else if opts.Tailscale && opts.TailscaleAuthKey == "" {
+		key, err := generateTailscaleAuthKey(ctx, c.httpClient(10*time.Second), c.TailscaleAPIKey)
 		if err != nil {
 			if !opts.Quiet {
 				_, _ = fmt.Fprintf(stdout, "- Could not generate Tailscale auth key (%v), will use browser auth\n", err)
@@ -235,14 +491,79 @@ func (c *Container) Launch(ctx context.Context, stdout, stderr io.Writer, opts *
 		}
 	}
 
+	// Collect credentials before starting anything so a missing or expired
+	// host login fails fast.
+	if len(opts.Credentials) > 0 {
+		creds, err := collectCredentials(ctx, c.Home, opts.Credentials, opts.ScopedCredentials)
+		if err != nil {
+			return err
+		}
+		c.credentials = creds
+	}
+	// Refuse to start without some of the working tree's content before
+	// creating anything.
+	c.nested = nil
+	for i := range c.Repos {
+		nested, err := checkNested(ctx, stdout, &c.Repos[i], opts.Nested, opts.Quiet)
+		if err != nil {
+			return err
+		}
+		if len(nested) != 0 {
+			if c.nested == nil {
+				c.nested = map[int][]gitutil.NestedRepo{}
+			}
+			c.nested[i] = nested
+		}
+	}
+	// Likewise, reject an invalid services file before creating anything.
+	if len(c.Repos) > 0 {
+		services, err := LoadServices(c.Repos[0].GitRoot)
+		if err != nil {
+			return err
+		}
+		c.services = services
+	}
+	if len(c.Repos) > 0 && !opts.NoSidecars {
+		p, err := findComposeFile(c.Repos[0].GitRoot)
+		if err != nil {
+			return err
+		}
+		if p != "" && c.Kube != nil {
+			return fmt.Errorf("%s: sidecar services need docker or podman, not Kubernetes; skip them with --no-sidecars", ComposeFile)
+		}
+		c.composeFile = p
+	}
+	if err := checkNetworkMode(c, opts); err != nil {
+		return err
+	}
+	if err := checkNetworkPolicy(opts); err != nil {
+		return err
+	}
+
 	baseImage := opts.BaseImage
 	if baseImage == "" {
 		baseImage = DefaultBaseImage + ":latest"
 	}
-	imageName, err := c.ensureImage(ctx, stdout, stderr, baseImage, opts.Caches, opts.Quiet)
+	if d := opts.DevContainer; d != nil && d.Image != "" {
+		if c.isMDImage(ctx, d.Image) {
+			baseImage = d.Image
+		} else if !opts.Quiet {
+			_, _ = fmt.Fprintf(stderr, "- Ignoring devcontainer image %s: it isn't built on md's image; install its tools with build.dockerfile or %s\n", d.Image, BakeFile)
+		}
+	}
+	imageName, err := c.ensureImage(ctx, stdout, stderr, &WarmupOpts{BaseImage: baseImage, Caches: opts.Caches, Sudo: opts.Sudo, NoPull: opts.NoPull, Rebuild: opts.Rebuild, Quiet: opts.Quiet})
 	if err != nil {
 		return err
 	}
+	if imageName, err = c.ensureBakedImage(ctx, stdout, stderr, imageName, opts.Quiet); err != nil {
+		return err
+	}
+	if imageName, err = c.ensureDevContainerImage(ctx, stdout, stderr, imageName, opts.DevContainer, opts.Quiet); err != nil {
+		return err
+	}
+	if c.Kube != nil {
+		return launchPod(ctx, stdout, stderr, c, opts, imageName)
+	}
 	return launchContainer(ctx, stdout, stderr, c, opts, imageName)
 }
 
@@ -250,55 +571,122 @@ func (c *Container) Launch(ctx context.Context, stdout, stderr io.Writer, opts *
 // startup. Must be called after Launch. Container.Repos must have
 // branches set before this call.
 func (c *Container) Connect(ctx context.Context, stdout, stderr io.Writer, opts *StartOpts) (*StartResult, error) {
+	c.checkpoint = "launched"
 	result, err := connectContainer(ctx, stdout, stderr, c, opts)
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("start of %s interrupted once %s; the container is left as is, md purge removes it: %w", c.Name, c.checkpoint, err)
+		}
 		return nil, err
 	}
+	if opts.DevContainer != nil {
+		c.postCreate(ctx, stdout, stderr, opts.DevContainer)
+	}
+	c.postHook(ctx, stdout, stderr, HookPostStart, 0)
+	result.VNCPorts = slices.Clone(c.VNCPorts)
+	if c.CDPPort != 0 {
+		// The browser starts asynchronously with the container; it is usually
+		// up by the time repos are pushed.
+		ws, err := waitForCDP(ctx, dialHost(c.Bind), c.CDPPort, time.Now().Add(30*time.Second))
+		if err != nil {
+			if !opts.Quiet {
+				_, _ = fmt.Fprintf(stderr, "- Browser DevTools not reachable: %v\n", err)
+			}
+		} else {
+			result.BrowserWSURL = ws
+		}
+	}
 	if opts.Tailscale {
 		c.Tailscale = true
 		c.State = "running"
 		result.TailscaleFQDN = c.TailscaleFQDN(ctx)
+		result.TailscaleAuthPending = opts.TailscaleAuthKey == "" && result.TailscaleFQDN == ""
 	}
 	return result, nil
 }
 
+// RunResult is the outcome of [Container.Run].
+type RunResult struct {
+	// Name is the temporary container's name.
+	Name string
+	// ExitCode is the command's exit code, 1 when it couldn't run.
+	ExitCode int
+	// Branch is the local branch holding the command's changes with
+	// [CommitResults]; empty when there were none.
+	Branch string
+}
+
+// CommitResults makes [Container.Run] keep the command's changes to the
+// primary repository, whatever its exit code: they are committed like
+// [Container.Fetch] does and land on a new local branch.
+type CommitResults struct {
+	// Branch is the local branch to create. It must not exist. Empty uses
+	// the temporary container's name.
+	Branch string
+	// Provider generates the commit message of uncommitted changes. Nil uses
+	// a default message.
+	Provider genai.Provider
+}
+
 // Run starts a temporary container, runs a command, then cleans up.
 // baseImage is the full Docker image reference; if empty, DefaultBaseImage is
 // used. caches lists host directories to COPY into the image (same semantics
 // as StartOpts.Caches); nil means no caches. extraEnv holds KEY=VALUE pairs
-// injected into the container's ~/.env (see StartOpts.ExtraEnv).
-func (c *Container) Run(ctx context.Context, stdout, stderr io.Writer, baseImage string, command []string, caches []CacheMount, extraEnv []string, maxCPUs int, extraRunArgs []string) (_ int, retErr error) {
-	var buf [4]byte
-	_, _ = rand.Read(buf[:])
+// injected into the container's ~/.env (see StartOpts.ExtraEnv). limits caps
+// the resources the command may use.
+//
+// results, when set, keeps the command's changes on a local branch. id, from
+// [NewRunID], names the temporary container (see [Container.RunName]); empty
+// picks a random one.
+func (c *Container) Run(ctx context.Context, stdout, stderr io.Writer, baseImage string, command []string, caches []CacheMount, extraEnv []string, limits Limits, extraRunArgs []string, results *CommitResults, id string) (*RunResult, error) {
+	if id == "" {
+		id = NewRunID()
+	}
 	var tmpRepos []Repo
-	var tmpName string
 	if len(c.Repos) > 0 {
 		tmpRepos = c.Repos[:1]
-		tmpName = fmt.Sprintf("md-%s-run-%x", sanitizeDockerName(c.Repos[0].Name()), buf)
-	} else {
-		tmpName = fmt.Sprintf("md-run-%x", buf)
 	}
+	tmpName := c.RunName(id)
 	tmp := &Container{
 		Client: c.Client,
 		Repos:  tmpRepos,
 		Name:   tmpName,
 	}
+	res := &RunResult{Name: tmpName, ExitCode: 1}
+	if results != nil {
+		if results.Branch == "" {
+			results = &CommitResults{Branch: tmpName, Provider: results.Provider}
+		}
+		if len(tmpRepos) == 0 {
+			return res, errors.New("there is no repository to commit the results to")
+		}
+		if err := checkNewBranch(ctx, tmpRepos[0].GitRoot, results.Branch); err != nil {
+			return res, err
+		}
+	}
 
 	if baseImage == "" {
 		baseImage = DefaultBaseImage + ":latest"
 	}
-	imageName, err := c.ensureImage(ctx, stdout, stderr, baseImage, caches, true)
+	imageName, err := c.ensureImage(ctx, stdout, stderr, &WarmupOpts{BaseImage: baseImage, Caches: caches, Sudo: SudoDefault, Quiet: true})
 	if err != nil {
-		return 1, err
+		return res, err
 	}
-	opts := StartOpts{Quiet: true, ExtraEnv: extraEnv, AgentPaths: slices.Collect(maps.Values(HarnessMounts)), MaxCPUs: maxCPUs, ExtraRunArgs: extraRunArgs}
+	opts := StartOpts{Quiet: true, ExtraEnv: extraEnv, AgentPaths: slices.Collect(maps.Values(HarnessMounts)), ExtraRunArgs: extraRunArgs}
+	limits.apply(&opts)
 	if err := launchContainer(ctx, stdout, stderr, tmp, &opts, imageName); err != nil {
+		if exit := tmp.exitState(ctx); exit != nil {
+			err = errors.Join(err, exit)
+		}
 		tmp.cleanup(ctx)
-		return 1, err
+		return res, err
 	}
 	if _, err := connectContainer(ctx, stdout, stderr, tmp, &opts); err != nil {
+		if exit := tmp.exitState(ctx); exit != nil {
+			err = errors.Join(err, exit)
+		}
 		tmp.cleanup(ctx)
-		return 1, err
+		return res, err
 	}
 
 	cmdStr := strings.Join(command, " ")
@@ -308,43 +696,259 @@ func (c *Container) Run(ctx context.Context, stdout, stderr io.Writer, baseImage
 	} else {
 		sshCmd = cmdStr
 	}
-	err = runCmdOut(ctx, "", c.SSHCommand(tmp.Name, sshCmd), stdout, stderr)
-	exitCode := 0
-	if err != nil {
+	res.ExitCode = 0
+	if err = runCmdOut(ctx, "", c.SSHCommand(tmp.Name, sshCmd), stdout, stderr); err != nil {
 		var exitErr *exec.ExitError
 		if errors.As(err, &exitErr) {
-			exitCode = exitErr.ExitCode()
+			res.ExitCode = exitErr.ExitCode()
 		} else {
-			exitCode = 1
+			res.ExitCode = 1
+		}
+	}
+	// A failure may be the container's rather than the command's, e.g.
+	// killed for running out of memory. Its diagnostics are lost with it.
+	var exit *ContainerExitError
+	if res.ExitCode != 0 {
+		exit = tmp.exitState(ctx)
+	}
+	if results != nil && (exit == nil || exit.Running) {
+		// The command's failure is no reason to lose its work.
+		res.Branch, err = tmp.commitResults(ctx, stdout, stderr, results)
+		if exit != nil {
+			err = errors.Join(exit, err)
 		}
+	} else if exit != nil {
+		err = exit
 	}
 	tmp.cleanup(ctx)
-	return exitCode, nil
+	return res, err
+}
+
+// RunName returns the name of the temporary container of [Container.Run]
+// with id.
+func (c *Container) RunName(id string) string {
+	if len(c.Repos) > 0 {
+		return "md-" + sanitizeDockerName(c.Repos[0].Name()) + "-run-" + id
+	}
+	return "md-run-" + id
 }
 
-// Revive restarts a stopped (exited) container. It validates git remotes,
+// exitLogLines is how many lines of the container's output a
+// [ContainerExitError] keeps.
+const exitLogLines = 20
+
+// ContainerExitError is the state of a container that stopped, or had a
+// process killed for running out of memory, while md was using it.
+type ContainerExitError struct {
+	Name string
+	// Status is the engine's, e.g. "exited".
+	Status string
+	// Running is set when the container still runs: a process in it was
+	// killed for running out of memory.
+	Running   bool
+	OOMKilled bool
+	ExitCode  int
+	// EngineError is the engine's error, e.g. when the entrypoint couldn't be
+	// started.
+	EngineError string
+	// FinishedAt is zero while the container runs.
+	FinishedAt time.Time
+	// Logs is the end of the container's output.
+	Logs string
+}
+
+// Label summarizes the container's health, e.g. "oom-killed, exited 137".
+func (e *ContainerExitError) Label() string {
+	var parts []string
+	if e.OOMKilled {
+		parts = append(parts, "oom-killed")
+	}
+	switch {
+	case e.Running:
+		parts = append(parts, "running")
+	case e.Status == "exited":
+		parts = append(parts, "exited "+strconv.Itoa(e.ExitCode))
+	case e.Status != "":
+		parts = append(parts, e.Status)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (e *ContainerExitError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "container %s: %s", e.Name, e.Label())
+	if !e.FinishedAt.IsZero() {
+		fmt.Fprintf(&b, " at %s", e.FinishedAt.UTC().Format(time.RFC3339))
+	}
+	if e.EngineError != "" {
+		b.WriteString(": " + e.EngineError)
+	}
+	if e.OOMKilled {
+		b.WriteString("\nIt ran out of memory: check its --memory limit")
+	}
+	if e.Logs != "" {
+		fmt.Fprintf(&b, "\nLast lines of its output:\n%s", e.Logs)
+	}
+	return b.String()
+}
+
+// exitError returns the exit state of the container, nil when it runs
+// healthy.
+func (i *containerInfo) exitError(name string) *ContainerExitError {
+	st := &i.State
+	if st.Running && !st.OOMKilled {
+		return nil
+	}
+	return &ContainerExitError{
+		Name:        name,
+		Status:      st.Status,
+		Running:     st.Running,
+		OOMKilled:   st.OOMKilled,
+		ExitCode:    st.ExitCode,
+		EngineError: st.Error,
+		FinishedAt:  st.FinishedAt,
+	}
+}
+
+// exitState returns the exit state of c with the end of its output, nil
+// when it runs healthy or can't be inspected.
+func (c *Container) exitState(ctx context.Context) *ContainerExitError {
+	if c.Kube != nil {
+		return nil
+	}
+	info, err := inspectContainer(ctx, c.Runtime, c.Name)
+	if err != nil {
+		return nil
+	}
+	exit := info.exitError(c.Name)
+	if exit == nil {
+		return nil
+	}
+	var logs bytes.Buffer
+	_ = runCmdOut(ctx, "", []string{c.Runtime, "logs", "--tail", strconv.Itoa(exitLogLines), c.Name}, &logs, &logs)
+	exit.Logs = strings.TrimRight(logs.String(), "\n")
+	return exit
+}
+
+// checkNewBranch returns an error unless branch is a valid name for a branch
+// that doesn't exist in gitRoot.
+func checkNewBranch(ctx context.Context, gitRoot, branch string) error {
+	if _, err := gitutil.RunGit(ctx, gitRoot, "check-ref-format", "--branch", branch); err != nil {
+		return fmt.Errorf("invalid branch name %q", branch)
+	}
+	if _, err := gitutil.RunGit(ctx, gitRoot, "rev-parse", "-q", "--verify", "refs/heads/"+branch); err == nil {
+		return fmt.Errorf("branch %s already exists", branch)
+	}
+	return nil
+}
+
+// commitResults fetches the changes of the temporary container c, committing
+// the pending ones, and creates the local branch results.Branch at them.
+// Returns the branch, empty when nothing changed.
+func (c *Container) commitResults(ctx context.Context, stdout, stderr io.Writer, results *CommitResults) (string, error) {
+	if _, err := c.Fetch(ctx, stdout, stderr, 0, &FetchOpts{Provider: results.Provider}); err != nil {
+		return "", fmt.Errorf("fetching the results: %w", err)
+	}
+	r := c.Repos[0]
+	remoteRef := "refs/remotes/" + c.Name + "/" + r.Branch
+	got, err := gitutil.RunGit(ctx, r.GitRoot, "rev-parse", remoteRef)
+	if err != nil {
+		return "", fmt.Errorf("fetching the results: %w", err)
+	}
+	if base, _ := gitutil.RunGit(ctx, r.GitRoot, "rev-parse", "refs/heads/"+r.Branch); got == base {
+		return "", nil
+	}
+	if _, err := gitutil.RunGit(ctx, r.GitRoot, "branch", "--no-track", results.Branch, got); err != nil {
+		return "", fmt.Errorf("creating branch %s: %w", results.Branch, err)
+	}
+	return results.Branch, nil
+}
+
+// Exec runs command in the running container over SSH, from the primary
+// repository's directory when there is one, and returns its exit code.
+//
+// A single-element command is passed to the shell as is, so it may contain
+// pipes and redirections; multiple elements are quoted individually. stdin is
+// streamed to the command; with tty, a pseudo-terminal is allocated, which
+// requires stdin to be a terminal.
+func (c *Container) Exec(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer, command []string, tty bool) (int, error) {
+	if len(command) == 0 {
+		return 1, errors.New("no command specified")
+	}
+	if err := c.checkContainerState(ctx); err != nil {
+		return 1, err
+	}
+	c.touch()
+	cmdStr := command[0]
+	if len(command) > 1 {
+		quoted := make([]string, len(command))
+		for i, a := range command {
+			quoted[i] = shellQuote(a)
+		}
+		cmdStr = strings.Join(quoted, " ")
+	}
+	if len(c.Repos) > 0 {
+		cmdStr = "cd ~/src/" + shellQuote(c.Repos[0].Name()) + " && " + cmdStr
+	}
+	ttyFlag := "-T"
+	if tty {
+		ttyFlag = "-t"
+	}
+	args := c.SSHCommand(ttyFlag, c.Name, cmdStr)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return exitErr.ExitCode(), nil
+		}
+		return 1, err
+	}
+	return 0, nil
+}
+
+// Resume restarts a container stopped with Stop. It validates git remotes,
 // runs `docker start`, re-queries the SSH port (which changes on restart),
 // rewrites the SSH config, and waits for SSH to become ready. It does NOT
 // push repos or send .env — the container's filesystem is preserved across
-// stop/start.
-func (c *Container) Revive(ctx context.Context, stdout, stderr io.Writer) error {
+// stop/start. Services declared in .md/services.json are restarted by the
+// container's init.
+func (c *Container) Resume(ctx context.Context, stdout, stderr io.Writer) error {
+	if c.Kube != nil {
+		return fmt.Errorf("%s runs on Kubernetes: pods can't be resumed", c.Name)
+	}
+	if c.State == "running" {
+		return fmt.Errorf("%s is already running", c.Name)
+	}
 	// Validate git remotes before starting. Each remote must either be
 	// absent (will be added) or point to the expected URL. A remote
 	// pointing elsewhere indicates a name collision — fail early.
-	for _, r := range c.Repos {
-		rName := r.Name()
-		wantURL := "user@" + c.Name + ":/home/user/src/" + rName
-		got, err := gitutil.RunGit(ctx, r.GitRoot, "remote", "get-url", c.Name)
-		if err == nil {
-			if got != wantURL {
-				return fmt.Errorf("git remote %s in %s points to %q, expected %q", c.Name, r.GitRoot, got, wantURL)
+	// Read-write and read-only checkouts have no remote.
+	if c.MountSource.ownsGit() {
+		for _, r := range c.Repos {
+			rName := r.Name()
+			wantURL := "user@" + c.Name + ":/home/user/src/" + rName
+			got, err := gitutil.RunGit(ctx, r.GitRoot, "remote", "get-url", c.Name)
+			if err == nil {
+				if got != wantURL {
+					return fmt.Errorf("git remote %s in %s points to %q, expected %q", c.Name, r.GitRoot, got, wantURL)
+				}
+				// Remote exists and is correct — nothing to do.
+				continue
+			}
+			// Remote doesn't exist, add it.
+			if err := runCmdOut(ctx, r.GitRoot, []string{"git", "remote", "add", c.Name, wantURL}, stdout, stderr); err != nil {
+				return fmt.Errorf("adding git remote for %s: %w", rName, err)
 			}
-			// Remote exists and is correct — nothing to do.
-			continue
 		}
-		// Remote doesn't exist, add it.
-		if err := runCmdOut(ctx, r.GitRoot, []string{"git", "remote", "add", c.Name, wantURL}, stdout, stderr); err != nil {
-			return fmt.Errorf("adding git remote for %s: %w", rName, err)
+	}
+
+	// Start the sidecars first, as the container expects them.
+	if c.Sidecars {
+		if _, err := runCmd(ctx, "", c.composeCmd("start")); err != nil {
+			return cmdErrWithStderr("starting the services of "+c.Name, err)
 		}
 	}
 
@@ -353,21 +957,49 @@ func (c *Container) Revive(ctx context.Context, stdout, stderr io.Writer) error
 	if _, err := runCmd(ctx, "", []string{rt, "start", c.Name}); err != nil {
 		return fmt.Errorf("docker start %s: %w", c.Name, err)
 	}
+	// Containers started before md sent the password have it in their
+	// environment instead.
+	if c.Display {
+		info, err := inspectContainer(ctx, rt, c.Name)
+		if err != nil {
+			return err
+		}
+		if slices.Contains(info.Config.Env, "MD_VNC_AUTH=1") {
+			if err := c.sendVNCPassword(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	// The rules went with the network namespace.
+	if c.NetworkPolicy != nil {
+		info, err := inspectContainer(ctx, rt, c.Name)
+		if err != nil {
+			return err
+		}
+		if err := c.applyNetworkPolicy(ctx, info.Config.Image); err != nil {
+			return err
+		}
+	}
 
 	// Query the new SSH port (port mapping changes on restart).
-	port, err := getHostPort(ctx, rt, c.Name, "22/tcp")
+	port, err := c.GetHostPort(ctx, "22/tcp")
 	if err != nil {
-		return fmt.Errorf("getting SSH port after revive: %w", err)
+		return fmt.Errorf("getting SSH port after resume: %w", err)
 	}
 	c.SSHPort = port
 
 	if c.Display {
-		vncPort, _ := getHostPort(ctx, rt, c.Name, "5901/tcp")
-		c.VNCPort = vncPort
+		c.queryVNCPorts(ctx)
+	}
+	if c.RDP {
+		c.RDPPort, _ = getHostPort(ctx, rt, c.Name, "3389/tcp")
+	}
+	if c.Browser {
+		c.CDPPort, _ = getHostPort(ctx, rt, c.Name, "9222/tcp")
 	}
 
-	// Rewrite SSH config with the new port. The known_hosts file also
-	// needs rewriting because entries are keyed by [127.0.0.1]:port.
+	// Rewrite SSH config with the new port. The host key is pinned by name
+	// so known_hosts is only rewritten alongside.
 	sshConfigDir := filepath.Join(c.Home, ".ssh", "config.d")
 	removeSSHConfig(sshConfigDir, c.Name)
 	knownHostsPath := filepath.Join(sshConfigDir, c.Name+".known_hosts")
@@ -375,17 +1007,16 @@ func (c *Container) Revive(ctx context.Context, stdout, stderr io.Writer) error
 	if err != nil {
 		return fmt.Errorf("reading host public key: %w", err)
 	}
-	if err := writeSSHConfig(sshConfigDir, c.Name, port, c.UserKeyPath, knownHostsPath, c.ControlMaster); err != nil {
+	if err := writeSSHConfig(sshConfigDir, c.Name, c.sshEndpoint(), c.UserKeyPath, knownHostsPath, c.ControlMaster); err != nil {
 		return fmt.Errorf("writing SSH config: %w", err)
 	}
-	if err := writeKnownHosts(knownHostsPath, port, strings.TrimSpace(string(hostPubKey))); err != nil {
+	if err := writeKnownHosts(knownHostsPath, c.Name, strings.TrimSpace(string(hostPubKey))); err != nil {
 		return fmt.Errorf("writing known_hosts: %w", err)
 	}
 
 	// Wait for TCP, then confirm SSH is fully ready.
-	addr := fmt.Sprintf("localhost:%d", port)
 	deadline := time.Now().Add(30 * time.Second)
-	if err := waitForTCP(ctx, addr, deadline); err != nil {
+	if err := c.waitForSSHPort(ctx, deadline); err != nil {
 		return fmt.Errorf("waiting for SSH port on %s: %w", c.Name, err)
 	}
 	if err := waitForSSH(ctx, c, deadline); err != nil {
@@ -393,9 +1024,17 @@ func (c *Container) Revive(ctx context.Context, stdout, stderr io.Writer) error
 	}
 
 	c.State = "running"
+	touchLastUsed(sshConfigDir, c.Name)
 	return nil
 }
 
+// Revive is the former name of Resume.
+//
+// Deprecated: use Resume.
+func (c *Container) Revive(ctx context.Context, stdout, stderr io.Writer) error {
+	return c.Resume(ctx, stdout, stderr)
+}
+
 // waitForSSH runs a trivial SSH command in a retry loop until it succeeds or
 // the deadline is exceeded. This confirms SSH is fully operational after the
 // TCP socket opens (sshd may need a few more milliseconds to accept auth).
@@ -412,21 +1051,36 @@ func waitForSSH(ctx context.Context, c *Container, deadline time.Time) error {
 		if time.Now().After(deadline) {
 			return fmt.Errorf("timed out waiting for SSH on %s", c.Name)
 		}
-		time.Sleep(10 * time.Millisecond)
+		if err := sleepCtx(ctx, 10*time.Millisecond); err != nil {
+			return err
+		}
 	}
 }
 
 // Stop stops the container without removing it. The container can be
-// restarted later with Revive. SSH config is preserved (Revive rewrites
-// it with the new port), but the ControlMaster socket is removed to
-// prevent stale connections from interfering with subsequent SSH commands.
+// restarted later with Resume. SSH config and git remotes are preserved
+// (Resume rewrites the SSH config with the new port), but the ControlMaster
+// socket is removed to prevent stale connections from interfering with
+// subsequent SSH commands.
 func (c *Container) Stop(ctx context.Context) error {
+	if c.Kube != nil {
+		return fmt.Errorf("%s runs on Kubernetes: pods can't be stopped, purge it instead", c.Name)
+	}
+	if c.State == "exited" {
+		return fmt.Errorf("%s is already stopped", c.Name)
+	}
 	if _, err := runCmd(ctx, "", []string{c.Runtime, "stop", c.Name}); err != nil {
 		return fmt.Errorf("docker stop %s: %w", c.Name, err)
 	}
+	if c.Sidecars {
+		if _, err := runCmd(ctx, "", c.composeCmd("stop")); err != nil {
+			return cmdErrWithStderr("stopping the services of "+c.Name, err)
+		}
+	}
 	// Clean up stale ControlMaster socket (if any). The SSH connection is
 	// dead now that the container is stopped.
 	cleanupControlSocket(c.Name)
+	c.closeForwards(ctx)
 	c.State = "exited"
 	return nil
 }
@@ -434,8 +1088,7 @@ func (c *Container) Stop(ctx context.Context) error {
 // Purge stops and removes the container, cleaning up SSH config and git remotes.
 func (c *Container) Purge(ctx context.Context, stdout, stderr io.Writer) error {
 	rt := c.Runtime
-	_, containerErr := runCmd(ctx, "", []string{rt, "inspect", c.Name})
-	containerExists := containerErr == nil
+	containerExists := c.exists(ctx)
 	var anyRemoteExists bool
 	for _, repo := range c.Repos {
 		if _, err := gitutil.RunGit(ctx, repo.GitRoot, "remote", "get-url", c.Name); err == nil {
@@ -453,22 +1106,29 @@ func (c *Container) Purge(ctx context.Context, stdout, stderr io.Writer) error {
 	if !containerExists && !anyRemoteExists && !sshExists {
 		return fmt.Errorf("%s not found", c.Name)
 	}
+	// A failing pre_kill hook doesn't stop the removal: the container's
+	// commands are under the agent's control and must not veto its teardown.
+	if err := c.runHook(ctx, stdout, stderr, HookPreKill, 0, containerExists && c.State == "running"); err != nil {
+		_, _ = fmt.Fprintf(stderr, "- %v; removing %s anyway\n", err, c.Name)
+	}
 
-	// Clean up non-ephemeral Tailscale node.
-	if containerExists {
+	// Clean up non-ephemeral Tailscale node. Pods have none.
+	if containerExists && c.Kube == nil {
+		var labels map[string]string
+		if info, err := inspectContainer(ctx, rt, c.Name); err == nil {
+			labels = info.Config.Labels
+		}
 		if !c.Tailscale {
-			tsLabel, _ := runCmd(ctx, "", []string{rt, "inspect", "--format", `{{index .Config.Labels "md.tailscale"}}`, c.Name})
-			c.Tailscale = tsLabel == "1"
+			c.Tailscale = labels["md.tailscale"] == "1"
 		}
 		if c.Tailscale {
-			ephLabel, _ := runCmd(ctx, "", []string{rt, "inspect", "--format", `{{index .Config.Labels "md.tailscale_ephemeral"}}`, c.Name})
-			if ephLabel != "1" {
+			if labels["md.tailscale_ephemeral"] != "1" {
 				statusJSON, err := runCmd(ctx, "", []string{rt, "exec", c.Name, "tailscale", "status", "--json"})
 				if err == nil {
 					var status tailscaleStatus
 					if json.Unmarshal([]byte(statusJSON), &status) == nil && status.Self.ID != "" {
 						_, _ = fmt.Fprintln(stdout, "- Removing Tailscale node from tailnet...")
-						if err := deleteTailscaleDevice(ctx, c.TailscaleAPIKey, status.Self.ID); err != nil {
+						if err := deleteTailscaleDevice(ctx, c.httpClient(10*time.Second), c.TailscaleAPIKey, status.Self.ID); err != nil {
 							slog.WarnContext(ctx, "md", "msg", "failed to remove Tailscale device", "err", err)
 						}
 					}
@@ -479,6 +1139,8 @@ func (c *Container) Purge(ctx context.Context, stdout, stderr io.Writer) error {
 
 	_ = os.Remove(sshConf)
 	_ = os.Remove(sshKnown)
+	_ = os.Remove(lastUsedPath(sshConfigDir, c.Name))
+	c.closeForwards(ctx)
 
 	var retErr error
 	for _, repo := range c.Repos {
@@ -489,28 +1151,71 @@ func (c *Container) Purge(ctx context.Context, stdout, stderr io.Writer) error {
 		}
 	}
 	if containerExists {
-		if _, err := runCmd(ctx, "", []string{rt, "rm", "-f", "-v", c.Name}); err != nil {
+		args := []string{rt, "rm", "-f", "-v", c.Name}
+		if c.Kube != nil {
+			args = c.Kube.kubectl("delete", "pod", podName(c.Name), "--wait=false")
+		}
+		if _, err := runCmd(ctx, "", args); err != nil {
 			retErr = err
 		}
 	}
+	if c.Sidecars {
+		if err := c.removeSidecars(ctx); err != nil {
+			retErr = errors.Join(retErr, err)
+		}
+	}
+	if err := c.removeNetwork(ctx); err != nil {
+		retErr = errors.Join(retErr, err)
+	}
+	_ = os.Remove(c.vncPasswordPath())
+	// The overlay's upper layers go once the volumes using them are removed.
+	_ = os.RemoveAll(c.overlayDir())
 	_, _ = fmt.Fprintf(stdout, "Removed %s\n", c.Name)
 	return retErr
 }
 
+// PushOpts configures [Container.Push].
+type PushOpts struct {
+	// IncludeUntracked sends the host's uncommitted changes, untracked files
+	// included, instead of refusing to push: they are left uncommitted in the
+	// container's checkout, like on the host. It requires the branch to be
+	// checked out. Not supported with jj.
+	IncludeUntracked bool
+	// Nested is how to handle nested repositories when the branch is checked
+	// out, like [StartOpts.Nested].
+	Nested string
+}
+
+// snapshotBranch is the container's branch briefly holding the host's
+// uncommitted changes sent by a push.
+const snapshotBranch = "md-snapshot"
+
 // Push force-pushes local state for Repos[repoIdx] into the container,
-// saving a backup of the container state and returning the backup branch name.
-func (c *Container) Push(ctx context.Context, stdout, stderr io.Writer, repoIdx int) (string, error) {
+// saving a backup of the container state. The result has the backup branch
+// name and what changed in the container's base. On a terminal, the transfer
+// progress is shown on stdout. opts may be nil.
+func (c *Container) Push(ctx context.Context, stdout, stderr io.Writer, repoIdx int, opts *PushOpts) (*SyncResult, error) {
+	if opts == nil {
+		opts = &PushOpts{}
+	}
 	if len(c.Repos) == 0 {
-		return "", errors.New("container has no repos")
+		return nil, errors.New("container has no repos")
 	}
 	if repoIdx < 0 || repoIdx >= len(c.Repos) {
-		return "", fmt.Errorf("repo index %d out of range [0, %d)", repoIdx, len(c.Repos))
+		return nil, fmt.Errorf("repo index %d out of range [0, %d)", repoIdx, len(c.Repos))
+	}
+	if err := c.checkOwnsGit("push to"); err != nil {
+		return nil, err
 	}
 	if err := c.checkContainerState(ctx); err != nil {
-		return "", err
+		return nil, err
+	}
+	c.touch()
+	if err := c.runHook(ctx, stdout, stderr, HookPrePush, repoIdx, true); err != nil {
+		return nil, err
 	}
 	if err := c.SyncDefaultBranch(ctx, repoIdx); err != nil {
-		return "", err
+		return nil, err
 	}
 	r := c.Repos[repoIdx]
 	repoName := shellQuote(r.Name())
@@ -518,135 +1223,343 @@ func (c *Container) Push(ctx context.Context, stdout, stderr io.Writer, repoIdx
 	// Commit any pending changes in the container.
 	_, _ = runCmd(ctx, "", c.SSHCommand(c.Name, "cd ~/src/"+repoName+" && git add . && (git diff --quiet HEAD -- . || git commit -q -m 'Backup before push')"))
 	// Refuse if there are pending local changes on the branch being pushed.
-	currentBranch, _ := gitutil.RunGit(ctx, r.GitRoot, "branch", "--show-current")
-	if currentBranch == r.Branch {
-		if _, err := gitutil.RunGit(ctx, r.GitRoot, "diff", "--quiet", "--exit-code"); err != nil {
-			return "", errors.New("there are pending changes locally. Please commit or stash them before pushing")
+	currentBranch, _ := r.vcs().CurrentBranch(ctx, r.GitRoot)
+	nested, err := checkNested(ctx, stdout, &r, opts.Nested, false)
+	if err != nil {
+		return nil, err
+	}
+	var snapshot string
+	if opts.IncludeUntracked {
+		if gitutil.IsJJ(r.GitRoot) {
+			return nil, errors.New("sending uncommitted changes isn't supported with jj: the working-copy commit already has them, run 'jj commit' and push it")
+		}
+		if currentBranch != r.Branch {
+			return nil, fmt.Errorf("sending uncommitted changes requires branch %s to be checked out", r.Branch)
+		}
+		head, err := gitutil.RevParse(ctx, r.GitRoot, "HEAD")
+		if err != nil {
+			return nil, err
+		}
+		if snapshot, err = gitutil.Snapshot(ctx, r.GitRoot, "md: uncommitted changes"); err != nil {
+			return nil, err
+		}
+		if snapshot == head {
+			snapshot = ""
+		}
+	} else if currentBranch == r.Branch {
+		dirty, _ := r.vcs().IsDirty(ctx, r.GitRoot)
+		if dirty && opts.Nested != "" {
+			// checkNested handled the submodules' changes.
+			_, err := gitutil.RunGit(ctx, r.GitRoot, "diff", "--quiet", "--ignore-submodules=all")
+			dirty = err != nil
+		}
+		if dirty {
+			if gitutil.IsJJ(r.GitRoot) {
+				return nil, fmt.Errorf("the working-copy commit has changes not in bookmark %s. Run 'jj commit' and 'jj bookmark set %s -r @-' before pushing", r.Branch, r.Branch)
+			}
+			return nil, errors.New("there are pending changes locally. Please commit or stash them before pushing, or send them with --include-untracked")
 		}
 	}
 	// Save a backup branch of the current container state.
-	containerCommit, _ := runCmd(ctx, "", c.SSHCommand(c.Name, "cd ~/src/"+repoName+" && git rev-parse HEAD"))
+	containerCommit, _ := runCmdRetry(ctx, "", c.SSHCommand(c.Name, "cd ~/src/"+repoName+" && git rev-parse HEAD"))
 	backupBranch := "backup-" + time.Now().Format("20060102-150405")
 	_, _ = runCmd(ctx, "", c.SSHCommand(c.Name, "cd ~/src/"+repoName+" && git branch -f "+backupBranch+" "+shellQuote(containerCommit)))
-	if err := runCmdOut(ctx, r.GitRoot, []string{"git", "push", "-q", "-f", "--tags", c.Name, r.Branch + ":base"}, stdout, stderr); err != nil {
-		return "", err
+	oldBase, _ := runCmdRetry(ctx, "", c.SSHCommand(c.Name, "cd ~/src/"+repoName+" && git rev-parse -q --verify base"))
+	lfs, err := c.pushLFS(ctx, stdout, &r, r.Branch)
+	if err != nil {
+		return nil, err
 	}
-	if err := runCmdOut(ctx, "", c.SSHCommand(c.Name, "cd ~/src/"+repoName+" && git switch -q -C "+branch+" base && git branch --set-upstream-to=base"), stdout, stderr); err != nil {
-		return "", err
+	progress, done := newProgress(stdout, "Pushing "+r.Branch)
+	err = c.sendRef(ctx, stdout, stderr, &r, r.Branch, "base", gitutil.PushOpts{Force: true, Tags: true, Progress: progress})
+	done()
+	if err != nil {
+		return nil, err
+	}
+	switchCmd := "git switch -q -C " + branch + " base && git branch --set-upstream-to=base"
+	if lfs {
+		switchCmd = lfsSkipSmudge + switchCmd + lfsCheckout
+	}
+	if snapshot != "" {
+		if err := c.sendRef(ctx, stdout, stderr, &r, snapshot, snapshotBranch, gitutil.PushOpts{Force: true}); err != nil {
+			return nil, err
+		}
+		// Check out the snapshot's tree, then unstage it: the changes are
+		// left uncommitted, new files untracked.
+		switchCmd += " && git read-tree -m -u HEAD " + snapshotBranch + " && git reset -q && git branch -q -D " + snapshotBranch
+	}
+	if c.fetchesFromHost() {
+		// A partial clone fetches the checkout's blobs.
+		err = c.hostGit(ctx, stdout, stderr, &r, switchCmd)
+	} else {
+		err = runCmdOut(ctx, "", c.SSHCommand(c.Name, "cd ~/src/"+repoName+" && "+switchCmd), stdout, stderr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := c.sendNested(ctx, stdout, stderr, &r, nested, false); err != nil {
+		return nil, err
 	}
 	// Update the local remote-tracking ref so it reflects the pushed state.
 	if err := runCmdOut(ctx, r.GitRoot, []string{"git", "update-ref", "refs/remotes/" + c.Name + "/" + r.Branch, r.Branch}, stdout, stderr); err != nil {
-		return "", err
+		return nil, err
+	}
+	res := &SyncResult{Backup: backupBranch, Stat: diffStat(ctx, r.GitRoot, oldBase, r.Branch)}
+	if res.Stat != nil {
+		_, _ = fmt.Fprintf(stdout, "- Pushed %s to base: %s\n", r.Branch, res.Stat)
+	}
+	if snapshot != "" {
+		res.Uncommitted = diffStat(ctx, r.GitRoot, r.Branch, snapshot)
+		if res.Uncommitted != nil {
+			u := res.Uncommitted
+			_, _ = fmt.Fprintf(stdout, "- Sent uncommitted changes: %d file(s) changed, +%d -%d\n", u.Files, u.Insertions, u.Deletions)
+		}
 	}
-	return backupBranch, nil
+	return res, nil
 }
 
-// Fetch commits any uncommitted changes in Repos[repoIdx] in the container and
-// fetches them locally, updating the remote-tracking ref without integrating.
-//
-// p controls AI commit message generation. Pass nil to use a default message.
-func (c *Container) Fetch(ctx context.Context, stdout, stderr io.Writer, repoIdx int, p genai.Provider) error {
+// FetchOpts configures [Container.Fetch].
+type FetchOpts struct {
+	// Provider generates the commit message of the container's uncommitted
+	// changes. Nil uses a default message.
+	Provider genai.Provider
+	// NoCommit leaves the container's uncommitted changes alone: only its
+	// commits are fetched.
+	NoCommit bool
+}
+
+// FetchResult is where [Container.Fetch] put the container's branch.
+type FetchResult struct {
+	// Ref is the remote-tracking ref updated, refs/remotes/<container>/<branch>.
+	Ref string `json:"ref"`
+	// Ahead counts the fetched commits missing from the host's branch,
+	// Behind the host branch's commits missing from the fetched ones.
+	Ahead  int `json:"ahead"`
+	Behind int `json:"behind"`
+}
+
+// Fetch commits any uncommitted changes in Repos[repoIdx] in the container,
+// unless opts.NoCommit is set, and fetches them locally, updating the
+// remote-tracking ref without integrating. On a terminal, the transfer
+// progress is shown on stdout.
+func (c *Container) Fetch(ctx context.Context, stdout, stderr io.Writer, repoIdx int, opts *FetchOpts) (*FetchResult, error) {
 	if len(c.Repos) == 0 {
-		return errors.New("container has no repos")
+		return nil, errors.New("container has no repos")
 	}
 	if repoIdx < 0 || repoIdx >= len(c.Repos) {
-		return fmt.Errorf("repo index %d out of range [0, %d)", repoIdx, len(c.Repos))
+		return nil, fmt.Errorf("repo index %d out of range [0, %d)", repoIdx, len(c.Repos))
+	}
+	if err := c.checkOwnsGit("fetch from"); err != nil {
+		return nil, err
 	}
 	if err := c.checkContainerState(ctx); err != nil {
-		return err
+		return nil, err
 	}
+	c.touch()
 	r := c.Repos[repoIdx]
 	repoName := shellQuote(r.Name())
 	if err := c.SyncDefaultBranch(ctx, repoIdx); err != nil {
-		return err
+		return nil, err
 	}
-	// Check if there are uncommitted changes in the container.
-	if _, err := runCmd(ctx, "", c.SSHCommand(c.Name, "cd ~/src/"+repoName+" && git add . && git diff --quiet HEAD -- .")); err != nil {
-		commitMsg := "Pull from md"
-		if p != nil {
-			metadata := c.gatherGitMetadata(ctx, c.Name, r.Name())
-			diff := c.gatherGitDiff(ctx, c.Name, r.Name())
-			if msg, err := gitutil.GenerateCommitMsg(ctx, p, metadata, diff, nil); err != nil {
-				slog.WarnContext(ctx, "md", "msg", "failed to generate commit message", "err", err)
-			} else if msg != "" {
-				commitMsg = msg
+	// Commit the uncommitted changes in the container, if any.
+	if !opts.NoCommit {
+		if _, err := runCmd(ctx, "", c.SSHCommand(c.Name, "cd ~/src/"+repoName+" && git add . && git diff --quiet HEAD -- .")); err != nil {
+			commitMsg := "Pull from md"
+			if p := opts.Provider; p != nil {
+				metadata := c.gatherGitMetadata(ctx, c.Name, r.Name())
+				diff := c.gatherGitDiff(ctx, c.Name, r.Name())
+				if msg, err := c.generateCommitMsg(ctx, p, metadata, diff); err != nil {
+					slog.WarnContext(ctx, "md", "msg", "failed to generate commit message", "err", err)
+				} else if msg != "" {
+					commitMsg = msg
+				}
+			}
+			gitUserName, _ := gitutil.RunGit(ctx, r.GitRoot, "config", "user.name")
+			gitUserEmail, _ := gitutil.RunGit(ctx, r.GitRoot, "config", "user.email")
+			if gitUserName == "" {
+				gitUserName = "md"
+			}
+			if gitUserEmail == "" {
+				gitUserEmail = "md@localhost"
+			}
+			gitAuthor := shellQuote(gitUserName + " <" + gitUserEmail + ">")
+			commitCmd := "cd ~/src/" + repoName + " && echo " + shellQuote(commitMsg) + " | git commit -a -q --author " + gitAuthor + " -F -"
+			if err := runCmdOut(ctx, "", c.SSHCommand(c.Name, commitCmd), stdout, stderr); err != nil {
+				return nil, fmt.Errorf("committing in container: %w", err)
 			}
-		}
-		gitUserName, _ := gitutil.RunGit(ctx, r.GitRoot, "config", "user.name")
-		gitUserEmail, _ := gitutil.RunGit(ctx, r.GitRoot, "config", "user.email")
-		if gitUserName == "" {
-			gitUserName = "md"
-		}
-		if gitUserEmail == "" {
-			gitUserEmail = "md@localhost"
-		}
-		gitAuthor := shellQuote(gitUserName + " <" + gitUserEmail + ">")
-		commitCmd := "cd ~/src/" + repoName + " && echo " + shellQuote(commitMsg) + " | git commit -a -q --author " + gitAuthor + " -F -"
-		if err := runCmdOut(ctx, "", c.SSHCommand(c.Name, commitCmd), stdout, stderr); err != nil {
-			return fmt.Errorf("committing in container: %w", err)
 		}
 	}
-	if err := runCmdOut(ctx, r.GitRoot, []string{"git", "fetch", "-q", c.Name, r.Branch}, stdout, stderr); err != nil {
-		return err
+	progress, done := newProgress(stdout, "Fetching "+r.Branch)
+	err := r.vcs().Fetch(ctx, r.GitRoot, c.Name, r.Branch, gitutil.FetchOpts{Progress: progress})
+	done()
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	res := &FetchResult{Ref: "refs/remotes/" + c.Name + "/" + r.Branch}
+	if counts, err := gitutil.RunGit(ctx, r.GitRoot, "rev-list", "--left-right", "--count", "refs/heads/"+r.Branch+"..."+res.Ref); err == nil {
+		res.Behind, res.Ahead = parseLeftRight(counts)
+	}
+	return res, nil
 }
 
 // Pull fetches changes from the container and integrates Repos[repoIdx] into
-// the local branch.
+// the local branch with opts.Strategy. With [Client.SignCommits], the
+// container's new commits are signed on the host first. The result has what
+// changed in the local branch. On conflicts, it returns a [ConflictError].
 //
-// p controls AI commit message generation. Pass nil to use a default message.
-func (c *Container) Pull(ctx context.Context, stdout, stderr io.Writer, repoIdx int, p genai.Provider) error {
-	if err := c.Fetch(ctx, stdout, stderr, repoIdx, p); err != nil {
-		return err
+// A nil opts rebases and uses a default commit message.
+func (c *Container) Pull(ctx context.Context, stdout, stderr io.Writer, repoIdx int, opts *PullOpts) (*SyncResult, error) {
+	if opts == nil {
+		opts = &PullOpts{}
+	}
+	if err := opts.check(); err != nil {
+		return nil, err
+	}
+	if repoIdx < 0 || repoIdx >= len(c.Repos) {
+		return nil, fmt.Errorf("repo index %d out of range [0, %d)", repoIdx, len(c.Repos))
 	}
 	r := c.Repos[repoIdx]
-	remoteRef := c.Name + "/" + r.Branch
-	currentBranch, _ := gitutil.RunGit(ctx, r.GitRoot, "branch", "--show-current")
-	if currentBranch == r.Branch {
-		// Already on the branch, rebase locally.
-		if err := runCmdOut(ctx, r.GitRoot, []string{"git", "rebase", "-q", remoteRef}, stdout, stderr); err != nil {
-			return err
+	if !gitutil.IsJJ(r.GitRoot) {
+		if err := checkNoPullInProgress(ctx, r.GitRoot); err != nil {
+			return nil, err
 		}
-	} else if _, err := gitutil.RunGit(ctx, r.GitRoot, "merge-base", "--is-ancestor", r.Branch, remoteRef); err == nil {
-		// Fast-forward: update ref without checkout.
-		if err := runCmdOut(ctx, r.GitRoot, []string{"git", "update-ref", "refs/heads/" + r.Branch, remoteRef}, stdout, stderr); err != nil {
-			return err
-		}
-	} else {
-		// Not a fast-forward. Checkout the branch, rebase, then checkout back.
-		origRef := currentBranch
-		if origRef == "" {
-			origRef, _ = gitutil.RunGit(ctx, r.GitRoot, "rev-parse", "HEAD")
+	}
+	if _, err := c.Fetch(ctx, stdout, stderr, repoIdx, &FetchOpts{Provider: opts.Provider}); err != nil {
+		return nil, err
+	}
+	if c.SignCommits {
+		if err := c.signCommits(ctx, stdout, &r); err != nil {
+			return nil, err
 		}
-		if err := runCmdOut(ctx, r.GitRoot, []string{"git", "checkout", "-q", r.Branch}, stdout, stderr); err != nil {
-			return err
+	}
+	if err := c.pullLFS(ctx, stdout, &r, "refs/remotes/"+c.Name+"/"+r.Branch); err != nil {
+		return nil, err
+	}
+	before, _ := gitutil.RunGit(ctx, r.GitRoot, "rev-parse", "-q", "--verify", "refs/heads/"+r.Branch)
+	if err := c.integrate(ctx, stdout, stderr, &r, opts); err != nil {
+		return nil, err
+	}
+	res := &SyncResult{Stat: diffStat(ctx, r.GitRoot, before, "refs/heads/"+r.Branch)}
+	if res.Stat != nil {
+		_, _ = fmt.Fprintf(stdout, "- Integrated into %s: %s\n", r.Branch, res.Stat)
+	}
+	return res, nil
+}
+
+// integrate brings the fetched state of r's branch in the container into the
+// local branch and makes it the container's base.
+func (c *Container) integrate(ctx context.Context, stdout, stderr io.Writer, r *Repo, opts *PullOpts) error {
+	remoteRef := c.Name + "/" + r.Branch
+	if gitutil.IsJJ(r.GitRoot) {
+		if opts.Strategy != "" && opts.Strategy != PullRebase {
+			return fmt.Errorf("the %s strategy isn't supported in jj repositories", opts.Strategy)
 		}
-		if err := runCmdOut(ctx, r.GitRoot, []string{"git", "rebase", "-q", remoteRef}, stdout, stderr); err != nil {
-			_ = runCmdOut(ctx, r.GitRoot, []string{"git", "checkout", "-q", origRef}, stdout, stderr)
-			return err
+		// jj owns the working copy: only move the bookmark, which jj imports
+		// on its next command.
+		if _, err := gitutil.RunGit(ctx, r.GitRoot, "merge-base", "--is-ancestor", r.Branch, remoteRef); err != nil {
+			return fmt.Errorf("bookmark %s diverged from %s: run 'jj git import' and 'jj rebase' to reconcile", r.Branch, remoteRef)
 		}
-		if err := runCmdOut(ctx, r.GitRoot, []string{"git", "checkout", "-q", origRef}, stdout, stderr); err != nil {
+		if err := runCmdOut(ctx, r.GitRoot, []string{"git", "update-ref", "refs/heads/" + r.Branch, remoteRef}, stdout, stderr); err != nil {
 			return err
 		}
+		_, _ = fmt.Fprintf(stdout, "- Moved bookmark %s; run 'jj new %s' to work on top of it\n", r.Branch, r.Branch)
+		return nil
+	}
+	fetched, err := gitutil.RevParse(ctx, r.GitRoot, remoteRef)
+	if err != nil {
+		return err
 	}
-	return runCmdOut(ctx, r.GitRoot, []string{"git", "push", "-q", "-f", c.Name, r.Branch + ":base"}, stdout, stderr)
+	if err := integrateBranch(ctx, stdout, stderr, r.GitRoot, r.Branch, remoteRef, c.Name, opts); err != nil {
+		return err
+	}
+	return c.finishIntegration(ctx, stdout, stderr, r, opts.Strategy, fetched)
 }
 
 // Diff writes the diff between base and current for Repos[repoIdx] to stdout/stderr.
 // When stdout is a terminal, a TTY is allocated so git's pager and colors work.
+// When the container mounts the host checkout read-write or read-only, the diff
+// is of its uncommitted changes against HEAD.
 func (c *Container) Diff(ctx context.Context, stdout, stderr io.Writer, repoIdx int, extraArgs []string) error {
+	return c.DiffWith(ctx, stdout, stderr, repoIdx, &DiffOpts{}, extraArgs)
+}
+
+// DiffSince is like Diff but compares against a past state of the container's
+// repository instead of base when since is set: "push#N" is its state before
+// the Nth md push (push#-1 the latest), a duration like "2h ago" or a time
+// like "2006-01-02 15:04" is its last commit at that time.
+func (c *Container) DiffSince(ctx context.Context, stdout, stderr io.Writer, repoIdx int, since string, extraArgs []string) error {
+	return c.DiffWith(ctx, stdout, stderr, repoIdx, &DiffOpts{Since: since}, extraArgs)
+}
+
+// DiffOpts selects what [Container.DiffWith] compares the container's working
+// tree with. At most one field may be set; none means base.
+type DiffOpts struct {
+	// Since is a past state of the container's repository, as for
+	// [Container.DiffSince].
+	Since string
+	// Base is a ref of the container's repository, e.g. "HEAD~3", a branch or
+	// a commit.
+	Base string
+	// From is another container of the same repository: the diff goes from
+	// its working tree to this container's.
+	From *Container
+}
+
+// DiffWith is like Diff but compares against what opts selects.
+func (c *Container) DiffWith(ctx context.Context, stdout, stderr io.Writer, repoIdx int, opts *DiffOpts, extraArgs []string) error {
 	if len(c.Repos) == 0 {
 		return errors.New("container has no repos")
 	}
 	if repoIdx < 0 || repoIdx >= len(c.Repos) {
 		return fmt.Errorf("repo index %d out of range [0, %d)", repoIdx, len(c.Repos))
 	}
+	n := 0
+	for _, set := range []bool{opts.Since != "", opts.Base != "", opts.From != nil} {
+		if set {
+			n++
+		}
+	}
+	if n > 1 {
+		return errors.New("since, base and from are mutually exclusive")
+	}
+	if opts.From != nil {
+		return c.diffFrom(ctx, stdout, stderr, repoIdx, opts.From, extraArgs)
+	}
+	if opts.Since != "" {
+		if err := c.checkOwnsGit("diff the history of"); err != nil {
+			return err
+		}
+		if _, err := parseSince(opts.Since, time.Now()); err != nil {
+			return err
+		}
+	}
+	if strings.HasPrefix(opts.Base, "-") {
+		return fmt.Errorf("invalid ref %q", opts.Base)
+	}
 	if err := c.checkContainerState(ctx); err != nil {
 		return err
 	}
-	if err := c.SyncDefaultBranch(ctx, repoIdx); err != nil {
-		return err
+	c.touch()
+	// A read-write or read-only checkout has no base branch: show its
+	// uncommitted changes without staging them, as the index is the host's.
+	gitDiff := "git diff HEAD "
+	if c.MountSource.ownsGit() {
+		if err := c.SyncDefaultBranch(ctx, repoIdx); err != nil {
+			return err
+		}
+		gitDiff = "git add . && git diff base "
+	}
+	if opts.Since != "" {
+		rev, err := c.resolveSince(ctx, repoIdx, opts.Since)
+		if err != nil {
+			return err
+		}
+		gitDiff = "git add . && git diff " + shellQuote(rev) + " "
+	}
+	if opts.Base != "" {
+		gitDiff = "git diff " + shellQuote(opts.Base) + " "
+		if c.MountSource.ownsGit() {
+			gitDiff = "git add . && " + gitDiff
+		}
 	}
 	quotedArgs := make([]string, len(extraArgs))
 	for i, a := range extraArgs {
@@ -660,7 +1573,7 @@ func (c *Container) Diff(ctx context.Context, stdout, stderr io.Writer, repoIdx
 		sshArgs = append(sshArgs, "-t")
 		cmd.Stdin = os.Stdin
 	}
-	sshArgs = append(sshArgs, c.Name, "cd ~/src/"+repoName+" && git add . && git diff base "+strings.Join(quotedArgs, " ")+" -- .")
+	sshArgs = append(sshArgs, c.Name, "cd ~/src/"+repoName+" && "+gitDiff+strings.Join(quotedArgs, " ")+" -- .")
 	var err error
 	cmd.Path, err = exec.LookPath(sshArgs[0])
 	if err != nil {
@@ -672,8 +1585,56 @@ func (c *Container) Diff(ctx context.Context, stdout, stderr io.Writer, repoIdx
 	return cmd.Run()
 }
 
+// diffRef is the ref holding a container's working tree for a diff with
+// another container, in the container and, suffixed by its name, on the host.
+const diffRef = "refs/md/diff"
+
+// diffFrom writes the diff from the working tree of Repos[repoIdx] in the
+// container from to c's. Each working tree is staged and recorded as a commit
+// on top of HEAD in diffRef, fetched by the host where git diffs them.
+func (c *Container) diffFrom(ctx context.Context, stdout, stderr io.Writer, repoIdx int, from *Container, extraArgs []string) error {
+	r := c.Repos[repoIdx]
+	if from.Name == c.Name {
+		return errors.New("can't diff a container with itself")
+	}
+	fromIdx := slices.IndexFunc(from.Repos, func(o Repo) bool { return o.GitRoot == r.GitRoot })
+	if fromIdx < 0 {
+		return fmt.Errorf("%s doesn't have %s", from.Name, r.GitRoot)
+	}
+	var refs [2]string
+	for i, side := range []struct {
+		ct  *Container
+		idx int
+	}{{from, fromIdx}, {c, repoIdx}} {
+		ct := side.ct
+		if err := ct.checkOwnsGit("diff"); err != nil {
+			return err
+		}
+		if err := ct.checkContainerState(ctx); err != nil {
+			return err
+		}
+		ct.touch()
+		script := "cd ~/src/" + shellQuote(ct.Repos[side.idx].Name()) +
+			" && git add . && git update-ref " + diffRef +
+			" \"$(git -c user.name=md -c user.email=md@localhost commit-tree -p HEAD -m 'md diff' \"$(git write-tree)\")\""
+		if err := runCmdOut(ctx, "", ct.SSHCommand(ct.Name, script), io.Discard, stderr); err != nil {
+			return fmt.Errorf("recording the working tree of %s: %w", ct.Name, err)
+		}
+		refs[i] = diffRef + "/" + ct.Name
+		if _, err := gitutil.RunGit(ctx, r.GitRoot, "fetch", "-q", "--no-tags", ct.Name, "+"+diffRef+":"+refs[i]); err != nil {
+			return fmt.Errorf("fetching the working tree of %s: %w", ct.Name, err)
+		}
+		defer func() { _, _ = gitutil.RunGit(context.WithoutCancel(ctx), r.GitRoot, "update-ref", "-d", refs[i]) }()
+	}
+	return runCmdOut(ctx, r.GitRoot, slices.Concat([]string{"git", "diff"}, extraArgs, []string{refs[0], refs[1], "--"}), stdout, stderr)
+}
+
 // ForkOpts configures a container fork operation.
 type ForkOpts struct {
+	// Branch is the destination branch of the primary repository. When
+	// empty, Fork generates one like for the other repos. It must not exist
+	// in the host repository nor be used by another container.
+	Branch string
 	// ExtraRepos are additional repos to map into the fork beyond the
 	// source container's repos. Branch is the source branch to push from
 	// the host; if empty, defaults to the repo's default upstream branch.
@@ -699,11 +1660,18 @@ type ForkOpts struct {
 	ExtraEnv []string
 	// MaxCPUs limits the number of CPU cores the forked container may use.
 	// Passed as --cpus to docker/podman. Zero means no limit.
-	// Use [DefaultMaxCPUs] for a sensible default.
+	// Use [DefaultMaxCPUs] for a sensible default. The other resource limits
+	// are inherited from the source container.
 	MaxCPUs int
 	// ExtraRunArgs are additional arguments passed verbatim to the
 	// container runtime's "run" command. Not portable across runtimes.
 	ExtraRunArgs []string
+	// BranchTemplate names the primary repository's destination branch when
+	// Branch is empty, with [BranchVars] of the source branch and Agent. A
+	// taken name gets a numbered suffix.
+	BranchTemplate string
+	// Agent is the agent's name for BranchTemplate.
+	Agent string
 }
 
 // Fork snapshots a running container and creates a new one where each mapped
@@ -716,6 +1684,9 @@ type ForkOpts struct {
 // Branch naming: each repo (source and extra) gets its own unique destination
 // branch derived from its source branch (e.g. "main" → "main-0").
 func (c *Container) Fork(ctx context.Context, stdout, stderr io.Writer, opts *ForkOpts) (*Container, error) {
+	if c.MountSource != SourceClone {
+		return nil, fmt.Errorf("%s mounts the host checkout (--mount-src=%s): the snapshot wouldn't include it", c.Name, c.MountSource)
+	}
 	if err := c.checkContainerState(ctx); err != nil {
 		return nil, err
 	}
@@ -757,6 +1728,33 @@ func (c *Container) Fork(ctx context.Context, stdout, stderr io.Writer, opts *Fo
 				}
 			}
 		}
+		if i == 0 && opts.Branch != "" {
+			if _, err := gitutil.RunGit(ctx, src.GitRoot, "check-ref-format", "--branch", opts.Branch); err != nil {
+				return nil, fmt.Errorf("invalid branch name %q", opts.Branch)
+			}
+			if _, ok := usedBranches[opts.Branch]; ok {
+				return nil, fmt.Errorf("branch %s of %s is already used by a container", opts.Branch, src.Name())
+			}
+			if _, err := gitutil.RunGit(ctx, src.GitRoot, "rev-parse", "--verify", "refs/heads/"+opts.Branch); err == nil {
+				return nil, fmt.Errorf("branch %s already exists in %s", opts.Branch, src.GitRoot)
+			}
+			forkRepos[i].Branch = opts.Branch
+			continue
+		}
+		if i == 0 && opts.BranchTemplate != "" {
+			name, err := RenderBranch(opts.BranchTemplate, &BranchVars{Agent: opts.Agent, Slug: Slugify(src.Branch), Branch: src.Branch, Repo: src.Name()})
+			if err != nil {
+				return nil, err
+			}
+			forkRepos[i].Branch = UniqueBranch(name, func(b string) bool {
+				if _, ok := usedBranches[b]; ok {
+					return true
+				}
+				_, err := gitutil.RunGit(ctx, src.GitRoot, "rev-parse", "--verify", "refs/heads/"+b)
+				return err == nil
+			})
+			continue
+		}
 		for n := 0; ; n++ {
 			cand := fmt.Sprintf("%s-%d", src.Branch, n)
 			if _, ok := usedBranches[cand]; ok {
@@ -778,12 +1776,12 @@ func (c *Container) Fork(ctx context.Context, stdout, stderr io.Writer, opts *Fo
 		_, _ = fmt.Fprintf(stdout, "- Snapshotting container %s → %s ...\n", c.Name, snapshotImage)
 	}
 	// Inspect the source container to discover all label keys.
-	labelCSV, err := runCmd(ctx, "", []string{rt, "inspect", "--format", `{{range $k, $v := .Config.Labels}}{{$k}} {{end}}`, c.Name})
+	info, err := inspectContainer(ctx, rt, c.Name)
 	if err != nil {
 		return nil, fmt.Errorf("inspecting labels: %w", err)
 	}
 	commitArgs := []string{rt, "commit"}
-	for key := range strings.FieldsSeq(labelCSV) {
+	for _, key := range slices.Sorted(maps.Keys(info.Config.Labels)) {
 		commitArgs = append(commitArgs, "--change", "LABEL "+key+"=")
 	}
 	commitArgs = append(commitArgs, c.Name, snapshotImage)
@@ -800,11 +1798,11 @@ func (c *Container) Fork(ctx context.Context, stdout, stderr io.Writer, opts *Fo
 		_, _ = fmt.Fprintln(stdout, "- Creating local branches ...")
 	}
 	for i, r := range c.Repos {
-		if err := runCmdOut(ctx, r.GitRoot, []string{"git", "fetch", "-q", c.Name, r.Branch}, stdout, stderr); err != nil {
+		if err := r.vcs().Fetch(ctx, r.GitRoot, c.Name, r.Branch, gitutil.FetchOpts{}); err != nil {
 			return nil, fmt.Errorf("fetching %s from source container: %w", r.Name(), err)
 		}
 		fetchedRef := c.Name + "/" + r.Branch
-		curr, _ := gitutil.CurrentBranch(ctx, r.GitRoot)
+		curr, _ := r.vcs().CurrentBranch(ctx, r.GitRoot)
 		newBranch := fork.Repos[i].Branch
 		if curr == newBranch {
 			if err := runCmdOut(ctx, r.GitRoot, []string{"git", "reset", "--hard", fetchedRef}, stdout, stderr); err != nil {
@@ -822,16 +1820,43 @@ func (c *Container) Fork(ctx context.Context, stdout, stderr io.Writer, opts *Fo
 		_, _ = fmt.Fprintf(stdout, "- Starting forked container %s ...\n", fork.Name)
 	}
 	startOpts := &StartOpts{
-		Quiet:        opts.Quiet,
-		Labels:       opts.Labels,
-		AgentPaths:   opts.AgentPaths,
-		ExtraEnv:     opts.ExtraEnv,
-		Display:      c.Display || opts.Display,
-		Tailscale:    c.Tailscale || opts.Tailscale,
-		USB:          c.USB || opts.USB,
-		MaxCPUs:      opts.MaxCPUs,
-		ExtraRunArgs: opts.ExtraRunArgs,
-	}
+		Quiet:         opts.Quiet,
+		Labels:        opts.Labels,
+		AgentPaths:    opts.AgentPaths,
+		ExtraEnv:      opts.ExtraEnv,
+		Display:       c.Display || opts.Display,
+		Displays:      c.Displays,
+		DisplaySize:   c.DisplaySize,
+		RDP:           c.RDP,
+		Browser:       c.Browser,
+		Tailscale:     c.Tailscale || opts.Tailscale,
+		USB:           c.USB || opts.USB,
+		MaxCPUs:       opts.MaxCPUs,
+		Memory:        c.Memory,
+		PidsLimit:     c.PidsLimit,
+		ShmSize:       c.ShmSize,
+		HostPorts:     c.HostPorts,
+		CloneDepth:    c.CloneDepth,
+		CloneFilter:   c.CloneFilter,
+		SharedObjects: c.SharedObjects,
+		Bind:          c.Bind,
+		Sudo:          c.Sudo,
+		ExtraRunArgs:  opts.ExtraRunArgs,
+	}
+	// The fork gets its own private network.
+	if c.Network != privateNetwork(c.Name) {
+		startOpts.NetworkMode = c.Network
+	}
+	startOpts.NetworkPolicy = c.NetworkPolicy
+	// Fork calls launchContainer, not Launch, so the credentials aren't
+	// collected again: Credentials only sets the md.credentials labels. The
+	// fork has the credential files the snapshot captured, as the source
+	// container left them: their directories are user-owned, so the agent
+	// there may have replaced them. writeEnv below rewrites ~/.env without the
+	// credentials' env vars, so those, scoped ones included, are dropped.
+	startOpts.Credentials = c.Credentials
+	startOpts.ScopedCredentials = c.ScopedCredentials
+	startOpts.Mounts = c.Mounts
 	if err := c.prepare(startOpts.AgentPaths); err != nil {
 		return nil, err
 	}
@@ -840,45 +1865,14 @@ func (c *Container) Fork(ctx context.Context, stdout, stderr io.Writer, opts *Fo
 	}
 
 	// Wait for SSH and set up repos.
-	addr := fmt.Sprintf("localhost:%d", fork.SSHPort)
 	deadline := time.Now().Add(30 * time.Second)
-	if err := waitForTCP(ctx, addr, deadline); err != nil {
+	if err := fork.waitForSSHPort(ctx, deadline); err != nil {
 		return nil, fmt.Errorf("waiting for SSH on forked container: %w", err)
 	}
 
 	// Send .env into the forked container.
-	var envContent []byte
-	for _, r := range forkRepos {
-		data, err := os.ReadFile(filepath.Join(r.GitRoot, ".env"))
-		if err != nil {
-			continue
-		}
-		if len(envContent) > 0 && envContent[len(envContent)-1] != '\n' {
-			envContent = append(envContent, '\n')
-		}
-		envContent = append(envContent, data...)
-	}
-	if len(startOpts.ExtraEnv) > 0 {
-		if len(envContent) > 0 && envContent[len(envContent)-1] != '\n' {
-			envContent = append(envContent, '\n')
-		}
-		for _, kv := range startOpts.ExtraEnv {
-			envContent = append(envContent, []byte(kv+"\n")...)
-		}
-	}
-	sshEnvArgs := fork.SSHCommand(fork.Name, "cat > /home/user/.env")
-	for {
-		cmd := exec.CommandContext(ctx, sshEnvArgs[0], sshEnvArgs[1:]...)
-		cmd.Stdin = bytes.NewReader(envContent)
-		out, err := cmd.CombinedOutput()
-		if err == nil {
-			break
-		}
-		var exitErr *exec.ExitError
-		if !errors.As(err, &exitErr) || exitErr.ExitCode() != 255 || time.Now().After(deadline) {
-			return nil, fmt.Errorf("copying .env to forked container: %w\n%s", err, out)
-		}
-		time.Sleep(10 * time.Millisecond)
+	if err := writeEnv(ctx, fork, appendEnv(appendEnv(appendEnv(readEnvFiles(forkRepos), startOpts.ExtraEnv), c.Proxy.Env()), fork.hostPortsEnv()), deadline); err != nil {
+		return nil, fmt.Errorf("forked container: %w", err)
 	}
 
 	// Inside the forked container: rename branches for source repos,
@@ -891,10 +1885,7 @@ func (c *Container) Fork(ctx context.Context, stdout, stderr io.Writer, opts *Fo
 		oldBranch := shellQuote(r.Branch)
 		newBranch := shellQuote(fork.Repos[i].Branch)
 
-		if err := runCmdOut(ctx, fork.Repos[i].GitRoot, []string{
-			"git", "push", "-q", "-f", fork.Name,
-			fork.Repos[i].Branch + ":refs/heads/base",
-		}, stdout, stderr); err != nil {
+		if err := fork.sendRef(ctx, stdout, stderr, &fork.Repos[i], fork.Repos[i].Branch, "base", gitutil.PushOpts{Force: true}); err != nil {
 			return nil, fmt.Errorf("pushing base for %s: %w", r.Name(), err)
 		}
 		renameCmd := "cd ~/src/" + repoName +
@@ -903,9 +1894,7 @@ func (c *Container) Fork(ctx context.Context, stdout, stderr io.Writer, opts *Fo
 		if err := runCmdOut(ctx, "", fork.SSHCommand(fork.Name, renameCmd), stdout, stderr); err != nil {
 			return nil, fmt.Errorf("renaming branch for %s: %w", r.Name(), err)
 		}
-		if err := runCmdOut(ctx, fork.Repos[i].GitRoot, []string{
-			"git", "fetch", "-q", fork.Name, fork.Repos[i].Branch,
-		}, stdout, stderr); err != nil {
+		if err := fork.Repos[i].vcs().Fetch(ctx, fork.Repos[i].GitRoot, fork.Name, fork.Repos[i].Branch, gitutil.FetchOpts{}); err != nil {
 			return nil, fmt.Errorf("fetching %s from fork: %w", fork.Repos[i].Branch, err)
 		}
 		if err := runCmdOut(ctx, fork.Repos[i].GitRoot, []string{
@@ -926,10 +1915,10 @@ func (c *Container) Fork(ctx context.Context, stdout, stderr io.Writer, opts *Fo
 		if err := runCmdOut(ctx, "", fork.SSHCommand(fork.Name, "git init -q ~/src/"+rRepo), stdout, stderr); err != nil {
 			return nil, fmt.Errorf("init extra repo %s in container: %w", rName, err)
 		}
-		if err := runCmdOut(ctx, src.GitRoot, []string{
-			"git", "push", "-q", fork.Name,
-			src.Branch + ":refs/heads/base",
-		}, stdout, stderr); err != nil {
+		if err := fork.guardBase(ctx, rName); err != nil {
+			return nil, err
+		}
+		if err := src.vcs().PushRef(ctx, src.GitRoot, fork.Name, src.Branch, "base", basePush(gitutil.PushOpts{})); err != nil {
 			return nil, fmt.Errorf("push extra repo %s: %w", rName, err)
 		}
 		setupCmd := "cd ~/src/" + rRepo +
@@ -944,6 +1933,28 @@ func (c *Container) Fork(ctx context.Context, stdout, stderr io.Writer, opts *Fo
 	return fork, nil
 }
 
+// CloneTo duplicates the running container onto branch, a new branch of the
+// primary repository, so two approaches can be tried in parallel from the
+// same in-progress state.
+//
+// It is [Container.Fork] with the primary repository's destination branch
+// chosen: the new container starts from a snapshot of this one, its working
+// tree included, and its git remote is set up on the host. opts may be nil.
+func (c *Container) CloneTo(ctx context.Context, stdout, stderr io.Writer, branch string, opts *ForkOpts) (*Container, error) {
+	if branch == "" {
+		return nil, errors.New("clone: branch is required")
+	}
+	if len(c.Repos) == 0 {
+		return nil, errors.New("container has no repos")
+	}
+	o := ForkOpts{}
+	if opts != nil {
+		o = *opts
+	}
+	o.Branch = branch
+	return c.Fork(ctx, stdout, stderr, &o)
+}
+
 // ContainerStats holds runtime resource usage for a container.
 type ContainerStats struct {
 	// CPUPerc is the CPU usage as a percentage (e.g. 1.23).
@@ -966,6 +1977,13 @@ type ContainerStats struct {
 	BlockWrite uint64 `json:"block_write"`
 	// DiskUsed is the writable container layer size in bytes (-1 if unavailable).
 	DiskUsed int64 `json:"disk_used"`
+	// VolumesUsed is the size in bytes of the engine volumes attached to the
+	// container, measured from inside it (-1 if unavailable, e.g. when
+	// stopped). Host bind mounts such as caches are not counted.
+	VolumesUsed int64 `json:"volumes_used"`
+	// FS is the disk usage measured from inside the container, nil when it
+	// isn't running.
+	FS *FilesystemUsage `json:"fs,omitempty"`
 }
 
 // Stats returns the current resource usage for the container, including CPU,
@@ -983,23 +2001,54 @@ func (c *Container) Stats(ctx context.Context) (*ContainerStats, error) {
 		return nil, fmt.Errorf("parsing stats for %s: %w", c.Name, err)
 	}
 	s.DiskUsed, _ = c.DiskUsage(ctx)
+	s.VolumesUsed = 0
+	if info, err := inspectContainer(ctx, c.Runtime, c.Name); err == nil {
+		if dests := info.volumeDestinations(); len(dests) > 0 {
+			s.VolumesUsed = volumesUsage(ctx, c.Runtime, c.Name, dests)
+		}
+	}
 	return s, nil
 }
 
+// volumesUsage returns the total size of the directories dests in the running
+// container name, or -1 when it can't be measured.
+func volumesUsage(ctx context.Context, rt, name string, dests []string) int64 {
+	out, err := runCmd(ctx, "", append([]string{rt, "exec", name, "du", "-sxbc"}, dests...))
+	if err != nil {
+		return -1
+	}
+	return parseDuTotal(out)
+}
+
+// parseDuTotal returns the total of `du -c` output, or -1.
+func parseDuTotal(out string) int64 {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	f := strings.Fields(lines[len(lines)-1])
+	if len(f) != 2 || f[1] != "total" {
+		return -1
+	}
+	n, err := strconv.ParseInt(f[0], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
 // DiskUsage returns the writable container layer size in bytes via
 // docker inspect --size. Works for both running and stopped containers.
 func (c *Container) DiskUsage(ctx context.Context) (int64, error) {
-	out, err := runCmd(ctx, "", []string{
-		c.Runtime, "inspect", "--size", "--format", "{{json .SizeRw}}", c.Name,
-	})
+	out, err := runCmdRetry(ctx, "", []string{c.Runtime, "container", "inspect", "--size", c.Name})
 	if err != nil {
 		return -1, fmt.Errorf("inspecting container %s: %w", c.Name, err)
 	}
-	var sz int64
-	if err := json.Unmarshal([]byte(out), &sz); err != nil {
-		return -1, fmt.Errorf("parsing SizeRw: %w", err)
+	info, err := decodeInspect[containerInfo](out, c.Name)
+	if err != nil {
+		return -1, err
+	}
+	if info.SizeRw == nil {
+		return -1, fmt.Errorf("inspecting container %s: no SizeRw", c.Name)
 	}
-	return sz, nil
+	return *info.SizeRw, nil
 }
 
 // StatsAll fetches resource usage for multiple containers in batch (2 docker
@@ -1045,39 +2094,57 @@ func StatsAll(ctx context.Context, runtime string, names []string) (map[string]*
 	})
 
 	// Batch docker inspect --size (one call).
+	volumes := map[string][]string{}
 	wg.Go(func() {
-		args := make([]string, 0, 5+len(names))
-		args = append(args, runtime, "inspect", "--size", "--format", "{{.Name}}\t{{json .SizeRw}}")
+		args := make([]string, 0, 3+len(names))
+		args = append(args, runtime, "inspect", "--size")
 		args = append(args, names...)
-		out, err := runCmd(ctx, "", args)
+		out, err := runCmdRetry(ctx, "", args)
 		if err != nil {
 			inspectErr = fmt.Errorf("docker inspect --size: %w", err)
 			return
 		}
-		for line := range strings.SplitSeq(out, "\n") {
-			line = strings.TrimSpace(line)
-			if line == "" {
-				continue
-			}
-			parts := strings.SplitN(line, "\t", 2)
-			if len(parts) != 2 {
-				continue
-			}
-			name := strings.TrimPrefix(parts[0], "/")
-			var sz int64
-			if err := json.Unmarshal([]byte(parts[1]), &sz); err != nil {
+		var infos []containerInfo
+		if err := json.Unmarshal([]byte(out), &infos); err != nil {
+			inspectErr = fmt.Errorf("docker inspect --size: %w", err)
+			return
+		}
+		for _, info := range infos {
+			if info.SizeRw == nil {
 				continue
 			}
+			name := strings.TrimPrefix(info.Name, "/")
 			mu.Lock()
 			if s, ok := result[name]; ok {
-				s.DiskUsed = sz
+				s.DiskUsed = *info.SizeRw
 			} else {
-				result[name] = &ContainerStats{DiskUsed: sz}
+				result[name] = &ContainerStats{DiskUsed: *info.SizeRw}
+			}
+			if dests := info.volumeDestinations(); len(dests) > 0 {
+				volumes[name] = dests
 			}
 			mu.Unlock()
 		}
 	})
 
+	wg.Wait()
+
+	// Measure attached volumes and the filesystem from inside the
+	// containers, concurrently.
+	for name, dests := range volumes {
+		s := result[name]
+		if s == nil {
+			continue
+		}
+		wg.Go(func() {
+			s.VolumesUsed = volumesUsage(ctx, runtime, name, dests)
+		})
+	}
+	for name, s := range result {
+		wg.Go(func() {
+			s.FS = filesystemUsage(ctx, runtime, name)
+		})
+	}
 	wg.Wait()
 	return result, errors.Join(statsErr, inspectErr)
 }
@@ -1141,29 +2208,105 @@ func parseStatsLine(line string) (*ContainerStats, string, error) {
 // GetHostPort returns the host port mapped to a container port (e.g.
 // "5901/tcp"). Returns 0 if the port is not mapped.
 func (c *Container) GetHostPort(ctx context.Context, containerPort string) (int32, error) {
+	if c.Kube != nil {
+		// Pods are reached through kubectl exec, without host ports.
+		return 0, nil
+	}
 	rt := c.Runtime
-	if _, err := runCmd(ctx, "", []string{rt, "inspect", c.Name}); err != nil {
+	if _, err := runCmdRetry(ctx, "", []string{rt, "inspect", c.Name}); err != nil {
 		return 0, fmt.Errorf("container %s is not running", c.Name)
 	}
+	if c.Network == NetworkNone || c.Network == NetworkHost {
+		// Nothing is published; sshd's port is known from the start.
+		if containerPort == "22/tcp" {
+			return c.SSHPort, nil
+		}
+		return 0, nil
+	}
 	return getHostPort(ctx, rt, c.Name, containerPort)
 }
 
+// GetHostAddr returns the "host:port" address to connect to a container port
+// (e.g. "5901/tcp") from this machine, honoring [StartOpts.Bind]. Returns ""
+// if the port is not mapped.
+func (c *Container) GetHostAddr(ctx context.Context, containerPort string) (string, error) {
+	if c.Kube != nil {
+		return "", nil
+	}
+	rt := c.Runtime
+	if _, err := runCmdRetry(ctx, "", []string{rt, "inspect", c.Name}); err != nil {
+		return "", fmt.Errorf("container %s is not running", c.Name)
+	}
+	info, err := inspectContainer(ctx, rt, c.Name)
+	if err != nil {
+		return "", err
+	}
+	bindings := info.NetworkSettings.Ports[containerPort]
+	if len(bindings) == 0 {
+		return "", nil
+	}
+	return net.JoinHostPort(dialHost(bindings[0].HostIP), bindings[0].HostPort), nil
+}
+
+// MaxDisplays is the maximum number of virtual displays per container.
+const MaxDisplays = 8
+
+// VNCContainerPort returns the container port, e.g. "5901/tcp", of the VNC
+// server of display n (1-based).
+func VNCContainerPort(n int) string {
+	return strconv.Itoa(5900+n) + "/tcp"
+}
+
+// ValidateDisplays checks the display options of [StartOpts]: at most
+// [MaxDisplays] displays and, when set, a valid size. Callers validate user
+// input with it before any build work.
+func ValidateDisplays(displays int, size string) error {
+	if displays < 1 || displays > MaxDisplays {
+		return fmt.Errorf("invalid number of displays: %d (between 1 and %d)", displays, MaxDisplays)
+	}
+	if size != "" {
+		return validateDisplaySize(size)
+	}
+	return nil
+}
+
+// validateDisplaySize checks that s is a WIDTHxHEIGHT geometry such as
+// "2560x1440".
+func validateDisplaySize(s string) error {
+	w, h, ok := strings.Cut(s, "x")
+	if !ok {
+		return fmt.Errorf("invalid display size %q: expected WIDTHxHEIGHT", s)
+	}
+	for _, v := range []string{w, h} {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 64 || n > 16384 {
+			return fmt.Errorf("invalid display size %q: dimensions must be between 64 and 16384", s)
+		}
+	}
+	return nil
+}
+
+// queryVNCPorts refreshes VNCPort and VNCPorts from the runtime's port map,
+// one entry per virtual display. Unmapped displays are reported as 0.
+func (c *Container) queryVNCPorts(ctx context.Context) {
+	n := max(1, c.Displays)
+	c.VNCPorts = make([]int32, n)
+	for i := range n {
+		c.VNCPorts[i], _ = getHostPort(ctx, c.Runtime, c.Name, VNCContainerPort(i+1))
+	}
+	c.VNCPort = c.VNCPorts[0]
+}
+
 // getHostPort extracts the host port for containerPort from a running
-// container. It uses JSON output instead of Go templates to work around
-// Docker 27's "index of untyped nil" bug when port bindings are nil.
+// container. It decodes the JSON document instead of using Go templates to
+// work around Docker 27's "index of untyped nil" bug when port bindings are
+// nil.
 func getHostPort(ctx context.Context, rt, container, containerPort string) (int32, error) {
-	raw, err := runCmd(ctx, "", []string{rt, "inspect", "--format", "{{json .NetworkSettings.Ports}}", container})
+	info, err := inspectContainer(ctx, rt, container)
 	if err != nil {
 		return 0, err
 	}
-	var ports map[string][]struct {
-		HostIP   string `json:"HostIp"`
-		HostPort string `json:"HostPort"`
-	}
-	if err := json.Unmarshal([]byte(raw), &ports); err != nil {
-		return 0, fmt.Errorf("parsing port map: %w", err)
-	}
-	bindings := ports[containerPort]
+	bindings := info.NetworkSettings.Ports[containerPort]
 	if len(bindings) == 0 {
 		return 0, nil
 	}
@@ -1205,15 +2348,54 @@ func (c *Container) SyncDefaultBranch(ctx context.Context, repoIdx int) error {
 	if r.DefaultBranch == r.Branch {
 		return nil
 	}
-	if _, err := gitutil.RunGit(ctx, r.GitRoot, "push", "-q", "-f", c.Name, "refs/remotes/"+r.DefaultRemote+"/"+r.DefaultBranch+":refs/heads/"+r.DefaultBranch); err != nil {
+	if err := c.sendRef(ctx, io.Discard, io.Discard, &r, "refs/remotes/"+r.DefaultRemote+"/"+r.DefaultBranch, r.DefaultBranch, gitutil.PushOpts{Force: true}); err != nil {
+		return fmt.Errorf("sync default branch %q: %w", r.DefaultBranch, err)
+	}
+	// Merging or checking out the default branch in the container needs its
+	// LFS objects too.
+	if _, err := c.pushLFS(ctx, io.Discard, &r, "refs/remotes/"+r.DefaultRemote+"/"+r.DefaultBranch); err != nil {
 		return fmt.Errorf("sync default branch %q: %w", r.DefaultBranch, err)
 	}
 	return nil
 }
 
+// touch records that the container is being used, for md gc.
+func (c *Container) touch() {
+	touchLastUsed(filepath.Join(c.Home, ".ssh", "config.d"), c.Name)
+}
+
+// sshEndpoint returns how ssh reaches the container's sshd.
+func (c *Container) sshEndpoint() sshEndpoint {
+	if c.Kube != nil {
+		return sshEndpoint{ProxyCommand: c.Kube.proxyCommand(podName(c.Name))}
+	}
+	if c.Network == NetworkNone {
+		return sshEndpoint{ProxyCommand: c.execProxyCommand()}
+	}
+	return sshEndpoint{Host: dialHost(c.Bind), Port: c.SSHPort, ProxyJump: c.RemoteHost}
+}
+
+// waitForSSHPort waits for the container's SSH port to accept connections.
+// The port isn't reachable from here on a remote engine or a pod: the SSH
+// handshake that follows checks it instead.
+func (c *Container) waitForSSHPort(ctx context.Context, deadline time.Time) error {
+	if c.Kube != nil || c.RemoteHost != "" || c.Network == NetworkNone {
+		return nil
+	}
+	return waitForTCP(ctx, net.JoinHostPort(dialHost(c.Bind), strconv.Itoa(int(c.SSHPort))), deadline)
+}
+
+// exists reports whether the container, or its pod, exists.
+func (c *Container) exists(ctx context.Context) bool {
+	if c.Kube != nil {
+		return c.Kube.podExists(ctx, c.Name)
+	}
+	_, err := runCmdRetry(ctx, "", []string{c.Runtime, "inspect", c.Name})
+	return err == nil
+}
+
 func (c *Container) checkContainerState(ctx context.Context) error {
-	_, containerErr := runCmd(ctx, "", []string{c.Runtime, "inspect", c.Name})
-	containerExists := containerErr == nil
+	containerExists := c.exists(ctx)
 	var remoteExists bool
 	if len(c.Repos) > 0 {
 		_, remoteErr := gitutil.RunGit(ctx, c.Repos[0].GitRoot, "remote", "get-url", c.Name)
@@ -1243,38 +2425,69 @@ func (c *Container) checkContainerState(ctx context.Context) error {
 		return fmt.Errorf("inconsistent state detected for %s:\n  - %s\nConsider running 'md purge' to clean up, then 'md start' to restart",
 			c.Name, strings.Join(issues, "\n  - "))
 	}
-	return nil
+	return c.refreshSSHConfig(ctx)
 }
 
 // ensureImage checks whether the user image needs rebuilding and, if so,
-// builds it. Returns the computed image name (keyed by base image and active
-// caches). The build is serialized via Client.buildMu.
-func (c *Container) ensureImage(ctx context.Context, stdout, stderr io.Writer, baseImage string, caches []CacheMount, quiet bool) (string, error) {
+// builds it. Returns the computed image name (keyed by base image, active
+// caches and sudo policy). opts.BaseImage must be set. The build is
+// serialized via Client.buildMu.
+func (c *Container) ensureImage(ctx context.Context, stdout, stderr io.Writer, opts *WarmupOpts) (string, error) {
+	if _, err := ParseSudoPolicy(string(opts.Sudo)); err != nil {
+		return "", err
+	}
 	c.buildMu.Lock()
 	defer c.buildMu.Unlock()
-	imageName := userImageName(baseImage, activeCacheKey(caches, c.Home))
-	if !c.imageBuildNeeded(ctx, c.Runtime, imageName, baseImage, c.keysDir, c.Home, caches) {
-		if !quiet {
+	imageName, reason := c.whyRebuild(ctx, opts)
+	if reason == nil {
+		if !opts.Quiet {
 			_, _ = fmt.Fprintf(stdout, "- Docker image %s is up to date, skipping build.\n", imageName)
 		}
 		return imageName, nil
 	}
-	if err := buildSpecializedImage(ctx, stdout, stderr, c.Runtime, c.keysDir, imageName, baseImage, c.Home, caches, agentContainerPaths(), quiet); err != nil {
+	if !opts.Quiet {
+		_, _ = fmt.Fprintf(stdout, "- Rebuilding %s because %s\n", imageName, reason)
+	}
+	if err := c.runHook(ctx, stdout, stderr, HookPreBuild, 0, false); err != nil {
+		return "", err
+	}
+	if err := buildSpecializedImage(ctx, stdout, stderr, c.Runtime, c.keysDir, imageName, opts.BaseImage, c.Home, opts.Caches, opts.Sudo, agentContainerPaths(), &c.Proxy, c.registryOffline(ctx, opts.BaseImage), opts.NoPull, opts.Quiet); err != nil {
 		return "", err
 	}
 	c.invalidateImageBuildCache()
 	return imageName, nil
 }
 
+// checkOwnsGit returns an error when the container works directly on the host
+// checkout, so there is no copy to op.
+func (c *Container) checkOwnsGit(op string) error {
+	if c.MountSource.ownsGit() {
+		return nil
+	}
+	return fmt.Errorf("%s mounts the host checkout (--mount-src=%s): there is no copy to %s", c.Name, c.MountSource, op)
+}
+
 func (c *Container) cleanup(ctx context.Context) {
-	removeSSHConfig(filepath.Join(c.Home, ".ssh", "config.d"), c.Name)
+	sshConfigDir := filepath.Join(c.Home, ".ssh", "config.d")
+	removeSSHConfig(sshConfigDir, c.Name)
+	_ = os.Remove(lastUsedPath(sshConfigDir, c.Name))
 	if len(c.Repos) > 0 {
 		_, _ = gitutil.RunGit(ctx, c.Repos[0].GitRoot, "remote", "remove", c.Name)
 		for _, repo := range c.Repos[1:] {
 			_, _ = gitutil.RunGit(ctx, repo.GitRoot, "remote", "remove", c.Name)
 		}
 	}
-	_, _ = runCmd(ctx, "", []string{c.Runtime, "rm", "-f", "-v", c.Name})
+	if c.Kube != nil {
+		_, _ = runCmd(ctx, "", c.Kube.kubectl("delete", "pod", podName(c.Name), "--wait=false"))
+	} else {
+		_, _ = runCmd(ctx, "", []string{c.Runtime, "rm", "-f", "-v", c.Name})
+	}
+	if c.Sidecars {
+		_ = c.removeSidecars(ctx)
+	}
+	_ = c.removeNetwork(ctx)
+	_ = os.RemoveAll(c.overlayDir())
+	_ = os.Remove(c.vncPasswordPath())
 }
 
 // pushSubmodules transfers submodule bare repos from hostGitRoot into the
@@ -1410,14 +2623,36 @@ func parseByteSize(s string) (uint64, error) {
 // runCmd executes a command, captures its output, and returns (stdout, error).
 // If dir is non-empty, the command runs in that directory.
 func runCmd(ctx context.Context, dir string, args []string) (string, error) {
+	return runCmdEnv(ctx, dir, args, nil)
+}
+
+// runCmdEnv is runCmd with env added to the environment.
+func runCmdEnv(ctx context.Context, dir string, args, env []string) (string, error) {
 	slog.DebugContext(ctx, "md", "msg", "exec", "cmd", args)
 	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
 	cmd.Dir = dir
-	cmd.Env = append(os.Environ(), "LANG=C")
+	cmd.Env = append(append(os.Environ(), env...), "LANG=C")
+	cmd.WaitDelay = cmdWaitDelay
 	out, err := cmd.Output()
 	return strings.TrimSpace(string(out)), err
 }
 
+// cmdWaitDelay bounds how long a canceled command's output is drained: a
+// child it left behind holding the pipes must not hang md.
+const cmdWaitDelay = 5 * time.Second
+
+// sleepCtx sleeps for d, returning ctx's error early when it is canceled.
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
 // cmdErrWithStderr wraps err with the captured stderr from an *exec.ExitError
 // so that quiet-mode failures include actionable output.
 func cmdErrWithStderr(prefix string, err error) error {
@@ -1440,6 +2675,7 @@ func runCmdOut(ctx context.Context, dir string, args []string, stdout, stderr io
 	cmd.Env = append(os.Environ(), "LANG=C")
 	cmd.Stdout = stdout
 	cmd.Stderr = stderr
+	cmd.WaitDelay = cmdWaitDelay
 	return cmd.Run()
 }
 
@@ -1509,27 +2745,89 @@ func unmarshalContainer(data []byte) (Container, error) {
 		if !ok {
 			continue
 		}
-		switch k {
-		case "md.repos":
-			if data, err := base64.StdEncoding.DecodeString(v); err == nil {
-				if err := json.Unmarshal(data, &ct.Repos); err != nil {
-					slog.Warn("md", "msg", "failed to unmarshal repos label", "err", err)
-				}
+		ct.setLabel(k, v)
+	}
+	return ct, nil
+}
+
+// setLabel sets the field backed by the md label k to v. Unknown labels are
+// ignored.
+func (c *Container) setLabel(k, v string) {
+	switch k {
+	case "md.repos":
+		if data, err := base64.StdEncoding.DecodeString(v); err == nil {
+			if err := json.Unmarshal(data, &c.Repos); err != nil {
+				slog.Warn("md", "msg", "failed to unmarshal repos label", "err", err)
 			}
-		case "md.display":
-			ct.Display = v == "1"
-		case "md.tailscale":
-			ct.Tailscale = v == "1"
-		case "md.usb":
-			ct.USB = v == "1"
+		}
+	case "md.display":
+		c.Display = v == "1"
+	case "md.displays":
+		c.Displays, _ = strconv.Atoi(v)
+	case "md.display_size":
+		c.DisplaySize = v
+	case "md.rdp":
+		c.RDP = v == "1"
+	case "md.browser":
+		c.Browser = v == "1"
+	case "md.mounts":
+		if data, err := base64.StdEncoding.DecodeString(v); err == nil {
+			if err := json.Unmarshal(data, &c.Mounts); err != nil {
+				slog.Warn("md", "msg", "failed to unmarshal mounts label", "err", err)
+			}
+		}
+	case "md.mount_src":
+		c.MountSource = SourceMount(v)
+	case "md.clone_depth":
+		c.CloneDepth, _ = strconv.Atoi(v)
+	case "md.clone_filter":
+		c.CloneFilter = v
+	case "md.shared_objects":
+		c.SharedObjects = v == "1"
+	case "md.credentials":
+		c.Credentials = parseCredentialsLabel(v)
+	case "md.credentials_scoped":
+		c.ScopedCredentials = v == "1"
+	case "md.tailscale":
+		c.Tailscale = v == "1"
+	case "md.usb":
+		c.USB = v == "1"
+	case "md.ports":
+		c.PublishedPorts = parsePortsLabel(v)
+	case "md.host_ports":
+		c.HostPorts = parseHostPortsLabel(v)
+	case "md.bind":
+		c.Bind = v
+	case "md.sudo":
+		c.Sudo = SudoPolicy(v)
+	case "md.ttl":
+		c.TTL, _ = time.ParseDuration(v)
+	case "md.cpus":
+		c.MaxCPUs, _ = strconv.Atoi(v)
+	case "md.memory":
+		c.Memory = v
+	case "md.pids_limit":
+		c.PidsLimit, _ = strconv.Atoi(v)
+	case "md.shm_size":
+		c.ShmSize = v
+	case "md.sidecars":
+		c.Sidecars = v == "1"
+	case "md.network":
+		c.Network = v
+	case "md.egress":
+		c.NetworkPolicy = parseEgressLabel(v)
+	case "md.ssh_port":
+		if p, err := strconv.ParseInt(v, 10, 32); err == nil {
+			c.SSHPort = int32(p)
 		}
 	}
-	return ct, nil
 }
 
 // tailscaleStatus is the subset of `tailscale status --json` we care about.
 type tailscaleStatus struct {
-	Self struct {
+	BackendState string `json:"BackendState"`
+	AuthURL      string `json:"AuthURL"`
+	Self         struct {
 		ID      string `json:"ID"`
 		DNSName string `json:"DNSName"`
 	} `json:"Self"`
@@ -5,9 +5,16 @@
 package md
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
 	"slices"
+	"strings"
 	"testing"
 	"time"
 )
@@ -78,6 +85,48 @@ func TestUnmarshalContainer(t *testing.T) {
 			t.Errorf("Repos[0].Branch = %q, want %q", ct.Repos[0].Branch, "main")
 		}
 	})
+	t.Run("limit_labels", func(t *testing.T) {
+		raw := `{"Names":"md-repo-main","State":"running","CreatedAt":"2025-06-15 10:30:00 +0000 UTC","Labels":"md.cpus=4,md.memory=8g,md.pids_limit=512,md.shm_size=1g"}`
+		ct, err := unmarshalContainer([]byte(raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ct.MaxCPUs != 4 || ct.Memory != "8g" || ct.PidsLimit != 512 || ct.ShmSize != "1g" {
+			t.Errorf("got MaxCPUs=%d Memory=%q PidsLimit=%d ShmSize=%q", ct.MaxCPUs, ct.Memory, ct.PidsLimit, ct.ShmSize)
+		}
+	})
+	t.Run("network_labels", func(t *testing.T) {
+		raw := `{"Names":"md-repo-main","State":"running","CreatedAt":"2025-06-15 10:30:00 +0000 UTC","Labels":"md.network=host,md.ssh_port=40022"}`
+		ct, err := unmarshalContainer([]byte(raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ct.Network != NetworkHost || ct.SSHPort != 40022 {
+			t.Errorf("got Network=%q SSHPort=%d", ct.Network, ct.SSHPort)
+		}
+	})
+	t.Run("display_labels", func(t *testing.T) {
+		raw := `{"Names":"md-repo-main","State":"running","CreatedAt":"2025-06-15 10:30:00 +0000 UTC","Labels":"md.display=1,md.displays=3,md.display_size=2560x1440,md.rdp=1,md.browser=1"}`
+		ct, err := unmarshalContainer([]byte(raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ct.Display {
+			t.Error("Display = false, want true")
+		}
+		if ct.Displays != 3 {
+			t.Errorf("Displays = %d, want 3", ct.Displays)
+		}
+		if ct.DisplaySize != "2560x1440" {
+			t.Errorf("DisplaySize = %q, want %q", ct.DisplaySize, "2560x1440")
+		}
+		if !ct.RDP {
+			t.Error("RDP = false, want true")
+		}
+		if !ct.Browser {
+			t.Error("Browser = false, want true")
+		}
+	})
 	t.Run("no_labels", func(t *testing.T) {
 		raw := `{"Names":"md-repo-main","State":"running","CreatedAt":"2025-06-15 10:30:00 +0000 UTC","Labels":""}`
 		ct, err := unmarshalContainer([]byte(raw))
@@ -155,6 +204,61 @@ func TestUnmarshalContainer(t *testing.T) {
 	})
 }
 
+func TestRepoIndex(t *testing.T) {
+	c := &Container{Name: "md-a-main", Repos: []Repo{{GitRoot: "/src/a"}, {GitRoot: "/src/lib.git"}}}
+	if i, err := c.RepoIndex("lib"); err != nil || i != 1 {
+		t.Errorf("RepoIndex(lib) = %d, %v", i, err)
+	}
+	if _, err := c.RepoIndex("b"); err == nil {
+		t.Error("expected error")
+	}
+	if err := checkRepoNames(c.Repos); err != nil {
+		t.Error(err)
+	}
+	if err := checkRepoNames([]Repo{{GitRoot: "/x/a"}, {GitRoot: "/y/a"}}); err == nil {
+		t.Error("expected error for colliding names")
+	}
+}
+
+func TestValidateDisplaySize(t *testing.T) {
+	for _, s := range []string{"1920x1080", "2560x1440", "64x64"} {
+		if err := validateDisplaySize(s); err != nil {
+			t.Errorf("validateDisplaySize(%q) = %v", s, err)
+		}
+	}
+	for _, s := range []string{"", "1920", "1920x", "x1080", "1920X1080", "axb", "10x10", "1920x1080x24", "99999x1080"} {
+		if err := validateDisplaySize(s); err == nil {
+			t.Errorf("validateDisplaySize(%q) = nil, want error", s)
+		}
+	}
+}
+
+func TestValidateDisplays(t *testing.T) {
+	if err := ValidateDisplays(1, ""); err != nil {
+		t.Error(err)
+	}
+	if err := ValidateDisplays(MaxDisplays, "2560x1440"); err != nil {
+		t.Error(err)
+	}
+	for _, tc := range []struct {
+		displays int
+		size     string
+	}{{0, ""}, {MaxDisplays + 1, ""}, {1, "1920"}} {
+		if err := ValidateDisplays(tc.displays, tc.size); err == nil {
+			t.Errorf("ValidateDisplays(%d, %q) = nil, want error", tc.displays, tc.size)
+		}
+	}
+}
+
+func TestVNCContainerPort(t *testing.T) {
+	if got := VNCContainerPort(1); got != "5901/tcp" {
+		t.Errorf("VNCContainerPort(1) = %q, want %q", got, "5901/tcp")
+	}
+	if got := VNCContainerPort(3); got != "5903/tcp" {
+		t.Errorf("VNCContainerPort(3) = %q, want %q", got, "5903/tcp")
+	}
+}
+
 func TestParseStatsLine(t *testing.T) {
 	t.Run("normal", func(t *testing.T) {
 		line := `{"Name":"md-repo-main","CPUPerc":"1.23%","MemUsage":"150MiB / 7.5GiB","MemPerc":"1.95%","PIDs":"12","NetIO":"1.5kB / 500B","BlockIO":"10MB / 2MB"}`
@@ -209,6 +313,19 @@ func TestParseStatsLine(t *testing.T) {
 	})
 }
 
+func TestParseDuTotal(t *testing.T) {
+	for out, want := range map[string]int64{
+		"4096\t/data\n8192\t/cache\n12288\ttotal\n": 12288,
+		"12\ttotal":              12,
+		"du: cannot access '/x'": -1,
+		"":                       -1,
+	} {
+		if got := parseDuTotal(out); got != want {
+			t.Errorf("parseDuTotal(%q) = %d, want %d", out, got, want)
+		}
+	}
+}
+
 func TestParseByteSize(t *testing.T) {
 	t.Run("valid", func(t *testing.T) {
 		tests := []struct {
@@ -408,3 +525,153 @@ func TestParseCreatedAt(t *testing.T) {
 		})
 	}
 }
+
+func TestSleepCtx(t *testing.T) {
+	if err := sleepCtx(t.Context(), time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	start := time.Now()
+	if err := sleepCtx(ctx, time.Hour); !errors.Is(err, context.Canceled) {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("took %s", d)
+	}
+}
+
+// fakeSSH returns a container whose ssh runs script with sh, the container
+// name as $1 and the remote command as $2.
+func fakeSSH(script string) *Container {
+	return &Container{Client: &Client{sshArgs: []string{"sh", "-c", script, "ssh"}}, Name: "md-r-main"}
+}
+
+func TestWaitForSSHCanceled(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell")
+	}
+	for name, script := range map[string]string{
+		// ssh fails fast while the container's sshd isn't up.
+		"refused": "exit 255",
+		// ssh hangs on a stuck handshake.
+		"hung": "exec sleep 30",
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			if err := waitForSSH(ctx, fakeSSH(script), time.Now().Add(time.Hour)); !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatal(err)
+			}
+			if d := time.Since(start); d > 5*time.Second {
+				t.Errorf("took %s", d)
+			}
+		})
+	}
+}
+
+func TestContainerExec(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell")
+	}
+	ctx := t.Context()
+	dir := filepath.Join(t.TempDir(), "repo")
+	for _, args := range [][]string{
+		{"init", "-q", "--initial-branch=main", dir},
+		{"-C", dir, "remote", "add", "md-r-main", "x"},
+	} {
+		if out, err := exec.CommandContext(ctx, "git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	home := t.TempDir()
+	sshConfigDir := filepath.Join(home, ".ssh", "config.d")
+	if err := os.MkdirAll(sshConfigDir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(sshConfigDir, "md-r-main.conf"), []byte("Host md-r-main\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// ssh prints its tty flag and the remote command, then runs the command
+	// without the cd into the repository, which doesn't exist here.
+	c := fakeSSH(`echo "$1 $3"; exec sh -c "${3#"cd ~/src/repo && "}"`)
+	c.Home, c.Runtime = home, "true"
+	for _, tc := range []struct {
+		name    string
+		repos   []Repo
+		command []string
+		stdin   string
+		tty     bool
+		want    string
+		code    int
+	}{
+		{
+			name:    "shell_form",
+			repos:   []Repo{{GitRoot: dir, Branch: "main"}},
+			command: []string{"echo a | tr a b"},
+			want:    "-T cd ~/src/repo && echo a | tr a b\nb\n",
+		},
+		{
+			name:    "quoted_args",
+			repos:   []Repo{{GitRoot: dir, Branch: "main"}},
+			command: []string{"echo", "a | tr a b"},
+			want:    "-T cd ~/src/repo && echo 'a | tr a b'\na | tr a b\n",
+		},
+		{
+			name:    "stdin_exit_code_tty",
+			repos:   []Repo{{GitRoot: dir, Branch: "main"}},
+			command: []string{"sh", "-c", "cat; exit 3"},
+			stdin:   "input\n",
+			tty:     true,
+			want:    "-t cd ~/src/repo && sh -c 'cat; exit 3'\ninput\n",
+			code:    3,
+		},
+		{
+			name:    "no_repo",
+			command: []string{"true"},
+			want:    "-T true\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c.Repos = tc.repos
+			var stdout, stderr strings.Builder
+			code, err := c.Exec(ctx, strings.NewReader(tc.stdin), &stdout, &stderr, tc.command, tc.tty)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if code != tc.code {
+				t.Errorf("exit code %d, want %d; stderr: %s", code, tc.code, stderr.String())
+			}
+			if got := stdout.String(); got != tc.want {
+				t.Errorf("stdout:\ngot  %q\nwant %q", got, tc.want)
+			}
+		})
+	}
+	if _, err := c.Exec(ctx, nil, nil, nil, nil, false); err == nil {
+		t.Error("expected error without command")
+	}
+}
+
+func TestCheckNewBranch(t *testing.T) {
+	ctx := t.Context()
+	dir := t.TempDir()
+	for _, args := range [][]string{
+		{"init", "-q", "--initial-branch=main"},
+		{"-c", "user.name=Test", "-c", "user.email=test@test", "commit", "-q", "--allow-empty", "-m", "init"},
+	} {
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	if err := checkNewBranch(ctx, dir, "md-r-run-1234"); err != nil {
+		t.Error(err)
+	}
+	for _, branch := range []string{"main", "a..b", "-x"} {
+		if err := checkNewBranch(ctx, dir, branch); err == nil {
+			t.Errorf("%q: expected error", branch)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Credential defines how one tool's host credentials are shared with a
+// container. Files are copied (never bind-mounted) so the container can't
+// modify the host's copy, and are owned by root with mode 0444 inside the
+// container so the agent can read but not rewrite them.
+type Credential struct {
+	// Description is a short human-readable label (e.g. "Kubernetes").
+	Description string
+	// Files are paths relative to $HOME copied into /home/user. Directories
+	// are copied recursively. Missing paths are skipped.
+	Files []string
+	// Env lists host environment variables forwarded when set.
+	Env []string
+	// scoped returns narrower or short-lived credentials generated on the
+	// host, used instead of Files when scoped sharing is requested. files
+	// maps paths relative to /home/user to content; env holds KEY=VALUE
+	// pairs.
+	scoped func(ctx context.Context) (files map[string][]byte, env []string, err error)
+}
+
+// WellKnownCredentials is the set of credentials that can be shared with a
+// container, keyed by short name.
+var WellKnownCredentials = map[string]Credential{
+	"aws": {
+		Description: "AWS CLI",
+		Files:       []string{".aws/config", ".aws/credentials"},
+		Env:         []string{"AWS_PROFILE", "AWS_REGION", "AWS_DEFAULT_REGION"},
+		scoped:      scopedAWS,
+	},
+	"azure": {
+		Description: "Azure CLI",
+		Files:       []string{".azure"},
+		Env:         []string{"AZURE_SUBSCRIPTION_ID", "AZURE_TENANT_ID"},
+	},
+	"gcloud": {
+		Description: "Google Cloud CLI",
+		Files:       []string{".config/gcloud"},
+		Env:         []string{"CLOUDSDK_CORE_PROJECT"},
+		scoped:      scopedGcloud,
+	},
+	"kube": {
+		Description: "Kubernetes",
+		Files:       []string{".kube/config"},
+		scoped:      scopedKube,
+	},
+}
+
+// credentialsLabel encodes credential names for the md.credentials label.
+// Docker prints labels comma-separated, so names are joined with '+'.
+func credentialsLabel(names []string) string {
+	s := slices.Clone(names)
+	slices.Sort(s)
+	return strings.Join(slices.Compact(s), "+")
+}
+
+// parseCredentialsLabel is the inverse of credentialsLabel.
+func parseCredentialsLabel(v string) []string {
+	if v == "" {
+		return nil
+	}
+	return strings.Split(v, "+")
+}
+
+// credentialBundle is the resolved set of credentials to inject.
+type credentialBundle struct {
+	// files maps paths relative to /home/user to content.
+	files map[string][]byte
+	env   []string
+}
+
+// collectCredentials reads or generates the credentials for names on the
+// host. It fails on unknown names, when a tool doesn't support scoped
+// sharing, or when nothing at all was found for a tool.
+func collectCredentials(ctx context.Context, home string, names []string, scoped bool) (*credentialBundle, error) {
+	b := &credentialBundle{files: map[string][]byte{}}
+	for _, name := range names {
+		cred, ok := WellKnownCredentials[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown credential %q; valid: %s", name, strings.Join(credentialNames(), ", "))
+		}
+		found := false
+		for _, k := range cred.Env {
+			if v := os.Getenv(k); v != "" {
+				b.env = append(b.env, k+"="+v)
+			}
+		}
+		if scoped {
+			if cred.scoped == nil {
+				return nil, fmt.Errorf("credential %q does not support scoped sharing", name)
+			}
+			files, env, err := cred.scoped(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("generating scoped %s credentials: %w", name, err)
+			}
+			maps.Copy(b.files, files)
+			b.env = append(b.env, env...)
+			continue
+		}
+		for _, rel := range cred.Files {
+			n, err := readCredentialFiles(filepath.Join(home, filepath.FromSlash(rel)), rel, b.files)
+			if err != nil {
+				return nil, fmt.Errorf("reading %s credentials: %w", name, err)
+			}
+			found = found || n > 0
+		}
+		if !found {
+			return nil, fmt.Errorf("no %s credentials found in ~/%s", name, strings.Join(cred.Files, ", ~/"))
+		}
+	}
+	return b, nil
+}
+
+// readCredentialFiles reads src (a file or directory) into files, keyed by
+// rel. Returns the number of files read; a missing src is not an error.
+func readCredentialFiles(src, rel string, files map[string][]byte) (int, error) {
+	fi, err := os.Stat(src)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	if !fi.IsDir() {
+		data, err := os.ReadFile(src)
+		if err != nil {
+			return 0, err
+		}
+		files[rel] = data
+		return 1, nil
+	}
+	n := 0
+	err = filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		r, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		files[path.Join(rel, filepath.ToSlash(r))] = data
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// credentialTar builds a tar archive of files for extraction in /home/user.
+// Directories are owned by user so tools can write caches next to the
+// credentials; files are owned by root and read-only.
+func credentialTar(files map[string][]byte) ([]byte, error) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	now := time.Now()
+	dirs := map[string]struct{}{}
+	names := slices.Sorted(maps.Keys(files))
+	for _, name := range names {
+		for d := path.Dir(name); d != "."; d = path.Dir(d) {
+			dirs[d] = struct{}{}
+		}
+	}
+	for _, d := range slices.Sorted(maps.Keys(dirs)) {
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: d + "/", Mode: 0o700, Uname: "user", Gname: "user", ModTime: now}); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range names {
+		data := files[name]
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0o444, Size: int64(len(data)), Uname: "root", Gname: "root", ModTime: now}); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// copyCredentials extracts the bundle's files into the container's home
+// directory as root.
+func copyCredentials(ctx context.Context, rt, name string, b *credentialBundle) error {
+	if len(b.files) == 0 {
+		return nil
+	}
+	data, err := credentialTar(b.files)
+	if err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, rt, "exec", "-i", "-u", "root", name, "tar", "-x", "-C", "/home/user")
+	cmd.Stdin = bytes.NewReader(data)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("copying credentials: %w\n%s", err, out)
+	}
+	return nil
+}
+
+func credentialNames() []string {
+	return slices.Sorted(maps.Keys(WellKnownCredentials))
+}
+
+// scopedAWS exports short-lived session credentials for the active profile.
+// Requires AWS CLI v2.
+func scopedAWS(ctx context.Context) (map[string][]byte, []string, error) {
+	out, err := runCmd(ctx, "", []string{"aws", "configure", "export-credentials", "--format", "env-no-export"})
+	if err != nil {
+		return nil, nil, err
+	}
+	var env []string
+	for line := range strings.SplitSeq(out, "\n") {
+		if k, _, ok := strings.Cut(line, "="); ok && strings.HasPrefix(k, "AWS_") {
+			env = append(env, line)
+		}
+	}
+	if len(env) == 0 {
+		return nil, nil, errors.New("aws configure export-credentials returned nothing")
+	}
+	return nil, env, nil
+}
+
+// scopedGcloud mints an OAuth access token (valid for about an hour) that
+// gcloud picks up via CLOUDSDK_AUTH_ACCESS_TOKEN. No refresh token leaves the
+// host.
+func scopedGcloud(ctx context.Context) (map[string][]byte, []string, error) {
+	token, err := runCmd(ctx, "", []string{"gcloud", "auth", "print-access-token"})
+	if err != nil {
+		return nil, nil, err
+	}
+	env := []string{"CLOUDSDK_AUTH_ACCESS_TOKEN=" + token}
+	if project, err := runCmd(ctx, "", []string{"gcloud", "config", "get-value", "project"}); err == nil && project != "" {
+		env = append(env, "CLOUDSDK_CORE_PROJECT="+project)
+	}
+	return nil, env, nil
+}
+
+// scopedKube exports only the current context, with its credentials inlined,
+// instead of every cluster in ~/.kube/config.
+func scopedKube(ctx context.Context) (map[string][]byte, []string, error) {
+	out, err := runCmd(ctx, "", []string{"kubectl", "config", "view", "--minify", "--flatten", "--raw"})
+	if err != nil {
+		return nil, nil, err
+	}
+	return map[string][]byte{".kube/config": []byte(out + "\n")}, nil, nil
+}
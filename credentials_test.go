@@ -0,0 +1,111 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestWellKnownCredentials(t *testing.T) {
+	for name, cred := range WellKnownCredentials {
+		if cred.Description == "" {
+			t.Errorf("WellKnownCredentials[%q]: Description is empty", name)
+		}
+		if len(cred.Files) == 0 {
+			t.Errorf("WellKnownCredentials[%q]: Files is empty", name)
+		}
+		for _, f := range cred.Files {
+			if filepath.IsAbs(f) || f != filepath.ToSlash(filepath.Clean(f)) {
+				t.Errorf("WellKnownCredentials[%q]: file %q must be a clean relative path", name, f)
+			}
+		}
+	}
+}
+
+func TestCredentialsLabel(t *testing.T) {
+	got := credentialsLabel([]string{"kube", "aws", "kube"})
+	if got != "aws+kube" {
+		t.Errorf("credentialsLabel() = %q, want %q", got, "aws+kube")
+	}
+	if back := parseCredentialsLabel(got); !slices.Equal(back, []string{"aws", "kube"}) {
+		t.Errorf("parseCredentialsLabel(%q) = %v", got, back)
+	}
+	if back := parseCredentialsLabel(""); back != nil {
+		t.Errorf("parseCredentialsLabel(\"\") = %v, want nil", back)
+	}
+}
+
+func TestCollectCredentials(t *testing.T) {
+	home := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(home, ".aws"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(home, ".aws", "credentials"), []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Run("files", func(t *testing.T) {
+		b, err := collectCredentials(t.Context(), home, []string{"aws"}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(b.files) != 1 || string(b.files[".aws/credentials"]) != "secret" {
+			t.Errorf("files = %v", b.files)
+		}
+	})
+	t.Run("missing", func(t *testing.T) {
+		if _, err := collectCredentials(t.Context(), home, []string{"kube"}, false); err == nil {
+			t.Fatal("expected error when no kube config exists")
+		}
+	})
+	t.Run("unknown", func(t *testing.T) {
+		if _, err := collectCredentials(t.Context(), home, []string{"nope"}, false); err == nil {
+			t.Fatal("expected error for unknown credential")
+		}
+	})
+	t.Run("scoped_unsupported", func(t *testing.T) {
+		if _, err := collectCredentials(t.Context(), home, []string{"azure"}, true); err == nil {
+			t.Fatal("expected error for credential without scoped support")
+		}
+	})
+}
+
+func TestCredentialTar(t *testing.T) {
+	data, err := credentialTar(map[string][]byte{".kube/config": []byte("k"), ".config/gcloud/a.db": []byte("g")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(bytes.NewReader(data))
+	var names []string
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, h.Name)
+		switch h.Typeflag {
+		case tar.TypeDir:
+			if h.Uname != "user" {
+				t.Errorf("%s: Uname = %q, want user", h.Name, h.Uname)
+			}
+		case tar.TypeReg:
+			if h.Uname != "root" || h.Mode != 0o444 {
+				t.Errorf("%s: Uname = %q, Mode = %o; want root, 444", h.Name, h.Uname, h.Mode)
+			}
+		}
+	}
+	want := []string{".config/", ".config/gcloud/", ".kube/", ".config/gcloud/a.db", ".kube/config"}
+	if !slices.Equal(names, want) {
+		t.Errorf("entries = %v, want %v", names, want)
+	}
+}
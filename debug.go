@@ -0,0 +1,246 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Timing is one md command run, as recorded by [RecordTiming].
+type Timing struct {
+	Time     time.Time     `json:"time"`
+	Command  string        `json:"command"`
+	Duration time.Duration `json:"duration_ns"`
+	Failed   bool          `json:"failed,omitempty"`
+}
+
+// timingsMaxSize is the size above which RecordTiming drops the oldest half
+// of the timings.
+const timingsMaxSize = 256 << 10
+
+// TimingsPath returns the file holding the timings of recent command runs.
+func TimingsPath(xdgStateHome string) string {
+	return filepath.Join(xdgStateHome, "md", "timings.jsonl")
+}
+
+// DefaultTimingsPath returns [TimingsPath] for the current user, honoring
+// $XDG_STATE_HOME.
+func DefaultTimingsPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return TimingsPath(envOr("XDG_STATE_HOME", filepath.Join(home, ".local", "state"))), nil
+}
+
+// RecordTiming appends t to the timings file at p, one JSON object per line,
+// for [Client.DebugBundle]. The file is kept under timingsMaxSize.
+func RecordTiming(p string, t Timing) error {
+	line, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if data, err := os.ReadFile(p); err == nil && len(data)+len(line) > timingsMaxSize {
+		data = data[len(data)/2:]
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			data = data[i+1:]
+		}
+		return os.WriteFile(p, append(data, line...), 0o600)
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(p, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(line)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// DebugBundleOpts configures [Client.DebugBundle].
+type DebugBundleOpts struct {
+	// Version is md's version, recorded in info.txt.
+	Version string
+	// GitRoot is the current repository, whose .md.toml is included. Empty
+	// skips it.
+	GitRoot string
+	// LogLines is the number of trailing lines of each container's output to
+	// include. Zero means 1000.
+	LogLines int
+}
+
+// DebugBundle writes a gzipped tarball for bug reports to w. It holds md's
+// version and platform, the user and repository configs, the container
+// engine's version and info, offline doctor checks, the md containers with
+// their output and SSH config, and the timings of recent command runs.
+//
+// Everything goes through [RedactSecrets]. Items that can't be collected are
+// listed in errors.txt instead of failing the bundle.
+func (c *Client) DebugBundle(ctx context.Context, w io.Writer, opts *DebugBundleOpts) error {
+	b := &debugBundle{}
+	b.add("info.txt", fmt.Sprintf("md %s\n%s/%s %s\nruntime: %s\ncreated: %s\n",
+		opts.Version, runtime.GOOS, runtime.GOARCH, runtime.Version(), c.Runtime, time.Now().UTC().Format(time.RFC3339)))
+	b.addFile("config.toml", ConfigPath(c.XDGConfigHome))
+	if opts.GitRoot != "" {
+		b.addFile("repo"+RepoConfigFile, filepath.Join(opts.GitRoot, RepoConfigFile))
+	}
+	var engine strings.Builder
+	for _, sub := range []string{"version", "info"} {
+		out, err := runCmd(ctx, "", []string{c.Runtime, sub})
+		engine.WriteString("$ " + c.Runtime + " " + sub + "\n" + out + "\n")
+		if err != nil {
+			engine.WriteString(err.Error() + "\n")
+		}
+		engine.WriteString("\n")
+	}
+	b.add("engine.txt", engine.String())
+	if checks, err := json.MarshalIndent(c.Doctor(ctx, &DoctorOpts{GitRoot: opts.GitRoot, Offline: true}), "", "  "); err == nil {
+		b.add("doctor.json", string(checks)+"\n")
+	}
+	b.addFile("timings.jsonl", TimingsPath(c.XDGStateHome))
+
+	containers, err := c.List(ctx)
+	if err != nil {
+		b.fail("containers", err)
+	}
+	type entry struct {
+		Name    string            `json:"name"`
+		State   string            `json:"state"`
+		Created time.Time         `json:"created"`
+		Repos   []string          `json:"repos,omitempty"`
+		Labels  map[string]string `json:"labels,omitempty"`
+	}
+	entries := make([]entry, 0, len(containers))
+	lines := opts.LogLines
+	if lines <= 0 {
+		lines = 1000
+	}
+	for _, ct := range containers {
+		e := entry{Name: ct.Name, State: ct.State, Created: ct.CreatedAt}
+		for _, r := range ct.Repos {
+			e.Repos = append(e.Repos, r.Name()+"@"+r.Branch)
+		}
+		if info, err := inspectContainer(ctx, c.Runtime, ct.Name); err == nil {
+			e.Labels = info.Config.Labels
+		}
+		entries = append(entries, e)
+		var out bytes.Buffer
+		if err := ct.Logs(ctx, &out, &out, &LogsOpts{Lines: lines}); err != nil {
+			b.fail("logs of "+ct.Name, err)
+		}
+		b.add("logs/"+ct.Name+".log", out.String())
+		b.addFile("ssh/"+ct.Name+".conf", filepath.Join(c.Home, ".ssh", "config.d", ct.Name+".conf"))
+	}
+	if data, err := json.MarshalIndent(entries, "", "  "); err == nil {
+		b.add("containers.json", string(data)+"\n")
+	}
+	if len(b.errs) > 0 {
+		b.add("errors.txt", strings.Join(b.errs, "\n")+"\n")
+	}
+	return b.write(w)
+}
+
+// debugBundle accumulates the files of [Client.DebugBundle].
+type debugBundle struct {
+	names    []string
+	contents map[string]string
+	errs     []string
+}
+
+func (b *debugBundle) add(name, content string) {
+	if b.contents == nil {
+		b.contents = map[string]string{}
+	}
+	b.names = append(b.names, name)
+	b.contents[name] = RedactSecrets(content)
+}
+
+// addFile adds the host file p, if it exists.
+func (b *debugBundle) addFile(name, p string) {
+	data, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		b.fail(name, err)
+		return
+	}
+	b.add(name, string(data))
+}
+
+func (b *debugBundle) fail(what string, err error) {
+	b.errs = append(b.errs, what+": "+err.Error())
+}
+
+func (b *debugBundle) write(w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	for _, name := range b.names {
+		content := b.contents[name]
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "md-debug/" + name, Mode: 0o600, Size: int64(len(content)), ModTime: now}); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(tw, content); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+var (
+	// reSecretAssign matches "key = value" and "key: value" where the key
+	// names a secret, e.g. api_key in config.toml or GITHUB_TOKEN in an
+	// environment dump.
+	reSecretAssign = regexp.MustCompile(`(?i)([\w.-]*(?:token|secret|password|passwd|api[_-]?key|private[_-]?key)[\w.-]*"?\s*[=:]\s*)("[^"\n]*"|'[^'\n]*'|[^\s,\]]+)`)
+	// reSecretToken matches well-known credential formats wherever they
+	// appear.
+	reSecretToken = regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{20,}|github_pat_\w{20,}|glpat-[\w-]{20,}|sk-[\w-]{20,}|xox[abpr]-[\w-]+|tskey-[\w-]+)`)
+	// reURL matches URLs. Webhook URLs carry their secret in the path.
+	reURL = regexp.MustCompile(`\bhttps?://[^\s"'<>\]]+`)
+)
+
+// RedactSecrets replaces credentials in s with REDACTED: values of keys
+// naming a secret, well-known token formats, and the user info, path and
+// query of URLs.
+func RedactSecrets(s string) string {
+	s = reURL.ReplaceAllStringFunc(s, func(u string) string {
+		scheme, rest, _ := strings.Cut(u, "://")
+		host, tail := rest, ""
+		if i := strings.IndexAny(rest, "/?#"); i >= 0 {
+			host, tail = rest[:i], rest[i:]
+		}
+		if i := strings.LastIndexByte(host, '@'); i >= 0 {
+			host = "REDACTED@" + host[i+1:]
+		}
+		if tail != "" && tail != "/" {
+			tail = "/REDACTED"
+		}
+		return scheme + "://" + host + tail
+	})
+	s = reSecretAssign.ReplaceAllString(s, `${1}"REDACTED"`)
+	return reSecretToken.ReplaceAllString(s, "REDACTED")
+}
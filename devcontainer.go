@@ -0,0 +1,371 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// DevContainerFiles are the paths, relative to the repository root, where a
+// devcontainer.json is looked up, in order.
+var DevContainerFiles = []string{".devcontainer/devcontainer.json", ".devcontainer.json"}
+
+// DevContainer is the subset of a devcontainer.json
+// (https://containers.dev/implementors/json_reference/) md honors.
+type DevContainer struct {
+	// Path is the devcontainer.json file.
+	Path string `json:"-"`
+	// Image is used as the base image when it is built on md's image. Other
+	// images can't run md containers and are ignored.
+	Image string `json:"image"`
+	// Build is a Dockerfile applied on top of md's image: the base image of
+	// its final (or target) stage is replaced, like [BakeFile].
+	Build *DevContainerBuild `json:"build"`
+	// ForwardPorts are published like [StartOpts.PublishPorts]. Entries are
+	// ports or "host:port" strings; ports of other hosts are skipped.
+	ForwardPorts []json.RawMessage `json:"forwardPorts"`
+	// ContainerEnv and RemoteEnv are added to ~/.env.
+	ContainerEnv map[string]string `json:"containerEnv"`
+	RemoteEnv    map[string]string `json:"remoteEnv"`
+	// PostCreateCommand runs once in the primary repository after the
+	// container started: a shell command line, an argv array, or an object
+	// of named commands of either form.
+	PostCreateCommand json.RawMessage `json:"postCreateCommand"`
+	// DockerFile and Context are the deprecated top-level forms of Build.
+	DockerFile string `json:"dockerFile"`
+	Context    string `json:"context"`
+
+	gitRoot string
+}
+
+// DevContainerBuild is the "build" property of a devcontainer.json. Paths are
+// relative to the devcontainer.json file.
+type DevContainerBuild struct {
+	Dockerfile string            `json:"dockerfile"`
+	Context    string            `json:"context"`
+	Args       map[string]string `json:"args"`
+	Target     string            `json:"target"`
+}
+
+// LoadDevContainer reads the devcontainer.json of the repository at gitRoot.
+// It returns nil without error when there is none.
+func LoadDevContainer(gitRoot string) (*DevContainer, error) {
+	for _, name := range DevContainerFiles {
+		p := filepath.Join(gitRoot, filepath.FromSlash(name))
+		raw, err := os.ReadFile(p)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		d := &DevContainer{Path: p, gitRoot: gitRoot}
+		dec := json.NewDecoder(bytes.NewReader(stripJSONC(raw)))
+		if err := dec.Decode(d); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", name, err)
+		}
+		if d.DockerFile != "" && d.Build == nil {
+			d.Build = &DevContainerBuild{Dockerfile: d.DockerFile, Context: d.Context}
+		}
+		if d.Build != nil && d.Build.Dockerfile == "" {
+			d.Build = nil
+		}
+		if d.Image != "" && d.Build != nil {
+			return nil, fmt.Errorf("%s: image and build.dockerfile are mutually exclusive", name)
+		}
+		return d, nil
+	}
+	return nil, nil
+}
+
+// Ports returns the forwarded ports md can publish, and the entries it
+// skipped because they are invalid or belong to another host.
+func (d *DevContainer) Ports() ([]PortMapping, []string) {
+	var ports []PortMapping
+	var skipped []string
+	for _, raw := range d.ForwardPorts {
+		var n uint16
+		if err := json.Unmarshal(raw, &n); err == nil && n != 0 {
+			ports = append(ports, PortMapping{Host: n, Container: n})
+			continue
+		}
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil {
+			host, port, ok := strings.Cut(s, ":")
+			if !ok {
+				port = host
+			}
+			if !ok || host == "localhost" || host == "127.0.0.1" {
+				if p, err := ParsePortMapping(port); err == nil {
+					ports = append(ports, p)
+					continue
+				}
+			}
+		}
+		skipped = append(skipped, string(raw))
+	}
+	return ports, skipped
+}
+
+// Env returns containerEnv and remoteEnv as sorted KEY=VALUE pairs for
+// [StartOpts.ExtraEnv], with ${localEnv:...} and workspace variables
+// substituted.
+func (d *DevContainer) Env() []string {
+	m := maps.Clone(d.ContainerEnv)
+	if m == nil {
+		m = map[string]string{}
+	}
+	maps.Copy(m, d.RemoteEnv)
+	out := make([]string, 0, len(m))
+	for _, k := range slices.Sorted(maps.Keys(m)) {
+		out = append(out, k+"="+strings.ReplaceAll(d.expand(m[k]), "\n", " "))
+	}
+	return out
+}
+
+// PostCreate returns the postCreateCommand as shell command lines.
+func (d *DevContainer) PostCreate() ([]string, error) {
+	if len(d.PostCreateCommand) == 0 || string(d.PostCreateCommand) == "null" {
+		return nil, nil
+	}
+	var named map[string]json.RawMessage
+	if err := json.Unmarshal(d.PostCreateCommand, &named); err == nil {
+		var out []string
+		for _, k := range slices.Sorted(maps.Keys(named)) {
+			cmd, err := devContainerCommand(named[k])
+			if err != nil {
+				return nil, fmt.Errorf("postCreateCommand %q: %w", k, err)
+			}
+			out = append(out, cmd)
+		}
+		return out, nil
+	}
+	cmd, err := devContainerCommand(d.PostCreateCommand)
+	if err != nil {
+		return nil, fmt.Errorf("postCreateCommand: %w", err)
+	}
+	return []string{cmd}, nil
+}
+
+// devContainerCommand converts a lifecycle command, a string run by the
+// shell or an argv array, into a shell command line.
+func devContainerCommand(raw json.RawMessage) (string, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, nil
+	}
+	var argv []string
+	if err := json.Unmarshal(raw, &argv); err != nil || len(argv) == 0 {
+		return "", errors.New("must be a string or a non-empty array of strings")
+	}
+	quoted := make([]string, len(argv))
+	for i, a := range argv {
+		quoted[i] = shellQuote(a)
+	}
+	return strings.Join(quoted, " "), nil
+}
+
+var devContainerVarRe = regexp.MustCompile(`\$\{([A-Za-z]+)(?::([^}:]*)(?::([^}]*))?)?\}`)
+
+// expand substitutes the devcontainer.json variables md can resolve.
+func (d *DevContainer) expand(s string) string {
+	return devContainerVarRe.ReplaceAllStringFunc(s, func(m string) string {
+		g := devContainerVarRe.FindStringSubmatch(m)
+		switch g[1] {
+		case "localEnv":
+			if v, ok := os.LookupEnv(g[2]); ok {
+				return v
+			}
+			return g[3]
+		case "localWorkspaceFolder":
+			return d.gitRoot
+		case "localWorkspaceFolderBasename", "containerWorkspaceFolderBasename":
+			return filepath.Base(d.gitRoot)
+		case "containerWorkspaceFolder":
+			return "/home/user/src/" + filepath.Base(d.gitRoot)
+		default:
+			return m
+		}
+	})
+}
+
+// isMDImage reports whether image is built on md's base image, pulling it
+// when it is not available locally.
+func (c *Client) isMDImage(ctx context.Context, image string) bool {
+	info, err := inspectImage(ctx, c.Runtime, image)
+	if err != nil {
+		if _, err := runCmd(ctx, "", []string{c.Runtime, "pull", "-q", image}); err != nil {
+			return false
+		}
+		if info, err = inspectImage(ctx, c.Runtime, image); err != nil {
+			return false
+		}
+	}
+	_, ok := info.Config.Labels["md.context_sha"]
+	return ok
+}
+
+// ensureDevContainerImage builds the devcontainer's Dockerfile on top of
+// imageName and returns the resulting image, or imageName when it declares
+// none. The build always runs so changes in its context are picked up; the
+// runtime's layer cache keeps it fast.
+func (c *Container) ensureDevContainerImage(ctx context.Context, stdout, stderr io.Writer, imageName string, d *DevContainer, quiet bool) (string, error) {
+	if d == nil || d.Build == nil {
+		return imageName, nil
+	}
+	dir := filepath.Dir(d.Path)
+	src, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(d.Build.Dockerfile)))
+	if err != nil {
+		return "", fmt.Errorf("devcontainer build: %w", err)
+	}
+	df, err := rebaseDockerfile(string(src), imageName, d.Build.Target)
+	if err != nil {
+		return "", fmt.Errorf("devcontainer build %s: %w", d.Build.Dockerfile, err)
+	}
+	base, err := inspectImage(ctx, c.Runtime, imageName)
+	if err != nil {
+		return "", fmt.Errorf("inspecting %s: %w", imageName, err)
+	}
+	h := sha256.Sum256([]byte(base.ID + "\x00" + d.Path))
+	tag := "md-devcontainer-" + hex.EncodeToString(h[:16])
+	if !quiet {
+		_, _ = fmt.Fprintf(stdout, "- Applying %s on top of the image ...\n", d.Build.Dockerfile)
+	}
+	tmp, err := os.MkdirTemp("", "md-devcontainer-")
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = os.RemoveAll(tmp) }()
+	dfPath := filepath.Join(tmp, "Dockerfile")
+	if err := os.WriteFile(dfPath, []byte(df+"\nUSER root\n"), 0o644); err != nil {
+		return "", err
+	}
+	args := append([]string{c.Runtime, "build", "--label", buildLabel, "-f", dfPath, "-t", tag}, c.Proxy.buildArgs()...)
+	for _, k := range slices.Sorted(maps.Keys(d.Build.Args)) {
+		args = append(args, "--build-arg", k+"="+d.expand(d.Build.Args[k]))
+	}
+	if d.Build.Target != "" {
+		args = append(args, "--target", d.Build.Target)
+	}
+	if quiet {
+		args = append(args, "-q")
+	}
+	buildCtx := filepath.Join(dir, filepath.FromSlash(cmp.Or(d.Build.Context, ".")))
+	if err := runBuildCmd(ctx, append(args, buildCtx), stdout, stderr); err != nil {
+		return "", fmt.Errorf("applying %s: %w", d.Build.Dockerfile, err)
+	}
+	return tag, nil
+}
+
+// postCreate runs the devcontainer's postCreateCommand in the primary
+// repository. Failures are reported but don't fail the start, like the
+// devcontainer CLI which leaves the container running.
+func (c *Container) postCreate(ctx context.Context, stdout, stderr io.Writer, d *DevContainer) {
+	cmds, err := d.PostCreate()
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "- Skipping %v\n", err)
+		return
+	}
+	for _, cmd := range cmds {
+		_, _ = fmt.Fprintf(stdout, "- Running postCreateCommand: %s\n", cmd)
+		code, err := c.Exec(ctx, nil, stdout, stderr, []string{cmd}, false)
+		if err == nil && code != 0 {
+			err = fmt.Errorf("exit code %d", code)
+		}
+		if err != nil {
+			_, _ = fmt.Fprintf(stderr, "- postCreateCommand failed: %v\n", err)
+			return
+		}
+	}
+}
+
+var fromRe = regexp.MustCompile(`(?i)^(\s*FROM\s+(?:--\S+\s+)*)(\S+)(.*)$`)
+
+// rebaseDockerfile replaces the base image of the final stage of df, or of
+// the stage named target, with image. Earlier stages are left alone so COPY
+// --from keeps working.
+func rebaseDockerfile(df, image, target string) (string, error) {
+	lines := strings.Split(df, "\n")
+	at := -1
+	for i, l := range lines {
+		m := fromRe.FindStringSubmatch(l)
+		if m == nil {
+			continue
+		}
+		if target == "" {
+			at = i
+		} else if f := strings.Fields(m[3]); len(f) == 2 && strings.EqualFold(f[0], "as") && f[1] == target {
+			at = i
+		}
+	}
+	if at < 0 {
+		if target != "" {
+			return "", fmt.Errorf("no stage named %s", strconv.Quote(target))
+		}
+		return "", errors.New("no FROM instruction")
+	}
+	m := fromRe.FindStringSubmatch(lines[at])
+	lines[at] = m[1] + image + m[3]
+	return strings.Join(lines, "\n"), nil
+}
+
+// stripJSONC removes the comments and trailing commas devcontainer.json
+// allows, so it can be decoded as JSON.
+func stripJSONC(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		switch c := b[i]; {
+		case c == '"':
+			// Copy the string verbatim, including escapes.
+			j := i + 1
+			for ; j < len(b) && b[j] != '"'; j++ {
+				if b[j] == '\\' {
+					j++
+				}
+			}
+			out = append(out, b[i:min(j+1, len(b))]...)
+			i = j
+		case c == '/' && i+1 < len(b) && b[i+1] == '/':
+			for i < len(b) && b[i] != '\n' {
+				i++
+			}
+			i--
+		case c == '/' && i+1 < len(b) && b[i+1] == '*':
+			end := bytes.Index(b[i+2:], []byte("*/"))
+			if end < 0 {
+				return out
+			}
+			i += end + 3
+		case c == ']' || c == '}':
+			// Drop a trailing comma before the closing bracket.
+			k := len(out) - 1
+			for k >= 0 && (out[k] == ' ' || out[k] == '\t' || out[k] == '\n' || out[k] == '\r') {
+				k--
+			}
+			if k >= 0 && out[k] == ',' {
+				out = append(out[:k], out[k+1:]...)
+			}
+			out = append(out, c)
+		default:
+			out = append(out, c)
+		}
+	}
+	return out
+}
@@ -0,0 +1,82 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestLoadDevContainer(t *testing.T) {
+	root := t.TempDir()
+	if d, err := LoadDevContainer(root); d != nil || err != nil {
+		t.Fatalf("got %+v, %v", d, err)
+	}
+	dir := filepath.Join(root, ".devcontainer")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	const src = `{
+	// The build.
+	"build": {"dockerfile": "Dockerfile", "args": {"V": "${localEnv:MD_TEST_DEVCONTAINER:1.2}"},},
+	/* Ports: "8080" is in a comment. */
+	"forwardPorts": [3000, "8080:9000", "db:5432", "localhost:5000"],
+	"containerEnv": {"URL": "http://x//y", "WS": "${containerWorkspaceFolder}"},
+	"remoteEnv": {"A": "b"},
+	"postCreateCommand": {"npm": "npm ci", "go": ["go", "mod", "download"]},
+}`
+	if err := os.WriteFile(filepath.Join(dir, "devcontainer.json"), []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := LoadDevContainer(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Build == nil || d.Build.Dockerfile != "Dockerfile" || d.expand(d.Build.Args["V"]) != "1.2" {
+		t.Errorf("Build = %+v", d.Build)
+	}
+	ports, skipped := d.Ports()
+	if want := []PortMapping{{3000, 3000}, {5000, 5000}}; !slices.Equal(ports, want) {
+		t.Errorf("Ports() = %+v", ports)
+	}
+	if want := []string{`"8080:9000"`, `"db:5432"`}; !slices.Equal(skipped, want) {
+		t.Errorf("skipped = %q", skipped)
+	}
+	want := []string{"A=b", "URL=http://x//y", "WS=/home/user/src/" + filepath.Base(root)}
+	if got := d.Env(); !slices.Equal(got, want) {
+		t.Errorf("Env() = %q", got)
+	}
+	cmds, err := d.PostCreate()
+	if err != nil || !slices.Equal(cmds, []string{"go mod download", "npm ci"}) {
+		t.Errorf("PostCreate() = %q, %v", cmds, err)
+	}
+}
+
+func TestRebaseDockerfile(t *testing.T) {
+	const df = "ARG V=1\nFROM golang:1.25 AS build\nRUN go build\nFROM --platform=linux/amd64 mcr.microsoft.com/devcontainers/base AS dev\nCOPY --from=build /x /x\n"
+	got, err := rebaseDockerfile(df, "md-specialized-1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "ARG V=1\nFROM golang:1.25 AS build\nRUN go build\nFROM --platform=linux/amd64 md-specialized-1 AS dev\nCOPY --from=build /x /x\n"
+	if got != want {
+		t.Errorf("got %q", got)
+	}
+	got, err = rebaseDockerfile(df, "md", "build")
+	if err != nil || got[:len("ARG V=1\nFROM md AS build\n")] != "ARG V=1\nFROM md AS build\n" {
+		t.Errorf("target: got %q, %v", got, err)
+	}
+	for _, target := range []string{"", "missing"} {
+		src := df
+		if target == "" {
+			src = "RUN true\n"
+		}
+		if _, err := rebaseDockerfile(src, "md", target); err == nil {
+			t.Errorf("%q: expected error", target)
+		}
+	}
+}
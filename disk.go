@@ -0,0 +1,273 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// FilesystemUsage is the space and inode usage seen from inside a container.
+type FilesystemUsage struct {
+	// Used and Avail are the bytes used and available on the filesystem
+	// holding the container's writable layer, usually the engine's storage
+	// shared by all containers.
+	Used  int64 `json:"used"`
+	Avail int64 `json:"avail"`
+	// Inodes and InodesUsed count the inodes of that filesystem. Inodes is
+	// zero for filesystems allocating them dynamically, like btrfs.
+	Inodes     int64 `json:"inodes"`
+	InodesUsed int64 `json:"inodes_used"`
+	// Src and SrcInodes are the bytes and inodes used by ~/src (-1 if
+	// unavailable).
+	Src       int64 `json:"src"`
+	SrcInodes int64 `json:"src_inodes"`
+}
+
+// UsedPercent returns the percentage of the space used, like df.
+func (u *FilesystemUsage) UsedPercent() float64 {
+	if u.Used+u.Avail <= 0 {
+		return 0
+	}
+	return 100 * float64(u.Used) / float64(u.Used+u.Avail)
+}
+
+// InodesPercent returns the percentage of the inodes used.
+func (u *FilesystemUsage) InodesPercent() float64 {
+	if u.Inodes <= 0 {
+		return 0
+	}
+	return 100 * float64(u.InodesUsed) / float64(u.Inodes)
+}
+
+// DiskThresholds are the disk usage levels md warns about. Zero disables a
+// check.
+type DiskThresholds struct {
+	// UsedPercent and InodesPercent are the fill levels of the filesystem
+	// holding the writable layer.
+	UsedPercent   float64
+	InodesPercent float64
+	// Layer is the size of the container's writable layer, in bytes.
+	Layer int64
+	// Src is the size of ~/src, in bytes.
+	Src int64
+}
+
+// DefaultDiskThresholds are used when [DiskConfig] doesn't set them.
+var DefaultDiskThresholds = DiskThresholds{UsedPercent: 90, InodesPercent: 90}
+
+// DiskWarning is a disk usage threshold a container crossed.
+type DiskWarning struct {
+	// Kind is "space", "inodes", "layer" or "src".
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+// Check returns the thresholds u and the writable layer size (-1 if
+// unknown) crossed.
+func (t *DiskThresholds) Check(u *FilesystemUsage, layer int64) []DiskWarning {
+	var w []DiskWarning
+	if p := u.UsedPercent(); t.UsedPercent > 0 && p >= t.UsedPercent {
+		w = append(w, DiskWarning{"space", fmt.Sprintf("disk %.0f%% full, %s left", p, FormatBytes(u.Avail))})
+	}
+	if p := u.InodesPercent(); t.InodesPercent > 0 && p >= t.InodesPercent {
+		w = append(w, DiskWarning{"inodes", fmt.Sprintf("%.0f%% of inodes used, %s left", p, formatCount(u.Inodes-u.InodesUsed))})
+	}
+	if t.Layer > 0 && layer >= t.Layer {
+		w = append(w, DiskWarning{"layer", fmt.Sprintf("writable layer is %s, over %s", FormatBytes(layer), FormatBytes(t.Layer))})
+	}
+	if t.Src > 0 && u.Src >= t.Src {
+		w = append(w, DiskWarning{"src", fmt.Sprintf("~/src is %s, over %s", FormatBytes(u.Src), FormatBytes(t.Src))})
+	}
+	return w
+}
+
+// fsUsageScript prints the usage of the root filesystem and ~/src for
+// parseFilesystemUsage. It runs as the user over ssh or as root with exec, so
+// it names ~/src by its absolute path.
+const fsUsageScript = `echo "space $(df -Pk / | tail -n 1)"; ` +
+	`echo "inodes $(df -Pi / | tail -n 1)"; ` +
+	`echo "src $(du -sxk /home/user/src 2>/dev/null | cut -f1) $(du -sx --inodes /home/user/src 2>/dev/null | cut -f1)"`
+
+// parseFilesystemUsage parses the output of fsUsageScript.
+func parseFilesystemUsage(out string) (*FilesystemUsage, error) {
+	u := &FilesystemUsage{Src: -1, SrcInodes: -1}
+	seen := 0
+	for line := range strings.SplitSeq(out, "\n") {
+		k, v, _ := strings.Cut(line, " ")
+		f := strings.Fields(v)
+		switch k {
+		case "space":
+			// Filesystem 1024-blocks Used Available Capacity Mounted-on
+			if len(f) < 4 {
+				return nil, fmt.Errorf("unexpected df output %q", line)
+			}
+			used, err1 := strconv.ParseInt(f[2], 10, 64)
+			avail, err2 := strconv.ParseInt(f[3], 10, 64)
+			if err := errors.Join(err1, err2); err != nil {
+				return nil, fmt.Errorf("unexpected df output %q: %w", line, err)
+			}
+			u.Used, u.Avail = used*1024, avail*1024
+			seen++
+		case "inodes":
+			// Filesystem Inodes IUsed IFree IUse% Mounted-on; some
+			// filesystems report "-".
+			if len(f) >= 3 {
+				u.Inodes, _ = strconv.ParseInt(f[1], 10, 64)
+				u.InodesUsed, _ = strconv.ParseInt(f[2], 10, 64)
+			}
+		case "src":
+			if len(f) >= 1 {
+				if n, err := strconv.ParseInt(f[0], 10, 64); err == nil {
+					u.Src = n * 1024
+				}
+			}
+			if len(f) >= 2 {
+				if n, err := strconv.ParseInt(f[1], 10, 64); err == nil {
+					u.SrcInodes = n
+				}
+			}
+		}
+	}
+	if seen == 0 {
+		return nil, fmt.Errorf("unexpected df output %q", out)
+	}
+	return u, nil
+}
+
+// FilesystemUsage measures the disk usage from inside the running container.
+func (c *Container) FilesystemUsage(ctx context.Context) (*FilesystemUsage, error) {
+	out, err := runCmdRetry(ctx, "", c.SSHCommand(c.Name, fsUsageScript))
+	if err != nil {
+		return nil, fmt.Errorf("measuring disk usage of %s: %w", c.Name, err)
+	}
+	return parseFilesystemUsage(out)
+}
+
+// filesystemUsage measures the disk usage from inside the running container
+// name with the engine, or returns nil.
+func filesystemUsage(ctx context.Context, rt, name string) *FilesystemUsage {
+	out, err := runCmd(ctx, "", []string{rt, "exec", name, "sh", "-c", fsUsageScript})
+	if err != nil {
+		return nil
+	}
+	u, _ := parseFilesystemUsage(out)
+	return u
+}
+
+// diskWarnings returns the thresholds the running container crossed, with
+// its disk usage. Errors are not fatal: the image may lack df.
+func (c *Container) diskWarnings(ctx context.Context) (*FilesystemUsage, []DiskWarning) {
+	u, err := c.FilesystemUsage(ctx)
+	if err != nil {
+		return nil, nil
+	}
+	layer := int64(-1)
+	if c.Kube == nil {
+		layer, _ = c.DiskUsage(ctx)
+	}
+	t := c.Config.DiskThresholds()
+	return u, t.Check(u, layer)
+}
+
+// DiskAlert is a running container over a disk usage threshold.
+type DiskAlert struct {
+	Container *Container
+	Warnings  []DiskWarning
+	// New is set when a warning's kind wasn't reported by the previous
+	// [Client.CheckDisk], so notifications aren't repeated.
+	New bool
+}
+
+// CheckDisk measures the disk usage of the running containers and returns
+// those over a threshold. The kinds of warnings reported are recorded in
+// $XDG_STATE_HOME/md/disk_alerts.json to set [DiskAlert.New]; dryRun leaves
+// the record alone.
+func (c *Client) CheckDisk(ctx context.Context, dryRun bool) ([]DiskAlert, error) {
+	containers, err := c.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(c.XDGStateHome, "md", "disk_alerts.json")
+	prev := map[string][]string{}
+	if b, err := os.ReadFile(path); err == nil {
+		_ = json.Unmarshal(b, &prev)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var alerts []DiskAlert
+	for _, ct := range containers {
+		if ct.State != "running" {
+			continue
+		}
+		wg.Go(func() {
+			if _, w := ct.diskWarnings(ctx); len(w) > 0 {
+				mu.Lock()
+				alerts = append(alerts, DiskAlert{Container: ct, Warnings: w})
+				mu.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+	slices.SortFunc(alerts, func(a, b DiskAlert) int { return strings.Compare(a.Container.Name, b.Container.Name) })
+	next := markNewAlerts(alerts, prev)
+	if dryRun {
+		return alerts, nil
+	}
+	b, err := json.Marshal(next)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	return alerts, os.WriteFile(path, b, 0o600)
+}
+
+// markNewAlerts sets New on the alerts with a kind of warning prev didn't
+// record for their container, and returns the record of alerts.
+func markNewAlerts(alerts []DiskAlert, prev map[string][]string) map[string][]string {
+	next := make(map[string][]string, len(alerts))
+	for i := range alerts {
+		a := &alerts[i]
+		for _, w := range a.Warnings {
+			next[a.Container.Name] = append(next[a.Container.Name], w.Kind)
+			if !slices.Contains(prev[a.Container.Name], w.Kind) {
+				a.New = true
+			}
+		}
+	}
+	return next
+}
+
+// parseSize returns the number of bytes of a size accepted by
+// [ValidateSize], with binary units like the engines use.
+func parseSize(s string) (int64, error) {
+	if !reSize.MatchString(s) {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	num := strings.TrimRight(s, "bB")
+	mult := int64(1)
+	if i := strings.IndexAny(num, "kKmMgGtT"); i >= 0 {
+		mult = int64(1) << (10 * (strings.IndexByte("kmgt", strings.ToLower(num[i:])[0]) + 1))
+		num = num[:i]
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, err
+	}
+	return int64(f * float64(mult)), nil
+}
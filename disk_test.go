@@ -0,0 +1,90 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseFilesystemUsage(t *testing.T) {
+	out := "space overlay 1000 900 100 90% /\n" +
+		"inodes overlay 2000 1500 500 75% /\n" +
+		"src 300 42"
+	got, err := parseFilesystemUsage(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := FilesystemUsage{Used: 900 * 1024, Avail: 100 * 1024, Inodes: 2000, InodesUsed: 1500, Src: 300 * 1024, SrcInodes: 42}
+	if *got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	// btrfs reports no inodes and busybox du has no --inodes.
+	got, err = parseFilesystemUsage("space btrfs 1000 10 990 1% /\ninodes btrfs 0 0 0 - /\nsrc 3 ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.InodesPercent() != 0 || got.Src != 3*1024 || got.SrcInodes != -1 {
+		t.Errorf("got %+v", got)
+	}
+	if _, err := parseFilesystemUsage("sh: df: not found"); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestDiskThresholdsCheck(t *testing.T) {
+	u := &FilesystemUsage{Used: 95, Avail: 5, Inodes: 100, InodesUsed: 50, Src: 3 << 30}
+	for _, tc := range []struct {
+		name  string
+		t     DiskThresholds
+		layer int64
+		want  []string
+	}{
+		{"defaults", DefaultDiskThresholds, -1, []string{"space"}},
+		{"disabled", DiskThresholds{}, 100 << 30, nil},
+		{"all", DiskThresholds{UsedPercent: 95, InodesPercent: 50, Layer: 1 << 30, Src: 2 << 30}, 1 << 30, []string{"space", "inodes", "layer", "src"}},
+		{"unknown layer", DiskThresholds{Layer: 1}, -1, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []string
+			for _, w := range tc.t.Check(u, tc.layer) {
+				got = append(got, w.Kind)
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for in, want := range map[string]int64{"512": 512, "2k": 2048, "1.5m": 3 << 19, "8g": 8 << 30, "1TB": 1 << 40} {
+		if got, err := parseSize(in); err != nil || got != want {
+			t.Errorf("parseSize(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	if _, err := parseSize("8 gigs"); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestMarkNewAlerts(t *testing.T) {
+	alerts := []DiskAlert{
+		{Container: &Container{Name: "md-a-main"}, Warnings: []DiskWarning{{Kind: "space"}}},
+		{Container: &Container{Name: "md-b-main"}, Warnings: []DiskWarning{{Kind: "space"}, {Kind: "src"}}},
+		{Container: &Container{Name: "md-c-main"}, Warnings: []DiskWarning{{Kind: "inodes"}}},
+	}
+	// md-d-main recovered, so it is dropped from the record.
+	prev := map[string][]string{"md-a-main": {"space"}, "md-b-main": {"space"}, "md-d-main": {"space"}}
+	next := markNewAlerts(alerts, prev)
+	for i, want := range []bool{false, true, true} {
+		if alerts[i].New != want {
+			t.Errorf("%s: New = %t", alerts[i].Container.Name, alerts[i].New)
+		}
+	}
+	if len(next) != 3 || !slices.Equal(next["md-b-main"], []string{"space", "src"}) {
+		t.Errorf("got %v", next)
+	}
+}
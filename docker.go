@@ -22,6 +22,7 @@ import (
 	"os/exec"
 	"path"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"sort"
 	"strconv"
@@ -37,6 +38,34 @@ func DefaultMaxCPUs() int {
 	return max(2, runtime.NumCPU()-2)
 }
 
+// Limits caps the host resources used by a container started by
+// [Container.Run]. The fields are those of [StartOpts].
+type Limits struct {
+	MaxCPUs   int
+	Memory    string
+	PidsLimit int
+	ShmSize   string
+}
+
+func (l Limits) apply(opts *StartOpts) {
+	opts.MaxCPUs = l.MaxCPUs
+	opts.Memory = l.Memory
+	opts.PidsLimit = l.PidsLimit
+	opts.ShmSize = l.ShmSize
+}
+
+var reSize = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?[kKmMgGtT]?[bB]?$`)
+
+// ValidateSize checks that s is a size accepted by the container runtime
+// for --memory and --shm-size, such as "512m" or "8g". name names the
+// setting in the error.
+func ValidateSize(name, s string) error {
+	if !reSize.MatchString(s) {
+		return fmt.Errorf("invalid %s %q: use a number of bytes with an optional unit, e.g. 512m or 8g", name, s)
+	}
+	return nil
+}
+
 //go:embed all:rsc
 var rscFS embed.FS
 
@@ -98,6 +127,29 @@ func prepareRootBuildContext() (string, error) {
 	return extractEmbeddedTree("rsc/root", "md-build-root-*")
 }
 
+// contextTreeSHA computes a deterministic SHA-256 hash over the files of the
+// build context rooted at root in fsys, walked like extractEmbeddedTree. It
+// identifies the context a base image was built from (md.context_sha).
+func contextTreeSHA(fsys fs.FS, root string) (string, error) {
+	h := sha256.New()
+	err := fs.WalkDir(fsys, root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(h, "%s\x00%d\x00", strings.TrimPrefix(p, root+"/"), len(data))
+		_, _ = h.Write(data)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
 // keysSHA computes a deterministic SHA-256 hash over the SSH key files in
 // keysDir. This is used to detect when SSH keys change and trigger an image
 // rebuild.
@@ -114,16 +166,118 @@ func keysSHA(keysDir string) (string, error) {
 	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
-func dockerInspectFormat(ctx context.Context, rt, name, format string) (string, error) {
-	return runCmd(ctx, "", []string{rt, "image", "inspect", name, "--format", format})
+// imageInfo is the part of `docker image inspect` output md uses. Decoding
+// the JSON document instead of using --format templates gives the same
+// result on docker and podman and distinguishes a missing label from an
+// error.
+type imageInfo struct {
+	ID          string    `json:"Id"`
+	RepoTags    []string  `json:"RepoTags"`
+	RepoDigests []string  `json:"RepoDigests"`
+	Created     time.Time `json:"Created"`
+	Size        int64     `json:"Size"`
+	Config      struct {
+		Labels map[string]string `json:"Labels"`
+	} `json:"Config"`
+}
+
+// digest returns the registry digest of the image, or its ID for images that
+// were never pushed or pulled.
+func (i *imageInfo) digest() string {
+	if len(i.RepoDigests) > 0 && i.RepoDigests[0] != "" {
+		return i.RepoDigests[0]
+	}
+	return i.ID
+}
+
+// containerInfo is the part of `docker inspect` output md uses for a
+// container.
+type containerInfo struct {
+	Name    string    `json:"Name"`
+	Created time.Time `json:"Created"`
+	// Image is the ID of the image the container was created from, which
+	// stays when its tag moves to a rebuilt image.
+	Image string `json:"Image"`
+	// SizeRw is only set by inspect --size.
+	SizeRw *int64 `json:"SizeRw"`
+	Config struct {
+		Image  string            `json:"Image"`
+		Labels map[string]string `json:"Labels"`
+		Env    []string          `json:"Env"`
+	} `json:"Config"`
+	Mounts []struct {
+		Type        string `json:"Type"`
+		Destination string `json:"Destination"`
+	} `json:"Mounts"`
+	NetworkSettings struct {
+		Ports map[string][]portBinding `json:"Ports"`
+	} `json:"NetworkSettings"`
+	State struct {
+		Status    string `json:"Status"`
+		Running   bool   `json:"Running"`
+		OOMKilled bool   `json:"OOMKilled"`
+		ExitCode  int    `json:"ExitCode"`
+		// Error is the engine's, e.g. when the entrypoint can't be started.
+		Error      string    `json:"Error"`
+		FinishedAt time.Time `json:"FinishedAt"`
+	} `json:"State"`
+}
+
+// portBinding is where a container port is published on the host.
+type portBinding struct {
+	HostIP   string `json:"HostIp"`
+	HostPort string `json:"HostPort"`
+}
+
+// volumeDestinations returns where engine volumes are mounted in the
+// container.
+func (i *containerInfo) volumeDestinations() []string {
+	var dests []string
+	for _, m := range i.Mounts {
+		if m.Type == "volume" {
+			dests = append(dests, m.Destination)
+		}
+	}
+	return dests
+}
+
+// inspectImage returns the local image name's metadata.
+func inspectImage(ctx context.Context, rt, name string) (*imageInfo, error) {
+	out, err := runCmdRetry(ctx, "", []string{rt, "image", "inspect", name})
+	if err != nil {
+		return nil, err
+	}
+	return decodeInspect[imageInfo](out, name)
+}
+
+// inspectContainer returns the container name's metadata.
+func inspectContainer(ctx context.Context, rt, name string) (*containerInfo, error) {
+	out, err := runCmdRetry(ctx, "", []string{rt, "container", "inspect", name})
+	if err != nil {
+		return nil, err
+	}
+	return decodeInspect[containerInfo](out, name)
+}
+
+// decodeInspect decodes the single element of the JSON array printed by
+// docker inspect.
+func decodeInspect[T any](out, name string) (*T, error) {
+	var v []T
+	if err := json.Unmarshal([]byte(out), &v); err != nil {
+		return nil, fmt.Errorf("parsing inspect output for %s: %w", name, err)
+	}
+	if len(v) != 1 {
+		return nil, fmt.Errorf("inspecting %s: got %d objects", name, len(v))
+	}
+	return &v[0], nil
 }
 
 func getImageVersionLabel(ctx context.Context, rt, imageName string) string {
-	out, err := dockerInspectFormat(ctx, rt, imageName, `{{index .Config.Labels "org.opencontainers.image.version"}}`)
-	if err != nil || out == "" || out == "<no value>" {
+	info, err := inspectImage(ctx, rt, imageName)
+	if err != nil {
 		return ""
 	}
-	return out
+	return info.Config.Labels["org.opencontainers.image.version"]
 }
 
 // getRemoteManifestDigest queries the registry for the per-architecture
@@ -145,9 +299,11 @@ func getImageVersionLabel(ctx context.Context, rt, imageName string) string {
 // Both Docker schema v2 manifest lists and OCI image indexes share the same
 // "manifests[].{digest, platform}" JSON structure, so one parser covers both
 // runtimes and both formats.
-func getRemoteManifestDigest(ctx context.Context, rt, image, arch string) (string, error) {
+func getRemoteManifestDigest(ctx context.Context, rt, image, arch string, proxy *ProxyConfig) (string, error) {
 	slog.DebugContext(ctx, "md", "msg", "fetching remote manifest digest", "image", image, "arch", arch)
-	out, err := runCmd(ctx, "", []string{rt, "manifest", "inspect", image})
+	// The engine's CLI queries the registry itself, through the proxy of
+	// its environment.
+	out, err := runCmdEnvRetry(ctx, "", []string{rt, "manifest", "inspect", image}, proxy.Env())
 	if err != nil {
 		return "", err
 	}
@@ -199,7 +355,8 @@ type imageBuildCacheEntry struct {
 	baseImage  string
 	contextSHA string
 	cacheKey   string
-	needed     bool
+	noPull     bool
+	reason     *RebuildReason
 }
 
 // cachedRemoteManifestDigest returns the remote per-architecture manifest digest.
@@ -207,7 +364,7 @@ type imageBuildCacheEntry struct {
 // to skip repeated registry round-trips. When zero, the registry is always queried.
 func (c *Client) cachedRemoteManifestDigest(ctx context.Context, rt, image, arch string) (string, error) {
 	if c.DigestCacheTTL == 0 {
-		return getRemoteManifestDigest(ctx, rt, image, arch)
+		return getRemoteManifestDigest(ctx, rt, image, arch, &c.Proxy)
 	}
 	key := rt + "\x00" + image + "\x00" + arch
 	c.mu.Lock()
@@ -216,7 +373,7 @@ func (c *Client) cachedRemoteManifestDigest(ctx context.Context, rt, image, arch
 		return e.digest, e.err
 	}
 	c.mu.Unlock()
-	digest, err := getRemoteManifestDigest(ctx, rt, image, arch)
+	digest, err := getRemoteManifestDigest(ctx, rt, image, arch, &c.Proxy)
 	c.mu.Lock()
 	c.digestCache[key] = remoteDigestEntry{digest: digest, err: err, expires: time.Now().Add(c.DigestCacheTTL)}
 	c.mu.Unlock()
@@ -264,18 +421,53 @@ func cacheSpecKey(caches []CacheMount) string {
 	return hex.EncodeToString(h[:8])
 }
 
-// imageBuildNeeded reports whether the specialized Docker image needs to be
-// rebuilt. It checks the base image digest, SSH keys hash, and cache spec
-// key against labels on the existing image. For remote base images it also
-// verifies the local copy matches the registry.
+// Reasons to rebuild the user image, in [RebuildReason.Code].
+const (
+	// RebuildNoImage is a user image that doesn't exist or can't be
+	// inspected.
+	RebuildNoImage = "no_image"
+	// RebuildNoLabels is a user image lacking md's labels, e.g. built by an
+	// older md.
+	RebuildNoLabels = "no_labels"
+	// RebuildNoBase is a base image that can't be inspected.
+	RebuildNoBase = "no_base"
+	// RebuildBaseChanged is a base image that changed locally, e.g. pulled.
+	RebuildBaseChanged = "base_changed"
+	// RebuildRemoteNewer is a base image updated in the registry.
+	RebuildRemoteNewer = "remote_newer"
+	// RebuildKeysChanged is a change in the SSH keys baked into the image.
+	RebuildKeysChanged = "keys_changed"
+	// RebuildCachesChanged is a change in the set of caches copied into the
+	// image, or in the sudo policy.
+	RebuildCachesChanged = "caches_changed"
+	// RebuildRequested is a rebuild asked for with [StartOpts.Rebuild].
+	RebuildRequested = "requested"
+)
+
+// RebuildReason is why the user image is rebuilt.
+type RebuildReason struct {
+	// Code is one of the Rebuild* constants.
+	Code string `json:"code"`
+	// Detail completes "Rebuilding because", e.g. "the SSH keys changed".
+	Detail string `json:"detail"`
+}
+
+func (r *RebuildReason) String() string {
+	return r.Detail
+}
+
+// imageBuildNeeded returns why the specialized Docker image needs to be
+// rebuilt, nil when it is up to date. It checks the base image digest, SSH
+// keys hash, and cache spec key against labels on the existing image. For
+// remote base images it also verifies the local copy matches the registry.
 // home is used to resolve "~/" in cache HostPaths so only caches whose host
 // directory currently exists are compared (matching what resolveCaches
-// would actually inject).
-func (c *Client) imageBuildNeeded(ctx context.Context, rt, imageName, baseImage, keysDir, home string, caches []CacheMount) bool {
+// would actually inject). With noPull, the registry isn't checked.
+func (c *Client) imageBuildNeeded(ctx context.Context, rt, imageName, baseImage, keysDir, home string, caches []CacheMount, sudo SudoPolicy, noPull bool) *RebuildReason {
 	// Compute cheap inputs first so we can check the cache.
 	contextSHA, err := keysSHA(keysDir)
 	if err != nil {
-		return true
+		return &RebuildReason{RebuildKeysChanged, "the SSH keys can't be read: " + err.Error()}
 	}
 	var activeCaches []CacheMount
 	for _, cm := range caches {
@@ -283,28 +475,32 @@ func (c *Client) imageBuildNeeded(ctx context.Context, rt, imageName, baseImage,
 			activeCaches = append(activeCaches, cm)
 		}
 	}
-	activeKey := cacheSpecKey(activeCaches)
+	activeKey := sudoImageKey(cacheSpecKey(activeCaches), sudo)
 
 	// Check cached result from a previous call with the same inputs.
 	c.mu.Lock()
-	if e := c.imageBuildCache; e != nil && e.baseImage == baseImage && e.contextSHA == contextSHA && e.cacheKey == activeKey {
-		needed := e.needed
+	if e := c.imageBuildCache; e != nil && e.baseImage == baseImage && e.contextSHA == contextSHA && e.cacheKey == activeKey && e.noPull == noPull {
+		reason := e.reason
 		c.mu.Unlock()
-		return needed
+		return reason
 	}
 	c.mu.Unlock()
 
-	needed := c.imageBuildNeededSlow(ctx, rt, imageName, baseImage, contextSHA, activeKey)
+	reason := c.imageBuildNeededSlow(ctx, rt, imageName, baseImage, contextSHA, activeKey, noPull)
+	if reason != nil {
+		slog.InfoContext(ctx, "md", "msg", "image build needed", "image", imageName, "reason", reason.Code, "detail", reason.Detail)
+	}
 
 	c.mu.Lock()
 	c.imageBuildCache = &imageBuildCacheEntry{
 		baseImage:  baseImage,
 		contextSHA: contextSHA,
 		cacheKey:   activeKey,
-		needed:     needed,
+		noPull:     noPull,
+		reason:     reason,
 	}
 	c.mu.Unlock()
-	return needed
+	return reason
 }
 
 // invalidateImageBuildCache clears the cached imageBuildNeeded result.
@@ -316,33 +512,36 @@ func (c *Client) invalidateImageBuildCache() {
 }
 
 // imageBuildNeededSlow performs the full check with docker inspect calls.
-func (c *Client) imageBuildNeededSlow(ctx context.Context, rt, imageName, baseImage, contextSHA, activeKey string) bool {
+func (c *Client) imageBuildNeededSlow(ctx context.Context, rt, imageName, baseImage, contextSHA, activeKey string, noPull bool) *RebuildReason {
 	slog.DebugContext(ctx, "md", "msg", "checking if image build needed", "image", imageName, "base", baseImage)
 	// Quick check: does the specialized image have labels at all?
-	currentDigest, err := dockerInspectFormat(ctx, rt, imageName, `{{index .Config.Labels "md.base_digest"}}`)
-	if err != nil || currentDigest == "" || currentDigest == "<no value>" {
+	info, err := inspectImage(ctx, rt, imageName)
+	if err != nil {
+		slog.DebugContext(ctx, "md", "msg", "build needed: cannot inspect image", "image", imageName, "err", err)
+		return &RebuildReason{RebuildNoImage, "the image " + imageName + " doesn't exist"}
+	}
+	labels := info.Config.Labels
+	currentDigest := labels["md.base_digest"]
+	if currentDigest == "" {
 		slog.DebugContext(ctx, "md", "msg", "build needed: no base_digest label", "image", imageName)
-		return true
+		return &RebuildReason{RebuildNoLabels, "the image has no md.base_digest label"}
 	}
-	currentContext, err := dockerInspectFormat(ctx, rt, imageName, `{{index .Config.Labels "md.context_sha"}}`)
-	if err != nil || currentContext == "" || currentContext == "<no value>" {
+	currentContext := labels["md.context_sha"]
+	if currentContext == "" {
 		slog.DebugContext(ctx, "md", "msg", "build needed: no context_sha label", "image", imageName)
-		return true
+		return &RebuildReason{RebuildNoLabels, "the image has no md.context_sha label"}
 	}
 
 	// Get the base image digest.
-	var baseDigest string
-	if d, err := dockerInspectFormat(ctx, rt, baseImage, "{{index .RepoDigests 0}}"); err == nil && d != "" {
-		baseDigest = d
-	} else if id, err := dockerInspectFormat(ctx, rt, baseImage, "{{.Id}}"); err == nil {
-		baseDigest = id
-	} else {
+	base, err := inspectImage(ctx, rt, baseImage)
+	if err != nil {
 		slog.DebugContext(ctx, "md", "msg", "build needed: cannot get base image digest", "base", baseImage)
-		return true
+		return &RebuildReason{RebuildNoBase, "the base image " + baseImage + " isn't available locally"}
 	}
+	baseDigest := base.digest()
 	if currentDigest != baseDigest {
 		slog.DebugContext(ctx, "md", "msg", "build needed: base digest changed", "current", currentDigest, "base", baseDigest)
-		return true
+		return &RebuildReason{RebuildBaseChanged, "the base image " + baseImage + " changed"}
 	}
 
 	// For remote images, verify the local base is up to date with the registry.
@@ -352,35 +551,31 @@ func (c *Client) imageBuildNeededSlow(ctx context.Context, rt, imageName, baseIm
 	// RepoDigests[0] (manifest list digest) against the per-platform entry.
 	// Errors are intentionally ignored: a registry failure is not a reason to rebuild;
 	// the base digest label comparison above already catches locally-pulled updates.
+	// Offline or with noPull, the local copy is used as is.
 	isLocal := !strings.Contains(baseImage, "/")
-	if !isLocal {
+	if !isLocal && !noPull {
 		slog.DebugContext(ctx, "md", "msg", "checking remote manifest digest", "base", baseImage)
-		storedManifest, err := dockerInspectFormat(ctx, rt, imageName, `{{index .Config.Labels "md.base_manifest_digest"}}`)
-		if err == nil && storedManifest != "" && storedManifest != "<no value>" {
+		if storedManifest := labels["md.base_manifest_digest"]; storedManifest != "" && !c.registryOffline(ctx, baseImage) {
 			remoteDigest, err := c.cachedRemoteManifestDigest(ctx, rt, baseImage, runtime.GOARCH)
 			if err == nil && remoteDigest != storedManifest {
 				slog.DebugContext(ctx, "md", "msg", "build needed: remote manifest changed", "stored", storedManifest, "remote", remoteDigest)
-				return true
+				return &RebuildReason{RebuildRemoteNewer, "a newer " + baseImage + " is in the registry"}
 			}
 		}
 	}
 
 	if currentContext != contextSHA {
 		slog.DebugContext(ctx, "md", "msg", "build needed: context SHA changed", "current", currentContext, "expected", contextSHA)
-		return true
+		return &RebuildReason{RebuildKeysChanged, "the SSH keys changed"}
 	}
 
-	currentKey, err := dockerInspectFormat(ctx, rt, imageName, `{{index .Config.Labels "md.cache_key"}}`)
-	if err != nil || currentKey == "<no value>" {
-		currentKey = ""
-	}
-	if activeKey != currentKey {
-		slog.DebugContext(ctx, "md", "msg", "build needed: cache key changed", "current", currentKey, "expected", activeKey)
-		return true
+	if currentKey := labels["md.cache_key"]; activeKey != currentKey {
+		slog.DebugContext(ctx, "md", "msg", "build needed: cache key changed", "current", labels["md.cache_key"], "expected", activeKey)
+		return &RebuildReason{RebuildCachesChanged, "the caches or the sudo policy changed"}
 	}
 
 	slog.DebugContext(ctx, "md", "msg", "image is up to date", "image", imageName)
-	return false
+	return nil
 }
 
 // resolveCaches determines which caches have existing host directories and
@@ -442,12 +637,15 @@ func resolveCaches(caches []CacheMount, home string, mountPaths []string) (activ
 }
 
 // generateDockerfile produces the Dockerfile content for a specialized image.
-func generateDockerfile(baseImage string, active []activeCM, dirs []string, baseDigest, contextSHA, activeKey, manifestDigest string) string {
+func generateDockerfile(baseImage string, active []activeCM, dirs []string, sudo SudoPolicy, baseDigest, contextSHA, activeKey, manifestDigest string) string {
 	var df strings.Builder
 	fmt.Fprintf(&df, "FROM %s\n", baseImage)
 	df.WriteString("COPY --chown=root:root ssh_host_ed25519_key /etc/ssh/ssh_host_ed25519_key\n")
 	df.WriteString("COPY --chown=root:root ssh_host_ed25519_key.pub /etc/ssh/ssh_host_ed25519_key.pub\n")
 	df.WriteString("COPY --chown=user:user authorized_keys /home/user/.ssh/authorized_keys\n")
+	if sudo != SudoDefault {
+		fmt.Fprintf(&df, "COPY --chown=root:root sudoers %s\n", sudoersPath)
+	}
 	for _, a := range active {
 		if a.files != nil {
 			// Shallow: copy only top-level files, skip subdirectories.
@@ -465,6 +663,9 @@ func generateDockerfile(baseImage string, active []activeCM, dirs []string, base
 	run.WriteString("chmod 0600 /etc/ssh/ssh_host_ed25519_key")
 	run.WriteString(" && chmod 0644 /etc/ssh/ssh_host_ed25519_key.pub")
 	run.WriteString(" && chmod 0400 /home/user/.ssh/authorized_keys")
+	if sudo != SudoDefault {
+		fmt.Fprintf(&run, " && chmod 0440 %s", sudoersPath)
+	}
 	if len(dirs) > 0 {
 		quoted := make([]string, len(dirs))
 		for i, d := range dirs {
@@ -513,23 +714,41 @@ func generateDockerfile(baseImage string, active []activeCM, dirs []string, base
 //
 // keysDir contains SSH host keys and authorized_keys. home resolves "~/" in
 // cache HostPaths. mountPaths lists container-side -v mount targets to
-// pre-create with user ownership.
-func buildSpecializedImage(ctx context.Context, stdout, stderr io.Writer, rt, keysDir, imageName, baseImage, home string, caches []CacheMount, mountPaths []string, quiet bool) error {
+// pre-create with user ownership. sudo is written as a sudoers fragment.
+// offline uses the local copy of a remote baseImage instead of pulling it.
+func buildSpecializedImage(ctx context.Context, stdout, stderr io.Writer, rt, keysDir, imageName, baseImage, home string, caches []CacheMount, sudo SudoPolicy, mountPaths []string, proxy *ProxyConfig, offline, noPull, quiet bool) error {
 	slog.DebugContext(ctx, "md", "msg", "building specialized image", "image", imageName, "base", baseImage)
 	arch := runtime.GOARCH
 	// Local-only images (no "/" in name) are never pulled from a registry.
 	// A tag (":latest") does not imply a registry; only a "/" does.
 	isLocal := !strings.Contains(baseImage, "/")
+	// pulled is set when the base image was checked against the registry.
+	pulled := false
 	if isLocal {
-		if _, err := runCmd(ctx, "", []string{rt, "image", "inspect", "--format", "{{.Id}}", baseImage}); err != nil {
+		if _, err := inspectImage(ctx, rt, baseImage); err != nil {
 			return fmt.Errorf("local image %s not found; build it first with 'md build-image'", baseImage)
 		}
 		if !quiet {
 			_, _ = fmt.Fprintf(stdout, "- Using local base image %s.\n", baseImage)
 		}
+	} else if offline {
+		if _, err := inspectImage(ctx, rt, baseImage); err != nil {
+			return fmt.Errorf("offline and base image %s isn't available locally; pull it once online", baseImage)
+		}
+		if !quiet {
+			_, _ = fmt.Fprintf(stdout, "- Offline: using the local copy of base image %s without checking for updates.\n", baseImage)
+		}
+	} else if _, err := inspectImage(ctx, rt, baseImage); noPull && err == nil {
+		if !quiet {
+			_, _ = fmt.Fprintf(stdout, "- Using the local copy of base image %s without checking for updates.\n", baseImage)
+		}
 	} else {
+		pulled = true
 		// Compare the local image ID before and after pull to detect changes.
-		idBefore, _ := runCmd(ctx, "", []string{rt, "image", "inspect", "--format", "{{.Id}}", baseImage})
+		var idBefore string
+		if info, err := inspectImage(ctx, rt, baseImage); err == nil {
+			idBefore = info.ID
+		}
 		if !quiet {
 			_, _ = fmt.Fprintf(stdout, "- Pulling base image %s ...\n", baseImage)
 		}
@@ -542,9 +761,12 @@ func buildSpecializedImage(ctx context.Context, stdout, stderr io.Writer, rt, ke
 				return fmt.Errorf("pulling base image: %w", err)
 			}
 		}
-		idAfter, _ := runCmd(ctx, "", []string{rt, "image", "inspect", "--format", "{{.Id}}", baseImage})
+		after, err := inspectImage(ctx, rt, baseImage)
+		if err != nil {
+			return fmt.Errorf("inspecting base image: %w", err)
+		}
 		if !quiet {
-			if idBefore != "" && idBefore == idAfter {
+			if idBefore != "" && idBefore == after.ID {
 				_, _ = fmt.Fprintf(stdout, "  Base image is up to date.\n")
 			} else if v := getImageVersionLabel(ctx, rt, baseImage); strings.HasPrefix(v, "v") {
 				_, _ = fmt.Fprintf(stdout, "  Version: %s\n", v)
@@ -554,13 +776,14 @@ func buildSpecializedImage(ctx context.Context, stdout, stderr io.Writer, rt, ke
 
 	slog.DebugContext(ctx, "md", "msg", "pull complete, fetching base image digest")
 	// Get base image digest for label.
-	baseDigest, err := runCmd(ctx, "", []string{rt, "image", "inspect", "--format", "{{index .RepoDigests 0}}", baseImage})
-	if err != nil || baseDigest == "" {
-		baseDigest, _ = runCmd(ctx, "", []string{rt, "image", "inspect", "--format", "{{.Id}}", baseImage})
+	base, err := inspectImage(ctx, rt, baseImage)
+	if err != nil {
+		return fmt.Errorf("inspecting base image: %w", err)
 	}
+	baseDigest := base.digest()
 	var manifestDigest string
-	if !isLocal {
-		manifestDigest, _ = getRemoteManifestDigest(ctx, rt, baseImage, arch)
+	if pulled {
+		manifestDigest, _ = getRemoteManifestDigest(ctx, rt, baseImage, arch, proxy)
 	}
 
 	contextSHA, err := keysSHA(keysDir)
@@ -569,6 +792,7 @@ func buildSpecializedImage(ctx context.Context, stdout, stderr io.Writer, rt, ke
 	}
 
 	active, dirs, activeKey := resolveCaches(caches, home, mountPaths)
+	activeKey = sudoImageKey(activeKey, sudo)
 
 	if !quiet {
 		_, _ = fmt.Fprintf(stdout, "- Building container image %s from %s ...\n", imageName, baseImage)
@@ -619,7 +843,13 @@ func buildSpecializedImage(ctx context.Context, stdout, stderr io.Writer, rt, ke
 		}
 	}
 
-	df := generateDockerfile(baseImage, active, dirs, baseDigest, contextSHA, activeKey, manifestDigest)
+	if f := sudoersFragment(sudo); f != "" {
+		if err := os.WriteFile(filepath.Join(tmpDir, "sudoers"), []byte(f), 0o600); err != nil {
+			return fmt.Errorf("staging sudoers: %w", err)
+		}
+	}
+
+	df := generateDockerfile(baseImage, active, dirs, sudo, baseDigest, contextSHA, activeKey, manifestDigest)
 	slog.DebugContext(ctx, "md", "msg", "generated Dockerfile", "content", df)
 
 	if err := os.WriteFile(filepath.Join(tmpDir, "Dockerfile"), []byte(df), 0o644); err != nil {
@@ -629,10 +859,11 @@ func buildSpecializedImage(ctx context.Context, stdout, stderr io.Writer, rt, ke
 	// Build the image. --no-cache forces all layers to rebuild (prevents stale
 	// results). We omit --pull so BuildKit won't re-pull the base (we already
 	// pulled above).
-	buildCmd := []string{rt, "build", "--no-cache", "--platform", "linux/" + arch, "-t", imageName}
+	buildCmd := []string{rt, "build", "--no-cache", "--label", buildLabel, "--platform", "linux/" + arch, "-t", imageName}
 	for _, a := range active {
 		buildCmd = append(buildCmd, "--build-context", fmt.Sprintf("cache-%s=%s", a.cm.Name, a.hostPath))
 	}
+	buildCmd = append(buildCmd, proxy.buildArgs()...)
 	buildCmd = append(buildCmd, tmpDir)
 
 	if quiet {
@@ -752,25 +983,123 @@ func launchContainer(ctx context.Context, stdout, stderr io.Writer, c *Container
 	if len(c.Repos) > 1000 {
 		return fmt.Errorf("too many repositories: %d (max 1000)", len(c.Repos))
 	}
+	if err := checkBind(opts.Bind); err != nil {
+		return err
+	}
 	rt := c.Runtime
+	bind := publishIP(opts.Bind)
 	var dockerArgs []string
-	dockerArgs = append(dockerArgs, rt, "run", "-d",
-		"--name", c.Name, "--hostname", c.Name,
-		"-p", "127.0.0.1::22")
+	dockerArgs = append(dockerArgs, rt, "run", "-d", "--name", c.Name)
+	switch opts.NetworkMode {
+	case NetworkNone:
+		c.SSHPort = 0
+		dockerArgs = append(dockerArgs, "--hostname", c.Name)
+	case NetworkHost:
+		// The engine refuses --hostname with the host's network.
+		port, err := freeLocalPort()
+		if err != nil {
+			return fmt.Errorf("picking the SSH port: %w", err)
+		}
+		c.SSHPort = port
+		p := strconv.Itoa(int(port))
+		dockerArgs = append(dockerArgs, "-e", "MD_SSH_PORT="+p, "--label", "md.ssh_port="+p)
+	default:
+		dockerArgs = append(dockerArgs, "--hostname", c.Name, "-p", bind+"::22")
+	}
+	if opts.Bind != "" {
+		dockerArgs = append(dockerArgs, "--label", "md.bind="+opts.Bind)
+	}
+	if opts.Sudo != SudoDefault {
+		dockerArgs = append(dockerArgs, "--label", "md.sudo="+string(opts.Sudo))
+	}
 
 	if opts.MaxCPUs > 0 {
-		dockerArgs = append(dockerArgs, "--cpus", strconv.Itoa(opts.MaxCPUs))
+		dockerArgs = append(dockerArgs, "--cpus", strconv.Itoa(opts.MaxCPUs), "--label", "md.cpus="+strconv.Itoa(opts.MaxCPUs))
+	}
+	if opts.Memory != "" {
+		if err := ValidateSize("memory limit", opts.Memory); err != nil {
+			return err
+		}
+		dockerArgs = append(dockerArgs, "--memory", opts.Memory, "--label", "md.memory="+opts.Memory)
+	}
+	if opts.PidsLimit < 0 {
+		return fmt.Errorf("invalid pids limit %d", opts.PidsLimit)
+	}
+	if opts.PidsLimit > 0 {
+		dockerArgs = append(dockerArgs, "--pids-limit", strconv.Itoa(opts.PidsLimit), "--label", "md.pids_limit="+strconv.Itoa(opts.PidsLimit))
+	}
+	if opts.ShmSize != "" {
+		if err := ValidateSize("shm size", opts.ShmSize); err != nil {
+			return err
+		}
+		dockerArgs = append(dockerArgs, "--shm-size", opts.ShmSize, "--label", "md.shm_size="+opts.ShmSize)
 	}
 
+	displays := max(1, opts.Displays)
+	if opts.RDP && !opts.Display {
+		return errors.New("RDP requires the virtual display to be enabled")
+	}
 	if opts.Display {
-		dockerArgs = append(dockerArgs, "-p", "127.0.0.1::5901", "-e", "MD_DISPLAY=1")
+		if err := ValidateDisplays(displays, opts.DisplaySize); err != nil {
+			return err
+		}
+		for i := 1; i <= displays; i++ {
+			dockerArgs = append(dockerArgs, "-p", bind+"::"+strconv.Itoa(5900+i))
+		}
+		// The displays wait for the password, sent once the container runs
+		// (sendVNCPassword): in the environment, it would show in inspect and
+		// in every process of the container.
+		if err := c.writeVNCPassword(newVNCPassword()); err != nil {
+			return err
+		}
+		dockerArgs = append(dockerArgs, "-e", "MD_DISPLAY=1", "-e", "MD_VNC_AUTH=1")
+		if displays > 1 {
+			dockerArgs = append(dockerArgs, "-e", "MD_DISPLAYS="+strconv.Itoa(displays))
+		}
+		if opts.DisplaySize != "" {
+			dockerArgs = append(dockerArgs, "-e", "MD_DISPLAY_SIZE="+opts.DisplaySize)
+		}
+		if opts.RDP {
+			dockerArgs = append(dockerArgs, "-p", bind+"::3389", "-e", "MD_RDP=1")
+		}
+	}
+
+	if opts.Browser {
+		dockerArgs = append(dockerArgs, "-p", bind+"::9222", "-e", "MD_BROWSER=1")
+	}
+	if err := checkPublishPorts(opts.PublishPorts); err != nil {
+		return err
+	}
+	for _, p := range opts.PublishPorts {
+		dockerArgs = append(dockerArgs, "-p", fmt.Sprintf("%s:%d:%d", bind, p.Host, p.Container))
+	}
+	if len(opts.PublishPorts) > 0 {
+		dockerArgs = append(dockerArgs, "--label", "md.ports="+portsLabel(opts.PublishPorts))
+	}
+	if err := checkHostPorts(opts); err != nil {
+		return err
+	}
+	if len(opts.HostPorts) > 0 {
+		// With the host's network, the container reaches its services on
+		// 127.0.0.1 already.
+		if opts.NetworkMode != NetworkHost {
+			dockerArgs = append(dockerArgs, "--add-host", HostGateway+":host-gateway")
+		}
+		dockerArgs = append(dockerArgs, "--label", "md.host_ports="+hostPortsLabel(opts.HostPorts))
 	}
 
-	if kvmAvailable() {
+	// Host paths and devices would be looked up on the remote machine.
+	remote := c.RemoteHost != ""
+	if remote {
+		if err := checkRemoteOpts(opts); err != nil {
+			return err
+		}
+	}
+	if kvmAvailable() && !remote {
 		dockerArgs = append(dockerArgs, "--device=/dev/kvm")
 	}
 	// Localtime.
-	if runtime.GOOS == "linux" {
+	if runtime.GOOS == "linux" && !remote {
 		dockerArgs = append(dockerArgs, "-v", "/etc/localtime:/etc/localtime:ro")
 	}
 	// Sandbox capabilities.
@@ -782,22 +1111,9 @@ func launchContainer(ctx context.Context, stdout, stderr io.Writer, c *Container
 	dockerArgs = append(dockerArgs,
 		"--cap-add=SYS_PTRACE",
 		"--security-opt", "seccomp=unconfined")
-	// - apparmor=unconfined: disables AppArmor's mandatory-access-control
-	//   profile so Chrome can create namespaces and sandboxed processes can
-	//   access /proc. Docker-only; podman uses SELinux and passing this
-	//   option can hang on kernel security filesystem access.
-	if rt != "podman" {
-		dockerArgs = append(dockerArgs, "--security-opt", "apparmor=unconfined")
-	}
-
-	// Rootless podman: --userns=keep-id maps host UID to same UID inside the
-	// container so bind-mounted configs are writable. --user 0:0 keeps
-	// start.sh running as root for privileged setup (groupmod, sshd, dbus).
-	// Rootless Docker is handled inside start.sh via /proc/self/uid_map
-	// detection since Docker lacks --userns=keep-id.
-	if isRootlessPodman(rt) {
-		dockerArgs = append(dockerArgs, "--userns=keep-id", "--user", "0:0")
-	}
+	// - The engine's own: AppArmor for docker, the user namespace of rootless
+	//   podman.
+	dockerArgs = append(dockerArgs, c.Engine().runArgs()...)
 
 	// Tailscale.
 	if opts.Tailscale {
@@ -824,8 +1140,12 @@ func launchContainer(ctx context.Context, stdout, stderr io.Writer, c *Container
 			"--device-cgroup-rule=c 189:* rwm")
 	}
 
-	// Agent config mounts: always-mounted paths plus caller-specified harness paths.
-	combined := mergePaths(opts.AgentPaths)
+	// Agent config mounts: always-mounted paths plus caller-specified harness
+	// paths. They are on this machine, out of a remote engine's reach.
+	var combined AgentPaths
+	if !remote {
+		combined = mergePaths(opts.AgentPaths)
+	}
 	home := c.Home
 	xdgConfig := c.XDGConfigHome
 	xdgData := c.XDGDataHome
@@ -847,6 +1167,47 @@ func launchContainer(ctx context.Context, stdout, stderr io.Writer, c *Container
 		dockerArgs = append(dockerArgs, "-v", filepath.Join(xdgState, p)+":/home/user/.local/state/"+p)
 	}
 
+	// User bind mounts.
+	mounts, err := validateBindMounts(opts.Mounts, home)
+	if err != nil {
+		return err
+	}
+	for _, m := range mounts {
+		dockerArgs = append(dockerArgs, "-v", m.String())
+	}
+
+	// Host checkouts mounted in place of the clones.
+	if opts.MountSource != SourceClone {
+		for _, r := range c.Repos {
+			args, err := sourceMountArgs(rt, opts.MountSource, r.GitRoot, "/home/user/src/"+r.Name(), filepath.Join(c.overlayDir(), r.Name()))
+			if err != nil {
+				return err
+			}
+			dockerArgs = append(dockerArgs, args...)
+		}
+		dockerArgs = append(dockerArgs, "--label", "md.mount_src="+string(opts.MountSource))
+	}
+	if err := checkCloneOpts(opts); err != nil {
+		return err
+	}
+	if opts.CloneDepth > 0 {
+		dockerArgs = append(dockerArgs, "--label", "md.clone_depth="+strconv.Itoa(opts.CloneDepth))
+	}
+	if opts.CloneFilter != "" {
+		dockerArgs = append(dockerArgs, "--label", "md.clone_filter="+opts.CloneFilter)
+	}
+	if err := checkSharedObjects(opts); err != nil {
+		return err
+	}
+	if opts.SharedObjects {
+		args, err := sharedObjectsArgs(ctx, c.Repos)
+		if err != nil {
+			return err
+		}
+		dockerArgs = append(dockerArgs, args...)
+		dockerArgs = append(dockerArgs, "--label", "md.shared_objects=1")
+	}
+
 	// Set md metadata labels.
 	if reposJSON, err := json.Marshal(c.Repos); err == nil {
 		// Base64-encode so commas in JSON don't corrupt the comma-separated
@@ -855,6 +1216,29 @@ func launchContainer(ctx context.Context, stdout, stderr io.Writer, c *Container
 	}
 	if opts.Display {
 		dockerArgs = append(dockerArgs, "--label", "md.display=1")
+		if displays > 1 {
+			dockerArgs = append(dockerArgs, "--label", "md.displays="+strconv.Itoa(displays))
+		}
+		if opts.DisplaySize != "" {
+			dockerArgs = append(dockerArgs, "--label", "md.display_size="+opts.DisplaySize)
+		}
+		if opts.RDP {
+			dockerArgs = append(dockerArgs, "--label", "md.rdp=1")
+		}
+	}
+	if opts.Browser {
+		dockerArgs = append(dockerArgs, "--label", "md.browser=1")
+	}
+	if len(mounts) > 0 {
+		if mountsJSON, err := json.Marshal(mounts); err == nil {
+			dockerArgs = append(dockerArgs, "--label", "md.mounts="+base64.StdEncoding.EncodeToString(mountsJSON))
+		}
+	}
+	if len(opts.Credentials) > 0 {
+		dockerArgs = append(dockerArgs, "--label", "md.credentials="+credentialsLabel(opts.Credentials))
+		if opts.ScopedCredentials {
+			dockerArgs = append(dockerArgs, "--label", "md.credentials_scoped=1")
+		}
 	}
 	if opts.Tailscale {
 		dockerArgs = append(dockerArgs, "--label", "md.tailscale=1")
@@ -865,54 +1249,129 @@ func launchContainer(ctx context.Context, stdout, stderr io.Writer, c *Container
 	if opts.USB {
 		dockerArgs = append(dockerArgs, "--label", "md.usb=1")
 	}
+	if opts.TTL > 0 {
+		dockerArgs = append(dockerArgs, "--label", "md.ttl="+opts.TTL.String())
+	}
+	c.Network = opts.NetworkMode
+	if c.Network == NetworkPrivate {
+		c.Network = privateNetwork(c.Name)
+		if err := c.createNetwork(ctx); err != nil {
+			return err
+		}
+	}
+	// The engine's default network is "podman" with podman: leave it implicit.
+	if c.Network != NetworkBridge {
+		dockerArgs = append(dockerArgs, "--network", c.Network)
+	}
+	dockerArgs = append(dockerArgs, "--label", "md.network="+c.Network)
+	if c.composeFile != "" {
+		dockerArgs = append(dockerArgs, "--label", "md.sidecars=1")
+	}
+	if opts.NetworkPolicy != nil {
+		dockerArgs = append(dockerArgs, "--label", "md.egress="+egressLabel(opts.NetworkPolicy))
+	}
 	for _, l := range opts.Labels {
 		dockerArgs = append(dockerArgs, "--label", l)
 	}
 	dockerArgs = append(dockerArgs, opts.ExtraRunArgs...)
 	dockerArgs = append(dockerArgs, imageName)
 
+	var runErr error
 	if opts.Quiet {
-		if _, err := runCmd(ctx, "", dockerArgs); err != nil {
-			return fmt.Errorf("starting container: %w", err)
-		}
+		_, runErr = runCmd(ctx, "", dockerArgs)
 	} else {
 		_, _ = fmt.Fprintf(stdout, "- Starting container %s ... ", c.Name)
-		if err := runCmdOut(ctx, "", dockerArgs, stdout, stderr); err != nil {
+		if runErr = runCmdOut(ctx, "", dockerArgs, stdout, stderr); runErr != nil {
 			_, _ = fmt.Fprintln(stdout)
-			return fmt.Errorf("starting container: %w", err)
 		}
 	}
+	if runErr != nil {
+		_ = c.removeNetwork(ctx)
+		return fmt.Errorf("starting container: %w", runErr)
+	}
+	if opts.Display {
+		if err := c.sendVNCPassword(ctx); err != nil {
+			return err
+		}
+	}
+	c.NetworkPolicy = opts.NetworkPolicy
+	c.HostPorts = opts.HostPorts
+	c.CloneDepth = opts.CloneDepth
+	c.CloneFilter = opts.CloneFilter
+	c.SharedObjects = opts.SharedObjects
+	if err := c.applyNetworkPolicy(ctx, imageName); err != nil {
+		return err
+	}
+
+	if c.composeFile != "" {
+		c.Sidecars = true
+		if err := c.startSidecars(ctx, stdout, stderr, c.composeFile, opts.Quiet); err != nil {
+			return err
+		}
+		c.composeFile = ""
+	}
 
 	// Get SSH port and creation time.
-	port, err := getHostPort(ctx, rt, c.Name, "22/tcp")
+	port, err := c.GetHostPort(ctx, "22/tcp")
 	if err != nil {
 		return fmt.Errorf("getting SSH port: %w", err)
 	}
 	c.SSHPort = port
-	if !opts.Quiet {
+	if port != 0 && !opts.Quiet {
 		_, _ = fmt.Fprintf(stdout, "- Found ssh port %d\n", port)
 	}
-	createdStr, err := runCmd(ctx, "", []string{rt, "inspect", "--format", "{{.Created}}", c.Name})
+	info, err := inspectContainer(ctx, rt, c.Name)
 	if err != nil {
 		return fmt.Errorf("getting container creation time: %w", err)
 	}
-	created, err := parseCreatedAt(createdStr)
-	if err != nil {
-		return fmt.Errorf("parsing container creation time %q: %w", createdStr, err)
-	}
-	c.CreatedAt = created
+	c.CreatedAt = info.Created
 
-	// Get VNC port if display enabled.
+	// Get VNC ports if display enabled.
 	if opts.Display {
-		vncPort, _ := getHostPort(ctx, rt, c.Name, "5901/tcp")
-		c.VNCPort = vncPort
-		if vncPort != 0 && !opts.Quiet {
-			_, _ = fmt.Fprintf(stdout, "- Found VNC port %d (display :1)\n", vncPort)
+		c.Display = true
+		if displays > 1 {
+			c.Displays = displays
+		}
+		c.DisplaySize = opts.DisplaySize
+		c.queryVNCPorts(ctx)
+		if !opts.Quiet {
+			for i, p := range c.VNCPorts {
+				if p != 0 {
+					_, _ = fmt.Fprintf(stdout, "- Found VNC port %d (display :%d)\n", p, i+1)
+				}
+			}
+		}
+	}
+	if opts.RDP {
+		c.RDP = true
+		c.RDPPort, _ = getHostPort(ctx, rt, c.Name, "3389/tcp")
+		if c.RDPPort != 0 && !opts.Quiet {
+			_, _ = fmt.Fprintf(stdout, "- Found RDP port %d\n", c.RDPPort)
 		}
 	}
+	c.Mounts = mounts
+	c.MountSource = opts.MountSource
+	c.PublishedPorts = opts.PublishPorts
+	c.Bind = opts.Bind
+	c.Sudo = opts.Sudo
+	if opts.Browser {
+		c.Browser = true
+		c.CDPPort, _ = getHostPort(ctx, rt, c.Name, "9222/tcp")
+		if c.CDPPort != 0 && !opts.Quiet {
+			_, _ = fmt.Fprintf(stdout, "- Found DevTools port %d\n", c.CDPPort)
+		}
+	}
+	checkExposure(ctx, stdout, stderr, c, info, opts.Quiet)
+
+	return setupHostAccess(ctx, stdout, stderr, c, c.sshEndpoint(), opts.Quiet)
+}
 
+// setupHostAccess writes the SSH config and known_hosts of the container
+// reached through ep, and adds the git remotes of its repos. It does NOT wait
+// for SSH.
+func setupHostAccess(ctx context.Context, stdout, stderr io.Writer, c *Container, ep sshEndpoint, quiet bool) error {
 	// Write SSH config.
-	sshConfigDir := filepath.Join(home, ".ssh", "config.d")
+	sshConfigDir := filepath.Join(c.Home, ".ssh", "config.d")
 	if err := os.MkdirAll(sshConfigDir, 0o700); err != nil {
 		return err
 	}
@@ -921,17 +1380,22 @@ func launchContainer(ctx context.Context, stdout, stderr io.Writer, c *Container
 	if err != nil {
 		return fmt.Errorf("reading host public key: %w", err)
 	}
-	if err := writeSSHConfig(sshConfigDir, c.Name, port, c.UserKeyPath, knownHostsPath, c.ControlMaster); err != nil {
+	if err := writeSSHConfig(sshConfigDir, c.Name, ep, c.UserKeyPath, knownHostsPath, c.ControlMaster); err != nil {
 		return err
 	}
-	if err := writeKnownHosts(knownHostsPath, port, strings.TrimSpace(string(hostPubKey))); err != nil {
+	if err := writeKnownHosts(knownHostsPath, c.Name, strings.TrimSpace(string(hostPubKey))); err != nil {
 		return err
 	}
+	touchLastUsed(sshConfigDir, c.Name)
 
 	// Set up git remotes for all repos before waiting for SSH, so they are
-	// ready to push as soon as the connection is established.
-	if len(c.Repos) > 0 {
-		if !opts.Quiet {
+	// ready to push as soon as the connection is established. Read-write and
+	// read-only checkouts have nothing to exchange.
+	if c.MountSource != SourceClone && !quiet {
+		_, _ = fmt.Fprintf(stdout, "- Mounted host checkout into container (%s)\n", c.MountSource)
+	}
+	if len(c.Repos) > 0 && c.MountSource.ownsGit() {
+		if !quiet && c.MountSource == SourceClone {
 			_, _ = fmt.Fprintln(stdout, "- git clone into container ...")
 		}
 		for _, r := range c.Repos {
@@ -961,7 +1425,9 @@ func waitForTCP(ctx context.Context, addr string, deadline time.Time) error {
 		if time.Now().After(deadline) {
 			return fmt.Errorf("timed out waiting for TCP %s", addr)
 		}
-		time.Sleep(10 * time.Millisecond)
+		if err := sleepCtx(ctx, 10*time.Millisecond); err != nil {
+			return err
+		}
 	}
 }
 
@@ -973,9 +1439,8 @@ func connectContainer(ctx context.Context, stdout, stderr io.Writer, c *Containe
 	result := &StartResult{}
 
 	// Phase 1: wait for TCP port to accept connections.
-	addr := fmt.Sprintf("localhost:%d", c.SSHPort)
 	deadline := time.Now().Add(30 * time.Second)
-	if err := waitForTCP(ctx, addr, deadline); err != nil {
+	if err := c.waitForSSHPort(ctx, deadline); err != nil {
 		return nil, err
 	}
 
@@ -983,48 +1448,41 @@ func connectContainer(ctx context.Context, stdout, stderr io.Writer, c *Containe
 	// operation and doubles as the handshake readiness check. Using ssh
 	// instead of scp gives reliable exit code 255 on connection errors.
 	// If no .env exists locally the container still gets an empty file.
-	var envContent []byte
-	for _, r := range c.Repos {
-		data, err := os.ReadFile(filepath.Join(r.GitRoot, ".env"))
-		if err != nil {
-			continue
-		}
-		if len(envContent) > 0 && envContent[len(envContent)-1] != '\n' {
-			envContent = append(envContent, '\n')
-		}
-		envContent = append(envContent, data...)
-	}
+	envContent := readEnvFiles(c.Repos)
 	if len(envContent) > 0 && !opts.Quiet {
 		_, _ = fmt.Fprintln(stdout, "- sending .env into container ...")
 	}
 	if len(opts.ExtraEnv) > 0 {
-		if len(envContent) > 0 && envContent[len(envContent)-1] != '\n' {
-			envContent = append(envContent, '\n')
-		}
-		for _, kv := range opts.ExtraEnv {
-			envContent = append(envContent, []byte(kv+"\n")...)
-		}
+		envContent = appendEnv(envContent, opts.ExtraEnv)
 		if !opts.Quiet {
 			_, _ = fmt.Fprintln(stdout, "- injecting extra env vars into container ...")
 		}
 	}
-	sshEnvArgs := c.SSHCommand(c.Name, "cat > /home/user/.env")
-	for {
-		cmd := exec.CommandContext(ctx, sshEnvArgs[0], sshEnvArgs[1:]...)
-		cmd.Stdin = bytes.NewReader(envContent)
-		out, err := cmd.CombinedOutput()
-		if err == nil {
-			break
+	if c.credentials != nil {
+		envContent = appendEnv(envContent, c.credentials.env)
+	}
+	envContent = appendEnv(envContent, c.Proxy.Env())
+	envContent = appendEnv(envContent, c.hostPortsEnv())
+	if err := writeEnv(ctx, c, envContent, deadline); err != nil {
+		return nil, err
+	}
+	c.checkpoint = "SSH was up"
+
+	if c.credentials != nil {
+		if err := copyCredentials(ctx, c.Runtime, c.Name, c.credentials); err != nil {
+			return nil, err
 		}
-		var exitErr *exec.ExitError
-		if !errors.As(err, &exitErr) || exitErr.ExitCode() != 255 || time.Now().After(deadline) {
-			return nil, fmt.Errorf("copying .env: %w\n%s", err, out)
+		if !opts.Quiet {
+			_, _ = fmt.Fprintf(stdout, "- Shared credentials: %s (read-only)\n", strings.Join(opts.Credentials, ", "))
 		}
+		c.Credentials = opts.Credentials
+		c.ScopedCredentials = opts.ScopedCredentials
+		c.credentials = nil
 	}
 
 	// Push all repos into the container in parallel. Each repo pushes to a
 	// distinct path (~/src/<name>) so there are no cross-repo conflicts.
-	if len(c.Repos) > 0 {
+	if len(c.Repos) > 0 && c.MountSource.ownsGit() {
 		eg, egCtx := errgroup.WithContext(ctx)
 		for repoIdx := range c.Repos {
 			eg.Go(func() error {
@@ -1032,9 +1490,27 @@ func connectContainer(ctx context.Context, stdout, stderr io.Writer, c *Containe
 				rRepo := shellQuote(rName)
 				rBranch := shellQuote(c.Repos[repoIdx].Branch)
 
+				if c.MountSource == SourceOverlay {
+					// The checkout is already there: the host's HEAD is base.
+					// The new ref is written to the overlay's upper layer.
+					if err := runCmdOut(egCtx, "", c.SSHCommand(c.Name,
+						"cd ~/src/"+rRepo+
+							" && "+baseUpdateEnv+"=1 git branch -q -f base HEAD"+
+							" && git branch -q --set-upstream-to=base "+rBranch), stdout, stderr); err != nil {
+						return fmt.Errorf("mark base for %s: %w", rName, err)
+					}
+					if err := c.guardBase(egCtx, rName); err != nil {
+						return err
+					}
+					return c.SyncDefaultBranch(egCtx, repoIdx)
+				}
+
 				if err := runCmdOut(egCtx, "", c.SSHCommand(c.Name, "git init -q ~/src/"+rRepo), stdout, stderr); err != nil {
 					return fmt.Errorf("init repo %s in container: %w", rName, err)
 				}
+				if err := c.guardBase(egCtx, rName); err != nil {
+					return err
+				}
 
 				// Resolve defaults concurrently with the base push (no git I/O to the
 				// container), but serialize the two pushes: concurrent receive-pack
@@ -1044,16 +1520,35 @@ func connectContainer(ctx context.Context, stdout, stderr io.Writer, c *Containe
 					resolveErr <- c.Repos[repoIdx].resolveDefaults(egCtx)
 				}()
 
-				if err := runCmdOut(egCtx, c.Repos[repoIdx].GitRoot, []string{
-					"git", "push", "-q", c.Name,
+				if c.fetchesFromHost() {
+					if err := c.hostGit(egCtx, stdout, stderr, &c.Repos[repoIdx], hostCloneScript(c.CloneFilter)+" && "+hostFetchScript("refs/heads/"+c.Repos[repoIdx].Branch, "base", c.CloneDepth)); err != nil {
+						return err
+					}
+				} else if c.SharedObjects {
+					if err := c.borrowObjects(egCtx, &c.Repos[repoIdx]); err != nil {
+						return err
+					}
+				} else if err := runCmdOut(egCtx, c.Repos[repoIdx].GitRoot, []string{
+					"git", "push", "-q", "--receive-pack=" + baseReceivePack, c.Name,
 					c.Repos[repoIdx].Branch + ":refs/heads/base",
 				}, stdout, stderr); err != nil {
 					return fmt.Errorf("push repo %s: %w", rName, err)
 				}
-				if err := runCmdOut(egCtx, "", c.SSHCommand(c.Name,
-					"cd ~/src/"+rRepo+
-						" && git branch -q --track "+rBranch+" base"+
-						" && git switch -q "+rBranch), stdout, stderr); err != nil {
+				lfs, err := c.pushLFS(egCtx, stdout, &c.Repos[repoIdx], c.Repos[repoIdx].Branch)
+				if err != nil {
+					return err
+				}
+				switchCmd := "git switch -q " + rBranch
+				if lfs {
+					switchCmd = lfsSkipSmudge + switchCmd + lfsCheckout
+				}
+				switchCmd = "git branch -q --track " + rBranch + " base && " + switchCmd
+				if c.fetchesFromHost() {
+					// A partial clone fetches the checkout's blobs.
+					if err := c.hostGit(egCtx, stdout, stderr, &c.Repos[repoIdx], switchCmd); err != nil {
+						return err
+					}
+				} else if err := runCmdOut(egCtx, "", c.SSHCommand(c.Name, "cd ~/src/"+rRepo+" && "+switchCmd), stdout, stderr); err != nil {
 					return err
 				}
 
@@ -1067,6 +1562,9 @@ func connectContainer(ctx context.Context, stdout, stderr io.Writer, c *Containe
 				if err := c.pushSubmodules(egCtx, stdout, stderr, "/home/user/src/"+rName, c.Repos[repoIdx].GitRoot, opts.Quiet); err != nil {
 					return fmt.Errorf("push submodules for %s: %w", rName, err)
 				}
+				if err := c.sendNested(egCtx, stdout, stderr, &c.Repos[repoIdx], c.nested[repoIdx], opts.Quiet); err != nil {
+					return fmt.Errorf("copy nested repositories for %s: %w", rName, err)
+				}
 
 				// resolveDefaults ran above, so DefaultRemote is set.
 				originURL, err := runCmd(egCtx, c.Repos[repoIdx].GitRoot, []string{"git", "remote", "get-url", c.Repos[repoIdx].DefaultRemote})
@@ -1083,30 +1581,89 @@ func connectContainer(ctx context.Context, stdout, stderr io.Writer, c *Containe
 		if err := eg.Wait(); err != nil {
 			return nil, err
 		}
+		c.checkpoint = "the repositories were pushed"
 	}
 
-	// Wait for Tailscale auth URL if needed.
-	if opts.Tailscale && opts.TailscaleAuthKey == "" {
-		tailArgs := c.SSHCommand(c.Name, "tail -f /tmp/tailscale_auth_url")
-		cmd := exec.CommandContext(ctx, tailArgs[0], tailArgs[1:]...)
-		stdout, err := cmd.StdoutPipe()
-		if err == nil {
-			if err := cmd.Start(); err == nil {
-				buf := make([]byte, 4096)
-				n, _ := stdout.Read(buf)
-				line := string(buf[:n])
-				if strings.Contains(line, "https://") {
-					result.TailscaleAuthURL = strings.TrimSpace(line)
-				}
-				_ = cmd.Process.Kill()
-				_ = cmd.Wait()
+	// Provision the repos before their services start.
+	if err := c.setup(ctx, stdout, stderr, opts); err != nil {
+		return nil, err
+	}
+	c.checkpoint = "the repositories were set up"
+
+	// Services run from the primary repo, so start them once it is pushed.
+	if len(c.services) > 0 {
+		if err := startServices(ctx, c.Runtime, c.Name, c.services, c.Repos[0].Name()); err != nil {
+			return nil, err
+		}
+		if !opts.Quiet {
+			names := make([]string, len(c.services))
+			for i, s := range c.services {
+				names[i] = s.Name
 			}
+			_, _ = fmt.Fprintf(stdout, "- Started services: %s\n", strings.Join(names, ", "))
 		}
+		c.services = nil
 	}
 
 	return result, nil
 }
 
+// readEnvFiles concatenates the .env files of repos, skipping the missing ones.
+func readEnvFiles(repos []Repo) []byte {
+	var content []byte
+	for _, r := range repos {
+		data, err := os.ReadFile(filepath.Join(r.GitRoot, ".env"))
+		if err != nil {
+			continue
+		}
+		if len(content) > 0 && content[len(content)-1] != '\n' {
+			content = append(content, '\n')
+		}
+		content = append(content, data...)
+	}
+	return content
+}
+
+// appendEnv appends the KEY=VALUE pairs kvs to the .env content, one per line.
+func appendEnv(content []byte, kvs []string) []byte {
+	if len(kvs) == 0 {
+		return content
+	}
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		content = append(content, '\n')
+	}
+	for _, kv := range kvs {
+		content = append(content, kv+"\n"...)
+	}
+	return content
+}
+
+// writeEnv writes content to the container's ~/.env, mode 0600 since it
+// holds secrets, via ssh+stdin. It is usually the first SSH operation on a
+// new container and doubles as the handshake readiness check: it retries on
+// connection errors (exit code 255) until deadline.
+func writeEnv(ctx context.Context, c *Container, content []byte, deadline time.Time) error {
+	sshEnvArgs := c.SSHCommand(c.Name, "umask 077 && cat > /home/user/.env && chmod 600 /home/user/.env")
+	for {
+		cmd := exec.CommandContext(ctx, sshEnvArgs[0], sshEnvArgs[1:]...)
+		cmd.Stdin = bytes.NewReader(content)
+		out, err := cmd.CombinedOutput()
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != 255 || time.Now().After(deadline) {
+			return fmt.Errorf("copying .env: %w\n%s", err, out)
+		}
+		if err := sleepCtx(ctx, 10*time.Millisecond); err != nil {
+			return err
+		}
+	}
+}
+
 // convertGitURLToHTTPS converts a git URL to HTTPS format.
 //
 // Supports git@host:path, ssh://git@host/path, git://host/path, and
@@ -5,12 +5,15 @@
 package md
 
 import (
+	"context"
+	"errors"
 	"os"
 	"path/filepath"
 	"runtime"
 	"slices"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestFormatBytes(t *testing.T) {
@@ -53,6 +56,14 @@ func TestFormatCount(t *testing.T) {
 	}
 }
 
+func TestValidateSize(t *testing.T) {
+	for s, ok := range map[string]bool{"512m": true, "8g": true, "1.5G": true, "1048576": true, "64mb": true, "": false, "8 g": false, "-1g": false, "1x": false} {
+		if err := ValidateSize("memory", s); (err == nil) != ok {
+			t.Errorf("ValidateSize(%q) = %v", s, err)
+		}
+	}
+}
+
 func TestResolveHostPath(t *testing.T) {
 	tests := []struct {
 		path, home, want string
@@ -148,6 +159,174 @@ func TestKeysSHA(t *testing.T) {
 	})
 }
 
+func TestContextTreeSHA(t *testing.T) {
+	want, err := contextTreeSHA(rscFS, "rsc/user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := prepareBuildContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	got, err := contextTreeSHA(os.DirFS(dir), ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("on-disk copy hashes to %s, embedded tree to %s", got, want)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM scratch\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = contextTreeSHA(os.DirFS(dir), "."); err != nil {
+		t.Fatal(err)
+	} else if got == want {
+		t.Error("contextTreeSHA should change when a file changes")
+	}
+}
+
+func TestDecodeInspect(t *testing.T) {
+	t.Run("image", func(t *testing.T) {
+		out := `[{"Id": "sha256:abc", "RepoDigests": [], "Config": {"Labels": {"md.context_sha": "123"}}}]`
+		info, err := decodeInspect[imageInfo](out, "img")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.digest() != "sha256:abc" || info.Config.Labels["md.context_sha"] != "123" || info.Config.Labels["md.cache_key"] != "" {
+			t.Errorf("got %+v", info)
+		}
+		info.RepoDigests = []string{"ghcr.io/x@sha256:def"}
+		if info.digest() != "ghcr.io/x@sha256:def" {
+			t.Errorf("digest() = %q", info.digest())
+		}
+	})
+	t.Run("container", func(t *testing.T) {
+		// Podman prints null for unpublished ports and fractional seconds.
+		out := `[{"Created": "2026-01-02T03:04:05.123456789Z", "Config": {"Labels": null}, "NetworkSettings": {"Ports": {"22/tcp": [{"HostIp": "127.0.0.1", "HostPort": "32768"}], "5901/tcp": null}}}]`
+		info, err := decodeInspect[containerInfo](out, "md-x")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Created.Year() != 2026 || info.NetworkSettings.Ports["22/tcp"][0].HostPort != "32768" || len(info.NetworkSettings.Ports["5901/tcp"]) != 0 {
+			t.Errorf("got %+v", info)
+		}
+	})
+	t.Run("size", func(t *testing.T) {
+		out := `[{"Name": "/md-x", "SizeRw": 1024, "Mounts": [{"Type": "bind", "Destination": "/home/user/.cache/go-build"}, {"Type": "volume", "Destination": "/data"}]}]`
+		info, err := decodeInspect[containerInfo](out, "md-x")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.SizeRw == nil || *info.SizeRw != 1024 || !slices.Equal(info.volumeDestinations(), []string{"/data"}) {
+			t.Errorf("got %+v", info)
+		}
+	})
+	t.Run("state", func(t *testing.T) {
+		out := `[{"State": {"Status": "running", "Running": true, "FinishedAt": "0001-01-01T00:00:00Z"}}]`
+		info, err := decodeInspect[containerInfo](out, "md-x")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exit := info.exitError("md-x"); exit != nil {
+			t.Errorf("healthy container: %v", exit)
+		}
+		out = `[{"State": {"Status": "exited", "OOMKilled": true, "ExitCode": 137, "FinishedAt": "2026-01-02T03:04:05.123456789Z"}}]`
+		if info, err = decodeInspect[containerInfo](out, "md-x"); err != nil {
+			t.Fatal(err)
+		}
+		exit := info.exitError("md-x")
+		if exit == nil || exit.Label() != "oom-killed, exited 137" {
+			t.Fatalf("got %+v", exit)
+		}
+		exit.Logs = "fatal error: out of memory"
+		want := "container md-x: oom-killed, exited 137 at 2026-01-02T03:04:05Z\nIt ran out of memory: check its --memory limit\nLast lines of its output:\nfatal error: out of memory"
+		if got := exit.Error(); got != want {
+			t.Errorf("got %q\nwant %q", got, want)
+		}
+		exit = &ContainerExitError{Name: "md-x", Status: "created", EngineError: "exec: \"/init\": not found"}
+		if want := `container md-x: created: exec: "/init": not found`; exit.Error() != want {
+			t.Errorf("got %q", exit.Error())
+		}
+	})
+	for name, out := range map[string]string{"empty": "[]", "invalid": "Error: no such object"} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := decodeInspect[imageInfo](out, "x"); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestImageBuildNeeded(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell")
+	}
+	ctx := t.Context()
+	keys := t.TempDir()
+	for _, name := range []string{"ssh_host_ed25519_key", "ssh_host_ed25519_key.pub", "authorized_keys"} {
+		if err := os.WriteFile(filepath.Join(keys, name), []byte(name), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	contextSHA, err := keysSHA(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The fake engine prints the inspect output saved for the image.
+	images := t.TempDir()
+	docker := filepath.Join(t.TempDir(), "docker")
+	if err := os.WriteFile(docker, []byte("#!/bin/sh\ncat \""+images+"/$3\"\n"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	setImage := func(name, labels string) {
+		t.Helper()
+		out := `[{"Id": "sha256:` + name + `", "Config": {"Labels": {` + labels + `}}}]`
+		if err := os.WriteFile(filepath.Join(images, name), []byte(out), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setImage("base", "")
+	for name, tt := range map[string]struct {
+		labels string
+		want   string
+	}{
+		"no_image":       {"-", RebuildNoImage},
+		"no_labels":      {"", RebuildNoLabels},
+		"base_changed":   {`"md.base_digest": "sha256:old", "md.context_sha": "` + contextSHA + `"`, RebuildBaseChanged},
+		"keys_changed":   {`"md.base_digest": "sha256:base", "md.context_sha": "old"`, RebuildKeysChanged},
+		"caches_changed": {`"md.base_digest": "sha256:base", "md.context_sha": "` + contextSHA + `", "md.cache_key": "old"`, RebuildCachesChanged},
+		"up_to_date":     {`"md.base_digest": "sha256:base", "md.context_sha": "` + contextSHA + `"`, ""},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_ = os.Remove(filepath.Join(images, "user"))
+			if tt.labels != "-" {
+				setImage("user", tt.labels)
+			}
+			c := &Client{}
+			reason := c.imageBuildNeeded(ctx, docker, "user", "base", keys, t.TempDir(), nil, SudoDefault, false)
+			if got := ""; reason != nil {
+				got = reason.Code
+				if got != tt.want || reason.Detail == "" {
+					t.Errorf("got %+v, want %q", reason, tt.want)
+				}
+			} else if tt.want != "" {
+				t.Errorf("got up to date, want %q", tt.want)
+			}
+		})
+	}
+	t.Run("requested", func(t *testing.T) {
+		setImage(userImageName("base", ""), `"md.base_digest": "sha256:base", "md.context_sha": "`+contextSHA+`"`)
+		c := &Client{Runtime: docker, keysDir: keys, Home: t.TempDir()}
+		if _, reason := c.WhyRebuild(ctx, &WarmupOpts{BaseImage: "base"}); reason != nil {
+			t.Fatalf("got %+v", reason)
+		}
+		if _, reason := c.WhyRebuild(ctx, &WarmupOpts{BaseImage: "base", Rebuild: true}); reason == nil || reason.Code != RebuildRequested {
+			t.Errorf("got %+v", reason)
+		}
+	})
+}
+
 func TestCacheSpecKey(t *testing.T) {
 	t.Run("nil_returns_empty", func(t *testing.T) {
 		if got := cacheSpecKey(nil); got != "" {
@@ -341,7 +520,7 @@ func TestResolveCaches(t *testing.T) {
 
 func TestGenerateDockerfile(t *testing.T) {
 	t.Run("no_caches_no_dirs", func(t *testing.T) {
-		got := generateDockerfile("mybase:latest", nil, nil, "sha256:abc", "ctxsha", "", "")
+		got := generateDockerfile("mybase:latest", nil, nil, "", "sha256:abc", "ctxsha", "", "")
 		if !strings.Contains(got, "FROM mybase:latest\n") {
 			t.Error("missing FROM line")
 		}
@@ -363,7 +542,7 @@ func TestGenerateDockerfile(t *testing.T) {
 		active := []activeCM{{
 			cm: CacheMount{Name: "go-mod", ContainerPath: "/home/user/go/pkg/mod"},
 		}}
-		got := generateDockerfile("base:v1", active, []string{"/home/user/go/pkg/mod"}, "", "", "cachekey", "")
+		got := generateDockerfile("base:v1", active, []string{"/home/user/go/pkg/mod"}, "", "", "", "cachekey", "")
 		if !strings.Contains(got, `COPY --from=cache-go-mod --chown=user:user [".", "/home/user/go/pkg/mod/"]`) {
 			t.Errorf("missing recursive COPY in:\n%s", got)
 		}
@@ -377,7 +556,7 @@ func TestGenerateDockerfile(t *testing.T) {
 			cm:    CacheMount{Name: "android-keys", ContainerPath: "/home/user/.android"},
 			files: []string{"debug.keystore", "adbkey"},
 		}}
-		got := generateDockerfile("base:v1", active, nil, "", "", "", "")
+		got := generateDockerfile("base:v1", active, nil, "", "", "", "", "")
 		if !strings.Contains(got, `COPY --from=cache-android-keys --chown=user:user ["debug.keystore", "/home/user/.android/"]`) {
 			t.Errorf("missing shallow COPY for debug.keystore in:\n%s", got)
 		}
@@ -391,7 +570,7 @@ func TestGenerateDockerfile(t *testing.T) {
 			cm:    CacheMount{Name: "keys", ContainerPath: "/home/user/.keys"},
 			files: []string{"my key.pem"},
 		}}
-		got := generateDockerfile("base:v1", active, nil, "", "", "", "")
+		got := generateDockerfile("base:v1", active, nil, "", "", "", "", "")
 		// JSON form should properly quote the filename.
 		if !strings.Contains(got, `"my key.pem"`) {
 			t.Errorf("filename with spaces not properly quoted in:\n%s", got)
@@ -400,14 +579,14 @@ func TestGenerateDockerfile(t *testing.T) {
 
 	t.Run("dir_with_spaces", func(t *testing.T) {
 		dirs := []string{"/home/user/my cache"}
-		got := generateDockerfile("base:v1", nil, dirs, "", "", "", "")
+		got := generateDockerfile("base:v1", nil, dirs, "", "", "", "", "")
 		if !strings.Contains(got, "'/home/user/my cache'") {
 			t.Errorf("dir with spaces not shell-quoted in:\n%s", got)
 		}
 	})
 
 	t.Run("labels_set", func(t *testing.T) {
-		got := generateDockerfile("img", nil, nil, "dig", "ctx", "ckey", "mdig")
+		got := generateDockerfile("img", nil, nil, "", "dig", "ctx", "ckey", "mdig")
 		for _, want := range []string{
 			`LABEL md.base_digest="dig"`,
 			`LABEL md.context_sha="ctx"`,
@@ -419,6 +598,21 @@ func TestGenerateDockerfile(t *testing.T) {
 			}
 		}
 	})
+
+	t.Run("sudo", func(t *testing.T) {
+		if got := generateDockerfile("img", nil, nil, "", "", "", "", ""); strings.Contains(got, "sudoers") {
+			t.Errorf("unexpected sudoers in:\n%s", got)
+		}
+		got := generateDockerfile("img", nil, nil, SudoNone, "", "", "", "")
+		for _, want := range []string{
+			"COPY --chown=root:root sudoers /etc/sudoers.d/zz-md\n",
+			" && chmod 0440 /etc/sudoers.d/zz-md",
+		} {
+			if !strings.Contains(got, want) {
+				t.Errorf("missing %q in:\n%s", want, got)
+			}
+		}
+	})
 }
 
 func TestConvertGitURLToHTTPS(t *testing.T) {
@@ -442,3 +636,14 @@ func TestConvertGitURLToHTTPS(t *testing.T) {
 		})
 	}
 }
+
+func TestWriteEnvCanceled(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell")
+	}
+	ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+	defer cancel()
+	if err := writeEnv(ctx, fakeSSH("exit 255"), nil, time.Now().Add(time.Hour)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal(err)
+	}
+}
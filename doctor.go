@@ -0,0 +1,339 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caic-xyz/md/gitutil"
+)
+
+// Check statuses reported by [Client.Doctor].
+const (
+	CheckOK   = "ok"
+	CheckWarn = "warn"
+	CheckFail = "fail"
+	CheckSkip = "skip"
+)
+
+// Check is the result of one [Client.Doctor] diagnostic.
+type Check struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	// Detail describes what was found.
+	Detail string `json:"detail,omitempty"`
+	// Fix is the action to take when Status is CheckWarn or CheckFail.
+	Fix string `json:"fix,omitempty"`
+}
+
+// DoctorOpts configures [Client.Doctor].
+type DoctorOpts struct {
+	// GitRoot is the repository checked for orphan md git remotes. Empty
+	// skips the check.
+	GitRoot string
+	// Offline skips the checks needing network access (GHCR, Tailscale).
+	Offline bool
+}
+
+// Doctor diagnoses the host setup md depends on and the md state left
+// behind by containers that no longer exist. It never modifies anything;
+// each failed check carries the command or action fixing it.
+func (c *Client) Doctor(ctx context.Context, opts *DoctorOpts) []Check {
+	checks := []Check{c.checkEngine(ctx), checkKVM()}
+	for _, bin := range []string{"ssh", "scp"} {
+		checks = append(checks, checkBinary(bin))
+	}
+	sshDir := filepath.Join(c.Home, ".ssh")
+	checks = append(checks, checkSSHInclude(sshDir))
+	for _, p := range []string{c.UserKeyPath, c.HostKeyPath} {
+		checks = append(checks, checkKeyPermissions(p))
+	}
+	if opts.Offline {
+		checks = append(checks,
+			Check{Name: "ghcr", Status: CheckSkip, Detail: "offline"},
+			Check{Name: "tailscale", Status: CheckSkip, Detail: "offline"})
+	} else {
+		checks = append(checks, checkGHCR(ctx, c.httpClient(10*time.Second)), checkTailscaleAPIKey(ctx, c.httpClient(10*time.Second), c.TailscaleAPIKey))
+	}
+	containers, err := c.List(ctx)
+	if err != nil {
+		return append(checks,
+			Check{Name: "ssh-configs", Status: CheckSkip, Detail: "container engine unavailable"},
+			Check{Name: "git-remotes", Status: CheckSkip, Detail: "container engine unavailable"})
+	}
+	names := make([]string, len(containers))
+	for i, ct := range containers {
+		names[i] = ct.Name
+	}
+	checks = append(checks, checkStaleSSHConfigs(filepath.Join(sshDir, "config.d"), names))
+	if opts.GitRoot == "" {
+		return append(checks, Check{Name: "git-remotes", Status: CheckSkip, Detail: "not in a git repository"})
+	}
+	return append(checks, checkOrphanRemotes(ctx, opts.GitRoot, names))
+}
+
+// checkEngine checks that the container engine runs and is recent enough.
+func (c *Client) checkEngine(ctx context.Context) Check {
+	chk := Check{Name: c.Runtime}
+	if _, err := exec.LookPath(c.Runtime); err != nil {
+		chk.Status = CheckFail
+		chk.Detail = c.Runtime + " not found in PATH"
+		chk.Fix = "install Docker (https://docs.docker.com/engine/install/) or Podman, or select the other one with --runtime"
+		return chk
+	}
+	e := c.Engine()
+	v, err := runCmd(ctx, "", []string{c.Runtime, "info", "--format", e.versionFormat()})
+	if err != nil {
+		chk.Status = CheckFail
+		chk.Detail = cmdErrWithStderr(c.Runtime+" info", err).Error()
+		chk.Fix = e.accessFix()
+		return chk
+	}
+	chk.Detail = "version " + v
+	if minV := e.minVersion(); versionLess(v, minV) {
+		chk.Status = CheckFail
+		chk.Fix = fmt.Sprintf("upgrade %s to %s or later", c.Runtime, minV)
+		return chk
+	}
+	chk.Status = CheckOK
+	return chk
+}
+
+// versionLess reports whether the dotted version v is older than minV. Only
+// the leading numeric components are compared; an unparsable v isn't older.
+func versionLess(v, minV string) bool {
+	vs := strings.Split(v, ".")
+	for i, m := range strings.Split(minV, ".") {
+		if i >= len(vs) {
+			return true
+		}
+		want, _ := strconv.Atoi(m)
+		got, err := strconv.Atoi(strings.TrimLeft(vs[i], "v"))
+		if err != nil {
+			return false
+		}
+		if got != want {
+			return got < want
+		}
+	}
+	return false
+}
+
+// checkKVM checks that /dev/kvm is usable, for hardware-accelerated
+// emulators in the container.
+func checkKVM() Check {
+	chk := Check{Name: "kvm"}
+	switch {
+	case runtime.GOOS != "linux":
+		chk.Status = CheckSkip
+		chk.Detail = "Linux only"
+	case kvmAvailable():
+		chk.Status = CheckOK
+	default:
+		chk.Status = CheckWarn
+		chk.Detail = "/dev/kvm is missing or not writable; emulators in the container run without acceleration"
+		chk.Fix = "enable virtualization in the firmware and add your user to the kvm group (sudo usermod -aG kvm $USER)"
+	}
+	return chk
+}
+
+// checkBinary checks that bin is in PATH.
+func checkBinary(bin string) Check {
+	p, err := exec.LookPath(bin)
+	if err != nil {
+		return Check{Name: bin, Status: CheckFail, Detail: bin + " not found in PATH", Fix: "install the OpenSSH client"}
+	}
+	return Check{Name: bin, Status: CheckOK, Detail: p}
+}
+
+// checkSSHInclude checks that ~/.ssh/config loads the per-container configs
+// so plain ssh, scp and editors reach containers by name.
+func checkSSHInclude(sshDir string) Check {
+	chk := Check{Name: "ssh-include"}
+	configPath := filepath.Join(sshDir, "config")
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		chk.Status = CheckFail
+		chk.Detail = err.Error()
+		chk.Fix = "run any md command to create " + configPath
+		return chk
+	}
+	if !hasSSHConfigInclude(data) {
+		chk.Status = CheckWarn
+		chk.Detail = configPath + " doesn't include config.d/*.conf; md compensates but ssh <container> doesn't work"
+		chk.Fix = "add \"" + sshConfigInclude + "\" at the top of " + configPath
+		return chk
+	}
+	chk.Status = CheckOK
+	return chk
+}
+
+// checkKeyPermissions checks that the private key at p exists and is only
+// accessible by its owner, which ssh requires.
+func checkKeyPermissions(p string) Check {
+	chk := Check{Name: "key " + filepath.Base(p)}
+	fi, err := os.Stat(p)
+	if err != nil {
+		chk.Status = CheckFail
+		chk.Detail = err.Error()
+		chk.Fix = "run any md command to generate it"
+		return chk
+	}
+	if runtime.GOOS != "windows" && fi.Mode().Perm()&0o077 != 0 {
+		chk.Status = CheckFail
+		chk.Detail = fmt.Sprintf("%s has mode %#o; ssh ignores keys readable by others", p, fi.Mode().Perm())
+		chk.Fix = "chmod 600 " + p
+		return chk
+	}
+	chk.Status = CheckOK
+	return chk
+}
+
+// checkGHCR checks that the registry hosting the base image is reachable.
+// Any HTTP response, including the expected 401, proves it.
+func checkGHCR(ctx context.Context, client *http.Client) Check {
+	chk := Check{Name: "ghcr"}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://ghcr.io/v2/", http.NoBody)
+	if err != nil {
+		chk.Status = CheckFail
+		chk.Detail = err.Error()
+		return chk
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		chk.Status = CheckFail
+		chk.Detail = err.Error()
+		chk.Fix = "check the network connection and proxy settings; " + DefaultBaseImage + " is pulled from ghcr.io"
+		return chk
+	}
+	_ = resp.Body.Close()
+	chk.Status = CheckOK
+	return chk
+}
+
+// checkTailscaleAPIKey checks that the Tailscale API key, when set, is
+// accepted by the API.
+func checkTailscaleAPIKey(ctx context.Context, client *http.Client, apiKey string) Check {
+	chk := Check{Name: "tailscale"}
+	if apiKey == "" {
+		chk.Status = CheckSkip
+		chk.Detail = "no API key; only needed for md start --tailscale"
+		return chk
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://api.tailscale.com/api/v2/tailnet/-/keys", http.NoBody)
+	if err != nil {
+		chk.Status = CheckFail
+		chk.Detail = err.Error()
+		return chk
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	resp, err := client.Do(req)
+	if err != nil {
+		chk.Status = CheckFail
+		chk.Detail = fmt.Sprintf("network error: %v", err)
+		return chk
+	}
+	_ = resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		chk.Status = CheckOK
+	case http.StatusUnauthorized, http.StatusForbidden:
+		chk.Status = CheckFail
+		chk.Detail = fmt.Sprintf("API key rejected (%d); keys expire after 90 days", resp.StatusCode)
+		chk.Fix = "create an API access key at https://login.tailscale.com/admin/settings/keys and update $TAILSCALE_API_KEY or tailscale.api_key"
+	default:
+		chk.Status = CheckWarn
+		chk.Detail = fmt.Sprintf("API returned %d", resp.StatusCode)
+	}
+	return chk
+}
+
+// checkStaleSSHConfigs checks for SSH configs in configDir of md containers
+// not in names.
+func checkStaleSSHConfigs(configDir string, names []string) Check {
+	chk := Check{Name: "ssh-configs", Status: CheckOK}
+	stale, err := staleSSHConfigs(configDir, names)
+	if err != nil {
+		chk.Status = CheckFail
+		chk.Detail = err.Error()
+		return chk
+	}
+	if len(stale) != 0 {
+		chk.Status = CheckWarn
+		chk.Detail = "SSH configs of removed containers: " + strings.Join(stale, ", ")
+		chk.Fix = "md prune"
+	}
+	return chk
+}
+
+// staleSSHConfigs returns the md containers with files in configDir that are
+// not in names.
+func staleSSHConfigs(configDir string, names []string) ([]string, error) {
+	entries, err := os.ReadDir(configDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	var stale []string
+	for _, e := range entries {
+		base, ext, ok := strings.Cut(e.Name(), ".")
+		if !ok || !strings.HasPrefix(base, "md-") || !slices.Contains([]string{"conf", "known_hosts", "last_used"}, ext) {
+			continue
+		}
+		if !slices.Contains(names, base) && !slices.Contains(stale, base) {
+			stale = append(stale, base)
+		}
+	}
+	return stale, nil
+}
+
+// checkOrphanRemotes checks for git remotes in gitRoot of md containers not
+// in names.
+func checkOrphanRemotes(ctx context.Context, gitRoot string, names []string) Check {
+	chk := Check{Name: "git-remotes", Status: CheckOK}
+	orphans, err := orphanRemotes(ctx, gitRoot, names)
+	if err != nil {
+		chk.Status = CheckFail
+		chk.Detail = err.Error()
+		return chk
+	}
+	if len(orphans) != 0 {
+		chk.Status = CheckWarn
+		chk.Detail = "git remotes of removed containers in " + gitRoot + ": " + strings.Join(orphans, ", ")
+		chk.Fix = "md prune --root " + gitRoot
+	}
+	return chk
+}
+
+// orphanRemotes returns the git remotes in gitRoot that md added for
+// containers not in names. They are recognized by their URL, which points
+// at the container's SSH host alias.
+func orphanRemotes(ctx context.Context, gitRoot string, names []string) ([]string, error) {
+	out, err := gitutil.RunGit(ctx, gitRoot, "remote")
+	if err != nil {
+		return nil, err
+	}
+	var orphans []string
+	for r := range strings.FieldsSeq(out) {
+		if !strings.HasPrefix(r, "md-") || slices.Contains(names, r) {
+			continue
+		}
+		u, err := gitutil.RunGit(ctx, gitRoot, "remote", "get-url", r)
+		if err == nil && strings.HasPrefix(u, "user@"+r+":") {
+			orphans = append(orphans, r)
+		}
+	}
+	return orphans, nil
+}
@@ -0,0 +1,99 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+)
+
+func TestVersionLess(t *testing.T) {
+	for _, tc := range []struct {
+		v, minV string
+		want    bool
+	}{
+		{"27.3.1", "23.0", false},
+		{"23.0.0", "23.0", false},
+		{"20.10.24", "23.0", true},
+		{"4.2.1", "4.3", true},
+		{"5.0.0-dev", "4.3", false},
+		{"4", "4.3", true},
+		{"unknown", "23.0", false},
+	} {
+		if got := versionLess(tc.v, tc.minV); got != tc.want {
+			t.Errorf("versionLess(%q, %q) = %t", tc.v, tc.minV, got)
+		}
+	}
+}
+
+func TestCheckKeyPermissions(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "md")
+	if chk := checkKeyPermissions(p); chk.Status != CheckFail {
+		t.Errorf("missing: %+v", chk)
+	}
+	if err := os.WriteFile(p, []byte("key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if chk := checkKeyPermissions(p); chk.Status != CheckOK {
+		t.Errorf("0600: %+v", chk)
+	}
+	if runtime.GOOS == "windows" {
+		return
+	}
+	if err := os.Chmod(p, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if chk := checkKeyPermissions(p); chk.Status != CheckFail || chk.Fix != "chmod 600 "+p {
+		t.Errorf("0644: %+v", chk)
+	}
+}
+
+func TestStaleSSHConfigs(t *testing.T) {
+	dir := t.TempDir()
+	for _, f := range []string{"md-a-main.conf", "md-a-main.known_hosts", "md-b-main.conf", "md-b-main.last_used", "md-c-main.known_hosts", "other.conf", "md-x.bak"} {
+		if err := os.WriteFile(filepath.Join(dir, f), nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := staleSSHConfigs(dir, []string{"md-a-main"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"md-b-main", "md-c-main"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, err := staleSSHConfigs(filepath.Join(dir, "missing"), nil); err != nil || got != nil {
+		t.Errorf("missing dir: %q, %v", got, err)
+	}
+}
+
+func TestOrphanRemotes(t *testing.T) {
+	ctx := t.Context()
+	dir := t.TempDir()
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"remote", "add", "origin", "https://github.com/x/y"},
+		{"remote", "add", "md-y-main", "user@md-y-main:/home/user/src/y"},
+		{"remote", "add", "md-y-old", "user@md-y-old:/home/user/src/y"},
+		{"remote", "add", "md-mirror", "https://example.com/y"},
+	} {
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	got, err := orphanRemotes(ctx, dir, []string{"md-y-main"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"md-y-old"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
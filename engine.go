@@ -0,0 +1,185 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// ContainerEngine is a container engine md drives through its CLI. Docker and
+// Podman take the same commands for almost everything md does;
+// ContainerEngine holds where they differ, so call sites don't compare
+// engine names. Get one with [Client.Engine] or [LookupEngine].
+type ContainerEngine interface {
+	// Name is the engine's executable, the value of [Client.Runtime].
+	Name() string
+
+	// endpoint returns the engine endpoint configured in the environment,
+	// "" for the local engine.
+	endpoint(ctx context.Context, home string) string
+	// runArgs returns the engine-specific arguments of the run command
+	// starting an md container.
+	runArgs() []string
+	// overlayMountArgs returns the run arguments mounting hostPath at
+	// containerPath as an overlay whose upper and work directories are in
+	// upperDir.
+	overlayMountArgs(hostPath, containerPath, upperDir string) ([]string, error)
+	// remoteBuilders reports whether builds can be delegated to a buildx
+	// builder.
+	remoteBuilders() bool
+	// composeWait reports whether compose up supports --wait.
+	composeWait() bool
+	// versionFormat is the info --format template printing the server
+	// version.
+	versionFormat() string
+	// minVersion is the oldest version supporting the --build-context flag
+	// md builds images with.
+	minVersion() string
+	// accessFix is the fix suggested when the engine can't be reached.
+	accessFix() string
+}
+
+// engines are the supported container engines.
+var engines = []ContainerEngine{dockerEngine{}, podmanEngine{}}
+
+// LookupEngine returns the engine named name, "docker" or "podman".
+func LookupEngine(name string) (ContainerEngine, error) {
+	for _, e := range engines {
+		if e.Name() == name {
+			return e, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid container engine %q: use docker or podman", name)
+}
+
+// Engine returns the engine of c.Runtime.
+func (c *Client) Engine() ContainerEngine {
+	return engineFor(c.Runtime)
+}
+
+// engineFor returns the engine named rt. Anything else, e.g. a test's fake
+// engine, behaves like docker.
+func engineFor(rt string) ContainerEngine {
+	if e, err := LookupEngine(rt); err == nil {
+		return e
+	}
+	return dockerEngine{}
+}
+
+// dockerEngine is Docker, Docker Desktop included.
+type dockerEngine struct{}
+
+func (dockerEngine) Name() string { return "docker" }
+
+// endpoint returns $DOCKER_HOST, else the current docker context's.
+func (dockerEngine) endpoint(ctx context.Context, home string) string {
+	if h := os.Getenv("DOCKER_HOST"); h != "" {
+		return h
+	}
+	return dockerContextHost(ctx, home)
+}
+
+// runArgs disables AppArmor's mandatory-access-control profile so Chrome
+// can create namespaces and sandboxed processes can access /proc. Rootless
+// Docker is handled inside start.sh via /proc/self/uid_map detection since
+// Docker lacks --userns=keep-id.
+func (dockerEngine) runArgs() []string {
+	return []string{"--security-opt", "apparmor=unconfined"}
+}
+
+// overlayMountArgs mounts a volume of the local driver, mounted by the
+// daemon, which must run on the same machine as the checkout.
+func (dockerEngine) overlayMountArgs(hostPath, containerPath, upperDir string) ([]string, error) {
+	if runtime.GOOS != "linux" {
+		return nil, fmt.Errorf("--mount-src=overlay needs podman on %s; Docker Desktop can't overlay host directories", runtime.GOOS)
+	}
+	upper, work, err := makeOverlayDirs(upperDir)
+	if err != nil {
+		return nil, err
+	}
+	// --mount is CSV: the overlay options contain commas so the field is
+	// quoted.
+	opt := "lowerdir=" + hostPath + ",upperdir=" + upper + ",workdir=" + work
+	return []string{"--mount", "type=volume,dst=" + containerPath +
+		",volume-driver=local,volume-opt=type=overlay,volume-opt=device=overlay," +
+		`"volume-opt=o=` + opt + `"`}, nil
+}
+
+func (dockerEngine) remoteBuilders() bool { return true }
+
+func (dockerEngine) composeWait() bool { return true }
+
+func (dockerEngine) versionFormat() string { return "{{.ServerVersion}}" }
+
+func (dockerEngine) minVersion() string { return "23.0" }
+
+func (dockerEngine) accessFix() string {
+	fix := "start the docker daemon and make sure your user may access it"
+	if runtime.GOOS == "linux" {
+		fix += " (sudo usermod -aG docker $USER)"
+	}
+	return fix
+}
+
+// podmanEngine is Podman, rootful or rootless.
+type podmanEngine struct{}
+
+func (podmanEngine) Name() string { return "podman" }
+
+// endpoint returns $CONTAINER_HOST.
+func (podmanEngine) endpoint(context.Context, string) string {
+	return os.Getenv("CONTAINER_HOST")
+}
+
+// runArgs maps, for rootless podman, the host UID to the same UID inside
+// the container so bind-mounted configs are writable; --user 0:0 keeps
+// start.sh running as root for privileged setup (groupmod, sshd, dbus).
+// Podman uses SELinux, and passing apparmor=unconfined can hang on kernel
+// security filesystem access.
+func (e podmanEngine) runArgs() []string {
+	if isRootlessPodman(e.Name()) {
+		return []string{"--userns=keep-id", "--user", "0:0"}
+	}
+	return nil
+}
+
+// overlayMountArgs uses podman's native overlay mounts, the ":O" option.
+func (podmanEngine) overlayMountArgs(hostPath, containerPath, upperDir string) ([]string, error) {
+	upper, work, err := makeOverlayDirs(upperDir)
+	if err != nil {
+		return nil, err
+	}
+	return []string{"-v", hostPath + ":" + containerPath + ":O,upperdir=" + upper + ",workdir=" + work}, nil
+}
+
+func (podmanEngine) remoteBuilders() bool { return false }
+
+func (podmanEngine) composeWait() bool { return false }
+
+func (podmanEngine) versionFormat() string { return "{{.Version.Version}}" }
+
+func (podmanEngine) minVersion() string { return "4.3" }
+
+func (podmanEngine) accessFix() string {
+	return "start the podman daemon and make sure your user may access it"
+}
+
+// makeOverlayDirs creates the upper and work directories of an overlay in
+// upperDir. The upper layer lives on the host so the changes survive md
+// stop.
+func makeOverlayDirs(upperDir string) (upper, work string, err error) {
+	upper = filepath.Join(upperDir, "upper")
+	work = filepath.Join(upperDir, "work")
+	for _, d := range []string{upper, work} {
+		if err := os.MkdirAll(d, 0o700); err != nil {
+			return "", "", err
+		}
+	}
+	return upper, work, nil
+}
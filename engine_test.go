@@ -0,0 +1,50 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestLookupEngine(t *testing.T) {
+	for _, name := range []string{"docker", "podman"} {
+		e, err := LookupEngine(name)
+		if err != nil || e.Name() != name {
+			t.Errorf("LookupEngine(%q) = %v, %v", name, e, err)
+		}
+	}
+	if _, err := LookupEngine("nerdctl"); err == nil {
+		t.Error("expected error")
+	}
+	// A fake engine behaves like docker.
+	if e := (&Client{Runtime: "/tmp/fake-engine"}).Engine(); e.Name() != "docker" {
+		t.Errorf("got %s", e.Name())
+	}
+}
+
+func TestEngineDifferences(t *testing.T) {
+	docker, podman := engineFor("docker"), engineFor("podman")
+	if !slices.Contains(docker.runArgs(), "apparmor=unconfined") || slices.Contains(podman.runArgs(), "apparmor=unconfined") {
+		t.Errorf("runArgs: docker %q, podman %q", docker.runArgs(), podman.runArgs())
+	}
+	if !docker.remoteBuilders() || podman.remoteBuilders() {
+		t.Error("only docker has buildx builders")
+	}
+	if !docker.composeWait() || podman.composeWait() {
+		t.Error("only docker compose has --wait")
+	}
+	if docker.minVersion() != "23.0" || podman.minVersion() != "4.3" {
+		t.Errorf("minVersion: docker %s, podman %s", docker.minVersion(), podman.minVersion())
+	}
+	t.Setenv("DOCKER_HOST", "ssh://docker-box")
+	t.Setenv("CONTAINER_HOST", "ssh://podman-box")
+	if got := docker.endpoint(t.Context(), t.TempDir()); got != "ssh://docker-box" {
+		t.Errorf("docker endpoint %q", got)
+	}
+	if got := podman.endpoint(t.Context(), t.TempDir()); got != "ssh://podman-box" {
+		t.Errorf("podman endpoint %q", got)
+	}
+}
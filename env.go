@@ -0,0 +1,429 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// DefaultEnvFile is the [EnvStore] file injected into every container.
+const DefaultEnvFile = "default"
+
+// Env injection modes, see [ValidateEnvInject].
+const (
+	// EnvInjectFile appends the variables to the container's ~/.env, mode
+	// 0600, sourced by its shells.
+	EnvInjectFile = "file"
+	// EnvInjectEngine passes the variables to the engine with --env-file:
+	// they are never written in the container but are only in the
+	// environment of the processes started by its entrypoint, not of SSH
+	// sessions, and the engine shows them in its inspect output.
+	EnvInjectEngine = "engine"
+)
+
+// ValidateEnvInject returns an error if mode isn't an env injection mode.
+func ValidateEnvInject(mode string) error {
+	if mode != EnvInjectFile && mode != EnvInjectEngine {
+		return fmt.Errorf("invalid env injection %q: use %s or %s", mode, EnvInjectFile, EnvInjectEngine)
+	}
+	return nil
+}
+
+var (
+	reEnvKey  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	reEnvFile = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+)
+
+// keychainPrefix marks the variables of an env file whose value is in the
+// OS keychain. It is a shell comment, so the file stays valid for sh.
+const keychainPrefix = "# keychain: "
+
+// EnvVar is a variable of an [EnvStore] file.
+type EnvVar struct {
+	Key string `json:"key"`
+	// Value is empty when listing a variable kept in the keychain.
+	Value string `json:"value,omitempty"`
+	// Keychain is set when the value is kept in the OS keychain instead of
+	// the file.
+	Keychain bool `json:"keychain,omitempty"`
+}
+
+// EnvStore holds named env files on the host, outside the repositories, for
+// the secrets to inject into containers. Unlike a repository's .env, they
+// can't be committed by mistake, and their values may be kept in the OS
+// keychain so they never hit the disk unencrypted.
+type EnvStore struct {
+	// Dir holds the files, <name>.env, mode 0600.
+	Dir string
+
+	// keychain keeps the values out of the files; nil when the OS has none.
+	keychain keychain
+}
+
+// NewEnvStore returns the env store in xdgStateHome/md/env: unlike
+// $XDG_CONFIG_HOME/md, it isn't mounted in the containers.
+func NewEnvStore(xdgStateHome string) *EnvStore {
+	return &EnvStore{Dir: filepath.Join(xdgStateHome, "md", "env"), keychain: osKeychain()}
+}
+
+// DefaultEnvStore returns [NewEnvStore] for the current user, honoring
+// $XDG_STATE_HOME.
+func DefaultEnvStore() (*EnvStore, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	return NewEnvStore(envOr("XDG_STATE_HOME", filepath.Join(home, ".local", "state"))), nil
+}
+
+// Files returns the names of the env files, sorted.
+func (s *EnvStore) Files() ([]string, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if name, ok := strings.CutSuffix(e.Name(), ".env"); ok && !e.IsDir() {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// List returns the variables of the env file name, in file order. The values
+// kept in the keychain aren't retrieved. A missing file has no variables.
+func (s *EnvStore) List(name string) ([]EnvVar, error) {
+	p, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	vars, err := parseEnvFile(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p, err)
+	}
+	return vars, nil
+}
+
+// Set sets key to value in the env file name, creating it as needed. With
+// inKeychain, value goes in the OS keychain and the file only records the
+// key.
+func (s *EnvStore) Set(ctx context.Context, name, key, value string, inKeychain bool) error {
+	if !reEnvKey.MatchString(key) {
+		return fmt.Errorf("invalid variable name %q", key)
+	}
+	vars, err := s.List(name)
+	if err != nil {
+		return err
+	}
+	v := EnvVar{Key: key, Value: value}
+	if inKeychain {
+		if s.keychain == nil {
+			return fmt.Errorf("no keychain on %s", runtime.GOOS)
+		}
+		if err := s.keychain.set(ctx, name+"/"+key, value); err != nil {
+			return fmt.Errorf("storing %s in the keychain: %w", key, err)
+		}
+		v = EnvVar{Key: key, Keychain: true}
+	}
+	if i := slices.IndexFunc(vars, func(e EnvVar) bool { return e.Key == key }); i >= 0 {
+		if vars[i].Keychain && !inKeychain {
+			s.removeKeychain(ctx, name, key)
+		}
+		vars[i] = v
+	} else {
+		vars = append(vars, v)
+	}
+	return s.write(name, vars)
+}
+
+// Unset removes key from the env file name, and its value from the
+// keychain.
+func (s *EnvStore) Unset(ctx context.Context, name, key string) error {
+	vars, err := s.List(name)
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(vars, func(e EnvVar) bool { return e.Key == key })
+	if i < 0 {
+		return fmt.Errorf("%s is not set in %s", key, name)
+	}
+	if vars[i].Keychain {
+		s.removeKeychain(ctx, name, key)
+	}
+	return s.write(name, slices.Delete(vars, i, i+1))
+}
+
+// Resolve returns the variables of the env files names, in order, with the
+// values kept in the keychain retrieved. A later file overrides the
+// variables of an earlier one. [DefaultEnvFile] may be missing, other files
+// must exist.
+func (s *EnvStore) Resolve(ctx context.Context, names []string) ([]EnvVar, error) {
+	var out []EnvVar
+	for _, name := range names {
+		p, err := s.path(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(p); err != nil {
+			if name == DefaultEnvFile && errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, fmt.Errorf("env file %q: %w", name, err)
+		}
+		vars, err := s.List(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range vars {
+			if v.Keychain {
+				if s.keychain == nil {
+					return nil, fmt.Errorf("%s is in the keychain but there is none on %s", v.Key, runtime.GOOS)
+				}
+				if v.Value, err = s.keychain.get(ctx, name+"/"+v.Key); err != nil {
+					return nil, fmt.Errorf("reading %s from the keychain: %w", v.Key, err)
+				}
+				v.Keychain = false
+			}
+			out = slices.DeleteFunc(out, func(e EnvVar) bool { return e.Key == v.Key })
+			out = append(out, v)
+		}
+	}
+	return out, nil
+}
+
+func (s *EnvStore) path(name string) (string, error) {
+	if !reEnvFile.MatchString(name) {
+		return "", fmt.Errorf("invalid env file name %q", name)
+	}
+	return filepath.Join(s.Dir, name+".env"), nil
+}
+
+// write replaces the env file name with vars, atomically.
+func (s *EnvStore) write(name string, vars []EnvVar) error {
+	p, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.Dir, 0o700); err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	for _, v := range vars {
+		if v.Keychain {
+			b.WriteString(keychainPrefix + v.Key + "\n")
+		} else {
+			b.WriteString(v.Key + "=" + shellQuote(v.Value) + "\n")
+		}
+	}
+	f, err := os.CreateTemp(s.Dir, name+".env.*")
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(b.Bytes()); err == nil {
+		err = f.Chmod(0o600)
+	}
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), p)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
+
+func (s *EnvStore) removeKeychain(ctx context.Context, name, key string) {
+	if s.keychain == nil {
+		return
+	}
+	// The entry may already be gone; the file is what lists the variables.
+	_ = s.keychain.remove(ctx, name+"/"+key)
+}
+
+// parseEnvFile parses the lines written by [EnvStore.write]: KEY=VALUE with
+// a sh-quoted VALUE, keychain markers, blank lines and comments.
+func parseEnvFile(data []byte) ([]EnvVar, error) {
+	var vars []EnvVar
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if key, ok := strings.CutPrefix(line, keychainPrefix); ok {
+			vars = append(vars, EnvVar{Key: strings.TrimSpace(key), Keychain: true})
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		if !ok || !reEnvKey.MatchString(key) {
+			return nil, fmt.Errorf("line %d: want KEY=VALUE", i+1)
+		}
+		v, err := shellUnquote(value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		vars = append(vars, EnvVar{Key: key, Value: v})
+	}
+	return vars, nil
+}
+
+// shellUnquote reverses [shellQuote]: it concatenates bare words,
+// backslash escapes and single-quoted strings.
+func shellUnquote(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return "", errors.New("unterminated quote")
+			}
+			b.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				b.WriteByte(s[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), nil
+}
+
+// EnvLines returns vars as KEY=VALUE lines for the container's ~/.env, the
+// values sh-quoted.
+func EnvLines(vars []EnvVar) []string {
+	out := make([]string, len(vars))
+	for i, v := range vars {
+		out[i] = v.Key + "=" + shellQuote(v.Value)
+	}
+	return out
+}
+
+// WriteEngineEnvFile writes vars in a new temporary file, mode 0600, in the
+// format of the engine's --env-file, where values are taken literally. The
+// caller removes the file once the container is created.
+func WriteEngineEnvFile(vars []EnvVar) (string, error) {
+	var b bytes.Buffer
+	for _, v := range vars {
+		if strings.ContainsAny(v.Value, "\r\n") {
+			return "", fmt.Errorf("%s: --env-file values can't span lines; use the %s injection", v.Key, EnvInjectFile)
+		}
+		b.WriteString(v.Key + "=" + v.Value + "\n")
+	}
+	f, err := os.CreateTemp("", "md-env-*")
+	if err != nil {
+		return "", err
+	}
+	// CreateTemp already uses mode 0600.
+	_, err = f.Write(b.Bytes())
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// keychain stores secrets in the OS keychain, under the service "md".
+type keychain interface {
+	get(ctx context.Context, account string) (string, error)
+	set(ctx context.Context, account, value string) error
+	remove(ctx context.Context, account string) error
+}
+
+// osKeychain returns the keychain of the OS, nil when there is none: the
+// macOS login keychain through security, or the Secret Service (GNOME
+// Keyring, KWallet) through secret-tool.
+func osKeychain() keychain {
+	switch runtime.GOOS {
+	case "darwin":
+		return securityKeychain{}
+	case "linux":
+		if _, err := exec.LookPath("secret-tool"); err == nil {
+			return secretToolKeychain{}
+		}
+	}
+	return nil
+}
+
+// securityKeychain is the macOS keychain.
+type securityKeychain struct{}
+
+func (securityKeychain) get(ctx context.Context, account string) (string, error) {
+	cmd := exec.CommandContext(ctx, "security", "find-generic-password", "-s", "md", "-a", account, "-w")
+	cmd.WaitDelay = cmdWaitDelay
+	out, err := cmd.Output()
+	if err != nil {
+		return "", cmdErrWithStderr("security", err)
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+func (securityKeychain) set(ctx context.Context, account, value string) error {
+	// security only reads the password from its command line or a prompt.
+	// Not through runCmd, which logs the command line.
+	cmd := exec.CommandContext(ctx, "security", "add-generic-password", "-U", "-s", "md", "-a", account, "-w", value)
+	cmd.WaitDelay = cmdWaitDelay
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("security: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (securityKeychain) remove(ctx context.Context, account string) error {
+	_, err := runCmd(ctx, "", []string{"security", "delete-generic-password", "-s", "md", "-a", account})
+	return cmdErrWithStderr("security", err)
+}
+
+// secretToolKeychain is the freedesktop Secret Service.
+type secretToolKeychain struct{}
+
+func (secretToolKeychain) get(ctx context.Context, account string) (string, error) {
+	cmd := exec.CommandContext(ctx, "secret-tool", "lookup", "service", "md", "account", account)
+	cmd.WaitDelay = cmdWaitDelay
+	out, err := cmd.Output()
+	if err != nil {
+		return "", cmdErrWithStderr("secret-tool", err)
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+func (secretToolKeychain) set(ctx context.Context, account, value string) error {
+	cmd := exec.CommandContext(ctx, "secret-tool", "store", "--label", "md "+account, "service", "md", "account", account)
+	cmd.Stdin = strings.NewReader(value)
+	cmd.WaitDelay = cmdWaitDelay
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("secret-tool: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (secretToolKeychain) remove(ctx context.Context, account string) error {
+	_, err := runCmd(ctx, "", []string{"secret-tool", "clear", "service", "md", "account", account})
+	return cmdErrWithStderr("secret-tool", err)
+}
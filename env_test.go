@@ -0,0 +1,152 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+type fakeKeychain map[string]string
+
+func (k fakeKeychain) get(_ context.Context, account string) (string, error) {
+	v, ok := k[account]
+	if !ok {
+		return "", errors.New("not found")
+	}
+	return v, nil
+}
+
+func (k fakeKeychain) set(_ context.Context, account, value string) error {
+	k[account] = value
+	return nil
+}
+
+func (k fakeKeychain) remove(_ context.Context, account string) error {
+	delete(k, account)
+	return nil
+}
+
+func TestEnvStore(t *testing.T) {
+	ctx := t.Context()
+	kc := fakeKeychain{}
+	s := &EnvStore{Dir: filepath.Join(t.TempDir(), "env"), keychain: kc}
+	if vars, err := s.Resolve(ctx, []string{DefaultEnvFile}); err != nil || len(vars) != 0 {
+		t.Fatalf("missing default: %v, %v", vars, err)
+	}
+	if _, err := s.Resolve(ctx, []string{"work"}); err == nil {
+		t.Error("missing file: expected error")
+	}
+	for _, kv := range []struct{ file, key, value string }{
+		{DefaultEnvFile, "A", "it's a \"value\" $HOME"},
+		{DefaultEnvFile, "B", "b"},
+		{"work", "B", "work b"},
+	} {
+		if err := s.Set(ctx, kv.file, kv.key, kv.value, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Set(ctx, DefaultEnvFile, "TOKEN", "secret", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set(ctx, DefaultEnvFile, "B", "b2", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set(ctx, DefaultEnvFile, "1BAD", "x", false); err == nil {
+		t.Error("invalid key: expected error")
+	}
+	if err := s.Set(ctx, "../x", "A", "x", false); err == nil {
+		t.Error("invalid file: expected error")
+	}
+
+	p := filepath.Join(s.Dir, DefaultEnvFile+".env")
+	data, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret") {
+		t.Errorf("the keychain value is in the file:\n%s", data)
+	}
+	if runtime.GOOS != "windows" {
+		if fi, err := os.Stat(p); err != nil || fi.Mode().Perm() != 0o600 {
+			t.Errorf("mode: %v, %v", fi.Mode(), err)
+		}
+	}
+	if files, err := s.Files(); err != nil || !slices.Equal(files, []string{DefaultEnvFile, "work"}) {
+		t.Errorf("Files: %q, %v", files, err)
+	}
+	got, err := s.List(DefaultEnvFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []EnvVar{{Key: "A", Value: "it's a \"value\" $HOME"}, {Key: "B", Value: "b2"}, {Key: "TOKEN", Keychain: true}}
+	if !slices.Equal(got, want) {
+		t.Errorf("List:\n got %+v\nwant %+v", got, want)
+	}
+	got, err = s.Resolve(ctx, []string{DefaultEnvFile, "work"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []EnvVar{{Key: "A", Value: "it's a \"value\" $HOME"}, {Key: "TOKEN", Value: "secret"}, {Key: "B", Value: "work b"}}
+	if !slices.Equal(got, want) {
+		t.Errorf("Resolve:\n got %+v\nwant %+v", got, want)
+	}
+	if lines := EnvLines(got[:1]); !slices.Equal(lines, []string{`A='it'\''s a "value" $HOME'`}) {
+		t.Errorf("EnvLines: %q", lines)
+	}
+
+	if err := s.Unset(ctx, DefaultEnvFile, "TOKEN"); err != nil {
+		t.Fatal(err)
+	}
+	if len(kc) != 0 {
+		t.Errorf("keychain: %v", kc)
+	}
+	if err := s.Unset(ctx, DefaultEnvFile, "TOKEN"); err == nil {
+		t.Error("unset twice: expected error")
+	}
+	s.keychain = nil
+	if err := s.Set(ctx, DefaultEnvFile, "TOKEN", "secret", true); err == nil {
+		t.Error("no keychain: expected error")
+	}
+}
+
+func TestShellUnquote(t *testing.T) {
+	for _, v := range []string{"", "plain", "a b", "it's", `'\''`, "$(x) `y` \\z", "line\nbreak"} {
+		got, err := shellUnquote(shellQuote(v))
+		if err != nil || got != v {
+			t.Errorf("%q: got %q, %v", v, got, err)
+		}
+	}
+	if _, err := shellUnquote("'open"); err == nil {
+		t.Error("expected error")
+	}
+	vars, err := parseEnvFile([]byte("# comment\nexport A=1\n\nB='x y'\n"))
+	if err != nil || !slices.Equal(vars, []EnvVar{{Key: "A", Value: "1"}, {Key: "B", Value: "x y"}}) {
+		t.Errorf("got %+v, %v", vars, err)
+	}
+	if _, err := parseEnvFile([]byte("not a variable\n")); err == nil || err.Error() != "line 1: want KEY=VALUE" {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestWriteEngineEnvFile(t *testing.T) {
+	p, err := WriteEngineEnvFile([]EnvVar{{Key: "A", Value: "it's 'raw'"}, {Key: "B", Value: ""}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Remove(p) }()
+	if data, _ := os.ReadFile(p); string(data) != "A=it's 'raw'\nB=\n" {
+		t.Errorf("got %q", data)
+	}
+	if _, err := WriteEngineEnvFile([]EnvVar{{Key: "A", Value: "a\nb"}}); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"slices"
+	"sync"
+	"time"
+)
+
+// exposureProbeTimeout bounds each connection attempt of probeExposure. The
+// addresses are the host's own so an answer is immediate.
+const exposureProbeTimeout = 300 * time.Millisecond
+
+// checkBind validates a [StartOpts.Bind] address.
+func checkBind(bind string) error {
+	if bind != "" && net.ParseIP(bind) == nil {
+		return fmt.Errorf("invalid bind address %q: want an IP address", bind)
+	}
+	return nil
+}
+
+// publishIP returns the host IP of the runtime's -p argument for bind.
+func publishIP(bind string) string {
+	ip := net.ParseIP(bind)
+	if ip == nil {
+		return "127.0.0.1"
+	}
+	if ip.To4() == nil {
+		return "[" + ip.String() + "]"
+	}
+	return ip.String()
+}
+
+// dialHost returns the address to connect to from this machine for ports
+// published on bind: 127.0.0.1 when they are published on all interfaces.
+func dialHost(bind string) string {
+	ip := net.ParseIP(bind)
+	if ip == nil || ip.IsUnspecified() {
+		return "127.0.0.1"
+	}
+	return ip.String()
+}
+
+// isExposedBind reports whether bind deliberately exposes the ports to other
+// machines.
+func isExposedBind(bind string) bool {
+	ip := net.ParseIP(bind)
+	return ip != nil && !ip.IsLoopback()
+}
+
+// checkExposure verifies once the container started that its ports are only
+// reachable from this machine. md binds them to 127.0.0.1 but an engine can
+// ignore the address, e.g. with rootless networking or a VM's port
+// forwarder, and a forwarding rule on the host can expose them anyway. The
+// warning is printed even when quiet since the container accepts DevTools
+// connections without authentication.
+func checkExposure(ctx context.Context, stdout, stderr io.Writer, c *Container, info *containerInfo, quiet bool) {
+	if isExposedBind(c.Bind) {
+		if !quiet {
+			_, _ = fmt.Fprintf(stdout, "- Ports published on %s: reachable from other machines\n", c.Bind)
+		}
+		return
+	}
+	ports := info.NetworkSettings.Ports
+	exposed := exposedBindings(ports)
+	if c.RemoteHost == "" {
+		// The bindings of a remote engine can't be probed from here.
+		exposed = append(exposed, probeExposure(ctx, hostAddrs(), ports)...)
+	}
+	if len(exposed) == 0 {
+		return
+	}
+	_, _ = fmt.Fprintf(stderr, "WARNING: ports of %s are reachable from other machines:\n", c.Name)
+	for _, e := range exposed {
+		_, _ = fmt.Fprintf(stderr, "  %s\n", e)
+	}
+	_, _ = fmt.Fprintln(stderr, "md binds them to 127.0.0.1 but the engine or the host's network configuration exposes them.")
+	_, _ = fmt.Fprintln(stderr, "Block them in the host firewall (for docker, in the DOCKER-USER iptables chain) or")
+	_, _ = fmt.Fprintln(stderr, "pass --bind to md start to expose them on purpose.")
+}
+
+// exposedBindings returns the bindings in ports that aren't on a loopback
+// address, as "host:port (container port)".
+func exposedBindings(ports map[string][]portBinding) []string {
+	var out []string
+	for _, k := range slices.Sorted(maps.Keys(ports)) {
+		for _, b := range ports[k] {
+			if ip := net.ParseIP(b.HostIP); ip == nil || !ip.IsLoopback() {
+				out = append(out, fmt.Sprintf("%s (%s)", net.JoinHostPort(cmp.Or(b.HostIP, "0.0.0.0"), b.HostPort), k))
+			}
+		}
+	}
+	return out
+}
+
+// probeExposure connects to the loopback bindings in ports on each of addrs,
+// the host's other addresses, and returns those that answer as
+// "host:port (container port)".
+func probeExposure(ctx context.Context, addrs []net.IP, ports map[string][]portBinding) []string {
+	var mu sync.Mutex
+	var out []string
+	var wg sync.WaitGroup
+	dialer := net.Dialer{Timeout: exposureProbeTimeout}
+	for k, bindings := range ports {
+		for _, b := range bindings {
+			if ip := net.ParseIP(b.HostIP); ip == nil || !ip.IsLoopback() {
+				// Already reported by exposedBindings.
+				continue
+			}
+			for _, a := range addrs {
+				addr := net.JoinHostPort(a.String(), b.HostPort)
+				wg.Go(func() {
+					conn, err := dialer.DialContext(ctx, "tcp", addr)
+					if err != nil {
+						return
+					}
+					_ = conn.Close()
+					mu.Lock()
+					out = append(out, fmt.Sprintf("%s (%s)", addr, k))
+					mu.Unlock()
+				})
+			}
+		}
+	}
+	wg.Wait()
+	slices.Sort(out)
+	return out
+}
+
+// hostAddrs returns the host's addresses other machines may reach it on.
+func hostAddrs() []net.IP {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	var out []net.IP
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok || ipnet.IP.IsLoopback() || ipnet.IP.IsLinkLocalUnicast() || ipnet.IP.IsUnspecified() {
+			continue
+		}
+		out = append(out, ipnet.IP)
+	}
+	return out
+}
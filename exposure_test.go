@@ -0,0 +1,100 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestBindAddresses(t *testing.T) {
+	for _, tc := range []struct {
+		bind    string
+		publish string
+		dial    string
+		exposed bool
+	}{
+		{"", "127.0.0.1", "127.0.0.1", false},
+		{"127.0.0.1", "127.0.0.1", "127.0.0.1", false},
+		{"::1", "[::1]", "::1", false},
+		{"0.0.0.0", "0.0.0.0", "127.0.0.1", true},
+		{"::", "[::]", "127.0.0.1", true},
+		{"192.168.1.5", "192.168.1.5", "192.168.1.5", true},
+	} {
+		if got := publishIP(tc.bind); got != tc.publish {
+			t.Errorf("publishIP(%q) = %q, want %q", tc.bind, got, tc.publish)
+		}
+		if got := dialHost(tc.bind); got != tc.dial {
+			t.Errorf("dialHost(%q) = %q, want %q", tc.bind, got, tc.dial)
+		}
+		if got := isExposedBind(tc.bind); got != tc.exposed {
+			t.Errorf("isExposedBind(%q) = %t, want %t", tc.bind, got, tc.exposed)
+		}
+	}
+	if err := checkBind("localhost"); err == nil {
+		t.Error("expected error for a host name")
+	}
+}
+
+func TestExposedBindings(t *testing.T) {
+	ports := map[string][]portBinding{
+		"22/tcp":   {{HostIP: "127.0.0.1", HostPort: "32768"}},
+		"5901/tcp": {{HostIP: "0.0.0.0", HostPort: "32769"}, {HostIP: "::", HostPort: "32769"}},
+		"9222/tcp": {{HostIP: "", HostPort: "32770"}},
+	}
+	want := []string{"0.0.0.0:32769 (5901/tcp)", "[::]:32769 (5901/tcp)", "0.0.0.0:32770 (9222/tcp)"}
+	if got := exposedBindings(ports); !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestProbeExposure(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	port := strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+	ports := map[string][]portBinding{"22/tcp": {{HostIP: "127.0.0.1", HostPort: port}}}
+	// Loopback stands in for the host's other addresses: a listener that
+	// answers on one of them is reported.
+	got := probeExposure(t.Context(), []net.IP{net.IPv4(127, 0, 0, 1)}, ports)
+	if want := []string{"127.0.0.1:" + port + " (22/tcp)"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	_ = ln.Close()
+	if got := probeExposure(t.Context(), []net.IP{net.IPv4(127, 0, 0, 1)}, ports); len(got) != 0 {
+		t.Errorf("closed port: got %q", got)
+	}
+}
+
+func TestWriteSSHConfigHost(t *testing.T) {
+	dir := t.TempDir()
+	ep := sshEndpoint{Host: "fd00::5", Port: 32768}
+	known := filepath.Join(dir, "md-repo-main.known_hosts")
+	if err := writeSSHConfig(dir, "md-repo-main", ep, "/home/me/.ssh/md", known, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeKnownHosts(known, "md-repo-main", "ssh-ed25519 AAAA"); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := os.ReadFile(filepath.Join(dir, "md-repo-main.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"  HostName fd00::5\n", "  Port 32768\n", "  AddressFamily inet6\n", "  HostKeyAlias md-repo-main\n", "  CheckHostIP no\n"} {
+		if !strings.Contains(string(conf), want) {
+			t.Errorf("missing %q in:\n%s", want, conf)
+		}
+	}
+	if got, err := os.ReadFile(known); err != nil || !knownHostsHas(got, "md-repo-main") || !strings.HasSuffix(string(got), " ssh-ed25519 AAAA\n") {
+		t.Errorf("known_hosts = %q, %v", got, err)
+	}
+}
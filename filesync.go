@@ -0,0 +1,249 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/caic-xyz/md/gitutil"
+)
+
+// DefaultSyncInterval is how often [Container.SyncFiles] scans the working
+// tree when watching.
+const DefaultSyncInterval = time.Second
+
+// SyncFilesOpts configures [Container.SyncFiles].
+type SyncFilesOpts struct {
+	// Watch keeps syncing the changes until ctx is canceled.
+	Watch bool
+	// Interval is how often the working tree is scanned with Watch. Zero
+	// means DefaultSyncInterval.
+	Interval time.Duration
+}
+
+// SyncFiles copies the host's uncommitted changes in Repos[repoIdx],
+// untracked files included and ignored ones excluded, into the container's
+// checkout, and removes there the files deleted on the host. Files are
+// compared by size and modification time; only the ones that changed since
+// the previous scan are sent. It doesn't commit anything on either side, nor
+// bring the container's changes back. opts may be nil.
+func (c *Container) SyncFiles(ctx context.Context, stdout, stderr io.Writer, repoIdx int, opts *SyncFilesOpts) error {
+	if opts == nil {
+		opts = &SyncFilesOpts{}
+	}
+	if len(c.Repos) == 0 {
+		return errors.New("container has no repos")
+	}
+	if repoIdx < 0 || repoIdx >= len(c.Repos) {
+		return fmt.Errorf("repo index %d out of range [0, %d)", repoIdx, len(c.Repos))
+	}
+	if err := c.checkOwnsGit("sync to"); err != nil {
+		return err
+	}
+	r := c.Repos[repoIdx]
+	if gitutil.HgSource(ctx, r.GitRoot) != "" {
+		return errors.New("syncing files isn't supported with Mercurial: md works on a git mirror of the committed changes")
+	}
+	if err := c.checkContainerState(ctx); err != nil {
+		return err
+	}
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = DefaultSyncInterval
+	}
+	s := &fileSyncer{dir: r.GitRoot, seen: map[string]fileStamp{}}
+	head, _ := gitutil.RevParse(ctx, r.GitRoot, "HEAD")
+	for {
+		send, remove, err := s.scan(ctx)
+		if err != nil {
+			return err
+		}
+		if len(send) != 0 || len(remove) != 0 {
+			c.touch()
+			if err := c.sendFiles(ctx, stderr, &r, send, remove); err != nil {
+				return err
+			}
+			_, _ = fmt.Fprintf(stdout, "- Synced %d file(s), removed %d\n", len(send), len(remove))
+		}
+		if !opts.Watch {
+			return nil
+		}
+		// Files changed by a commit or a checkout on the host aren't
+		// uncommitted changes anymore.
+		if now, _ := gitutil.RevParse(ctx, r.GitRoot, "HEAD"); now != head {
+			head = now
+			_, _ = fmt.Fprintf(stderr, "HEAD moved to %s on the host: run md push to update the container's branch\n", now)
+		}
+		if err := sleepCtx(ctx, interval); err != nil {
+			return nil
+		}
+	}
+}
+
+// fileStamp is what a file looked like at a scan.
+type fileStamp struct {
+	deleted bool
+	size    int64
+	modTime time.Time
+	mode    fs.FileMode
+}
+
+// fileSyncer finds the files of a working tree to send to the container.
+type fileSyncer struct {
+	dir string
+	// seen is the state of the files at the previous scan, as sent.
+	seen map[string]fileStamp
+}
+
+// scan returns the paths, relative to the working tree's root, of the
+// uncommitted files that changed since the previous scan: the ones to send
+// and the ones deleted. A file that stops being uncommitted, e.g. reverted,
+// is sent once more as it is now.
+func (s *fileSyncer) scan(ctx context.Context) (send, remove []string, err error) {
+	out, err := gitutil.RunGit(ctx, s.dir, "ls-files", "-z", "--modified", "--deleted", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, nil, err
+	}
+	listed := map[string]bool{}
+	for p := range strings.SplitSeq(out, "\x00") {
+		if p != "" {
+			listed[p] = true
+		}
+	}
+	paths := slices.Collect(maps.Keys(listed))
+	for p := range s.seen {
+		if !listed[p] {
+			paths = append(paths, p)
+		}
+	}
+	slices.Sort(paths)
+	for _, p := range paths {
+		var st fileStamp
+		fi, err := os.Lstat(filepath.Join(s.dir, filepath.FromSlash(p)))
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			st.deleted = true
+		case err != nil:
+			return nil, nil, err
+		case fi.IsDir():
+			// A submodule.
+			continue
+		default:
+			st = fileStamp{size: fi.Size(), modTime: fi.ModTime(), mode: fi.Mode()}
+		}
+		if prev, ok := s.seen[p]; !ok || prev != st {
+			if st.deleted {
+				remove = append(remove, p)
+			} else {
+				send = append(send, p)
+			}
+		} else if !listed[p] {
+			// Committed or reverted, and sent as such.
+			delete(s.seen, p)
+			continue
+		}
+		s.seen[p] = st
+	}
+	return send, remove, nil
+}
+
+// sendFiles copies the files send of r's working tree into the container's
+// checkout, as a tar archive, and removes the files remove there.
+func (c *Container) sendFiles(ctx context.Context, stderr io.Writer, r *Repo, send, remove []string) error {
+	script := "cd ~/src/" + shellQuote(r.Name())
+	if len(remove) != 0 {
+		quoted := make([]string, len(remove))
+		for i, p := range remove {
+			quoted[i] = shellQuote(p)
+		}
+		script += " && rm -f -- " + strings.Join(quoted, " ")
+	}
+	script += " && tar -xf -"
+	args := c.SSHCommand(c.Name, script)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stderr = stderr
+	cmd.WaitDelay = cmdWaitDelay
+	w, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	err = writeTar(w, r.GitRoot, send)
+	if err2 := w.Close(); err == nil {
+		err = err2
+	}
+	if err2 := cmd.Wait(); err2 != nil {
+		return fmt.Errorf("syncing files to %s: %w", c.Name, err2)
+	}
+	return err
+}
+
+// writeTar writes the files paths of dir to w as a tar archive. A file
+// deleted since it was listed is skipped; the next scan removes it.
+func writeTar(w io.Writer, dir string, paths []string) error {
+	tw := tar.NewWriter(w)
+	for _, p := range paths {
+		if err := addTarFile(tw, dir, p); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func addTarFile(tw *tar.Writer, dir, p string) error {
+	full := filepath.Join(dir, filepath.FromSlash(p))
+	fi, err := os.Lstat(full)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var link string
+	var content []byte
+	switch {
+	case fi.Mode()&fs.ModeSymlink != 0:
+		if link, err = os.Readlink(full); err != nil {
+			return err
+		}
+	case fi.Mode().IsRegular():
+		// Read at once: a file being written may change size while it is
+		// archived. The next scan sends it again.
+		if content, err = os.ReadFile(full); errors.Is(err, os.ErrNotExist) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+	hdr, err := tar.FileInfoHeader(fi, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = p
+	hdr.Size = int64(len(content))
+	// The container's user owns the files.
+	hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = tw.Write(content)
+	return err
+}
@@ -0,0 +1,102 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestFileSyncer(t *testing.T) {
+	ctx := t.Context()
+	dir := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.CommandContext(ctx, "git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@test"}, args...)...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	// Each write gets a distinct modification time.
+	mtime := time.Now().Add(-time.Hour)
+	write := func(name, content string) {
+		t.Helper()
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		mtime = mtime.Add(time.Second)
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git("init", "-q")
+	write(".gitignore", "*.log\n")
+	write("a.txt", "a\n")
+	write("b.txt", "b\n")
+	git("add", ".")
+	git("commit", "-q", "-m", "init")
+
+	s := &fileSyncer{dir: dir, seen: map[string]fileStamp{}}
+	check := func(wantSend, wantRemove []string) {
+		t.Helper()
+		send, remove, err := s.scan(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(send, wantSend) || !slices.Equal(remove, wantRemove) {
+			t.Errorf("got send %q remove %q, want %q %q", send, remove, wantSend, wantRemove)
+		}
+	}
+	check(nil, nil)
+	write("a.txt", "changed\n")
+	write("dir/new.txt", "new\n")
+	write("x.log", "ignored\n")
+	if err := os.Remove(filepath.Join(dir, "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+	check([]string{"a.txt", "dir/new.txt"}, []string{"b.txt"})
+	check(nil, nil)
+	write("dir/new.txt", "newer\n")
+	check([]string{"dir/new.txt"}, nil)
+	// Reverted and deleted files are sent once.
+	git("checkout", "--", "a.txt", "b.txt")
+	if err := os.Remove(filepath.Join(dir, "dir", "new.txt")); err != nil {
+		t.Fatal(err)
+	}
+	check([]string{"a.txt", "b.txt"}, []string{"dir/new.txt"})
+	check(nil, nil)
+	if len(s.seen) != 0 {
+		t.Errorf("seen: %v", s.seen)
+	}
+
+	var buf bytes.Buffer
+	if err := writeTar(&buf, dir, []string{"a.txt", "gone.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(&buf)
+	hdr, err := tr.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(tr)
+	if hdr.Name != "a.txt" || string(b) != "a\n" || hdr.Uid != 0 {
+		t.Errorf("got %+v %q", hdr, b)
+	}
+	if _, err := tr.Next(); err != io.EOF {
+		t.Errorf("more entries: %v", err)
+	}
+}
@@ -0,0 +1,274 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
+// FSChangeCategory classifies a filesystem change found by [Container.FSDiff].
+type FSChangeCategory string
+
+// Filesystem change categories, from most to least actionable.
+const (
+	// FSApt is a Debian package installed or removed with apt.
+	FSApt FSChangeCategory = "apt"
+	// FSNpm is a globally installed npm/bun package.
+	FSNpm FSChangeCategory = "npm"
+	// FSGo is a binary installed with go install.
+	FSGo FSChangeCategory = "go"
+	// FSCargo is a binary installed with cargo install.
+	FSCargo FSChangeCategory = "cargo"
+	// FSPython is a package installed with pip --user or uv tool.
+	FSPython FSChangeCategory = "python"
+	// FSBin is an executable dropped in a bin directory (~/.local/bin,
+	// /usr/local/bin).
+	FSBin FSChangeCategory = "bin"
+	// FSDotfile is a file or directory directly under the user's home or
+	// ~/.config.
+	FSDotfile FSChangeCategory = "dotfile"
+	// FSConfig is a system configuration file under /etc.
+	FSConfig FSChangeCategory = "config"
+	// FSSystem is a change to system directories (/usr, /opt, /var/lib),
+	// usually a side effect of package installs.
+	FSSystem FSChangeCategory = "system"
+	// FSOther is anything else.
+	FSOther FSChangeCategory = "other"
+)
+
+// FSChange is one classified item that differs from the container's image.
+// Several paths may collapse into one item (e.g. every file of an npm
+// package).
+type FSChange struct {
+	// Category is the kind of change.
+	Category FSChangeCategory `json:"category"`
+	// Item identifies what changed within the category: a package name, a
+	// binary name or a path.
+	Item string `json:"item"`
+	// Kind is "added", "changed" or "deleted", taken from the item's
+	// shallowest path.
+	Kind string `json:"kind"`
+	// Path is the item's root path in the container, e.g. the package
+	// directory or the top-level dotfile.
+	Path string `json:"path"`
+	// Paths is the number of filesystem paths that make up the item.
+	Paths int `json:"paths"`
+}
+
+// fsDiffIgnored are path prefixes excluded from FSDiff unless all is set:
+// the git-tracked repos, caches, logs, and per-boot runtime state.
+var fsDiffIgnored = []string{
+	"/dev",
+	"/home/user/.bash_history",
+	"/home/user/.cache",
+	"/home/user/.cargo/git",
+	"/home/user/.cargo/registry",
+	"/home/user/.env",
+	"/home/user/.gradle/caches",
+	"/home/user/.lesshst",
+	"/home/user/.m2/repository",
+	"/home/user/.npm",
+	"/home/user/.viminfo",
+	"/home/user/go/pkg",
+	"/home/user/src",
+	"/proc",
+	"/root/.cache",
+	"/run",
+	"/sys",
+	"/tmp",
+	"/usr/share/doc",
+	"/usr/share/man",
+	"/var/cache",
+	"/var/lib/apt",
+	"/var/lib/dpkg",
+	"/var/lib/md",
+	"/var/log",
+	"/var/run",
+	"/var/tmp",
+}
+
+// FSDiff reports filesystem changes in the container relative to its image
+// that git doesn't track: installed packages and tools, modified dotfiles and
+// system configuration. Repos, caches, logs and temporary files are skipped
+// unless all is true. Bind mounts are never included.
+func (c *Container) FSDiff(ctx context.Context, all bool) ([]FSChange, error) {
+	out, err := runCmd(ctx, "", []string{c.Runtime, "diff", c.Name})
+	if err != nil {
+		return nil, fmt.Errorf("%s diff: %w", c.Runtime, err)
+	}
+	return classifyFSDiff(out, all), nil
+}
+
+// classifyFSDiff parses "docker diff" output ("A /path", "C /path", "D
+// /path") and groups paths into items.
+func classifyFSDiff(out string, all bool) []FSChange {
+	type entry struct {
+		kind byte
+		path string
+	}
+	var entries []entry
+	for line := range strings.SplitSeq(out, "\n") {
+		if len(line) < 3 || line[1] != ' ' {
+			continue
+		}
+		entries = append(entries, entry{line[0], path.Clean(line[2:])})
+	}
+	// A directory is reported whenever something below it changed; its
+	// descendants carry the information.
+	dirs := map[string]struct{}{}
+	for _, e := range entries {
+		for d := path.Dir(e.path); d != "/"; d = path.Dir(d) {
+			dirs[d] = struct{}{}
+		}
+	}
+	type key struct {
+		cat  FSChangeCategory
+		item string
+	}
+	type agg struct {
+		kind  byte
+		depth int
+		root  string
+		paths int
+	}
+	items := map[key]*agg{}
+	for _, e := range entries {
+		if _, ok := dirs[e.path]; ok {
+			continue
+		}
+		if !all && slices.ContainsFunc(fsDiffIgnored, func(p string) bool { return isWithinSlash(e.path, p) }) {
+			// /var/lib/dpkg is ignored as a whole but its file lists still
+			// identify the packages.
+			if cat, item := classifyDpkg(e.path); cat != "" {
+				k := key{cat, item}
+				if items[k] == nil {
+					items[k] = &agg{kind: e.kind, root: e.path}
+				}
+				items[k].paths++
+			}
+			continue
+		}
+		cat, item, root := classifyFSPath(e.path)
+		k := key{cat, item}
+		depth := strings.Count(e.path, "/")
+		a := items[k]
+		if a == nil {
+			a = &agg{kind: e.kind, depth: depth, root: root}
+			items[k] = a
+		} else if depth < a.depth {
+			a.kind, a.depth = e.kind, depth
+		}
+		a.paths++
+	}
+	changes := make([]FSChange, 0, len(items))
+	for k, a := range items {
+		changes = append(changes, FSChange{Category: k.cat, Item: k.item, Kind: fsKind(a.kind), Path: a.root, Paths: a.paths})
+	}
+	order := []FSChangeCategory{FSApt, FSNpm, FSGo, FSCargo, FSPython, FSBin, FSDotfile, FSConfig, FSSystem, FSOther}
+	slices.SortFunc(changes, func(a, b FSChange) int {
+		if c := cmp.Compare(slices.Index(order, a.Category), slices.Index(order, b.Category)); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Item, b.Item)
+	})
+	return changes
+}
+
+func fsKind(k byte) string {
+	switch k {
+	case 'A':
+		return "added"
+	case 'D':
+		return "deleted"
+	default:
+		return "changed"
+	}
+}
+
+// classifyDpkg recognizes dpkg's per-package file lists, which are the
+// reliable signal of an apt install or removal.
+func classifyDpkg(p string) (FSChangeCategory, string) {
+	if rest, ok := strings.CutPrefix(p, "/var/lib/dpkg/info/"); ok && strings.HasSuffix(rest, ".list") {
+		pkg, _, _ := strings.Cut(strings.TrimSuffix(rest, ".list"), ":")
+		return FSApt, pkg
+	}
+	return "", ""
+}
+
+// classifyFSPath maps a path to its category, item and the item's root path
+// (the package directory, binary or top-level dotfile p belongs to).
+func classifyFSPath(p string) (FSChangeCategory, string, string) {
+	if cat, item := classifyDpkg(p); cat != "" {
+		return cat, item, p
+	}
+	// first returns the first n path elements of rest.
+	first := func(rest string, n int) string {
+		parts := strings.SplitN(rest, "/", n+1)
+		return strings.Join(parts[:min(n, len(parts))], "/")
+	}
+	// npmPkg returns the package name, keeping the @scope/ prefix.
+	npmPkg := func(rest string) string {
+		if strings.HasPrefix(rest, "@") {
+			return first(rest, 2)
+		}
+		return first(rest, 1)
+	}
+	if prefix, rest, ok := strings.Cut(p, "/lib/node_modules/"); ok && (strings.HasPrefix(p, "/home/user/.nvm/") || strings.HasPrefix(p, "/usr/local/")) {
+		pkg := npmPkg(rest)
+		return FSNpm, pkg, prefix + "/lib/node_modules/" + pkg
+	}
+	if rest, ok := strings.CutPrefix(p, "/home/user/.bun/install/global/node_modules/"); ok {
+		pkg := npmPkg(rest)
+		return FSNpm, pkg, "/home/user/.bun/install/global/node_modules/" + pkg
+	}
+	if rest, ok := strings.CutPrefix(p, "/home/user/go/bin/"); ok {
+		return FSGo, first(rest, 1), "/home/user/go/bin/" + first(rest, 1)
+	}
+	if rest, ok := strings.CutPrefix(p, "/home/user/.cargo/bin/"); ok {
+		return FSCargo, first(rest, 1), "/home/user/.cargo/bin/" + first(rest, 1)
+	}
+	if rest, ok := strings.CutPrefix(p, "/home/user/.local/share/uv/tools/"); ok {
+		return FSPython, first(rest, 1), "/home/user/.local/share/uv/tools/" + first(rest, 1)
+	}
+	if strings.HasPrefix(p, "/home/user/.local/lib/python") {
+		if prefix, rest, ok := strings.Cut(p, "/site-packages/"); ok {
+			dir := first(rest, 1)
+			name := dir
+			// foo-1.2.dist-info → foo
+			if i := strings.Index(name, ".dist-info"); i >= 0 {
+				name, _, _ = strings.Cut(name[:i], "-")
+			}
+			return FSPython, strings.TrimSuffix(name, ".py"), prefix + "/site-packages/" + dir
+		}
+	}
+	for _, bin := range []string{"/home/user/.local/bin/", "/home/user/bin/", "/usr/local/bin/"} {
+		if rest, ok := strings.CutPrefix(p, bin); ok {
+			return FSBin, first(rest, 1), bin + first(rest, 1)
+		}
+	}
+	for _, dir := range []string{"/home/user/.config/", "/home/user/.local/share/", "/home/user/.local/state/"} {
+		if rest, ok := strings.CutPrefix(p, dir); ok {
+			return FSDotfile, "~/" + strings.TrimPrefix(dir, "/home/user/") + first(rest, 1), dir + first(rest, 1)
+		}
+	}
+	if rest, ok := strings.CutPrefix(p, "/home/user/"); ok {
+		return FSDotfile, "~/" + first(rest, 1), "/home/user/" + first(rest, 1)
+	}
+	if rest, ok := strings.CutPrefix(p, "/etc/"); ok {
+		return FSConfig, "/etc/" + first(rest, 1), "/etc/" + first(rest, 1)
+	}
+	for _, sys := range []string{"/usr/", "/opt/", "/var/lib/", "/lib/", "/bin/", "/sbin/"} {
+		if rest, ok := strings.CutPrefix(p, sys); ok {
+			return FSSystem, sys + first(rest, 1), sys + first(rest, 1)
+		}
+	}
+	item := "/" + first(strings.TrimPrefix(p, "/"), 2)
+	return FSOther, item, item
+}
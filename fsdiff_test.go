@@ -0,0 +1,76 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestClassifyFSDiff(t *testing.T) {
+	out := `C /home
+C /home/user
+A /home/user/.bashrc.d
+A /home/user/.bashrc.d/x.sh
+C /home/user/.gitconfig
+C /home/user/src
+A /home/user/src/repo/main.go
+C /home/user/.config
+A /home/user/.config/foo
+A /home/user/.config/foo/bar.toml
+C /home/user/go
+A /home/user/go/bin
+A /home/user/go/bin/gopls
+A /home/user/go/pkg/mod/x
+C /home/user/.nvm/versions/node/v24.1.0/lib/node_modules
+A /home/user/.nvm/versions/node/v24.1.0/lib/node_modules/@scope/pkg
+A /home/user/.nvm/versions/node/v24.1.0/lib/node_modules/@scope/pkg/index.js
+A /home/user/.local/lib/python3.12/site-packages/requests
+A /home/user/.local/lib/python3.12/site-packages/requests-2.32.0.dist-info
+A /var/lib/dpkg/info/htop.list
+A /var/lib/dpkg/info/libfoo:amd64.list
+C /var/lib/dpkg/status
+A /usr/bin/htop
+C /etc
+A /etc/apt/sources.list.d/x.list
+D /etc/motd
+A /tmp/junk
+A /srv/data/a/b
+`
+	got := classifyFSDiff(out, false)
+	type ci struct {
+		cat  FSChangeCategory
+		item string
+		kind string
+	}
+	var items []ci
+	for _, c := range got {
+		items = append(items, ci{c.Category, c.Item, c.Kind})
+	}
+	want := []ci{
+		{FSApt, "htop", "added"},
+		{FSApt, "libfoo", "added"},
+		{FSNpm, "@scope/pkg", "added"},
+		{FSGo, "gopls", "added"},
+		{FSPython, "requests", "added"},
+		{FSDotfile, "~/.bashrc.d", "added"},
+		{FSDotfile, "~/.config/foo", "added"},
+		{FSDotfile, "~/.gitconfig", "changed"},
+		{FSConfig, "/etc/apt", "added"},
+		{FSConfig, "/etc/motd", "deleted"},
+		{FSSystem, "/usr/bin", "added"},
+		{FSOther, "/srv/data", "added"},
+	}
+	if !slices.Equal(items, want) {
+		t.Errorf("classifyFSDiff()\ngot  %v\nwant %v", items, want)
+	}
+
+	t.Run("all", func(t *testing.T) {
+		got := classifyFSDiff(out, true)
+		if !slices.ContainsFunc(got, func(c FSChange) bool { return c.Item == "~/src" }) {
+			t.Errorf("expected ~/src with all=true, got %v", got)
+		}
+	})
+}
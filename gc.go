@@ -0,0 +1,86 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// GCOpts configures [Client.GC].
+type GCOpts struct {
+	// Idle reaps containers not used for at least this long. Zero only reaps
+	// containers whose [Container.TTL] elapsed.
+	Idle time.Duration
+	// Remove purges idle containers, including stopped ones, instead of
+	// stopping them.
+	Remove bool
+	// DryRun reports what would be done without doing it.
+	DryRun bool
+}
+
+// GCAction is what [Client.GC] did, or would do with DryRun, to a container.
+type GCAction struct {
+	Name string
+	// Repos are the container's repositories.
+	Repos []Repo
+	// Action is "stop" or "remove".
+	Action string
+	// Idle is how long the container has not been used.
+	Idle time.Duration
+	// Err is set when the action failed.
+	Err error
+}
+
+// GC stops or removes md containers that have not been used for opts.Idle,
+// or for their own TTL when opts.Idle is zero. Removal cleans up the SSH
+// config and git remotes like [Container.Purge]. Containers without a
+// threshold are left alone.
+func (c *Client) GC(ctx context.Context, stdout, stderr io.Writer, opts *GCOpts) ([]GCAction, error) {
+	containers, err := c.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var actions []GCAction
+	for _, ct := range containers {
+		a := gcAction(ct, now, opts)
+		if a == "" {
+			continue
+		}
+		act := GCAction{Name: ct.Name, Repos: ct.Repos, Action: a, Idle: now.Sub(ct.LastUsed).Truncate(time.Second)}
+		if !opts.DryRun {
+			if a == "remove" {
+				act.Err = ct.Purge(ctx, io.Discard, stderr)
+			} else {
+				act.Err = ct.Stop(ctx)
+			}
+		}
+		actions = append(actions, act)
+	}
+	return actions, nil
+}
+
+// gcAction returns what GC does to ct at now: "stop", "remove" or "" to leave
+// it alone.
+func gcAction(ct *Container, now time.Time, opts *GCOpts) string {
+	threshold := opts.Idle
+	if threshold <= 0 {
+		threshold = ct.TTL
+	}
+	if threshold <= 0 || now.Sub(ct.LastUsed) < threshold {
+		return ""
+	}
+	switch {
+	case opts.Remove, ct.Client != nil && ct.Kube != nil:
+		// Pods can't be stopped.
+		return "remove"
+	case ct.State == "running":
+		return "stop"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGCAction(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	ago := func(d time.Duration) time.Time { return now.Add(-d) }
+	for _, tc := range []struct {
+		name string
+		ct   Container
+		opts GCOpts
+		want string
+	}{
+		{"idle_running", Container{State: "running", LastUsed: ago(72 * time.Hour)}, GCOpts{Idle: 48 * time.Hour}, "stop"},
+		{"recent", Container{State: "running", LastUsed: ago(time.Hour)}, GCOpts{Idle: 48 * time.Hour}, ""},
+		{"idle_stopped", Container{State: "exited", LastUsed: ago(72 * time.Hour)}, GCOpts{Idle: 48 * time.Hour}, ""},
+		{"remove_stopped", Container{State: "exited", LastUsed: ago(72 * time.Hour)}, GCOpts{Idle: 48 * time.Hour, Remove: true}, "remove"},
+		{"ttl", Container{State: "running", LastUsed: ago(3 * time.Hour), TTL: 2 * time.Hour}, GCOpts{}, "stop"},
+		{"ttl_not_elapsed", Container{State: "running", LastUsed: ago(time.Hour), TTL: 2 * time.Hour}, GCOpts{}, ""},
+		{"idle_overrides_ttl", Container{State: "running", LastUsed: ago(3 * time.Hour), TTL: 2 * time.Hour}, GCOpts{Idle: 48 * time.Hour}, ""},
+		{"no_threshold", Container{State: "running", LastUsed: ago(1000 * time.Hour)}, GCOpts{}, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := gcAction(&tc.ct, now, &tc.opts); got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
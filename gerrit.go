@@ -0,0 +1,143 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/caic-xyz/md/gitutil"
+	"github.com/maruel/genai"
+)
+
+// GerritOpts configures [Container.GerritPush].
+type GerritOpts struct {
+	// Remote is the Gerrit remote in the host repository. Defaults to its
+	// default remote.
+	Remote string
+	// Branch is the target branch of the change. Defaults to the remote's
+	// default branch.
+	Branch string
+	// Topic is the optional Gerrit topic.
+	Topic string
+	// Provider generates the commit message when the container made several
+	// commits. Nil joins their subjects.
+	Provider genai.Provider
+}
+
+// GerritResult is the change uploaded by [Container.GerritPush].
+type GerritResult struct {
+	Commit   string
+	ChangeID string
+	// Output is git push's output, with the change URL printed by Gerrit.
+	Output string
+}
+
+// GerritPush uploads the work in Repos[repoIdx] as a single Gerrit change
+// for review on opts.Branch. It fetches the container's commits like
+// [Container.Fetch], squashes them on top of the remote branch and pushes
+// the result to refs/for/<branch>. The Change-Id of the container's commits
+// is kept; otherwise one derived from the container and branch names is
+// added so that pushing again uploads a new patch set of the same change.
+func (c *Container) GerritPush(ctx context.Context, stdout, stderr io.Writer, repoIdx int, opts *GerritOpts) (*GerritResult, error) {
+	if _, err := c.Fetch(ctx, stdout, stderr, repoIdx, &FetchOpts{Provider: opts.Provider}); err != nil {
+		return nil, err
+	}
+	r := c.Repos[repoIdx]
+	remote := opts.Remote
+	if remote == "" {
+		var err error
+		if remote, err = r.vcs().DefaultRemote(ctx, r.GitRoot); err != nil {
+			return nil, err
+		}
+	}
+	branch := opts.Branch
+	if branch == "" {
+		var err error
+		if branch, err = r.vcs().DefaultBranch(ctx, r.GitRoot, remote); err != nil {
+			return nil, err
+		}
+	}
+	if err := runCmdOut(ctx, r.GitRoot, []string{"git", "fetch", "-q", remote, branch}, stdout, stderr); err != nil {
+		return nil, err
+	}
+	onto := remote + "/" + branch
+	source := c.Name + "/" + r.Branch
+	if _, err := gitutil.RunGit(ctx, r.GitRoot, "merge-base", "--is-ancestor", source, onto); err == nil {
+		return nil, fmt.Errorf("%s has no changes over %s", source, onto)
+	}
+	msg, err := c.gerritMessage(ctx, r.GitRoot, onto, source, opts.Provider)
+	if err != nil {
+		return nil, err
+	}
+	id := gitutil.ChangeID(msg)
+	if id == "" {
+		id = gitutil.NewChangeID(c.Name + "\x00" + r.Name() + "\x00" + r.Branch + "\x00" + branch)
+		msg = gitutil.AddChangeID(msg, id)
+	}
+	commit, err := gitutil.SquashMerge(ctx, r.GitRoot, onto, source, msg)
+	if err != nil {
+		return nil, err
+	}
+	out, err := gitutil.PushGerrit(ctx, r.GitRoot, remote, commit, branch, opts.Topic)
+	if err != nil {
+		return nil, err
+	}
+	return &GerritResult{Commit: commit, ChangeID: id, Output: out}, nil
+}
+
+// gerritMessage returns the commit message of the change squashing the
+// commits of source not in onto: the message of the only commit, or one
+// summarizing them.
+func (c *Container) gerritMessage(ctx context.Context, dir, onto, source string, p genai.Provider) (string, error) {
+	rng := onto + ".." + source
+	count, err := gitutil.RunGit(ctx, dir, "rev-list", "--count", "--no-merges", rng)
+	if err != nil {
+		return "", err
+	}
+	if count == "1" {
+		return gitutil.RunGit(ctx, dir, "log", "-1", "--no-merges", "--format=%B", rng)
+	}
+	log, err := gitutil.RunGit(ctx, dir, "log", "--no-merges", "--format=%B%x00", rng)
+	if err != nil {
+		return "", err
+	}
+	// Keep the Change-Id of an earlier upload, whichever commit carries it.
+	var id string
+	msgs := strings.Split(log, "\x00")
+	for _, m := range msgs {
+		if id = gitutil.ChangeID(m); id != "" {
+			break
+		}
+	}
+	log = strings.Join(msgs, "\n")
+	msg := ""
+	if p != nil {
+		diff, _ := gitutil.RunGit(ctx, dir, "diff", "--patience", "-U10", onto+"..."+source)
+		metadata := "=== Commits ===\n" + log
+		if msg, err = c.generateCommitMsg(ctx, p, metadata, diff); err != nil {
+			slog.WarnContext(ctx, "md", "msg", "failed to generate commit message", "err", err)
+			msg = ""
+		}
+	}
+	if msg == "" {
+		subjects, err := gitutil.RunGit(ctx, dir, "log", "--reverse", "--no-merges", "--format=- %s", rng)
+		if err != nil {
+			return "", err
+		}
+		if subjects == "" {
+			return "", errors.New("no commits to push")
+		}
+		msg = "Changes from " + c.Name + "\n\n" + subjects
+	}
+	if id != "" {
+		msg = gitutil.AddChangeID(msg, id)
+	}
+	return msg, nil
+}
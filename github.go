@@ -0,0 +1,177 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caic-xyz/md/gitutil"
+)
+
+// githubError is a failed GitHub API call.
+type githubError struct {
+	Status  int
+	Message string
+}
+
+func (e *githubError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("GitHub API: %d %s", e.Status, e.Message)
+	}
+	return fmt.Sprintf("GitHub API: %d %s", e.Status, http.StatusText(e.Status))
+}
+
+// githubDo calls the GitHub API at path with in as JSON body, when not nil,
+// and decodes the response in out, when not nil.
+func githubDo(ctx context.Context, token, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, githubAPI+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("User-Agent", "md")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode/100 != 2 {
+		var e struct {
+			Message string `json:"message"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&e)
+		return &githubError{Status: resp.StatusCode, Message: e.Message}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// ParsePullRef parses "7", "#7" or a pull request URL
+// (https://github.com/owner/repo/pull/7). owner and repo are empty unless
+// given by the URL.
+func ParsePullRef(ref string) (owner, repo string, number int, err error) {
+	s := strings.TrimPrefix(ref, "#")
+	if u, err := url.Parse(ref); err == nil && u.Host == "github.com" {
+		parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+		if len(parts) < 4 || parts[2] != "pull" {
+			return "", "", 0, fmt.Errorf("invalid pull request URL %q", ref)
+		}
+		owner, repo, s = parts[0], parts[1], parts[3]
+	}
+	number, err = strconv.Atoi(s)
+	if err != nil || number <= 0 {
+		return "", "", 0, fmt.Errorf("invalid pull request %q: use a number or a pull request URL", ref)
+	}
+	return owner, repo, number, nil
+}
+
+// PostReview posts findings as a review of the pull request owner/repo#number
+// and returns its URL. The findings on a line are comments on that line of
+// the pull request's latest commit; the others, and all of them when GitHub
+// rejects a line that isn't part of the pull request's diff, are listed in
+// the review's body.
+func PostReview(ctx context.Context, token, owner, repo string, number int, findings []gitutil.ReviewFinding) (string, error) {
+	type comment struct {
+		Path string `json:"path"`
+		Line int    `json:"line"`
+		Side string `json:"side"`
+		Body string `json:"body"`
+	}
+	type review struct {
+		Event    string    `json:"event"`
+		Body     string    `json:"body"`
+		Comments []comment `json:"comments,omitempty"`
+	}
+	path := fmt.Sprintf("/repos/%s/%s/pulls/%d/reviews", url.PathEscape(owner), url.PathEscape(repo), number)
+	var inline, general []gitutil.ReviewFinding
+	for _, f := range findings {
+		if f.Line > 0 {
+			inline = append(inline, f)
+		} else {
+			general = append(general, f)
+		}
+	}
+	r := review{Event: "COMMENT", Body: reviewBody(findings, general)}
+	for _, f := range inline {
+		r.Comments = append(r.Comments, comment{Path: f.File, Line: f.Line, Side: "RIGHT", Body: "**" + f.Severity + "**: " + f.Comment})
+	}
+	var res struct {
+		HTMLURL string `json:"html_url"`
+	}
+	err := githubDo(ctx, token, http.MethodPost, path, &r, &res)
+	if e := (*githubError)(nil); errors.As(err, &e) && e.Status == http.StatusUnprocessableEntity && len(r.Comments) != 0 {
+		r = review{Event: "COMMENT", Body: reviewBody(findings, findings)}
+		err = githubDo(ctx, token, http.MethodPost, path, &r, &res)
+	}
+	if err != nil {
+		return "", fmt.Errorf("posting the review on %s/%s#%d: %w", owner, repo, number, err)
+	}
+	return res.HTMLURL, nil
+}
+
+// reviewBody returns the body of a review of findings listing listed.
+func reviewBody(findings, listed []gitutil.ReviewFinding) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "md review: %d finding(s).\n", len(findings))
+	if len(listed) != 0 {
+		b.WriteString("\n")
+	}
+	for _, f := range listed {
+		loc := f.File
+		if f.Line > 0 {
+			loc += fmt.Sprintf(":%d", f.Line)
+		}
+		fmt.Fprintf(&b, "- `%s` **%s**: %s\n", loc, f.Severity, f.Comment)
+	}
+	return b.String()
+}
+
+// NewPullRequest is a pull request to open with [CreatePullRequest].
+type NewPullRequest struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	// Head is the branch with the changes, Base the branch to merge them in.
+	Head  string `json:"head"`
+	Base  string `json:"base"`
+	Draft bool   `json:"draft,omitempty"`
+}
+
+// CreatePullRequest opens pr on owner/repo and returns its URL.
+func CreatePullRequest(ctx context.Context, token, owner, repo string, pr *NewPullRequest) (string, error) {
+	path := fmt.Sprintf("/repos/%s/%s/pulls", url.PathEscape(owner), url.PathEscape(repo))
+	var res struct {
+		HTMLURL string `json:"html_url"`
+	}
+	if err := githubDo(ctx, token, http.MethodPost, path, pr, &res); err != nil {
+		return "", fmt.Errorf("opening a pull request on %s/%s from %s: %w", owner, repo, pr.Head, err)
+	}
+	return res.HTMLURL, nil
+}
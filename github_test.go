@@ -0,0 +1,117 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/caic-xyz/md/gitutil"
+)
+
+func TestParsePullRef(t *testing.T) {
+	for ref, want := range map[string]string{
+		"7":                                   "//7",
+		"#7":                                  "//7",
+		"https://github.com/o/r/pull/7":       "o/r/7",
+		"https://github.com/o/r/pull/7/files": "o/r/7",
+	} {
+		owner, repo, n, err := ParsePullRef(ref)
+		if got := owner + "/" + repo + "/" + strconv.Itoa(n); err != nil || got != want {
+			t.Errorf("%s: got %s, %v", ref, got, err)
+		}
+	}
+	for _, ref := range []string{"", "x", "-1", "https://github.com/o/r/issues/7"} {
+		if _, _, _, err := ParsePullRef(ref); err == nil {
+			t.Errorf("%s: expected error", ref)
+		}
+	}
+}
+
+func TestPostReview(t *testing.T) {
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/repos/o/r/pulls/7/reviews" || r.Header.Get("Authorization") != "Bearer tok" {
+			t.Errorf("%s %s %q", r.Method, r.URL.Path, r.Header.Get("Authorization"))
+		}
+		var req struct {
+			Event    string `json:"event"`
+			Body     string `json:"body"`
+			Comments []struct {
+				Path string `json:"path"`
+				Line int    `json:"line"`
+			} `json:"comments"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		bodies = append(bodies, req.Body)
+		// The first attempt comments on a line outside the pull request.
+		if len(req.Comments) != 0 {
+			if req.Event != "COMMENT" || req.Comments[0].Path != "main.go" || req.Comments[0].Line != 12 {
+				t.Errorf("got %+v", req)
+			}
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			_, _ = w.Write([]byte(`{"message": "Line could not be resolved"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"html_url": "https://github.com/o/r/pull/7#pullrequestreview-1"}`))
+	}))
+	defer srv.Close()
+	old := githubAPI
+	githubAPI = srv.URL
+	defer func() { githubAPI = old }()
+
+	findings := []gitutil.ReviewFinding{
+		{File: "main.go", Line: 12, Severity: gitutil.SeverityError, Comment: "nil dereference"},
+		{File: "README.md", Severity: gitutil.SeveritySuggestion, Comment: "document the flag"},
+	}
+	u, err := PostReview(t.Context(), "tok", "o", "r", 7, findings)
+	if err != nil || u != "https://github.com/o/r/pull/7#pullrequestreview-1" {
+		t.Fatalf("got %q, %v", u, err)
+	}
+	if len(bodies) != 2 || strings.Contains(bodies[0], "main.go") || !strings.Contains(bodies[0], "README.md") || !strings.Contains(bodies[1], "`main.go:12` **error**: nil dereference") {
+		t.Errorf("bodies %q", bodies)
+	}
+}
+
+func TestCreatePullRequest(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/repos/o/r/pulls" || r.Header.Get("Authorization") != "Bearer tok" {
+			t.Errorf("%s %s %q", r.Method, r.URL.Path, r.Header.Get("Authorization"))
+		}
+		var req NewPullRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		if req.Head == "taken" {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			_, _ = w.Write([]byte(`{"message": "A pull request already exists for o:taken."}`))
+			return
+		}
+		if want := (NewPullRequest{Title: "Fix the parser", Body: "It choked on tabs.", Head: "fix", Base: "main"}); req != want {
+			t.Errorf("got %+v", req)
+		}
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"html_url": "https://github.com/o/r/pull/8"}`))
+	}))
+	defer srv.Close()
+	old := githubAPI
+	githubAPI = srv.URL
+	defer func() { githubAPI = old }()
+
+	pr := &NewPullRequest{Title: "Fix the parser", Body: "It choked on tabs.", Head: "fix", Base: "main"}
+	if u, err := CreatePullRequest(t.Context(), "tok", "o", "r", pr); err != nil || u != "https://github.com/o/r/pull/8" {
+		t.Fatalf("got %q, %v", u, err)
+	}
+	pr.Head = "taken"
+	if _, err := CreatePullRequest(t.Context(), "tok", "o", "r", pr); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("got %v", err)
+	}
+}
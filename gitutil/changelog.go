@@ -0,0 +1,217 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package gitutil
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/maruel/genai"
+)
+
+// Kinds of changes of a commit, as classified by GenerateChangelog from its
+// message.
+const (
+	ChangeBreaking = "breaking"
+	ChangeFeature  = "feature"
+	ChangeFix      = "fix"
+)
+
+// changelogPrompt is the system prompt used by GenerateChangelog, for the
+// whole range or for each chunk of it.
+const changelogPrompt = "Write release notes in Markdown for the commits below. Follow these rules:\n" +
+	"- Use these sections in this order, leaving out empty ones: \"## Breaking changes\", \"## Features\", \"## Fixes\", \"## Other changes\"\n" +
+	"- Commits tagged [breaking], [feature] or [fix] were classified from their message; classify the [?] ones from their subject and body\n" +
+	"- One bullet per user-visible change, merging related commits, ending with their short hashes in parentheses\n" +
+	"- Leave out changes with no effect for users (refactoring, tests, CI) unless nothing else is left\n" +
+	"- Describe breaking changes with what users must do\n" +
+	"- No emojis\n" +
+	"- Output only the Markdown, nothing else"
+
+// changelogSynthesizePrompt combines the release notes of the chunks of a
+// large range.
+const changelogSynthesizePrompt = "Below are release notes written for consecutive parts of the same release. " +
+	"Merge them into a single set of release notes following these rules:\n" +
+	"- Keep the sections in this order, leaving out empty ones: \"## Breaking changes\", \"## Features\", \"## Fixes\", \"## Other changes\"\n" +
+	"- Merge duplicate or related bullets, keeping their short hashes in parentheses\n" +
+	"- No emojis\n" +
+	"- Output only the Markdown, nothing else"
+
+// maxChangelogBody is the length of a commit message's body kept for the
+// LLM.
+const maxChangelogBody = 2000
+
+// changelogCommit is a commit of the range described by GenerateChangelog.
+type changelogCommit struct {
+	hash    string
+	subject string
+	body    string
+	// kind is one of the Change constants, or "" when the message doesn't
+	// say.
+	kind string
+}
+
+// conventionalRe matches a Conventional Commits subject: type, optional
+// scope, optional "!" for a breaking change.
+var conventionalRe = regexp.MustCompile(`^([a-zA-Z]+)(\([^)]*\))?(!)?: `)
+
+// tagRe matches a leading tag such as "[area]" or "[org/repo#123]".
+var tagRe = regexp.MustCompile(`^(\[[^\]]*\]\s*)+`)
+
+// classifyCommit returns the kind of change a commit message announces, from
+// Conventional Commits markers or the subject's leading verb, or "" when it
+// doesn't say.
+func classifyCommit(subject, body string) string {
+	if strings.Contains(body, "BREAKING CHANGE:") || strings.Contains(body, "BREAKING-CHANGE:") {
+		return ChangeBreaking
+	}
+	subject = tagRe.ReplaceAllString(subject, "")
+	if m := conventionalRe.FindStringSubmatch(subject); m != nil {
+		if m[3] != "" {
+			return ChangeBreaking
+		}
+		switch strings.ToLower(m[1]) {
+		case "feat", "feature":
+			return ChangeFeature
+		case "fix", "bugfix", "hotfix":
+			return ChangeFix
+		}
+		return ""
+	}
+	verb, _, _ := strings.Cut(subject, " ")
+	switch strings.ToLower(verb) {
+	case "add", "adds", "added", "implement", "implements", "implemented", "introduce", "introduces", "introduced", "support", "supports":
+		return ChangeFeature
+	case "fix", "fixes", "fixed":
+		return ChangeFix
+	}
+	return ""
+}
+
+// changelogCommits returns the non-merge commits in toRef and not in
+// fromRef, newest first.
+func changelogCommits(ctx context.Context, dir, fromRef, toRef string) ([]changelogCommit, error) {
+	out, err := RunGit(ctx, dir, "log", "--no-merges", "--format=%h%x00%s%x00%b%x1e", fromRef+".."+toRef, "--")
+	if err != nil {
+		return nil, err
+	}
+	var commits []changelogCommit
+	for rec := range strings.SplitSeq(out, "\x1e") {
+		f := strings.SplitN(strings.TrimSpace(rec), "\x00", 3)
+		if len(f) != 3 {
+			continue
+		}
+		c := changelogCommit{hash: f[0], subject: f[1], body: strings.TrimSpace(f[2])}
+		c.kind = classifyCommit(c.subject, c.body)
+		commits = append(commits, c)
+	}
+	return commits, nil
+}
+
+// changelogEntries renders commits for the LLM, grouped by kind: breaking
+// changes, features, fixes, then the unclassified ones, each group newest
+// first.
+func changelogEntries(commits []changelogCommit) []string {
+	order := []string{ChangeBreaking, ChangeFeature, ChangeFix, ""}
+	sorted := slices.Clone(commits)
+	slices.SortStableFunc(sorted, func(a, b changelogCommit) int {
+		return slices.Index(order, a.kind) - slices.Index(order, b.kind)
+	})
+	entries := make([]string, len(sorted))
+	for i, c := range sorted {
+		var b strings.Builder
+		fmt.Fprintf(&b, "- [%s] %s %s\n", cmp.Or(c.kind, "?"), c.hash, c.subject)
+		body := c.body
+		if len(body) > maxChangelogBody {
+			body = body[:maxChangelogBody] + "\n...[truncated]"
+		}
+		if body != "" {
+			for l := range strings.SplitSeq(body, "\n") {
+				b.WriteString("  " + l + "\n")
+			}
+		}
+		entries[i] = b.String()
+	}
+	return entries
+}
+
+// splitEntries groups entries in chunks that each fit under maxChunk bytes.
+// An entry larger than maxChunk is a chunk of its own.
+func splitEntries(entries []string, maxChunk int) []string {
+	var chunks []string
+	var b strings.Builder
+	for _, e := range entries {
+		if b.Len() > 0 && b.Len()+len(e) > maxChunk {
+			chunks = append(chunks, b.String())
+			b.Reset()
+		}
+		b.WriteString(e)
+	}
+	if b.Len() > 0 {
+		chunks = append(chunks, b.String())
+	}
+	return chunks
+}
+
+// LastTag returns the most recent tag reachable from ref in the repository
+// at dir.
+func LastTag(ctx context.Context, dir, ref string) (string, error) {
+	return RunGit(ctx, dir, "describe", "--tags", "--abbrev=0", ref)
+}
+
+// GenerateChangelog asks the LLM for Markdown release notes of the commits
+// in toRef and not in fromRef in the repository at dir. An empty fromRef is
+// the last tag before toRef and an empty toRef is HEAD.
+//
+// Commits are classified from their message (breaking changes, features,
+// fixes) and grouped before being sent. A range too large for one request is
+// split in chunks described concurrently and merged, like GenerateCommitMsg
+// does for a large diff.
+func GenerateChangelog(ctx context.Context, p genai.Provider, dir, fromRef, toRef string) (string, error) {
+	if toRef == "" {
+		toRef = "HEAD"
+	}
+	if fromRef == "" {
+		var err error
+		if fromRef, err = LastTag(ctx, dir, toRef); err != nil {
+			return "", fmt.Errorf("no tag before %s, name the start of the range: %w", toRef, err)
+		}
+	}
+	commits, err := changelogCommits(ctx, dir, fromRef, toRef)
+	if err != nil {
+		return "", err
+	}
+	if len(commits) == 0 {
+		return "", fmt.Errorf("no commits between %s and %s", fromRef, toRef)
+	}
+	metadata := fmt.Sprintf("=== Range ===\n%s..%s: %d commit(s)\n\n", fromRef, toRef, len(commits))
+	entries := changelogEntries(commits)
+	budget := maxDiffLen - len(changelogPrompt) - len(metadata) - 100
+	if all := strings.Join(entries, ""); len(all) <= budget {
+		return genChangelog(ctx, p, changelogPrompt, metadata+"=== Commits ===\n"+all)
+	}
+	chunks := splitEntries(entries, budget)
+	return mapReduce(ctx, p, genChangelog, changelogPrompt, changelogSynthesizePrompt, metadata, chunks)
+}
+
+// genChangelog writes release notes. They are longer than commit messages:
+// it allows more tokens and time than genCommitMsg.
+func genChangelog(ctx context.Context, p genai.Provider, systemPrompt, content string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+	res, err := p.GenSync(ctx, genai.Messages{genai.NewTextMessage(content)}, &genai.GenOptionText{
+		MaxTokens:    4096,
+		SystemPrompt: systemPrompt,
+	})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(res.String()), nil
+}
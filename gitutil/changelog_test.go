@@ -0,0 +1,85 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package gitutil
+
+import (
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestClassifyCommit(t *testing.T) {
+	for _, tc := range []struct {
+		subject, body, want string
+	}{
+		{"feat: add --json", "", ChangeFeature},
+		{"feat(cli)!: drop -x", "", ChangeBreaking},
+		{"fix(parser): handle tabs", "", ChangeFix},
+		{"refactor: split main.go", "BREAKING CHANGE: Run is gone", ChangeBreaking},
+		{"chore: bump deps", "", ""},
+		{"[maruel/md#12] Add md pr", "", ChangeFeature},
+		{"Fix the parser on tabs", "", ChangeFix},
+		{"Rename x to y", "", ""},
+	} {
+		if got := classifyCommit(tc.subject, tc.body); got != tc.want {
+			t.Errorf("%q: got %q, want %q", tc.subject, got, tc.want)
+		}
+	}
+}
+
+func TestChangelogCommits(t *testing.T) {
+	ctx := t.Context()
+	dir := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.CommandContext(ctx, "git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@test"}, args...)...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	git("init", "-q", "--initial-branch=main")
+	git("commit", "-q", "--allow-empty", "-m", "init")
+	git("tag", "v1.0.0")
+	git("commit", "-q", "--allow-empty", "-m", "Fix the parser")
+	git("commit", "-q", "--allow-empty", "-m", "Rename x", "-m", "BREAKING CHANGE: x is y now.")
+	git("commit", "-q", "--allow-empty", "-m", "docs: typo")
+	git("commit", "-q", "--allow-empty", "-m", "feat: add --json")
+
+	if tag, err := LastTag(ctx, dir, "HEAD"); err != nil || tag != "v1.0.0" {
+		t.Fatalf("got %q, %v", tag, err)
+	}
+	commits, err := changelogCommits(ctx, dir, "v1.0.0", "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kinds []string
+	for _, c := range commits {
+		kinds = append(kinds, c.subject+"="+c.kind)
+	}
+	if want := []string{"feat: add --json=feature", "docs: typo=", "Rename x=breaking", "Fix the parser=fix"}; !slices.Equal(kinds, want) {
+		t.Errorf("got %q", kinds)
+	}
+	entries := changelogEntries(commits)
+	var tags []string
+	for _, e := range entries {
+		tag, _, _ := strings.Cut(e[len("- "):], " ")
+		tags = append(tags, tag)
+	}
+	if want := []string{"[breaking]", "[feature]", "[fix]", "[?]"}; !slices.Equal(tags, want) {
+		t.Errorf("got %q", tags)
+	}
+	if !strings.HasSuffix(entries[0], " Rename x\n  BREAKING CHANGE: x is y now.\n") {
+		t.Errorf("got %q", entries[0])
+	}
+}
+
+func TestSplitEntries(t *testing.T) {
+	got := splitEntries([]string{"aaaa\n", "bb\n", "cccccccccc\n", "d\n"}, 8)
+	if want := []string{"aaaa\nbb\n", "cccccccccc\n", "d\n"}; !slices.Equal(got, want) {
+		t.Errorf("got %q", got)
+	}
+}
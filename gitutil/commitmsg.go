@@ -54,7 +54,7 @@ const synthesizePrompt = "Below are descriptions of different parts of the same
 // Each filter is tried in order; matching files are removed only if the diff
 // is still too large after the previous step. Pass nil to GenerateCommitMsg
 // to use these defaults.
-var defaultDiffFilters = []func(string) bool{isTestFile, isDataFile, isGeneratedFile}
+var defaultDiffFilters = []func(string) bool{isTestFile, isDataFile, IsGeneratedFile}
 
 // hunk represents a single hunk in a unified diff.
 type hunk struct {
@@ -193,9 +193,9 @@ func isDataFile(name string) bool {
 	return ext == ".json" || ext == ".yaml" || ext == ".yml"
 }
 
-// isGeneratedFile returns true for lock files, generated code, and vendored
+// IsGeneratedFile returns true for lock files, generated code, and vendored
 // dependencies.
-func isGeneratedFile(name string) bool {
+func IsGeneratedFile(name string) bool {
 	lower := strings.ToLower(name)
 	switch strings.ToLower(path.Base(name)) {
 	case "cargo.lock", "composer.lock", "gemfile.lock", "go.sum",
@@ -424,6 +424,13 @@ func progressiveFilter(files []fileDiff, filters []func(string) bool, budget int
 // filters is an ordered list of file predicates applied progressively to
 // reduce the diff size. Pass nil to use defaultDiffFilters.
 func GenerateCommitMsg(ctx context.Context, p genai.Provider, metadata, diff string, filters []func(string) bool) (string, error) {
+	return generateMsg(ctx, p, commitMsgPrompt, synthesizePrompt, metadata, diff, filters)
+}
+
+// generateMsg runs GenerateCommitMsg's reduction pipeline with prompt as the
+// system prompt, or synthesize to combine the chunk summaries of the parallel
+// map-reduce fallback.
+func generateMsg(ctx context.Context, p genai.Provider, prompt, synthesize, metadata, diff string, filters []func(string) bool) (string, error) {
 	if filters == nil {
 		filters = defaultDiffFilters
 	}
@@ -432,46 +439,61 @@ func GenerateCommitMsg(ctx context.Context, p genai.Provider, metadata, diff str
 
 	// Step 0: try full diff.
 	if metaLen+renderDiffLen(files) <= maxDiffLen {
-		return genCommitMsg(ctx, p, commitMsgPrompt, buildContext(metadata, renderDiff(files)))
+		return genCommitMsg(ctx, p, prompt, buildContext(metadata, renderDiff(files)))
 	}
 
 	// Step 1: reduce context lines.
 	reduceFileDiffContext(files, reducedContext)
 	if metaLen+renderDiffLen(files) <= maxDiffLen {
-		return genCommitMsg(ctx, p, commitMsgPrompt, buildContext(metadata, renderDiff(files)))
+		return genCommitMsg(ctx, p, prompt, buildContext(metadata, renderDiff(files)))
 	}
 
 	// Step 2+: apply each filter progressively until the diff fits.
 	files, removed := progressiveFilter(files, filters, maxDiffLen-metaLen)
 	annotation := filteredAnnotation(removed)
 	if metaLen+renderDiffLen(files)+len(annotation) <= maxDiffLen {
-		return genCommitMsg(ctx, p, commitMsgPrompt, buildContext(metadata, renderDiff(files)+annotation))
+		return genCommitMsg(ctx, p, prompt, buildContext(metadata, renderDiff(files)+annotation))
 	}
 
 	// Final fallback: parallel map-reduce. Include annotation in metadata so
 	// the synthesis step knows which files were omitted.
-	return parallelDescribe(ctx, p, metadata+annotation, files)
+	return parallelDescribe(ctx, p, prompt, synthesize, metadata+annotation, files)
 }
 
 const maxMetadataPrefix = 10000
 
 // parallelDescribe splits the diff into chunks, summarizes each concurrently,
-// then synthesizes the summaries into a single commit message. Each chunk
-// prompt includes a truncated metadata header for context.
-func parallelDescribe(ctx context.Context, p genai.Provider, metadata string, files []fileDiff) (string, error) {
-	// Truncate metadata prefix for chunk prompts to avoid blowing the budget.
-	metaPrefix := metadata
-	if len(metaPrefix) > maxMetadataPrefix {
-		metaPrefix = metaPrefix[:maxMetadataPrefix] + "\n...[truncated]\n"
-	}
-	chunkOverhead := len(chunkPrompt) + len("\n\n") + len(metaPrefix) + len("\n") + 100
+// then synthesizes the summaries into a single message with the system prompt
+// synthesize, or prompt when there is nothing to split. Each chunk prompt
+// includes a truncated metadata header for context.
+func parallelDescribe(ctx context.Context, p genai.Provider, prompt, synthesize, metadata string, files []fileDiff) (string, error) {
+	chunkOverhead := len(chunkPrompt) + len("\n\n") + len(truncateMetadata(metadata)) + len("\n") + 100
 	chunkSize := maxDiffLen - chunkOverhead
 	chunkSize = max(chunkSize, 1000)
 	chunks := splitFiles(files, chunkSize)
 	if len(chunks) == 0 {
-		return genCommitMsg(ctx, p, commitMsgPrompt, metadata)
+		return genCommitMsg(ctx, p, prompt, metadata)
 	}
+	return mapReduce(ctx, p, genCommitMsg, chunkPrompt, synthesize, metadata, chunks)
+}
+
+// genFunc calls the LLM with a system prompt and content.
+type genFunc func(ctx context.Context, p genai.Provider, systemPrompt, content string) (string, error)
+
+// truncateMetadata truncates metadata for chunk prompts to avoid blowing the
+// budget.
+func truncateMetadata(metadata string) string {
+	if len(metadata) > maxMetadataPrefix {
+		return metadata[:maxMetadataPrefix] + "\n...[truncated]\n"
+	}
+	return metadata
+}
 
+// mapReduce summarizes chunks concurrently with the system prompt
+// mapPrompt, each after the truncated metadata, then combines the
+// summaries with the system prompt synthesize.
+func mapReduce(ctx context.Context, p genai.Provider, gen genFunc, mapPrompt, synthesize, metadata string, chunks []string) (string, error) {
+	metaPrefix := truncateMetadata(metadata)
 	summaries := make([]string, len(chunks))
 	g, gctx := errgroup.WithContext(ctx)
 	g.SetLimit(maxParallelCalls)
@@ -479,7 +501,7 @@ func parallelDescribe(ctx context.Context, p genai.Provider, metadata string, fi
 		g.Go(func() error {
 			header := fmt.Sprintf("(part %d/%d)\n", i+1, len(chunks))
 			content := metaPrefix + "\n" + header + chunk
-			summary, err := genCommitMsg(gctx, p, chunkPrompt, content)
+			summary, err := gen(gctx, p, mapPrompt, content)
 			if err != nil {
 				return err
 			}
@@ -493,7 +515,7 @@ func parallelDescribe(ctx context.Context, p genai.Provider, metadata string, fi
 
 	// Synthesize.
 	combined := metadata + "\n=== Chunk Summaries ===\n" + strings.Join(summaries, "\n---\n")
-	return genCommitMsg(ctx, p, synthesizePrompt, combined)
+	return gen(ctx, p, synthesize, combined)
 }
 
 // genCommitMsg generates a commit message using an already-initialized provider.
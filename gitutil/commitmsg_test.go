@@ -79,7 +79,7 @@ func Test_isDataFile(t *testing.T) {
 	}
 }
 
-func Test_isGeneratedFile(t *testing.T) {
+func TestIsGeneratedFile(t *testing.T) {
 	tests := []struct {
 		name string
 		path string
@@ -104,9 +104,9 @@ func Test_isGeneratedFile(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := isGeneratedFile(tt.path)
+			got := IsGeneratedFile(tt.path)
 			if got != tt.want {
-				t.Errorf("isGeneratedFile(%q) = %v, want %v", tt.path, got, tt.want)
+				t.Errorf("IsGeneratedFile(%q) = %v, want %v", tt.path, got, tt.want)
 			}
 		})
 	}
@@ -0,0 +1,187 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package gitutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/maruel/genai"
+)
+
+// ConventionalTypes are the commit types accepted in Conventional Commits
+// mode, those of the Angular convention commonly used with the spec.
+var ConventionalTypes = []string{"feat", "fix", "docs", "style", "refactor", "perf", "test", "build", "ci", "chore", "revert"}
+
+// conventionalPrompt is the system prompt used by
+// GenerateConventionalCommitMsg.
+const conventionalPrompt = "Write a git commit message for the changes below following the Conventional Commits specification. Follow these rules:\n" +
+	"- Subject: \"type(scope): description\", or \"type: description\" without a scope, max 72 chars\n" +
+	"- type is one of: feat, fix, docs, style, refactor, perf, test, build, ci, chore, revert\n" +
+	"- Use the suggested type and scope if provided, unless the diff clearly contradicts them\n" +
+	"- scope is a short lowercase noun naming the area changed, e.g. a package or directory\n" +
+	"- description: imperative mood, lowercase first letter, no period (e.g. \"fix(retry): handle timeout in loop\")\n" +
+	"- Add \"!\" after the scope and a \"BREAKING CHANGE: \" footer for incompatible changes\n" +
+	"- If the change is non-trivial, add a blank line then a body explaining what and why, not how\n" +
+	"- Wrap body lines at 72 chars\n" +
+	"- Focus on the meaningful changes; ignore ancillary updates (imports, test data, build files, dependency bumps, formatting) unless they are the primary purpose of the commit\n" +
+	"- No emojis\n" +
+	"- Output only the commit message, nothing else"
+
+// conventionalSynthesizePrompt combines chunk summaries into a Conventional
+// Commits message during parallel map-reduce for large diffs.
+const conventionalSynthesizePrompt = "Below are descriptions of different parts of the same commit. " +
+	"Write a single unified git commit message following the Conventional Commits specification and these rules:\n" +
+	"- Subject: \"type(scope): description\", or \"type: description\" without a scope, max 72 chars\n" +
+	"- type is one of: feat, fix, docs, style, refactor, perf, test, build, ci, chore, revert\n" +
+	"- Use the suggested type and scope if provided, unless the descriptions clearly contradict them\n" +
+	"- description: imperative mood, lowercase first letter, no period\n" +
+	"- If non-trivial, add a blank line then a body explaining what and why\n" +
+	"- Wrap body lines at 72 chars\n" +
+	"- No emojis\n" +
+	"- Output only the commit message, nothing else"
+
+// conventionalFixPrompt asks to correct a message whose subject failed
+// ValidateConventionalSubject.
+const conventionalFixPrompt = "The commit message below doesn't follow the Conventional Commits specification, for the reason given. " +
+	"Rewrite its subject as \"type(scope): description\", or \"type: description\", max 72 chars, " +
+	"with type one of: feat, fix, docs, style, refactor, perf, test, build, ci, chore, revert. " +
+	"Keep the body as is. Output only the commit message, nothing else"
+
+// conventionalSubjectRe matches a Conventional Commits subject: type,
+// optional scope, optional "!" for a breaking change, then the description.
+var conventionalSubjectRe = regexp.MustCompile(`^([a-z]+)(\(([^()\s]+)\))?(!)?: (\S.*)$`)
+
+// ValidateConventionalSubject returns an error when subject isn't a
+// Conventional Commits subject with one of ConventionalTypes and a
+// description of at most 72 characters overall, without a final period.
+func ValidateConventionalSubject(subject string) error {
+	m := conventionalSubjectRe.FindStringSubmatch(subject)
+	switch {
+	case m == nil:
+		return fmt.Errorf("%q isn't \"type(scope): description\"", subject)
+	case !slices.Contains(ConventionalTypes, m[1]):
+		return fmt.Errorf("unknown type %q, want one of %s", m[1], strings.Join(ConventionalTypes, ", "))
+	case len(subject) > 72:
+		return fmt.Errorf("subject is %d characters long, more than 72", len(subject))
+	case strings.HasSuffix(m[5], "."):
+		return errors.New("subject ends with a period")
+	}
+	return nil
+}
+
+// inferConventional guesses the Conventional Commits type and scope of a
+// change from the paths it touches. The type is only set when every path
+// agrees, e.g. all documentation; the LLM picks between feat, fix and
+// refactor from the diff. The scope is the innermost directory containing
+// all the paths, empty for the root.
+func inferConventional(paths []string) (typ, scope string) {
+	if len(paths) == 0 {
+		return "", ""
+	}
+	for _, c := range []struct {
+		typ   string
+		match func(string) bool
+	}{
+		{"ci", isCIFile},
+		{"docs", isDocFile},
+		{"build", isBuildFile},
+		{"test", isTestFile},
+	} {
+		if !slices.ContainsFunc(paths, func(p string) bool { return !c.match(p) }) {
+			typ = c.typ
+			break
+		}
+	}
+	dir := path.Dir(paths[0])
+	for _, p := range paths[1:] {
+		for dir != "." && !strings.HasPrefix(p, dir+"/") {
+			dir = path.Dir(dir)
+		}
+	}
+	if dir != "." {
+		scope = strings.ToLower(path.Base(dir))
+	}
+	return typ, scope
+}
+
+// isDocFile returns true for documentation: markdown, reStructuredText and
+// AsciiDoc files and files under a docs directory.
+func isDocFile(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".md", ".rst", ".adoc":
+		return true
+	}
+	return slices.Contains(strings.Split(strings.ToLower(name), "/"), "docs")
+}
+
+// isCIFile returns true for continuous integration configuration.
+func isCIFile(name string) bool {
+	lower := strings.ToLower(name)
+	return strings.HasPrefix(lower, ".github/workflows/") || strings.HasPrefix(lower, ".circleci/") ||
+		lower == ".gitlab-ci.yml" || lower == ".travis.yml" || lower == "azure-pipelines.yml"
+}
+
+// isBuildFile returns true for build files and dependency manifests.
+func isBuildFile(name string) bool {
+	switch strings.ToLower(path.Base(name)) {
+	case "makefile", "dockerfile", "cmakelists.txt", "build.gradle", "pom.xml",
+		"go.mod", "go.sum", "package.json", "package-lock.json", "pnpm-lock.yaml", "yarn.lock",
+		"cargo.toml", "cargo.lock", "pyproject.toml", "poetry.lock", "gemfile", "gemfile.lock", "composer.json", "composer.lock":
+		return true
+	}
+	return false
+}
+
+// conventionalHint returns the metadata section suggesting the type and scope
+// inferred from the paths of diff, or "" when there is nothing to suggest.
+func conventionalHint(diff string) string {
+	files := parseDiff(diff)
+	paths := make([]string, len(files))
+	for i := range files {
+		paths[i] = files[i].path
+	}
+	typ, scope := inferConventional(paths)
+	if typ == "" && scope == "" {
+		return ""
+	}
+	hint := "=== Conventional Commits ===\n"
+	if typ != "" {
+		hint += "Suggested type: " + typ + "\n"
+	}
+	if scope != "" {
+		hint += "Suggested scope: " + scope + "\n"
+	}
+	return hint + "\n"
+}
+
+// GenerateConventionalCommitMsg is GenerateCommitMsg for a message following
+// the Conventional Commits specification, "type(scope): description". The
+// type and scope inferred from the changed paths are suggested in the
+// metadata. A message whose subject fails ValidateConventionalSubject is sent
+// back once to be corrected; it is an error when it still fails.
+func GenerateConventionalCommitMsg(ctx context.Context, p genai.Provider, metadata, diff string, filters []func(string) bool) (string, error) {
+	msg, err := generateMsg(ctx, p, conventionalPrompt, conventionalSynthesizePrompt, conventionalHint(diff)+metadata, diff, filters)
+	if err != nil {
+		return "", err
+	}
+	subject, _, _ := strings.Cut(msg, "\n")
+	if err = ValidateConventionalSubject(subject); err == nil {
+		return msg, nil
+	}
+	if msg, err = genCommitMsg(ctx, p, conventionalFixPrompt, "=== Reason ===\n"+err.Error()+"\n\n=== Commit message ===\n"+msg); err != nil {
+		return "", err
+	}
+	subject, _, _ = strings.Cut(msg, "\n")
+	if err = ValidateConventionalSubject(subject); err != nil {
+		return "", fmt.Errorf("the generated commit message isn't a Conventional Commit: %w", err)
+	}
+	return msg, nil
+}
@@ -0,0 +1,59 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package gitutil
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateConventionalSubject(t *testing.T) {
+	for _, tc := range []struct {
+		subject string
+		want    string
+	}{
+		{"feat(cli): add --json", ""},
+		{"fix: handle tabs", ""},
+		{"refactor(gitutil)!: drop GenerateMsg", ""},
+		{"Add --json", "isn't"},
+		{"feat(): add --json", "isn't"},
+		{"feat:add --json", "isn't"},
+		{"feature: add --json", "unknown type"},
+		{"fix: handle tabs.", "period"},
+		{"fix: " + strings.Repeat("x", 68), "more than 72"},
+	} {
+		err := ValidateConventionalSubject(tc.subject)
+		if tc.want == "" && err != nil {
+			t.Errorf("%q: %v", tc.subject, err)
+		} else if tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)) {
+			t.Errorf("%q: got %v, want %q", tc.subject, err, tc.want)
+		}
+	}
+}
+
+func TestInferConventional(t *testing.T) {
+	for _, tc := range []struct {
+		paths      []string
+		typ, scope string
+	}{
+		{[]string{"gitutil/commitmsg.go", "gitutil/commitmsg_test.go"}, "", "gitutil"},
+		{[]string{"gitutil/commitmsg_test.go", "gitutil/pr_test.go"}, "test", "gitutil"},
+		{[]string{"README.md", "docs/setup.md"}, "docs", ""},
+		{[]string{"cmd/md/main.go", "cmd/md/commands.go"}, "", "md"},
+		{[]string{"cmd/md/main.go", "cmd/other/main.go"}, "", "cmd"},
+		{[]string{".github/workflows/test.yml"}, "ci", "workflows"},
+		{[]string{"go.mod", "go.sum"}, "build", ""},
+		{[]string{"main.go", "go.mod"}, "", ""},
+		{nil, "", ""},
+	} {
+		if typ, scope := inferConventional(tc.paths); typ != tc.typ || scope != tc.scope {
+			t.Errorf("%q: got %q, %q, want %q, %q", tc.paths, typ, scope, tc.typ, tc.scope)
+		}
+	}
+	diff := "diff --git a/docs/a.md b/docs/a.md\n@@ -1 +1 @@\n-a\n+b\n"
+	if got, want := conventionalHint(diff), "=== Conventional Commits ===\nSuggested type: docs\nSuggested scope: docs\n\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
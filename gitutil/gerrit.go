@@ -0,0 +1,127 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package gitutil
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1" //nolint:gosec // Change-Id format, not used for security
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// changeIDRe matches a Change-Id trailer line.
+var changeIDRe = regexp.MustCompile(`^Change-Id: (I[0-9a-f]{40})\s*$`)
+
+// trailerRe matches a git trailer line ("Key: value").
+var trailerRe = regexp.MustCompile(`^[A-Za-z0-9-]+: `)
+
+// NewChangeID returns a Gerrit Change-Id derived from seed. Like the
+// commit-msg hook, it is "I" followed by a SHA-1; the same seed always gives
+// the same ID so that pushing again uploads a new patch set of the same
+// change.
+func NewChangeID(seed string) string {
+	h := sha1.Sum([]byte(seed)) //nolint:gosec // Change-Id format, not used for security
+	return "I" + hex.EncodeToString(h[:])
+}
+
+// ChangeID returns the Change-Id trailer of the commit message msg, or "".
+func ChangeID(msg string) string {
+	paras := strings.Split(strings.TrimSpace(msg), "\n\n")
+	if len(paras) < 2 {
+		return ""
+	}
+	for l := range strings.SplitSeq(paras[len(paras)-1], "\n") {
+		if m := changeIDRe.FindStringSubmatch(l); m != nil {
+			return m[1]
+		}
+	}
+	return ""
+}
+
+// AddChangeID returns msg with a Change-Id trailer set to id, the way
+// Gerrit's commit-msg hook does: it joins the last paragraph when it only
+// holds trailers, before any Signed-off-by line, and starts a new paragraph
+// otherwise. msg is returned unchanged when it already has a Change-Id.
+func AddChangeID(msg, id string) string {
+	msg = strings.TrimSpace(msg)
+	if ChangeID(msg) != "" {
+		return msg + "\n"
+	}
+	trailer := "Change-Id: " + id
+	i := strings.LastIndex(msg, "\n\n")
+	if i < 0 {
+		return msg + "\n\n" + trailer + "\n"
+	}
+	lines := strings.Split(msg[i+2:], "\n")
+	at := len(lines)
+	for j, l := range lines {
+		if !trailerRe.MatchString(l) {
+			return msg + "\n\n" + trailer + "\n"
+		}
+		if at == len(lines) && strings.HasPrefix(l, "Signed-off-by: ") {
+			at = j
+		}
+	}
+	lines = append(lines[:at], append([]string{trailer}, lines[at:]...)...)
+	return msg[:i+2] + strings.Join(lines, "\n") + "\n"
+}
+
+// SquashMerge creates a single commit on top of onto holding the changes of
+// source since their merge base, merged with onto's changes. Unlike
+// [SquashOnto] it doesn't push, and it fails on conflicts instead of
+// reverting onto's changes. It requires git 2.38 or later.
+func SquashMerge(ctx context.Context, dir, onto, source, message string) (string, error) {
+	tree, err := RunGit(ctx, dir, "merge-tree", "--write-tree", "--no-messages", onto, source)
+	if err != nil {
+		return "", fmt.Errorf("%s doesn't merge cleanly into %s; pull and rebase it first: %w", source, onto, err)
+	}
+	// The first line is the tree; conflicted file info follows on failure.
+	tree, _, _ = strings.Cut(tree, "\n")
+	return commitTree(ctx, dir, tree, message, onto)
+}
+
+// commitTree creates a commit of tree with parents and returns its hash.
+func commitTree(ctx context.Context, dir, tree, message string, parents ...string) (string, error) {
+	args := []string{"commit-tree"}
+	for _, p := range parents {
+		args = append(args, "-p", p)
+	}
+	cmd := newGitCmd(ctx, dir, append(args, "-F", "-", tree))
+	cmd.Stdin = strings.NewReader(message)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git commit-tree: %w: %s", err, stderr.String())
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// GerritRef returns the ref to push to for review on branch, with an
+// optional topic.
+func GerritRef(branch, topic string) string {
+	ref := "refs/for/" + branch
+	if topic != "" {
+		ref += "%topic=" + topic
+	}
+	return ref
+}
+
+// PushGerrit pushes commit to remote for review on branch. It returns git's
+// output, which holds the change URL printed by Gerrit.
+func PushGerrit(ctx context.Context, dir, remote, commit, branch, topic string) (string, error) {
+	ref := GerritRef(branch, topic)
+	slog.InfoContext(ctx, "git", "msg", "git push", "remote", remote, "commit", commit, "ref", ref)
+	cmd := newGitCmd(ctx, dir, []string{"push", remote, commit + ":" + ref})
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git push %s %s:%s: %w: %s", remote, commit, ref, err, out)
+	}
+	return string(out), nil
+}
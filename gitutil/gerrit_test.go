@@ -0,0 +1,103 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package gitutil
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAddChangeID(t *testing.T) {
+	id := NewChangeID("seed")
+	if len(id) != 41 || id[0] != 'I' || id != NewChangeID("seed") || id == NewChangeID("other") {
+		t.Fatalf("NewChangeID() = %q", id)
+	}
+	tr := "Change-Id: " + id
+	for _, tc := range []struct {
+		name, msg, want string
+	}{
+		{"SubjectOnly", "Fix it", "Fix it\n\n" + tr + "\n"},
+		{"Body", "Fix it\n\nLonger text.\n", "Fix it\n\nLonger text.\n\n" + tr + "\n"},
+		{"Trailers", "Fix it\n\nBody\n\nBug: 12", "Fix it\n\nBody\n\nBug: 12\n" + tr + "\n"},
+		{"SignedOff", "Fix it\n\nBug: 12\nSigned-off-by: A <a@b>", "Fix it\n\nBug: 12\n" + tr + "\nSigned-off-by: A <a@b>\n"},
+		{"NotTrailers", "Fix it\n\nSee: this\nand that", "Fix it\n\nSee: this\nand that\n\n" + tr + "\n"},
+		{"Existing", "Fix it\n\nChange-Id: I" + strings.Repeat("a", 40), "Fix it\n\nChange-Id: I" + strings.Repeat("a", 40) + "\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := AddChangeID(tc.msg, id)
+			if got != tc.want {
+				t.Errorf("got %q\nwant %q", got, tc.want)
+			}
+			if ChangeID(got) == "" {
+				t.Error("ChangeID() is empty")
+			}
+		})
+	}
+	if got := ChangeID("Change-Id: " + id); got != "" {
+		t.Errorf("subject parsed as trailer: %q", got)
+	}
+}
+
+func TestSquashMerge(t *testing.T) {
+	ctx := t.Context()
+	dir := t.TempDir()
+	run := func(args ...string) string {
+		t.Helper()
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run("init", "-q", "--initial-branch=main")
+	run("config", "user.name", "Test")
+	run("config", "user.email", "test@test")
+	write("a.txt", "a\n")
+	run("add", ".")
+	run("commit", "-q", "-m", "init")
+	run("checkout", "-q", "-b", "feature")
+	write("b.txt", "b\n")
+	run("add", ".")
+	run("commit", "-q", "-m", "add b")
+	write("c.txt", "c\n")
+	run("add", ".")
+	run("commit", "-q", "-m", "add c")
+	run("checkout", "-q", "main")
+	write("d.txt", "d\n")
+	run("add", ".")
+	run("commit", "-q", "-m", "add d")
+
+	commit, err := SquashMerge(ctx, dir, "main", "feature", "Add b and c\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := run("rev-parse", commit+"^"); got != run("rev-parse", "main") {
+		t.Errorf("parent = %s", got)
+	}
+	if got := run("ls-tree", "--name-only", commit); got != "a.txt\nb.txt\nc.txt\nd.txt" {
+		t.Errorf("tree = %q", got)
+	}
+	if got := run("log", "-1", "--format=%B", commit); got != "Add b and c" {
+		t.Errorf("message = %q", got)
+	}
+
+	write("b.txt", "conflict\n")
+	run("add", ".")
+	run("commit", "-q", "-m", "conflicting b")
+	if _, err := SquashMerge(ctx, dir, "main", "feature", "x"); err == nil {
+		t.Error("expected conflict error")
+	}
+}
@@ -44,19 +44,26 @@ func RunGit(ctx context.Context, dir string, args ...string) (string, error) {
 func RootDir(ctx context.Context, wd string) (string, error) {
 	out, err := RunGit(ctx, wd, "rev-parse", "--show-toplevel")
 	if err != nil {
+		if root := jjRoot(wd); root != "" {
+			return "", fmt.Errorf("%s is a jj repository not colocated with git: run 'jj git init --colocate' in it", root)
+		}
 		return "", fmt.Errorf("not a git checkout directory: %s: %w", wd, err)
 	}
 	return out, nil
 }
 
 // CurrentBranch returns the current branch name for the given working
-// directory.
+// directory. In a jj repository, it is the bookmark on the working-copy
+// commit's parent.
 func CurrentBranch(ctx context.Context, wd string) (string, error) {
 	out, err := RunGit(ctx, wd, "branch", "--show-current")
 	if err != nil {
 		return "", fmt.Errorf("check out a named branch: %w", err)
 	}
 	if out == "" {
+		if root, err := RootDir(ctx, wd); err == nil && IsJJ(root) {
+			return jjBookmark(ctx, root)
+		}
 		return "", errors.New("check out a named branch")
 	}
 	return out, nil
@@ -119,6 +126,37 @@ func Fetch(ctx context.Context, dir string) error {
 	return nil
 }
 
+// CheckBranchName returns an error when name isn't a valid branch name, per
+// git check-ref-format --branch, without needing a repository.
+func CheckBranchName(name string) error {
+	bad := func(why string) error { return fmt.Errorf("invalid branch name %q: %s", name, why) }
+	switch {
+	case name == "" || name == "@":
+		return bad("empty or @")
+	case strings.HasPrefix(name, "-"):
+		return bad("starts with -")
+	case strings.HasPrefix(name, "/") || strings.HasSuffix(name, "/"):
+		return bad("starts or ends with /")
+	case strings.HasSuffix(name, "."):
+		return bad("ends with .")
+	case strings.Contains(name, ".."), strings.Contains(name, "//"), strings.Contains(name, "@{"):
+		return bad("contains .., // or @{")
+	case strings.ContainsAny(name, " ~^:?*[\\\x7f"):
+		return bad("contains a space or one of ~^:?*[\\")
+	}
+	for _, r := range name {
+		if r < 0x20 {
+			return bad("contains a control character")
+		}
+	}
+	for part := range strings.SplitSeq(name, "/") {
+		if strings.HasPrefix(part, ".") || strings.HasSuffix(part, ".lock") {
+			return bad("a component starts with . or ends with .lock")
+		}
+	}
+	return nil
+}
+
 // CreateBranch creates a new branch from startPoint without touching the
 // working tree or index.
 func CreateBranch(ctx context.Context, dir, name, startPoint string) error {
@@ -237,6 +275,92 @@ func RevParse(ctx context.Context, dir, ref string) (string, error) {
 	return RunGit(ctx, dir, "rev-parse", "--verify", ref)
 }
 
+// Conflicts returns the files conflicting when merging theirs into ours,
+// without touching the working tree. It requires git 2.38 or later.
+func Conflicts(ctx context.Context, dir, ours, theirs string) ([]string, error) {
+	cmd := newGitCmd(ctx, dir, []string{"merge-tree", "--write-tree", "--name-only", "--no-messages", ours, theirs})
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		// Exit code 1 with a tree means conflicts, listed after it.
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 || len(out) == 0 {
+			return nil, fmt.Errorf("git merge-tree: %w: %s", err, stderr.String())
+		}
+	}
+	var files []string
+	for i, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if i != 0 && line != "" && !slices.Contains(files, line) {
+			files = append(files, line)
+		}
+	}
+	return files, nil
+}
+
+// Merge creates a merge commit of theirs into ours and returns its hash,
+// without touching the working tree. It fails on conflicts. It requires git
+// 2.38 or later.
+func Merge(ctx context.Context, dir, ours, theirs, message string) (string, error) {
+	tree, err := RunGit(ctx, dir, "merge-tree", "--write-tree", "--no-messages", ours, theirs)
+	if err != nil {
+		return "", fmt.Errorf("%s doesn't merge cleanly into %s: %w", theirs, ours, err)
+	}
+	tree, _, _ = strings.Cut(tree, "\n")
+	return commitTree(ctx, dir, tree, message, ours, theirs)
+}
+
+// Snapshot returns a commit on top of HEAD with the working tree's changes,
+// untracked files included and ignored ones excluded, or HEAD when there are
+// none. The index and the working tree are left alone.
+func Snapshot(ctx context.Context, dir, message string) (string, error) {
+	head, err := RunGit(ctx, dir, "rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	index, err := RunGit(ctx, dir, "rev-parse", "--path-format=absolute", "--git-path", "index")
+	if err != nil {
+		return "", err
+	}
+	// A copy of the index keeps its stat data, sparing git from hashing
+	// every file again.
+	f, err := os.CreateTemp(filepath.Dir(index), "md-snapshot-index-*")
+	if err != nil {
+		return "", err
+	}
+	tmp := f.Name()
+	defer func() { _ = os.Remove(tmp) }()
+	b, err := os.ReadFile(index)
+	if err == nil {
+		_, err = f.Write(b)
+	} else if errors.Is(err, os.ErrNotExist) {
+		// git creates the index but refuses an empty one.
+		err = os.Remove(tmp)
+	}
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return "", err
+	}
+	var tree string
+	for _, args := range [][]string{{"add", "-A", ":/"}, {"write-tree"}} {
+		cmd := newGitCmd(ctx, dir, args)
+		cmd.Env = append(cmd.Env, "GIT_INDEX_FILE="+tmp)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("git %s: %w: %s", strings.Join(args, " "), err, stderr.String())
+		}
+		tree = strings.TrimSpace(string(out))
+	}
+	if headTree, err := RunGit(ctx, dir, "rev-parse", "HEAD^{tree}"); err != nil || tree == headTree {
+		return head, err
+	}
+	return commitTree(ctx, dir, tree, message, head)
+}
+
 // IsReachable reports whether commit is an ancestor of (or equal to) any ref
 // in refs/heads/ or refs/remotes/origin/. Container remote-tracking refs
 // (refs/remotes/<container>/*) are excluded by construction.
@@ -630,3 +630,144 @@ func TestCreateBranch(t *testing.T) {
 		}
 	})
 }
+
+func TestConflictsAndMerge(t *testing.T) {
+	ctx := t.Context()
+	dir := t.TempDir()
+	run := func(args ...string) string {
+		t.Helper()
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run("init", "-q", "--initial-branch=main")
+	run("config", "user.name", "Test")
+	run("config", "user.email", "test@test")
+	write("a.txt", "a\n")
+	write("b.txt", "b\n")
+	run("add", ".")
+	run("commit", "-q", "-m", "init")
+	run("checkout", "-q", "-b", "feature")
+	write("a.txt", "feature\n")
+	run("commit", "-q", "-am", "feature")
+	run("checkout", "-q", "main")
+	write("c.txt", "c\n")
+	run("add", ".")
+	run("commit", "-q", "-m", "main")
+
+	if files, err := Conflicts(ctx, dir, "main", "feature"); err != nil || len(files) != 0 {
+		t.Fatalf("Conflicts() = %q, %v", files, err)
+	}
+	commit, err := Merge(ctx, dir, "main", "feature", "Merge feature\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := run("rev-list", "--parents", "-1", commit); got != commit+" "+run("rev-parse", "main")+" "+run("rev-parse", "feature") {
+		t.Errorf("parents = %s", got)
+	}
+	if got := run("show", commit+":a.txt"); got != "feature" {
+		t.Errorf("a.txt = %q", got)
+	}
+
+	write("a.txt", "main\n")
+	write("b.txt", "main\n")
+	run("commit", "-q", "-am", "conflicting a")
+	run("checkout", "-q", "feature")
+	write("b.txt", "feature\n")
+	run("commit", "-q", "-am", "conflicting b")
+	files, err := Conflicts(ctx, dir, "main", "feature")
+	if err != nil || !slices.Equal(files, []string{"a.txt", "b.txt"}) {
+		t.Errorf("Conflicts() = %q, %v", files, err)
+	}
+	if _, err := Merge(ctx, dir, "main", "feature", "x"); err == nil {
+		t.Error("expected conflict error")
+	}
+	if _, err := Conflicts(ctx, dir, "main", "nonexistent"); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	ctx := t.Context()
+	dir := t.TempDir()
+	run := func(args ...string) string {
+		t.Helper()
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run("init", "-q", "--initial-branch=main")
+	run("config", "user.name", "Test")
+	run("config", "user.email", "test@test")
+	write(".gitignore", "*.log\n")
+	write("a.txt", "a\n")
+	write("b.txt", "b\n")
+	run("add", ".")
+	run("commit", "-q", "-m", "init")
+	head := run("rev-parse", "HEAD")
+
+	if got, err := Snapshot(ctx, dir, "wip"); err != nil || got != head {
+		t.Fatalf("clean: %q, %v", got, err)
+	}
+	write("a.txt", "changed\n")
+	write("new.txt", "new\n")
+	write("build.log", "ignored\n")
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	write("sub/staged.txt", "staged\n")
+	run("add", "sub")
+	run("rm", "-q", "--cached", "b.txt")
+	status := run("status", "--porcelain")
+
+	// From a subdirectory, the whole tree is included.
+	got, err := Snapshot(ctx, filepath.Join(dir, "sub"), "wip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run("rev-parse", got+"^") != head || run("log", "-1", "--format=%s", got) != "wip" {
+		t.Errorf("commit %s isn't on HEAD", got)
+	}
+	if files := run("ls-tree", "-r", "--name-only", got); files != ".gitignore\na.txt\nb.txt\nnew.txt\nsub/staged.txt" {
+		t.Errorf("files: %q", files)
+	}
+	if a := run("show", got+":a.txt"); a != "changed" {
+		t.Errorf("a.txt: %q", a)
+	}
+	if run("rev-parse", "HEAD") != head || run("status", "--porcelain") != status {
+		t.Error("the repository changed")
+	}
+}
+
+func TestCheckBranchName(t *testing.T) {
+	for _, name := range []string{"main", "agent/claude/42-fix-parser", "feature.x", "a-b_c"} {
+		if err := CheckBranchName(name); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "@", "-x", "/x", "x/", "x.", "a..b", "a//b", "a@{1}", "a b", "a~1", "a^", "a:b", "a?", "a*", "a[", `a\b`, "a\tb", "a/.b", "a.lock", "a/b.lock/c"} {
+		if err := CheckBranchName(name); err == nil {
+			t.Errorf("%q: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package gitutil
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Mercurial repositories are bridged to git with the hg-git extension: "hg
+// gexport" writes the hg history to the bare git repository .hg/git, md works
+// on a git mirror of it, and "hg gimport" brings the commits pulled from a
+// container back into hg. The mirror lives in the user cache directory and
+// records its hg repository in the md.hgroot git config key.
+
+// HgRoot returns the root of the Mercurial repository holding wd, or "".
+func HgRoot(wd string) string {
+	dir, err := filepath.Abs(wd)
+	if err != nil {
+		return ""
+	}
+	for {
+		if fi, err := os.Stat(filepath.Join(dir, ".hg")); err == nil && fi.IsDir() {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// runHg runs hg in hgRoot with the hg-git extension enabled.
+func runHg(ctx context.Context, hgRoot string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "hg", append([]string{"--config", "extensions.hggit=", "-R", hgRoot}, args...)...)
+	cmd.Env = append(os.Environ(), "LANG=C", "HGPLAIN=1")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return "", errors.New("hg not found: install Mercurial and hg-git to use Mercurial repositories")
+		}
+		return "", fmt.Errorf("hg %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// HgMirror exports the Mercurial repository at hgRoot to its git mirror and
+// returns the mirror's root. The active bookmark becomes the mirror's current
+// branch; only committed changes are exported. The mirror is owned by md:
+// its working tree and branch are reset to the hg state every time.
+func HgMirror(ctx context.Context, hgRoot string) (string, error) {
+	bookmark, err := runHg(ctx, hgRoot, "log", "-r", ".", "-T", "{activebookmark}")
+	if err != nil {
+		return "", err
+	}
+	if bookmark == "" {
+		return "", fmt.Errorf("%s: activate a bookmark with 'hg bookmark <name>'; md maps it to a git branch", hgRoot)
+	}
+	slog.InfoContext(ctx, "git", "msg", "hg gexport", "dir", hgRoot)
+	if _, err := runHg(ctx, hgRoot, "gexport"); err != nil {
+		return "", fmt.Errorf("exporting to git (is hg-git installed?): %w", err)
+	}
+	mirror, err := hgMirrorDir(hgRoot)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(filepath.Join(mirror, ".git")); err != nil {
+		if err := os.MkdirAll(filepath.Dir(mirror), 0o700); err != nil {
+			return "", err
+		}
+		if _, err := RunGit(ctx, "", "clone", "-q", "--no-checkout", filepath.Join(hgRoot, ".hg", "git"), mirror); err != nil {
+			return "", err
+		}
+		if _, err := RunGit(ctx, mirror, "config", "md.hgroot", hgRoot); err != nil {
+			return "", err
+		}
+	} else if _, err := RunGit(ctx, mirror, "fetch", "-q", "--prune", "origin"); err != nil {
+		return "", err
+	}
+	if _, err := RunGit(ctx, mirror, "checkout", "-q", "-f", "-B", bookmark, "origin/"+bookmark); err != nil {
+		return "", err
+	}
+	return mirror, nil
+}
+
+// hgMirrorDir returns the git mirror's directory for hgRoot.
+func hgMirrorDir(hgRoot string) (string, error) {
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256([]byte(hgRoot))
+	// The base name keeps the repository name, which md uses for container
+	// names and the directory in the container.
+	return filepath.Join(cache, "md", "hg", hex.EncodeToString(h[:6]), filepath.Base(hgRoot)), nil
+}
+
+// HgSource returns the Mercurial repository gitRoot mirrors, or "" when it
+// is a regular git repository.
+func HgSource(ctx context.Context, gitRoot string) string {
+	out, err := RunGit(ctx, gitRoot, "config", "--get", "md.hgroot")
+	if err != nil {
+		return ""
+	}
+	return out
+}
+
+// HgImport translates branch of the git mirror at gitRoot back into its
+// Mercurial repository: the branch is pushed to .hg/git and imported with
+// "hg gimport", which moves the bookmark of the same name. The hg working
+// copy isn't updated.
+func HgImport(ctx context.Context, gitRoot, branch string) error {
+	hgRoot := HgSource(ctx, gitRoot)
+	if hgRoot == "" {
+		return fmt.Errorf("%s is not a Mercurial mirror", gitRoot)
+	}
+	if _, err := RunGit(ctx, gitRoot, "push", "-q", "origin", branch); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "git", "msg", "hg gimport", "dir", hgRoot)
+	if _, err := runHg(ctx, hgRoot, "gimport"); err != nil {
+		return fmt.Errorf("importing from git: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package gitutil
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHg(t *testing.T) {
+	ctx := t.Context()
+	hgRoot := t.TempDir()
+	sub := filepath.Join(hgRoot, "sub")
+	if err := os.MkdirAll(filepath.Join(hgRoot, ".hg"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if got := HgRoot(sub); got != hgRoot {
+		t.Errorf("HgRoot() = %q, want %q", got, hgRoot)
+	}
+	if got := HgRoot(t.TempDir()); got != "" {
+		t.Errorf("HgRoot() = %q", got)
+	}
+
+	mirror := t.TempDir()
+	cmd := exec.CommandContext(ctx, "git", "init", "-q")
+	cmd.Dir = mirror
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v\n%s", err, out)
+	}
+	if got := HgSource(ctx, mirror); got != "" {
+		t.Errorf("HgSource() = %q", got)
+	}
+	if err := HgImport(ctx, mirror, "main"); err == nil || !strings.Contains(err.Error(), "not a Mercurial mirror") {
+		t.Errorf("HgImport() error = %v", err)
+	}
+	if _, err := RunGit(ctx, mirror, "config", "md.hgroot", hgRoot); err != nil {
+		t.Fatal(err)
+	}
+	if got := HgSource(ctx, mirror); got != hgRoot {
+		t.Errorf("HgSource() = %q, want %q", got, hgRoot)
+	}
+	dir, err := hgMirrorDir(hgRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(dir) != filepath.Base(hgRoot) {
+		t.Errorf("hgMirrorDir() = %q", dir)
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package gitutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// IsJJ reports whether the git repository at root is a Jujutsu (jj)
+// repository colocated with git.
+//
+// In a colocated repository HEAD is detached at the parent of jj's
+// working-copy commit (@-), bookmarks are exported as refs/heads/*, and the
+// working tree holds the working-copy commit's changes.
+func IsJJ(root string) bool {
+	fi, err := os.Stat(filepath.Join(root, ".jj"))
+	return err == nil && fi.IsDir()
+}
+
+// jjRoot returns the closest directory from wd up holding a .jj directory,
+// or "".
+func jjRoot(wd string) string {
+	dir, err := filepath.Abs(wd)
+	if err != nil {
+		return ""
+	}
+	for {
+		if IsJJ(dir) {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// jjBookmark returns the bookmark at HEAD, the parent of the working-copy
+// commit, read from the git refs jj exports so it doesn't depend on the jj
+// CLI version.
+func jjBookmark(ctx context.Context, root string) (string, error) {
+	out, err := RunGit(ctx, root, "for-each-ref", "--points-at", "HEAD", "--format=%(refname:short)", "refs/heads/")
+	if err != nil {
+		return "", err
+	}
+	switch names := strings.Fields(out); len(names) {
+	case 0:
+		return "", errors.New("no jj bookmark on @-: create one with 'jj bookmark set <name> -r @-'")
+	case 1:
+		return names[0], nil
+	default:
+		return "", fmt.Errorf("several jj bookmarks on @- (%s): use -b to pick one", strings.Join(names, ", "))
+	}
+}
+
+// IsDirty reports whether the checkout at wd has changes not committed on
+// its branch: modified tracked files for git, any change in the working-copy
+// commit for jj, which tracks new files automatically.
+func IsDirty(ctx context.Context, wd string) (bool, error) {
+	root, err := RootDir(ctx, wd)
+	if err != nil {
+		return false, err
+	}
+	if IsJJ(root) {
+		out, err := RunGit(ctx, root, "status", "--porcelain")
+		return out != "", err
+	}
+	if _, err := RunGit(ctx, root, "diff", "--quiet", "--exit-code"); err != nil {
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package gitutil
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJJ(t *testing.T) {
+	ctx := t.Context()
+	dir := t.TempDir()
+	run := func(args ...string) {
+		t.Helper()
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	run("init", "-q", "--initial-branch=main")
+	run("config", "user.name", "Test")
+	run("config", "user.email", "test@test")
+	run("commit", "-q", "--allow-empty", "-m", "init")
+	// Simulate a colocated jj repository: HEAD detached at @-, a .jj
+	// directory ignoring itself, and the working-copy changes in the tree.
+	run("checkout", "-q", "--detach")
+	if err := os.MkdirAll(filepath.Join(dir, ".jj"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".jj", ".gitignore"), []byte("/*\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if !IsJJ(dir) {
+		t.Fatal("IsJJ() = false")
+	}
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := CurrentBranch(ctx, sub); err != nil || got != "main" {
+		t.Errorf("CurrentBranch() = %q, %v", got, err)
+	}
+	run("branch", "other")
+	if _, err := CurrentBranch(ctx, dir); err == nil || !strings.Contains(err.Error(), "several jj bookmarks") {
+		t.Errorf("CurrentBranch() error = %v", err)
+	}
+	if dirty, err := IsDirty(ctx, dir); err != nil || dirty {
+		t.Errorf("IsDirty() = %t, %v", dirty, err)
+	}
+	// jj tracks new files, so an untracked file is a change.
+	if err := os.WriteFile(filepath.Join(dir, "new.txt"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if dirty, err := IsDirty(ctx, dir); err != nil || !dirty {
+		t.Errorf("IsDirty() = %t, %v", dirty, err)
+	}
+
+	// A jj repository with its own git store isn't usable.
+	plain := t.TempDir()
+	if err := os.Mkdir(filepath.Join(plain, ".jj"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RootDir(ctx, plain); err == nil || !strings.Contains(err.Error(), "colocate") {
+		t.Errorf("RootDir() error = %v", err)
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package gitutil
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Kinds of [NestedRepo].
+const (
+	// NestedSubmodule is a submodule declared in .gitmodules.
+	NestedSubmodule = "submodule"
+	// NestedGitlink is a repository added to the index as a gitlink without
+	// being declared in .gitmodules, e.g. with "git add" on a clone.
+	NestedGitlink = "gitlink"
+	// NestedUntracked is an untracked repository in the working tree.
+	NestedUntracked = "untracked"
+)
+
+// NestedRepo is a repository inside another one's working tree whose
+// content git doesn't carry along with the outer repository's commits.
+type NestedRepo struct {
+	// Path is relative to the outer repository's root, with forward slashes.
+	Path string `json:"path"`
+	Kind string `json:"kind"`
+	// Commit is the commit HEAD records for a submodule or a gitlink, empty
+	// when HEAD doesn't have it yet.
+	Commit string `json:"commit,omitempty"`
+	// NewCommit is set when a submodule has another commit checked out.
+	NewCommit bool `json:"new_commit,omitempty"`
+	// Modified is set when a submodule has changes to its tracked files.
+	Modified bool `json:"modified,omitempty"`
+	// Untracked is set when a submodule has untracked files.
+	Untracked bool `json:"untracked,omitempty"`
+}
+
+// String describes what git would leave out of the repository.
+func (n *NestedRepo) String() string {
+	switch n.Kind {
+	case NestedSubmodule:
+		var s []string
+		if n.NewCommit {
+			s = append(s, "another commit checked out than recorded")
+		}
+		if n.Modified {
+			s = append(s, "modified files")
+		}
+		if n.Untracked {
+			s = append(s, "untracked files")
+		}
+		return n.Path + " (submodule): " + strings.Join(s, ", ")
+	case NestedGitlink:
+		return n.Path + " (repository added without .gitmodules): only its commit hash is tracked, not its files"
+	default:
+		return n.Path + " (untracked repository): git doesn't look inside it"
+	}
+}
+
+// FindNestedRepos returns the repositories nested in dir's working tree
+// whose content a push of dir's commits leaves out: submodules with changes
+// HEAD doesn't record, gitlinks not declared in .gitmodules and untracked
+// repositories. Ignored ones and clean submodules aren't reported.
+func FindNestedRepos(ctx context.Context, dir string) ([]NestedRepo, error) {
+	subs, err := ListSubmodules(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	declared := map[string]bool{}
+	for _, s := range subs {
+		declared[s.Path] = true
+	}
+	staged, err := RunGit(ctx, dir, "ls-files", "-z", "--stage")
+	if err != nil {
+		return nil, err
+	}
+	byPath := map[string]*NestedRepo{}
+	for e := range strings.SplitSeq(staged, "\x00") {
+		// "<mode> <hash> <stage>\t<path>"
+		meta, p, ok := strings.Cut(e, "\t")
+		if f := strings.Fields(meta); ok && len(f) == 3 && f[0] == "160000" && !declared[p] {
+			byPath[p] = &NestedRepo{Path: p, Kind: NestedGitlink, Commit: f[1]}
+		}
+	}
+	status, err := RunGit(ctx, dir, "status", "-z", "--porcelain=v2", "--untracked-files=all", "--ignore-submodules=none")
+	if err != nil {
+		return nil, err
+	}
+	entries := strings.Split(status, "\x00")
+	for i := 0; i < len(entries); i++ {
+		e := entries[i]
+		switch {
+		case strings.HasPrefix(e, "? "):
+			// Git doesn't descend in a nested repository: it is listed as a
+			// directory.
+			if p, ok := strings.CutSuffix(e[2:], "/"); ok {
+				if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(p), ".git")); err == nil {
+					byPath[p] = &NestedRepo{Path: p, Kind: NestedUntracked}
+				}
+			}
+		case strings.HasPrefix(e, "1 "), strings.HasPrefix(e, "2 "):
+			// "1 <XY> <sub> <mH> <mI> <mW> <hH> <hI> <path>"; a rename has
+			// a score before the path and is followed by its original path.
+			n := 9
+			if e[0] == '2' {
+				n = 10
+				i++
+			}
+			f := strings.SplitN(e, " ", n)
+			if len(f) != n || len(f[2]) != 4 || f[2][0] != 'S' {
+				continue
+			}
+			p := f[n-1]
+			sub := f[2]
+			if !declared[p] {
+				// Reported above, dirty or not.
+				continue
+			}
+			if sub[1:] == "..." {
+				continue
+			}
+			r := &NestedRepo{Path: p, Kind: NestedSubmodule, NewCommit: sub[1] == 'C', Modified: sub[2] == 'M', Untracked: sub[3] == 'U'}
+			if strings.Trim(f[6], "0") != "" {
+				r.Commit = f[6]
+			}
+			byPath[p] = r
+		}
+	}
+	out := make([]NestedRepo, 0, len(byPath))
+	for _, r := range byPath {
+		out = append(out, *r)
+	}
+	slices.SortFunc(out, func(a, b NestedRepo) int { return strings.Compare(a.Path, b.Path) })
+	return out, nil
+}
+
+// NestedFiles returns the files of n to copy for dir's working tree to have
+// its content, and the ones to delete, relative to dir with forward slashes.
+// For a submodule, these are the differences between the commit HEAD
+// records and its working tree; otherwise every file that isn't ignored.
+// The nested repository's own .git isn't included.
+func NestedFiles(ctx context.Context, dir string, n *NestedRepo) (send, remove []string, err error) {
+	sub := filepath.Join(dir, filepath.FromSlash(n.Path))
+	var changed string
+	if n.Kind == NestedSubmodule && n.Commit != "" {
+		if changed, err = RunGit(ctx, sub, "diff", "-z", "--name-only", "--no-renames", n.Commit); err != nil {
+			return nil, nil, err
+		}
+	} else if changed, err = RunGit(ctx, sub, "ls-files", "-z", "--cached"); err != nil {
+		return nil, nil, err
+	}
+	others, err := RunGit(ctx, sub, "ls-files", "-z", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, list := range []string{changed, others} {
+		for p := range strings.SplitSeq(list, "\x00") {
+			if p == "" {
+				continue
+			}
+			fi, err := os.Lstat(filepath.Join(sub, filepath.FromSlash(p)))
+			switch {
+			case errors.Is(err, os.ErrNotExist):
+				remove = append(remove, path.Join(n.Path, p))
+			case err != nil:
+				return nil, nil, err
+			case !fi.IsDir():
+				// A directory is a submodule of the nested repository.
+				send = append(send, path.Join(n.Path, p))
+			}
+		}
+	}
+	return send, remove, nil
+}
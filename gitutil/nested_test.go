@@ -0,0 +1,104 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package gitutil
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestFindNestedRepos(t *testing.T) {
+	ctx := t.Context()
+	dir := t.TempDir()
+	run := func(d string, args ...string) {
+		t.Helper()
+		cmd := exec.CommandContext(ctx, "git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@test", "-c", "protocol.file.allow=always"}, args...)...)
+		cmd.Dir = d
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	write := func(p, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sub := filepath.Join(dir, "sub")
+	run(dir, "init", "-q", "--initial-branch=main", sub)
+	write(filepath.Join(sub, "a"), "a\n")
+	write(filepath.Join(sub, "b"), "b\n")
+	run(sub, "add", ".")
+	run(sub, "commit", "-q", "-m", "init")
+
+	main := filepath.Join(dir, "main")
+	run(dir, "init", "-q", "--initial-branch=main", main)
+	write(filepath.Join(main, "README"), "hi\n")
+	run(main, "add", "README")
+	run(main, "submodule", "add", "-q", sub, "lib/sub")
+	run(main, "submodule", "add", "-q", sub, "lib/clean")
+	run(main, "commit", "-q", "-m", "init")
+
+	got, err := FindNestedRepos(ctx, main)
+	if err != nil || len(got) != 0 {
+		t.Fatalf("clean: got %+v, %v", got, err)
+	}
+
+	write(filepath.Join(main, "lib", "sub", "a"), "changed\n")
+	write(filepath.Join(main, "lib", "sub", "new"), "new\n")
+	if err := os.Remove(filepath.Join(main, "lib", "sub", "b")); err != nil {
+		t.Fatal(err)
+	}
+	nested := filepath.Join(main, "vendor", "dep")
+	run(main, "init", "-q", nested)
+	write(filepath.Join(nested, "dep.go"), "package dep\n")
+	write(filepath.Join(main, "ignored", "x"), "x\n")
+	run(main, "init", "-q", filepath.Join(main, "ignored", "repo"))
+	write(filepath.Join(main, ".gitignore"), "/ignored/\n")
+	gl := filepath.Join(main, "gl")
+	run(main, "init", "-q", gl)
+	write(filepath.Join(gl, "q"), "q\n")
+	run(gl, "add", "q")
+	run(gl, "commit", "-q", "-m", "q")
+	run(main, "add", "gl")
+
+	got, err = FindNestedRepos(ctx, main)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("got %+v", got)
+	}
+	if g := got[0]; g.Path != "gl" || g.Kind != NestedGitlink || g.Commit == "" {
+		t.Errorf("got %+v", g)
+	}
+	if g := got[1]; g.Path != "lib/sub" || g.Kind != NestedSubmodule || g.Commit == "" || g.NewCommit || !g.Modified || !g.Untracked {
+		t.Errorf("got %+v", g)
+	}
+	if g := got[2]; g.Path != "vendor/dep" || g.Kind != NestedUntracked {
+		t.Errorf("got %+v", g)
+	}
+
+	send, remove, err := NestedFiles(ctx, main, &got[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(send, []string{"lib/sub/a", "lib/sub/new"}) || !slices.Equal(remove, []string{"lib/sub/b"}) {
+		t.Errorf("got %q, %q", send, remove)
+	}
+	send, remove, err = NestedFiles(ctx, main, &got[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(send, []string{"vendor/dep/dep.go"}) || len(remove) != 0 {
+		t.Errorf("got %q, %q", send, remove)
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package gitutil
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/maruel/genai"
+)
+
+// prPrompt is the system prompt used by GeneratePRDescription.
+const prPrompt = "Write a GitHub pull request title and description for the changes below. Follow these rules:\n" +
+	"- First line: the title, imperative mood, no period, max 72 chars\n" +
+	"- Then a blank line and the description in markdown: what changed and why, and what a reviewer should look at\n" +
+	"- Keep it short: a paragraph or a bullet list, no headings for small changes\n" +
+	"- Match the style of recent upstream commits if provided\n" +
+	"- No emojis\n" +
+	"- Output only the title and the description, nothing else"
+
+// prSynthesizePrompt combines chunk summaries into a pull request
+// description for large diffs.
+const prSynthesizePrompt = "Below are descriptions of different parts of the same pull request. " +
+	"Write a single GitHub pull request title and description following these rules:\n" +
+	"- First line: the title, imperative mood, no period, max 72 chars\n" +
+	"- Then a blank line and the description in markdown: what changed and why\n" +
+	"- Match the style of recent upstream commits if provided\n" +
+	"- No emojis\n" +
+	"- Output only the title and the description, nothing else"
+
+// GeneratePRDescription asks the LLM for a pull request title and body
+// describing diff. metadata and diff are as for GenerateCommitMsg and go
+// through the same reduction pipeline.
+func GeneratePRDescription(ctx context.Context, p genai.Provider, metadata, diff string) (title, body string, err error) {
+	out, err := generateMsg(ctx, p, prPrompt, prSynthesizePrompt, metadata, diff, nil)
+	if err != nil {
+		return "", "", err
+	}
+	title, body = splitPRDescription(out)
+	if title == "" {
+		return "", "", errors.New("the LLM returned an empty pull request description")
+	}
+	return title, body, nil
+}
+
+// splitPRDescription splits the LLM's answer to prPrompt in a title and a
+// body, dropping a wrapping code block and a "Title:" or heading prefix.
+func splitPRDescription(out string) (title, body string) {
+	out = strings.TrimSpace(out)
+	if strings.HasPrefix(out, "```") && strings.HasSuffix(out, "```") {
+		// The opening line may name the code block's language.
+		if _, rest, ok := strings.Cut(out, "\n"); ok {
+			out = strings.TrimSpace(strings.TrimSuffix(rest, "```"))
+		}
+	}
+	title, body, _ = strings.Cut(out, "\n")
+	title = strings.TrimSpace(strings.TrimLeft(title, "# "))
+	if t, ok := strings.CutPrefix(title, "Title:"); ok {
+		title = strings.TrimSpace(t)
+	}
+	body = strings.TrimSpace(body)
+	if b, ok := strings.CutPrefix(body, "Description:"); ok {
+		body = strings.TrimSpace(b)
+	}
+	return title, body
+}
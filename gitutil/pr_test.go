@@ -0,0 +1,24 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package gitutil
+
+import "testing"
+
+func TestSplitPRDescription(t *testing.T) {
+	for _, tc := range []struct {
+		out, title, body string
+	}{
+		{"Fix the parser\n\nIt choked on tabs.\n", "Fix the parser", "It choked on tabs."},
+		{"Refactor", "Refactor", ""},
+		{"```markdown\n# Fix the parser\n\n- Handle tabs\n```", "Fix the parser", "- Handle tabs"},
+		{"```\nTitle: Fix the parser\n\nDescription: It choked on tabs.\n```", "Fix the parser", "It choked on tabs."},
+		{"", "", ""},
+	} {
+		title, body := splitPRDescription(tc.out)
+		if title != tc.title || body != tc.body {
+			t.Errorf("%q: got %q, %q", tc.out, title, body)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package gitutil
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Progress is one update of a git transfer, as git prints it on stderr with
+// --progress, e.g. "Writing objects:  45% (9/20), 1.20 MiB | 2.00 MiB/s".
+type Progress struct {
+	// Phase is the step, e.g. "Writing objects" or "Receiving objects".
+	Phase string
+	// Remote is set for the other side's progress, relayed on git's sideband.
+	Remote bool
+	// Percent is -1 for steps that only count, like "Enumerating objects".
+	Percent int
+	Done    int
+	Total   int
+	// Transferred is the data sent or received so far, e.g. "1.20 MiB", when
+	// git reports it.
+	Transferred string
+}
+
+var reProgress = regexp.MustCompile(`^(remote: )?([A-Za-z][A-Za-z ]*): +(?:(\d+)% \((\d+)/(\d+)\)|(\d+))(?:, (\d[^|,]*?))?(?: \|[^,]*)?(?:, .*)?$`)
+
+// ParseProgress parses a line of git's progress output.
+func ParseProgress(line string) (Progress, bool) {
+	m := reProgress.FindStringSubmatch(strings.TrimSpace(line))
+	if m == nil {
+		return Progress{}, false
+	}
+	p := Progress{Phase: m[2], Remote: m[1] != "", Percent: -1, Transferred: strings.TrimSpace(m[7])}
+	if m[3] != "" {
+		p.Percent, _ = strconv.Atoi(m[3])
+		p.Done, _ = strconv.Atoi(m[4])
+		p.Total, _ = strconv.Atoi(m[5])
+	} else {
+		p.Done, _ = strconv.Atoi(m[6])
+	}
+	return p, true
+}
+
+// stripProgress removes git's progress lines from its stderr, so the
+// remaining messages can go in an error.
+func stripProgress(stderr string) string {
+	var lines []string
+	for line := range strings.FieldsFuncSeq(stderr, func(r rune) bool { return r == '\r' || r == '\n' }) {
+		if _, ok := ParseProgress(line); !ok {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// progressWriter splits git's stderr on the carriage returns and newlines
+// git uses to redraw its progress.
+type progressWriter struct {
+	fn  func(Progress)
+	buf []byte
+}
+
+// NewProgressWriter returns a writer for git's stderr calling fn with each
+// progress update. Other output is discarded.
+func NewProgressWriter(fn func(Progress)) io.Writer {
+	return &progressWriter{fn: fn}
+}
+
+func (w *progressWriter) Write(b []byte) (int, error) {
+	w.buf = append(w.buf, b...)
+	for {
+		i := bytes.IndexAny(w.buf, "\r\n")
+		if i < 0 {
+			return len(b), nil
+		}
+		if p, ok := ParseProgress(string(w.buf[:i])); ok {
+			w.fn(p)
+		}
+		w.buf = w.buf[i+1:]
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package gitutil
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseProgress(t *testing.T) {
+	for _, tc := range []struct {
+		line string
+		want Progress
+	}{
+		{"Writing objects:  45% (9/20), 1.20 MiB | 2.00 MiB/s", Progress{Phase: "Writing objects", Percent: 45, Done: 9, Total: 20, Transferred: "1.20 MiB"}},
+		{"Receiving objects: 100% (20/20), 3.10 MiB | 2.00 MiB/s, done.", Progress{Phase: "Receiving objects", Percent: 100, Done: 20, Total: 20, Transferred: "3.10 MiB"}},
+		{"Counting objects: 100% (5/5), done.", Progress{Phase: "Counting objects", Percent: 100, Done: 5, Total: 5}},
+		{"Enumerating objects: 12, done.", Progress{Phase: "Enumerating objects", Percent: -1, Done: 12}},
+		{"remote: Resolving deltas: 100% (3/3), completed with 2 local objects.        ", Progress{Phase: "Resolving deltas", Remote: true, Percent: 100, Done: 3, Total: 3}},
+	} {
+		got, ok := ParseProgress(tc.line)
+		if !ok || got != tc.want {
+			t.Errorf("%q:\n got %+v, %t\nwant %+v", tc.line, got, ok, tc.want)
+		}
+	}
+	for _, line := range []string{"To /tmp/repo", " + 1234567...89abcde main -> base (forced update)", "Total 3 (delta 0), reused 0 (delta 0)"} {
+		if p, ok := ParseProgress(line); ok {
+			t.Errorf("%q: got %+v", line, p)
+		}
+	}
+}
+
+func TestProgressWriter(t *testing.T) {
+	var got []int
+	w := NewProgressWriter(func(p Progress) { got = append(got, p.Percent) })
+	for _, chunk := range []string{"Writing objects:  50% (1/2)\rWriting obj", "ects: 100% (2/2), 1 KiB | 1 MiB/s, done.\nTo /tmp/x\n"} {
+		if _, err := w.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !slices.Equal(got, []int{50, 100}) {
+		t.Errorf("got %v", got)
+	}
+	if s := stripProgress("Writing objects:  50% (1/2)\rTo /tmp/x\n ! [rejected] main -> base\n"); s != "To /tmp/x\n ! [rejected] main -> base" {
+		t.Errorf("got %q", s)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package gitutil
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/maruel/genai"
+	"golang.org/x/sync/errgroup"
+)
+
+// Severities of a [ReviewFinding].
+const (
+	SeverityError      = "error"
+	SeverityWarning    = "warning"
+	SeveritySuggestion = "suggestion"
+)
+
+// ReviewFinding is an issue GenerateReview found in a diff.
+type ReviewFinding struct {
+	File string `json:"file"`
+	// Line is the line in the new version of File, 0 when the finding is
+	// about the file as a whole.
+	Line     int    `json:"line"`
+	Severity string `json:"severity"`
+	Comment  string `json:"comment"`
+}
+
+// reviewPrompt is the system prompt used by GenerateReview for each chunk of
+// the diff.
+const reviewPrompt = "Review the code changes below as a senior engineer would. Report only real problems: bugs, " +
+	"security issues, race conditions, error handling mistakes, missing tests for risky logic, and confusing code. " +
+	"Don't comment on formatting or on code that didn't change. Answer with a JSON array only, no prose, each element " +
+	"an object with the fields \"file\" (path as in the diff), \"line\" (line number in the new version of the file, " +
+	"0 if not about a line), \"severity\" (\"error\", \"warning\" or \"suggestion\") and \"comment\" (one or two " +
+	"sentences). Answer [] when there is nothing to report."
+
+// GenerateReview asks the LLM to review diff and returns its findings,
+// sorted by file and line. metadata gives the git context, as for
+// GenerateCommitMsg. Generated files are left out and the diff is split in
+// chunks reviewed concurrently, so any size of diff is reviewed in full.
+func GenerateReview(ctx context.Context, p genai.Provider, metadata, diff string) ([]ReviewFinding, error) {
+	files, _ := filterFiles(parseDiff(diff), IsGeneratedFile)
+	if len(metadata) > maxMetadataPrefix {
+		metadata = metadata[:maxMetadataPrefix] + "\n...[truncated]\n"
+	}
+	chunkSize := max(maxDiffLen-len(reviewPrompt)-len(metadata)-100, 1000)
+	chunks := splitFiles(files, chunkSize)
+	results := make([][]ReviewFinding, len(chunks))
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(maxParallelCalls)
+	for i, chunk := range chunks {
+		g.Go(func() error {
+			out, err := genReview(gctx, p, buildContext(metadata, chunk))
+			if err != nil {
+				return err
+			}
+			if results[i], err = parseReviewFindings(out); err != nil {
+				return fmt.Errorf("part %d/%d: %w", i+1, len(chunks), err)
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	findings := slices.Concat(results...)
+	slices.SortStableFunc(findings, func(a, b ReviewFinding) int {
+		return cmp.Or(strings.Compare(a.File, b.File), cmp.Compare(a.Line, b.Line))
+	})
+	return findings, nil
+}
+
+// parseReviewFindings parses the LLM's answer to reviewPrompt. The JSON
+// array may be wrapped in a markdown code block or prose. An unknown
+// severity becomes a suggestion.
+func parseReviewFindings(out string) ([]ReviewFinding, error) {
+	start, end := strings.Index(out, "["), strings.LastIndex(out, "]")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("no JSON array in the review: %q", out)
+	}
+	var findings []ReviewFinding
+	if err := json.Unmarshal([]byte(out[start:end+1]), &findings); err != nil {
+		return nil, fmt.Errorf("parsing the review: %w", err)
+	}
+	findings = slices.DeleteFunc(findings, func(f ReviewFinding) bool { return strings.TrimSpace(f.Comment) == "" })
+	for i := range findings {
+		f := &findings[i]
+		f.Severity = strings.ToLower(strings.TrimSpace(f.Severity))
+		if f.Severity != SeverityError && f.Severity != SeverityWarning {
+			f.Severity = SeveritySuggestion
+		}
+		f.Line = max(f.Line, 0)
+		f.Comment = strings.TrimSpace(f.Comment)
+	}
+	return findings, nil
+}
+
+// genReview reviews a chunk. Reviews are longer than commit messages: it
+// allows more tokens and time than genCommitMsg.
+func genReview(ctx context.Context, p genai.Provider, content string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+	res, err := p.GenSync(ctx, genai.Messages{genai.NewTextMessage(content)}, &genai.GenOptionText{
+		MaxTokens:    4096,
+		SystemPrompt: reviewPrompt,
+	})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(res.String()), nil
+}
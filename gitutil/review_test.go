@@ -0,0 +1,37 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package gitutil
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseReviewFindings(t *testing.T) {
+	const out = "Here is the review:\n```json\n[\n" +
+		`{"file": "main.go", "line": 12, "severity": "Error", "comment": " nil dereference when err is set "},` +
+		`{"file": "main.go", "line": -1, "severity": "nit", "comment": "rename x"},` +
+		`{"file": "util.go", "line": 3, "severity": "warning", "comment": ""}` +
+		"\n]\n```"
+	got, err := parseReviewFindings(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ReviewFinding{
+		{File: "main.go", Line: 12, Severity: SeverityError, Comment: "nil dereference when err is set"},
+		{File: "main.go", Line: 0, Severity: SeveritySuggestion, Comment: "rename x"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %+v", got)
+	}
+	if got, err := parseReviewFindings("[]"); err != nil || len(got) != 0 {
+		t.Errorf("got %+v, %v", got, err)
+	}
+	for _, out := range []string{"Looks good to me", "[{]"} {
+		if _, err := parseReviewFindings(out); err == nil {
+			t.Errorf("%q: expected error", out)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package gitutil
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// VCS is the set of operations md performs on a host repository to exchange
+// commits with a container. The container side always uses git; a backend
+// maps the host's version control system onto it.
+//
+// [Git] is the only implementation. Jujutsu repositories colocated with git
+// and Mercurial repositories bridged through hg-git go through it.
+type VCS interface {
+	// Name identifies the backend, e.g. "git".
+	Name() string
+	// Root returns the root of the repository holding wd.
+	Root(ctx context.Context, wd string) (string, error)
+	// CurrentBranch returns the branch checked out in dir.
+	CurrentBranch(ctx context.Context, dir string) (string, error)
+	// IsDirty reports whether dir has changes not committed on its branch.
+	IsDirty(ctx context.Context, dir string) (bool, error)
+	// DefaultRemote returns the remote the repository tracks upstream.
+	DefaultRemote(ctx context.Context, dir string) (string, error)
+	// DefaultBranch returns the default branch of remote.
+	DefaultBranch(ctx context.Context, dir, remote string) (string, error)
+	// PushRef pushes ref to branch on remote.
+	PushRef(ctx context.Context, dir, remote, ref, branch string, opts PushOpts) error
+	// Fetch fetches branch from remote, updating its remote-tracking ref
+	// <remote>/<branch> without touching local branches.
+	Fetch(ctx context.Context, dir, remote, branch string, opts FetchOpts) error
+	// Diff returns the changes on head since it forked from base.
+	Diff(ctx context.Context, dir, base, head string, extraArgs ...string) (string, error)
+}
+
+// PushOpts configures [VCS.PushRef].
+type PushOpts struct {
+	// Force overwrites the remote branch even when it isn't an ancestor.
+	Force bool
+	// Tags also pushes all tags.
+	Tags bool
+	// ReceivePack, when set, is the command run on the remote instead of
+	// git-receive-pack.
+	ReceivePack string
+	// Progress, when set, receives git's progress output, see
+	// [NewProgressWriter].
+	Progress io.Writer
+}
+
+// FetchOpts configures [VCS.Fetch].
+type FetchOpts struct {
+	// Progress, when set, receives git's progress output, see
+	// [NewProgressWriter].
+	Progress io.Writer
+}
+
+// Git is the git [VCS] backend. It shells out to the git CLI.
+type Git struct{}
+
+var _ VCS = Git{}
+
+// Name implements [VCS].
+func (Git) Name() string {
+	return "git"
+}
+
+// Root implements [VCS].
+func (Git) Root(ctx context.Context, wd string) (string, error) {
+	return RootDir(ctx, wd)
+}
+
+// CurrentBranch implements [VCS].
+func (Git) CurrentBranch(ctx context.Context, dir string) (string, error) {
+	return CurrentBranch(ctx, dir)
+}
+
+// IsDirty implements [VCS].
+func (Git) IsDirty(ctx context.Context, dir string) (bool, error) {
+	return IsDirty(ctx, dir)
+}
+
+// DefaultRemote implements [VCS].
+func (Git) DefaultRemote(ctx context.Context, dir string) (string, error) {
+	return DefaultRemote(ctx, dir)
+}
+
+// DefaultBranch implements [VCS].
+func (Git) DefaultBranch(ctx context.Context, dir, remote string) (string, error) {
+	return DefaultBranch(ctx, dir, remote)
+}
+
+// PushRef implements [VCS].
+func (Git) PushRef(ctx context.Context, dir, remote, ref, branch string, opts PushOpts) error {
+	slog.InfoContext(ctx, "git", "msg", "git push", "remote", remote, "ref", ref, "branch", branch, "force", opts.Force)
+	args := []string{"push"}
+	if opts.Force {
+		args = append(args, "-f")
+	}
+	if opts.Tags {
+		args = append(args, "--tags")
+	}
+	if opts.ReceivePack != "" {
+		args = append(args, "--receive-pack="+opts.ReceivePack)
+	}
+	return runGitProgress(ctx, dir, opts.Progress, append(args, remote, ref+":refs/heads/"+branch)...)
+}
+
+// Fetch implements [VCS].
+func (Git) Fetch(ctx context.Context, dir, remote, branch string, opts FetchOpts) error {
+	slog.InfoContext(ctx, "git", "msg", "git fetch", "remote", remote, "branch", branch)
+	return runGitProgress(ctx, dir, opts.Progress, "fetch", remote, branch)
+}
+
+// Diff implements [VCS].
+func (Git) Diff(ctx context.Context, dir, base, head string, extraArgs ...string) (string, error) {
+	args := append([]string{"diff"}, extraArgs...)
+	return RunGit(ctx, dir, append(args, base+"..."+head)...)
+}
+
+// runGitProgress runs the transfer command args quietly, or with its
+// progress output going to progress when set.
+func runGitProgress(ctx context.Context, dir string, progress io.Writer, args ...string) error {
+	if progress == nil {
+		_, err := RunGit(ctx, dir, append([]string{args[0], "-q"}, args[1:]...)...)
+		return err
+	}
+	args = append([]string{args[0], "--progress"}, args[1:]...)
+	cmd := newGitCmd(ctx, dir, args)
+	var stderr bytes.Buffer
+	cmd.Stderr = io.MultiWriter(&stderr, progress)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git %s: %w: %s", strings.Join(args, " "), err, stripProgress(stderr.String()))
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package gitutil
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGitVCS(t *testing.T) {
+	ctx := t.Context()
+	dir := t.TempDir()
+	bare := filepath.Join(dir, "remote.git")
+	clone := filepath.Join(dir, "clone")
+	run := func(wd string, args ...string) {
+		t.Helper()
+		cmd := exec.CommandContext(ctx, "git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@test"}, args...)...)
+		cmd.Dir = wd
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	run("", "init", "-q", "--bare", "--initial-branch=main", bare)
+	run("", "clone", "-q", bare, clone)
+	run(clone, "commit", "-q", "--allow-empty", "-m", "init")
+	run(clone, "tag", "v1")
+	run(clone, "push", "-q", "origin", "main")
+	run(clone, "checkout", "-q", "-b", "feature")
+	if err := os.WriteFile(filepath.Join(clone, "new.txt"), []byte("data\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	run(clone, "add", "new.txt")
+	run(clone, "commit", "-q", "-m", "add file")
+
+	var v VCS = Git{}
+	if got, err := v.CurrentBranch(ctx, clone); err != nil || got != "feature" {
+		t.Errorf("CurrentBranch() = %q, %v", got, err)
+	}
+	if got, err := v.DefaultBranch(ctx, clone, "origin"); err != nil || got != "main" {
+		t.Errorf("DefaultBranch() = %q, %v", got, err)
+	}
+	if err := v.PushRef(ctx, clone, "origin", "feature", "base", PushOpts{Tags: true}); err != nil {
+		t.Fatal(err)
+	}
+	for _, ref := range []string{"refs/heads/base", "refs/tags/v1"} {
+		if _, err := RevParse(ctx, bare, ref); err != nil {
+			t.Error(err)
+		}
+	}
+	if err := v.Fetch(ctx, clone, "origin", "base", FetchOpts{}); err != nil {
+		t.Fatal(err)
+	}
+	diff, err := v.Diff(ctx, clone, "origin/main", "origin/base", "--stat")
+	if err != nil || !strings.Contains(diff, "new.txt") {
+		t.Errorf("Diff() = %q, %v", diff, err)
+	}
+	// Without Force, a non-fast-forward push is rejected.
+	if err := v.PushRef(ctx, clone, "origin", "main", "base", PushOpts{}); err == nil {
+		t.Error("expected non-fast-forward error")
+	}
+	// With Progress, git's messages still end up in the error.
+	progress := NewProgressWriter(func(Progress) {})
+	if err := v.PushRef(ctx, clone, "origin", "main", "base", PushOpts{Progress: progress}); err == nil || !strings.Contains(err.Error(), "rejected") {
+		t.Errorf("expected non-fast-forward error, got %v", err)
+	}
+	if err := v.PushRef(ctx, clone, "origin", "main", "base", PushOpts{Force: true}); err != nil {
+		t.Error(err)
+	}
+}
@@ -3,9 +3,11 @@ module github.com/caic-xyz/md
 go 1.25.7
 
 require (
+	github.com/BurntSushi/toml v1.6.0
 	github.com/maruel/genai v0.5.0
 	golang.org/x/crypto v0.49.0
 	golang.org/x/sync v0.20.0
+	golang.org/x/sys v0.42.0
 	golang.org/x/term v0.41.0
 )
 
@@ -19,6 +21,5 @@ require (
 	github.com/maruel/httpjson v0.5.0 // indirect
 	github.com/maruel/roundtrippers v0.5.0 // indirect
 	github.com/wk8/go-ordered-map/v2 v2.1.8 // indirect
-	golang.org/x/sys v0.42.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
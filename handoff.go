@@ -0,0 +1,227 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/caic-xyz/md/gitutil"
+)
+
+// HandoffOpts configures [Container.Handoff].
+type HandoffOpts struct {
+	// Host is the ssh destination, "[user@]host", of the machine to move the
+	// container to. It needs md, the same container engine and the
+	// repositories.
+	Host string
+	// RemoteRoots are the repositories' roots on Host, in [Container.Repos]
+	// order. Missing or empty entries map the local root: a path under the
+	// home directory goes under Host's, others are used as is.
+	RemoteRoots []string
+	// RemoteMD is the md command on Host. Empty means "md" in its PATH.
+	RemoteMD string
+	// Quiet suppresses informational output.
+	Quiet bool
+}
+
+// HandoffResult describes a container moved by [Container.Handoff].
+type HandoffResult struct {
+	// Host is the machine the container was restored on.
+	Host string `json:"host"`
+	// Container is the restored container's name, the same as the original.
+	Container string `json:"container"`
+	// Image is the snapshot image loaded on Host.
+	Image string `json:"image"`
+	// Repos are the repositories on Host.
+	Repos []Repo `json:"repos"`
+}
+
+// Handoff starts an equivalent of the running container on another machine,
+// e.g. to move an agent's in-progress session from a laptop to a desktop.
+//
+// The container is snapshotted like [Container.Snapshot], with the
+// repositories' roots on the other machine, and the image is streamed there
+// with the engine's save and load over ssh. Each repository's branch, created
+// when missing, and the container's work on it are sent as a git bundle.
+// md restore then recreates the container there with the same name and
+// settings. The local container is left untouched and the local image
+// removed.
+func (c *Container) Handoff(ctx context.Context, stdout, stderr io.Writer, opts *HandoffOpts) (*HandoffResult, error) {
+	if opts.Host == "" {
+		return nil, errors.New("no destination host")
+	}
+	if c.Kube != nil {
+		return nil, errors.New("pods can't be handed off")
+	}
+	if c.RemoteHost != "" {
+		return nil, fmt.Errorf("%s runs on %s: the engine there can't stream images to another machine through md", c.Name, c.RemoteHost)
+	}
+	if len(c.Repos) == 0 {
+		return nil, errors.New("container has no repos")
+	}
+	for _, r := range c.Repos {
+		if name := r.vcs().Name(); name != "git" {
+			return nil, fmt.Errorf("%s is a %s repository: only git repositories can be handed off", r.Name(), name)
+		}
+	}
+	if c.MountSource != SourceClone {
+		return nil, fmt.Errorf("%s mounts the host checkout (--mount-src=%s): there is no copy to hand off", c.Name, c.MountSource)
+	}
+	if err := c.checkContainerState(ctx); err != nil {
+		return nil, err
+	}
+	remoteMD := opts.RemoteMD
+	if remoteMD == "" {
+		remoteMD = "md"
+	}
+	progress := func(format string, args ...any) {
+		if !opts.Quiet {
+			_, _ = fmt.Fprintf(stdout, "- "+format+"\n", args...)
+		}
+	}
+	remoteHome, err := runCmd(ctx, "", []string{"ssh", opts.Host, `echo "$HOME"`})
+	if err != nil {
+		return nil, cmdErrWithStderr("reaching "+opts.Host, err)
+	}
+	res := &HandoffResult{Host: opts.Host, Container: c.Name, Repos: make([]Repo, len(c.Repos))}
+	for i, r := range c.Repos {
+		res.Repos[i] = Repo{GitRoot: remoteRoot(r.GitRoot, c.Home, remoteHome), Branch: r.Branch}
+		if i < len(opts.RemoteRoots) && opts.RemoteRoots[i] != "" {
+			res.Repos[i].GitRoot = opts.RemoteRoots[i]
+		}
+	}
+
+	// Send the branches first: a missing repository on the other machine
+	// fails before the large image transfer.
+	for i, r := range c.Repos {
+		progress("Sending %s to %s:%s", r.Branch, opts.Host, res.Repos[i].GitRoot)
+		if err := c.sendBundle(ctx, opts.Host, &r, res.Repos[i].GitRoot); err != nil {
+			return nil, err
+		}
+	}
+
+	progress("Saving %s", c.Name)
+	snap, err := c.commitSnapshot(ctx, "handoff-"+time.Now().Format("20060102-150405"), res.Repos)
+	if err != nil {
+		return nil, err
+	}
+	res.Image = snap.Image
+	defer func() {
+		if _, err := runCmd(context.WithoutCancel(ctx), "", []string{c.Runtime, "rmi", snap.Image}); err != nil {
+			slog.WarnContext(ctx, "md", "msg", "failed to remove the handoff image", "image", snap.Image, "err", err)
+		}
+	}()
+	progress("Copying %s to %s", snap.Image, opts.Host)
+	load := []string{"ssh", opts.Host, shellQuote(c.Runtime) + " load -q"}
+	if err := pipeCmds(ctx, []string{c.Runtime, "save", snap.Image}, load, stderr); err != nil {
+		return nil, fmt.Errorf("copying %s to %s: %w", snap.Image, opts.Host, err)
+	}
+
+	progress("Restoring %s on %s", c.Name, opts.Host)
+	restore := shellQuote(remoteMD) + " restore -no-ssh -q " + shellQuote(snap.Image)
+	if err := runCmdOut(ctx, "", []string{"ssh", opts.Host, restore}, stdout, stderr); err != nil {
+		return nil, fmt.Errorf("restoring %s on %s: %w", c.Name, opts.Host, err)
+	}
+	return res, nil
+}
+
+// remoteRoot maps the local repository root to the other machine: a path
+// under home goes under remoteHome.
+func remoteRoot(root, home, remoteHome string) string {
+	rel, err := filepath.Rel(home, root)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || remoteHome == "" {
+		return root
+	}
+	if rel == "." {
+		return remoteHome
+	}
+	return remoteHome + "/" + filepath.ToSlash(rel)
+}
+
+// sendBundle fetches the container's work on r's branch and sends it with
+// the branch to the repository at root on host.
+func (c *Container) sendBundle(ctx context.Context, host string, r *Repo, root string) error {
+	if err := r.vcs().Fetch(ctx, r.GitRoot, c.Name, r.Branch, gitutil.FetchOpts{}); err != nil {
+		return fmt.Errorf("fetching %s: %w", r.Name(), err)
+	}
+	base, err := gitutil.RunGit(ctx, r.GitRoot, "rev-parse", "refs/heads/"+r.Branch)
+	if err != nil {
+		return fmt.Errorf("%s has no branch %s", r.Name(), r.Branch)
+	}
+	f, err := os.CreateTemp("", "md-handoff-*.bundle")
+	if err != nil {
+		return err
+	}
+	_ = f.Close()
+	defer func() { _ = os.Remove(f.Name()) }()
+	work := "refs/remotes/" + c.Name + "/" + r.Branch
+	if _, err := gitutil.RunGit(ctx, r.GitRoot, "bundle", "create", "-q", f.Name(), "refs/heads/"+r.Branch, work); err != nil {
+		return fmt.Errorf("bundling %s: %w", r.Name(), err)
+	}
+	in, err := os.Open(f.Name())
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	cmd := exec.CommandContext(ctx, "ssh", host, bundleScript(root, r.Branch, work, base))
+	cmd.Stdin = in
+	cmd.WaitDelay = cmdWaitDelay
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("sending %s to %s: %w: %s", r.Name(), host, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// bundleScript returns the shell script fetching the git bundle on its stdin
+// into the repository at root: the container's work into work and, when
+// missing, branch at base. An existing branch is left alone.
+func bundleScript(root, branch, work, base string) string {
+	return `set -e
+cd ` + shellQuote(root) + `
+f=$(mktemp)
+trap 'rm -f "$f"' EXIT
+cat >"$f"
+git fetch -q "$f" ` + shellQuote("+"+work+":"+work) + ` ` + shellQuote("refs/heads/"+branch) + `
+git rev-parse -q --verify ` + shellQuote("refs/heads/"+branch) + ` >/dev/null || git branch -q ` + shellQuote(branch) + ` ` + base + `
+`
+}
+
+// pipeCmds runs from with its stdout piped into to.
+func pipeCmds(ctx context.Context, from, to []string, stderr io.Writer) error {
+	slog.DebugContext(ctx, "md", "msg", "exec", "cmd", from, "to", to)
+	src := exec.CommandContext(ctx, from[0], from[1:]...)
+	dst := exec.CommandContext(ctx, to[0], to[1:]...)
+	src.Stderr = stderr
+	dst.Stdout = stderr
+	dst.Stderr = stderr
+	src.WaitDelay = cmdWaitDelay
+	dst.WaitDelay = cmdWaitDelay
+	pipe, err := src.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	dst.Stdin = pipe
+	if err := src.Start(); err != nil {
+		return err
+	}
+	if err := dst.Run(); err != nil {
+		_ = src.Process.Kill()
+		_ = src.Wait()
+		return fmt.Errorf("%s: %w", to[0], err)
+	}
+	if err := src.Wait(); err != nil {
+		return fmt.Errorf("%s: %w", from[0], err)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestRemoteRoot(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("POSIX paths")
+	}
+	for _, tt := range []struct{ root, want string }{
+		{"/home/me/src/r", "/Users/me/src/r"},
+		{"/home/me", "/Users/me"},
+		{"/home/meh/r", "/home/meh/r"},
+		{"/srv/r", "/srv/r"},
+	} {
+		if got := remoteRoot(tt.root, "/home/me", "/Users/me"); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.root, got, tt.want)
+		}
+	}
+	if got := remoteRoot("/home/me/r", "/home/me", ""); got != "/home/me/r" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestBundleScript(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell")
+	}
+	ctx := t.Context()
+	src := t.TempDir()
+	dst := t.TempDir()
+	git := func(dir string, args ...string) string {
+		t.Helper()
+		cmd := exec.CommandContext(ctx, "git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@test"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git(src, "init", "-q", "--initial-branch=main")
+	git(src, "commit", "-q", "--allow-empty", "-m", "base")
+	base := git(src, "rev-parse", "HEAD")
+	git(src, "commit", "-q", "--allow-empty", "-m", "work")
+	work := "refs/remotes/md-r-main/main"
+	git(src, "update-ref", work, "HEAD")
+	git(src, "reset", "-q", "--hard", base)
+	bundle := filepath.Join(t.TempDir(), "b.bundle")
+	git(src, "bundle", "create", "-q", bundle, "refs/heads/main", work)
+	git(dst, "init", "-q", "--initial-branch=other")
+	git(dst, "commit", "-q", "--allow-empty", "-m", "unrelated")
+
+	run := func() {
+		t.Helper()
+		data, err := os.ReadFile(bundle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmd := exec.CommandContext(ctx, "sh", "-c", bundleScript(dst, "main", work, base))
+		cmd.Stdin = bytes.NewReader(data)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("%v\n%s", err, out)
+		}
+	}
+	run()
+	if got := git(dst, "rev-parse", "refs/heads/main"); got != base {
+		t.Errorf("main: got %s, want %s", got, base)
+	}
+	if got, want := git(dst, "rev-parse", work), git(src, "rev-parse", work); got != want {
+		t.Errorf("work: got %s, want %s", got, want)
+	}
+	// An existing branch is kept.
+	git(dst, "branch", "-f", "main", "other")
+	run()
+	if got, want := git(dst, "rev-parse", "refs/heads/main"), git(dst, "rev-parse", "other"); got != want {
+		t.Errorf("main moved to %s", got)
+	}
+}
+
+func TestPipeCmds(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell")
+	}
+	out := filepath.Join(t.TempDir(), "out")
+	var stderr bytes.Buffer
+	if err := pipeCmds(t.Context(), []string{"echo", "hello"}, []string{"sh", "-c", "cat >" + out}, &stderr); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(out); string(data) != "hello\n" {
+		t.Errorf("got %q", data)
+	}
+	if err := pipeCmds(t.Context(), []string{"sh", "-c", "exit 3"}, []string{"cat"}, &stderr); err == nil || !strings.HasPrefix(err.Error(), "sh: ") {
+		t.Errorf("got %v", err)
+	}
+	if err := pipeCmds(t.Context(), []string{"echo", "hello"}, []string{"false"}, &stderr); err == nil || !strings.HasPrefix(err.Error(), "false: ") {
+		t.Errorf("got %v", err)
+	}
+}
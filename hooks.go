@@ -0,0 +1,150 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Hook points, the keys of [HooksConfig].
+const (
+	// HookPreBuild runs before md builds the specialized image of a
+	// container. The container doesn't exist yet, so it has host commands
+	// only.
+	HookPreBuild = "pre_build"
+	// HookPostStart runs once a started container has its repositories.
+	HookPostStart = "post_start"
+	// HookPrePush runs before md push sends a repository to the container.
+	HookPrePush = "pre_push"
+	// HookPostPull runs after md pull brought a repository's changes back.
+	HookPostPull = "post_pull"
+	// HookPreKill runs before md kill removes a container.
+	HookPreKill = "pre_kill"
+)
+
+// HookPoints lists the valid hook points.
+var HookPoints = []string{HookPreBuild, HookPostStart, HookPrePush, HookPostPull, HookPreKill}
+
+// HooksConfig holds the commands md runs at each hook point.
+//
+// A failing pre_* command aborts the operation, except pre_kill, which is
+// reported like a failing post_* command, the operation having already
+// happened: nothing in the container can prevent its removal.
+type HooksConfig struct {
+	PreBuild  HookCommands `toml:"pre_build"`
+	PostStart HookCommands `toml:"post_start"`
+	PrePush   HookCommands `toml:"pre_push"`
+	PostPull  HookCommands `toml:"post_pull"`
+	PreKill   HookCommands `toml:"pre_kill"`
+}
+
+// HookCommands are the shell commands run at a hook point, in order, host
+// ones first. They get $MD_HOOK (the hook point), $MD_CONTAINER, $MD_REPO
+// and $MD_BRANCH (the repository concerned, the primary one for start,
+// build and kill) and $MD_GIT_ROOT (its root on the host).
+type HookCommands struct {
+	// Host commands run on the host, in the repository's root. User config
+	// only: a repository's .md.toml must not run commands on the host.
+	Host []string `toml:"host"`
+	// Container commands run in the container, in ~/src/<repo>, with the
+	// user's shell like md exec.
+	Container []string `toml:"container"`
+}
+
+// commands returns the commands of point.
+func (h *HooksConfig) commands(point string) *HookCommands {
+	switch point {
+	case HookPreBuild:
+		return &h.PreBuild
+	case HookPostStart:
+		return &h.PostStart
+	case HookPrePush:
+		return &h.PrePush
+	case HookPostPull:
+		return &h.PostPull
+	case HookPreKill:
+		return &h.PreKill
+	}
+	panic("unknown hook point " + point)
+}
+
+// hookEnv returns the environment describing the hook point and the
+// repository concerned, Repos[repoIdx].
+func (c *Container) hookEnv(point string, repoIdx int) []string {
+	env := []string{"MD_HOOK=" + point, "MD_CONTAINER=" + c.Name}
+	if repoIdx >= 0 && repoIdx < len(c.Repos) {
+		r := &c.Repos[repoIdx]
+		env = append(env, "MD_REPO="+r.Name(), "MD_BRANCH="+r.Branch, "MD_GIT_ROOT="+r.GitRoot)
+	}
+	return env
+}
+
+// exportEnv returns the shell command exporting env.
+func exportEnv(env []string) string {
+	s := "export"
+	for _, kv := range env {
+		k, v, _ := strings.Cut(kv, "=")
+		s += " " + k + "=" + shellQuote(v)
+	}
+	return s
+}
+
+// runHook runs the commands of point from c.Hooks about Repos[repoIdx]. It
+// stops at the first failure. Container commands are skipped when
+// withContainer is false, e.g. for a stopped container.
+func (c *Container) runHook(ctx context.Context, stdout, stderr io.Writer, point string, repoIdx int, withContainer bool) error {
+	h := c.Hooks.commands(point)
+	if len(h.Host) == 0 && (!withContainer || len(h.Container) == 0) {
+		return nil
+	}
+	env := c.hookEnv(point, repoIdx)
+	dir := ""
+	if repoIdx >= 0 && repoIdx < len(c.Repos) {
+		dir = c.Repos[repoIdx].GitRoot
+	}
+	for _, line := range h.Host {
+		_, _ = fmt.Fprintf(stdout, "- Running %s hook: %s\n", point, line)
+		args := []string{"sh", "-c", line}
+		if runtime.GOOS == "windows" {
+			args = []string{"cmd", "/C", line}
+		}
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), env...)
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s hook %q: %w", point, line, err)
+		}
+	}
+	if !withContainer {
+		return nil
+	}
+	prefix := exportEnv(env)
+	if dir != "" {
+		prefix += " && cd ~/src/" + shellQuote(c.Repos[repoIdx].Name())
+	}
+	for _, line := range h.Container {
+		_, _ = fmt.Fprintf(stdout, "- Running %s hook in the container: %s\n", point, line)
+		if err := runCmdOut(ctx, "", c.SSHCommand(c.Name, prefix+" && {\n"+line+"\n}"), stdout, stderr); err != nil {
+			return fmt.Errorf("%s hook %q in the container: %w", point, line, err)
+		}
+	}
+	return nil
+}
+
+// postHook runs the commands of a post_* point and reports their failure
+// on stderr.
+func (c *Container) postHook(ctx context.Context, stdout, stderr io.Writer, point string, repoIdx int) {
+	if err := c.runHook(ctx, stdout, stderr, point, repoIdx, true); err != nil {
+		_, _ = fmt.Fprintf(stderr, "- %v\n", err)
+	}
+}
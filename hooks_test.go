@@ -0,0 +1,92 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestRunHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	root := t.TempDir()
+	c := &Container{
+		Client: &Client{Hooks: HooksConfig{
+			PrePush: HookCommands{
+				Host:      []string{`echo "$MD_HOOK $MD_CONTAINER $MD_REPO $MD_BRANCH $(pwd)" > out.txt`, "exit 3", "touch not-run"},
+				Container: []string{"never"},
+			},
+		}},
+		Name:  "md-repo-main",
+		Repos: []Repo{{GitRoot: root, Branch: "main"}},
+	}
+	var stdout bytes.Buffer
+	err := c.runHook(t.Context(), &stdout, &stdout, HookPrePush, 0, false)
+	if err == nil || !strings.Contains(err.Error(), `pre_push hook "exit 3"`) {
+		t.Fatalf("got %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(root, "out.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, _ := filepath.EvalSymlinks(root)
+	if want := "pre_push md-repo-main " + filepath.Base(root) + " main " + dir + "\n"; string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(root, "not-run")); err == nil {
+		t.Error("ran the command after the failure")
+	}
+	if !strings.Contains(stdout.String(), "- Running pre_push hook: exit 3\n") {
+		t.Errorf("stdout: %q", stdout.String())
+	}
+	// Without commands, nothing runs.
+	if err := c.runHook(t.Context(), &stdout, &stdout, HookPostPull, 0, true); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPurgePreKillFails(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	tmp := t.TempDir()
+	// The engine records its arguments; the container exists.
+	log := filepath.Join(tmp, "engine.log")
+	engine := filepath.Join(tmp, "engine")
+	if err := os.WriteFile(engine, []byte("#!/bin/sh\necho \"$@\" >> "+log+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	c := &Container{
+		Client: &Client{
+			Runtime:      engine,
+			Home:         tmp,
+			XDGStateHome: tmp,
+			Hooks:        HooksConfig{PreKill: HookCommands{Host: []string{"exit 1"}}},
+		},
+		Name:  "md-repo-main",
+		State: "exited",
+		Repos: []Repo{{GitRoot: tmp, Branch: "main"}},
+	}
+	var stdout, stderr bytes.Buffer
+	if err := c.Purge(t.Context(), &stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stderr.String(), `pre_kill hook "exit 1"`) || !strings.Contains(stderr.String(), "removing md-repo-main anyway") {
+		t.Errorf("stderr: %q", stderr.String())
+	}
+	got, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(got), "rm -f -v md-repo-main\n") {
+		t.Errorf("engine calls:\n%s", got)
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// HostGateway is the name the container reaches the host by, e.g. for a
+// model server running there. The engine resolves it to the host's address
+// on the container's network.
+const HostGateway = "host.docker.internal"
+
+// HostPort makes a TCP service listening on the host reachable from the
+// container, like a local model server.
+type HostPort struct {
+	// Port is the port the service listens on on the host.
+	Port uint16 `json:"port"`
+	// Env is the environment variable set in the container to the service's
+	// URL, e.g. OLLAMA_HOST=http://host.docker.internal:11434. Empty sets
+	// none.
+	Env string `json:"env,omitempty"`
+}
+
+// WellKnownHostPorts are the host services [ParseHostPort] accepts by name.
+var WellKnownHostPorts = map[string]HostPort{
+	"ollama": {Port: 11434, Env: "OLLAMA_HOST"},
+}
+
+var reEnvName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ParseHostPort parses "port", "port=ENV" or the name of one of
+// [WellKnownHostPorts].
+func ParseHostPort(spec string) (HostPort, error) {
+	if p, ok := WellKnownHostPorts[spec]; ok {
+		return p, nil
+	}
+	s, env, _ := strings.Cut(spec, "=")
+	port, err := strconv.ParseUint(s, 10, 16)
+	if err != nil || port == 0 {
+		return HostPort{}, fmt.Errorf("invalid host port %q: use port, port=ENV or one of %s", spec, strings.Join(slices.Sorted(maps.Keys(WellKnownHostPorts)), ", "))
+	}
+	if strings.Contains(spec, "=") && !reEnvName.MatchString(env) {
+		return HostPort{}, fmt.Errorf("invalid host port %q: %q isn't an environment variable name", spec, env)
+	}
+	return HostPort{Port: uint16(port), Env: env}, nil
+}
+
+// String returns the host port in the form accepted by [ParseHostPort].
+func (p HostPort) String() string {
+	if p.Env == "" {
+		return strconv.Itoa(int(p.Port))
+	}
+	return fmt.Sprintf("%d=%s", p.Port, p.Env)
+}
+
+// hostPortsLabel encodes ports for the md.host_ports label.
+func hostPortsLabel(ports []HostPort) string {
+	s := make([]string, len(ports))
+	for i, p := range ports {
+		s[i] = p.String()
+	}
+	return strings.Join(s, " ")
+}
+
+// parseHostPortsLabel decodes the md.host_ports label, skipping invalid
+// entries.
+func parseHostPortsLabel(v string) []HostPort {
+	var ports []HostPort
+	for f := range strings.FieldsSeq(v) {
+		if p, err := ParseHostPort(f); err == nil {
+			ports = append(ports, p)
+		}
+	}
+	return ports
+}
+
+// checkHostPorts rejects duplicate ports and environment variables, and the
+// network modes without a way to the host.
+func checkHostPorts(opts *StartOpts) error {
+	if len(opts.HostPorts) == 0 {
+		return nil
+	}
+	if opts.NetworkMode == NetworkNone {
+		return errors.New("host ports need a network: use another network mode")
+	}
+	ports := map[uint16]struct{}{}
+	envs := map[string]struct{}{}
+	for _, p := range opts.HostPorts {
+		if _, ok := ports[p.Port]; ok {
+			return fmt.Errorf("host port %d is given twice", p.Port)
+		}
+		ports[p.Port] = struct{}{}
+		if p.Env != "" {
+			if _, ok := envs[p.Env]; ok {
+				return fmt.Errorf("%s is set by two host ports", p.Env)
+			}
+			envs[p.Env] = struct{}{}
+		}
+	}
+	return nil
+}
+
+// hostAddr returns the address the container reaches the host by on network.
+func hostAddr(network string) string {
+	if network == NetworkHost {
+		return "127.0.0.1"
+	}
+	return HostGateway
+}
+
+// hostPortsEnv returns the KEY=VALUE pairs pointing the container's tools to
+// the host ports.
+func (c *Container) hostPortsEnv() []string {
+	var env []string
+	for _, p := range c.HostPorts {
+		if p.Env != "" {
+			env = append(env, fmt.Sprintf("%s=http://%s:%d", p.Env, hostAddr(c.Network), p.Port))
+		}
+	}
+	return env
+}
+
+// HostPortStatus is whether a [HostPort] answers from the container.
+type HostPortStatus struct {
+	HostPort
+	// Addr is the address the container connects to, e.g.
+	// "host.docker.internal:11434".
+	Addr      string `json:"addr"`
+	Reachable bool   `json:"reachable"`
+}
+
+// hostPortsScript tries to connect to each of ports from the container,
+// printing "<port> ok" or "<port> down".
+func hostPortsScript(addr string, ports []HostPort) string {
+	var b strings.Builder
+	for _, p := range ports {
+		fmt.Fprintf(&b, "if timeout 2 bash -c '</dev/tcp/%s/%d' 2>/dev/null; then echo '%d ok'; else echo '%d down'; fi; ", addr, p.Port, p.Port, p.Port)
+	}
+	return b.String()
+}
+
+// HostPortsStatus checks that the container's host ports answer. A service
+// bound to the host's 127.0.0.1 doesn't: it must listen on the address the
+// engine routes [HostGateway] to, e.g. with OLLAMA_HOST=0.0.0.0 for Ollama.
+func (c *Container) HostPortsStatus(ctx context.Context) ([]HostPortStatus, error) {
+	if len(c.HostPorts) == 0 {
+		return nil, nil
+	}
+	addr := hostAddr(c.Network)
+	out, err := runCmdRetry(ctx, "", c.SSHCommand(c.Name, hostPortsScript(addr, c.HostPorts)))
+	if err != nil {
+		return nil, fmt.Errorf("checking the host ports: %w", err)
+	}
+	up := map[string]bool{}
+	for line := range strings.SplitSeq(out, "\n") {
+		if port, state, ok := strings.Cut(line, " "); ok {
+			up[port] = state == "ok"
+		}
+	}
+	s := make([]HostPortStatus, len(c.HostPorts))
+	for i, p := range c.HostPorts {
+		port := strconv.Itoa(int(p.Port))
+		s[i] = HostPortStatus{HostPort: p, Addr: addr + ":" + port, Reachable: up[port]}
+	}
+	return s, nil
+}
@@ -0,0 +1,92 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseHostPort(t *testing.T) {
+	for spec, want := range map[string]HostPort{
+		"8080":              {Port: 8080},
+		"11434=OLLAMA_HOST": {Port: 11434, Env: "OLLAMA_HOST"},
+		"ollama":            {Port: 11434, Env: "OLLAMA_HOST"},
+	} {
+		t.Run(spec, func(t *testing.T) {
+			got, err := ParseHostPort(spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+	for _, spec := range []string{"", "0", "llama", "70000", "8080=", "8080=1X", "8080=A-B", "=FOO"} {
+		t.Run("invalid_"+spec, func(t *testing.T) {
+			if _, err := ParseHostPort(spec); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestHostPortsLabel(t *testing.T) {
+	ports := []HostPort{{Port: 11434, Env: "OLLAMA_HOST"}, {Port: 8080}}
+	if got := parseHostPortsLabel(hostPortsLabel(ports)); !slices.Equal(got, ports) {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestCheckHostPorts(t *testing.T) {
+	for name, tt := range map[string]struct {
+		opts    StartOpts
+		wantErr bool
+	}{
+		"none":        {StartOpts{}, false},
+		"ok":          {StartOpts{HostPorts: []HostPort{{Port: 11434, Env: "OLLAMA_HOST"}, {Port: 8080}}}, false},
+		"host":        {StartOpts{NetworkMode: NetworkHost, HostPorts: []HostPort{{Port: 8080}}}, false},
+		"no_network":  {StartOpts{NetworkMode: NetworkNone, HostPorts: []HostPort{{Port: 8080}}}, true},
+		"dup_port":    {StartOpts{HostPorts: []HostPort{{Port: 8080}, {Port: 8080, Env: "X"}}}, true},
+		"dup_env":     {StartOpts{HostPorts: []HostPort{{Port: 8080, Env: "X"}, {Port: 8081, Env: "X"}}}, true},
+		"no_env_dups": {StartOpts{HostPorts: []HostPort{{Port: 8080}, {Port: 8081}}}, false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := checkHostPorts(&tt.opts); (err != nil) != tt.wantErr {
+				t.Fatalf("got %v", err)
+			}
+		})
+	}
+}
+
+func TestHostPortsEnv(t *testing.T) {
+	c := &Container{HostPorts: []HostPort{{Port: 11434, Env: "OLLAMA_HOST"}, {Port: 8080}}}
+	if got, want := c.hostPortsEnv(), []string{"OLLAMA_HOST=http://host.docker.internal:11434"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	c.Network = NetworkHost
+	if got, want := c.hostPortsEnv(), []string{"OLLAMA_HOST=http://127.0.0.1:11434"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestHostPortsScript(t *testing.T) {
+	s := hostPortsScript(HostGateway, []HostPort{{Port: 11434}, {Port: 8080}})
+	for _, want := range []string{"/dev/tcp/host.docker.internal/11434", "echo '8080 ok'", "echo '8080 down'"} {
+		if !strings.Contains(s, want) {
+			t.Errorf("lacks %q:\n%s", want, s)
+		}
+	}
+	if runtime.GOOS == "windows" {
+		return
+	}
+	if out, err := exec.CommandContext(t.Context(), "bash", "-n", "-c", s).CombinedOutput(); err != nil {
+		t.Errorf("%v: %s", err, out)
+	}
+}
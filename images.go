@@ -0,0 +1,147 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ImageUsage describes an image md built and the containers using it, as
+// reported by [Client.Images].
+type ImageUsage struct {
+	ID string `json:"id"`
+	// Tags are the image's names, e.g. md-specialized-<hash>. An image
+	// without tags was superseded by a rebuild and is kept by its containers.
+	Tags    []string  `json:"tags"`
+	Created time.Time `json:"created"`
+	Size    int64     `json:"size"`
+	// BaseImage and BaseDigest are the base the image was built from, for
+	// user images.
+	BaseImage  string `json:"base_image,omitempty"`
+	BaseDigest string `json:"base_digest,omitempty"`
+	// BaseCurrent is whether the local base image is still BaseDigest; when
+	// it isn't, the next start rebuilds the user image.
+	BaseCurrent bool `json:"base_current"`
+	// ContextSHA is the hash of the SSH keys baked in the image; KeysCurrent
+	// is whether it matches the current keys.
+	ContextSHA  string `json:"context_sha,omitempty"`
+	KeysCurrent bool   `json:"keys_current"`
+	// Containers are the md containers created from the image.
+	Containers []string `json:"containers"`
+	// Reclaimable estimates the space removing the image frees: zero while
+	// containers use it, else its layers on top of its base, or all of them
+	// when the base was updated since. Layers shared by several superseded
+	// images are counted for each.
+	Reclaimable int64 `json:"reclaimable"`
+}
+
+// Images returns the images md built, tagged or superseded, with the
+// containers using each, newest first.
+func (c *Client) Images(ctx context.Context) ([]ImageUsage, error) {
+	if c.Kube != nil {
+		return nil, errors.New("md images isn't supported on Kubernetes")
+	}
+	out, err := runCmd(ctx, "", []string{c.Runtime, "images", "--no-trunc", "--filter", "label=" + buildLabel, "--format", "{{.ID}}"})
+	if err != nil {
+		return nil, fmt.Errorf("listing images: %w", err)
+	}
+	var ids []string
+	for id := range strings.SplitSeq(out, "\n") {
+		if id != "" && !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	var infos []imageInfo
+	if err := c.inspectAll(ctx, []string{"image", "inspect"}, ids, &infos); err != nil {
+		return nil, fmt.Errorf("inspecting images: %w", err)
+	}
+	users, err := c.imageContainers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	contextSHA, _ := keysSHA(c.keysDir)
+	bases := map[string]*imageInfo{}
+	images := make([]ImageUsage, 0, len(infos))
+	for _, info := range infos {
+		labels := info.Config.Labels
+		u := ImageUsage{
+			ID:         info.ID,
+			Tags:       slices.DeleteFunc(slices.Clone(info.RepoTags), func(t string) bool { return t == "<none>:<none>" }),
+			Created:    info.Created,
+			Size:       info.Size,
+			BaseImage:  labels["md.base_image"],
+			BaseDigest: labels["md.base_digest"],
+			ContextSHA: labels["md.context_sha"],
+			Containers: users[info.ID],
+		}
+		u.KeysCurrent = u.ContextSHA != "" && u.ContextSHA == contextSHA
+		var base *imageInfo
+		if u.BaseImage != "" {
+			var ok bool
+			if base, ok = bases[u.BaseImage]; !ok {
+				base, _ = inspectImage(ctx, c.Runtime, u.BaseImage)
+				bases[u.BaseImage] = base
+			}
+			u.BaseCurrent = base != nil && base.digest() == u.BaseDigest
+		}
+		if len(u.Containers) == 0 {
+			u.Reclaimable = u.Size
+			if u.BaseCurrent {
+				u.Reclaimable = max(0, u.Size-base.Size)
+			}
+		}
+		images = append(images, u)
+	}
+	slices.SortFunc(images, func(a, b ImageUsage) int {
+		return cmp.Or(b.Created.Compare(a.Created), strings.Compare(a.ID, b.ID))
+	})
+	return images, nil
+}
+
+// imageContainers returns the names of the md containers by the ID of the
+// image they were created from.
+func (c *Client) imageContainers(ctx context.Context) (map[string][]string, error) {
+	out, err := runCmd(ctx, "", []string{c.Runtime, "ps", "--all", "--quiet", "--no-trunc", "--filter", "name=^md-"})
+	if err != nil {
+		return nil, fmt.Errorf("listing containers: %w", err)
+	}
+	ids := strings.Fields(out)
+	users := map[string][]string{}
+	if len(ids) == 0 {
+		return users, nil
+	}
+	var infos []containerInfo
+	if err := c.inspectAll(ctx, []string{"inspect", "--type", "container"}, ids, &infos); err != nil {
+		return nil, fmt.Errorf("inspecting containers: %w", err)
+	}
+	for _, info := range infos {
+		// Docker prefixes the name with a slash, podman doesn't.
+		users[info.Image] = append(users[info.Image], strings.TrimPrefix(info.Name, "/"))
+	}
+	for _, names := range users {
+		slices.Sort(names)
+	}
+	return users, nil
+}
+
+// inspectAll runs the inspect command cmd on ids and decodes its output in
+// v.
+func (c *Client) inspectAll(ctx context.Context, cmd, ids []string, v any) error {
+	out, err := runCmdRetry(ctx, "", slices.Concat([]string{c.Runtime}, cmd, ids))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(out), v)
+}
@@ -0,0 +1,87 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+)
+
+func TestImages(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell")
+	}
+	keys := t.TempDir()
+	for _, name := range []string{"ssh_host_ed25519_key", "ssh_host_ed25519_key.pub", "authorized_keys"} {
+		if err := os.WriteFile(filepath.Join(keys, name), []byte(name), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	contextSHA, err := keysSHA(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The current user image, used by md-a; the one it superseded, still
+	// used by md-b; and an unused one built from an older base.
+	images := `[
+{"Id": "sha256:new", "RepoTags": ["md-specialized-1:latest"], "Created": "2026-03-02T00:00:00Z", "Size": 1500,
+ "Config": {"Labels": {"md.build": "1", "md.base_image": "base", "md.base_digest": "sha256:base", "md.context_sha": "` + contextSHA + `"}}},
+{"Id": "sha256:old", "RepoTags": [], "Created": "2026-03-01T00:00:00Z", "Size": 1400,
+ "Config": {"Labels": {"md.build": "1", "md.base_image": "base", "md.base_digest": "sha256:base", "md.context_sha": "old"}}},
+{"Id": "sha256:unused", "RepoTags": ["md-specialized-2:latest"], "Created": "2026-02-01T00:00:00Z", "Size": 1200,
+ "Config": {"Labels": {"md.build": "1", "md.base_image": "base", "md.base_digest": "sha256:older", "md.context_sha": "` + contextSHA + `"}}},
+{"Id": "sha256:fresh", "RepoTags": ["md-specialized-3:latest"], "Created": "2026-01-01T00:00:00Z", "Size": 1300,
+ "Config": {"Labels": {"md.build": "1", "md.base_image": "base", "md.base_digest": "sha256:base", "md.context_sha": "` + contextSHA + `"}}}
+]`
+	const containers = `[{"Name": "/md-a", "Image": "sha256:new"}, {"Name": "/md-b", "Image": "sha256:old"}]`
+	const base = `[{"Id": "sha256:base", "Size": 1000}]`
+	dir := t.TempDir()
+	for name, content := range map[string]string{"images": images, "containers": containers, "base": base} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	docker := filepath.Join(t.TempDir(), "docker")
+	script := "#!/bin/sh\ncase \"$1 $2 $3\" in\n" +
+		"\"images \"*) printf 'sha256:new\\nsha256:old\\nsha256:unused\\nsha256:fresh\\n' ;;\n" +
+		"\"ps \"*) printf 'c1\\nc2\\n' ;;\n" +
+		"\"image inspect base\") cat " + dir + "/base ;;\n" +
+		"\"image inspect \"*) cat " + dir + "/images ;;\n" +
+		"\"inspect \"*) cat " + dir + "/containers ;;\n" +
+		"*) exit 1 ;;\nesac\n"
+	if err := os.WriteFile(docker, []byte(script), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	c := &Client{Runtime: docker, keysDir: keys}
+	got, err := c.Images(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 4 {
+		t.Fatalf("got %+v", got)
+	}
+	for i, want := range []struct {
+		id          string
+		containers  []string
+		base, keys  bool
+		reclaimable int64
+	}{
+		{"sha256:new", []string{"md-a"}, true, true, 0},
+		{"sha256:old", []string{"md-b"}, true, false, 0},
+		{"sha256:unused", nil, false, true, 1200},
+		{"sha256:fresh", nil, true, true, 300},
+	} {
+		u := got[i]
+		if u.ID != want.id || !slices.Equal(u.Containers, want.containers) || u.BaseCurrent != want.base || u.KeysCurrent != want.keys || u.Reclaimable != want.reclaimable {
+			t.Errorf("%d: got %+v", i, u)
+		}
+	}
+	if len(got[1].Tags) != 0 || !slices.Equal(got[0].Tags, []string{"md-specialized-1:latest"}) {
+		t.Errorf("tags %q, %q", got[0].Tags, got[1].Tags)
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/caic-xyz/md/gitutil"
+)
+
+// Inspection is everything md knows about a container, as returned by
+// [Container.Inspect], to attach to a bug report.
+type Inspection struct {
+	Name  string `json:"name"`
+	State string `json:"state"`
+	// Labels are the container's labels, md's settings included.
+	Labels map[string]string `json:"labels"`
+	// Ports are the published ports by container port, e.g. "22/tcp", as
+	// "host:port".
+	Ports map[string][]string `json:"ports,omitempty"`
+	// Image is the image name the container was created from and ImageID
+	// the image it runs, which differs from the name's current image after a
+	// rebuild.
+	Image       string            `json:"image"`
+	ImageID     string            `json:"image_id"`
+	ImageLabels map[string]string `json:"image_labels,omitempty"`
+	// SSHConfig and KnownHosts are the content of the container's files in
+	// ~/.ssh/config.d, empty when missing.
+	SSHConfig  string `json:"ssh_config"`
+	KnownHosts string `json:"known_hosts"`
+	// Remotes are the container's git remote in each repository.
+	Remotes []InspectRemote `json:"remotes,omitempty"`
+	Drift   Drift           `json:"drift"`
+	// Status is as reported by [Container.Status].
+	Status *Status `json:"status,omitempty"`
+	// Errors are the parts md failed to gather; the others are still
+	// reported.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// InspectRemote is the container's git remote in a repository on the host.
+type InspectRemote struct {
+	Repo    string `json:"repo"`
+	GitRoot string `json:"git_root"`
+	// URL is empty when the remote is missing.
+	URL string `json:"url"`
+}
+
+// Drift lists how the container departed from what md would set up today.
+// Everything is false for a container started from a fresh image whose SSH
+// config matches its published port.
+type Drift struct {
+	// ImageSuperseded is set when the image name now refers to a rebuilt
+	// image: the next md start gets a different image.
+	ImageSuperseded bool `json:"image_superseded"`
+	// BaseChanged is set when the local base image no longer has the digest
+	// the image was built from.
+	BaseChanged bool `json:"base_changed"`
+	// KeysChanged is set when the SSH keys baked in the image differ from
+	// the current ones.
+	KeysChanged bool `json:"keys_changed"`
+	// SSHConfigPort is the port in the SSH config when it differs from the
+	// published SSH port, e.g. after the engine restarted the container.
+	SSHConfigPort int `json:"ssh_config_port,omitempty"`
+	// SSHConfigMissing and RemoteMissing are set when md's SSH config or a
+	// repository's git remote for the container is gone.
+	SSHConfigMissing bool `json:"ssh_config_missing"`
+	RemoteMissing    bool `json:"remote_missing"`
+}
+
+// sshConfigPortRe matches the Port line of an SSH config written by
+// writeSSHConfig.
+var sshConfigPortRe = regexp.MustCompile(`(?m)^\s*Port (\d+)$`)
+
+// Inspect gathers what md knows about the container without changing
+// anything: its labels, ports, image, SSH files, git remotes, how it drifted
+// from a fresh start, and its status. The container's State must be set, as
+// done by [Client.List].
+func (c *Container) Inspect(ctx context.Context) (*Inspection, error) {
+	if c.Kube != nil {
+		return nil, errors.New("md inspect isn't supported on Kubernetes")
+	}
+	info, err := inspectContainer(ctx, c.Runtime, c.Name)
+	if err != nil {
+		return nil, fmt.Errorf("inspecting %s: %w", c.Name, err)
+	}
+	in := &Inspection{
+		Name:    c.Name,
+		State:   c.State,
+		Labels:  info.Config.Labels,
+		Ports:   info.publishedPorts(),
+		Image:   info.Config.Image,
+		ImageID: info.Image,
+	}
+	if img, err := inspectImage(ctx, c.Runtime, info.Image); err != nil {
+		in.Errors = append(in.Errors, fmt.Sprintf("inspecting image %s: %v", info.Image, err))
+	} else {
+		in.ImageLabels = img.Config.Labels
+		if base := img.Config.Labels["md.base_image"]; base != "" {
+			b, err := inspectImage(ctx, c.Runtime, base)
+			in.Drift.BaseChanged = err != nil || b.digest() != img.Config.Labels["md.base_digest"]
+		}
+		if sha, err := keysSHA(c.keysDir); err == nil {
+			in.Drift.KeysChanged = img.Config.Labels["md.context_sha"] != sha
+		}
+	}
+	if cur, err := inspectImage(ctx, c.Runtime, info.Config.Image); err != nil || cur.ID != info.Image {
+		in.Drift.ImageSuperseded = true
+	}
+
+	sshConfigDir := filepath.Join(c.Home, ".ssh", "config.d")
+	conf, err := os.ReadFile(filepath.Join(sshConfigDir, c.Name+".conf"))
+	if err != nil {
+		in.Drift.SSHConfigMissing = true
+	}
+	in.SSHConfig = string(conf)
+	known, _ := os.ReadFile(filepath.Join(sshConfigDir, c.Name+".known_hosts"))
+	in.KnownHosts = string(known)
+	in.Drift.SSHConfigPort = sshConfigPortDrift(in.SSHConfig, info)
+
+	for _, r := range c.Repos {
+		rm := InspectRemote{Repo: r.Name(), GitRoot: r.GitRoot}
+		if rm.URL, err = gitutil.RunGit(ctx, r.GitRoot, "remote", "get-url", c.Name); err != nil {
+			rm.URL = ""
+			in.Drift.RemoteMissing = true
+		}
+		in.Remotes = append(in.Remotes, rm)
+	}
+
+	if in.Status, err = c.Status(ctx); err != nil {
+		in.Errors = append(in.Errors, "status: "+err.Error())
+	}
+	return in, nil
+}
+
+// publishedPorts returns the container's published ports by container port,
+// as "host:port".
+func (i *containerInfo) publishedPorts() map[string][]string {
+	var ports map[string][]string
+	for port, bindings := range i.NetworkSettings.Ports {
+		for _, b := range bindings {
+			if ports == nil {
+				ports = map[string][]string{}
+			}
+			ports[port] = append(ports[port], net.JoinHostPort(b.HostIP, b.HostPort))
+		}
+	}
+	return ports
+}
+
+// sshConfigPortDrift returns the port of the SSH config conf when it isn't
+// the one the container's SSH port is published on, else 0.
+func sshConfigPortDrift(conf string, info *containerInfo) int {
+	m := sshConfigPortRe.FindStringSubmatch(conf)
+	b := info.NetworkSettings.Ports["22/tcp"]
+	if m == nil || len(b) == 0 || b[0].HostPort == m[1] {
+		return 0
+	}
+	port, _ := strconv.Atoi(m[1])
+	return port
+}
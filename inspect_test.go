@@ -0,0 +1,39 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"maps"
+	"slices"
+	"testing"
+)
+
+func TestInspectPorts(t *testing.T) {
+	var info containerInfo
+	info.NetworkSettings.Ports = map[string][]portBinding{
+		"22/tcp":   {{HostIP: "127.0.0.1", HostPort: "32769"}},
+		"5901/tcp": {{HostIP: "::1", HostPort: "32770"}},
+		"9222/tcp": nil,
+	}
+	ports := info.publishedPorts()
+	if got := slices.Sorted(maps.Keys(ports)); !slices.Equal(got, []string{"22/tcp", "5901/tcp"}) {
+		t.Errorf("got %q", got)
+	}
+	if got := ports["5901/tcp"]; !slices.Equal(got, []string{"[::1]:32770"}) {
+		t.Errorf("got %q", got)
+	}
+	conf := "Host md-repo-main\n  HostName 127.0.0.1\n  Port 32768\n  User user\n"
+	if got := sshConfigPortDrift(conf, &info); got != 32768 {
+		t.Errorf("got %d", got)
+	}
+	info.NetworkSettings.Ports["22/tcp"][0].HostPort = "32768"
+	if got := sshConfigPortDrift(conf, &info); got != 0 {
+		t.Errorf("got %d", got)
+	}
+	// Containers on the host's network and Kubernetes pods publish nothing.
+	if got := sshConfigPortDrift(conf, &containerInfo{}); got != 0 {
+		t.Errorf("got %d", got)
+	}
+}
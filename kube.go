@@ -0,0 +1,319 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kube selects a Kubernetes cluster to run containers on, as pods, instead
+// of the local engine. The engine still builds the image, which is then
+// pushed to Registry for the cluster to pull. ssh reaches the pod's sshd
+// through kubectl exec, so git push and pull work unchanged.
+//
+// Host-side features are not available: displays, browser, Tailscale, USB,
+// bind mounts, mounted checkouts, published ports, shared credentials,
+// services, process limits, and stop/resume.
+type Kube struct {
+	// Context is the kubeconfig context. Empty uses the current context.
+	Context string
+	// Namespace is the namespace of the pods. Empty uses the context's
+	// namespace.
+	Namespace string
+	// Registry is the image repository the cluster pulls from, e.g.
+	// "registry.example.com/md". The image holds md's SSH host key and the
+	// host caches, so the registry must be private.
+	Registry string
+}
+
+// kubeSelector selects md's pods.
+const kubeSelector = "app.kubernetes.io/managed-by=md"
+
+// kubectl returns the kubectl command line targeting k's context and
+// namespace.
+func (k *Kube) kubectl(args ...string) []string {
+	out := []string{"kubectl"}
+	if k.Context != "" {
+		out = append(out, "--context", k.Context)
+	}
+	if k.Namespace != "" {
+		out = append(out, "--namespace", k.Namespace)
+	}
+	return append(out, args...)
+}
+
+// image returns the reference the specialized image imageName is pushed as.
+func (k *Kube) image(imageName string) string {
+	return strings.TrimSuffix(k.Registry, "/") + "/" + imageName
+}
+
+// proxyCommand returns the ssh ProxyCommand connecting to the sshd of pod.
+func (k *Kube) proxyCommand(pod string) string {
+	args := k.kubectl("exec", "-i", pod, "--", "socat", "STDIO", "TCP:127.0.0.1:22")
+	for i, a := range args {
+		args[i] = shellQuote(a)
+	}
+	return strings.Join(args, " ")
+}
+
+// podName returns the pod name of the container: pod names are lowercase DNS
+// subdomains.
+func podName(name string) string {
+	return strings.Trim(strings.ReplaceAll(strings.ToLower(name), "_", "-"), "-.")
+}
+
+// kubeQuantity converts a Docker size like "8g" to a Kubernetes quantity
+// like "8Gi". s must pass ValidateSize.
+func kubeQuantity(s string) string {
+	s = strings.TrimRight(s, "bB")
+	if s == "" {
+		return s
+	}
+	switch last := s[len(s)-1]; last {
+	case 'k', 'K':
+		return s[:len(s)-1] + "Ki"
+	case 'm', 'M', 'g', 'G', 't', 'T':
+		return s[:len(s)-1] + strings.ToUpper(string(last)) + "i"
+	}
+	return s
+}
+
+// checkKubeOpts returns an error for the start options a pod can't honor.
+func checkKubeOpts(c *Container, opts *StartOpts) error {
+	var unsupported []string
+	for _, f := range []struct {
+		set  bool
+		name string
+	}{
+		{opts.Display, "display"},
+		{opts.Browser, "browser"},
+		{opts.Tailscale, "Tailscale"},
+		{opts.USB, "USB"},
+		{len(opts.Mounts) > 0, "mounts"},
+		{opts.MountSource != SourceClone, "mounted checkouts"},
+		{len(opts.PublishPorts) > 0, "published ports"},
+		{len(opts.HostPorts) > 0, "host ports"},
+		{opts.CloneDepth > 0 || opts.CloneFilter != "", "shallow or partial clones"},
+		{opts.SharedObjects, "shared objects"},
+		{opts.Bind != "", "bind address"},
+		{opts.Sudo != SudoDefault, "sudo policy"},
+		{len(opts.Credentials) > 0, "shared credentials"},
+		{len(c.services) > 0, "services"},
+		{opts.PidsLimit > 0, "pids limit"},
+		{len(opts.ExtraRunArgs) > 0, "engine arguments"},
+		{opts.NetworkMode != NetworkPrivate, "network mode"},
+		{opts.NetworkPolicy != nil, "egress restrictions"},
+	} {
+		if f.set {
+			unsupported = append(unsupported, f.name)
+		}
+	}
+	if len(unsupported) > 0 {
+		return fmt.Errorf("not supported on Kubernetes: %s", strings.Join(unsupported, ", "))
+	}
+	return nil
+}
+
+// podManifest returns the pod running container c from image. The md labels
+// docker records on containers are pod annotations.
+func podManifest(c *Container, opts *StartOpts, image string) ([]byte, error) {
+	annotations := map[string]string{"md.name": c.Name}
+	reposJSON, err := json.Marshal(c.Repos)
+	if err != nil {
+		return nil, err
+	}
+	annotations["md.repos"] = base64.StdEncoding.EncodeToString(reposJSON)
+	limits := map[string]string{}
+	if opts.MaxCPUs > 0 {
+		annotations["md.cpus"] = strconv.Itoa(opts.MaxCPUs)
+		limits["cpu"] = strconv.Itoa(opts.MaxCPUs)
+	}
+	if opts.Memory != "" {
+		if err := ValidateSize("memory limit", opts.Memory); err != nil {
+			return nil, err
+		}
+		annotations["md.memory"] = opts.Memory
+		limits["memory"] = kubeQuantity(opts.Memory)
+	}
+	if opts.TTL > 0 {
+		annotations["md.ttl"] = opts.TTL.String()
+	}
+	for _, l := range opts.Labels {
+		if k, v, ok := strings.Cut(l, "="); ok {
+			annotations[k] = v
+		}
+	}
+	ctr := map[string]any{
+		"name":  "md",
+		"image": image,
+		// The image is tagged by content.
+		"imagePullPolicy": "IfNotPresent",
+		// Same as --cap-add=SYS_PTRACE --security-opt seccomp=unconfined
+		// with docker.
+		"securityContext": map[string]any{
+			"capabilities":   map[string]any{"add": []string{"SYS_PTRACE"}},
+			"seccompProfile": map[string]any{"type": "Unconfined"},
+		},
+		"readinessProbe": map[string]any{
+			"tcpSocket":     map[string]any{"port": 22},
+			"periodSeconds": 1,
+		},
+	}
+	if len(limits) > 0 {
+		ctr["resources"] = map[string]any{"limits": limits}
+	}
+	spec := map[string]any{
+		"restartPolicy": "Never",
+		"containers":    []any{ctr},
+	}
+	if opts.ShmSize != "" {
+		if err := ValidateSize("shm size", opts.ShmSize); err != nil {
+			return nil, err
+		}
+		annotations["md.shm_size"] = opts.ShmSize
+		ctr["volumeMounts"] = []any{map[string]any{"name": "shm", "mountPath": "/dev/shm"}}
+		spec["volumes"] = []any{map[string]any{
+			"name":     "shm",
+			"emptyDir": map[string]any{"medium": "Memory", "sizeLimit": kubeQuantity(opts.ShmSize)},
+		}}
+	}
+	return json.Marshal(map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata": map[string]any{
+			"name":        podName(c.Name),
+			"labels":      map[string]string{"app.kubernetes.io/managed-by": "md"},
+			"annotations": annotations,
+		},
+		"spec": spec,
+	})
+}
+
+// kubePod is the subset of a pod's JSON md uses.
+type kubePod struct {
+	Metadata struct {
+		Name              string            `json:"name"`
+		CreationTimestamp time.Time         `json:"creationTimestamp"`
+		DeletionTimestamp *time.Time        `json:"deletionTimestamp"`
+		Annotations       map[string]string `json:"annotations"`
+	} `json:"metadata"`
+	Status struct {
+		Phase string `json:"phase"`
+	} `json:"status"`
+}
+
+// container returns the md container the pod runs, with a nil Client.
+func (p *kubePod) container() *Container {
+	ct := &Container{Name: p.Metadata.Annotations["md.name"], CreatedAt: p.Metadata.CreationTimestamp}
+	if ct.Name == "" {
+		ct.Name = p.Metadata.Name
+	}
+	for _, k := range slices.Sorted(maps.Keys(p.Metadata.Annotations)) {
+		ct.setLabel(k, p.Metadata.Annotations[k])
+	}
+	// Map the phase to docker's states.
+	switch {
+	case p.Metadata.DeletionTimestamp != nil:
+		ct.State = "removing"
+	case p.Status.Phase == "Pending":
+		ct.State = "created"
+	case p.Status.Phase == "Running":
+		ct.State = "running"
+	case p.Status.Phase == "Succeeded" || p.Status.Phase == "Failed":
+		ct.State = "exited"
+	default:
+		ct.State = strings.ToLower(p.Status.Phase)
+	}
+	return ct
+}
+
+// listPods returns md's pods.
+func (k *Kube) listPods(ctx context.Context) ([]*Container, error) {
+	out, err := runCmd(ctx, "", k.kubectl("get", "pods", "-l", kubeSelector, "-o", "json"))
+	if err != nil {
+		return nil, cmdErrWithStderr("listing pods", err)
+	}
+	var list struct {
+		Items []kubePod `json:"items"`
+	}
+	if err := json.Unmarshal([]byte(out), &list); err != nil {
+		return nil, fmt.Errorf("parsing pods: %w", err)
+	}
+	containers := make([]*Container, len(list.Items))
+	for i := range list.Items {
+		containers[i] = list.Items[i].container()
+	}
+	return containers, nil
+}
+
+// podExists reports whether the container's pod exists.
+func (k *Kube) podExists(ctx context.Context, name string) bool {
+	_, err := runCmd(ctx, "", k.kubectl("get", "pod", podName(name), "-o", "name"))
+	return err == nil
+}
+
+// launchPod pushes imageName to the registry, creates the pod running it and
+// sets up SSH and the git remotes like launchContainer. It waits for the pod
+// to be ready, but not for SSH.
+func launchPod(ctx context.Context, stdout, stderr io.Writer, c *Container, opts *StartOpts, imageName string) error {
+	k := c.Kube
+	if k.Registry == "" {
+		return errors.New("a registry the cluster can pull from is required to run on Kubernetes")
+	}
+	if err := checkKubeOpts(c, opts); err != nil {
+		return err
+	}
+	image := k.image(imageName)
+	manifest, err := podManifest(c, opts, image)
+	if err != nil {
+		return err
+	}
+	out, errOut := stdout, stderr
+	if opts.Quiet {
+		out, errOut = io.Discard, io.Discard
+	} else {
+		_, _ = fmt.Fprintf(stdout, "- Pushing %s ...\n", image)
+	}
+	if _, err := runCmd(ctx, "", []string{c.Runtime, "tag", imageName, image}); err != nil {
+		return cmdErrWithStderr("tagging image", err)
+	}
+	if err := runCmdOut(ctx, "", []string{c.Runtime, "push", image}, out, errOut); err != nil {
+		return fmt.Errorf("pushing %s: %w", image, err)
+	}
+	if !opts.Quiet {
+		_, _ = fmt.Fprintf(stdout, "- Starting pod %s ...\n", podName(c.Name))
+	}
+	args := k.kubectl("create", "-f", "-")
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(manifest)
+	if b, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("creating pod: %w\n%s", err, strings.TrimSpace(string(b)))
+	}
+	// Pulling the image onto the node may take a while.
+	if _, err := runCmd(ctx, "", k.kubectl("wait", "--for=condition=Ready", "pod/"+podName(c.Name), "--timeout=10m")); err != nil {
+		return cmdErrWithStderr("waiting for pod "+podName(c.Name), err)
+	}
+	c.CreatedAt = time.Now()
+	if pods, err := k.listPods(ctx); err == nil {
+		for _, p := range pods {
+			if p.Name == c.Name {
+				c.CreatedAt = p.CreatedAt
+			}
+		}
+	}
+	return setupHostAccess(ctx, stdout, stderr, c, c.sshEndpoint(), opts.Quiet)
+}
@@ -0,0 +1,151 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKubectl(t *testing.T) {
+	k := &Kube{Context: "dev", Namespace: "md"}
+	if got, want := k.kubectl("get", "pods"), []string{"kubectl", "--context", "dev", "--namespace", "md", "get", "pods"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := (&Kube{}).kubectl("get", "pods"), []string{"kubectl", "get", "pods"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := (&Kube{Registry: "r.example.com/md/"}).image("md-specialized-abc"), "r.example.com/md/md-specialized-abc"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	want := "kubectl --context dev --namespace md exec -i md-repo-main -- socat STDIO TCP:127.0.0.1:22"
+	if got := k.proxyCommand("md-repo-main"); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestPodName(t *testing.T) {
+	for in, want := range map[string]string{
+		"md-repo-main":        "md-repo-main",
+		"md-Repo-feature_x":   "md-repo-feature-x",
+		"md-repo-release-1.2": "md-repo-release-1.2",
+	} {
+		if got := podName(in); got != want {
+			t.Errorf("podName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestKubeQuantity(t *testing.T) {
+	for in, want := range map[string]string{
+		"8g":    "8Gi",
+		"512m":  "512Mi",
+		"1.5GB": "1.5Gi",
+		"64k":   "64Ki",
+		"1t":    "1Ti",
+		"4096":  "4096",
+		"100b":  "100",
+	} {
+		if got := kubeQuantity(in); got != want {
+			t.Errorf("kubeQuantity(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestCheckKubeOpts(t *testing.T) {
+	c := &Container{}
+	if err := checkKubeOpts(c, &StartOpts{MaxCPUs: 4, Memory: "8g"}); err != nil {
+		t.Fatal(err)
+	}
+	err := checkKubeOpts(c, &StartOpts{Display: true, PublishPorts: []PortMapping{{Host: 8080, Container: 8080}}})
+	if err == nil || err.Error() != "not supported on Kubernetes: display, published ports" {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestPodManifest(t *testing.T) {
+	c := &Container{Name: "md-repo-Main", Repos: []Repo{{GitRoot: "/src/repo", Branch: "Main"}}}
+	opts := &StartOpts{MaxCPUs: 4, Memory: "8g", ShmSize: "1g", TTL: 48 * time.Hour, Labels: []string{"team=infra"}}
+	data, err := podManifest(c, opts, "r.example.com/md/md-specialized-abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pod struct {
+		kubePod
+		Spec struct {
+			Containers []struct {
+				Image     string `json:"image"`
+				Resources struct {
+					Limits map[string]string `json:"limits"`
+				} `json:"resources"`
+			} `json:"containers"`
+			Volumes []struct {
+				EmptyDir struct {
+					SizeLimit string `json:"sizeLimit"`
+				} `json:"emptyDir"`
+			} `json:"volumes"`
+		} `json:"spec"`
+	}
+	if err := json.Unmarshal(data, &pod); err != nil {
+		t.Fatal(err)
+	}
+	if pod.Metadata.Name != "md-repo-main" {
+		t.Errorf("name = %q", pod.Metadata.Name)
+	}
+	if len(pod.Spec.Containers) != 1 || pod.Spec.Containers[0].Image != "r.example.com/md/md-specialized-abc" {
+		t.Fatalf("containers = %+v", pod.Spec.Containers)
+	}
+	if l := pod.Spec.Containers[0].Resources.Limits; l["cpu"] != "4" || l["memory"] != "8Gi" {
+		t.Errorf("limits = %v", l)
+	}
+	if len(pod.Spec.Volumes) != 1 || pod.Spec.Volumes[0].EmptyDir.SizeLimit != "1Gi" {
+		t.Errorf("volumes = %+v", pod.Spec.Volumes)
+	}
+	if pod.Metadata.Annotations["team"] != "infra" {
+		t.Errorf("annotations = %v", pod.Metadata.Annotations)
+	}
+
+	// The annotations round-trip through List.
+	pod.Status.Phase = "Running"
+	ct := pod.container()
+	if ct.Name != "md-repo-Main" || ct.State != "running" || ct.MaxCPUs != 4 || ct.Memory != "8g" || ct.ShmSize != "1g" || ct.TTL != 48*time.Hour {
+		t.Errorf("got %+v", ct)
+	}
+	if len(ct.Repos) != 1 || ct.Repos[0].Branch != "Main" {
+		t.Errorf("repos = %+v", ct.Repos)
+	}
+}
+
+func TestWriteSSHConfigProxy(t *testing.T) {
+	dir := t.TempDir()
+	ep := sshEndpoint{ProxyCommand: "kubectl exec -i md-repo-main -- socat STDIO TCP:127.0.0.1:22"}
+	known := filepath.Join(dir, "md-repo-main.known_hosts")
+	if err := writeSSHConfig(dir, "md-repo-main", ep, "/home/me/.ssh/md", known, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeKnownHosts(known, "md-repo-main", "ssh-ed25519 AAAA"); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := os.ReadFile(filepath.Join(dir, "md-repo-main.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"  ProxyCommand " + ep.ProxyCommand + "\n", "  HostKeyAlias md-repo-main\n"} {
+		if !strings.Contains(string(conf), want) {
+			t.Errorf("missing %q in:\n%s", want, conf)
+		}
+	}
+	if strings.Contains(string(conf), "HostName") {
+		t.Errorf("unexpected HostName in:\n%s", conf)
+	}
+	if got, err := os.ReadFile(known); err != nil || !knownHostsHas(got, "md-repo-main") || !strings.HasSuffix(string(got), " ssh-ed25519 AAAA\n") {
+		t.Errorf("known_hosts = %q, %v", got, err)
+	}
+}
@@ -0,0 +1,283 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/caic-xyz/md/gitutil"
+)
+
+// Git LFS objects are copied between the host's and the container's object
+// directories over SSH instead of going through an LFS server: the container
+// has none to reach, and the host's one may not be reachable either.
+
+// errNoGitLFS is returned when a repository uses Git LFS but git-lfs isn't
+// installed on the host.
+var errNoGitLFS = errors.New("git-lfs is not installed on the host")
+
+// reLFSObject matches the path of an LFS object relative to the object
+// directory, e.g. "ab/cd/abcd...".
+var reLFSObject = regexp.MustCompile(`^([0-9a-f]{2})/([0-9a-f]{2})/([0-9a-f]{64})$`)
+
+// lfsSkipSmudge prefixes a git command run in the container so a checkout
+// doesn't try to download the LFS objects md didn't copy. lfsCheckout then
+// replaces the pointers of the copied ones with their content.
+const (
+	lfsSkipSmudge = "GIT_LFS_SKIP_SMUDGE=1 "
+	lfsCheckout   = " && if command -v git-lfs >/dev/null; then git lfs checkout >/dev/null; fi"
+)
+
+// usesLFS reports whether ref stores files with Git LFS, per the
+// .gitattributes files of its tree.
+func usesLFS(ctx context.Context, dir, ref string) bool {
+	_, err := gitutil.RunGit(ctx, dir, "grep", "-q", "-e", "filter=lfs", ref, "--", ".gitattributes", ":(glob)**/.gitattributes")
+	return err == nil
+}
+
+// lfsObjects returns the host directory of the LFS objects of the repository
+// at dir and the paths, relative to it, of the objects ref's tree references.
+// The directory is "" when ref doesn't use Git LFS.
+func lfsObjects(ctx context.Context, dir, ref string) (string, []string, error) {
+	if !usesLFS(ctx, dir, ref) {
+		return "", nil, nil
+	}
+	if _, err := exec.LookPath("git-lfs"); err != nil {
+		return "", nil, errNoGitLFS
+	}
+	env, err := gitutil.RunGit(ctx, dir, "lfs", "env")
+	if err != nil {
+		return "", nil, err
+	}
+	objDir := ""
+	for line := range strings.SplitSeq(env, "\n") {
+		if v, ok := strings.CutPrefix(line, "LocalMediaDir="); ok {
+			objDir = v
+		}
+	}
+	if objDir == "" {
+		return "", nil, errors.New("git lfs env: no LocalMediaDir")
+	}
+	out, err := gitutil.RunGit(ctx, dir, "lfs", "ls-files", "--long", ref)
+	if err != nil {
+		return "", nil, err
+	}
+	var paths []string
+	for line := range strings.SplitSeq(out, "\n") {
+		// "<oid> <*|-> <path>"
+		if oid, _, _ := strings.Cut(line, " "); len(oid) == 64 {
+			if p := oid[:2] + "/" + oid[2:4] + "/" + oid; reLFSObject.MatchString(p) {
+				paths = append(paths, p)
+			}
+		}
+	}
+	slices.Sort(paths)
+	return objDir, slices.Compact(paths), nil
+}
+
+// lfsDirScript changes to the LFS object directory of repo in the container,
+// in $d.
+func lfsDirScript(repo string) string {
+	return "cd ~/src/" + shellQuote(repo) + ` && d="$(git rev-parse --path-format=absolute --git-common-dir)/lfs/objects"`
+}
+
+// pushLFS copies to the container the LFS objects ref references that the
+// host has and the container lacks, so a checkout of ref there gets the
+// files' content. It reports whether ref uses Git LFS.
+func (c *Container) pushLFS(ctx context.Context, stdout io.Writer, r *Repo, ref string) (bool, error) {
+	objDir, paths, err := lfsObjects(ctx, r.GitRoot, ref)
+	if errors.Is(err, errNoGitLFS) {
+		_, _ = fmt.Fprintf(stdout, "- %s uses Git LFS but git-lfs isn't installed on the host: its LFS files stay pointers in the container\n", r.Name())
+		return true, nil
+	}
+	if err != nil || objDir == "" {
+		return false, err
+	}
+	var have []string
+	for _, p := range paths {
+		if _, err := os.Stat(filepath.Join(objDir, filepath.FromSlash(p))); err == nil {
+			have = append(have, p)
+		}
+	}
+	if missing := len(paths) - len(have); missing != 0 {
+		slog.WarnContext(ctx, "md", "msg", "LFS objects missing on the host", "repo", r.Name(), "ref", ref, "missing", missing)
+	}
+	if len(have) == 0 {
+		return true, nil
+	}
+	// Ask the container which ones it lacks.
+	var want bytes.Buffer
+	if err := c.sshStdin(ctx, lfsDirScript(r.Name())+` && while read -r p; do [ -f "$d/$p" ] || echo "$p"; done`, strings.NewReader(strings.Join(have, "\n")+"\n"), &want); err != nil {
+		return true, fmt.Errorf("listing the LFS objects of %s in the container: %w", r.Name(), err)
+	}
+	send := slices.DeleteFunc(strings.Fields(want.String()), func(p string) bool { return !slices.Contains(have, p) })
+	if len(send) == 0 {
+		return true, nil
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		_ = pw.CloseWithError(writeLFSTar(pw, objDir, send))
+	}()
+	err = c.sshStdin(ctx, lfsDirScript(r.Name())+` && mkdir -p "$d" && tar -C "$d" -xf -`, pr, io.Discard)
+	_ = pr.Close()
+	if err != nil {
+		return true, fmt.Errorf("copying the LFS objects of %s to the container: %w", r.Name(), err)
+	}
+	_, _ = fmt.Fprintf(stdout, "- Copied %d Git LFS object(s) of %s to the container\n", len(send), r.Name())
+	return true, nil
+}
+
+// pullLFS copies from the container the LFS objects ref, fetched from it,
+// references that the host lacks, so integrating ref checks out the files'
+// content. It does nothing when ref doesn't use Git LFS.
+func (c *Container) pullLFS(ctx context.Context, stdout io.Writer, r *Repo, ref string) error {
+	objDir, paths, err := lfsObjects(ctx, r.GitRoot, ref)
+	if errors.Is(err, errNoGitLFS) {
+		_, _ = fmt.Fprintf(stdout, "- %s uses Git LFS but git-lfs isn't installed on the host: its LFS files stay pointers\n", r.Name())
+		return nil
+	}
+	if err != nil || objDir == "" {
+		return err
+	}
+	var want []string
+	for _, p := range paths {
+		if _, err := os.Stat(filepath.Join(objDir, filepath.FromSlash(p))); err != nil {
+			want = append(want, p)
+		}
+	}
+	if len(want) == 0 {
+		return nil
+	}
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		n, err := readLFSTar(pr, objDir, want)
+		_ = pr.CloseWithError(err)
+		if err == nil && n != 0 {
+			_, _ = fmt.Fprintf(stdout, "- Copied %d Git LFS object(s) of %s from the container\n", n, r.Name())
+		}
+		done <- err
+	}()
+	// The objects the container doesn't have either are left to the host's
+	// LFS server, if any.
+	script := lfsDirScript(r.Name()) + ` && cd "$d" 2>/dev/null || exit 0; while read -r p; do if [ -f "$p" ]; then echo "$p"; fi; done | tar -cf - -T -`
+	err = c.sshStdin(ctx, script, strings.NewReader(strings.Join(want, "\n")+"\n"), pw)
+	_ = pw.CloseWithError(err)
+	if err2 := <-done; err == nil {
+		err = err2
+	}
+	if err != nil {
+		return fmt.Errorf("copying the LFS objects of %s from the container: %w", r.Name(), err)
+	}
+	return nil
+}
+
+// sshStdin runs script in the container with stdin, writing its stdout to
+// stdout.
+func (c *Container) sshStdin(ctx context.Context, script string, stdin io.Reader, stdout io.Writer) error {
+	args := c.SSHCommand(c.Name, script)
+	slog.DebugContext(ctx, "md", "msg", "exec", "cmd", args)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	cmd.WaitDelay = cmdWaitDelay
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// writeLFSTar writes the LFS objects paths of objDir as a tar stream.
+func writeLFSTar(w io.Writer, objDir string, paths []string) error {
+	tw := tar.NewWriter(w)
+	for _, p := range paths {
+		f, err := os.Open(filepath.Join(objDir, filepath.FromSlash(p)))
+		if err != nil {
+			return err
+		}
+		fi, err := f.Stat()
+		if err == nil {
+			err = tw.WriteHeader(&tar.Header{Name: p, Mode: 0o644, Size: fi.Size(), ModTime: fi.ModTime(), Typeflag: tar.TypeReg})
+		}
+		if err == nil {
+			_, err = io.Copy(tw, f)
+		}
+		_ = f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// readLFSTar extracts the LFS objects of the tar stream r into objDir. Only
+// the wanted objects whose content matches their ID are kept: the container
+// isn't trusted. It returns the number of objects written.
+func readLFSTar(r io.Reader, objDir string, want []string) (int, error) {
+	tr := tar.NewReader(r)
+	n := 0
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		name := path.Clean(hdr.Name)
+		m := reLFSObject.FindStringSubmatch(name)
+		if hdr.Typeflag != tar.TypeReg || m == nil || !slices.Contains(want, name) {
+			return n, fmt.Errorf("unexpected LFS object %q", hdr.Name)
+		}
+		if err := writeLFSObject(tr, filepath.Join(objDir, filepath.FromSlash(name)), m[3]); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+// writeLFSObject writes the object oid read from r to dst, verifying its
+// content.
+func writeLFSObject(r io.Reader, dst, oid string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(dst), ".md-lfs-*")
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, h), r)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err == nil && hex.EncodeToString(h.Sum(nil)) != oid {
+		err = fmt.Errorf("LFS object %s: content doesn't match", oid)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), dst)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
@@ -0,0 +1,100 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUsesLFS(t *testing.T) {
+	ctx := t.Context()
+	dir := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.CommandContext(ctx, "git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@test"}, args...)...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	git("init", "-q", "--initial-branch=main")
+	if err := os.WriteFile(filepath.Join(dir, ".gitattributes"), []byte("*.txt text\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	git("add", ".")
+	git("commit", "-q", "-m", "plain")
+	if usesLFS(ctx, dir, "main") {
+		t.Error("plain repository uses LFS")
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "assets"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "assets", ".gitattributes"), []byte("*.png filter=lfs diff=lfs merge=lfs -text\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	git("add", ".")
+	git("commit", "-q", "-m", "lfs")
+	if !usesLFS(ctx, dir, "main") {
+		t.Error("expected LFS")
+	}
+	if usesLFS(ctx, dir, "main~1") {
+		t.Error("main~1 uses LFS")
+	}
+}
+
+func TestLFSTar(t *testing.T) {
+	src := t.TempDir()
+	var paths []string
+	for _, content := range []string{"one", "two"} {
+		sum := sha256.Sum256([]byte(content))
+		oid := hex.EncodeToString(sum[:])
+		p := oid[:2] + "/" + oid[2:4] + "/" + oid
+		paths = append(paths, p)
+		if err := os.MkdirAll(filepath.Join(src, oid[:2], oid[2:4]), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(src, filepath.FromSlash(p)), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := writeLFSTar(&buf, src, paths); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	dst := t.TempDir()
+	if n, err := readLFSTar(bytes.NewReader(data), dst, paths); err != nil || n != 2 {
+		t.Fatalf("got %d, %v", n, err)
+	}
+	if b, err := os.ReadFile(filepath.Join(dst, filepath.FromSlash(paths[1]))); err != nil || string(b) != "two" {
+		t.Errorf("got %q, %v", b, err)
+	}
+	// Objects that weren't asked for are rejected.
+	if _, err := readLFSTar(bytes.NewReader(data), t.TempDir(), paths[1:]); err == nil || !strings.Contains(err.Error(), "unexpected LFS object") {
+		t.Errorf("got %v", err)
+	}
+	// So is content not matching its ID.
+	if err := os.WriteFile(filepath.Join(src, filepath.FromSlash(paths[0])), []byte("tampered"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := writeLFSTar(&buf, src, paths[:1]); err != nil {
+		t.Fatal(err)
+	}
+	dst = t.TempDir()
+	if _, err := readLFSTar(&buf, dst, paths); err == nil || !strings.Contains(err.Error(), "doesn't match") {
+		t.Errorf("got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, filepath.FromSlash(paths[0]))); err == nil {
+		t.Error("the tampered object was written")
+	}
+}
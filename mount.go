@@ -0,0 +1,261 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// BindMount is an arbitrary host path bind-mounted into the container at
+// runtime, e.g. a large dataset that shouldn't be copied. Unlike
+// [CacheMount], it is not baked into the image.
+type BindMount struct {
+	// HostPath is the absolute path on the host.
+	HostPath string `json:"host"`
+	// ContainerPath is the absolute path inside the container.
+	ContainerPath string `json:"container"`
+	// ReadWrite allows the container to modify the host directory. Mounts
+	// are read-only by default.
+	ReadWrite bool `json:"rw,omitempty"`
+}
+
+// ParseBindMount parses "host:container[:ro|:rw]". Mounts are read-only
+// unless ":rw" is specified.
+func ParseBindMount(spec string) (BindMount, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return BindMount{}, fmt.Errorf("invalid mount %q: use host:container[:ro|:rw]", spec)
+	}
+	m := BindMount{HostPath: parts[0], ContainerPath: parts[1]}
+	if len(parts) == 3 {
+		switch parts[2] {
+		case "ro":
+		case "rw":
+			m.ReadWrite = true
+		default:
+			return BindMount{}, fmt.Errorf("invalid mount %q: mode must be ro or rw", spec)
+		}
+	}
+	return m, nil
+}
+
+// String returns the mount in the form accepted by [ParseBindMount].
+func (m BindMount) String() string {
+	if m.ReadWrite {
+		return m.HostPath + ":" + m.ContainerPath + ":rw"
+	}
+	return m.HostPath + ":" + m.ContainerPath + ":ro"
+}
+
+// deniedHostPaths are host paths (and their subtrees) that must never be
+// mounted: they would let the container reach the host's runtime or
+// system configuration.
+var deniedHostPaths = []string{
+	"/boot",
+	"/dev",
+	"/etc",
+	"/private/etc",
+	"/private/var/run",
+	"/proc",
+	"/run",
+	"/sys",
+	"/var/run",
+	"/var/lib/docker",
+	"/var/lib/containers",
+}
+
+// deniedHomePaths are paths relative to $HOME holding secrets or md's own
+// state. Credentials are shared via [StartOpts.Credentials] instead.
+var deniedHomePaths = []string{
+	".aws",
+	".azure",
+	".config/gcloud",
+	".config/md",
+	".docker",
+	".gnupg",
+	".kube",
+	".local/share/containers",
+	".ssh",
+}
+
+// deniedContainerPaths are container paths a mount must not cover or shadow.
+var deniedContainerPaths = []string{
+	"/bin",
+	"/boot",
+	"/dev",
+	"/etc",
+	"/lib",
+	"/proc",
+	"/root",
+	"/sbin",
+	"/sys",
+	"/usr",
+	"/var",
+	"/home/user/src",
+}
+
+// validateBindMount enforces the mount security policy and returns the mount
+// with HostPath resolved to an absolute path free of symlinks.
+//
+// The host path must be an existing path that is neither "/", $HOME, an
+// ancestor of $HOME nor within a system or secret directory. The container
+// path must be absolute and must not cover system directories or the repos
+// in /home/user/src.
+func validateBindMount(m BindMount, home string) (BindMount, error) {
+	if !filepath.IsAbs(m.HostPath) {
+		return m, fmt.Errorf("mount %s: host path must be absolute", m)
+	}
+	resolved, err := filepath.EvalSymlinks(m.HostPath)
+	if err != nil {
+		return m, fmt.Errorf("mount %s: %w", m, err)
+	}
+	if _, err := os.Stat(resolved); err != nil {
+		return m, fmt.Errorf("mount %s: %w", m, err)
+	}
+	if realHome, err := filepath.EvalSymlinks(home); err == nil {
+		home = realHome
+	}
+	if resolved == string(filepath.Separator) || isWithin(home, resolved) {
+		return m, fmt.Errorf("mount %s: refusing to mount %s or an ancestor of the home directory", m, resolved)
+	}
+	for _, d := range deniedHostPaths {
+		if isWithin(resolved, d) {
+			return m, fmt.Errorf("mount %s: %s is a protected system path", m, resolved)
+		}
+	}
+	for _, d := range deniedHomePaths {
+		if p := filepath.Join(home, filepath.FromSlash(d)); isWithin(resolved, p) || isWithin(p, resolved) {
+			return m, fmt.Errorf("mount %s: %s would expose ~/%s; use --creds to share credentials", m, resolved, d)
+		}
+	}
+	m.HostPath = resolved
+
+	if !path.IsAbs(m.ContainerPath) {
+		return m, fmt.Errorf("mount %s: container path must be absolute", m)
+	}
+	c := path.Clean(m.ContainerPath)
+	if c == "/" || c == "/home" || c == "/home/user" {
+		return m, fmt.Errorf("mount %s: refusing to shadow %s", m, c)
+	}
+	for _, d := range deniedContainerPaths {
+		if isWithinSlash(c, d) || isWithinSlash(d, c) {
+			return m, fmt.Errorf("mount %s: container path %s overlaps %s", m, c, d)
+		}
+	}
+	m.ContainerPath = c
+	return m, nil
+}
+
+// validateBindMounts validates every mount and rejects duplicate container
+// paths.
+func validateBindMounts(mounts []BindMount, home string) ([]BindMount, error) {
+	out := make([]BindMount, 0, len(mounts))
+	seen := make(map[string]struct{}, len(mounts))
+	for _, m := range mounts {
+		v, err := validateBindMount(m, home)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := seen[v.ContainerPath]; ok {
+			return nil, errors.New("duplicate mount target " + v.ContainerPath)
+		}
+		seen[v.ContainerPath] = struct{}{}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+// isWithin reports whether p is dir or inside dir (host paths).
+func isWithin(p, dir string) bool {
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// isWithinSlash reports whether p is dir or inside dir (container paths).
+func isWithinSlash(p, dir string) bool {
+	return p == dir || strings.HasPrefix(p, dir+"/")
+}
+
+// SourceMount selects how the repositories reach the container.
+type SourceMount string
+
+const (
+	// SourceClone pushes each repository into its own clone in the
+	// container; md push and md pull exchange commits with it. The default.
+	SourceClone SourceMount = ""
+	// SourceReadWrite bind-mounts the host checkout read-write. Edits in the
+	// container are immediately on the host, including any destructive git
+	// operation an agent runs.
+	SourceReadWrite SourceMount = "rw"
+	// SourceReadOnly bind-mounts the host checkout read-only. The container
+	// sees host edits live but can't write to the repository.
+	SourceReadOnly SourceMount = "ro"
+	// SourceOverlay mounts the host checkout read-only under an overlayfs
+	// whose upper layer belongs to the container. The container can write
+	// freely, its .git included; the host checkout is never modified. md push
+	// and md pull work as with a clone: md pull commits the overlay's changes
+	// in the container and fetches the commit.
+	SourceOverlay SourceMount = "overlay"
+)
+
+// ownsGit reports whether the container has a git repository of its own to
+// exchange commits with: a clone, or the overlay's copy-on-write view of the
+// host's.
+func (s SourceMount) ownsGit() bool {
+	return s == SourceClone || s == SourceOverlay
+}
+
+// ParseSourceMount parses a --mount-src mode. "true", as set by a bare
+// boolean flag, means read-write.
+func ParseSourceMount(s string) (SourceMount, error) {
+	switch s {
+	case "", "false":
+		return SourceClone, nil
+	case "true", "rw":
+		return SourceReadWrite, nil
+	case "ro":
+		return SourceReadOnly, nil
+	case "overlay":
+		return SourceOverlay, nil
+	}
+	return SourceClone, fmt.Errorf("invalid source mount mode %q: use rw, ro or overlay", s)
+}
+
+// sourceMountArgs returns the container runtime arguments mounting the host
+// checkout hostPath at containerPath. upperDir holds the overlay's upper and
+// work directories when the runtime can't provide them itself.
+//
+// Podman supports overlay mounts natively with the ":O" option. Docker has
+// no equivalent, so the overlay is a volume of the local driver mounted by
+// the daemon, which must run on the same machine as the checkout. Either way
+// the upper layer is under upperDir.
+func sourceMountArgs(rt string, mode SourceMount, hostPath, containerPath, upperDir string) ([]string, error) {
+	if fi, err := os.Stat(filepath.Join(hostPath, ".git")); err != nil || !fi.IsDir() {
+		return nil, fmt.Errorf("%s: only a main checkout with its own .git directory can be mounted, not a worktree or a mirror", hostPath)
+	}
+	if strings.ContainsAny(hostPath, `:,"`) {
+		return nil, fmt.Errorf("%s: can't mount a path containing ':', ',' or '\"'", hostPath)
+	}
+	switch mode {
+	case SourceReadWrite:
+		return []string{"-v", hostPath + ":" + containerPath}, nil
+	case SourceReadOnly:
+		return []string{"-v", hostPath + ":" + containerPath + ":ro"}, nil
+	case SourceOverlay:
+		return engineFor(rt).overlayMountArgs(hostPath, containerPath, upperDir)
+	}
+	return nil, fmt.Errorf("invalid source mount mode %q", mode)
+}
+
+// overlayDir is where Docker keeps the upper layers of the container's
+// --mount-src=overlay mounts, one subdirectory per repo.
+func (c *Container) overlayDir() string {
+	return filepath.Join(c.XDGStateHome, "md", "overlay", c.Name)
+}
@@ -0,0 +1,151 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestParseBindMount(t *testing.T) {
+	t.Run("default_ro", func(t *testing.T) {
+		m, err := ParseBindMount("/data/set:/mnt/set")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := BindMount{HostPath: "/data/set", ContainerPath: "/mnt/set"}
+		if m != want {
+			t.Errorf("got %+v, want %+v", m, want)
+		}
+		if s := m.String(); s != "/data/set:/mnt/set:ro" {
+			t.Errorf("String() = %q", s)
+		}
+	})
+	t.Run("rw", func(t *testing.T) {
+		m, err := ParseBindMount("/data/set:/mnt/set:rw")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !m.ReadWrite {
+			t.Error("ReadWrite = false, want true")
+		}
+	})
+	for _, spec := range []string{"", "/data", ":/mnt", "/data:", "/a:/b:rx", "/a:/b:ro:x"} {
+		t.Run("invalid_"+spec, func(t *testing.T) {
+			if _, err := ParseBindMount(spec); err == nil {
+				t.Errorf("ParseBindMount(%q) succeeded", spec)
+			}
+		})
+	}
+}
+
+func TestValidateBindMount(t *testing.T) {
+	root := t.TempDir()
+	home := filepath.Join(root, "home")
+	data := filepath.Join(root, "data")
+	for _, d := range []string{filepath.Join(home, ".ssh"), filepath.Join(home, "datasets"), data} {
+		if err := os.MkdirAll(d, 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	realData, err := filepath.EvalSymlinks(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Run("ok", func(t *testing.T) {
+		m, err := validateBindMount(BindMount{HostPath: data, ContainerPath: "/mnt/set/"}, home)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.HostPath != realData || m.ContainerPath != "/mnt/set" {
+			t.Errorf("got %+v", m)
+		}
+	})
+	t.Run("home_subdir_ok", func(t *testing.T) {
+		if _, err := validateBindMount(BindMount{HostPath: filepath.Join(home, "datasets"), ContainerPath: "/data"}, home); err != nil {
+			t.Fatal(err)
+		}
+	})
+	bad := []BindMount{
+		{HostPath: "relative", ContainerPath: "/mnt/x"},
+		{HostPath: filepath.Join(root, "missing"), ContainerPath: "/mnt/x"},
+		{HostPath: home, ContainerPath: "/mnt/x"},
+		{HostPath: root, ContainerPath: "/mnt/x"},
+		{HostPath: filepath.Join(home, ".ssh"), ContainerPath: "/mnt/x"},
+		{HostPath: data, ContainerPath: "mnt/x"},
+		{HostPath: data, ContainerPath: "/"},
+		{HostPath: data, ContainerPath: "/home/user"},
+		{HostPath: data, ContainerPath: "/usr/local"},
+		{HostPath: data, ContainerPath: "/home/user/src/repo"},
+	}
+	for _, m := range bad {
+		t.Run(m.String(), func(t *testing.T) {
+			if _, err := validateBindMount(m, home); err == nil {
+				t.Errorf("validateBindMount(%s) succeeded", m)
+			}
+		})
+	}
+	t.Run("duplicate_target", func(t *testing.T) {
+		ms := []BindMount{{HostPath: data, ContainerPath: "/mnt/x"}, {HostPath: data, ContainerPath: "/mnt/x/"}}
+		if _, err := validateBindMounts(ms, home); err == nil {
+			t.Error("expected error for duplicate target")
+		}
+	})
+}
+
+func TestParseSourceMount(t *testing.T) {
+	for in, want := range map[string]SourceMount{
+		"":        SourceClone,
+		"true":    SourceReadWrite,
+		"rw":      SourceReadWrite,
+		"ro":      SourceReadOnly,
+		"overlay": SourceOverlay,
+	} {
+		if got, err := ParseSourceMount(in); err != nil || got != want {
+			t.Errorf("ParseSourceMount(%q) = %q, %v", in, got, err)
+		}
+	}
+	if _, err := ParseSourceMount("copy"); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestSourceMountArgs(t *testing.T) {
+	repo := t.TempDir()
+	if err := os.Mkdir(filepath.Join(repo, ".git"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	upper := filepath.Join(t.TempDir(), "repo")
+	got, err := sourceMountArgs("docker", SourceReadOnly, repo, "/home/user/src/repo", upper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"-v", repo + ":/home/user/src/repo:ro"}; !slices.Equal(got, want) {
+		t.Errorf("ro: got %q, want %q", got, want)
+	}
+	got, err = sourceMountArgs("podman", SourceOverlay, repo, "/home/user/src/repo", upper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"-v", repo + ":/home/user/src/repo:O,upperdir=" + filepath.Join(upper, "upper") + ",workdir=" + filepath.Join(upper, "work")}
+	if !slices.Equal(got, want) {
+		t.Errorf("overlay: got %q, want %q", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(upper, "work")); err != nil {
+		t.Error(err)
+	}
+
+	// A worktree's .git is a file pointing at the main repository, which
+	// isn't mounted.
+	worktree := t.TempDir()
+	if err := os.WriteFile(filepath.Join(worktree, ".git"), []byte("gitdir: /elsewhere\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sourceMountArgs("docker", SourceReadWrite, worktree, "/home/user/src/repo", upper); err == nil {
+		t.Error("expected error for a worktree")
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/caic-xyz/md/gitutil"
+)
+
+// Ways to handle nested repositories, for [StartOpts.Nested] and
+// [PushOpts.Nested]. Empty refuses to start or push with a [NestedError].
+const (
+	// NestedInclude copies the nested repositories' files into the
+	// container's checkout, left uncommitted. Their history isn't sent.
+	NestedInclude = "include"
+	// NestedExclude leaves them out: the container only has what the
+	// branch's commits record.
+	NestedExclude = "exclude"
+)
+
+// NestedError is returned when a repository has nested repositories whose
+// content git doesn't carry and no way to handle them was given.
+type NestedError struct {
+	Repo   string
+	Nested []gitutil.NestedRepo
+}
+
+func (e *NestedError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s has content inside nested repositories that git doesn't carry:\n", e.Repo)
+	for i := range e.Nested {
+		fmt.Fprintf(&b, "  - %s\n", &e.Nested[i])
+	}
+	b.WriteString("The container gets the branch's commits, submodules at the commit they record and their history, but not the content above.\n")
+	b.WriteString("Use --nested include to copy these files into the container's checkout, left uncommitted and without their history, or --nested exclude to go ahead without them")
+	return b.String()
+}
+
+// checkNested returns the nested repositories of r to copy into the
+// container given mode, one of the Nested constants. Only the branch checked
+// out on the host is checked: another branch's commits are all there is.
+func checkNested(ctx context.Context, stdout io.Writer, r *Repo, mode string, quiet bool) ([]gitutil.NestedRepo, error) {
+	if mode != "" && mode != NestedInclude && mode != NestedExclude {
+		return nil, fmt.Errorf("invalid nested repositories mode %q: use %q or %q", mode, NestedInclude, NestedExclude)
+	}
+	if gitutil.HgSource(ctx, r.GitRoot) != "" {
+		return nil, nil
+	}
+	if cur, _ := r.vcs().CurrentBranch(ctx, r.GitRoot); cur != r.Branch {
+		return nil, nil
+	}
+	nested, err := gitutil.FindNestedRepos(ctx, r.GitRoot)
+	if err != nil || len(nested) == 0 {
+		return nil, err
+	}
+	switch mode {
+	case "":
+		return nil, &NestedError{Repo: r.Name(), Nested: nested}
+	case NestedExclude:
+		if !quiet {
+			for i := range nested {
+				_, _ = fmt.Fprintf(stdout, "- Not syncing %s\n", &nested[i])
+			}
+		}
+		return nil, nil
+	}
+	return nested, nil
+}
+
+// sendNested copies the files of nested into r's checkout in the container.
+func (c *Container) sendNested(ctx context.Context, stdout, stderr io.Writer, r *Repo, nested []gitutil.NestedRepo, quiet bool) error {
+	for i := range nested {
+		send, remove, err := gitutil.NestedFiles(ctx, r.GitRoot, &nested[i])
+		if err != nil {
+			return fmt.Errorf("listing the files of %s: %w", nested[i].Path, err)
+		}
+		if len(send) == 0 && len(remove) == 0 {
+			continue
+		}
+		if err := c.sendFiles(ctx, stderr, r, send, remove); err != nil {
+			return err
+		}
+		if !quiet {
+			_, _ = fmt.Fprintf(stdout, "- Copied %s: %d file(s), removed %d\n", nested[i].Path, len(send), len(remove))
+		}
+	}
+	return nil
+}
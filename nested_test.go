@@ -0,0 +1,51 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckNested(t *testing.T) {
+	ctx := t.Context()
+	dir, git := newPullRepo(t, false)
+	r := &Repo{GitRoot: dir, Branch: "main"}
+	if nested, err := checkNested(ctx, io.Discard, r, "", true); err != nil || nested != nil {
+		t.Fatalf("clean: got %+v, %v", nested, err)
+	}
+	git("init", "-q", "vendor/dep")
+	if err := os.WriteFile(filepath.Join(dir, "vendor", "dep", "dep.go"), []byte("package dep\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := checkNested(ctx, io.Discard, r, "", true)
+	var e *NestedError
+	if !errors.As(err, &e) || len(e.Nested) != 1 || e.Nested[0].Path != "vendor/dep" {
+		t.Fatalf("got %v", err)
+	}
+	if msg := err.Error(); !strings.Contains(msg, "vendor/dep (untracked repository)") || !strings.Contains(msg, "--nested include") {
+		t.Errorf("got %q", msg)
+	}
+	var out strings.Builder
+	if nested, err := checkNested(ctx, &out, r, NestedExclude, false); err != nil || nested != nil || !strings.Contains(out.String(), "- Not syncing vendor/dep") {
+		t.Errorf("exclude: got %+v, %v, %q", nested, err, out.String())
+	}
+	if nested, err := checkNested(ctx, io.Discard, r, NestedInclude, true); err != nil || len(nested) != 1 {
+		t.Errorf("include: got %+v, %v", nested, err)
+	}
+	if _, err := checkNested(ctx, io.Discard, r, "all", true); err == nil {
+		t.Error("expected an error for an invalid mode")
+	}
+	// Another branch than the one checked out is pushed from its commits.
+	r.Branch = "ctr"
+	if nested, err := checkNested(ctx, io.Discard, r, "", true); err != nil || nested != nil {
+		t.Errorf("other branch: got %+v, %v", nested, err)
+	}
+}
@@ -0,0 +1,234 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// Network modes of [StartOpts.NetworkMode]. Any other value is the name of
+// an existing network to join, which md neither creates nor removes.
+const (
+	// NetworkPrivate, the default, is a network md creates for the container
+	// and its sidecars and removes with them. The container resolves the
+	// sidecars by name and no other container reaches it.
+	NetworkPrivate = ""
+	// NetworkBridge is the engine's default network, shared by all the
+	// containers not on another one.
+	NetworkBridge = "bridge"
+	// NetworkNone leaves the container without network. md reaches its sshd
+	// through the engine's exec.
+	NetworkNone = "none"
+	// NetworkHost shares the host's network. Nothing is published: sshd
+	// listens on a free port of 127.0.0.1 md picks.
+	NetworkHost = "host"
+)
+
+// privateNetwork returns the network md creates for the container name.
+func privateNetwork(name string) string {
+	return name + "-net"
+}
+
+// checkNetworkMode returns an error when opts asks for what the network mode
+// can't do: without network or on the host's, no port is published.
+func checkNetworkMode(c *Container, opts *StartOpts) error {
+	mode := opts.NetworkMode
+	var unsupported []string
+	if mode == NetworkNone || mode == NetworkHost {
+		for _, f := range []struct {
+			set  bool
+			name string
+		}{
+			{opts.Display, "display"},
+			{opts.RDP, "RDP"},
+			{opts.Browser, "browser"},
+			{opts.Tailscale, "Tailscale"},
+			{len(opts.PublishPorts) > 0, "published ports"},
+			{opts.Bind != "", "bind address"},
+		} {
+			if f.set {
+				unsupported = append(unsupported, f.name)
+			}
+		}
+	}
+	if c.composeFile != "" && (mode == NetworkBridge || mode == NetworkNone || mode == NetworkHost) {
+		unsupported = append(unsupported, "the sidecars of "+ComposeFile+" (skip them with --no-sidecars)")
+	}
+	if len(unsupported) > 0 {
+		return fmt.Errorf("not supported with network %s: %s", mode, strings.Join(unsupported, ", "))
+	}
+	return nil
+}
+
+// createNetwork creates the container's private network.
+func (c *Container) createNetwork(ctx context.Context) error {
+	if _, err := runCmd(ctx, "", []string{c.Runtime, "network", "create", privateNetwork(c.Name)}); err != nil {
+		return cmdErrWithStderr("creating network "+privateNetwork(c.Name), err)
+	}
+	return nil
+}
+
+// removeNetwork removes the container's private network, if it has one.
+func (c *Container) removeNetwork(ctx context.Context) error {
+	if c.Network != privateNetwork(c.Name) {
+		return nil
+	}
+	if _, err := runCmd(ctx, "", []string{c.Runtime, "network", "rm", c.Network}); err != nil {
+		return cmdErrWithStderr("removing network "+c.Network, err)
+	}
+	return nil
+}
+
+// execProxyCommand returns the ssh ProxyCommand reaching the container's sshd
+// through the engine's exec, for a container without network.
+func (c *Container) execProxyCommand() string {
+	args := []string{c.Runtime, "exec", "-i", c.Name, "socat", "STDIO", "TCP:127.0.0.1:22"}
+	for i, a := range args {
+		args[i] = shellQuote(a)
+	}
+	return strings.Join(args, " ")
+}
+
+// freeLocalPort returns a TCP port of 127.0.0.1 nothing listens on.
+func freeLocalPort() (int32, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	if err := l.Close(); err != nil {
+		return 0, err
+	}
+	return int32(port), nil
+}
+
+// NetworkPolicy restricts the container's outbound connections. The zero
+// value allows none: the container is offline. Its sidecars, DNS and the
+// replies to inbound connections, like ssh's, are always allowed.
+type NetworkPolicy struct {
+	// AllowHosts are the host names, IP addresses and CIDR ranges the
+	// container may connect to. Names are resolved when the policy is
+	// applied, at start and resume.
+	AllowHosts []string
+}
+
+// egressLabel returns the md.egress label value of p.
+func egressLabel(p *NetworkPolicy) string {
+	if len(p.AllowHosts) == 0 {
+		return "offline"
+	}
+	return strings.Join(p.AllowHosts, " ")
+}
+
+// parseEgressLabel parses the md.egress label.
+func parseEgressLabel(v string) *NetworkPolicy {
+	if v == "offline" {
+		return &NetworkPolicy{}
+	}
+	return &NetworkPolicy{AllowHosts: strings.Fields(v)}
+}
+
+var reAllowHost = regexp.MustCompile(`^[A-Za-z0-9:][A-Za-z0-9.:/-]*$`)
+
+// checkNetworkPolicy returns an error when opts.NetworkPolicy can't apply.
+func checkNetworkPolicy(opts *StartOpts) error {
+	if opts.NetworkPolicy == nil {
+		return nil
+	}
+	switch opts.NetworkMode {
+	case NetworkNone:
+		return errors.New("network none is already offline")
+	case NetworkHost:
+		return errors.New("egress restrictions would apply to the host's network: use another network mode")
+	}
+	if opts.Tailscale {
+		return errors.New("egress restrictions block Tailscale")
+	}
+	for _, h := range opts.NetworkPolicy.AllowHosts {
+		if !reAllowHost.MatchString(h) {
+			return fmt.Errorf("invalid allowed host %q: want a host name, an IP address or a CIDR range", h)
+		}
+	}
+	return nil
+}
+
+// egressScript returns the shell script installing p as iptables rules,
+// letting through the connections to hostPorts. It flushes the OUTPUT chains
+// first so it can be applied again.
+func egressScript(p *NetworkPolicy, hostPorts []HostPort) string {
+	var b strings.Builder
+	b.WriteString(`set -e
+for ipt in iptables ip6tables; do
+	$ipt -F OUTPUT
+	$ipt -A OUTPUT -o lo -j ACCEPT
+	$ipt -A OUTPUT -m conntrack --ctstate ESTABLISHED,RELATED -j ACCEPT
+done
+for ns in $(awk '$1 == "nameserver" {print $2}' /etc/resolv.conf); do
+	ipt=iptables
+	case $ns in *:*) ipt=ip6tables ;; esac
+	$ipt -A OUTPUT -d "$ns" -p udp --dport 53 -j ACCEPT
+	$ipt -A OUTPUT -d "$ns" -p tcp --dport 53 -j ACCEPT
+done
+gw=$(ip -4 route show default | awk '{print $3; exit}')
+`)
+	if len(hostPorts) > 0 {
+		// The helper shares the container's /etc/hosts, which maps HostGateway.
+		fmt.Fprintf(&b, "hips=$(getent ahostsv4 %s | awk '{print $1}' | sort -u)\n", HostGateway)
+		b.WriteString("for ip in ${hips:-$gw}; do\n")
+		for _, hp := range hostPorts {
+			fmt.Fprintf(&b, "\tiptables -A OUTPUT -d \"$ip\" -p tcp --dport %d -j ACCEPT\n", hp.Port)
+		}
+		b.WriteString("done\n")
+	}
+	b.WriteString(`# The sidecars are on the container's subnets, so is the host's gateway.
+if [ -n "$gw" ]; then iptables -A OUTPUT -d "$gw" -j REJECT; fi
+for net in $(ip -4 route show scope link | awk '{print $1}'); do
+	iptables -A OUTPUT -d "$net" -j ACCEPT
+done
+allow() {
+	case $1 in
+	*:*) ip6tables -A OUTPUT -d "$1" -j ACCEPT ;;
+	*) iptables -A OUTPUT -d "$1" -j ACCEPT ;;
+	esac
+}
+`)
+	for _, h := range p.AllowHosts {
+		if _, _, err := net.ParseCIDR(h); err == nil || net.ParseIP(h) != nil {
+			fmt.Fprintf(&b, "allow %s\n", h)
+			continue
+		}
+		fmt.Fprintf(&b, "ips=$(getent ahosts %[1]s | awk '{print $1}' | sort -u)\n"+
+			"[ -n \"$ips\" ] || { echo 'cannot resolve %[1]s' >&2; exit 1; }\n"+
+			"for ip in $ips; do allow \"$ip\"; done\n", h)
+	}
+	b.WriteString(`for ipt in iptables ip6tables; do
+	$ipt -A OUTPUT -j REJECT
+done
+`)
+	return b.String()
+}
+
+// applyNetworkPolicy installs the container's egress rules from a helper
+// container sharing its network namespace: the container itself has no
+// NET_ADMIN capability to lift them, even as root. image must have iptables;
+// md's images do.
+func (c *Container) applyNetworkPolicy(ctx context.Context, image string) error {
+	if c.NetworkPolicy == nil {
+		return nil
+	}
+	args := []string{
+		c.Runtime, "run", "--rm", "--network", "container:" + c.Name, "--cap-add", "NET_ADMIN",
+		"--user", "root", "--entrypoint", "/bin/sh", image, "-c", egressScript(c.NetworkPolicy, c.HostPorts),
+	}
+	if _, err := runCmd(ctx, "", args); err != nil {
+		return cmdErrWithStderr("applying the egress restrictions of "+c.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"net"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCheckNetworkMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    StartOpts
+		compose bool
+		wantErr string
+	}{
+		{"private", StartOpts{Display: true, PublishPorts: []PortMapping{{Container: 8080}}}, true, ""},
+		{"custom", StartOpts{NetworkMode: "dev", Display: true}, true, ""},
+		{"bridge", StartOpts{NetworkMode: NetworkBridge, Browser: true}, false, ""},
+		{"bridge sidecars", StartOpts{NetworkMode: NetworkBridge}, true, "the sidecars of .md/compose.yaml"},
+		{"none", StartOpts{NetworkMode: NetworkNone, USB: true}, false, ""},
+		{"none display", StartOpts{NetworkMode: NetworkNone, Display: true, Tailscale: true}, false, "network none: display, Tailscale"},
+		{"host ports", StartOpts{NetworkMode: NetworkHost, PublishPorts: []PortMapping{{Container: 8080}}, Bind: "0.0.0.0"}, false, "network host: published ports, bind address"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Container{Name: "md-r-main"}
+			if tt.compose {
+				c.composeFile = "/r/.md/compose.yaml"
+			}
+			err := checkNetworkMode(c, &tt.opts)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNetworkSSHEndpoint(t *testing.T) {
+	c := &Container{Client: &Client{Runtime: "docker"}, Name: "md-r-main", Network: NetworkNone}
+	want := "docker exec -i md-r-main socat STDIO TCP:127.0.0.1:22"
+	if ep := c.sshEndpoint(); ep.ProxyCommand != want {
+		t.Errorf("got %+v", ep)
+	}
+	c.Network = NetworkHost
+	c.SSHPort = 40022
+	if ep := c.sshEndpoint(); ep.ProxyCommand != "" || ep.Port != 40022 {
+		t.Errorf("got %+v", ep)
+	}
+}
+
+func TestFreeLocalPort(t *testing.T) {
+	port, err := freeLocalPort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = l.Close()
+}
+
+func TestEgressLabel(t *testing.T) {
+	for _, p := range []*NetworkPolicy{{}, {AllowHosts: []string{"github.com", "10.0.0.0/8"}}} {
+		got := parseEgressLabel(egressLabel(p))
+		if !slices.Equal(got.AllowHosts, p.AllowHosts) {
+			t.Errorf("got %+v, want %+v", got, p)
+		}
+	}
+}
+
+func TestCheckNetworkPolicy(t *testing.T) {
+	offline := &NetworkPolicy{}
+	tests := []struct {
+		name    string
+		opts    StartOpts
+		wantErr bool
+	}{
+		{"none", StartOpts{}, false},
+		{"offline", StartOpts{NetworkPolicy: offline}, false},
+		{"allow", StartOpts{NetworkPolicy: &NetworkPolicy{AllowHosts: []string{"github.com", "::1", "10.0.0.0/8"}}}, false},
+		{"invalid host", StartOpts{NetworkPolicy: &NetworkPolicy{AllowHosts: []string{"x;rm -rf /"}}}, true},
+		{"network none", StartOpts{NetworkMode: NetworkNone, NetworkPolicy: offline}, true},
+		{"network host", StartOpts{NetworkMode: NetworkHost, NetworkPolicy: offline}, true},
+		{"tailscale", StartOpts{Tailscale: true, NetworkPolicy: offline}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkNetworkPolicy(&tt.opts); (err != nil) != tt.wantErr {
+				t.Fatalf("got %v", err)
+			}
+		})
+	}
+}
+
+func TestEgressScript(t *testing.T) {
+	s := egressScript(&NetworkPolicy{AllowHosts: []string{"github.com", "10.0.0.0/8", "2001:db8::1"}}, nil)
+	for _, want := range []string{"getent ahosts github.com", "allow 10.0.0.0/8\n", "allow 2001:db8::1\n"} {
+		if !strings.Contains(s, want) {
+			t.Errorf("lacks %q:\n%s", want, s)
+		}
+	}
+	if !strings.HasSuffix(s, "\t$ipt -A OUTPUT -j REJECT\ndone\n") {
+		t.Errorf("doesn't end rejecting the rest:\n%s", s)
+	}
+	if runtime.GOOS == "windows" {
+		return
+	}
+	if out, err := exec.CommandContext(t.Context(), "sh", "-n", "-c", s).CombinedOutput(); err != nil {
+		t.Errorf("%v: %s", err, out)
+	}
+}
+
+func TestEgressScriptHostPorts(t *testing.T) {
+	s := egressScript(&NetworkPolicy{}, []HostPort{{Port: 11434}})
+	allow := strings.Index(s, "--dport 11434 -j ACCEPT")
+	reject := strings.Index(s, `-d "$gw" -j REJECT`)
+	if allow < 0 || reject < 0 || allow > reject {
+		t.Errorf("doesn't allow the host port before rejecting the gateway:\n%s", s)
+	}
+	if runtime.GOOS == "windows" {
+		return
+	}
+	if out, err := exec.CommandContext(t.Context(), "sh", "-n", "-c", s).CombinedOutput(); err != nil {
+		t.Errorf("%v: %s", err, out)
+	}
+}
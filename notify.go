@@ -0,0 +1,275 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/caic-xyz/md/gitutil"
+	"github.com/maruel/genai"
+)
+
+// Event types sent by [Notifier].
+const (
+	// EventStart is sent once md start has started a container.
+	EventStart = "start"
+	// EventKill is sent once md kill (or md purge) has removed a container.
+	EventKill = "kill"
+	// EventPull is sent once md pull has pulled a repository's changes.
+	EventPull = "pull"
+	// EventAgentFinished is sent when a command run with md run or md exec,
+	// typically an agent, exits.
+	EventAgentFinished = "agent_finished"
+	// EventIdle is sent when md gc stopped or removed an idle container.
+	EventIdle = "idle"
+	// EventDisk is sent when md gc found a container over a disk usage
+	// threshold it wasn't over at the previous check.
+	EventDisk = "disk"
+)
+
+// EventTypes lists the valid event types.
+var EventTypes = []string{EventStart, EventKill, EventPull, EventAgentFinished, EventIdle, EventDisk}
+
+// Event is the JSON payload describing a container lifecycle event.
+type Event struct {
+	// Event is one of [EventTypes].
+	Event     string    `json:"event"`
+	Time      time.Time `json:"time"`
+	Container string    `json:"container"`
+	// Repo and Branch identify the repository the event is about, the
+	// primary one unless stated otherwise.
+	Repo   string `json:"repo,omitempty"`
+	Branch string `json:"branch,omitempty"`
+	// Command and ExitCode are set for EventAgentFinished.
+	Command  []string `json:"command,omitempty"`
+	ExitCode *int     `json:"exit_code,omitempty"`
+	// Action ("stop" or "remove") and Idle are set for EventIdle.
+	Action string `json:"action,omitempty"`
+	Idle   string `json:"idle,omitempty"`
+	// DiskWarnings is set for EventDisk.
+	DiskWarnings []DiskWarning `json:"disk_warnings,omitempty"`
+	// Diffstat and Summary describe the repository's changes since the
+	// container started, when known. See [Container.Summarize].
+	Diffstat string `json:"diffstat,omitempty"`
+	Summary  string `json:"summary,omitempty"`
+	// Error is set when the operation failed.
+	Error string `json:"error,omitempty"`
+}
+
+// NewEvent returns an event of type typ about ct's primary repository.
+func NewEvent(typ string, ct *Container) *Event {
+	ev := &Event{Event: typ, Time: time.Now().UTC().Truncate(time.Second), Container: ct.Name}
+	if len(ct.Repos) > 0 {
+		ev.Repo = ct.Repos[0].Name()
+		ev.Branch = ct.Repos[0].Branch
+	}
+	return ev
+}
+
+// Notifier delivers events to webhooks, commands and chat rooms.
+type Notifier struct {
+	// Webhooks are URLs each event is POSTed to as JSON.
+	Webhooks []string
+	// Commands are shell command lines run with the JSON event on stdin and
+	// $MD_EVENT set to its type.
+	Commands []string
+	// Slack are Slack incoming webhook URLs a readable message is posted to.
+	Slack []string
+	// Matrix, when set, is a room a readable message is posted to.
+	Matrix *MatrixRoom
+	// Events limits delivery to these event types. Empty delivers all.
+	Events []string
+	// Client is used for webhooks. Defaults to a client with a 10s timeout.
+	Client *http.Client
+}
+
+// MatrixRoom identifies a Matrix room to post to.
+type MatrixRoom struct {
+	// Homeserver is the client API base URL, e.g. "https://matrix.org".
+	Homeserver string
+	// Room is the room ID, e.g. "!abc123:matrix.org".
+	Room string
+	// Token is the access token of the account posting.
+	Token string
+}
+
+// Wants reports whether an event of type typ would be delivered anywhere, so
+// callers can skip preparing it.
+func (n *Notifier) Wants(typ string) bool {
+	if len(n.Events) > 0 && !slices.Contains(n.Events, typ) {
+		return false
+	}
+	return len(n.Webhooks) > 0 || len(n.Commands) > 0 || len(n.Slack) > 0 || n.Matrix != nil
+}
+
+// Notify delivers ev to every webhook, command and chat room. It tries all
+// of them and returns their errors joined.
+func (n *Notifier) Notify(ctx context.Context, ev *Event) error {
+	if !n.Wants(ev.Event) {
+		return nil
+	}
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	client := n.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	var errs []error
+	for _, u := range n.Webhooks {
+		if err := postEvent(ctx, client, u, payload); err != nil {
+			errs = append(errs, fmt.Errorf("webhook %s: %w", u, err))
+		}
+	}
+	for _, c := range n.Commands {
+		args := []string{"sh", "-c", c}
+		if runtime.GOOS == "windows" {
+			args = []string{"cmd", "/C", c}
+		}
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Stdin = bytes.NewReader(payload)
+		cmd.Env = append(os.Environ(), "MD_EVENT="+ev.Event)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			errs = append(errs, fmt.Errorf("command %q: %w\n%s", c, err, out))
+		}
+	}
+	if len(n.Slack) > 0 || n.Matrix != nil {
+		text := eventText(ev)
+		for _, u := range n.Slack {
+			b, _ := json.Marshal(map[string]string{"text": text})
+			if err := postEvent(ctx, client, u, b); err != nil {
+				errs = append(errs, fmt.Errorf("slack: %w", err))
+			}
+		}
+		if m := n.Matrix; m != nil {
+			// The transaction ID makes retries idempotent; the event time is
+			// unique enough per container.
+			txn := fmt.Sprintf("md-%s-%s-%d", ev.Container, ev.Event, ev.Time.UnixNano())
+			u := strings.TrimSuffix(m.Homeserver, "/") + "/_matrix/client/v3/rooms/" + url.PathEscape(m.Room) + "/send/m.room.message/" + url.PathEscape(txn)
+			b, _ := json.Marshal(map[string]string{"msgtype": "m.text", "body": text})
+			if err := sendJSON(ctx, client, http.MethodPut, u, m.Token, b); err != nil {
+				errs = append(errs, fmt.Errorf("matrix: %w", err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// eventText formats ev as a chat message.
+func eventText(ev *Event) string {
+	where := ev.Container
+	if ev.Repo != "" {
+		where += " (" + ev.Repo
+		if ev.Branch != "" {
+			where += " @ " + ev.Branch
+		}
+		where += ")"
+	}
+	var text string
+	switch ev.Event {
+	case EventAgentFinished:
+		cmd := strings.Join(ev.Command, " ")
+		switch {
+		case ev.Error != "":
+			text = fmt.Sprintf("`%s` failed in %s: %s", cmd, where, ev.Error)
+		case ev.ExitCode != nil && *ev.ExitCode != 0:
+			text = fmt.Sprintf("`%s` exited with code %d in %s", cmd, *ev.ExitCode, where)
+		default:
+			text = fmt.Sprintf("`%s` finished in %s", cmd, where)
+		}
+	case EventIdle:
+		verb := "Stopped"
+		if ev.Action == "remove" {
+			verb = "Removed"
+		}
+		text = fmt.Sprintf("%s %s, idle for %s", verb, where, ev.Idle)
+	case EventDisk:
+		text = "Running out of disk in " + where + ":"
+		for _, w := range ev.DiskWarnings {
+			text += "\n- " + w.Message
+		}
+	case EventStart:
+		text = "Started " + where
+	case EventKill:
+		text = "Removed " + where
+	case EventPull:
+		text = "Pulled " + where
+	default:
+		text = ev.Event + ": " + where
+	}
+	if ev.Summary != "" {
+		text += "\n\n" + ev.Summary
+	}
+	if ev.Diffstat != "" {
+		text += "\n\n```\n" + ev.Diffstat + "\n```"
+	}
+	return text
+}
+
+// Summarize fills ev's Diffstat and, when p is not nil, an AI generated
+// Summary of the changes in Repos[repoIdx] since the container started.
+// Uncommitted changes are staged in the container, like [Container.Fetch]
+// does. Errors are logged: a summary is best effort.
+func (c *Container) Summarize(ctx context.Context, ev *Event, repoIdx int, p genai.Provider) {
+	if repoIdx < 0 || repoIdx >= len(c.Repos) {
+		return
+	}
+	repo := c.Repos[repoIdx].Name()
+	if _, err := runCmd(ctx, "", c.SSHCommand(c.Name, "cd ~/src/"+shellQuote(repo)+" && git add -A")); err != nil {
+		slog.WarnContext(ctx, "md", "msg", "staging changes", "err", err)
+		return
+	}
+	stat, _ := runCmd(ctx, "", c.SSHCommand(c.Name, "cd ~/src/"+shellQuote(repo)+" && git diff --stat --cached base -- ."))
+	ev.Diffstat = stat
+	if stat == "" || p == nil {
+		return
+	}
+	msg, err := gitutil.GenerateCommitMsg(ctx, p, c.gatherGitMetadata(ctx, c.Name, repo), c.gatherGitDiff(ctx, c.Name, repo), nil)
+	if err != nil {
+		slog.WarnContext(ctx, "md", "msg", "summarizing changes", "err", err)
+		return
+	}
+	ev.Summary = msg
+}
+
+func postEvent(ctx context.Context, client *http.Client, u string, payload []byte) error {
+	return sendJSON(ctx, client, http.MethodPost, u, "", payload)
+}
+
+// sendJSON sends payload to u, with token as a bearer token when set.
+func sendJSON(ctx context.Context, client *http.Client, method, u, token string, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "md")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestNotifier(t *testing.T) {
+	var got []Event
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("got %s %s", r.Method, r.Header.Get("Content-Type"))
+		}
+		var ev Event
+		if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+			t.Error(err)
+		}
+		got = append(got, ev)
+		if ev.Event == EventKill {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+	ct := &Container{Name: "md-repo-main", Repos: []Repo{{GitRoot: "/src/repo", Branch: "main"}}}
+	n := &Notifier{Webhooks: []string{srv.URL}, Events: []string{EventStart, EventKill}}
+	t.Run("sent", func(t *testing.T) {
+		got = nil
+		if err := n.Notify(t.Context(), NewEvent(EventStart, ct)); err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 || got[0].Event != EventStart || got[0].Container != "md-repo-main" || got[0].Repo != "repo" || got[0].Branch != "main" {
+			t.Errorf("got %+v", got)
+		}
+	})
+	t.Run("filtered", func(t *testing.T) {
+		got = nil
+		if err := n.Notify(t.Context(), NewEvent(EventPull, ct)); err != nil || len(got) != 0 {
+			t.Errorf("got %+v, %v", got, err)
+		}
+	})
+	t.Run("error", func(t *testing.T) {
+		if err := n.Notify(t.Context(), NewEvent(EventKill, ct)); err == nil {
+			t.Error("expected error")
+		}
+	})
+	t.Run("command", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("uses sh")
+		}
+		out := filepath.Join(t.TempDir(), "event")
+		n := &Notifier{Commands: []string{`{ echo "$MD_EVENT"; cat; } > ` + out}}
+		code := 3
+		ev := NewEvent(EventAgentFinished, ct)
+		ev.Command, ev.ExitCode = []string{"claude"}, &code
+		if err := n.Notify(t.Context(), ev); err != nil {
+			t.Fatal(err)
+		}
+		b, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := string(b); !strings.HasPrefix(s, "agent_finished\n{") || !strings.Contains(s, `"exit_code":3`) {
+			t.Errorf("got %q", s)
+		}
+	})
+}
+
+func TestNotifierChat(t *testing.T) {
+	type req struct {
+		method, path, auth string
+		body               map[string]string
+	}
+	var got []req
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		got = append(got, req{r.Method, r.URL.EscapedPath(), r.Header.Get("Authorization"), body})
+	}))
+	defer srv.Close()
+	n := &Notifier{
+		Slack:  []string{srv.URL + "/slack"},
+		Matrix: &MatrixRoom{Homeserver: srv.URL + "/", Room: "!room:example.org", Token: "tok"},
+	}
+	ev := &Event{Event: EventIdle, Container: "md-repo-main", Action: "stop", Idle: "48h0m0s"}
+	if err := n.Notify(t.Context(), ev); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %+v", got)
+	}
+	if got[0].method != http.MethodPost || got[0].path != "/slack" || got[0].body["text"] != "Stopped md-repo-main, idle for 48h0m0s" {
+		t.Errorf("slack: %+v", got[0])
+	}
+	if got[1].method != http.MethodPut || !strings.HasPrefix(got[1].path, "/_matrix/client/v3/rooms/%21room:example.org/send/m.room.message/md-") || got[1].auth != "Bearer tok" || got[1].body["msgtype"] != "m.text" {
+		t.Errorf("matrix: %+v", got[1])
+	}
+}
+
+func TestEventText(t *testing.T) {
+	code := 1
+	ev := &Event{
+		Event:     EventAgentFinished,
+		Container: "md-repo-main",
+		Repo:      "repo",
+		Branch:    "main",
+		Command:   []string{"claude", "-p", "fix"},
+		ExitCode:  &code,
+		Diffstat:  " a.go | 2 +-",
+		Summary:   "Fix the parser",
+	}
+	want := "`claude -p fix` exited with code 1 in md-repo-main (repo @ main)\n\nFix the parser\n\n```\n a.go | 2 +-\n```"
+	if got := eventText(ev); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestEventTextDisk(t *testing.T) {
+	ev := &Event{
+		Event:        EventDisk,
+		Container:    "md-repo-main",
+		DiskWarnings: []DiskWarning{{"space", "disk 95% full, 1.0 GB left"}, {"src", "~/src is 25.0 GB, over 20.0 GB"}},
+	}
+	want := "Running out of disk in md-repo-main:\n- disk 95% full, 1.0 GB left\n- ~/src is 25.0 GB, over 20.0 GB"
+	if got := eventText(ev); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// tailscaleAPIURL is probed before generating a Tailscale auth key.
+const tailscaleAPIURL = "https://api.tailscale.com/"
+
+// reachProbeTTL is how long the result of probing a server is remembered.
+const reachProbeTTL = time.Minute
+
+// reachEntry is a cached probe result.
+type reachEntry struct {
+	ok      bool
+	expires time.Time
+}
+
+// registryURL returns the URL of the registry serving image.
+func registryURL(image string) string {
+	host, _, ok := strings.Cut(image, "/")
+	if !ok || (!strings.ContainsAny(host, ".:") && host != "localhost") {
+		host = "registry-1.docker.io"
+	}
+	return "https://" + host + "/v2/"
+}
+
+// registryOffline reports whether image's registry must not be used: the
+// client is [Client.Offline] or the registry doesn't answer. It is false for
+// local images, which never involve a registry.
+func (c *Client) registryOffline(ctx context.Context, image string) bool {
+	if !strings.Contains(image, "/") {
+		return false
+	}
+	return !c.reachable(ctx, registryURL(image))
+}
+
+// reachable reports whether the server at url answers, with any status,
+// within a few seconds. It is false when the client is [Client.Offline].
+// Results are cached for reachProbeTTL so a command probes a server once.
+func (c *Client) reachable(ctx context.Context, url string) bool {
+	if c.Offline {
+		return false
+	}
+	c.mu.Lock()
+	if e, ok := c.reachCache[url]; ok && time.Now().Before(e.expires) {
+		c.mu.Unlock()
+		return e.ok
+	}
+	c.mu.Unlock()
+	ok := false
+	if req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, http.NoBody); err == nil {
+		resp, err := c.httpClient(3 * time.Second).Do(req)
+		if err == nil {
+			_ = resp.Body.Close()
+			ok = true
+		} else {
+			slog.InfoContext(ctx, "md", "msg", "offline", "url", url, "err", err)
+		}
+	}
+	c.mu.Lock()
+	if c.reachCache == nil {
+		c.reachCache = map[string]reachEntry{}
+	}
+	c.reachCache[url] = reachEntry{ok: ok, expires: time.Now().Add(reachProbeTTL)}
+	c.mu.Unlock()
+	return ok
+}
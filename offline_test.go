@@ -0,0 +1,50 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRegistryURL(t *testing.T) {
+	for image, want := range map[string]string{
+		"ghcr.io/caic-xyz/md-root:latest": "https://ghcr.io/v2/",
+		"localhost:5000/img":              "https://localhost:5000/v2/",
+		"localhost/img":                   "https://localhost/v2/",
+		"library/ubuntu":                  "https://registry-1.docker.io/v2/",
+		"ubuntu":                          "https://registry-1.docker.io/v2/",
+	} {
+		if got := registryURL(image); got != want {
+			t.Errorf("%s: got %q, want %q", image, got, want)
+		}
+	}
+}
+
+func TestReachable(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	url := srv.URL + "/v2/"
+	ctx := t.Context()
+	c := &Client{}
+	if !c.reachable(ctx, url) || !c.reachable(ctx, url) {
+		t.Error("expected reachable")
+	}
+	if n := hits.Load(); n != 1 {
+		t.Errorf("probed %d times, want 1", n)
+	}
+	srv.Close()
+	if c2 := (&Client{}); c2.reachable(ctx, url) {
+		t.Error("closed server: expected unreachable")
+	}
+	if c3 := (&Client{Offline: true}); c3.reachable(ctx, url) || !c3.registryOffline(ctx, "ghcr.io/x/y") || c3.registryOffline(ctx, "md-local") {
+		t.Error("Offline: expected unreachable registries, but for local images")
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// PortMapping makes a container TCP port reachable on the host's loopback
+// interface.
+type PortMapping struct {
+	// Host is the port on 127.0.0.1.
+	Host uint16 `json:"host"`
+	// Container is the port the service listens on inside the container.
+	Container uint16 `json:"container"`
+}
+
+// ParsePortMapping parses "port" or "host:container".
+func ParsePortMapping(spec string) (PortMapping, error) {
+	h, c, ok := strings.Cut(spec, ":")
+	if !ok {
+		c = h
+	}
+	host, err1 := strconv.ParseUint(h, 10, 16)
+	ctr, err2 := strconv.ParseUint(c, 10, 16)
+	if err1 != nil || err2 != nil || host == 0 || ctr == 0 {
+		return PortMapping{}, fmt.Errorf("invalid port %q: use port or host:container, between 1 and 65535", spec)
+	}
+	return PortMapping{Host: uint16(host), Container: uint16(ctr)}, nil
+}
+
+// String returns the mapping in the form accepted by [ParsePortMapping].
+func (m PortMapping) String() string {
+	if m.Host == m.Container {
+		return strconv.Itoa(int(m.Host))
+	}
+	return fmt.Sprintf("%d:%d", m.Host, m.Container)
+}
+
+// portsLabel encodes mappings for the md.ports label.
+func portsLabel(ports []PortMapping) string {
+	s := make([]string, len(ports))
+	for i, p := range ports {
+		s[i] = p.String()
+	}
+	return strings.Join(s, " ")
+}
+
+// parsePortsLabel decodes the md.ports label, skipping invalid entries.
+func parsePortsLabel(v string) []PortMapping {
+	var ports []PortMapping
+	for f := range strings.FieldsSeq(v) {
+		if p, err := ParsePortMapping(f); err == nil {
+			ports = append(ports, p)
+		}
+	}
+	return ports
+}
+
+// checkPublishPorts rejects duplicate host ports.
+func checkPublishPorts(ports []PortMapping) error {
+	seen := make(map[uint16]struct{}, len(ports))
+	for _, p := range ports {
+		if _, ok := seen[p.Host]; ok {
+			return fmt.Errorf("host port %d is published twice", p.Host)
+		}
+		seen[p.Host] = struct{}{}
+	}
+	return nil
+}
+
+// Forwards returns the SSH port forwards to the container established by
+// [Container.AddForward] that are still active, sorted by host port.
+func (c *Container) Forwards(ctx context.Context) ([]PortMapping, error) {
+	matches, err := filepath.Glob(filepath.Join(os.TempDir(), "md-"+c.Name+".fwd.*.sock"))
+	if err != nil {
+		return nil, err
+	}
+	var out []PortMapping
+	for _, sock := range matches {
+		m, ok := parseForwardSocket(c.Name, sock)
+		if !ok {
+			continue
+		}
+		if exec.CommandContext(ctx, "ssh", "-S", sock, "-O", "check", c.Name).Run() != nil {
+			// The master died, e.g. the container was stopped.
+			_ = os.Remove(sock)
+			continue
+		}
+		out = append(out, m)
+	}
+	slices.SortFunc(out, func(a, b PortMapping) int { return int(a.Host) - int(b.Host) })
+	return out, nil
+}
+
+// AddForward forwards 127.0.0.1:m.Host to port m.Container in the running
+// container through a background SSH connection, for services started after
+// the container. It doesn't require restarting the container, unlike
+// [StartOpts.PublishPorts]. The forward lasts until [Container.RemoveForward]
+// or the container stops.
+func (c *Container) AddForward(ctx context.Context, m PortMapping) error {
+	if runtime.GOOS == "windows" {
+		return errors.New("port forwarding requires SSH connection sharing, which is unavailable on Windows")
+	}
+	if err := c.checkContainerState(ctx); err != nil {
+		return err
+	}
+	active, err := c.Forwards(ctx)
+	if err != nil {
+		return err
+	}
+	if slices.ContainsFunc(active, func(a PortMapping) bool { return a.Host == m.Host }) {
+		return fmt.Errorf("host port %d is already forwarded", m.Host)
+	}
+	// ssh -f keeps running in the background with the stdio it was given, so
+	// capturing its output through a pipe would never complete. Its errors go
+	// to a log file instead.
+	log, err := os.CreateTemp("", "md-fwd-*.log")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = log.Close()
+		_ = os.Remove(log.Name())
+	}()
+	sock := forwardSocketPath(c.Name, m)
+	args := c.SSHCommand("-f", "-N", "-M", "-S", sock, "-E", log.Name(),
+		"-o", "ControlPersist=no", "-o", "ExitOnForwardFailure=yes",
+		"-L", fmt.Sprintf("127.0.0.1:%d:127.0.0.1:%d", m.Host, m.Container), c.Name)
+	if err := exec.CommandContext(ctx, args[0], args[1:]...).Run(); err != nil {
+		msg, _ := io.ReadAll(log)
+		return fmt.Errorf("forwarding port %s: %w: %s", m, err, strings.TrimSpace(string(msg)))
+	}
+	c.touch()
+	return nil
+}
+
+// RemoveForward stops the SSH port forward on hostPort.
+func (c *Container) RemoveForward(ctx context.Context, hostPort uint16) error {
+	active, err := c.Forwards(ctx)
+	if err != nil {
+		return err
+	}
+	for _, m := range active {
+		if m.Host == hostPort {
+			sock := forwardSocketPath(c.Name, m)
+			_ = exec.CommandContext(ctx, "ssh", "-S", sock, "-O", "exit", c.Name).Run()
+			_ = os.Remove(sock)
+			return nil
+		}
+	}
+	return fmt.Errorf("host port %d is not forwarded to %s", hostPort, c.Name)
+}
+
+// closeForwards stops all the SSH port forwards to the container.
+func (c *Container) closeForwards(ctx context.Context) {
+	active, _ := c.Forwards(ctx)
+	for _, m := range active {
+		_ = c.RemoveForward(ctx, m.Host)
+	}
+}
+
+// forwardSocketPath returns the control socket of the SSH connection holding
+// a forward. The mapping is encoded in the name so Forwards needs no state.
+func forwardSocketPath(containerName string, m PortMapping) string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf("md-%s.fwd.%d-%d.sock", containerName, m.Host, m.Container))
+}
+
+// parseForwardSocket is the reverse of forwardSocketPath.
+func parseForwardSocket(containerName, sock string) (PortMapping, bool) {
+	rest, ok := strings.CutPrefix(filepath.Base(sock), "md-"+containerName+".fwd.")
+	if !ok {
+		return PortMapping{}, false
+	}
+	rest, ok = strings.CutSuffix(rest, ".sock")
+	if !ok {
+		return PortMapping{}, false
+	}
+	m, err := ParsePortMapping(strings.Replace(rest, "-", ":", 1))
+	return m, err == nil
+}
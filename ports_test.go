@@ -0,0 +1,59 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParsePortMapping(t *testing.T) {
+	for spec, want := range map[string]PortMapping{
+		"8080":      {Host: 8080, Container: 8080},
+		"8080:3000": {Host: 8080, Container: 3000},
+		"1:65535":   {Host: 1, Container: 65535},
+	} {
+		t.Run(spec, func(t *testing.T) {
+			got, err := ParsePortMapping(spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+			if got.String() != spec {
+				t.Errorf("String() = %q", got.String())
+			}
+		})
+	}
+	for _, spec := range []string{"", "0", "http", "8080:", ":3000", "70000", "1:2:3", "-1"} {
+		t.Run("invalid_"+spec, func(t *testing.T) {
+			if _, err := ParsePortMapping(spec); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestPortsLabel(t *testing.T) {
+	ports := []PortMapping{{Host: 8080, Container: 8080}, {Host: 9000, Container: 3000}}
+	if got := parsePortsLabel(portsLabel(ports)); !slices.Equal(got, ports) {
+		t.Errorf("got %+v", got)
+	}
+	if err := checkPublishPorts(append(ports, PortMapping{Host: 9000, Container: 1})); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestParseForwardSocket(t *testing.T) {
+	m := PortMapping{Host: 8080, Container: 3000}
+	got, ok := parseForwardSocket("md-repo-main", forwardSocketPath("md-repo-main", m))
+	if !ok || got != m {
+		t.Errorf("got %+v, %t", got, ok)
+	}
+	if _, ok := parseForwardSocket("md-repo", forwardSocketPath("md-repo-main", m)); ok {
+		t.Error("matched another container's socket")
+	}
+}
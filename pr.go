@@ -0,0 +1,107 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/caic-xyz/md/gitutil"
+	"github.com/maruel/genai"
+)
+
+// PROpts configures [Container.OpenPR].
+type PROpts struct {
+	// Provider generates the commit message of uncommitted changes and the
+	// pull request's title and body. Required unless Title is set.
+	Provider genai.Provider
+	// Title and Body replace the generated ones when Title is set.
+	Title string
+	Body  string
+	// Head is the branch pushed to origin, the container's branch by default.
+	Head string
+	// Base is the branch the pull request targets, origin's default branch
+	// by default.
+	Base string
+	// Draft opens a draft pull request.
+	Draft bool
+	// Force overwrites Head on origin when it isn't an ancestor.
+	Force bool
+}
+
+// PRResult is the pull request [Container.OpenPR] opened.
+type PRResult struct {
+	URL   string `json:"url"`
+	Owner string `json:"owner"`
+	Repo  string `json:"repo"`
+	Head  string `json:"head"`
+	Base  string `json:"base"`
+	Title string `json:"title"`
+}
+
+// OpenPR fetches Repos[repoIdx]'s branch from the container, like
+// [Container.Fetch] does, pushes it to origin and opens a GitHub pull request
+// with [Client.GithubToken]. The title and body are generated from the
+// container's changes unless opts.Title is set.
+func (c *Container) OpenPR(ctx context.Context, stdout, stderr io.Writer, repoIdx int, opts *PROpts) (*PRResult, error) {
+	if opts == nil {
+		opts = &PROpts{}
+	}
+	if repoIdx < 0 || repoIdx >= len(c.Repos) {
+		return nil, fmt.Errorf("repo index %d out of range [0, %d)", repoIdx, len(c.Repos))
+	}
+	if c.GithubToken == "" {
+		return nil, errors.New("opening a pull request needs a GitHub token")
+	}
+	if opts.Title == "" && opts.Provider == nil {
+		return nil, errors.New("no AI provider available to describe the pull request: set ASK_PROVIDER or give a title")
+	}
+	r := c.Repos[repoIdx]
+	owner, repo, err := ParseGitHubRepo(gitutil.RemoteOriginURL(ctx, r.GitRoot))
+	if err != nil {
+		return nil, err
+	}
+	res := &PRResult{Owner: owner, Repo: repo, Head: cmp.Or(opts.Head, r.Branch), Base: opts.Base}
+	if res.Base == "" {
+		if res.Base, err = gitutil.DefaultBranch(ctx, r.GitRoot, "origin"); err != nil {
+			return nil, err
+		}
+	}
+	if res.Head == res.Base {
+		return nil, fmt.Errorf("cannot open a pull request from %s onto itself: push to another branch", res.Base)
+	}
+	if err := gitutil.CheckBranchName(res.Head); err != nil {
+		return nil, err
+	}
+	f, err := c.Fetch(ctx, stdout, stderr, repoIdx, &FetchOpts{Provider: opts.Provider})
+	if err != nil {
+		return nil, err
+	}
+	if n, err := gitutil.RunGit(ctx, r.GitRoot, "rev-list", "--count", "refs/remotes/origin/"+res.Base+".."+f.Ref); err == nil && strings.TrimSpace(n) == "0" {
+		return nil, fmt.Errorf("%s has no commits missing from origin/%s", r.Branch, res.Base)
+	}
+	res.Title = opts.Title
+	body := opts.Body
+	if res.Title == "" {
+		metadata := c.gatherGitMetadata(ctx, c.Name, r.Name())
+		diff := c.gatherGitDiff(ctx, c.Name, r.Name())
+		if res.Title, body, err = gitutil.GeneratePRDescription(ctx, opts.Provider, metadata, diff); err != nil {
+			return nil, fmt.Errorf("describing the pull request: %w", err)
+		}
+	}
+	if err := gitutil.PushRef(ctx, r.GitRoot, f.Ref, res.Head, opts.Force); err != nil {
+		return nil, err
+	}
+	_, _ = fmt.Fprintf(stdout, "- Pushed %s to origin/%s\n", r.Branch, res.Head)
+	pr := &NewPullRequest{Title: res.Title, Body: body, Head: res.Head, Base: res.Base, Draft: opts.Draft}
+	if res.URL, err = CreatePullRequest(ctx, c.GithubToken, owner, repo, pr); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
@@ -0,0 +1,136 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ProxyConfig holds the HTTP(S) proxy of a corporate network. md passes it to
+// image builds as build arguments, to the containers' ~/.env and uses it for
+// its own HTTP requests.
+type ProxyConfig struct {
+	// HTTP is the proxy URL of http:// requests, like $HTTP_PROXY.
+	HTTP string `toml:"http"`
+	// HTTPS is the proxy URL of https:// requests, like $HTTPS_PROXY.
+	HTTPS string `toml:"https"`
+	// NoProxy is the comma-separated list of hosts, domains (matching their
+	// subdomains), IP addresses and CIDR ranges reached directly, like
+	// $NO_PROXY. "*" disables the proxy.
+	NoProxy string `toml:"no_proxy"`
+}
+
+// resolveProxy returns cfg with its empty fields taken from the environment:
+// $HTTP_PROXY, $HTTPS_PROXY and $NO_PROXY or their lowercase forms.
+func resolveProxy(cfg ProxyConfig, getenv func(string) string) ProxyConfig {
+	env := func(name string) string {
+		if v := getenv(name); v != "" {
+			return v
+		}
+		return getenv(strings.ToLower(name))
+	}
+	if cfg.HTTP == "" {
+		cfg.HTTP = env("HTTP_PROXY")
+	}
+	if cfg.HTTPS == "" {
+		cfg.HTTPS = env("HTTPS_PROXY")
+	}
+	if cfg.NoProxy == "" {
+		cfg.NoProxy = env("NO_PROXY")
+	}
+	return cfg
+}
+
+// Env returns the proxy environment variables, each in both cases since
+// tools disagree on which one they read.
+func (p *ProxyConfig) Env() []string {
+	var env []string
+	for _, kv := range []struct{ name, value string }{
+		{"HTTP_PROXY", p.HTTP},
+		{"HTTPS_PROXY", p.HTTPS},
+		{"NO_PROXY", p.NoProxy},
+	} {
+		if kv.value != "" {
+			env = append(env, kv.name+"="+kv.value, strings.ToLower(kv.name)+"="+kv.value)
+		}
+	}
+	return env
+}
+
+// buildArgs returns the build arguments passing the proxy to an image
+// build. They are predefined arguments: docker keeps them out of the image's
+// history and its cache key.
+func (p *ProxyConfig) buildArgs() []string {
+	var args []string
+	for _, kv := range p.Env() {
+		args = append(args, "--build-arg", kv)
+	}
+	return args
+}
+
+// proxyURL returns the proxy to use for req, nil to connect directly. Like
+// [http.ProxyFromEnvironment], loopback hosts are always reached directly.
+func (p *ProxyConfig) proxyURL(req *http.Request) (*url.URL, error) {
+	proxy := p.HTTP
+	if req.URL.Scheme == "https" {
+		proxy = p.HTTPS
+	}
+	if proxy == "" || p.bypass(req.URL.Hostname()) {
+		return nil, nil
+	}
+	u, err := url.Parse(proxy)
+	if err != nil || u.Host == "" {
+		// A bare host:port, as often found in $HTTP_PROXY.
+		if u, err = url.Parse("http://" + proxy); err != nil {
+			return nil, err
+		}
+	}
+	return u, nil
+}
+
+// bypass reports whether host is reached directly.
+func (p *ProxyConfig) bypass(host string) bool {
+	host = strings.ToLower(host)
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	if ip != nil && ip.IsLoopback() {
+		return true
+	}
+	for entry := range strings.SplitSeq(p.NoProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case entry == "":
+		case entry == "*":
+			return true
+		case strings.Contains(entry, "/"):
+			if _, n, err := net.ParseCIDR(entry); err == nil && ip != nil && n.Contains(ip) {
+				return true
+			}
+		default:
+			if h, _, err := net.SplitHostPort(entry); err == nil {
+				entry = h
+			}
+			entry = strings.TrimPrefix(entry, "*")
+			if host == strings.TrimPrefix(entry, ".") || strings.HasSuffix(host, "."+strings.TrimPrefix(entry, ".")) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// httpClient returns an HTTP client going through the proxy.
+func (c *Client) httpClient(timeout time.Duration) *http.Client {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	p := c.Proxy
+	t.Proxy = p.proxyURL
+	return &http.Client{Timeout: timeout, Transport: t}
+}
@@ -0,0 +1,75 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestResolveProxy(t *testing.T) {
+	env := map[string]string{
+		"https_proxy": "http://lower:3128",
+		"HTTPS_PROXY": "http://upper:3128",
+		"http_proxy":  "http://env:3128",
+		"no_proxy":    ".corp",
+	}
+	got := resolveProxy(ProxyConfig{HTTP: "http://cfg:8080"}, func(k string) string { return env[k] })
+	want := ProxyConfig{HTTP: "http://cfg:8080", HTTPS: "http://upper:3128", NoProxy: ".corp"}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	p := ProxyConfig{HTTPS: "http://p:3128"}
+	if got := p.Env(); !slices.Equal(got, []string{"HTTPS_PROXY=http://p:3128", "https_proxy=http://p:3128"}) {
+		t.Errorf("Env: %q", got)
+	}
+	if got := p.buildArgs(); !slices.Equal(got, []string{"--build-arg", "HTTPS_PROXY=http://p:3128", "--build-arg", "https_proxy=http://p:3128"}) {
+		t.Errorf("buildArgs: %q", got)
+	}
+	if got := (&ProxyConfig{}).Env(); len(got) != 0 {
+		t.Errorf("empty: %q", got)
+	}
+}
+
+func TestProxyURL(t *testing.T) {
+	p := &ProxyConfig{HTTP: "proxy.corp:3128", HTTPS: "https://sproxy.corp:3129", NoProxy: "internal.corp, .example.com,10.0.0.0/8,registry:5000"}
+	for _, tt := range []struct{ url, want string }{
+		{"http://golang.org/x", "http://proxy.corp:3128"},
+		{"https://api.tailscale.com/api/v2", "https://sproxy.corp:3129"},
+		{"https://internal.corp/", ""},
+		{"https://git.internal.corp/", ""},
+		{"https://notinternal.corp/", "https://sproxy.corp:3129"},
+		{"https://example.com/", ""},
+		{"https://a.example.com/", ""},
+		{"http://10.1.2.3/", ""},
+		{"http://11.1.2.3/", "http://proxy.corp:3128"},
+		{"https://registry:5000/v2/", ""},
+		{"http://localhost:9222/json", ""},
+		{"http://127.0.0.1:9222/json", ""},
+		{"http://[::1]:9222/json", ""},
+	} {
+		req, err := http.NewRequest(http.MethodGet, tt.url, http.NoBody)
+		if err != nil {
+			t.Fatal(err)
+		}
+		u, err := p.proxyURL(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if u != nil {
+			got = u.String()
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.url, got, tt.want)
+		}
+	}
+	p.NoProxy = "*"
+	req, _ := http.NewRequest(http.MethodGet, "http://golang.org", http.NoBody)
+	if u, _ := p.proxyURL(req); u != nil {
+		t.Errorf("*: got %s", u)
+	}
+}
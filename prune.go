@@ -0,0 +1,145 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/caic-xyz/md/gitutil"
+)
+
+// pruneRepoDepth is how deep Prune looks for git repositories under each of
+// [PruneOpts.Roots].
+const pruneRepoDepth = 3
+
+// staleBuildDir is the age after which a build context left in the temporary
+// directory is considered abandoned by an interrupted md process rather than
+// in use by a running build.
+const staleBuildDir = 24 * time.Hour
+
+// buildDirPatterns match the temporary build contexts md creates.
+var buildDirPatterns = []string{"md-build-*", "md-specialized-*"}
+
+// PruneOpts configures [Client.Prune].
+type PruneOpts struct {
+	// DryRun reports what would be removed without removing anything.
+	DryRun bool
+	// Roots are the directories searched for git repositories holding
+	// remotes of removed containers.
+	Roots []string
+}
+
+// Prune removes what md leaves behind: the images removed by
+// [Client.PruneImages], build contexts abandoned in the temporary directory,
+// and the SSH config files and git remotes of containers that no longer
+// exist. Git remotes are looked up in the repositories found under
+// opts.Roots. Returns a description of each removed item, or of each item
+// that would be with opts.DryRun.
+func (c *Client) Prune(ctx context.Context, stdout, stderr io.Writer, opts *PruneOpts) ([]string, error) {
+	// List first: the SSH configs and remotes are only orphans if the
+	// containers are known.
+	containers, err := c.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(containers))
+	for i, ct := range containers {
+		names[i] = ct.Name
+	}
+	removed, err := c.pruneImages(ctx, stdout, opts.DryRun)
+	if err != nil {
+		return nil, err
+	}
+	removed = append(removed, pruneBuildDirs(stdout, os.TempDir(), time.Now(), opts.DryRun)...)
+	configs, err := pruneSSHConfigs(filepath.Join(c.Home, ".ssh", "config.d"), names, opts.DryRun)
+	if err != nil {
+		return removed, err
+	}
+	removed = append(removed, configs...)
+	var repos []string
+	for _, root := range opts.Roots {
+		found, err := gitutil.DiscoverRepos(root, pruneRepoDepth)
+		if err != nil {
+			return removed, err
+		}
+		for _, r := range found {
+			if !slices.Contains(repos, r) {
+				repos = append(repos, r)
+			}
+		}
+	}
+	for _, r := range repos {
+		removed = append(removed, pruneRemotes(ctx, stdout, r, names, opts.DryRun)...)
+	}
+	return removed, nil
+}
+
+// pruneBuildDirs removes the md build contexts in tmpDir older than
+// staleBuildDir.
+func pruneBuildDirs(stdout io.Writer, tmpDir string, now time.Time, dryRun bool) []string {
+	var removed []string
+	for _, pattern := range buildDirPatterns {
+		matches, _ := filepath.Glob(filepath.Join(tmpDir, pattern))
+		for _, p := range matches {
+			fi, err := os.Lstat(p)
+			if err != nil || !fi.IsDir() || now.Sub(fi.ModTime()) < staleBuildDir {
+				continue
+			}
+			if !dryRun {
+				if err := os.RemoveAll(p); err != nil {
+					_, _ = fmt.Fprintf(stdout, "- Warning: failed to remove %s: %v\n", p, err)
+					continue
+				}
+			}
+			removed = append(removed, "build context "+p)
+		}
+	}
+	return removed
+}
+
+// pruneSSHConfigs removes the SSH config files in configDir of the md
+// containers not in names.
+func pruneSSHConfigs(configDir string, names []string, dryRun bool) ([]string, error) {
+	stale, err := staleSSHConfigs(configDir, names)
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for _, name := range stale {
+		if !dryRun {
+			removeSSHConfig(configDir, name)
+			_ = os.Remove(lastUsedPath(configDir, name))
+		}
+		removed = append(removed, "SSH config "+name)
+	}
+	return removed, nil
+}
+
+// pruneRemotes removes the git remotes in gitRoot of the md containers not
+// in names.
+func pruneRemotes(ctx context.Context, stdout io.Writer, gitRoot string, names []string, dryRun bool) []string {
+	orphans, err := orphanRemotes(ctx, gitRoot, names)
+	if err != nil {
+		_, _ = fmt.Fprintf(stdout, "- Warning: listing git remotes of %s: %v\n", gitRoot, err)
+		return nil
+	}
+	var removed []string
+	for _, r := range orphans {
+		if !dryRun {
+			if _, err := gitutil.RunGit(ctx, gitRoot, "remote", "remove", r); err != nil {
+				_, _ = fmt.Fprintf(stdout, "- Warning: failed to remove git remote %s in %s: %v\n", r, gitRoot, err)
+				continue
+			}
+		}
+		removed = append(removed, fmt.Sprintf("git remote %s in %s", r, gitRoot))
+	}
+	return removed
+}
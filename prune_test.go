@@ -0,0 +1,103 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPruneBuildDirs(t *testing.T) {
+	tmp := t.TempDir()
+	now := time.Now()
+	old := now.Add(-2 * staleBuildDir)
+	for _, d := range []string{"md-build-old", "md-specialized-old", "md-build-new", "md-bake-old"} {
+		p := filepath.Join(tmp, d)
+		if err := os.Mkdir(p, 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasSuffix(d, "-old") {
+			if err := os.Chtimes(p, old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	want := []string{"build context " + filepath.Join(tmp, "md-build-old"), "build context " + filepath.Join(tmp, "md-specialized-old")}
+	if got := pruneBuildDirs(io.Discard, tmp, now, true); !slices.Equal(got, want) {
+		t.Errorf("dry run: got %q, want %q", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(tmp, "md-build-old")); err != nil {
+		t.Errorf("dry run removed: %v", err)
+	}
+	if got := pruneBuildDirs(io.Discard, tmp, now, false); !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	entries, err := os.ReadDir(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var left []string
+	for _, e := range entries {
+		left = append(left, e.Name())
+	}
+	if want := []string{"md-bake-old", "md-build-new"}; !slices.Equal(left, want) {
+		t.Errorf("left %q, want %q", left, want)
+	}
+}
+
+func TestPruneSSHConfigs(t *testing.T) {
+	dir := t.TempDir()
+	for _, f := range []string{"md-a-main.conf", "md-b-main.conf", "md-b-main.known_hosts", "md-b-main.last_used"} {
+		if err := os.WriteFile(filepath.Join(dir, f), nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := pruneSSHConfigs(dir, []string{"md-a-main"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"SSH config md-b-main"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "md-a-main.conf" {
+		t.Errorf("left %v", entries)
+	}
+}
+
+func TestPruneRemotes(t *testing.T) {
+	ctx := t.Context()
+	dir := t.TempDir()
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"remote", "add", "md-y-main", "user@md-y-main:/home/user/src/y"},
+		{"remote", "add", "md-y-old", "user@md-y-old:/home/user/src/y"},
+	} {
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	want := []string{"git remote md-y-old in " + dir}
+	if got := pruneRemotes(ctx, io.Discard, dir, []string{"md-y-main"}, true); !slices.Equal(got, want) {
+		t.Errorf("dry run: got %q, want %q", got, want)
+	}
+	if got := pruneRemotes(ctx, io.Discard, dir, []string{"md-y-main"}, false); !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, err := orphanRemotes(ctx, dir, []string{"md-y-main"}); err != nil || len(got) != 0 {
+		t.Errorf("left %q, %v", got, err)
+	}
+}
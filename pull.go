@@ -0,0 +1,576 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/caic-xyz/md/gitutil"
+	"github.com/maruel/genai"
+)
+
+// Pull strategies, how [Container.Pull] integrates the container's branch
+// into the local one.
+const (
+	// PullRebase replays the local branch's new commits on top of the
+	// container's. It is the default.
+	PullRebase = "rebase"
+	// PullMerge merges the container's branch into the local one.
+	PullMerge = "merge"
+	// PullSquash adds the container's changes to the local branch as a
+	// single commit.
+	PullSquash = "squash"
+)
+
+// PullStrategies are the valid [PullOpts.Strategy] values.
+var PullStrategies = []string{PullRebase, PullMerge, PullSquash}
+
+// PullOpts configures [Container.Pull] and [Container.PullContinue].
+type PullOpts struct {
+	// Provider generates the commit message of the container's uncommitted
+	// changes and, with Resolve, suggests conflict resolutions. Nil uses a
+	// default message.
+	Provider genai.Provider
+	// Strategy is one of PullStrategies. Empty means PullRebase.
+	// [Container.PullContinue] uses the one of the pull in progress.
+	Strategy string
+	// Resolve asks Provider to resolve the conflicting hunks when the pull
+	// stops on conflicts. The suggestions are written in the working tree
+	// for review, to finish with [Container.PullContinue].
+	Resolve bool
+}
+
+func (o *PullOpts) check() error {
+	if o.Strategy != "" && !slices.Contains(PullStrategies, o.Strategy) {
+		return fmt.Errorf("invalid pull strategy %q: use %s", o.Strategy, strings.Join(PullStrategies, ", "))
+	}
+	if o.Resolve && o.Provider == nil {
+		return errors.New("resolving conflicts needs an AI provider: set ASK_PROVIDER")
+	}
+	return nil
+}
+
+// ConflictError is returned by [Container.Pull] and [Container.PullContinue]
+// when the container's changes conflict with the local branch.
+type ConflictError struct {
+	Branch string
+	// Files are the conflicting paths.
+	Files []string
+	// InProgress is set when the pull stopped with the conflicts in the
+	// working tree, to resolve and finish with [Container.PullContinue] or
+	// undo with [Container.PullAbort]. Otherwise the working tree is
+	// untouched.
+	InProgress bool
+	// Resolved are the Files the AI provider suggested a resolution for.
+	Resolved []string
+}
+
+func (e *ConflictError) Error() string {
+	files := strings.Join(e.Files, ", ")
+	if !e.InProgress {
+		return fmt.Sprintf("the container's %s conflicts with the local one in %s: check out %s and pull again to resolve the conflicts", e.Branch, files, e.Branch)
+	}
+	msg := "pull stopped on conflicts in " + files
+	if len(e.Resolved) != 0 {
+		msg += "; suggested resolutions were written to " + strings.Join(e.Resolved, ", ") + ", review them with git diff"
+	}
+	return msg + ": resolve them, git add them and run md pull --continue, or md pull --abort"
+}
+
+// pullState records a pull stopped on conflicts, in the git directory of
+// the worktree it happens in.
+type pullState struct {
+	Container string `json:"container"`
+	Strategy  string `json:"strategy"`
+	// Before is the local branch before the pull.
+	Before string `json:"before"`
+	// Fetched is the container's branch integrated.
+	Fetched string `json:"fetched"`
+	// Message is the squash commit's message.
+	Message string `json:"message,omitempty"`
+}
+
+// gitPath returns the absolute path of name in dir's git directory.
+func gitPath(ctx context.Context, dir, name string) (string, error) {
+	return gitutil.RunGit(ctx, dir, "rev-parse", "--path-format=absolute", "--git-path", name)
+}
+
+// gitPathExists reports whether name exists in dir's git directory.
+func gitPathExists(ctx context.Context, dir, name string) bool {
+	p, err := gitPath(ctx, dir, name)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(p)
+	return err == nil
+}
+
+// readPullState returns the pull in progress in dir, or nil.
+func readPullState(ctx context.Context, dir string) (*pullState, error) {
+	p, err := gitPath(ctx, dir, "md-pull")
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	st := &pullState{}
+	if err := json.Unmarshal(b, st); err != nil {
+		return nil, fmt.Errorf("%s: %w", p, err)
+	}
+	return st, nil
+}
+
+func writePullState(ctx context.Context, dir string, st *pullState) error {
+	p, err := gitPath(ctx, dir, "md-pull")
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(p, b, 0o644)
+}
+
+func removePullState(ctx context.Context, dir string) error {
+	p, err := gitPath(ctx, dir, "md-pull")
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// checkNoPullInProgress returns an error when a pull, rebase or merge is in
+// progress in dir.
+func checkNoPullInProgress(ctx context.Context, dir string) error {
+	st, err := readPullState(ctx, dir)
+	if err != nil {
+		return err
+	}
+	if st != nil {
+		return fmt.Errorf("a pull from %s stopped on conflicts in %s: run md pull --continue or md pull --abort", st.Container, dir)
+	}
+	for _, name := range []string{"rebase-merge", "rebase-apply", "MERGE_HEAD"} {
+		if gitPathExists(ctx, dir, name) {
+			return fmt.Errorf("a rebase or merge is in progress in %s: finish it first", dir)
+		}
+	}
+	return nil
+}
+
+// unmergedFiles returns the files with unresolved conflicts in dir's index.
+func unmergedFiles(ctx context.Context, dir string) []string {
+	out, _ := gitutil.RunGit(ctx, dir, "diff", "--name-only", "--diff-filter=U")
+	if out == "" {
+		return nil
+	}
+	return slices.Compact(strings.Split(out, "\n"))
+}
+
+// integrateBranch brings remoteRef, the container's branch, into branch of
+// the git checkout dir with opts.Strategy. Without conflicts the working
+// tree is only updated when branch is checked out. On conflicts, it returns
+// a [ConflictError], stopping with the conflicts in the working tree when
+// branch is checked out. It doesn't touch the container.
+func integrateBranch(ctx context.Context, stdout, stderr io.Writer, dir, branch, remoteRef, container string, opts *PullOpts) error {
+	if _, err := gitutil.RunGit(ctx, dir, "merge-base", "--is-ancestor", remoteRef, branch); err == nil {
+		// Nothing new in the container.
+		return nil
+	}
+	strategy := opts.Strategy
+	if strategy == "" {
+		strategy = PullRebase
+	}
+	currentBranch, _ := gitutil.RunGit(ctx, dir, "branch", "--show-current")
+	onBranch := currentBranch == branch
+	if _, err := gitutil.RunGit(ctx, dir, "merge-base", "--is-ancestor", branch, remoteRef); err == nil && strategy != PullSquash {
+		// Fast-forward.
+		if onBranch {
+			return runCmdOut(ctx, dir, []string{"git", "merge", "-q", "--ff-only", remoteRef}, stdout, stderr)
+		}
+		return runCmdOut(ctx, dir, []string{"git", "update-ref", "refs/heads/" + branch, remoteRef}, stdout, stderr)
+	}
+	files, err := gitutil.Conflicts(ctx, dir, branch, remoteRef)
+	if err != nil {
+		return err
+	}
+	if len(files) != 0 && !onBranch {
+		return &ConflictError{Branch: branch, Files: files}
+	}
+	before, err := gitutil.RevParse(ctx, dir, "refs/heads/"+branch)
+	if err != nil {
+		return err
+	}
+	var msg string
+	switch strategy {
+	case PullMerge:
+		msg = fmt.Sprintf("Merge branch '%s' of md container %s", branch, container)
+	case PullSquash:
+		msg = squashMessage(ctx, dir, branch, remoteRef, container)
+	}
+	if !onBranch {
+		if strategy == PullRebase {
+			return rebaseElsewhere(ctx, stdout, stderr, dir, branch, currentBranch, remoteRef)
+		}
+		var commit string
+		if strategy == PullMerge {
+			commit, err = gitutil.Merge(ctx, dir, branch, remoteRef, msg)
+		} else {
+			commit, err = gitutil.SquashMerge(ctx, dir, branch, remoteRef, msg)
+		}
+		if err != nil {
+			return err
+		}
+		return runCmdOut(ctx, dir, []string{"git", "update-ref", "refs/heads/" + branch, commit, before}, stdout, stderr)
+	}
+	if len(files) != 0 {
+		// Don't mix the conflicts with pending changes.
+		if _, err := gitutil.RunGit(ctx, dir, "diff", "--quiet", "HEAD"); err != nil {
+			return fmt.Errorf("the container's changes conflict in %s and there are pending changes locally. Please commit or stash them before pulling", strings.Join(files, ", "))
+		}
+	}
+	fetched, err := gitutil.RevParse(ctx, dir, remoteRef)
+	if err != nil {
+		return err
+	}
+	st := &pullState{Container: container, Strategy: strategy, Before: before, Fetched: fetched, Message: msg}
+	if err := writePullState(ctx, dir, st); err != nil {
+		return err
+	}
+	switch strategy {
+	case PullRebase:
+		err = runCmdOut(ctx, dir, []string{"git", "rebase", "-q", remoteRef}, stdout, stderr)
+	case PullMerge:
+		err = runCmdOut(ctx, dir, []string{"git", "merge", "-q", "-m", msg, remoteRef}, stdout, stderr)
+	case PullSquash:
+		if len(files) != 0 {
+			err = runCmdOut(ctx, dir, []string{"git", "merge", "-q", "--squash", remoteRef}, stdout, stderr)
+			break
+		}
+		var commit string
+		if commit, err = gitutil.SquashMerge(ctx, dir, branch, remoteRef, msg); err == nil {
+			err = runCmdOut(ctx, dir, []string{"git", "merge", "-q", "--ff-only", commit}, stdout, stderr)
+		}
+	}
+	return stopOrFinish(ctx, dir, branch, strategy, err, opts)
+}
+
+// stopOrFinish ends a pull step run in dir that returned err: a
+// [ConflictError] when it stopped on conflicts, else the pull is done unless
+// its operation is still in progress.
+func stopOrFinish(ctx context.Context, dir, branch, strategy string, err error, opts *PullOpts) error {
+	if err == nil {
+		return removePullState(ctx, dir)
+	}
+	files := unmergedFiles(ctx, dir)
+	if len(files) == 0 {
+		if !operationInProgress(ctx, dir, strategy) {
+			// Failed before starting, e.g. on pending changes.
+			_ = removePullState(ctx, dir)
+		}
+		return err
+	}
+	ce := &ConflictError{Branch: branch, Files: files, InProgress: true}
+	if opts.Resolve {
+		ce.Resolved = resolveConflicts(ctx, opts.Provider, dir, files)
+	}
+	return ce
+}
+
+// operationInProgress reports whether the git operation of strategy is in
+// progress in dir.
+func operationInProgress(ctx context.Context, dir, strategy string) bool {
+	switch strategy {
+	case PullRebase:
+		return gitPathExists(ctx, dir, "rebase-merge") || gitPathExists(ctx, dir, "rebase-apply")
+	case PullMerge:
+		return gitPathExists(ctx, dir, "MERGE_HEAD")
+	case PullSquash:
+		return gitPathExists(ctx, dir, "SQUASH_MSG")
+	}
+	return false
+}
+
+// rebaseElsewhere rebases branch on remoteRef when another branch, or a
+// detached HEAD, is checked out, restoring it afterward. The rebase is
+// aborted if it conflicts.
+func rebaseElsewhere(ctx context.Context, stdout, stderr io.Writer, dir, branch, currentBranch, remoteRef string) error {
+	origRef := currentBranch
+	if origRef == "" {
+		origRef, _ = gitutil.RunGit(ctx, dir, "rev-parse", "HEAD")
+	}
+	if err := runCmdOut(ctx, dir, []string{"git", "checkout", "-q", branch}, stdout, stderr); err != nil {
+		return err
+	}
+	if err := runCmdOut(ctx, dir, []string{"git", "rebase", "-q", remoteRef}, stdout, stderr); err != nil {
+		files := unmergedFiles(ctx, dir)
+		_ = runCmdOut(ctx, dir, []string{"git", "rebase", "--abort"}, stdout, stderr)
+		_ = runCmdOut(ctx, dir, []string{"git", "checkout", "-q", origRef}, stdout, stderr)
+		if len(files) != 0 {
+			return &ConflictError{Branch: branch, Files: files}
+		}
+		return err
+	}
+	return runCmdOut(ctx, dir, []string{"git", "checkout", "-q", origRef}, stdout, stderr)
+}
+
+// squashMessage returns the message of the commit squashing the container's
+// commits: the commit's own when there is one, else their subjects.
+func squashMessage(ctx context.Context, dir, branch, remoteRef, container string) string {
+	out, _ := gitutil.RunGit(ctx, dir, "log", "--reverse", "--format=%s", branch+".."+remoteRef)
+	subjects := strings.Split(out, "\n")
+	if len(subjects) == 1 && subjects[0] != "" {
+		if msg, err := gitutil.RunGit(ctx, dir, "log", "-1", "--format=%B", remoteRef); err == nil {
+			return msg
+		}
+	}
+	msg := fmt.Sprintf("Squash %d commits from md container %s\n\n", len(subjects), container)
+	for _, s := range subjects {
+		msg += "- " + s + "\n"
+	}
+	return msg
+}
+
+// continueIntegration finishes the pull st stopped in dir once its
+// conflicts are resolved. It returns a [ConflictError] when a rebase stops
+// again on the next commit.
+func continueIntegration(ctx context.Context, stdout, stderr io.Writer, dir, branch string, st *pullState, opts *PullOpts) error {
+	if files := unmergedFiles(ctx, dir); len(files) != 0 {
+		return fmt.Errorf("conflicts remain in %s: resolve them and git add them first", strings.Join(files, ", "))
+	}
+	var err error
+	if operationInProgress(ctx, dir, st.Strategy) {
+		switch st.Strategy {
+		case PullRebase:
+			err = runCmdOut(ctx, dir, []string{"git", "-c", "core.editor=true", "rebase", "--continue"}, stdout, stderr)
+		case PullMerge:
+			err = runCmdOut(ctx, dir, []string{"git", "commit", "-q", "--no-edit"}, stdout, stderr)
+		case PullSquash:
+			err = runCmdOut(ctx, dir, []string{"git", "commit", "-q", "-m", st.Message}, stdout, stderr)
+		}
+	}
+	return stopOrFinish(ctx, dir, branch, st.Strategy, err, opts)
+}
+
+// abortIntegration undoes the pull st stopped in dir.
+func abortIntegration(ctx context.Context, stdout, stderr io.Writer, dir string, st *pullState) error {
+	if operationInProgress(ctx, dir, st.Strategy) {
+		var args []string
+		switch st.Strategy {
+		case PullRebase:
+			args = []string{"git", "rebase", "--abort"}
+		case PullMerge:
+			args = []string{"git", "merge", "--abort"}
+		case PullSquash:
+			args = []string{"git", "reset", "-q", "--merge"}
+		}
+		if err := runCmdOut(ctx, dir, args, stdout, stderr); err != nil {
+			return err
+		}
+	}
+	return removePullState(ctx, dir)
+}
+
+// PullContinue finishes a pull of Repos[repoIdx] that stopped on conflicts
+// once they are resolved and staged, then makes the local branch the
+// container's base like [Container.Pull].
+func (c *Container) PullContinue(ctx context.Context, stdout, stderr io.Writer, repoIdx int, opts *PullOpts) (*SyncResult, error) {
+	if opts == nil {
+		opts = &PullOpts{}
+	}
+	if err := opts.check(); err != nil {
+		return nil, err
+	}
+	r, st, err := c.pullInProgress(ctx, repoIdx)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Strategy != "" && opts.Strategy != st.Strategy {
+		return nil, fmt.Errorf("the pull in progress uses the %s strategy", st.Strategy)
+	}
+	if err := c.checkContainerState(ctx); err != nil {
+		return nil, err
+	}
+	c.touch()
+	if err := continueIntegration(ctx, stdout, stderr, r.GitRoot, r.Branch, st, opts); err != nil {
+		return nil, err
+	}
+	if err := c.finishIntegration(ctx, stdout, stderr, r, st.Strategy, st.Fetched); err != nil {
+		return nil, err
+	}
+	res := &SyncResult{Stat: diffStat(ctx, r.GitRoot, st.Before, "refs/heads/"+r.Branch)}
+	if res.Stat != nil {
+		_, _ = fmt.Fprintf(stdout, "- Integrated into %s: %s\n", r.Branch, res.Stat)
+	}
+	return res, nil
+}
+
+// PullAbort undoes a pull of Repos[repoIdx] that stopped on conflicts,
+// restoring the local branch. The container is left alone.
+func (c *Container) PullAbort(ctx context.Context, stdout, stderr io.Writer, repoIdx int) error {
+	r, st, err := c.pullInProgress(ctx, repoIdx)
+	if err != nil {
+		return err
+	}
+	return abortIntegration(ctx, stdout, stderr, r.GitRoot, st)
+}
+
+// pullInProgress returns Repos[repoIdx] and the pull from c stopped in it.
+func (c *Container) pullInProgress(ctx context.Context, repoIdx int) (*Repo, *pullState, error) {
+	if repoIdx < 0 || repoIdx >= len(c.Repos) {
+		return nil, nil, fmt.Errorf("repo index %d out of range [0, %d)", repoIdx, len(c.Repos))
+	}
+	r := &c.Repos[repoIdx]
+	st, err := readPullState(ctx, r.GitRoot)
+	if err != nil {
+		return nil, nil, err
+	}
+	if st == nil {
+		return nil, nil, fmt.Errorf("no pull stopped on conflicts in %s", r.GitRoot)
+	}
+	if st.Container != c.Name {
+		return nil, nil, fmt.Errorf("the pull in progress in %s is from %s", r.GitRoot, st.Container)
+	}
+	return r, st, nil
+}
+
+// finishIntegration makes r's local branch the container's base once the
+// fetched commit is integrated. After a squash, the container's branch is
+// reset onto it, as its commits aren't in the base's history.
+func (c *Container) finishIntegration(ctx context.Context, stdout, stderr io.Writer, r *Repo, strategy, fetched string) error {
+	if err := c.sendRef(ctx, stdout, stderr, r, r.Branch, "base", gitutil.PushOpts{Force: true}); err != nil {
+		return err
+	}
+	if strategy == PullSquash {
+		// Only if the agent didn't commit since the fetch.
+		cmd := "cd ~/src/" + shellQuote(r.Name()) + " && test \"$(git rev-parse HEAD)\" = " + shellQuote(fetched) + " && git reset -q --keep base"
+		if _, err := runCmd(ctx, "", c.SSHCommand(c.Name, cmd)); err != nil {
+			_, _ = fmt.Fprintf(stdout, "- The container's %s moved since the pull; run md push to reset it onto the squashed commit\n", r.Branch)
+		}
+	}
+	if gitutil.HgSource(ctx, r.GitRoot) != "" {
+		return gitutil.HgImport(ctx, r.GitRoot, r.Branch)
+	}
+	return nil
+}
+
+// resolveSystemPrompt instructs the AI provider resolving a conflict hunk.
+const resolveSystemPrompt = `You resolve git merge conflicts. You get the path of a file, the lines
+before a conflict, the conflict with its markers, and the lines after it.
+Reply with only the lines replacing the conflict and its markers, combining
+both sides' intent. No explanation, no code fence.`
+
+// resolveConflicts asks p to resolve the conflict hunks of files in dir and
+// writes the suggestions in place, unstaged. It returns the files resolved.
+func resolveConflicts(ctx context.Context, p genai.Provider, dir string, files []string) []string {
+	var resolved []string
+	for _, f := range files {
+		path := filepath.Join(dir, f)
+		b, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		out, err := resolveHunks(string(b), func(before, hunk, after string) (string, error) {
+			content := "File: " + f + "\n\n=== Before ===\n" + before + "=== Conflict ===\n" + hunk + "=== After ===\n" + after
+			ctx, cancel := context.WithTimeout(ctx, time.Minute)
+			defer cancel()
+			res, err := p.GenSync(ctx, genai.Messages{genai.NewTextMessage(content)}, &genai.GenOptionText{
+				MaxTokens:    4096,
+				SystemPrompt: resolveSystemPrompt,
+			})
+			if err != nil {
+				return "", err
+			}
+			return res.String(), nil
+		})
+		if err != nil {
+			slog.WarnContext(ctx, "md", "msg", "failed to resolve conflicts", "file", f, "err", err)
+			continue
+		}
+		if err := os.WriteFile(path, []byte(out), 0o644); err != nil {
+			slog.WarnContext(ctx, "md", "msg", "failed to write resolution", "file", f, "err", err)
+			continue
+		}
+		resolved = append(resolved, f)
+	}
+	return resolved
+}
+
+// resolveContext is how many lines around a conflict hunk are sent along.
+const resolveContext = 10
+
+// resolveHunks replaces each conflict hunk of content, from its <<<<<<<
+// marker to its >>>>>>> one, with what resolve returns for it and the lines
+// around it. A reply still holding markers is an error.
+func resolveHunks(content string, resolve func(before, hunk, after string) (string, error)) (string, error) {
+	lines := strings.SplitAfter(content, "\n")
+	var out strings.Builder
+	n := 0
+	for i := 0; i < len(lines); i++ {
+		if !strings.HasPrefix(lines[i], "<<<<<<<") {
+			out.WriteString(lines[i])
+			continue
+		}
+		end := i + 1
+		for end < len(lines) && !strings.HasPrefix(lines[end], ">>>>>>>") {
+			end++
+		}
+		if end == len(lines) {
+			return "", errors.New("unterminated conflict")
+		}
+		before := strings.Join(lines[max(0, i-resolveContext):i], "")
+		after := strings.Join(lines[end+1:min(len(lines), end+1+resolveContext)], "")
+		got, err := resolve(before, strings.Join(lines[i:end+1], ""), after)
+		if err != nil {
+			return "", err
+		}
+		got = stripCodeFence(got)
+		if strings.Contains(got, "<<<<<<<") || strings.Contains(got, ">>>>>>>") {
+			return "", errors.New("the suggestion still has conflict markers")
+		}
+		if got != "" && !strings.HasSuffix(got, "\n") {
+			got += "\n"
+		}
+		out.WriteString(got)
+		n++
+		i = end
+	}
+	if n == 0 {
+		return "", errors.New("no conflict markers")
+	}
+	return out.String(), nil
+}
+
+// stripCodeFence removes the markdown code fence models tend to wrap their
+// replies in.
+func stripCodeFence(s string) string {
+	t := strings.TrimSpace(s)
+	if !strings.HasPrefix(t, "```") || !strings.HasSuffix(t, "```") || len(t) < 6 {
+		return s
+	}
+	t = strings.TrimSuffix(t, "```")
+	if _, body, ok := strings.Cut(t, "\n"); ok {
+		return body
+	}
+	return ""
+}
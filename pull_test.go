@@ -0,0 +1,230 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// newPullRepo returns a repository where the main branch and the ctr
+// branch, standing for the container's, both changed since their base.
+// With conflict, both changed a.txt.
+func newPullRepo(t *testing.T, conflict bool) (dir string, git func(args ...string) string) {
+	t.Helper()
+	dir = t.TempDir()
+	git = func(args ...string) string {
+		t.Helper()
+		cmd := exec.CommandContext(t.Context(), "git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@test"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git("init", "-q", "--initial-branch=main")
+	git("config", "user.name", "Test")
+	git("config", "user.email", "test@test")
+	write("a.txt", "a\n")
+	git("add", ".")
+	git("commit", "-q", "-m", "init")
+	git("checkout", "-q", "-b", "ctr")
+	write("a.txt", "container\n")
+	git("commit", "-q", "-am", "container 1")
+	write("b.txt", "b\n")
+	git("add", ".")
+	git("commit", "-q", "-m", "container 2")
+	git("checkout", "-q", "main")
+	if conflict {
+		write("a.txt", "host\n")
+	} else {
+		write("c.txt", "c\n")
+	}
+	git("add", ".")
+	git("commit", "-q", "-m", "host")
+	return dir, git
+}
+
+func TestIntegrateBranch(t *testing.T) {
+	for name, tt := range map[string]struct {
+		strategy string
+		onBranch bool
+		// wantLog is the subjects of main after the pull.
+		wantLog string
+	}{
+		"rebase":            {PullRebase, true, "host\ncontainer 2\ncontainer 1\ninit"},
+		"rebase_elsewhere":  {PullRebase, false, "host\ncontainer 2\ncontainer 1\ninit"},
+		"merge":             {PullMerge, true, "Merge branch 'main' of md container ctr\ncontainer 2\ncontainer 1\nhost\ninit"},
+		"merge_elsewhere":   {PullMerge, false, "Merge branch 'main' of md container ctr\ncontainer 2\ncontainer 1\nhost\ninit"},
+		"squash":            {PullSquash, true, "Squash 2 commits from md container ctr\nhost\ninit"},
+		"squash_elsewhere":  {PullSquash, false, "Squash 2 commits from md container ctr\nhost\ninit"},
+		"default_strategy":  {"", true, "host\ncontainer 2\ncontainer 1\ninit"},
+		"default_elsewhere": {"", false, "host\ncontainer 2\ncontainer 1\ninit"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir, git := newPullRepo(t, false)
+			if !tt.onBranch {
+				git("checkout", "-q", "--detach", "main")
+			}
+			if err := integrateBranch(t.Context(), io.Discard, io.Discard, dir, "main", "ctr", "ctr", &PullOpts{Strategy: tt.strategy}); err != nil {
+				t.Fatal(err)
+			}
+			if got := git("log", "--format=%s", "--topo-order", "main"); got != tt.wantLog {
+				t.Errorf("log:\n%s\nwant:\n%s", got, tt.wantLog)
+			}
+			if got := git("ls-tree", "--name-only", "main"); got != "a.txt\nb.txt\nc.txt" {
+				t.Errorf("tree: %q", got)
+			}
+			if got := git("status", "--porcelain"); got != "" {
+				t.Errorf("status: %q", got)
+			}
+			if st, err := readPullState(t.Context(), dir); st != nil || err != nil {
+				t.Errorf("state: %+v, %v", st, err)
+			}
+		})
+	}
+}
+
+func TestIntegrateBranchConflict(t *testing.T) {
+	ctx := t.Context()
+	t.Run("elsewhere", func(t *testing.T) {
+		dir, git := newPullRepo(t, true)
+		git("checkout", "-q", "--detach", "main")
+		head := git("rev-parse", "main")
+		err := integrateBranch(ctx, io.Discard, io.Discard, dir, "main", "ctr", "ctr", &PullOpts{})
+		var ce *ConflictError
+		if !errors.As(err, &ce) || ce.InProgress || !slices.Equal(ce.Files, []string{"a.txt"}) {
+			t.Fatalf("got %v", err)
+		}
+		if git("rev-parse", "main") != head || git("status", "--porcelain") != "" {
+			t.Error("the repository changed")
+		}
+	})
+	for _, strategy := range PullStrategies {
+		t.Run(strategy, func(t *testing.T) {
+			dir, git := newPullRepo(t, true)
+			head := git("rev-parse", "main")
+			err := integrateBranch(ctx, io.Discard, io.Discard, dir, "main", "ctr", "ctr", &PullOpts{Strategy: strategy})
+			var ce *ConflictError
+			if !errors.As(err, &ce) || !ce.InProgress || !slices.Equal(ce.Files, []string{"a.txt"}) {
+				t.Fatalf("got %v", err)
+			}
+			if err := checkNoPullInProgress(ctx, dir); err == nil || !strings.Contains(err.Error(), "md pull --continue") {
+				t.Errorf("in progress: %v", err)
+			}
+			st, err := readPullState(ctx, dir)
+			if err != nil || st.Strategy != strategy || st.Container != "ctr" || st.Before != head {
+				t.Fatalf("state: %+v, %v", st, err)
+			}
+
+			// Continuing with the conflict unresolved fails.
+			if err := continueIntegration(ctx, io.Discard, io.Discard, dir, "main", st, &PullOpts{}); err == nil || !strings.Contains(err.Error(), "conflicts remain in a.txt") {
+				t.Fatalf("unresolved: %v", err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("both\n"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			git("add", "a.txt")
+			if err := continueIntegration(ctx, io.Discard, io.Discard, dir, "main", st, &PullOpts{}); err != nil {
+				t.Fatal(err)
+			}
+			if got := git("show", "main:a.txt"); got != "both" {
+				t.Errorf("a.txt: %q", got)
+			}
+			if got := git("status", "--porcelain"); got != "" {
+				t.Errorf("status: %q", got)
+			}
+			if err := checkNoPullInProgress(ctx, dir); err != nil {
+				t.Error(err)
+			}
+		})
+		t.Run(strategy+"_abort", func(t *testing.T) {
+			dir, git := newPullRepo(t, true)
+			head := git("rev-parse", "main")
+			if err := integrateBranch(ctx, io.Discard, io.Discard, dir, "main", "ctr", "ctr", &PullOpts{Strategy: strategy}); err == nil {
+				t.Fatal("expected conflicts")
+			}
+			st, err := readPullState(ctx, dir)
+			if err != nil || st == nil {
+				t.Fatalf("state: %+v, %v", st, err)
+			}
+			if err := abortIntegration(ctx, io.Discard, io.Discard, dir, st); err != nil {
+				t.Fatal(err)
+			}
+			if git("rev-parse", "HEAD") != head || git("branch", "--show-current") != "main" || git("status", "--porcelain") != "" {
+				t.Error("the pull wasn't undone")
+			}
+			if err := checkNoPullInProgress(ctx, dir); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	t.Run("dirty", func(t *testing.T) {
+		dir, git := newPullRepo(t, true)
+		if err := os.WriteFile(filepath.Join(dir, "c.txt"), []byte("pending\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		git("add", "c.txt")
+		err := integrateBranch(ctx, io.Discard, io.Discard, dir, "main", "ctr", "ctr", &PullOpts{})
+		if err == nil || !strings.Contains(err.Error(), "pending changes") {
+			t.Fatalf("got %v", err)
+		}
+		if st, _ := readPullState(ctx, dir); st != nil {
+			t.Errorf("state: %+v", st)
+		}
+	})
+}
+
+func TestResolveHunks(t *testing.T) {
+	content := "1\n2\n<<<<<<< HEAD\nhost\n=======\ncontainer\n>>>>>>> ctr\n3\n<<<<<<< HEAD\nx\n=======\ny\n>>>>>>> ctr\n"
+	var hunks []string
+	got, err := resolveHunks(content, func(before, hunk, after string) (string, error) {
+		hunks = append(hunks, before+"|"+hunk+"|"+after)
+		if len(hunks) == 1 {
+			return "```go\nboth\n```", nil
+		}
+		return "xy", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "1\n2\nboth\n3\nxy\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	want := []string{
+		"1\n2\n|<<<<<<< HEAD\nhost\n=======\ncontainer\n>>>>>>> ctr\n|3\n<<<<<<< HEAD\nx\n=======\ny\n>>>>>>> ctr\n",
+		"1\n2\n<<<<<<< HEAD\nhost\n=======\ncontainer\n>>>>>>> ctr\n3\n|<<<<<<< HEAD\nx\n=======\ny\n>>>>>>> ctr\n|",
+	}
+	if !slices.Equal(hunks, want) {
+		t.Errorf("hunks:\n%q\nwant\n%q", hunks, want)
+	}
+
+	for name, tc := range map[string]struct {
+		content, reply string
+	}{
+		"no_markers":   {"a\n", "b"},
+		"unterminated": {"<<<<<<< HEAD\na\n", "b"},
+		"markers_left": {content, "<<<<<<< HEAD\nboth\n"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := resolveHunks(tc.content, func(_, _, _ string) (string, error) { return tc.reply, nil }); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
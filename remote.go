@@ -0,0 +1,113 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// remoteEngineHost returns the ssh destination, "[user@]host[:port]", of the
+// machine running the engine rt, or "" when it runs locally. It is derived
+// from $DOCKER_HOST ($CONTAINER_HOST for podman), else from the current
+// docker context.
+func remoteEngineHost(ctx context.Context, rt, home string) string {
+	return sshDestination(engineFor(rt).endpoint(ctx, home))
+}
+
+// dockerContextHost returns the engine endpoint of the current docker
+// context, "" for the default one.
+func dockerContextHost(ctx context.Context, home string) string {
+	name := os.Getenv("DOCKER_CONTEXT")
+	if name == "" {
+		// Read the current context from docker's config first so the common
+		// case doesn't pay for running docker.
+		data, err := os.ReadFile(filepath.Join(envOr("DOCKER_CONFIG", filepath.Join(home, ".docker")), "config.json"))
+		if err != nil {
+			return ""
+		}
+		var cfg struct {
+			CurrentContext string `json:"currentContext"`
+		}
+		if json.Unmarshal(data, &cfg) != nil {
+			return ""
+		}
+		name = cfg.CurrentContext
+	}
+	if name == "" || name == "default" {
+		return ""
+	}
+	out, err := runCmd(ctx, "", []string{"docker", "context", "inspect", name})
+	if err != nil {
+		return ""
+	}
+	info, err := decodeInspect[dockerContextInfo](out, name)
+	if err != nil {
+		return ""
+	}
+	return info.Endpoints.Docker.Host
+}
+
+// dockerContextInfo is the subset of docker context inspect's output md
+// reads.
+type dockerContextInfo struct {
+	Endpoints struct {
+		Docker struct {
+			Host string `json:"Host"`
+		} `json:"docker"`
+	} `json:"Endpoints"`
+}
+
+// sshDestination returns the ssh destination of the engine endpoint, "" for
+// a local one. An ssh:// endpoint is used as is; for a tcp:// one, the
+// machine is assumed to be reachable with ssh under the same name.
+func sshDestination(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return ""
+	}
+	switch u.Scheme {
+	case "ssh":
+		if u.User != nil {
+			return u.User.Username() + "@" + u.Host
+		}
+		return u.Host
+	case "tcp", "http", "https":
+		switch h := u.Hostname(); h {
+		case "", "localhost", "127.0.0.1", "::1":
+			return ""
+		default:
+			return h
+		}
+	}
+	return ""
+}
+
+// checkRemoteOpts returns an error for the start options that name host
+// paths or devices, which a remote engine would look up on its own machine.
+func checkRemoteOpts(opts *StartOpts) error {
+	var unsupported []string
+	if len(opts.Mounts) > 0 {
+		unsupported = append(unsupported, "mounts")
+	}
+	if opts.MountSource != SourceClone {
+		unsupported = append(unsupported, "mounted checkouts")
+	}
+	if opts.SharedObjects {
+		unsupported = append(unsupported, "shared objects")
+	}
+	if opts.USB {
+		unsupported = append(unsupported, "USB")
+	}
+	if len(unsupported) > 0 {
+		return fmt.Errorf("not supported with a remote engine: %s", strings.Join(unsupported, ", "))
+	}
+	return nil
+}
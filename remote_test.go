@@ -0,0 +1,95 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestSSHDestination(t *testing.T) {
+	for in, want := range map[string]string{
+		"":                                "",
+		"unix:///var/run/docker.sock":     "",
+		"npipe:////./pipe/docker_engine":  "",
+		"ssh://build":                     "build",
+		"ssh://me@build.example.com:2222": "me@build.example.com:2222",
+		"tcp://build.example.com:2376":    "build.example.com",
+		"tcp://localhost:2375":            "",
+		"tcp://127.0.0.1:2375":            "",
+	} {
+		if got := sshDestination(in); got != want {
+			t.Errorf("sshDestination(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestRemoteEngineHost(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", "")
+	t.Setenv("DOCKER_CONTEXT", "")
+	t.Setenv("DOCKER_HOST", "")
+	t.Setenv("CONTAINER_HOST", "ssh://me@podbox/run/user/1000/podman/podman.sock")
+	if got := remoteEngineHost(t.Context(), "docker", home); got != "" {
+		t.Errorf("no docker config: got %q", got)
+	}
+	if err := os.MkdirAll(filepath.Join(home, ".docker"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(home, ".docker", "config.json"), `{"currentContext": "default"}`)
+	if got := remoteEngineHost(t.Context(), "docker", home); got != "" {
+		t.Errorf("default context: got %q", got)
+	}
+	if runtime.GOOS != "windows" {
+		// docker context inspect prints a JSON array.
+		bin := t.TempDir()
+		writeFile(t, filepath.Join(bin, "docker"), "#!/bin/sh\n"+`echo '[{"Name": "build", "Endpoints": {"docker": {"Host": "ssh://me@ctx"}}}]'`+"\n")
+		if err := os.Chmod(filepath.Join(bin, "docker"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+		t.Setenv("DOCKER_CONTEXT", "build")
+		if got := remoteEngineHost(t.Context(), "docker", home); got != "me@ctx" {
+			t.Errorf("context: got %q", got)
+		}
+	}
+	t.Setenv("DOCKER_HOST", "ssh://me@build")
+	if got := remoteEngineHost(t.Context(), "docker", home); got != "me@build" {
+		t.Errorf("DOCKER_HOST: got %q", got)
+	}
+	if got := remoteEngineHost(t.Context(), "podman", home); got != "me@podbox" {
+		t.Errorf("CONTAINER_HOST: got %q", got)
+	}
+}
+
+func TestCheckRemoteOpts(t *testing.T) {
+	if err := checkRemoteOpts(&StartOpts{Display: true}); err != nil {
+		t.Fatal(err)
+	}
+	err := checkRemoteOpts(&StartOpts{MountSource: SourceOverlay, USB: true})
+	if err == nil || err.Error() != "not supported with a remote engine: mounted checkouts, USB" {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestWriteSSHConfigProxyJump(t *testing.T) {
+	dir := t.TempDir()
+	ep := sshEndpoint{Port: 32768, ProxyJump: "me@build:2222"}
+	if err := writeSSHConfig(dir, "md-repo-main", ep, "/home/me/.ssh/md", filepath.Join(dir, "md-repo-main.known_hosts"), false); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := os.ReadFile(filepath.Join(dir, "md-repo-main.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"  HostName 127.0.0.1\n", "  Port 32768\n", "  ProxyJump me@build:2222\n"} {
+		if !strings.Contains(string(conf), want) {
+			t.Errorf("missing %q in:\n%s", want, conf)
+		}
+	}
+}
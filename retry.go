@@ -0,0 +1,86 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// cmdRetries is how many times runCmdRetry retries a transient failure. The
+// first retry waits cmdRetryDelay, each next one twice as long, so a command
+// gives up after about 2s.
+var (
+	cmdRetries    = 3
+	cmdRetryDelay = 250 * time.Millisecond
+)
+
+// transientErrors are the messages, lowercased, of failures that usually go
+// away on their own: the engine's daemon or a registry briefly unreachable or
+// overloaded, sshd restarting.
+var transientErrors = []string{
+	"bad gateway",
+	"broken pipe",
+	"cannot connect to the docker daemon",
+	"client.timeout exceeded",
+	"connection closed by",
+	"connection refused",
+	"connection reset",
+	"connection timed out",
+	"gateway timeout",
+	"i/o timeout",
+	"kex_exchange_identification",
+	"service unavailable",
+	"temporary failure in name resolution",
+	"tls handshake timeout",
+	"too many requests",
+	"unexpected eof",
+}
+
+// isTransient reports whether the command failed with one of
+// transientErrors on stderr.
+func isTransient(err error) bool {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+	stderr := strings.ToLower(string(exitErr.Stderr))
+	for _, s := range transientErrors {
+		if strings.Contains(stderr, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// runCmdRetry is runCmd for idempotent commands, like inspecting an image or
+// reading state in the container over SSH: a transient failure is retried
+// with exponential backoff, up to cmdRetries times.
+func runCmdRetry(ctx context.Context, dir string, args []string) (string, error) {
+	return runCmdEnvRetry(ctx, dir, args, nil)
+}
+
+// runCmdEnvRetry is runCmdRetry with env added to the environment.
+func runCmdEnvRetry(ctx context.Context, dir string, args, env []string) (string, error) {
+	delay := cmdRetryDelay
+	for retries := 0; ; retries++ {
+		out, err := runCmdEnv(ctx, dir, args, env)
+		if err == nil || retries == cmdRetries || !isTransient(err) || ctx.Err() != nil {
+			if retries > 0 {
+				slog.InfoContext(ctx, "md", "msg", "retried transient failures", "cmd", args[0], "retries", retries, "err", err)
+			}
+			return out, err
+		}
+		slog.DebugContext(ctx, "md", "msg", "transient failure, retrying", "cmd", args, "in", delay, "err", cmdErrWithStderr(args[0], err))
+		if err := sleepCtx(ctx, delay); err != nil {
+			return "", err
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunCmdRetry(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell")
+	}
+	oldRetries, oldDelay := cmdRetries, cmdRetryDelay
+	t.Cleanup(func() { cmdRetries, cmdRetryDelay = oldRetries, oldDelay })
+	cmdRetries, cmdRetryDelay = 3, time.Millisecond
+	ctx := t.Context()
+	// flaky fails with msg until its nth run.
+	flaky := func(n int, msg string) ([]string, func() string) {
+		counter := filepath.Join(t.TempDir(), "n")
+		script := `n=$(($(cat "$1" 2>/dev/null || echo 0)+1)); echo $n >"$1"; [ $n -ge ` + strconv.Itoa(n) + ` ] && echo ok && exit 0; echo "$2" >&2; exit 1`
+		return []string{"sh", "-c", script, "sh", counter, msg}, func() string {
+			b, _ := os.ReadFile(counter)
+			return strings.TrimSpace(string(b))
+		}
+	}
+	args, runs := flaky(3, "dial tcp 127.0.0.1:2375: connect: connection refused")
+	if out, err := runCmdRetry(ctx, "", args); err != nil || out != "ok" || runs() != "3" {
+		t.Errorf("transient: got %q, %v after %s runs", out, err, runs())
+	}
+	args, runs = flaky(9, "kex_exchange_identification: read: Connection reset by peer")
+	if _, err := runCmdRetry(ctx, "", args); err == nil || runs() != "4" {
+		t.Errorf("persistent: got %v after %s runs", err, runs())
+	}
+	args, runs = flaky(2, "Error: No such container: md-x")
+	if _, err := runCmdRetry(ctx, "", args); err == nil || runs() != "1" {
+		t.Errorf("permanent: got %v after %s runs", err, runs())
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/caic-xyz/md/gitutil"
+	"github.com/maruel/genai"
+)
+
+// Files of a review bundle.
+const (
+	ReviewDiffFile     = "diff.patch"
+	ReviewSummaryFile  = "summary.md"
+	ReviewTestsFile    = "tests.txt"
+	ReviewMetadataFile = "metadata.json"
+)
+
+// ReviewOpts configures [Container.ExportReview].
+type ReviewOpts struct {
+	// TestCommand is a shell command run in the repository whose output and
+	// exit code are recorded. Empty skips tests.
+	TestCommand string
+	// Provider generates the summary. Nil skips it.
+	Provider genai.Provider
+}
+
+// ReviewMetadata describes a review bundle. It is stored as metadata.json.
+type ReviewMetadata struct {
+	Container string    `json:"container"`
+	Repo      string    `json:"repo"`
+	Branch    string    `json:"branch"`
+	Time      time.Time `json:"time"`
+	// Base and Head are the commits the diff is between. Uncommitted changes
+	// are included in the diff on top of Head.
+	Base     string `json:"base"`
+	Head     string `json:"head"`
+	Diffstat string `json:"diffstat,omitempty"`
+	// TestCommand and TestExitCode are set when tests were run.
+	TestCommand  string `json:"test_command,omitempty"`
+	TestExitCode *int   `json:"test_exit_code,omitempty"`
+	// Files lists the bundle's files, metadata.json excluded.
+	Files []string `json:"files"`
+}
+
+// ReviewBundle is the material sent to a code review tool: the unified diff,
+// an AI summary, test results and metadata.
+type ReviewBundle struct {
+	Metadata ReviewMetadata
+	Diff     []byte
+	Summary  string
+	Tests    []byte
+}
+
+// ExportReview collects a review bundle for the changes in Repos[repoIdx]
+// since the container started. Uncommitted changes are staged in the
+// container, like [Container.Diff] does. The summary is best effort: it is
+// empty when opts.Provider is nil or fails.
+func (c *Container) ExportReview(ctx context.Context, repoIdx int, opts *ReviewOpts) (*ReviewBundle, error) {
+	if repoIdx < 0 || repoIdx >= len(c.Repos) {
+		return nil, fmt.Errorf("repo index %d out of range [0, %d)", repoIdx, len(c.Repos))
+	}
+	var diff, stderr bytes.Buffer
+	if err := c.Diff(ctx, &diff, &stderr, repoIdx, []string{"--no-color", "--no-ext-diff", "--binary"}); err != nil {
+		return nil, fmt.Errorf("diffing: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	if diff.Len() == 0 {
+		return nil, errors.New("no changes to review")
+	}
+	r := c.Repos[repoIdx]
+	ev := NewEvent("review", c)
+	c.Summarize(ctx, ev, repoIdx, opts.Provider)
+	b := &ReviewBundle{
+		Metadata: ReviewMetadata{
+			Container: c.Name,
+			Repo:      r.Name(),
+			Branch:    r.Branch,
+			Time:      ev.Time,
+			Diffstat:  ev.Diffstat,
+			Files:     []string{ReviewDiffFile},
+		},
+		Diff:    diff.Bytes(),
+		Summary: ev.Summary,
+	}
+	cd := "cd ~/src/" + shellQuote(r.Name()) + " && "
+	b.Metadata.Base, _ = runCmdRetry(ctx, "", c.SSHCommand(c.Name, cd+"git rev-parse base"))
+	b.Metadata.Head, _ = runCmdRetry(ctx, "", c.SSHCommand(c.Name, cd+"git rev-parse HEAD"))
+	if b.Summary != "" {
+		b.Metadata.Files = append(b.Metadata.Files, ReviewSummaryFile)
+	}
+	if opts.TestCommand != "" {
+		var out bytes.Buffer
+		code, err := c.Exec(ctx, nil, &out, &out, []string{cd + opts.TestCommand}, false)
+		if err != nil {
+			return nil, fmt.Errorf("running tests: %w", err)
+		}
+		b.Tests = out.Bytes()
+		b.Metadata.TestCommand = opts.TestCommand
+		b.Metadata.TestExitCode = &code
+		b.Metadata.Files = append(b.Metadata.Files, ReviewTestsFile)
+	}
+	return b, nil
+}
+
+// files returns the bundle's files in a stable order, metadata.json last.
+func (b *ReviewBundle) files() (map[string][]byte, []string, error) {
+	meta, err := json.MarshalIndent(&b.Metadata, "", "  ")
+	if err != nil {
+		return nil, nil, err
+	}
+	content := map[string][]byte{
+		ReviewDiffFile:     b.Diff,
+		ReviewSummaryFile:  []byte(b.Summary),
+		ReviewTestsFile:    b.Tests,
+		ReviewMetadataFile: append(meta, '\n'),
+	}
+	return content, slices.Concat(b.Metadata.Files, []string{ReviewMetadataFile}), nil
+}
+
+// WriteDir writes the bundle's files in dir, creating it if needed.
+func (b *ReviewBundle) WriteDir(dir string) error {
+	content, names, err := b.files()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), content[name], 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteTarball writes the bundle as a gzipped tarball whose files are in the
+// directory prefix.
+func (b *ReviewBundle) WriteTarball(w io.Writer, prefix string) error {
+	content, names, err := b.files()
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, name := range names {
+		hdr := &tar.Header{Name: prefix + "/" + name, Mode: 0o644, Size: int64(len(content[name])), ModTime: b.Metadata.Time}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(content[name]); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Review asks p to review the changes in Repos[repoIdx] against what opts
+// selects, base by default, uncommitted changes included like
+// [Container.Diff]. opts may be nil.
+func (c *Container) Review(ctx context.Context, repoIdx int, opts *DiffOpts, p genai.Provider) ([]gitutil.ReviewFinding, error) {
+	if opts == nil {
+		opts = &DiffOpts{}
+	}
+	if p == nil {
+		return nil, errors.New("no AI provider available: set ASK_PROVIDER")
+	}
+	if repoIdx < 0 || repoIdx >= len(c.Repos) {
+		return nil, fmt.Errorf("repo index %d out of range [0, %d)", repoIdx, len(c.Repos))
+	}
+	var diff, stderr bytes.Buffer
+	if err := c.DiffWith(ctx, &diff, &stderr, repoIdx, opts, []string{"--no-color", "--no-ext-diff", "--patience", "-U10"}); err != nil {
+		return nil, fmt.Errorf("diffing: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	if diff.Len() == 0 {
+		return nil, errors.New("no changes to review")
+	}
+	return gitutil.GenerateReview(ctx, p, c.gatherGitMetadata(ctx, c.Name, c.Repos[repoIdx].Name()), diff.String())
+}
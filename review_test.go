@@ -0,0 +1,92 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestReviewBundle(t *testing.T) {
+	code := 1
+	b := &ReviewBundle{
+		Metadata: ReviewMetadata{
+			Container:    "md-repo-main",
+			Repo:         "repo",
+			Branch:       "main",
+			Time:         time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+			TestCommand:  "go test ./...",
+			TestExitCode: &code,
+			Files:        []string{ReviewDiffFile, ReviewTestsFile},
+		},
+		Diff:  []byte("diff --git a/x b/x\n"),
+		Tests: []byte("FAIL\n"),
+	}
+	want := []string{ReviewDiffFile, ReviewTestsFile, ReviewMetadataFile}
+
+	dir := t.TempDir()
+	if err := b.WriteDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Name())
+	}
+	if !slices.Equal(got, slices.Sorted(slices.Values(want))) {
+		t.Errorf("WriteDir wrote %v", got)
+	}
+	raw, err := os.ReadFile(filepath.Join(dir, ReviewMetadataFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var meta ReviewMetadata
+	if err := json.Unmarshal(raw, &meta); err != nil {
+		t.Fatal(err)
+	}
+	if meta.TestExitCode == nil || *meta.TestExitCode != 1 || meta.Container != "md-repo-main" {
+		t.Errorf("metadata = %+v", meta)
+	}
+
+	var buf bytes.Buffer
+	if err := b.WriteTarball(&buf, "review"); err != nil {
+		t.Fatal(err)
+	}
+	gz, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	got = nil
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, hdr.Name)
+		if hdr.Name == "review/"+ReviewDiffFile {
+			if d, _ := io.ReadAll(tr); !bytes.Equal(d, b.Diff) {
+				t.Errorf("diff = %q", d)
+			}
+		}
+	}
+	if !slices.Equal(got, []string{"review/" + want[0], "review/" + want[1], "review/" + want[2]}) {
+		t.Errorf("WriteTarball wrote %v", got)
+	}
+}
@@ -0,0 +1,50 @@
+#!/bin/bash
+# Start a Chrome/Chromium instance with the DevTools protocol exposed on port
+# 9222 - runs during container startup when MD_BROWSER is set.
+#
+# Chrome only binds its DevTools port to loopback, so the browser listens on
+# 127.0.0.1:9223 and socat forwards 0.0.0.0:9222 to it. The browser runs
+# headed on display :1 when MD_DISPLAY is set, headless otherwise. It is
+# restarted if it dies.
+
+set -eu
+
+LOGFILE="/var/log/browser.log"
+
+log() {
+	echo "[browser-start] $*" | tee -a "$LOGFILE"
+}
+
+: >"$LOGFILE"
+chmod 666 "$LOGFILE"
+
+browser=""
+for b in google-chrome chromium; do
+	if command -v "$b" >/dev/null 2>&1; then
+		browser="$b"
+		break
+	fi
+done
+if [ -z "$browser" ]; then
+	log "ERROR: neither google-chrome nor chromium is installed"
+	exit 0
+fi
+
+flags="--remote-debugging-port=9223 --user-data-dir=/home/user/.cache/md-browser --no-first-run --no-default-browser-check"
+if [ -n "${MD_DISPLAY:-}" ]; then
+	env="DISPLAY=:1"
+else
+	env=""
+	flags="$flags --headless=new"
+fi
+
+socat TCP-LISTEN:9222,fork,reuseaddr TCP:127.0.0.1:9223 >>"$LOGFILE" 2>&1 &
+
+(
+	while true; do
+		log "Starting $browser with DevTools on port 9222"
+		su - user -c "$env $browser $flags about:blank" </dev/null >>"$LOGFILE" 2>&1 || true
+		log "$browser exited, restarting"
+		sleep 1
+	done
+) &
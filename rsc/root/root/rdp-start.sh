@@ -0,0 +1,54 @@
+#!/bin/bash
+# Start xrdp as a gateway to the VNC display :1 - runs during container startup
+#
+# xrdp is configured with a single libvnc session that connects to the local
+# Xvnc server, so RDP clients land on the same XFCE desktop as VNC clients.
+# No xrdp-sesman is needed since no new X session is created.
+# When the display has a password (MD_VNC_AUTH), xrdp's login screen asks
+# for it instead of passing a fixed one.
+
+set -eu
+
+LOGFILE="/var/log/display-server.log"
+
+log() {
+	echo "[rdp-start] $*" | tee -a "$LOGFILE"
+}
+
+PASSWORD=na
+if [ -n "${MD_VNC_AUTH:-}" ]; then
+	PASSWORD=ask
+fi
+
+log "Writing /etc/xrdp/xrdp.ini"
+cat >/etc/xrdp/xrdp.ini <<INI
+[Globals]
+port=tcp://:3389
+security_layer=negotiate
+crypt_level=high
+certificate=
+key_file=
+autorun=md
+allow_channels=true
+max_bpp=32
+new_cursors=true
+fork=true
+
+[Logging]
+LogFile=/var/log/xrdp.log
+LogLevel=INFO
+EnableSyslog=false
+
+[md]
+name=md
+lib=libvnc.so
+ip=127.0.0.1
+port=5901
+username=na
+password=$PASSWORD
+INI
+
+rm -f /var/run/xrdp/xrdp.pid 2>/dev/null || true
+mkdir -p /var/run/xrdp
+log "Starting xrdp on port 3389..."
+xrdp
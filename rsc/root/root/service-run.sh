@@ -0,0 +1,55 @@
+#!/bin/bash
+# Supervise one md service: run it as user, append its output to
+# /var/log/md/services/<name>.log and record its state in
+# /run/md/services/<name>.status for md status. The service is restarted per
+# its restart policy (always, on-failure, never) with exponential backoff.
+# Runs as root - unkillable by user
+#
+# Usage: service-run.sh <name>
+
+set -u
+
+NAME="${1:?usage: service-run.sh <name>}"
+SCRIPT="/var/lib/md/services/$NAME.sh"
+LOGFILE="/var/log/md/services/$NAME.log"
+STATUS="/run/md/services/$NAME.status"
+
+echo $$ >"/run/md/services/$NAME.pid"
+touch "$LOGFILE"
+chmod 644 "$LOGFILE"
+
+log() {
+	echo "[service-run $NAME] $*" >>"$LOGFILE"
+}
+
+# status atomically replaces the status file with "key=value..." fields.
+status() {
+	echo "$* since=$(date +%s)" >"$STATUS.tmp"
+	mv "$STATUS.tmp" "$STATUS"
+}
+
+restarts=0
+delay=1
+while [ -f "$SCRIPT" ]; do
+	started=$(date +%s)
+	log "Starting"
+	su user -c "exec bash $SCRIPT" </dev/null >>"$LOGFILE" 2>&1 &
+	pid=$!
+	status "state=running pid=$pid restarts=$restarts"
+	code=0
+	wait "$pid" || code=$?
+	log "Exited with code $code"
+	policy=$(cat "/var/lib/md/services/$NAME.restart" 2>/dev/null || echo always)
+	if [ "$policy" = never ] || { [ "$policy" = on-failure ] && [ "$code" = 0 ]; }; then
+		status "state=exited exit=$code restarts=$restarts"
+		exit 0
+	fi
+	# A service that ran for a while gets a fresh backoff.
+	if [ $(($(date +%s) - started)) -ge 60 ]; then
+		delay=1
+	fi
+	status "state=backoff exit=$code restarts=$restarts"
+	sleep "$delay"
+	delay=$((delay * 2 > 30 ? 30 : delay * 2))
+	restarts=$((restarts + 1))
+done
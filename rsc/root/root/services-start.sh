@@ -0,0 +1,20 @@
+#!/bin/bash
+# Start a supervisor for each service declared in /var/lib/md/services (see
+# ServicesFile). Runs as root at container startup and when md installs the
+# services; services whose supervisor is still alive are left alone.
+
+set -eu
+
+mkdir -p /run/md/services /var/log/md/services
+chmod 755 /var/log/md /var/log/md/services
+
+for f in /var/lib/md/services/*.sh; do
+	[ -e "$f" ] || continue
+	name=$(basename "$f" .sh)
+	pidfile="/run/md/services/$name.pid"
+	if [ -f "$pidfile" ] && kill -0 "$(cat "$pidfile")" 2>/dev/null; then
+		continue
+	fi
+	echo "[services-start] Starting service $name"
+	setsid /root/service-run.sh "$name" </dev/null >/dev/null 2>&1 &
+done
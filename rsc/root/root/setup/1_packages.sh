@@ -34,11 +34,13 @@ apt-get install -qq -y --no-install-recommends \
 	flex \
 	fuse-overlayfs \
 	git \
+	git-lfs \
 	gperf \
 	gpg \
 	gradle \
 	imagemagick \
 	iproute2 \
+	iptables \
 	jq \
 	kmod \
 	less \
@@ -87,8 +89,10 @@ apt-get install -qq -y --no-install-recommends \
 	shared-mime-info \
 	shellcheck \
 	slirp4netns \
+	socat \
 	sqlite3 \
 	strace \
+	sudo \
 	tigervnc-standalone-server \
 	tigervnc-tools \
 	tigervnc-viewer \
@@ -99,6 +103,7 @@ apt-get install -qq -y --no-install-recommends \
 	whois \
 	xfce4 \
 	xfce4-terminal \
+	xrdp \
 	xvfb \
 	xxd \
 	zstd >/dev/null
@@ -107,6 +112,10 @@ apt-get install -qq -y --no-install-recommends \
 ARCH=$(dpkg-architecture -qDEB_HOST_MULTIARCH)
 update-alternatives --set "libblas.so.3-${ARCH}" "/usr/lib/${ARCH}/libopenblas.so.0"
 
+# Install the Git LFS filters for all users, so md's LFS objects are smudged
+# into the checkouts.
+git lfs install --system --skip-repo >/dev/null
+
 # Remove PEP 668 marker — pip install --user is safe and this is a container.
 rm -f /usr/lib/python3.*/EXTERNALLY-MANAGED
 
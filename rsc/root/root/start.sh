@@ -49,10 +49,20 @@ EOF
 if [ -n "${MD_DISPLAY:-}" ]; then
 	# Start Xvnc + XFCE with monitors (runs as root, unkillable by user)
 	/root/vnc-start.sh
+	if [ -n "${MD_RDP:-}" ]; then
+		# RDP gateway in front of the VNC display
+		/root/rdp-start.sh
+	fi
 else
 	echo "[start.sh] MD_DISPLAY not set, skipping X/VNC startup"
 fi
 
+# Start the DevTools-enabled browser if enabled (after the display so it can
+# run headed on :1)
+if [ -n "${MD_BROWSER:-}" ]; then
+	/root/browser-start.sh
+fi
+
 # Start Tailscale if enabled
 if [ -n "${MD_TAILSCALE:-}" ]; then
 	echo "[start.sh] Starting Tailscale..."
@@ -60,7 +70,7 @@ if [ -n "${MD_TAILSCALE:-}" ]; then
 	mkdir -p /dev/net
 	mknod /dev/net/tun c 10 200 2>/dev/null || true
 	chmod 600 /dev/net/tun
-	tailscaled --state=/var/lib/tailscale/tailscaled.state &
+	tailscaled --state=/var/lib/tailscale/tailscaled.state >>/var/log/tailscaled.log 2>&1 &
 	# Wait for tailscaled to be ready
 	for _ in $(seq 1 30); do
 		if tailscale status >/dev/null 2>&1; then
@@ -77,7 +87,9 @@ if [ -n "${MD_TAILSCALE:-}" ]; then
 		if [ -n "$ts_fqdn" ]; then
 			echo "Connected to $ts_fqdn" >/etc/motd
 			if [ -n "${MD_DISPLAY:-}" ]; then
-				echo "VNC: vnc://$ts_fqdn:5901" >>/etc/motd
+				for n in $(seq 1 "${MD_DISPLAYS:-1}"); do
+					echo "VNC :$n: vnc://$ts_fqdn:$((5900 + n))" >>/etc/motd
+				done
 			fi
 			echo "[start.sh] Tailscale connected: $ts_fqdn"
 		fi
@@ -114,7 +126,21 @@ if ! unshare --user true 2>/dev/null; then
 	echo "[start.sh] WARNING: nested user namespaces unavailable — rootless Podman will not work inside this container (host is likely using rootless Docker or rootless Podman)"
 fi
 
-# Start SSH server (after VNC so DISPLAY is available)
+# Restart user services installed by md (no-op on first boot: md installs and
+# starts them once the repos are pushed)
+/root/services-start.sh
+
+# With the host's network nothing is published: md picks a free port for
+# sshd and only the host can reach it.
+if [ -n "${MD_SSH_PORT:-}" ]; then
+	printf 'Port %s\nListenAddress 127.0.0.1\n' "$MD_SSH_PORT" >/etc/ssh/sshd_config.d/md-port.conf
+fi
+
+# Start SSH server (after VNC so DISPLAY is available). There is no syslog, so
+# sshd logs to a file for md logs --service sshd.
+if ! grep -q '^SSHD_OPTS=.*-E' /etc/default/ssh 2>/dev/null; then
+	echo 'SSHD_OPTS="-E /var/log/sshd.log"' >>/etc/default/ssh
+fi
 service ssh start
 
 sleep infinity
@@ -1,9 +1,19 @@
 #!/bin/bash
 # Start Xvnc and XFCE - runs synchronously during container startup
+#
+# MD_DISPLAYS sets the number of virtual displays (default 1). Display :N
+# listens on VNC port 5900+N. Only :1 runs the full XFCE session; extra
+# displays run a standalone window manager.
+# MD_DISPLAY_SIZE sets the geometry of every display (default 1920x1080).
+# MD_VNC_AUTH, set by md, protects every display with the password md writes
+# to /root/.vnc/md-password once the container runs; without it the displays
+# accept any connection.
 
 set -eu
 
 DISPLAY=":1"
+DISPLAYS="${MD_DISPLAYS:-1}"
+GEOMETRY="${MD_DISPLAY_SIZE:-1920x1080}"
 LOGFILE="/var/log/display-server.log"
 DISPLAY_FILE="/etc/profile.d/60-vnc-display.sh"
 
@@ -11,20 +21,41 @@ log() {
 	echo "[vnc-start] $*" | tee -a "$LOGFILE"
 }
 
-# Clean up any stale X locks/sockets
-rm -f /tmp/.X1-lock /tmp/.X11-unix/X1 2>/dev/null || true
-
 # Prepare log file
 : >"$LOGFILE"
 chmod 666 "$LOGFILE"
 
-# Start Xvnc
-log "Starting Xvnc on $DISPLAY (port 5901)..."
-Xvnc "$DISPLAY" -geometry 1920x1080 -depth 24 -SecurityTypes None -rfbport 5901 &
-# Wait for the X socket to appear instead of a fixed sleep.
-for _ in $(seq 1 50); do
-	[ -e /tmp/.X11-unix/X1 ] && break
-	sleep 0.1
+# md sends the password on every start, after the engine started the
+# container. The password file is root-only; xvnc-monitor.sh reuses it.
+SECURITY="-SecurityTypes None"
+if [ -n "${MD_VNC_AUTH:-}" ]; then
+	rm -f /root/.vnc/passwd
+	for _ in $(seq 1 300); do
+		[ -f /root/.vnc/md-password ] && break
+		sleep 0.1
+	done
+	if [ ! -f /root/.vnc/md-password ]; then
+		log "No VNC password received from md"
+		exit 1
+	fi
+	(umask 077 && vncpasswd -f </root/.vnc/md-password >/root/.vnc/passwd)
+	rm -f /root/.vnc/md-password
+	SECURITY="-SecurityTypes VncAuth -PasswordFile /root/.vnc/passwd"
+fi
+
+for n in $(seq 1 "$DISPLAYS"); do
+	# Clean up any stale X locks/sockets
+	rm -f "/tmp/.X$n-lock" "/tmp/.X11-unix/X$n" 2>/dev/null || true
+	log "Starting Xvnc on :$n (port $((5900 + n)), $GEOMETRY)..."
+	# shellcheck disable=SC2086 # SECURITY holds several arguments.
+	Xvnc ":$n" -geometry "$GEOMETRY" -depth 24 $SECURITY -rfbport "$((5900 + n))" &
+done
+# Wait for the X sockets to appear instead of a fixed sleep.
+for n in $(seq 1 "$DISPLAYS"); do
+	for _ in $(seq 1 50); do
+		[ -e "/tmp/.X11-unix/X$n" ] && break
+		sleep 0.1
+	done
 done
 
 # Write DISPLAY to profile.d
@@ -39,6 +70,15 @@ chmod 644 "$DISPLAY_FILE"
 log "Starting XFCE session as user..."
 su - user -c "DISPLAY=$DISPLAY startxfce4" </dev/null &
 
+# Extra displays get a window manager only; a second XFCE session would
+# fight the first one over the shared DBus session.
+for n in $(seq 2 "$DISPLAYS"); do
+	log "Starting window manager on :$n..."
+	su - user -c "DISPLAY=:$n xfwm4" </dev/null >>"$LOGFILE" 2>&1 &
+done
+
 log "VNC startup complete, starting monitors"
-/root/xvnc-monitor.sh &
+for n in $(seq 1 "$DISPLAYS"); do
+	/root/xvnc-monitor.sh "$n" &
+done
 /root/xfce-monitor.sh &
@@ -1,24 +1,34 @@
 #!/bin/bash
-# Monitor Xvnc, restart if it dies
+# Monitor Xvnc for one display, restart if it dies
 # Runs as root - unkillable by user
+#
+# Usage: xvnc-monitor.sh [display-number]
 
 set -eu
 
-DISPLAY=":1"
+N="${1:-1}"
+GEOMETRY="${MD_DISPLAY_SIZE:-1920x1080}"
 LOGFILE="/var/log/display-server.log"
 
 log() {
-	echo "[xvnc-monitor] $*" | tee -a "$LOGFILE"
+	echo "[xvnc-monitor :$N] $*" | tee -a "$LOGFILE"
 }
 
+# The password file is written by vnc-start.sh when MD_VNC_AUTH is set.
+SECURITY="-SecurityTypes None"
+if [ -f /root/.vnc/passwd ]; then
+	SECURITY="-SecurityTypes VncAuth -PasswordFile /root/.vnc/passwd"
+fi
+
 start_xvnc() {
-	rm -f /tmp/.X1-lock /tmp/.X11-unix/X1 2>/dev/null || true
-	Xvnc "$DISPLAY" -geometry 1920x1080 -depth 24 -SecurityTypes None -rfbport 5901 &
+	rm -f "/tmp/.X$N-lock" "/tmp/.X11-unix/X$N" 2>/dev/null || true
+	# shellcheck disable=SC2086 # SECURITY holds several arguments.
+	Xvnc ":$N" -geometry "$GEOMETRY" -depth 24 $SECURITY -rfbport "$((5900 + N))" &
 	echo $!
 }
 
 while true; do
-	pid=$(pgrep -x Xvnc || start_xvnc)
+	pid=$(pgrep -f "^Xvnc :$N " || start_xvnc)
 	log "Watching Xvnc (pid $pid)"
 	tail --pid="$pid" -f /dev/null 2>/dev/null || true
 	log "Xvnc died"
@@ -50,6 +50,7 @@
 	# Build Tools
 	check_version "awk" "awk" "--version"
 	check_version "Git" "git" "--version"
+	check_version "Git LFS" "git-lfs" "--version"
 	check_version "Make" "make" "--version"
 	check_version "Ninja" "ninja" "--version"
 	check_version "CMake" "cmake" "--version"
@@ -68,6 +69,7 @@
 	check_version "objdump" "objdump" "--version"
 	check_version "radare2" "r2" "-v"
 	check_version "strace" "strace" "-V"
+	check_version "sudo" "sudo" "--version"
 	check_version "yq" "yq" "--version"
 	check_version "bubblewrap" "bwrap" "--version"
 	check_version "Podman" "podman" "--version"
@@ -119,8 +121,11 @@
 	fi
 
 	# Network Tools
+	check_version "iptables" "iptables" "--version"
 	check_version "nmap" "nmap" "--version"
+	check_version "socat" "socat" "-V" "^socat version"
 	check_version "Tailscale" "tailscale" "version"
+	check_version "xrdp" "xrdp" "--version" "^xrdp"
 
 	# GitHub
 	check_version "GitHub CLI" "gh" "--version"
@@ -0,0 +1,273 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+)
+
+// States of an md run in its [RunRecord].
+const (
+	// RunRunning is a run whose md process is running the command.
+	RunRunning = "running"
+	// RunExited is a run whose command ended; ExitCode is its exit code.
+	RunExited = "exited"
+	// RunFailed is a run md failed to complete, e.g. to start the container;
+	// Error says why.
+	RunFailed = "failed"
+	// RunLost is a run whose md process ended without recording the result,
+	// e.g. killed with its terminal. Its container may be left behind.
+	RunLost = "lost"
+)
+
+// RunRecord is the status of an md run, persisted with its output by
+// [RunLog].
+type RunRecord struct {
+	ID      string   `json:"id"`
+	Command []string `json:"command"`
+	// Container is the temporary container running the command.
+	Container string `json:"container"`
+	// PID is the md process running it.
+	PID      int    `json:"pid"`
+	Detached bool   `json:"detached,omitempty"`
+	State    string `json:"state"`
+	// Started and Ended are in UTC; Ended is zero while running.
+	Started  time.Time `json:"started"`
+	Ended    time.Time `json:"ended,omitzero"`
+	ExitCode int       `json:"exit_code"`
+	// Branch has the command's changes, with md run --commit-results.
+	Branch string `json:"branch,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// RunsDir returns the directory holding a directory per md run, with its
+// status.json and output.log.
+func RunsDir(xdgStateHome string) string {
+	return filepath.Join(xdgStateHome, "md", "runs")
+}
+
+// NewRunID returns a random ID for an md run.
+func NewRunID() string {
+	var b [4]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+var runIDRe = regexp.MustCompile(`^[0-9a-f]{8}$`)
+
+// runDir returns the directory of run id.
+func runDir(xdgStateHome, id string) (string, error) {
+	if !runIDRe.MatchString(id) {
+		return "", fmt.Errorf("invalid run ID %q", id)
+	}
+	return filepath.Join(RunsDir(xdgStateHome), id), nil
+}
+
+// RunLog persists the status and output of an md run.
+type RunLog struct {
+	dir string
+
+	mu  sync.Mutex
+	rec RunRecord
+}
+
+// CreateRunLog records the start of the run rec, in the running state.
+func CreateRunLog(xdgStateHome string, rec *RunRecord) (*RunLog, error) {
+	dir, err := runDir(xdgStateHome, rec.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(RunsDir(xdgStateHome), 0o700); err != nil {
+		return nil, err
+	}
+	if err := os.Mkdir(dir, 0o700); err != nil {
+		return nil, err
+	}
+	l := &RunLog{dir: dir, rec: *rec}
+	l.rec.State = RunRunning
+	l.rec.Started = time.Now().UTC().Truncate(time.Second)
+	if err := os.WriteFile(l.OutputPath(), nil, 0o600); err != nil {
+		return nil, err
+	}
+	if err := l.write(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// OpenRunLog opens the record of run id to update it, e.g. from the md
+// process of a detached run.
+func OpenRunLog(xdgStateHome, id string) (*RunLog, error) {
+	dir, err := runDir(xdgStateHome, id)
+	if err != nil {
+		return nil, err
+	}
+	rec, err := readRunRecord(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &RunLog{dir: dir, rec: *rec}, nil
+}
+
+// ID returns the run's ID.
+func (l *RunLog) ID() string {
+	return l.rec.ID
+}
+
+// OutputPath returns the file the run's output is written to.
+func (l *RunLog) OutputPath() string {
+	return filepath.Join(l.dir, "output.log")
+}
+
+// Update applies f to the record and saves it. The record is reread first
+// as the md process starting a detached run and the one running it both
+// update it.
+func (l *RunLog) Update(f func(*RunRecord)) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if b, err := os.ReadFile(filepath.Join(l.dir, "status.json")); err == nil {
+		_ = json.Unmarshal(b, &l.rec)
+	}
+	f(&l.rec)
+	return l.write()
+}
+
+// Finish records the end of the run: res's exit code and branch, or err.
+func (l *RunLog) Finish(res *RunResult, err error) error {
+	return l.Update(func(r *RunRecord) {
+		r.Ended = time.Now().UTC().Truncate(time.Second)
+		if err != nil {
+			r.State, r.Error = RunFailed, err.Error()
+			return
+		}
+		r.State, r.ExitCode, r.Branch = RunExited, res.ExitCode, res.Branch
+	})
+}
+
+// write saves the record atomically, as readers poll it.
+func (l *RunLog) write() error {
+	b, err := json.Marshal(&l.rec)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(l.dir, "status.json.*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filepath.Join(l.dir, "status.json"))
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
+
+func readRunRecord(dir string) (*RunRecord, error) {
+	b, err := os.ReadFile(filepath.Join(dir, "status.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no run %s", filepath.Base(dir))
+	}
+	if err != nil {
+		return nil, err
+	}
+	rec := &RunRecord{}
+	if err := json.Unmarshal(b, rec); err != nil {
+		return nil, fmt.Errorf("run %s: %w", filepath.Base(dir), err)
+	}
+	// A detached run's PID is unknown until its md process starts.
+	if rec.State == RunRunning && rec.PID != 0 && !processAlive(rec.PID) {
+		rec.State = RunLost
+	}
+	return rec, nil
+}
+
+// ReadRun returns the record of run id. A run whose md process is gone
+// without recording its end is [RunLost].
+func ReadRun(xdgStateHome, id string) (*RunRecord, error) {
+	dir, err := runDir(xdgStateHome, id)
+	if err != nil {
+		return nil, err
+	}
+	return readRunRecord(dir)
+}
+
+// ListRuns returns the records of the md runs, oldest first.
+func ListRuns(xdgStateHome string) ([]*RunRecord, error) {
+	entries, err := os.ReadDir(RunsDir(xdgStateHome))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var recs []*RunRecord
+	for _, e := range entries {
+		if !e.IsDir() || !runIDRe.MatchString(e.Name()) {
+			continue
+		}
+		rec, err := readRunRecord(filepath.Join(RunsDir(xdgStateHome), e.Name()))
+		if err != nil {
+			// Being created.
+			continue
+		}
+		recs = append(recs, rec)
+	}
+	slices.SortStableFunc(recs, func(a, b *RunRecord) int { return a.Started.Compare(b.Started) })
+	return recs, nil
+}
+
+// runPollInterval is how often [FollowRun] checks for new output.
+const runPollInterval = 250 * time.Millisecond
+
+// FollowRun copies the output of run id to w. With follow, it keeps copying
+// until the run ends. It returns the run's record, at its end with follow.
+func FollowRun(ctx context.Context, xdgStateHome, id string, w io.Writer, follow bool) (*RunRecord, error) {
+	dir, err := runDir(xdgStateHome, id)
+	if err != nil {
+		return nil, err
+	}
+	rec, err := readRunRecord(dir)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filepath.Join(dir, "output.log"))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	for {
+		// Read the state first so the output of a run that just ended is
+		// copied to its end.
+		if rec, err = readRunRecord(dir); err != nil {
+			return nil, err
+		}
+		if _, err := io.Copy(w, f); err != nil {
+			return nil, err
+		}
+		if !follow || rec.State != RunRunning {
+			return rec, nil
+		}
+		if err := sleepCtx(ctx, runPollInterval); err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunLog(t *testing.T) {
+	ctx := t.Context()
+	state := t.TempDir()
+	id := NewRunID()
+	l, err := CreateRunLog(state, &RunRecord{ID: id, Command: []string{"make", "test"}, Container: "md-r-run-" + id, PID: os.Getpid()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreateRunLog(state, &RunRecord{ID: id}); err == nil {
+		t.Error("created twice")
+	}
+	if err := os.WriteFile(l.OutputPath(), []byte("building\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	rec, err := ReadRun(state, id)
+	if err != nil || rec.State != RunRunning || rec.Started.IsZero() {
+		t.Fatalf("got %+v, %v", rec, err)
+	}
+
+	done := make(chan struct{})
+	var b strings.Builder
+	go func() {
+		defer close(done)
+		if rec, err = FollowRun(ctx, state, id, &b, true); err != nil {
+			t.Error(err)
+		}
+	}()
+	time.Sleep(2 * runPollInterval)
+	f, err := os.OpenFile(l.OutputPath(), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString("ok\n")
+	_ = f.Close()
+	if err := l.Finish(&RunResult{ExitCode: 2, Branch: "results"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if b.String() != "building\nok\n" || rec.State != RunExited || rec.ExitCode != 2 || rec.Branch != "results" || rec.Ended.IsZero() {
+		t.Errorf("followed %q, %+v", b.String(), rec)
+	}
+
+	// A run whose md process is gone is lost.
+	cmd := exec.CommandContext(ctx, "go", "version")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	lost := NewRunID()
+	if _, err := CreateRunLog(state, &RunRecord{ID: lost, PID: cmd.Process.Pid}); err != nil {
+		t.Fatal(err)
+	}
+	failed := NewRunID()
+	l, err = CreateRunLog(state, &RunRecord{ID: failed, PID: os.Getpid()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Finish(nil, errors.New("no image")); err != nil {
+		t.Fatal(err)
+	}
+	recs, err := ListRuns(state)
+	if err != nil || len(recs) != 3 {
+		t.Fatalf("got %d runs, %v", len(recs), err)
+	}
+	states := map[string]string{}
+	for _, r := range recs {
+		states[r.ID] = r.State + " " + r.Error
+	}
+	if states[id] != "exited " || states[lost] != "lost " || states[failed] != "failed no image" {
+		t.Errorf("states %v", states)
+	}
+
+	if _, err := ReadRun(state, "../x"); err == nil {
+		t.Error("invalid ID accepted")
+	}
+	if _, err := ReadRun(state, "0123abcd"); err == nil || !strings.Contains(err.Error(), "no run") {
+		t.Errorf("got %v", err)
+	}
+}
@@ -0,0 +1,299 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ServicesFile is the path, relative to the primary repository root, of the
+// JSON file declaring [Service] entries started in the container:
+//
+//	{"services": [{"name": "watch", "command": "npm run watch", "dir": "web"}]}
+const ServicesFile = ".md/services.json"
+
+// Service is a long-running user process (file watcher, LSP server, test
+// daemon) started and supervised by the container's init. It runs as user
+// through bash, so the PATH and ~/.env set up for SSH sessions apply.
+type Service struct {
+	// Name identifies the service in md status and md logs --service. It must
+	// match [a-z0-9][a-z0-9_-]*.
+	Name string `json:"name"`
+	// Command is the shell command line to run.
+	Command string `json:"command"`
+	// Dir is the working directory relative to the primary repository root in
+	// the container. Defaults to the repository root.
+	Dir string `json:"dir,omitempty"`
+	// Restart is "always" (default), "on-failure" or "never".
+	Restart string `json:"restart,omitempty"`
+}
+
+// ServiceStatus is a service's state as reported by its supervisor.
+type ServiceStatus struct {
+	Name string `json:"name"`
+	// State is "starting", "running", "backoff" (exited, waiting to be
+	// restarted) or "exited" (won't be restarted per its restart policy).
+	State string `json:"state"`
+	// PID is the process ID in the container while running.
+	PID int `json:"pid,omitempty"`
+	// ExitCode is the last exit code, when the service has exited at least
+	// once.
+	ExitCode int `json:"exit_code,omitempty"`
+	// Restarts counts how many times the supervisor restarted the service.
+	Restarts int `json:"restarts"`
+	// Since is when the service entered State.
+	Since time.Time `json:"since"`
+}
+
+var serviceNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// LoadServices reads and validates [ServicesFile] in gitRoot. A missing file
+// yields no services.
+func LoadServices(gitRoot string) ([]Service, error) {
+	data, err := os.ReadFile(filepath.Join(gitRoot, filepath.FromSlash(ServicesFile)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var cfg struct {
+		Services []Service `json:"services"`
+	}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", ServicesFile, err)
+	}
+	if err := validateServices(cfg.Services); err != nil {
+		return nil, fmt.Errorf("%s: %w", ServicesFile, err)
+	}
+	return cfg.Services, nil
+}
+
+func validateServices(services []Service) error {
+	seen := make(map[string]struct{}, len(services))
+	for _, s := range services {
+		if !serviceNameRe.MatchString(s.Name) {
+			return fmt.Errorf("invalid service name %q", s.Name)
+		}
+		if _, ok := seen[s.Name]; ok {
+			return fmt.Errorf("duplicate service %q", s.Name)
+		}
+		if _, ok := SystemServiceLogs[s.Name]; ok {
+			return fmt.Errorf("service name %q is reserved by md", s.Name)
+		}
+		seen[s.Name] = struct{}{}
+		if strings.TrimSpace(s.Command) == "" {
+			return fmt.Errorf("service %q: command is required", s.Name)
+		}
+		if s.Dir != "" && (path.IsAbs(s.Dir) || path.Clean(s.Dir) == ".." || strings.HasPrefix(path.Clean(s.Dir), "../")) {
+			return fmt.Errorf("service %q: dir must be relative to the repository", s.Name)
+		}
+		switch s.Restart {
+		case "", "always", "on-failure", "never":
+		default:
+			return fmt.Errorf("service %q: restart must be always, on-failure or never", s.Name)
+		}
+	}
+	return nil
+}
+
+// serviceScript returns the bash script the supervisor runs for s, with repo
+// being the primary repository's directory name.
+func serviceScript(s Service, repo string) string {
+	dir := path.Join("/home/user/src", repo, s.Dir)
+	return "# md service " + s.Name + "\ncd " + shellQuote(dir) + " || exit 1\n" + s.Command + "\n"
+}
+
+// servicesTar builds the archive extracted in /var/lib/md/services: one
+// <name>.sh script and one <name>.restart policy file per service.
+func servicesTar(services []Service, repo string) ([]byte, error) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	now := time.Now()
+	add := func(name, content string) error {
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0o644, Size: int64(len(content)), ModTime: now}); err != nil {
+			return err
+		}
+		_, err := io.WriteString(tw, content)
+		return err
+	}
+	for _, s := range services {
+		restart := s.Restart
+		if restart == "" {
+			restart = "always"
+		}
+		if err := add(s.Name+".sh", serviceScript(s, repo)); err != nil {
+			return nil, err
+		}
+		if err := add(s.Name+".restart", restart+"\n"); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// startServices installs the service definitions in the container and starts
+// their supervisors. The definitions persist in the container so init
+// restarts the services when the container is revived.
+func startServices(ctx context.Context, rt, name string, services []Service, repo string) error {
+	data, err := servicesTar(services, repo)
+	if err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, rt, "exec", "-i", "-u", "root", name, "sh", "-c",
+		"rm -rf /var/lib/md/services && mkdir -p /var/lib/md/services && tar -x -C /var/lib/md/services")
+	cmd.Stdin = bytes.NewReader(data)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("installing services: %w\n%s", err, out)
+	}
+	if _, err := runCmd(ctx, "", []string{rt, "exec", "-u", "root", name, "/root/services-start.sh"}); err != nil {
+		return fmt.Errorf("starting services: %w", err)
+	}
+	return nil
+}
+
+// Services returns the status of the services supervised in the container,
+// sorted by name.
+func (c *Container) Services(ctx context.Context) ([]ServiceStatus, error) {
+	if c.Kube != nil {
+		// Pods don't run services.
+		return nil, nil
+	}
+	out, err := runCmd(ctx, "", []string{c.Runtime, "exec", c.Name, "sh", "-c",
+		`for f in /var/lib/md/services/*.sh; do [ -e "$f" ] || continue; n=$(basename "$f" .sh); echo "$n $(cat "/run/md/services/$n.status" 2>/dev/null)"; done`})
+	if err != nil {
+		return nil, fmt.Errorf("querying services: %w", err)
+	}
+	return parseServiceStatus(out), nil
+}
+
+// parseServiceStatus parses lines of "<name> key=value..." written by
+// service-run.sh. A service without status fields is reported as starting.
+func parseServiceStatus(out string) []ServiceStatus {
+	var statuses []ServiceStatus
+	for line := range strings.SplitSeq(out, "\n") {
+		f := strings.Fields(line)
+		if len(f) == 0 {
+			continue
+		}
+		s := ServiceStatus{Name: f[0], State: "starting"}
+		for _, kv := range f[1:] {
+			k, v, _ := strings.Cut(kv, "=")
+			switch k {
+			case "state":
+				s.State = v
+			case "pid":
+				s.PID, _ = strconv.Atoi(v)
+			case "exit":
+				s.ExitCode, _ = strconv.Atoi(v)
+			case "restarts":
+				s.Restarts, _ = strconv.Atoi(v)
+			case "since":
+				if sec, err := strconv.ParseInt(v, 10, 64); err == nil {
+					s.Since = time.Unix(sec, 0)
+				}
+			}
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses
+}
+
+// SystemServiceLogs maps the services md itself runs in the container to
+// their log file. [Container.Logs] accepts these names besides the user
+// services, which can't reuse them.
+var SystemServiceLogs = map[string]string{
+	"browser":    "/var/log/browser.log",
+	"rdp":        "/var/log/xrdp.log",
+	"sshd":       "/var/log/sshd.log",
+	"tailscaled": "/var/log/tailscaled.log",
+	"xvnc":       "/var/log/display-server.log",
+}
+
+// LogsOpts configures [Container.Logs].
+type LogsOpts struct {
+	// Service selects a user service or one of [SystemServiceLogs]. Empty
+	// selects the container's own output, i.e. its startup script.
+	Service string
+	// Lines is the number of trailing lines to show. Negative shows all.
+	Lines int
+	// Since only shows the container output newer than a duration (e.g.
+	// "10m") or timestamp, as accepted by docker logs. Service logs have no
+	// timestamps so it can't be used with Service.
+	Since string
+	// Follow keeps streaming until ctx is canceled.
+	Follow bool
+}
+
+// Logs writes the container's output, or a service's log, to stdout. The
+// container's output is available even when it is stopped, e.g. after a
+// failed startup.
+func (c *Container) Logs(ctx context.Context, stdout, stderr io.Writer, opts *LogsOpts) error {
+	lines := "all"
+	if opts.Lines >= 0 {
+		lines = strconv.Itoa(opts.Lines)
+	}
+	if opts.Service == "" {
+		args := []string{c.Runtime, "logs", "--tail", lines}
+		name := c.Name
+		if c.Kube != nil {
+			if lines == "all" {
+				lines = "-1"
+			}
+			args = c.Kube.kubectl("logs", "--tail", lines)
+			name = podName(c.Name)
+		}
+		if opts.Since != "" {
+			args = append(args, "--since", opts.Since)
+		}
+		if opts.Follow {
+			args = append(args, "--follow")
+		}
+		return runCmdOut(ctx, "", append(args, name), stdout, stderr)
+	}
+	if c.Kube != nil {
+		return fmt.Errorf("%s runs on Kubernetes: only its output is available", c.Name)
+	}
+	if opts.Since != "" {
+		return errors.New("since only applies to the container output, not to service logs")
+	}
+	logFile, ok := SystemServiceLogs[opts.Service]
+	if !ok {
+		if !serviceNameRe.MatchString(opts.Service) {
+			return fmt.Errorf("invalid service name %q", opts.Service)
+		}
+		if _, err := runCmd(ctx, "", []string{c.Runtime, "exec", c.Name, "test", "-e", "/var/lib/md/services/" + opts.Service + ".sh"}); err != nil {
+			return fmt.Errorf("no service %q in %s", opts.Service, c.Name)
+		}
+		logFile = "/var/log/md/services/" + opts.Service + ".log"
+	}
+	if lines == "all" {
+		lines = "+1"
+	}
+	args := []string{c.Runtime, "exec", c.Name, "tail", "-n", lines}
+	if opts.Follow {
+		args = append(args, "-F")
+	}
+	return runCmdOut(ctx, "", append(args, logFile), stdout, stderr)
+}
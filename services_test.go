@@ -0,0 +1,90 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadServices(t *testing.T) {
+	write := func(t *testing.T, content string) string {
+		dir := t.TempDir()
+		if err := os.MkdirAll(filepath.Join(dir, ".md"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, ServicesFile), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return dir
+	}
+	t.Run("missing", func(t *testing.T) {
+		s, err := LoadServices(t.TempDir())
+		if err != nil || s != nil {
+			t.Fatalf("got %v, %v", s, err)
+		}
+	})
+	t.Run("valid", func(t *testing.T) {
+		dir := write(t, `{"services": [{"name": "watch", "command": "npm run watch", "dir": "web", "restart": "on-failure"}, {"name": "lsp", "command": "gopls serve"}]}`)
+		s, err := LoadServices(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(s) != 2 || s[0].Name != "watch" || s[0].Dir != "web" || s[1].Restart != "" {
+			t.Errorf("got %+v", s)
+		}
+	})
+	for name, content := range map[string]string{
+		"bad_json":      `{`,
+		"unknown_field": `{"services": [{"name": "a", "command": "x", "cmd": "y"}]}`,
+		"bad_name":      `{"services": [{"name": "A b", "command": "x"}]}`,
+		"duplicate":     `{"services": [{"name": "a", "command": "x"}, {"name": "a", "command": "y"}]}`,
+		"reserved":      `{"services": [{"name": "sshd", "command": "x"}]}`,
+		"no_command":    `{"services": [{"name": "a", "command": " "}]}`,
+		"abs_dir":       `{"services": [{"name": "a", "command": "x", "dir": "/etc"}]}`,
+		"escape_dir":    `{"services": [{"name": "a", "command": "x", "dir": "web/../.."}]}`,
+		"bad_restart":   `{"services": [{"name": "a", "command": "x", "restart": "sometimes"}]}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := LoadServices(write(t, content)); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestServiceScript(t *testing.T) {
+	got := serviceScript(Service{Name: "watch", Command: "npm run watch", Dir: "web app"}, "repo")
+	want := "# md service watch\ncd '/home/user/src/repo/web app' || exit 1\nnpm run watch\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestParseServiceStatus(t *testing.T) {
+	out := "lsp state=running pid=42 restarts=0 since=1700000000\n" +
+		"new\n" +
+		"watch state=backoff exit=2 restarts=3 since=1700000100\n"
+	got := parseServiceStatus(out)
+	want := []ServiceStatus{
+		{Name: "lsp", State: "running", PID: 42, Since: time.Unix(1700000000, 0)},
+		{Name: "new", State: "starting"},
+		{Name: "watch", State: "backoff", ExitCode: 2, Restarts: 3, Since: time.Unix(1700000100, 0)},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %+v", got)
+	}
+	for i := range want {
+		if !got[i].Since.Equal(want[i].Since) {
+			t.Errorf("[%d] Since = %v, want %v", i, got[i].Since, want[i].Since)
+		}
+		got[i].Since, want[i].Since = time.Time{}, time.Time{}
+		if got[i] != want[i] {
+			t.Errorf("[%d] got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// SetupScript is the path, relative to a repository root, of the script md
+// start runs in the container once the repository is there, e.g. to install
+// its dependencies before the agent connects. It is read from the host
+// checkout, so it needn't be committed, and run with its shebang.
+const SetupScript = ".md/setup.sh"
+
+// setupStep is a repository's provisioning: a script copied into the
+// container or a command.
+type setupStep struct {
+	repoIdx int
+	script  []byte
+	command string
+}
+
+// setupSteps returns what to run for each repository: command for the
+// primary one when set, their SetupScript otherwise.
+func setupSteps(repos []Repo, command string) ([]setupStep, error) {
+	var steps []setupStep
+	for i := range repos {
+		if i == 0 && command != "" {
+			steps = append(steps, setupStep{repoIdx: i, command: command})
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(repos[i].GitRoot, filepath.FromSlash(SetupScript)))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		steps = append(steps, setupStep{repoIdx: i, script: b})
+	}
+	return steps, nil
+}
+
+// setupShell returns the shell command running s in the container, its
+// script being on stdin.
+func (c *Container) setupShell(s *setupStep) string {
+	prefix := exportEnv(c.hookEnv("setup", s.repoIdx)) + " && cd ~/src/" + shellQuote(c.Repos[s.repoIdx].Name())
+	if s.command != "" {
+		return prefix + " && {\n" + s.command + "\n}"
+	}
+	// The script comes on stdin: copy it so its shebang applies.
+	return prefix + ` && f=$(mktemp) && cat > "$f" && chmod +x "$f" && { "$f"; rc=$?; rm -f "$f"; exit $rc; }`
+}
+
+// setup provisions the repositories in the container, streaming the output
+// unless quiet. The first failure aborts: the start fails rather than
+// leaving the agent in a half set up container.
+func (c *Container) setup(ctx context.Context, stdout, stderr io.Writer, opts *StartOpts) error {
+	if opts.NoSetup || len(c.Repos) == 0 {
+		return nil
+	}
+	steps, err := setupSteps(c.Repos, opts.Setup)
+	if err != nil {
+		return err
+	}
+	for i := range steps {
+		s := &steps[i]
+		name := c.Repos[s.repoIdx].Name()
+		what := SetupScript
+		if s.command != "" {
+			what = s.command
+		}
+		var buf bytes.Buffer
+		out, errOut := stdout, stderr
+		if opts.Quiet {
+			out, errOut = &buf, &buf
+		} else {
+			_, _ = fmt.Fprintf(stdout, "- Running setup of %s: %s\n", name, what)
+		}
+		args := c.SSHCommand(c.Name, c.setupShell(s))
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Stdin = bytes.NewReader(s.script)
+		cmd.Stdout = out
+		cmd.Stderr = errOut
+		if err := cmd.Run(); err != nil {
+			err = fmt.Errorf("setup of %s (%s) failed: %w (md start --no-setup skips it)", name, what, err)
+			if buf.Len() > 0 {
+				err = fmt.Errorf("%w\n%s", err, strings.TrimSpace(buf.String()))
+			}
+			return err
+		}
+	}
+	return nil
+}
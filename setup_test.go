@@ -0,0 +1,79 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestSetupSteps(t *testing.T) {
+	dir := t.TempDir()
+	repos := []Repo{{GitRoot: filepath.Join(dir, "a")}, {GitRoot: filepath.Join(dir, "b")}, {GitRoot: filepath.Join(dir, "c")}}
+	for _, r := range repos[:2] {
+		if err := os.MkdirAll(filepath.Join(r.GitRoot, ".md"), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(r.GitRoot, filepath.FromSlash(SetupScript)), []byte("#!/bin/sh\necho "+filepath.Base(r.GitRoot)+"\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	steps, err := setupSteps(repos, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 2 || steps[0].repoIdx != 0 || steps[1].repoIdx != 1 || !strings.Contains(string(steps[1].script), "echo b") {
+		t.Errorf("got %+v", steps)
+	}
+	// The configured command replaces the primary repository's script.
+	steps, err = setupSteps(repos, "make deps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 2 || steps[0].command != "make deps" || steps[0].script != nil || steps[1].repoIdx != 1 {
+		t.Errorf("got %+v", steps)
+	}
+}
+
+func TestSetupShell(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell")
+	}
+	home := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(home, "src", "r"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	c := &Container{Name: "md-r-main", Repos: []Repo{{GitRoot: "/host/r", Branch: "main"}}}
+	run := func(s *setupStep) (string, int) {
+		cmd := exec.CommandContext(t.Context(), "sh", "-c", c.setupShell(s))
+		cmd.Env = append(os.Environ(), "HOME="+home, "TMPDIR="+home)
+		cmd.Stdin = bytes.NewReader(s.script)
+		out, err := cmd.CombinedOutput()
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return string(out), exitErr.ExitCode()
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		return string(out), 0
+	}
+	out, code := run(&setupStep{script: []byte("#!/bin/sh\necho \"$MD_REPO $MD_BRANCH $(basename \"$PWD\")\"\nexit 3\n")})
+	if out != "r main r\n" || code != 3 {
+		t.Errorf("got %q, %d", out, code)
+	}
+	// The copy of the script is removed.
+	if entries, _ := os.ReadDir(home); len(entries) != 1 {
+		t.Errorf("left %v", entries)
+	}
+	if out, code := run(&setupStep{command: "echo \"$MD_HOOK\" && pwd"}); out != "setup\n"+filepath.Join(home, "src", "r")+"\n" || code != 0 {
+		t.Errorf("got %q, %d", out, code)
+	}
+}
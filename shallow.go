@@ -0,0 +1,240 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/caic-xyz/md/gitutil"
+)
+
+// git push can't send a truncated or filtered history: the receiving side
+// would lack the parents and blobs the pushed commits refer to. So a shallow
+// or partial clone fetches from the host instead. For the duration of an SSH
+// session, the host serves the repository read-only over the git:// protocol
+// on a loopback port, which an SSH remote forward makes reachable in the
+// container. The same session lets git fetch the blobs a checkout needs
+// lazily from the "promisor" remote.
+
+// hostRemote is the remote of the container's shallow or partial clones
+// pointing to the host, reachable only during hostGit.
+const hostRemote = "md-host"
+
+// reCloneFilter matches the object filters md accepts for partial clones.
+var reCloneFilter = regexp.MustCompile(`^(blob:none|blob:limit=\d+[kmg]?|tree:0)$`)
+
+// maxPktLine is the largest pkt-line the git:// protocol allows.
+const maxPktLine = 65520
+
+// checkCloneOpts returns an error when opts asks for a shallow or partial
+// clone md can't make.
+func checkCloneOpts(opts *StartOpts) error {
+	if opts.CloneDepth < 0 {
+		return fmt.Errorf("invalid clone depth %d", opts.CloneDepth)
+	}
+	if opts.CloneFilter != "" && !reCloneFilter.MatchString(opts.CloneFilter) {
+		return fmt.Errorf("invalid clone filter %q: use blob:none, blob:limit=<size> or tree:0", opts.CloneFilter)
+	}
+	if (opts.CloneDepth > 0 || opts.CloneFilter != "") && opts.MountSource != SourceClone {
+		return errors.New("a shallow or partial clone needs the repositories cloned in the container, not mounted")
+	}
+	return nil
+}
+
+// fetchesFromHost reports whether the container's repositories are shallow
+// or partial clones, updated with hostFetchScript rather than git push.
+func (c *Container) fetchesFromHost() bool {
+	return c.CloneDepth > 0 || c.CloneFilter != ""
+}
+
+// hostCloneScript prepares the empty repository in the current directory to
+// fetch from hostRemote with filter, if any.
+func hostCloneScript(filter string) string {
+	if filter == "" {
+		return "true"
+	}
+	return "git config core.repositoryformatversion 1" +
+		" && git config extensions.partialClone " + hostRemote +
+		" && git config remote." + hostRemote + ".promisor true" +
+		" && git config remote." + hostRemote + ".partialCloneFilter " + shellQuote(filter)
+}
+
+// hostFetchScript fetches the host's ref into the branch dst of the
+// repository in the current directory, force-updating it, like md's pushes.
+// depth limits the history of a first fetch; later ones bring the commits
+// missing since the shallow boundary. Tags aren't fetched: they would drag
+// in their history.
+func hostFetchScript(ref, dst string, depth int) string {
+	s := "git fetch -q --no-tags"
+	if depth > 0 {
+		s += " --depth=" + strconv.Itoa(depth)
+	}
+	s += " " + hostRemote + " " + shellQuote("+"+ref+":refs/heads/"+dst)
+	if dst == "base" {
+		s = baseUpdateEnv + "=1 " + s
+	}
+	return s
+}
+
+// sendRef makes the branch dst of the container's copy of r point to the
+// host's ref: pushed with opts, or fetched from the host for a shallow or
+// partial clone.
+func (c *Container) sendRef(ctx context.Context, stdout, stderr io.Writer, r *Repo, ref, dst string, opts gitutil.PushOpts) error {
+	if !c.fetchesFromHost() {
+		if dst == "base" {
+			opts = basePush(opts)
+		}
+		return r.vcs().PushRef(ctx, r.GitRoot, c.Name, ref, dst, opts)
+	}
+	return c.hostGit(ctx, stdout, stderr, r, hostFetchScript(ref, dst, 0))
+}
+
+// hostGit runs script in the container's ~/src/<repo> while the host serves
+// r's repository as hostRemote.
+func (c *Container) hostGit(ctx context.Context, stdout, stderr io.Writer, r *Repo, script string) error {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("serving %s to the container: %w", r.Name(), err)
+	}
+	defer ln.Close()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go serveGit(ctx, ln, r.GitRoot)
+	port := ln.Addr().(*net.TCPAddr).Port
+	// The container's port is the host's: sshd can't report the one it would
+	// pick to the script. A busy one fails the session.
+	fwd := fmt.Sprintf("127.0.0.1:%d:127.0.0.1:%d", port, port)
+	script = "cd ~/src/" + shellQuote(r.Name()) +
+		" && git config remote." + hostRemote + ".url " + shellQuote(fmt.Sprintf("git://127.0.0.1:%d/", port)) +
+		" && " + script
+	// A connection of its own: forwards requested through a shared one
+	// outlive the session.
+	args := c.SSHCommand("-o", "ControlPath=none", "-o", "ExitOnForwardFailure=yes", "-R", fwd, c.Name, script)
+	if err := runCmdOut(ctx, "", args, stdout, stderr); err != nil {
+		return fmt.Errorf("fetching %s from the host: %w", r.Name(), err)
+	}
+	return nil
+}
+
+// serveGit serves the repository at dir read-only over the git:// protocol
+// on ln until it is closed.
+func serveGit(ctx context.Context, ln net.Listener, dir string) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			if err := serveGitConn(ctx, conn, dir); err != nil {
+				slog.WarnContext(ctx, "md", "msg", "serving git", "dir", dir, "err", err)
+			}
+		}()
+	}
+}
+
+// serveGitConn answers a git:// request, like "git-upload-pack
+// /\x00host=127.0.0.1\x00\x00version=2\x00", with git upload-pack. A
+// partial clone's lazy fetches want blobs by ID, hence allowAnySHA1InWant.
+func serveGitConn(ctx context.Context, conn io.ReadWriter, dir string) error {
+	req, err := readPktLine(conn)
+	if err != nil {
+		return err
+	}
+	fields := strings.Split(strings.TrimSuffix(req, "\n"), "\x00")
+	if !strings.HasPrefix(fields[0], "git-upload-pack ") {
+		return fmt.Errorf("unexpected request %q", fields[0])
+	}
+	cmd := exec.CommandContext(ctx, "git", "-c", "uploadpack.allowFilter=true", "-c", "uploadpack.allowAnySHA1InWant=true", "upload-pack", dir)
+	cmd.Stdout = conn
+	cmd.Env = os.Environ()
+	for _, f := range fields[1:] {
+		if strings.HasPrefix(f, "version=") {
+			cmd.Env = append(cmd.Env, "GIT_PROTOCOL="+f)
+		}
+	}
+	// Not cmd.Stdin: Wait would wait for the client to hang up, which it
+	// doesn't when upload-pack fails.
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go func() {
+		_, _ = io.Copy(stdin, conn)
+		_ = stdin.Close()
+	}()
+	return cmd.Wait()
+}
+
+// readPktLine reads a pkt-line: its length as 4 hex digits, itself
+// included, then its data.
+func readPktLine(r io.Reader) (string, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return "", err
+	}
+	n, err := strconv.ParseUint(string(hdr[:]), 16, 16)
+	if err != nil || n < 4 || n > maxPktLine {
+		return "", fmt.Errorf("invalid pkt-line length %q", hdr)
+	}
+	buf := make([]byte, n-4)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// deepenScript fetches depth more commits of the host's ref into the
+// repository in the current directory, or with depth 0 all of its history
+// and the blobs a partial clone lacks.
+func deepenScript(ref string, depth int) string {
+	ref = shellQuote(ref)
+	shallow := `[ "$(git rev-parse --is-shallow-repository)" = true ]`
+	if depth > 0 {
+		return "if " + shallow + "; then git fetch -q --no-tags --deepen=" + strconv.Itoa(depth) + " " + hostRemote + " " + ref + "; fi"
+	}
+	return "if " + shallow + "; then git fetch -q --no-tags --unshallow " + hostRemote + " " + ref + "; fi" +
+		" && if [ -n \"$(git config remote." + hostRemote + ".partialCloneFilter)\" ]; then" +
+		" git config --unset remote." + hostRemote + ".partialCloneFilter" +
+		" && git fetch -q --no-tags --refetch " + hostRemote + " " + ref + "; fi"
+}
+
+// Deepen fetches depth more commits of history into the container's shallow
+// clone of Repos[repoIdx], or with depth 0 all of it and, for a partial
+// clone, the blobs it lacks, so tools like git log -p and git blame work on
+// the whole history.
+func (c *Container) Deepen(ctx context.Context, stdout, stderr io.Writer, repoIdx int, depth int) error {
+	if len(c.Repos) == 0 {
+		return errors.New("container has no repos")
+	}
+	if repoIdx < 0 || repoIdx >= len(c.Repos) {
+		return fmt.Errorf("repo index %d out of range [0, %d)", repoIdx, len(c.Repos))
+	}
+	if depth < 0 {
+		return fmt.Errorf("invalid depth %d", depth)
+	}
+	if !c.fetchesFromHost() {
+		return fmt.Errorf("%s has full clones: it wasn't started with a clone depth or filter", c.Name)
+	}
+	if err := c.checkContainerState(ctx); err != nil {
+		return err
+	}
+	c.touch()
+	r := c.Repos[repoIdx]
+	return c.hostGit(ctx, stdout, stderr, &r, deepenScript("refs/heads/"+r.Branch, depth))
+}
@@ -0,0 +1,156 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCheckCloneOpts(t *testing.T) {
+	for name, tt := range map[string]struct {
+		opts    StartOpts
+		wantErr bool
+	}{
+		"none":       {StartOpts{}, false},
+		"depth":      {StartOpts{CloneDepth: 1}, false},
+		"blob_none":  {StartOpts{CloneFilter: "blob:none"}, false},
+		"blob_limit": {StartOpts{CloneDepth: 50, CloneFilter: "blob:limit=1m"}, false},
+		"negative":   {StartOpts{CloneDepth: -1}, true},
+		"bad_filter": {StartOpts{CloneFilter: "sparse:oid=x"}, true},
+		"mounted":    {StartOpts{CloneDepth: 1, MountSource: SourceOverlay}, true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := checkCloneOpts(&tt.opts); (err != nil) != tt.wantErr {
+				t.Fatalf("got %v", err)
+			}
+		})
+	}
+}
+
+func TestReadPktLine(t *testing.T) {
+	got, err := readPktLine(strings.NewReader("0015git-upload-pack /rest"))
+	if err != nil || got != "git-upload-pack /" {
+		t.Errorf("got %q, %v", got, err)
+	}
+	for _, in := range []string{"", "00", "0003", "zzzz", "0010short"} {
+		if _, err := readPktLine(strings.NewReader(in)); err == nil {
+			t.Errorf("%q: expected error", in)
+		}
+	}
+}
+
+func TestServeGitRejectsPush(t *testing.T) {
+	var conn bytes.Buffer
+	req := "git-receive-pack /\x00host=127.0.0.1\x00"
+	fmt.Fprintf(&conn, "%04x%s", len(req)+4, req)
+	if err := serveGitConn(t.Context(), &conn, t.TempDir()); err == nil {
+		t.Error("expected error")
+	}
+}
+
+// TestHostFetch runs the scripts md runs in the container's repository
+// against a local one, the host being served like hostGit does.
+func TestHostFetch(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the scripts run in the container's shell")
+	}
+	ctx := t.Context()
+	host, ctr := t.TempDir(), t.TempDir()
+	git := func(dir string, args ...string) string {
+		t.Helper()
+		cmd := exec.CommandContext(ctx, "git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@test"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	commit := func(i int) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(host, "a"), []byte(strconv.Itoa(i)+"\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		git(host, "add", ".")
+		git(host, "commit", "-q", "-m", "c"+strconv.Itoa(i))
+	}
+	git(host, "init", "-q", "--initial-branch=main")
+	for i := 1; i <= 5; i++ {
+		commit(i)
+	}
+	git(ctr, "init", "-q", "--initial-branch=unborn")
+	// A background gc would race with the cleanup of the directory.
+	git(ctr, "config", "maintenance.auto", "false")
+	git(ctr, "config", "gc.auto", "0")
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go serveGit(ctx, ln, host)
+	url := fmt.Sprintf("git://127.0.0.1:%d/", ln.Addr().(*net.TCPAddr).Port)
+	run := func(script string) {
+		t.Helper()
+		cmd := exec.CommandContext(ctx, "sh", "-c", "git config remote."+hostRemote+".url "+url+" && "+script)
+		cmd.Dir = ctr
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("%s: %v\n%s", script, err, out)
+		}
+	}
+
+	run(hostCloneScript("blob:none") + " && " + hostFetchScript("refs/heads/main", "base", 2) +
+		" && git branch -q --track main base && git switch -q main")
+	if got := git(ctr, "rev-parse", "--is-shallow-repository"); got != "true" {
+		t.Errorf("shallow: %s", got)
+	}
+	if got := git(ctr, "rev-list", "--count", "HEAD"); got != "2" {
+		t.Errorf("commits: %s", got)
+	}
+	if b, err := os.ReadFile(filepath.Join(ctr, "a")); err != nil || string(b) != "5\n" {
+		t.Errorf("checkout: %q, %v", b, err)
+	}
+
+	// A later fetch brings the new commits without truncating the history.
+	commit(6)
+	run(hostFetchScript("refs/heads/main", "base", 0))
+	if got := git(ctr, "rev-list", "--count", "base"); got != "3" {
+		t.Errorf("commits after fetch: %s", got)
+	}
+
+	// md pull fetches the container's commits from the shallow, partial clone.
+	if err := os.WriteFile(filepath.Join(ctr, "b"), []byte("b\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	git(ctr, "add", ".")
+	git(ctr, "commit", "-q", "-m", "agent")
+	git(host, "fetch", "-q", ctr, "main:refs/remotes/ctr/main")
+	if got := git(host, "rev-list", "--count", "refs/remotes/ctr/main"); got != "6" {
+		t.Errorf("host commits after pull: %s", got)
+	}
+
+	run(deepenScript("refs/heads/main", 1))
+	if got := git(ctr, "rev-list", "--count", "base"); got != "4" {
+		t.Errorf("commits after deepen: %s", got)
+	}
+	run(deepenScript("refs/heads/main", 0))
+	if got := git(ctr, "rev-parse", "--is-shallow-repository"); got != "false" {
+		t.Errorf("shallow after full deepen: %s", got)
+	}
+	// The blobs are all there: nothing is fetched from the gone server.
+	ln.Close()
+	if got := git(ctr, "show", "base~5:a"); got != "1" {
+		t.Errorf("old blob: %q", got)
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// A container with shared objects works like a git worktree of the host's
+// repository: its clone borrows the host's object store, mounted read-only,
+// as a git alternate instead of receiving a copy of the history. Starting it
+// transfers nothing, and the containers of the same repository, whatever
+// their branch, share one object store. The commits made in the container
+// are written to its own object store.
+
+// sharedObjectsDir is where the git directories of the host repositories are
+// mounted read-only, one subdirectory per repo.
+const sharedObjectsDir = "/var/lib/md/git"
+
+// checkSharedObjects returns an error when opts asks for shared objects md
+// can't provide.
+func checkSharedObjects(opts *StartOpts) error {
+	if !opts.SharedObjects {
+		return nil
+	}
+	if opts.MountSource != SourceClone {
+		return errors.New("shared objects need the repositories cloned in the container, not mounted")
+	}
+	if opts.CloneDepth > 0 || opts.CloneFilter != "" {
+		return errors.New("shared objects and a shallow or partial clone are exclusive: the clone already borrows the whole history")
+	}
+	return nil
+}
+
+// sharedObjectsArgs returns the container runtime arguments mounting the git
+// directory of each of repos read-only under sharedObjectsDir. A worktree's
+// is the main checkout's, which holds the objects.
+func sharedObjectsArgs(ctx context.Context, repos []Repo) ([]string, error) {
+	var args []string
+	for _, r := range repos {
+		if name := r.vcs().Name(); name != "git" {
+			return nil, fmt.Errorf("%s is a %s repository: only git repositories can share objects", r.Name(), name)
+		}
+		dir, err := runCmd(ctx, r.GitRoot, []string{"git", "rev-parse", "--path-format=absolute", "--git-common-dir"})
+		if err != nil {
+			return nil, fmt.Errorf("finding the git directory of %s: %w", r.Name(), err)
+		}
+		if strings.ContainsAny(dir, `:,"`) {
+			return nil, fmt.Errorf("%s: can't mount a path containing ':', ',' or '\"'", dir)
+		}
+		args = append(args, "-v", dir+":"+sharedObjectsDir+"/"+r.Name()+":ro")
+	}
+	return args, nil
+}
+
+// borrowScript makes the empty repository in the current directory use the
+// object store objects as an alternate and points base to commit, which it
+// holds.
+func borrowScript(objects, commit string) string {
+	return "echo " + shellQuote(objects) + " >> \"$(git rev-parse --git-path objects/info/alternates)\"" +
+		" && " + baseUpdateEnv + "=1 git update-ref refs/heads/base " + shellQuote(commit)
+}
+
+// borrowObjects makes the container's clone of r borrow the objects of the
+// host's repository and sets its base to the host's branch.
+func (c *Container) borrowObjects(ctx context.Context, r *Repo) error {
+	commit, err := runCmd(ctx, r.GitRoot, []string{"git", "rev-parse", "--verify", "refs/heads/" + r.Branch + "^{commit}"})
+	if err != nil {
+		return fmt.Errorf("resolving %s of %s: %w", r.Branch, r.Name(), err)
+	}
+	script := "cd ~/src/" + shellQuote(r.Name()) + " && " + borrowScript(sharedObjectsDir+"/"+r.Name()+"/objects", commit)
+	if _, err := runCmd(ctx, "", c.SSHCommand(c.Name, script)); err != nil {
+		return fmt.Errorf("sharing the objects of %s: %w", r.Name(), err)
+	}
+	return nil
+}
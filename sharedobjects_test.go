@@ -0,0 +1,102 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestCheckSharedObjects(t *testing.T) {
+	for name, tt := range map[string]struct {
+		opts    StartOpts
+		wantErr bool
+	}{
+		"none":    {StartOpts{}, false},
+		"shared":  {StartOpts{SharedObjects: true}, false},
+		"mounted": {StartOpts{SharedObjects: true, MountSource: SourceOverlay}, true},
+		"shallow": {StartOpts{SharedObjects: true, CloneDepth: 1}, true},
+		"partial": {StartOpts{SharedObjects: true, CloneFilter: "blob:none"}, true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := checkSharedObjects(&tt.opts); (err != nil) != tt.wantErr {
+				t.Fatalf("got %v", err)
+			}
+		})
+	}
+}
+
+// TestBorrowObjects runs the script md runs in the container's repository
+// against a local one borrowing the objects of a host worktree's repository.
+func TestBorrowObjects(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the script runs in the container's shell")
+	}
+	ctx := t.Context()
+	host, ctr := t.TempDir(), t.TempDir()
+	git := func(dir string, args ...string) string {
+		t.Helper()
+		cmd := exec.CommandContext(ctx, "git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@test"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git(host, "init", "-q", "--initial-branch=main")
+	// A background gc would race with the cleanup of the directory.
+	git(host, "config", "maintenance.auto", "false")
+	git(host, "config", "gc.auto", "0")
+	if err := os.WriteFile(filepath.Join(host, "a"), []byte("a\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	git(host, "add", ".")
+	git(host, "commit", "-q", "-m", "c1")
+	wt := filepath.Join(t.TempDir(), "wt")
+	git(host, "worktree", "add", "-q", "-b", "feature", wt)
+
+	args, err := sharedObjectsArgs(ctx, []Repo{{GitRoot: wt, Branch: "feature"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gitDir, err := filepath.EvalSymlinks(filepath.Join(host, ".git"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := gitDir + ":" + sharedObjectsDir + "/wt:ro"; len(args) != 2 || args[1] != want {
+		t.Errorf("args: got %q, want %q", args, want)
+	}
+
+	git(ctr, "init", "-q", "--initial-branch=unborn")
+	cmd := exec.CommandContext(ctx, "sh", "-c", borrowScript(filepath.Join(host, ".git", "objects"), git(wt, "rev-parse", "feature"))+
+		" && git branch -q --track feature base && git switch -q feature")
+	cmd.Dir = ctr
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	if b, err := os.ReadFile(filepath.Join(ctr, "a")); err != nil || string(b) != "a\n" {
+		t.Errorf("checkout: %q, %v", b, err)
+	}
+	// The container's commits go to its own object store, which md pull
+	// fetches from.
+	if err := os.WriteFile(filepath.Join(ctr, "b"), []byte("b\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	git(ctr, "add", ".")
+	git(ctr, "commit", "-q", "-m", "agent")
+	git(host, "fetch", "-q", ctr, "feature:refs/remotes/ctr/feature")
+	if got := git(host, "rev-list", "--count", "refs/remotes/ctr/feature"); got != "2" {
+		t.Errorf("host commits after pull: %s", got)
+	}
+	// Only the agent's commit, tree and blob are the container's own.
+	if got := git(ctr, "count-objects"); !strings.HasPrefix(got, "3 objects") {
+		t.Errorf("container objects: %s", got)
+	}
+}
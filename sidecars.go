@@ -0,0 +1,150 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ComposeFile is the path, relative to the primary repository root, of the
+// Docker Compose file whose services (databases, caches, queues) md start
+// runs next to the container, e.g.:
+//
+//	services:
+//	  postgres:
+//	    image: postgres:17
+//	    environment: {POSTGRES_PASSWORD: dev}
+//
+// They share the container's network, its private one unless
+// [StartOpts.NetworkMode] names another; the container reaches them by
+// service name (postgres:5432). They are removed with it.
+const ComposeFile = ".md/compose.yaml"
+
+// Sidecar is the state of a service of [ComposeFile].
+type Sidecar struct {
+	// Service is the service name, its host name in the container.
+	Service string `json:"service"`
+	// Name is the engine's container name.
+	Name string `json:"name"`
+	// State is the engine's state: "running", "exited", ...
+	State string `json:"state"`
+	// Health is "healthy", "unhealthy" or "starting" for services with a
+	// healthcheck.
+	Health string `json:"health,omitempty"`
+	// Status is the engine's human readable status, e.g. "Up 2 minutes".
+	Status string `json:"status"`
+}
+
+// findComposeFile returns the path of ComposeFile in gitRoot, empty when
+// there is none.
+func findComposeFile(gitRoot string) (string, error) {
+	p := filepath.Join(gitRoot, filepath.FromSlash(ComposeFile))
+	if _, err := os.Stat(p); errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return p, nil
+}
+
+// composeProject returns the compose project of the sidecars of the container
+// name: compose projects are lowercase and can't have dots.
+func composeProject(name string) string {
+	return strings.ReplaceAll(strings.ToLower(name), ".", "_")
+}
+
+// sidecarOverride is the compose file md adds to ComposeFile to put its
+// services on the container's network.
+func sidecarOverride(network string) string {
+	return "networks:\n  default:\n    name: " + network + "\n    external: true\n"
+}
+
+// composeCmd returns the compose command line acting on the sidecars of c.
+func (c *Container) composeCmd(args ...string) []string {
+	return append([]string{c.Runtime, "compose", "-p", composeProject(c.Name)}, args...)
+}
+
+// startSidecars brings the services of the compose file up on the
+// container's network, waiting for their healthchecks with docker.
+func (c *Container) startSidecars(ctx context.Context, stdout, stderr io.Writer, composeFile string, quiet bool) error {
+	override, err := os.CreateTemp("", "md-compose-*.yaml")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(override.Name()) }()
+	if _, err := override.WriteString(sidecarOverride(c.Network)); err != nil {
+		_ = override.Close()
+		return err
+	}
+	if err := override.Close(); err != nil {
+		return err
+	}
+	args := c.composeCmd("-f", composeFile, "-f", override.Name(), "up", "-d")
+	if c.Engine().composeWait() {
+		args = append(args, "--wait")
+	}
+	if quiet {
+		if _, err := runCmd(ctx, "", args); err != nil {
+			return cmdErrWithStderr("starting the services of "+ComposeFile, err)
+		}
+		return nil
+	}
+	_, _ = fmt.Fprintf(stdout, "- Starting the services of %s\n", ComposeFile)
+	if err := runCmdOut(ctx, "", args, stdout, stderr); err != nil {
+		return fmt.Errorf("starting the services of %s: %w", ComposeFile, err)
+	}
+	return nil
+}
+
+// removeSidecars removes the sidecars and their volumes.
+func (c *Container) removeSidecars(ctx context.Context) error {
+	if _, err := runCmd(ctx, "", c.composeCmd("down", "-v", "--remove-orphans")); err != nil {
+		return cmdErrWithStderr("removing the services of "+c.Name, err)
+	}
+	return nil
+}
+
+// SidecarStatus returns the state of the services of the container's
+// [ComposeFile].
+func (c *Container) SidecarStatus(ctx context.Context) ([]Sidecar, error) {
+	if !c.Sidecars {
+		return nil, nil
+	}
+	out, err := runCmd(ctx, "", c.composeCmd("ps", "--all", "--format", "json"))
+	if err != nil {
+		return nil, cmdErrWithStderr("listing the services of "+c.Name, err)
+	}
+	return parseComposePS(out)
+}
+
+// parseComposePS parses the output of compose ps --format json: a JSON array
+// for older versions, one object per line since docker compose 2.21. Its keys
+// are capitalized; encoding/json matches them regardless of case.
+func parseComposePS(out string) ([]Sidecar, error) {
+	var sidecars []Sidecar
+	out = strings.TrimSpace(out)
+	if strings.HasPrefix(out, "[") {
+		if err := json.Unmarshal([]byte(out), &sidecars); err != nil {
+			return nil, fmt.Errorf("parsing compose ps: %w", err)
+		}
+		return sidecars, nil
+	}
+	for d := json.NewDecoder(strings.NewReader(out)); d.More(); {
+		var s Sidecar
+		if err := d.Decode(&s); err != nil {
+			return nil, fmt.Errorf("parsing compose ps: %w", err)
+		}
+		sidecars = append(sidecars, s)
+	}
+	return sidecars, nil
+}
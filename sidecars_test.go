@@ -0,0 +1,71 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestFindComposeFile(t *testing.T) {
+	dir := t.TempDir()
+	if p, err := findComposeFile(dir); p != "" || err != nil {
+		t.Errorf("got %q, %v", p, err)
+	}
+	want := filepath.Join(dir, ".md", "compose.yaml")
+	if err := os.MkdirAll(filepath.Dir(want), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(want, []byte("services: {}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := findComposeFile(dir); p != want || err != nil {
+		t.Errorf("got %q, %v", p, err)
+	}
+}
+
+func TestComposeNames(t *testing.T) {
+	if got := composeProject("md-My.Repo-main"); got != "md-my_repo-main" {
+		t.Errorf("composeProject: %q", got)
+	}
+	c := &Container{Client: &Client{Runtime: "podman"}, Name: "md-r-main"}
+	if got := c.composeCmd("ps"); !slices.Equal(got, []string{"podman", "compose", "-p", "md-r-main", "ps"}) {
+		t.Errorf("composeCmd: %q", got)
+	}
+	want := "networks:\n  default:\n    name: md-r-main-net\n    external: true\n"
+	if got := sidecarOverride(privateNetwork("md-r-main")); got != want {
+		t.Errorf("sidecarOverride: %q", got)
+	}
+}
+
+func TestParseComposePS(t *testing.T) {
+	want := []Sidecar{
+		{Service: "postgres", Name: "md-r-main-postgres-1", State: "running", Health: "healthy", Status: "Up 2 minutes (healthy)"},
+		{Service: "redis", Name: "md-r-main-redis-1", State: "exited", Status: "Exited (1) 5 seconds ago"},
+	}
+	for name, out := range map[string]string{
+		"lines": `{"Service":"postgres","Name":"md-r-main-postgres-1","State":"running","Health":"healthy","Status":"Up 2 minutes (healthy)","Ports":""}
+{"Service":"redis","Name":"md-r-main-redis-1","State":"exited","Health":"","Status":"Exited (1) 5 seconds ago"}
+`,
+		"array": `[{"Service":"postgres","Name":"md-r-main-postgres-1","State":"running","Health":"healthy","Status":"Up 2 minutes (healthy)"},` +
+			`{"Service":"redis","Name":"md-r-main-redis-1","State":"exited","Status":"Exited (1) 5 seconds ago"}]`,
+	} {
+		got, err := parseComposePS(out)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("%s: got %+v", name, got)
+		}
+	}
+	if got, err := parseComposePS(""); len(got) != 0 || err != nil {
+		t.Errorf("empty: %+v, %v", got, err)
+	}
+	if _, err := parseComposePS("{"); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/caic-xyz/md/gitutil"
+)
+
+// signCommits signs on the host, with its git signing setup (gpg.format,
+// user.signingkey), the commits the container added on r's branch, then
+// moves the branch in the container and its remote-tracking ref to the
+// signed copies. The signing keys never enter the container, and since
+// signing doesn't change the trees, the container's working tree is
+// untouched.
+//
+// Commits already on a host branch, tag or other remote, like the upstream
+// ones the agent merged, are kept as is. The container's branch is only
+// moved if it didn't change since it was fetched.
+func (c *Container) signCommits(ctx context.Context, stdout io.Writer, r *Repo) error {
+	remoteRef := "refs/remotes/" + c.Name + "/" + r.Branch
+	tip, err := gitutil.RunGit(ctx, r.GitRoot, "rev-parse", "--verify", remoteRef)
+	if err != nil {
+		return err
+	}
+	out, err := gitutil.RunGit(ctx, r.GitRoot, "rev-list", "--reverse", "--topo-order", tip, "--not", "--branches", "--tags", "--exclude="+c.Name+"/*", "--remotes")
+	if err != nil || out == "" {
+		return err
+	}
+	newTip, n, err := resignCommits(ctx, r.GitRoot, strings.Fields(out))
+	if err != nil || n == 0 {
+		return err
+	}
+	lease := "--force-with-lease=refs/heads/" + r.Branch + ":" + tip
+	if _, err := gitutil.RunGit(ctx, r.GitRoot, "push", "-q", lease, c.Name, newTip+":refs/heads/"+r.Branch); err != nil {
+		return fmt.Errorf("moving %s in the container to the signed commits: %w", r.Branch, err)
+	}
+	// The push usually moves the remote-tracking ref already, depending on
+	// the remote's fetch refspec.
+	if _, err := gitutil.RunGit(ctx, r.GitRoot, "update-ref", "-m", "md: signed on the host", remoteRef, newTip); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(stdout, "- Signed %d commit(s) of %s\n", n, r.Branch)
+	return nil
+}
+
+// resignCommits creates signed copies of commits, parents first, preserving
+// their trees, authors, committers and messages. A commit already signed
+// whose parents are unchanged is kept. It returns the copy of the last
+// commit and the number of commits signed.
+func resignCommits(ctx context.Context, dir string, commits []string) (string, int, error) {
+	copies := make(map[string]string, len(commits))
+	last := ""
+	for _, sha := range commits {
+		raw, err := gitOutput(ctx, dir, nil, nil, "cat-file", "commit", sha)
+		if err != nil {
+			return "", 0, err
+		}
+		cm, err := parseCommitObject(raw)
+		if err != nil {
+			return "", 0, fmt.Errorf("commit %s: %w", sha, err)
+		}
+		changed := false
+		args := []string{"commit-tree", "-S", cm.tree}
+		for _, p := range cm.parents {
+			if cp, ok := copies[p]; ok {
+				p = cp
+				changed = true
+			}
+			args = append(args, "-p", p)
+		}
+		last = sha
+		if cm.signed && !changed {
+			continue
+		}
+		env := append(identEnv("AUTHOR", cm.author), identEnv("COMMITTER", cm.committer)...)
+		out, err := gitOutput(ctx, dir, env, strings.NewReader(cm.message), args...)
+		if err != nil {
+			return "", 0, fmt.Errorf("signing %s: %w; configure the host's git signing (gpg.format, user.signingkey)", sha, err)
+		}
+		copies[sha] = strings.TrimSpace(out)
+	}
+	if cp, ok := copies[last]; ok {
+		return cp, len(copies), nil
+	}
+	return last, 0, nil
+}
+
+// commitObject is the content of a git commit object.
+type commitObject struct {
+	tree      string
+	parents   []string
+	author    string
+	committer string
+	signed    bool
+	message   string
+}
+
+// parseCommitObject parses the output of git cat-file commit.
+func parseCommitObject(raw string) (*commitObject, error) {
+	headers, message, ok := strings.Cut(raw, "\n\n")
+	if !ok {
+		headers = strings.TrimSuffix(raw, "\n")
+	}
+	cm := &commitObject{message: message}
+	for line := range strings.SplitSeq(headers, "\n") {
+		key, value, _ := strings.Cut(line, " ")
+		switch key {
+		case "tree":
+			cm.tree = value
+		case "parent":
+			cm.parents = append(cm.parents, value)
+		case "author":
+			cm.author = value
+		case "committer":
+			cm.committer = value
+		case "gpgsig", "gpgsig-sha256":
+			cm.signed = true
+		}
+	}
+	if cm.tree == "" || cm.author == "" || cm.committer == "" {
+		return nil, errors.New("malformed commit object")
+	}
+	return cm, nil
+}
+
+// identEnv returns the environment variables giving git the identity
+// "Name <email> timestamp tz" as the role (AUTHOR or COMMITTER).
+func identEnv(role, ident string) []string {
+	i := strings.LastIndex(ident, "> ")
+	j := strings.Index(ident, " <")
+	if i < 0 || j < 0 || j > i {
+		return nil
+	}
+	return []string{
+		"GIT_" + role + "_NAME=" + ident[:j],
+		"GIT_" + role + "_EMAIL=" + ident[j+2:i],
+		"GIT_" + role + "_DATE=" + ident[i+2:],
+	}
+}
+
+// gitOutput runs git in dir with env added and stdin, returning its
+// untrimmed stdout.
+func gitOutput(ctx context.Context, dir string, env []string, stdin io.Reader, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(append(os.Environ(), env...), "LANG=C")
+	cmd.Stdin = stdin
+	cmd.WaitDelay = cmdWaitDelay
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
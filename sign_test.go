@@ -0,0 +1,106 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"bytes"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestParseCommitObject(t *testing.T) {
+	raw := "tree t1\nparent p1\nparent p2\nauthor A U <a@x> 1700000000 +0100\ncommitter C <c@x> 1700000001 -0500\ngpgsig -----BEGIN SSH SIGNATURE-----\n abc\n -----END SSH SIGNATURE-----\n\nsubject\n\nbody\n"
+	cm, err := parseCommitObject(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cm.tree != "t1" || len(cm.parents) != 2 || !cm.signed || cm.message != "subject\n\nbody\n" {
+		t.Errorf("got %+v", cm)
+	}
+	env := identEnv("AUTHOR", cm.author)
+	want := []string{"GIT_AUTHOR_NAME=A U", "GIT_AUTHOR_EMAIL=a@x", "GIT_AUTHOR_DATE=1700000000 +0100"}
+	if strings.Join(env, "|") != strings.Join(want, "|") {
+		t.Errorf("got %q", env)
+	}
+	if _, err := parseCommitObject("parent p1\n\nmsg"); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestSignCommits(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell")
+	}
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen not found")
+	}
+	ctx := t.Context()
+	tmp := t.TempDir()
+	key := filepath.Join(tmp, "key")
+	if out, err := exec.CommandContext(ctx, "ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-f", key).CombinedOutput(); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	host := filepath.Join(tmp, "host")
+	ctr := filepath.Join(tmp, "ctr")
+	git := func(dir string, args ...string) string {
+		t.Helper()
+		cmd := exec.CommandContext(ctx, "git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@test"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git(tmp, "init", "-q", "--initial-branch=main", host)
+	git(host, "commit", "-q", "--allow-empty", "-m", "base")
+	git(tmp, "clone", "-q", host, ctr)
+	git(ctr, "config", "receive.denyCurrentBranch", "ignore")
+	git(ctr, "commit", "-q", "--allow-empty", "-m", "one")
+	git(ctr, "commit", "-q", "--allow-empty", "-m", "two\n\nbody")
+	ctrTree := git(ctr, "rev-parse", "HEAD^{tree}")
+
+	const name = "md-host-main"
+	git(host, "remote", "add", name, ctr)
+	git(host, "config", "gpg.format", "ssh")
+	git(host, "config", "user.signingkey", key)
+	git(host, "fetch", "-q", name, "main")
+	c := &Container{Name: name}
+	r := &Repo{GitRoot: host, Branch: "main"}
+	var stdout bytes.Buffer
+	if err := c.signCommits(ctx, &stdout, r); err != nil {
+		t.Fatal(err)
+	}
+	if got := stdout.String(); got != "- Signed 2 commit(s) of main\n" {
+		t.Errorf("got %q", got)
+	}
+	tip := git(host, "rev-parse", "refs/remotes/"+name+"/main")
+	if got := git(ctr, "rev-parse", "main"); got != tip {
+		t.Errorf("container's branch %s, want %s", got, tip)
+	}
+	for _, rev := range []string{tip, tip + "^"} {
+		if raw := git(host, "cat-file", "commit", rev); !strings.Contains(raw, "\ngpgsig -----BEGIN SSH SIGNATURE-----") {
+			t.Errorf("%s isn't signed:\n%s", rev, raw)
+		}
+	}
+	if base := git(host, "rev-parse", "main"); git(host, "rev-parse", tip+"~2") != base {
+		t.Error("the host's commit was rewritten")
+	}
+	if got := git(host, "log", "-1", "--format=%an <%ae>%n%B", tip); got != "Test <test@test>\ntwo\n\nbody" {
+		t.Errorf("got %q", got)
+	}
+	if got := git(ctr, "rev-parse", "HEAD^{tree}"); got != ctrTree {
+		t.Error("tree changed")
+	}
+
+	// Nothing left to sign.
+	stdout.Reset()
+	if err := c.signCommits(ctx, &stdout, r); err != nil || stdout.Len() != 0 {
+		t.Errorf("got %q, %v", stdout.String(), err)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sinceSpec is a past state of a container's repository named by
+// [Container.DiffSince]: the Push-th push (counting back from the latest
+// when negative) or the state at Time.
+type sinceSpec struct {
+	Push int
+	Time time.Time
+}
+
+// sinceLayouts are the absolute times parseSince accepts, in local time
+// unless they have an offset.
+var sinceLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02"}
+
+// parseSince parses "push#N", "push#-N", a duration ago like "2h", "90m ago"
+// or "3d ago", or an absolute time.
+func parseSince(s string, now time.Time) (sinceSpec, error) {
+	s = strings.TrimSpace(s)
+	if n, ok := strings.CutPrefix(s, "push#"); ok {
+		i, err := strconv.Atoi(n)
+		if err != nil || i == 0 {
+			return sinceSpec{}, fmt.Errorf("invalid push %q: want push#N, N from 1 for the first push or from -1 for the latest", s)
+		}
+		return sinceSpec{Push: i}, nil
+	}
+	ago := strings.TrimSpace(strings.TrimSuffix(s, "ago"))
+	days := time.Duration(0)
+	if i := strings.IndexByte(ago, 'd'); i > 0 {
+		n, err := strconv.Atoi(ago[:i])
+		if err == nil {
+			days = time.Duration(n) * 24 * time.Hour
+			ago = ago[i+1:]
+		}
+	}
+	if d, err := time.ParseDuration(ago); err == nil || (ago == "" && days > 0) {
+		if d+days <= 0 {
+			return sinceSpec{}, fmt.Errorf("invalid time %q: the duration must be positive", s)
+		}
+		return sinceSpec{Time: now.Add(-(d + days))}, nil
+	}
+	for _, layout := range sinceLayouts {
+		if t, err := time.ParseInLocation(layout, s, now.Location()); err == nil {
+			return sinceSpec{Time: t}, nil
+		}
+	}
+	return sinceSpec{}, fmt.Errorf("invalid time %q: want push#N, a duration like \"2h ago\" or a time like \"2006-01-02 15:04\"", s)
+}
+
+// pickBackup returns the commit of the push-th backup branch among refs, the
+// output of git for-each-ref listing them oldest first.
+func pickBackup(refs string, push int) (string, error) {
+	commits := strings.Fields(refs)
+	i := push - 1
+	if push < 0 {
+		i = len(commits) + push
+	}
+	if i < 0 || i >= len(commits) {
+		return "", fmt.Errorf("push#%d not found: %d pushes recorded", push, len(commits))
+	}
+	return commits[i], nil
+}
+
+// resolveSince returns the commit of the container's Repos[repoIdx] named
+// by since.
+//
+// A push is the backup branch Push saved: the container's state just before
+// it. A time is resolved with HEAD's reflog, so it names the last commit at
+// that time; uncommitted changes weren't recorded. A time before the reflog
+// starts names its oldest entry, when md set up the repository.
+func (c *Container) resolveSince(ctx context.Context, repoIdx int, since string) (string, error) {
+	spec, err := parseSince(since, time.Now())
+	if err != nil {
+		return "", err
+	}
+	cd := "cd ~/src/" + shellQuote(c.Repos[repoIdx].Name()) + " && "
+	if spec.Push != 0 {
+		out, err := runCmdRetry(ctx, "", c.SSHCommand(c.Name, cd+"git for-each-ref --sort=refname --format='%(objectname)' 'refs/heads/backup-*'"))
+		if err != nil {
+			return "", cmdErrWithStderr("listing pushes", err)
+		}
+		return pickBackup(out, spec.Push)
+	}
+	rev := "HEAD@{" + spec.Time.Format("2006-01-02 15:04:05 -0700") + "}"
+	out, err := runCmdRetry(ctx, "", c.SSHCommand(c.Name, cd+"git rev-parse -q --verify "+shellQuote(rev+"^{commit}")))
+	if err != nil {
+		return "", cmdErrWithStderr("finding the state at "+spec.Time.Format(time.DateTime), err)
+	}
+	return out, nil
+}
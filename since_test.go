@@ -0,0 +1,68 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		in   string
+		want sinceSpec
+	}{
+		{"push#3", sinceSpec{Push: 3}},
+		{"push#-1", sinceSpec{Push: -1}},
+		{"2h", sinceSpec{Time: now.Add(-2 * time.Hour)}},
+		{"2h ago", sinceSpec{Time: now.Add(-2 * time.Hour)}},
+		{"90m ago", sinceSpec{Time: now.Add(-90 * time.Minute)}},
+		{"3d ago", sinceSpec{Time: now.Add(-72 * time.Hour)}},
+		{"1d12h", sinceSpec{Time: now.Add(-36 * time.Hour)}},
+		{"2026-03-09 08:30", sinceSpec{Time: time.Date(2026, 3, 9, 8, 30, 0, 0, time.UTC)}},
+		{"2026-03-09", sinceSpec{Time: time.Date(2026, 3, 9, 0, 0, 0, 0, time.UTC)}},
+		{"2026-03-09T08:30:00+01:00", sinceSpec{Time: time.Date(2026, 3, 9, 7, 30, 0, 0, time.UTC)}},
+	} {
+		got, err := parseSince(tc.in, now)
+		if err != nil {
+			t.Errorf("%q: %v", tc.in, err)
+			continue
+		}
+		if got.Push != tc.want.Push || !got.Time.Equal(tc.want.Time) {
+			t.Errorf("%q: got %+v, want %+v", tc.in, got, tc.want)
+		}
+	}
+	for _, in := range []string{"", "push#0", "push#x", "-2h", "0s", "yesterday", "ago"} {
+		if _, err := parseSince(in, now); err == nil {
+			t.Errorf("%q: expected error", in)
+		}
+	}
+}
+
+func TestPickBackup(t *testing.T) {
+	refs := "aaa\nbbb\nccc\n"
+	for _, tc := range []struct {
+		push int
+		want string
+	}{
+		{1, "aaa"},
+		{3, "ccc"},
+		{-1, "ccc"},
+		{-3, "aaa"},
+	} {
+		if got, err := pickBackup(refs, tc.push); err != nil || got != tc.want {
+			t.Errorf("push#%d: got %q, %v", tc.push, got, err)
+		}
+	}
+	for _, push := range []int{4, -4} {
+		if _, err := pickBackup(refs, push); err == nil {
+			t.Errorf("push#%d: expected error", push)
+		}
+	}
+	if _, err := pickBackup("", 1); err == nil {
+		t.Error("expected error without pushes")
+	}
+}
@@ -0,0 +1,283 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/caic-xyz/md/gitutil"
+)
+
+// snapshotLabel is the image label holding a snapshot's metadata as
+// base64-encoded JSON.
+const snapshotLabel = "md.snapshot"
+
+// Snapshot is an image of a container saved by [Container.Snapshot].
+//
+// The image holds the container's whole filesystem: repositories, installed
+// packages, but also ~/.env and the shared credentials. Don't push it.
+type Snapshot struct {
+	// Image is the image reference, as returned by [SnapshotImage].
+	Image string `json:"image"`
+	// Container is the name of the snapshotted container. Restore recreates
+	// a container of that name.
+	Container string `json:"container"`
+	// Repos are the container's repositories with their git root and
+	// branch.
+	Repos []Repo `json:"repos,omitempty"`
+	// Created is when the snapshot was taken.
+	Created time.Time `json:"created"`
+	// Labels are the container's md labels other than md.repos. Restore
+	// starts the new container with the same settings.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+var reSnapshotTag = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+
+// SnapshotImage returns the image reference of the snapshot of container
+// tagged tag.
+func SnapshotImage(container, tag string) string {
+	return "md-snapshot-" + strings.ToLower(container) + ":" + tag
+}
+
+// Snapshot commits the running container into an image tagged tag, the
+// current time when empty, so it can be restored with [Client.Restore].
+func (c *Container) Snapshot(ctx context.Context, tag string) (*Snapshot, error) {
+	if c.MountSource != SourceClone {
+		return nil, fmt.Errorf("%s mounts the host checkout (--mount-src=%s): the snapshot wouldn't include it", c.Name, c.MountSource)
+	}
+	if err := c.checkContainerState(ctx); err != nil {
+		return nil, err
+	}
+	if tag == "" {
+		tag = time.Now().Format("20060102-150405")
+	}
+	return c.commitSnapshot(ctx, tag, c.Repos)
+}
+
+// commitSnapshot commits the container into the snapshot image tagged tag,
+// recording repos as its repositories.
+func (c *Container) commitSnapshot(ctx context.Context, tag string, repos []Repo) (*Snapshot, error) {
+	if !reSnapshotTag.MatchString(tag) {
+		return nil, fmt.Errorf("invalid snapshot tag %q: use letters, digits, '_', '.' and '-'", tag)
+	}
+	info, err := inspectContainer(ctx, c.Runtime, c.Name)
+	if err != nil {
+		return nil, fmt.Errorf("inspecting labels: %w", err)
+	}
+	snap := &Snapshot{
+		Image:     SnapshotImage(c.Name, tag),
+		Container: c.Name,
+		Repos:     repos,
+		Created:   time.Now().UTC().Truncate(time.Second),
+		Labels:    map[string]string{},
+	}
+	for k, v := range info.Config.Labels {
+		if strings.HasPrefix(k, "md.") && k != "md.repos" && k != snapshotLabel {
+			snap.Labels[k] = v
+		}
+	}
+	meta, err := json.Marshal(snap)
+	if err != nil {
+		return nil, err
+	}
+	// Strip the container's labels like Fork does: launchContainer sets them
+	// again on the restored container.
+	args := []string{c.Runtime, "commit"}
+	for _, key := range slices.Sorted(maps.Keys(info.Config.Labels)) {
+		if key != snapshotLabel {
+			args = append(args, "--change", "LABEL "+key+"=")
+		}
+	}
+	args = append(args, "--change", "LABEL "+snapshotLabel+"="+base64.StdEncoding.EncodeToString(meta), c.Name, snap.Image)
+	if _, err := runCmd(ctx, "", args); err != nil {
+		return nil, fmt.Errorf("docker commit: %w", err)
+	}
+	return snap, nil
+}
+
+// Snapshots returns the saved snapshots of all containers, most recent
+// first.
+func (c *Client) Snapshots(ctx context.Context) ([]Snapshot, error) {
+	out, err := runCmd(ctx, "", []string{c.Runtime, "images", "--filter", "label=" + snapshotLabel, "--format", "{{.ID}}"})
+	if err != nil {
+		return nil, fmt.Errorf("listing snapshots: %w", err)
+	}
+	var ids []string
+	for id := range strings.SplitSeq(out, "\n") {
+		if id != "" && !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	out, err = runCmdRetry(ctx, "", append([]string{c.Runtime, "image", "inspect"}, ids...))
+	if err != nil {
+		return nil, fmt.Errorf("inspecting snapshots: %w", err)
+	}
+	var infos []imageInfo
+	if err := json.Unmarshal([]byte(out), &infos); err != nil {
+		return nil, fmt.Errorf("parsing snapshots: %w", err)
+	}
+	snaps := make([]Snapshot, 0, len(infos))
+	for _, info := range infos {
+		// Images derived from a restored container, e.g. forks, carry the
+		// label emptied.
+		if info.Config.Labels[snapshotLabel] == "" {
+			continue
+		}
+		snap, err := parseSnapshotLabel(info.Config.Labels[snapshotLabel])
+		if err != nil {
+			return nil, err
+		}
+		snaps = append(snaps, *snap)
+	}
+	slices.SortFunc(snaps, func(a, b Snapshot) int {
+		return cmp.Or(b.Created.Compare(a.Created), strings.Compare(a.Image, b.Image))
+	})
+	return snaps, nil
+}
+
+// RemoveSnapshot deletes the snapshot image.
+func (c *Client) RemoveSnapshot(ctx context.Context, image string) error {
+	if _, err := c.snapshot(ctx, image); err != nil {
+		return err
+	}
+	if _, err := runCmd(ctx, "", []string{c.Runtime, "rmi", image}); err != nil {
+		return fmt.Errorf("removing %s: %w", image, err)
+	}
+	return nil
+}
+
+// RestoreOpts configures [Client.Restore].
+type RestoreOpts struct {
+	// Replace purges the snapshotted container first if it still exists.
+	Replace bool
+	// Quiet suppresses informational output.
+	Quiet bool
+	// AgentPaths specifies which agent config directories to mount.
+	AgentPaths []AgentPaths
+	// ExtraEnv holds additional KEY=VALUE pairs to inject into the
+	// container's ~/.env at runtime.
+	ExtraEnv []string
+	// ExtraRunArgs are additional arguments passed verbatim to the
+	// container runtime's "run" command.
+	ExtraRunArgs []string
+}
+
+// Restore starts a container from a snapshot image. The container gets the
+// snapshotted container's name and settings, its SSH config and the git
+// remotes of its repositories, whose remote-tracking refs are fetched.
+func (c *Client) Restore(ctx context.Context, stdout, stderr io.Writer, image string, opts *RestoreOpts) (*Container, error) {
+	snap, err := c.snapshot(ctx, image)
+	if err != nil {
+		return nil, err
+	}
+	ct := &Container{Client: c, Name: snap.Container}
+	for k, v := range snap.Labels {
+		ct.setLabel(k, v)
+	}
+	ct.Repos = snap.Repos
+	if _, err := runCmdRetry(ctx, "", []string{c.Runtime, "inspect", ct.Name}); err == nil {
+		if !opts.Replace {
+			return nil, fmt.Errorf("container %s exists; remove it with 'md kill' or replace it", ct.Name)
+		}
+		if err := ct.Purge(ctx, stdout, stderr); err != nil {
+			return nil, err
+		}
+	}
+	if !opts.Quiet {
+		_, _ = fmt.Fprintf(stdout, "- Restoring %s from %s ...\n", ct.Name, image)
+	}
+	startOpts := &StartOpts{
+		Quiet:             opts.Quiet,
+		AgentPaths:        opts.AgentPaths,
+		ExtraEnv:          opts.ExtraEnv,
+		ExtraRunArgs:      opts.ExtraRunArgs,
+		Display:           ct.Display,
+		Displays:          ct.Displays,
+		DisplaySize:       ct.DisplaySize,
+		RDP:               ct.RDP,
+		Browser:           ct.Browser,
+		Tailscale:         ct.Tailscale,
+		USB:               ct.USB,
+		Credentials:       ct.Credentials,
+		ScopedCredentials: ct.ScopedCredentials,
+		Mounts:            ct.Mounts,
+		PublishPorts:      ct.PublishedPorts,
+		HostPorts:         ct.HostPorts,
+		CloneDepth:        ct.CloneDepth,
+		CloneFilter:       ct.CloneFilter,
+		SharedObjects:     ct.SharedObjects,
+		Bind:              ct.Bind,
+		Sudo:              ct.Sudo,
+		TTL:               ct.TTL,
+		MaxCPUs:           ct.MaxCPUs,
+		Memory:            ct.Memory,
+		PidsLimit:         ct.PidsLimit,
+		ShmSize:           ct.ShmSize,
+	}
+	if err := ct.prepare(startOpts.AgentPaths); err != nil {
+		return nil, err
+	}
+	if err := launchContainer(ctx, stdout, stderr, ct, startOpts, image); err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(30 * time.Second)
+	if err := ct.waitForSSHPort(ctx, deadline); err != nil {
+		return nil, fmt.Errorf("waiting for SSH on restored container: %w", err)
+	}
+	if err := writeEnv(ctx, ct, appendEnv(appendEnv(readEnvFiles(ct.Repos), opts.ExtraEnv), c.Proxy.Env()), deadline); err != nil {
+		return nil, fmt.Errorf("restored container: %w", err)
+	}
+	for _, r := range ct.Repos {
+		if err := r.vcs().Fetch(ctx, r.GitRoot, ct.Name, r.Branch, gitutil.FetchOpts{}); err != nil {
+			return nil, fmt.Errorf("fetching %s from restored container: %w", r.Name(), err)
+		}
+	}
+	ct.State = "running"
+	return ct, nil
+}
+
+// snapshot returns the metadata of the snapshot image.
+func (c *Client) snapshot(ctx context.Context, image string) (*Snapshot, error) {
+	info, err := inspectImage(ctx, c.Runtime, image)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot %s not found", image)
+	}
+	v := info.Config.Labels[snapshotLabel]
+	if v == "" {
+		return nil, fmt.Errorf("%s is not an md snapshot", image)
+	}
+	return parseSnapshotLabel(v)
+}
+
+// parseSnapshotLabel decodes the value of snapshotLabel.
+func parseSnapshotLabel(v string) (*Snapshot, error) {
+	data, err := base64.StdEncoding.DecodeString(v)
+	if err != nil {
+		return nil, fmt.Errorf("decoding snapshot label: %w", err)
+	}
+	snap := &Snapshot{}
+	if err := json.Unmarshal(data, snap); err != nil {
+		return nil, fmt.Errorf("decoding snapshot label: %w", err)
+	}
+	if snap.Container == "" {
+		return nil, errors.New("decoding snapshot label: no container name")
+	}
+	return snap, nil
+}
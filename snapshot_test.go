@@ -0,0 +1,57 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSnapshotImage(t *testing.T) {
+	if got, want := SnapshotImage("md-Repo-main", "before-refactor"), "md-snapshot-md-repo-main:before-refactor"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	for tag, want := range map[string]bool{"20260102-150405": true, "v1.2_rc": true, "-x": false, "a/b": false, "a:b": false} {
+		if got := reSnapshotTag.MatchString(tag); got != want {
+			t.Errorf("tag %q: got %t", tag, got)
+		}
+	}
+}
+
+func TestParseSnapshotLabel(t *testing.T) {
+	want := &Snapshot{
+		Image:     "md-snapshot-md-repo-main:t",
+		Container: "md-repo-main",
+		Repos:     []Repo{{GitRoot: "/src/repo", Branch: "main"}},
+		Created:   time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC),
+		Labels:    map[string]string{"md.display": "1"},
+	}
+	data, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parseSnapshotLabel(base64.StdEncoding.EncodeToString(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+	ct := &Container{}
+	for k, v := range got.Labels {
+		ct.setLabel(k, v)
+	}
+	if !ct.Display {
+		t.Error("Display label not applied")
+	}
+	for _, v := range []string{"!", base64.StdEncoding.EncodeToString([]byte(`{"image":"x"}`))} {
+		if _, err := parseSnapshotLabel(v); err == nil {
+			t.Errorf("parseSnapshotLabel(%q) succeeded", v)
+		}
+	}
+}
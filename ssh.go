@@ -5,15 +5,24 @@
 package md
 
 import (
+	"context"
 	"crypto/ed25519"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha1" //nolint:gosec // known_hosts hashing is defined with HMAC-SHA1.
+	"encoding/base64"
 	"encoding/pem"
 	"fmt"
 	"io"
+	"log/slog"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 )
@@ -72,24 +81,63 @@ func controlSocketPath(containerName string) string {
 	return filepath.Join(os.TempDir(), "md-"+containerName+".sock")
 }
 
+// sshEndpoint is how ssh reaches a container's sshd.
+type sshEndpoint struct {
+	// Host is the address Port is published on; empty for 127.0.0.1.
+	Host string
+	// Port is the host port the container's port 22 is published on.
+	Port int32
+	// ProxyJump, when set, is the ssh destination of the remote machine
+	// Port is published on.
+	ProxyJump string
+	// ProxyCommand, when set, reaches sshd through the command's stdio
+	// instead, e.g. over kubectl exec.
+	ProxyCommand string
+}
+
+// host returns the address to connect to.
+func (ep *sshEndpoint) host() string {
+	if ep.Host == "" {
+		return "127.0.0.1"
+	}
+	return ep.Host
+}
+
 // writeSSHConfig writes the SSH config file for a container.
 // When controlMaster is true, ControlMaster/ControlPath/ControlPersist
 // directives are included for connection multiplexing.
-func writeSSHConfig(configDir, containerName string, port int32, identityFile, knownHostsFile string, controlMaster bool) error {
+func writeSSHConfig(configDir, containerName string, ep sshEndpoint, identityFile, knownHostsFile string, controlMaster bool) error {
 	confPath := filepath.Join(configDir, containerName+".conf")
-	content := fmt.Sprintf(
-		"Host %s\n"+
-			"  HostName 127.0.0.1\n"+
-			"  Port %d\n"+
-			"  User user\n"+
+	content := "Host " + containerName + "\n"
+	if ep.ProxyCommand != "" {
+		content += "  ProxyCommand " + ep.ProxyCommand + "\n"
+	} else {
+		family := "inet"
+		if ip := net.ParseIP(ep.host()); ip != nil && ip.To4() == nil {
+			family = "inet6"
+		}
+		content += fmt.Sprintf(
+			"  HostName %s\n"+
+				"  Port %d\n"+
+				"  AddressFamily %s\n",
+			ep.host(), ep.Port, family)
+		if ep.ProxyJump != "" {
+			content += "  ProxyJump " + ep.ProxyJump + "\n"
+		}
+	}
+	// The host key is pinned to the container's name rather than to the
+	// address and port it is reached at, which change when the engine
+	// restarts it.
+	content += sshHostKeyOptions(containerName)
+	content += fmt.Sprintf(
+		"  User user\n"+
 			"  IdentityFile %s\n"+
 			"  IdentitiesOnly yes\n"+
 			"  UserKnownHostsFile %s\n"+
 			"  StrictHostKeyChecking yes\n"+
-			"  AddressFamily inet\n"+
 			"  GSSAPIAuthentication no\n"+
 			"  PreferredAuthentications publickey\n",
-		containerName, port, identityFile, knownHostsFile)
+		identityFile, knownHostsFile)
 	if controlMaster {
 		content += fmt.Sprintf(
 			"  ControlMaster auto\n"+
@@ -97,15 +145,133 @@ func writeSSHConfig(configDir, containerName string, port int32, identityFile, k
 				"  ControlPersist 5s\n",
 			controlSocketPath(containerName))
 	}
+	if runtime.GOOS != "windows" {
+		// Record direct ssh sessions too, for md gc.
+		content += fmt.Sprintf(
+			"  PermitLocalCommand yes\n"+
+				"  LocalCommand touch %q\n",
+			lastUsedPath(configDir, containerName))
+	}
 	return os.WriteFile(confPath, []byte(content), 0o600)
 }
 
-// writeKnownHosts writes the known hosts file for a container.
-func writeKnownHosts(knownHostsPath string, port int32, hostPubKey string) error {
-	content := fmt.Sprintf("[127.0.0.1]:%d %s\n", port, hostPubKey)
+// lastUsedPath returns the file whose modification time records when the
+// container was last used. Docker labels can't be updated after creation, so
+// this is tracked on the host next to the SSH config.
+func lastUsedPath(configDir, containerName string) string {
+	return filepath.Join(configDir, containerName+".last_used")
+}
+
+// touchLastUsed marks the container as used now.
+func touchLastUsed(configDir, containerName string) {
+	p := lastUsedPath(configDir, containerName)
+	now := time.Now()
+	if err := os.Chtimes(p, now, now); err != nil {
+		_ = os.WriteFile(p, nil, 0o600)
+	}
+}
+
+// sshHostKeyOptions returns the SSH config options looking up the host key
+// of a container by its name.
+func sshHostKeyOptions(containerName string) string {
+	return "  HostKeyAlias " + containerName + "\n" +
+		"  CheckHostIP no\n"
+}
+
+// writeKnownHosts writes the known hosts file for a container: its host key
+// under the container's name, hashed like "ssh-keygen -H" does, as ssh
+// looks it up with HostKeyAlias.
+func writeKnownHosts(knownHostsPath, containerName, hostPubKey string) error {
+	salt := make([]byte, sha1.Size)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	content := hashKnownHost(containerName, salt) + " " + hostPubKey + "\n"
 	return os.WriteFile(knownHostsPath, []byte(content), 0o600) //nolint:gosec // path is constructed from trusted config dir
 }
 
+// hashKnownHost returns the hashed known_hosts host field of name with salt:
+// "|1|" then the salt and the HMAC-SHA1 of name keyed by the salt, both in
+// base64.
+func hashKnownHost(name string, salt []byte) string {
+	h := hmac.New(sha1.New, salt)
+	h.Write([]byte(name))
+	return "|1|" + base64.StdEncoding.EncodeToString(salt) + "|" + base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// knownHostsHas reports whether the known_hosts content data has an entry
+// for name, hashed or not.
+func knownHostsHas(data []byte, name string) bool {
+	for line := range strings.SplitSeq(string(data), "\n") {
+		hosts, _, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if !ok {
+			continue
+		}
+		if rest, ok := strings.CutPrefix(hosts, "|1|"); ok {
+			salt64, _, _ := strings.Cut(rest, "|")
+			if salt, err := base64.StdEncoding.DecodeString(salt64); err == nil && hashKnownHost(name, salt) == hosts {
+				return true
+			}
+			continue
+		}
+		if slices.Contains(strings.Split(hosts, ","), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// refreshSSHConfig rewrites the container's SSH config when the host port
+// its sshd is published on changed, e.g. after the engine restarted it, and
+// replaces a known_hosts file keyed by address and port, written by previous
+// versions, with one keyed by the container's name. The rest of the config
+// is left as is. A stopped container, or one without a published port, is
+// left alone.
+func (c *Container) refreshSSHConfig(ctx context.Context) error {
+	if c.Kube != nil {
+		return nil
+	}
+	sshConfigDir := filepath.Join(c.Home, ".ssh", "config.d")
+	confPath := filepath.Join(sshConfigDir, c.Name+".conf")
+	knownHostsPath := filepath.Join(sshConfigDir, c.Name+".known_hosts")
+	conf, err := os.ReadFile(confPath)
+	if err != nil {
+		return nil
+	}
+	lines := strings.Split(string(conf), "\n")
+	portLine := slices.IndexFunc(lines, func(l string) bool { return strings.HasPrefix(l, "  Port ") })
+	if portLine < 0 {
+		return nil
+	}
+	port, err := getHostPort(ctx, c.Runtime, c.Name, "22/tcp")
+	if err != nil || port == 0 {
+		return nil
+	}
+	known, _ := os.ReadFile(knownHostsPath)
+	hasAlias := slices.Contains(lines, "  HostKeyAlias "+c.Name)
+	if lines[portLine] == fmt.Sprintf("  Port %d", port) && hasAlias && knownHostsHas(known, c.Name) {
+		return nil
+	}
+	slog.InfoContext(ctx, "md", "msg", "refreshing SSH config", "container", c.Name, "port", port)
+	lines[portLine] = fmt.Sprintf("  Port %d", port)
+	if !hasAlias {
+		lines = slices.Insert(lines, portLine+1, strings.Split(strings.TrimSuffix(sshHostKeyOptions(c.Name), "\n"), "\n")...)
+	}
+	c.SSHPort = port
+	cleanupControlSocket(c.Name)
+	if err := os.WriteFile(confPath, []byte(strings.Join(lines, "\n")), 0o600); err != nil {
+		return fmt.Errorf("writing SSH config: %w", err)
+	}
+	hostPubKey, err := os.ReadFile(c.HostKeyPath + ".pub")
+	if err != nil {
+		return fmt.Errorf("reading host public key: %w", err)
+	}
+	if err := writeKnownHosts(knownHostsPath, c.Name, strings.TrimSpace(string(hostPubKey))); err != nil {
+		return fmt.Errorf("writing known_hosts: %w", err)
+	}
+	return nil
+}
+
 // ensureSSHConfigInclude ensures ~/.ssh/config contains an Include directive
 // for config.d/*.conf. When the config file doesn't exist, it is created.
 // When it exists but the directive is missing, a warning is printed and the
@@ -113,29 +279,40 @@ func writeKnownHosts(knownHostsPath string, port int32, hostPubKey string) error
 // command line.
 func ensureSSHConfigInclude(w io.Writer, sshDir string) (missing bool, err error) {
 	configPath := filepath.Join(sshDir, "config")
-	needle := "Include config.d/*.conf"
 	data, err := os.ReadFile(configPath)
 	if err != nil && !os.IsNotExist(err) {
 		return false, err
 	}
-	// Check whether the Include is already present.
-	for line := range strings.SplitSeq(string(data), "\n") {
-		if strings.TrimSpace(line) == needle {
-			return false, nil
-		}
+	if hasSSHConfigInclude(data) {
+		return false, nil
 	}
 	if len(data) == 0 {
 		// No config file (or empty): safe to create.
-		content := "# Load all configuration files in config.d/.\n" + needle + "\n"
+		content := "# Load all configuration files in config.d/.\n" + sshConfigInclude + "\n"
 		return false, os.WriteFile(configPath, []byte(content), 0o600)
 	}
 	// Existing config without the directive: warn and compensate via CLI flags.
 	_, _ = fmt.Fprintf(w, "WARNING: %s is missing the Include directive for per-container SSH configs.\n", configPath)
 	_, _ = fmt.Fprintf(w, "  Consider adding the following line at the top of %s:\n", configPath)
-	_, _ = fmt.Fprintf(w, "    %s\n", needle)
+	_, _ = fmt.Fprintf(w, "    %s\n", sshConfigInclude)
 	return true, nil
 }
 
+// sshConfigInclude is the ~/.ssh/config directive loading the per-container
+// SSH configs.
+const sshConfigInclude = "Include config.d/*.conf"
+
+// hasSSHConfigInclude reports whether the ~/.ssh/config content data holds
+// sshConfigInclude.
+func hasSSHConfigInclude(data []byte) bool {
+	for line := range strings.SplitSeq(string(data), "\n") {
+		if strings.TrimSpace(line) == sshConfigInclude {
+			return true
+		}
+	}
+	return false
+}
+
 // removeSSHConfig removes SSH config and known_hosts files for a container.
 // It also closes any active ControlMaster connection and removes the socket.
 func removeSSHConfig(configDir, containerName string) {
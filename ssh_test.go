@@ -0,0 +1,33 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestKnownHostsHas(t *testing.T) {
+	// "ssh-keygen -F md-repo-main" finds this entry.
+	salt := bytes.Repeat([]byte{1}, 20)
+	if got, want := hashKnownHost("md-repo-main", salt), "|1|AQEBAQEBAQEBAQEBAQEBAQEBAQE=|yRpwX4Mn0xA+rIXTrIDb1J/PvLE="; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	for _, tc := range []struct {
+		data string
+		want bool
+	}{
+		{hashKnownHost("md-repo-main", salt) + " ssh-ed25519 AAAA\n", true},
+		{hashKnownHost("md-repo-other", salt) + " ssh-ed25519 AAAA\n", false},
+		{"md-repo-main ssh-ed25519 AAAA\n", true},
+		{"other,md-repo-main ssh-ed25519 AAAA\n", true},
+		{"[127.0.0.1]:32768 ssh-ed25519 AAAA\n", false},
+		{"", false},
+	} {
+		if got := knownHostsHas([]byte(tc.data), "md-repo-main"); got != tc.want {
+			t.Errorf("%q: got %v", tc.data, got)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/caic-xyz/md/gitutil"
+)
+
+// Status is the state of a container and of its repositories, as returned by
+// [Container.Status].
+type Status struct {
+	Name string `json:"name"`
+	// State is the container state (e.g. "running", "exited").
+	State string `json:"state"`
+	// SSHPort and VNCPort are the host ports mapped to the container's SSH
+	// and first VNC ports; zero when the container isn't running or has no
+	// display.
+	SSHPort int32 `json:"ssh_port,omitempty"`
+	VNCPort int32 `json:"vnc_port,omitempty"`
+	// TailscaleFQDN is the container's name on the tailnet, if any.
+	TailscaleFQDN string `json:"tailscale_fqdn,omitempty"`
+	// HostPorts tells whether the host services of [Container.HostPorts]
+	// answer from the container.
+	HostPorts []HostPortStatus `json:"host_ports,omitempty"`
+	Repos     []RepoStatus     `json:"repos,omitempty"`
+	Services  []ServiceStatus  `json:"services,omitempty"`
+	// Sidecars are the services of the primary repository's [ComposeFile].
+	Sidecars []Sidecar `json:"sidecars,omitempty"`
+	// Disk is the disk usage measured inside the container, if available,
+	// and DiskWarnings the thresholds of [Config.DiskThresholds] it crossed.
+	Disk         *FilesystemUsage `json:"disk,omitempty"`
+	DiskWarnings []DiskWarning    `json:"disk_warnings,omitempty"`
+}
+
+// RepoStatus compares a repository on the host with its copy in the
+// container. The counts are only set while the container is running.
+type RepoStatus struct {
+	Name   string `json:"name"`
+	Branch string `json:"branch"`
+	// Base and Head are the container's base branch and HEAD commits. Base
+	// is empty for a mounted checkout.
+	Base string `json:"base,omitempty"`
+	Head string `json:"head,omitempty"`
+	// LocalAhead counts the host branch's commits missing from base, which
+	// md push would send; LocalBehind counts base's commits missing from the
+	// host branch, e.g. after a rebase on the host.
+	LocalAhead  int `json:"local_ahead"`
+	LocalBehind int `json:"local_behind"`
+	// ContainerAhead counts the commits on the container's HEAD since base,
+	// which md pull would bring; ContainerBehind counts base's commits
+	// missing from it, e.g. after a reset in the container.
+	ContainerAhead  int `json:"container_ahead"`
+	ContainerBehind int `json:"container_behind"`
+	// Staged, Unstaged and Untracked count the files with uncommitted
+	// changes in the container's working tree.
+	Staged    int `json:"staged"`
+	Unstaged  int `json:"unstaged"`
+	Untracked int `json:"untracked"`
+}
+
+// repoStatusScript prints the state of the repository in the current
+// directory for parseRepoStatus.
+const repoStatusScript = `echo "head $(git rev-parse HEAD)"; ` +
+	`echo "base $(git rev-parse -q --verify base)"; ` +
+	`echo "count $(git rev-list --left-right --count base...HEAD 2>/dev/null)"; ` +
+	`echo status; git status --porcelain`
+
+// Status returns the state of the container and of each of its repositories.
+// The container's State must be set, as done by [Client.List]. Only the
+// state and the repositories' names and branches are known for a container
+// that isn't running.
+func (c *Container) Status(ctx context.Context) (*Status, error) {
+	s := &Status{Name: c.Name, State: c.State, Repos: make([]RepoStatus, len(c.Repos))}
+	for i, r := range c.Repos {
+		s.Repos[i] = RepoStatus{Name: r.Name(), Branch: r.Branch}
+	}
+	if c.State != "running" {
+		return s, nil
+	}
+	var err error
+	if s.SSHPort, err = c.GetHostPort(ctx, "22/tcp"); err != nil {
+		return nil, err
+	}
+	if c.Display {
+		s.VNCPort, _ = c.GetHostPort(ctx, VNCContainerPort(1))
+	}
+	s.TailscaleFQDN = c.TailscaleFQDN(ctx)
+	if s.HostPorts, err = c.HostPortsStatus(ctx); err != nil {
+		return nil, err
+	}
+	for i, r := range c.Repos {
+		out, err := runCmdRetry(ctx, "", c.SSHCommand(c.Name, "cd ~/src/"+shellQuote(r.Name())+" && { "+repoStatusScript+"; }"))
+		if err != nil {
+			return nil, fmt.Errorf("querying %s in the container: %w", r.Name(), err)
+		}
+		parseRepoStatus(&s.Repos[i], out)
+		if s.Repos[i].Base != "" {
+			// The host pushed base, so it has the commit unless it was
+			// garbage collected since.
+			if counts, err := gitutil.RunGit(ctx, r.GitRoot, "rev-list", "--left-right", "--count", s.Repos[i].Base+"..."+r.Branch); err == nil {
+				s.Repos[i].LocalBehind, s.Repos[i].LocalAhead = parseLeftRight(counts)
+			}
+		}
+	}
+	if s.Services, err = c.Services(ctx); err != nil {
+		return nil, err
+	}
+	if s.Sidecars, err = c.SidecarStatus(ctx); err != nil {
+		return nil, err
+	}
+	s.Disk, s.DiskWarnings = c.diskWarnings(ctx)
+	return s, nil
+}
+
+// parseRepoStatus parses the output of repoStatusScript into rs.
+func parseRepoStatus(rs *RepoStatus, out string) {
+	inStatus := false
+	for line := range strings.SplitSeq(out, "\n") {
+		if inStatus {
+			if len(line) < 3 {
+				continue
+			}
+			if line[:2] == "??" {
+				rs.Untracked++
+				continue
+			}
+			if line[0] != ' ' {
+				rs.Staged++
+			}
+			if line[1] != ' ' {
+				rs.Unstaged++
+			}
+			continue
+		}
+		k, v, _ := strings.Cut(line, " ")
+		switch k {
+		case "head":
+			rs.Head = v
+		case "base":
+			rs.Base = v
+		case "count":
+			rs.ContainerBehind, rs.ContainerAhead = parseLeftRight(v)
+		case "status":
+			inStatus = true
+		}
+	}
+}
+
+// parseLeftRight parses the "<left>\t<right>" output of git rev-list
+// --left-right --count.
+func parseLeftRight(s string) (int, int) {
+	f := strings.Fields(s)
+	if len(f) != 2 {
+		return 0, 0
+	}
+	left, _ := strconv.Atoi(f[0])
+	right, _ := strconv.Atoi(f[1])
+	return left, right
+}
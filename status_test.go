@@ -0,0 +1,24 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import "testing"
+
+func TestParseRepoStatus(t *testing.T) {
+	out := "head 2222\nbase 1111\ncount 1\t3\nstatus\nM  staged.go\n M edited.go\nMM both.go\n?? new.go\n?? other/"
+	var got RepoStatus
+	parseRepoStatus(&got, out)
+	want := RepoStatus{Base: "1111", Head: "2222", ContainerAhead: 3, ContainerBehind: 1, Staged: 2, Unstaged: 2, Untracked: 2}
+	if got != want {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+
+	// A mounted checkout has no base branch.
+	got = RepoStatus{}
+	parseRepoStatus(&got, "head 2222\nbase \ncount \nstatus\n")
+	if want := (RepoStatus{Head: "2222"}); got != want {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SudoPolicy selects what the container's user may run with sudo.
+//
+// The policy is written as a sudoers fragment into the specialized image, so
+// each policy has its own image, and recorded in the md.sudo label.
+type SudoPolicy string
+
+const (
+	// SudoDefault leaves the base image's sudoers untouched. md's images
+	// grant the user no sudo.
+	SudoDefault SudoPolicy = ""
+	// SudoFull grants passwordless sudo for any command. The agent can
+	// modify the sandbox arbitrarily, its guardrails included.
+	SudoFull SudoPolicy = "full"
+	// SudoLimited grants passwordless sudo for [SudoAllowlist] only.
+	SudoLimited SudoPolicy = "limited"
+	// SudoNone denies sudo, overriding any rule of the base image, e.g. a
+	// devcontainer image granting it.
+	SudoNone SudoPolicy = "none"
+)
+
+// SudoAllowlist lists the commands [SudoLimited] allows, to install
+// packages. Package maintainer scripts run as root, so this keeps honest
+// agents from tampering with the sandbox rather than stopping a determined
+// one.
+var SudoAllowlist = []string{
+	"/usr/bin/apt-get update",
+	"/usr/bin/apt-get install *",
+	"/usr/bin/apt update",
+	"/usr/bin/apt install *",
+}
+
+// sudoersPath is where the fragment goes. sudo reads /etc/sudoers.d in
+// lexical order and the last matching rule wins, so it sorts last.
+const sudoersPath = "/etc/sudoers.d/zz-md"
+
+// ParseSudoPolicy parses a --sudo policy.
+func ParseSudoPolicy(s string) (SudoPolicy, error) {
+	switch p := SudoPolicy(s); p {
+	case SudoDefault, SudoFull, SudoLimited, SudoNone:
+		return p, nil
+	}
+	return "", fmt.Errorf("invalid sudo policy %q: want full, limited or none", s)
+}
+
+// sudoersFragment returns the sudoers fragment implementing p, "" for
+// SudoDefault.
+func sudoersFragment(p SudoPolicy) string {
+	switch p {
+	case SudoFull:
+		return "user ALL=(ALL:ALL) NOPASSWD: ALL\n"
+	case SudoLimited:
+		// Deny everything first so a broader rule of the base image doesn't
+		// win over the allowlist.
+		return "user ALL=(ALL:ALL) !ALL\nuser ALL=(root) NOPASSWD: " + strings.Join(SudoAllowlist, ", ") + "\n"
+	case SudoNone:
+		return "user ALL=(ALL:ALL) !ALL\n"
+	}
+	return ""
+}
+
+// sudoImageKey adds the sudo policy to the cache key of the specialized
+// image, so each policy gets its own image.
+func sudoImageKey(cacheKey string, p SudoPolicy) string {
+	if p == SudoDefault {
+		return cacheKey
+	}
+	return cacheKey + "+sudo-" + string(p)
+}
@@ -0,0 +1,60 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseSudoPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want SudoPolicy
+	}{
+		{"", SudoDefault},
+		{"full", SudoFull},
+		{"limited", SudoLimited},
+		{"none", SudoNone},
+	} {
+		got, err := ParseSudoPolicy(tc.in)
+		if err != nil || got != tc.want {
+			t.Errorf("ParseSudoPolicy(%q) = %q, %v, want %q", tc.in, got, err, tc.want)
+		}
+	}
+	if _, err := ParseSudoPolicy("root"); err == nil {
+		t.Error("want error")
+	}
+}
+
+func TestSudoersFragment(t *testing.T) {
+	if got := sudoersFragment(SudoDefault); got != "" {
+		t.Errorf("default: %q", got)
+	}
+	if got, want := sudoersFragment(SudoFull), "user ALL=(ALL:ALL) NOPASSWD: ALL\n"; got != want {
+		t.Errorf("full: %q, want %q", got, want)
+	}
+	if got, want := sudoersFragment(SudoNone), "user ALL=(ALL:ALL) !ALL\n"; got != want {
+		t.Errorf("none: %q, want %q", got, want)
+	}
+	// The allowlist comes last so it wins over the denial.
+	lines := strings.Split(strings.TrimSuffix(sudoersFragment(SudoLimited), "\n"), "\n")
+	if len(lines) != 2 || lines[0] != "user ALL=(ALL:ALL) !ALL" || !strings.HasPrefix(lines[1], "user ALL=(root) NOPASSWD: /usr/bin/apt-get update, ") {
+		t.Errorf("limited: %q", lines)
+	}
+}
+
+func TestSudoImageKey(t *testing.T) {
+	if got := sudoImageKey("abc", SudoDefault); got != "abc" {
+		t.Errorf("got %q", got)
+	}
+	keys := map[string]bool{}
+	for _, p := range []SudoPolicy{SudoDefault, SudoFull, SudoLimited, SudoNone} {
+		keys[userImageName("base", sudoImageKey("abc", p))] = true
+	}
+	if len(keys) != 4 {
+		t.Errorf("image names collide: %v", keys)
+	}
+}
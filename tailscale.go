@@ -13,6 +13,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"regexp"
 	"strings"
 	"time"
 )
@@ -23,7 +24,7 @@ type tailscaleAuthKeyResponse struct {
 
 // generateTailscaleAuthKey creates a one-time ephemeral pre-authorized
 // Tailscale auth key via the API.
-func generateTailscaleAuthKey(ctx context.Context, apiKey string) (string, error) {
+func generateTailscaleAuthKey(ctx context.Context, client *http.Client, apiKey string) (string, error) {
 	if apiKey == "" {
 		return "", errors.New("no Tailscale API key provided, create an API access key at https://login.tailscale.com/admin/settings/keys")
 	}
@@ -50,7 +51,6 @@ func generateTailscaleAuthKey(ctx context.Context, apiKey string) (string, error
 	}
 	req.Header.Set("Authorization", "Bearer "+apiKey)
 	req.Header.Set("Content-Type", "application/json")
-	client := &http.Client{Timeout: 10 * time.Second}
 	resp, err := client.Do(req)
 	if err != nil {
 		return "", fmt.Errorf("network error: %w", err)
@@ -75,7 +75,7 @@ func generateTailscaleAuthKey(ctx context.Context, apiKey string) (string, error
 }
 
 // deleteTailscaleDevice deletes a Tailscale device using the API.
-func deleteTailscaleDevice(ctx context.Context, apiKey, deviceID string) error {
+func deleteTailscaleDevice(ctx context.Context, client *http.Client, apiKey, deviceID string) error {
 	if apiKey == "" {
 		return nil
 	}
@@ -91,7 +91,6 @@ func deleteTailscaleDevice(ctx context.Context, apiKey, deviceID string) error {
 		return fmt.Errorf("creating request: %w", err)
 	}
 	req.Header.Set("Authorization", "Bearer "+apiKey)
-	client := &http.Client{Timeout: 10 * time.Second}
 	resp, err := client.Do(req)
 	if err != nil {
 		return fmt.Errorf("deleting device: %w", err)
@@ -102,3 +101,83 @@ func deleteTailscaleDevice(ctx context.Context, apiKey, deviceID string) error {
 	}
 	return nil
 }
+
+// tailscaleAuthFile is where start.sh saves the output of tailscale up when
+// no auth key was provided.
+const tailscaleAuthFile = "/tmp/tailscale_auth_url"
+
+// TailscaleAuth is the login state of a container's Tailscale node.
+type TailscaleAuth struct {
+	// State is tailscaled's backend state: "NeedsLogin", "Starting",
+	// "Running", ... Empty while tailscaled isn't up yet.
+	State string `json:"state"`
+	// URL is the login URL while the node waits for the user.
+	URL string `json:"url,omitempty"`
+	// FQDN is the node's name once it is logged in.
+	FQDN string `json:"fqdn,omitempty"`
+}
+
+// Ready reports whether there is something for the user: the login URL to
+// visit or, once logged in, the node's name.
+func (a *TailscaleAuth) Ready() bool {
+	return a.URL != "" || a.FQDN != ""
+}
+
+var reTailscaleURL = regexp.MustCompile(`https://\S+`)
+
+// parseTailscaleAuth returns the login state from the output of tailscale
+// status --json and the content of tailscaleAuthFile, either possibly empty.
+func parseTailscaleAuth(statusJSON, authFile string) *TailscaleAuth {
+	a := &TailscaleAuth{}
+	var status tailscaleStatus
+	if json.Unmarshal([]byte(statusJSON), &status) == nil {
+		a.State = status.BackendState
+		a.URL = status.AuthURL
+		if status.BackendState == "Running" {
+			a.FQDN = strings.TrimRight(status.Self.DNSName, ".")
+			a.URL = ""
+			return a
+		}
+	}
+	if a.URL == "" {
+		a.URL = reTailscaleURL.FindString(authFile)
+	}
+	return a
+}
+
+// TailscaleStatus returns the login state of the container's Tailscale node.
+func (c *Container) TailscaleStatus(ctx context.Context) (*TailscaleAuth, error) {
+	if !c.Tailscale {
+		return nil, fmt.Errorf("%s wasn't started with Tailscale", c.Name)
+	}
+	if c.State != "running" {
+		return nil, fmt.Errorf("container %s is not running", c.Name)
+	}
+	// tailscale status fails while tailscaled starts: that is a state, not
+	// an error.
+	statusJSON, _ := runCmd(ctx, "", []string{c.Runtime, "exec", c.Name, "tailscale", "status", "--json"})
+	authFile, _ := runCmd(ctx, "", []string{c.Runtime, "exec", c.Name, "cat", tailscaleAuthFile})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return parseTailscaleAuth(statusJSON, authFile), nil
+}
+
+// WaitTailscaleAuth polls the container's Tailscale node until its login URL
+// is known or it is logged in, without blocking md start: [Container.Connect]
+// returns with [StartResult.TailscaleAuthPending] set instead. Bound the wait
+// with ctx.
+func (c *Container) WaitTailscaleAuth(ctx context.Context) (*TailscaleAuth, error) {
+	for {
+		a, err := c.TailscaleStatus(ctx)
+		if err == nil && a.Ready() {
+			return a, nil
+		}
+		if err == nil {
+			err = sleepCtx(ctx, 500*time.Millisecond)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("waiting for the Tailscale login of %s: %w", c.Name, err)
+		}
+	}
+}
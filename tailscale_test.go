@@ -0,0 +1,67 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestParseTailscaleAuth(t *testing.T) {
+	const authFile = "\nTo authenticate, visit:\n\n\thttps://login.tailscale.com/a/abc123\n\n"
+	tests := []struct {
+		name       string
+		statusJSON string
+		authFile   string
+		want       TailscaleAuth
+	}{
+		{"starting", "", "", TailscaleAuth{}},
+		{"file", "", authFile, TailscaleAuth{URL: "https://login.tailscale.com/a/abc123"}},
+		{"status", `{"BackendState":"NeedsLogin","AuthURL":"https://login.tailscale.com/a/def"}`, authFile, TailscaleAuth{State: "NeedsLogin", URL: "https://login.tailscale.com/a/def"}},
+		{"waiting", `{"BackendState":"NeedsLogin"}`, "", TailscaleAuth{State: "NeedsLogin"}},
+		{"running", `{"BackendState":"Running","Self":{"DNSName":"md-r-main.tail1234.ts.net."}}`, authFile, TailscaleAuth{State: "Running", FQDN: "md-r-main.tail1234.ts.net"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseTailscaleAuth(tt.statusJSON, tt.authFile); *got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWaitTailscaleAuth(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell")
+	}
+	// The fake engine serves exec cat from dir; tailscale status fails as
+	// when tailscaled is starting.
+	dir := t.TempDir()
+	engine := filepath.Join(dir, "docker")
+	script := "#!/bin/sh\n[ \"$3\" = cat ] && exec cat " + filepath.Join(dir, "auth") + "\nexit 1\n"
+	if err := os.WriteFile(engine, []byte(script), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	c := &Container{Client: &Client{Runtime: engine}, Name: "md-r-main", State: "running", Tailscale: true}
+
+	ctx, cancel := context.WithTimeout(t.Context(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := c.WaitTailscaleAuth(ctx); err == nil {
+		t.Fatal("expected a timeout")
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_ = os.WriteFile(filepath.Join(dir, "auth"), []byte("To authenticate, visit:\n\thttps://login.tailscale.com/a/abc123\n"), 0o600)
+	}()
+	a, err := c.WaitTailscaleAuth(t.Context())
+	if err != nil || a.URL != "https://login.tailscale.com/a/abc123" {
+		t.Fatalf("got %+v, %v", a, err)
+	}
+}
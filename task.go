@@ -0,0 +1,137 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caic-xyz/md/gitutil"
+)
+
+// DefaultAgent is the command md task runs in the container when none is
+// configured. The task prompt is appended as its last argument.
+const DefaultAgent = "claude -p"
+
+// githubAPI is the GitHub REST API base URL. Overridden in tests.
+var githubAPI = "https://api.github.com"
+
+// Issue is a GitHub issue turned into an agent task.
+type Issue struct {
+	Owner  string
+	Repo   string
+	Number int
+	Title  string
+	Body   string
+	URL    string
+	Labels []string
+}
+
+// ParseGitHubRepo returns the owner and name of the GitHub repository at the
+// git remote URL remote.
+func ParseGitHubRepo(remote string) (owner, repo string, err error) {
+	u, err := url.Parse(gitutil.RemoteToHTTPS(remote))
+	if err != nil || u.Host != "github.com" {
+		return "", "", fmt.Errorf("%q is not a GitHub repository", remote)
+	}
+	owner, repo, ok := strings.Cut(strings.Trim(u.Path, "/"), "/")
+	if !ok || owner == "" || repo == "" || strings.Contains(repo, "/") {
+		return "", "", fmt.Errorf("%q is not a GitHub repository", remote)
+	}
+	return owner, repo, nil
+}
+
+// ParseIssueRef parses "123", "#123" or an issue URL
+// (https://github.com/owner/repo/issues/123). owner and repo are empty unless
+// given by the URL.
+func ParseIssueRef(ref string) (owner, repo string, number int, err error) {
+	s := strings.TrimPrefix(ref, "#")
+	if u, err := url.Parse(ref); err == nil && u.Host == "github.com" {
+		parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+		if len(parts) != 4 || parts[2] != "issues" {
+			return "", "", 0, fmt.Errorf("invalid issue URL %q", ref)
+		}
+		owner, repo, s = parts[0], parts[1], parts[3]
+	}
+	number, err = strconv.Atoi(s)
+	if err != nil || number <= 0 {
+		return "", "", 0, fmt.Errorf("invalid issue %q: use a number or an issue URL", ref)
+	}
+	return owner, repo, number, nil
+}
+
+// FetchIssue fetches a GitHub issue. token may be empty for public
+// repositories.
+func FetchIssue(ctx context.Context, token, owner, repo string, number int) (*Issue, error) {
+	u := fmt.Sprintf("%s/repos/%s/%s/issues/%d", githubAPI, url.PathEscape(owner), url.PathEscape(repo), number)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("User-Agent", "md")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s/%s#%d: %s", owner, repo, number, resp.Status)
+	}
+	var raw struct {
+		Title       string          `json:"title"`
+		Body        string          `json:"body"`
+		HTMLURL     string          `json:"html_url"`
+		PullRequest json.RawMessage `json:"pull_request"`
+		Labels      []struct {
+			Name string `json:"name"`
+		} `json:"labels"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("decoding %s/%s#%d: %w", owner, repo, number, err)
+	}
+	if raw.PullRequest != nil {
+		return nil, fmt.Errorf("%s/%s#%d is a pull request, not an issue", owner, repo, number)
+	}
+	issue := &Issue{Owner: owner, Repo: repo, Number: number, Title: raw.Title, Body: raw.Body, URL: raw.HTMLURL}
+	for _, l := range raw.Labels {
+		issue.Labels = append(issue.Labels, l.Name)
+	}
+	return issue, nil
+}
+
+// Branch returns the branch name for working on the issue, e.g.
+// "issue-123-fix-the-parser".
+func (i *Issue) Branch() string {
+	name := "issue-" + strconv.Itoa(i.Number)
+	if slug := Slugify(i.Title); slug != "" {
+		name += "-" + slug
+	}
+	return name
+}
+
+// Prompt renders the issue as the task given to the agent.
+func (i *Issue) Prompt() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", i.Title)
+	fmt.Fprintf(&b, "GitHub issue %s/%s#%d: %s\n", i.Owner, i.Repo, i.Number, i.URL)
+	if len(i.Labels) > 0 {
+		fmt.Fprintf(&b, "Labels: %s\n", strings.Join(i.Labels, ", "))
+	}
+	if body := strings.TrimSpace(i.Body); body != "" {
+		b.WriteString("\n" + body + "\n")
+	}
+	b.WriteString("\nResolve this issue in the repository. Commit your changes with a message referencing the issue.\n")
+	return b.String()
+}
@@ -0,0 +1,88 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseGitHubRepo(t *testing.T) {
+	for _, remote := range []string{"git@github.com:caic-xyz/md.git", "https://github.com/caic-xyz/md", "ssh://git@github.com/caic-xyz/md.git"} {
+		owner, repo, err := ParseGitHubRepo(remote)
+		if err != nil || owner != "caic-xyz" || repo != "md" {
+			t.Errorf("%s: got %q, %q, %v", remote, owner, repo, err)
+		}
+	}
+	for _, remote := range []string{"", "git@gitlab.com:a/b.git", "https://github.com/a"} {
+		if _, _, err := ParseGitHubRepo(remote); err == nil {
+			t.Errorf("%s: expected error", remote)
+		}
+	}
+}
+
+func TestParseIssueRef(t *testing.T) {
+	type want struct {
+		owner, repo string
+		number      int
+	}
+	for ref, w := range map[string]want{
+		"123": {"", "", 123},
+		"#7":  {"", "", 7},
+		"https://github.com/caic-xyz/md/issues/42": {"caic-xyz", "md", 42},
+	} {
+		owner, repo, n, err := ParseIssueRef(ref)
+		if err != nil || (want{owner, repo, n}) != w {
+			t.Errorf("%s: got %q, %q, %d, %v", ref, owner, repo, n, err)
+		}
+	}
+	for _, ref := range []string{"", "x", "0", "https://github.com/caic-xyz/md/pull/42"} {
+		if _, _, _, err := ParseIssueRef(ref); err == nil {
+			t.Errorf("%s: expected error", ref)
+		}
+	}
+}
+
+func TestFetchIssue(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/o/r/issues/1":
+			if r.Header.Get("Authorization") != "Bearer tok" {
+				t.Errorf("Authorization = %q", r.Header.Get("Authorization"))
+			}
+			_, _ = w.Write([]byte(`{"title": "Crash on empty input!", "body": "Steps:\n1. run", "html_url": "https://github.com/o/r/issues/1", "labels": [{"name": "bug"}]}`))
+		case "/repos/o/r/issues/2":
+			_, _ = w.Write([]byte(`{"title": "PR", "pull_request": {}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	old := githubAPI
+	githubAPI = srv.URL
+	defer func() { githubAPI = old }()
+
+	issue, err := FetchIssue(t.Context(), "tok", "o", "r", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if issue.Title != "Crash on empty input!" || !slices.Equal(issue.Labels, []string{"bug"}) {
+		t.Errorf("got %+v", issue)
+	}
+	if got := issue.Branch(); got != "issue-1-crash-on-empty-input" {
+		t.Errorf("Branch() = %q", got)
+	}
+	if p := issue.Prompt(); !strings.HasPrefix(p, "# Crash on empty input!\n") || !strings.Contains(p, "o/r#1") || !strings.Contains(p, "Steps:\n1. run") {
+		t.Errorf("Prompt() = %q", p)
+	}
+	for _, n := range []int{2, 3} {
+		if _, err := FetchIssue(t.Context(), "", "o", "r", n); err == nil {
+			t.Errorf("%d: expected error", n)
+		}
+	}
+}
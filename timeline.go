@@ -0,0 +1,228 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimelineEvent is an entry of a container's [Timeline].
+type TimelineEvent struct {
+	Time time.Time `json:"time"`
+	// Kind is "created", "start", "push" or "pull" for md's operations,
+	// "command" for an interactive shell command, or the git operation
+	// moving the container's HEAD: "commit", "checkout", "reset", "rebase",
+	// "merge", ...
+	Kind string `json:"kind"`
+	// Repo is the repository concerned, empty for the container's events.
+	Repo string `json:"repo,omitempty"`
+	// Commit is the commit the event produced or saved.
+	Commit  string `json:"commit,omitempty"`
+	Message string `json:"message"`
+}
+
+// Timeline is the chronological history of a container, reconstructed from
+// what it records: the reflogs of HEAD and base and the backup branches of
+// its repositories, and its timestamped shell history.
+type Timeline struct {
+	Container string          `json:"container"`
+	Events    []TimelineEvent `json:"events"`
+}
+
+// pushWindow is how long after saving its backup branch a push updates base.
+const pushWindow = 5 * time.Minute
+
+// timelineScript prints, for the repository in the current directory, the
+// reflogs of HEAD and base and the backup branches for parseRepoTimeline.
+const timelineScript = "echo '# head'; git log -g --date=unix --format='%gd%x09%H%x09%gs' HEAD -- 2>/dev/null" +
+	"; echo '# base'; git log -g --date=unix --format='%gd%x09%H%x09%gs' refs/heads/base -- 2>/dev/null" +
+	"; echo '# backup'; git for-each-ref --format='%(refname:short)%09%(objectname)' 'refs/heads/backup-*'"
+
+// Timeline reconstructs the history of the running container. md doesn't log
+// its operations; they are recovered from their traces in git: a push saves
+// a backup branch then moves base, a pull moves base alone. Uncommitted
+// changes and the commands agents ran aren't recorded. Shell commands are
+// recorded with their time only when bash has HISTTIMEFORMAT set, like in
+// md's image.
+func (c *Container) Timeline(ctx context.Context) (*Timeline, error) {
+	if err := c.checkContainerState(ctx); err != nil {
+		return nil, err
+	}
+	t := &Timeline{Container: c.Name}
+	if !c.CreatedAt.IsZero() {
+		t.Events = append(t.Events, TimelineEvent{Time: c.CreatedAt, Kind: "created", Message: "container created"})
+	}
+	if c.MountSource.ownsGit() {
+		for i := range c.Repos {
+			name := c.Repos[i].Name()
+			out, err := runCmdRetry(ctx, "", c.SSHCommand(c.Name, "cd ~/src/"+shellQuote(name)+" && { "+timelineScript+"; }"))
+			if err != nil {
+				return nil, cmdErrWithStderr("reading the history of "+name, err)
+			}
+			t.Events = append(t.Events, parseRepoTimeline(name, out, time.Local)...)
+		}
+	}
+	// The history is optional: ignore errors.
+	out, _ := runCmdRetry(ctx, "", c.SSHCommand(c.Name, "cat ~/.bash_history 2>/dev/null"))
+	t.Events = append(t.Events, parseShellHistory(out)...)
+	slices.SortStableFunc(t.Events, func(a, b TimelineEvent) int { return a.Time.Compare(b.Time) })
+	return t, nil
+}
+
+// parseRepoTimeline returns the events of repo from the output of
+// timelineScript. Backup branch names are in the host's time zone loc.
+func parseRepoTimeline(repo, out string, loc *time.Location) []TimelineEvent {
+	var events, base []TimelineEvent
+	var backups []time.Time
+	section := ""
+	for line := range strings.SplitSeq(out, "\n") {
+		if s, ok := strings.CutPrefix(line, "# "); ok {
+			section = s
+			continue
+		}
+		f := strings.SplitN(line, "\t", 3)
+		switch section {
+		case "head", "base":
+			if len(f) != 3 {
+				continue
+			}
+			ts, ok := reflogTime(f[0])
+			if !ok {
+				continue
+			}
+			if section == "base" {
+				base = append(base, TimelineEvent{Time: ts, Repo: repo, Commit: f[1]})
+				continue
+			}
+			kind, msg := f[2], ""
+			if k, m, ok := strings.Cut(f[2], ": "); ok {
+				kind, msg = k, m
+			}
+			// "commit (amend)", "rebase (finish)", ...
+			kind, detail, _ := strings.Cut(kind, " ")
+			if detail != "" {
+				msg = strings.Trim(detail, "()") + ": " + msg
+			}
+			events = append(events, TimelineEvent{Time: ts, Kind: kind, Repo: repo, Commit: f[1], Message: msg})
+		case "backup":
+			if len(f) != 2 {
+				continue
+			}
+			ts, err := time.ParseInLocation("20060102-150405", strings.TrimPrefix(f[0], "backup-"), loc)
+			if err != nil {
+				continue
+			}
+			backups = append(backups, ts)
+			events = append(events, TimelineEvent{Time: ts, Kind: "push", Repo: repo, Commit: f[1], Message: "md push, container state saved in " + f[0]})
+		}
+	}
+	// The reflog is newest first: the oldest update of base is the setup.
+	for i, e := range base {
+		switch {
+		case i == len(base)-1:
+			e.Kind, e.Message = "start", "md set up base"
+		case slices.ContainsFunc(backups, func(b time.Time) bool { d := e.Time.Sub(b); return d >= 0 && d < pushWindow }):
+			// Part of a push, reported with its backup branch.
+			continue
+		default:
+			e.Kind, e.Message = "pull", "md pull, base updated"
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+// reflogTime parses the time of a reflog selector printed with --date=unix,
+// like "HEAD@{1700000000}".
+func reflogTime(s string) (time.Time, bool) {
+	_, v, ok := strings.Cut(s, "@{")
+	if !ok {
+		return time.Time{}, false
+	}
+	n, err := strconv.ParseInt(strings.TrimSuffix(v, "}"), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(n, 0), true
+}
+
+// parseShellHistory returns the commands of a bash history file having a
+// "#<unix time>" line before them, as written with HISTTIMEFORMAT set.
+func parseShellHistory(out string) []TimelineEvent {
+	var events []TimelineEvent
+	var ts time.Time
+	for line := range strings.SplitSeq(out, "\n") {
+		if v, ok := strings.CutPrefix(line, "#"); ok {
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+				ts = time.Unix(n, 0)
+				continue
+			}
+		}
+		if ts.IsZero() || strings.TrimSpace(line) == "" {
+			continue
+		}
+		events = append(events, TimelineEvent{Time: ts, Kind: "command", Message: line})
+		ts = time.Time{}
+	}
+	return events
+}
+
+// WriteMarkdown writes the timeline as a markdown table.
+func (t *Timeline) WriteMarkdown(w io.Writer) error {
+	esc := strings.NewReplacer("|", `\|`, "\n", " ")
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Timeline of %s\n\n", t.Container)
+	b.WriteString("| Time | Repo | Event | Commit | Details |\n|---|---|---|---|---|\n")
+	for i := range t.Events {
+		e := &t.Events[i]
+		commit := ""
+		if e.Commit != "" {
+			commit = "`" + shortSHA(e.Commit) + "`"
+		}
+		fmt.Fprintf(&b, "| %s | %s | %s | %s | %s |\n", e.Time.Local().Format(time.DateTime), esc.Replace(e.Repo), e.Kind, commit, esc.Replace(e.Message))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var timelineHTML = template.Must(template.New("").Funcs(template.FuncMap{
+	"time":  func(t time.Time) string { return t.Local().Format(time.DateTime) },
+	"short": shortSHA,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Timeline of {{.Container}}</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 2px 6px; text-align: left; vertical-align: top; }
+td.kind-push, td.kind-pull, td.kind-start, td.kind-created { font-weight: bold; }
+code { white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<h1>Timeline of {{.Container}}</h1>
+<table>
+<tr><th>Time</th><th>Repo</th><th>Event</th><th>Commit</th><th>Details</th></tr>
+{{- range .Events}}
+<tr><td>{{time .Time}}</td><td>{{.Repo}}</td><td class="kind-{{.Kind}}">{{.Kind}}</td><td><code>{{short .Commit}}</code></td><td>{{if eq .Kind "command"}}<code>{{.Message}}</code>{{else}}{{.Message}}{{end}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+// WriteHTML writes the timeline as a standalone HTML page.
+func (t *Timeline) WriteHTML(w io.Writer) error {
+	return timelineHTML.Execute(w, t)
+}
@@ -0,0 +1,89 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRepoTimeline(t *testing.T) {
+	push := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	ref := func(name string, d time.Duration) string {
+		return name + "@{" + strconv.FormatInt(push.Add(d).Unix(), 10) + "}"
+	}
+	out := "# head\n" +
+		ref("HEAD", 2*time.Hour) + "\tccc\tcommit (amend): Fix the parser\n" +
+		ref("HEAD", time.Hour) + "\tbbb\tcommit: Add the parser\n" +
+		ref("HEAD", 10*time.Second) + "\taaa\tcheckout: moving from main to main\n" +
+		"# base\n" +
+		ref("base", 3*time.Hour) + "\tddd\tpush\n" +
+		ref("base", 5*time.Second) + "\taaa\tpush\n" +
+		ref("base", -time.Hour) + "\t000\tpush\n" +
+		"# backup\n" +
+		"backup-20260310-120000\tfff\n" +
+		"backup-garbage\tggg\n"
+	got := parseRepoTimeline("r", out, time.UTC)
+	want := []TimelineEvent{
+		{Time: push.Add(2 * time.Hour), Kind: "commit", Repo: "r", Commit: "ccc", Message: "amend: Fix the parser"},
+		{Time: push.Add(time.Hour), Kind: "commit", Repo: "r", Commit: "bbb", Message: "Add the parser"},
+		{Time: push.Add(10 * time.Second), Kind: "checkout", Repo: "r", Commit: "aaa", Message: "moving from main to main"},
+		{Time: push, Kind: "push", Repo: "r", Commit: "fff", Message: "md push, container state saved in backup-20260310-120000"},
+		{Time: push.Add(3 * time.Hour), Kind: "pull", Repo: "r", Commit: "ddd", Message: "md pull, base updated"},
+		{Time: push.Add(-time.Hour), Kind: "start", Repo: "r", Commit: "000", Message: "md set up base"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d events, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if !g.Time.Equal(w.Time) || g.Kind != w.Kind || g.Repo != w.Repo || g.Commit != w.Commit || g.Message != w.Message {
+			t.Errorf("#%d: got %+v, want %+v", i, g, w)
+		}
+	}
+}
+
+func TestParseShellHistory(t *testing.T) {
+	got := parseShellHistory("ls\n#1700000000\ngo test ./...\n#1700000060\n\n#not a time\n#1700000120\ngit status\n")
+	want := []string{"go test ./...", "#not a time", "git status"}
+	if len(got) != len(want) {
+		t.Fatalf("got %+v", got)
+	}
+	for i, e := range got {
+		if e.Kind != "command" || e.Message != want[i] {
+			t.Errorf("#%d: got %+v", i, e)
+		}
+	}
+	if !got[0].Time.Equal(time.Unix(1700000000, 0)) || !got[2].Time.Equal(time.Unix(1700000120, 0)) {
+		t.Errorf("times: %v, %v", got[0].Time, got[2].Time)
+	}
+}
+
+func TestTimelineWrite(t *testing.T) {
+	tl := &Timeline{Container: "md-r-main", Events: []TimelineEvent{
+		{Time: time.Unix(1700000000, 0), Kind: "commit", Repo: "r", Commit: "0123456789abcdef", Message: "a | b"},
+		{Time: time.Unix(1700000060, 0), Kind: "command", Message: "echo <x>"},
+	}}
+	var md strings.Builder
+	if err := tl.WriteMarkdown(&md); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"# Timeline of md-r-main", "| commit | `0123456789ab` | a \\| b |", "| command |  | echo <x> |"} {
+		if !strings.Contains(md.String(), s) {
+			t.Errorf("markdown lacks %q:\n%s", s, md.String())
+		}
+	}
+	var html strings.Builder
+	if err := tl.WriteHTML(&html); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"<title>Timeline of md-r-main</title>", "<code>0123456789ab</code>", "<code>echo &lt;x&gt;</code>"} {
+		if !strings.Contains(html.String(), s) {
+			t.Errorf("HTML lacks %q:\n%s", s, html.String())
+		}
+	}
+}
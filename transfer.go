@@ -0,0 +1,154 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/caic-xyz/md/gitutil"
+	"golang.org/x/term"
+)
+
+// SyncResult is the outcome of [Container.Push] or [Container.Pull].
+type SyncResult struct {
+	// Backup is the branch in the container saving its state before a push.
+	Backup string `json:"backup,omitempty"`
+	// Stat is what changed: in the container's base for a push, in the local
+	// branch for a pull. It is nil when unknown, e.g. when the container's
+	// base isn't in the host's repository.
+	Stat *DiffStat `json:"stat,omitempty"`
+	// Uncommitted is the host's uncommitted changes sent by a push with
+	// [PushOpts.IncludeUntracked].
+	Uncommitted *DiffStat `json:"uncommitted,omitempty"`
+}
+
+// DiffStat summarizes the changes between two commits.
+type DiffStat struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Commits counts the commits in To and not in From, ignoring the ones
+	// equivalent to a commit of From, like rebased ones.
+	Commits    int `json:"commits"`
+	Files      int `json:"files_changed"`
+	Insertions int `json:"insertions"`
+	Deletions  int `json:"deletions"`
+}
+
+func (d *DiffStat) String() string {
+	if d.From == d.To {
+		return "up to date"
+	}
+	return fmt.Sprintf("%d commit(s), %d file(s) changed, +%d -%d", d.Commits, d.Files, d.Insertions, d.Deletions)
+}
+
+// diffStat returns the changes from the commit from to to in the repository
+// at dir, or nil when from isn't known.
+func diffStat(ctx context.Context, dir, from, to string) *DiffStat {
+	if from == "" {
+		return nil
+	}
+	to, err := gitutil.RunGit(ctx, dir, "rev-parse", "--verify", "-q", to+"^{commit}")
+	if err != nil {
+		return nil
+	}
+	if _, err := gitutil.RunGit(ctx, dir, "cat-file", "-e", from+"^{commit}"); err != nil {
+		return nil
+	}
+	d := &DiffStat{From: from, To: to}
+	if from == to {
+		return d
+	}
+	n, err := gitutil.RunGit(ctx, dir, "rev-list", "--count", "--right-only", "--cherry-pick", from+"..."+to)
+	if err != nil {
+		return nil
+	}
+	d.Commits, _ = strconv.Atoi(n)
+	short, err := gitutil.RunGit(ctx, dir, "diff", "--shortstat", from, to)
+	if err != nil {
+		return nil
+	}
+	d.Files, d.Insertions, d.Deletions = parseShortStat(short)
+	return d
+}
+
+// parseShortStat parses the output of git diff --shortstat, e.g.
+// " 3 files changed, 10 insertions(+), 1 deletion(-)".
+func parseShortStat(s string) (files, insertions, deletions int) {
+	for part := range strings.SplitSeq(s, ",") {
+		f := strings.Fields(part)
+		if len(f) < 2 {
+			continue
+		}
+		n, err := strconv.Atoi(f[0])
+		if err != nil {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(f[1], "file"):
+			files = n
+		case strings.HasPrefix(f[1], "insertion"):
+			insertions = n
+		case strings.HasPrefix(f[1], "deletion"):
+			deletions = n
+		}
+	}
+	return files, insertions, deletions
+}
+
+// progressLine shows git's transfer progress as a single line redrawn in
+// place.
+type progressLine struct {
+	w     io.Writer
+	label string
+	shown bool
+}
+
+// newProgress returns the writer for git's progress output of the transfer
+// described by label and the function erasing the line once it's done. The
+// writer is nil when stdout isn't a terminal, so logs and machine-readable
+// output don't get the redraws.
+func newProgress(stdout io.Writer, label string) (io.Writer, func()) {
+	f, ok := stdout.(*os.File)
+	if !ok || !term.IsTerminal(int(f.Fd())) {
+		return nil, func() {}
+	}
+	p := &progressLine{w: stdout, label: label}
+	return gitutil.NewProgressWriter(p.update), p.done
+}
+
+func (p *progressLine) update(pr gitutil.Progress) {
+	p.shown = true
+	_, _ = fmt.Fprintf(p.w, "\r- %s: %s\x1b[K", p.label, formatProgress(pr))
+}
+
+func (p *progressLine) done() {
+	if p.shown {
+		_, _ = io.WriteString(p.w, "\r\x1b[K")
+	}
+}
+
+// formatProgress returns the text of a progress update, e.g.
+// "Writing objects 45% (9/20), 1.20 MiB".
+func formatProgress(p gitutil.Progress) string {
+	var b strings.Builder
+	if p.Remote {
+		b.WriteString("remote: ")
+	}
+	b.WriteString(p.Phase)
+	if p.Percent < 0 {
+		_, _ = fmt.Fprintf(&b, " %d", p.Done)
+	} else {
+		_, _ = fmt.Fprintf(&b, " %d%% (%d/%d)", p.Percent, p.Done, p.Total)
+	}
+	if p.Transferred != "" {
+		b.WriteString(", " + p.Transferred)
+	}
+	return b.String()
+}
@@ -0,0 +1,103 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/caic-xyz/md/gitutil"
+)
+
+func TestParseShortStat(t *testing.T) {
+	for _, tc := range []struct {
+		in               string
+		files, ins, dels int
+	}{
+		{"", 0, 0, 0},
+		{" 1 file changed, 1 insertion(+)", 1, 1, 0},
+		{" 3 files changed, 10 insertions(+), 2 deletions(-)", 3, 10, 2},
+		{" 2 files changed, 4 deletions(-)", 2, 0, 4},
+	} {
+		f, i, d := parseShortStat(tc.in)
+		if f != tc.files || i != tc.ins || d != tc.dels {
+			t.Errorf("%q: got %d, %d, %d", tc.in, f, i, d)
+		}
+	}
+}
+
+func TestDiffStat(t *testing.T) {
+	ctx := t.Context()
+	dir := t.TempDir()
+	git := func(args ...string) string {
+		t.Helper()
+		cmd := exec.CommandContext(ctx, "git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@test"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git("init", "-q", "--initial-branch=main")
+	write("a", "1\n2\n")
+	git("add", ".")
+	git("commit", "-q", "-m", "base")
+	base := git("rev-parse", "HEAD")
+	// The local commit, rebased on top of the container's two.
+	git("switch", "-q", "-c", "local")
+	write("l", "l\n")
+	git("add", ".")
+	git("commit", "-q", "-m", "local")
+	local := git("rev-parse", "HEAD")
+	git("switch", "-q", "main")
+	write("a", "1\n3\n")
+	git("commit", "-q", "-am", "one")
+	write("b", "b\n")
+	git("add", ".")
+	git("commit", "-q", "-m", "two")
+	git("switch", "-q", "local")
+	git("rebase", "-q", "main")
+
+	d := diffStat(ctx, dir, local, "local")
+	want := DiffStat{From: local, To: git("rev-parse", "HEAD"), Commits: 2, Files: 2, Insertions: 2, Deletions: 1}
+	if d == nil || *d != want {
+		t.Fatalf("got %+v\nwant %+v", d, want)
+	}
+	if got := d.String(); got != "2 commit(s), 2 file(s) changed, +2 -1" {
+		t.Errorf("got %q", got)
+	}
+	if d := diffStat(ctx, dir, base, base); d == nil || d.String() != "up to date" {
+		t.Errorf("got %+v", d)
+	}
+	for _, from := range []string{"", strings.Repeat("0", 40)} {
+		if d := diffStat(ctx, dir, from, "main"); d != nil {
+			t.Errorf("%q: got %+v", from, d)
+		}
+	}
+}
+
+func TestFormatProgress(t *testing.T) {
+	for _, tc := range []struct {
+		p    gitutil.Progress
+		want string
+	}{
+		{gitutil.Progress{Phase: "Writing objects", Percent: 45, Done: 9, Total: 20, Transferred: "1.20 MiB"}, "Writing objects 45% (9/20), 1.20 MiB"},
+		{gitutil.Progress{Phase: "Counting objects", Remote: true, Percent: -1, Done: 7}, "remote: Counting objects 7"},
+	} {
+		if got := formatProgress(tc.p); got != tc.want {
+			t.Errorf("got %q, want %q", got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caic-xyz/md/gitutil"
+)
+
+// verifySSHRuns is the number of SSH round trips Verify measures.
+const verifySSHRuns = 3
+
+// sshLatencyWarn is the SSH round trip above which Verify warns.
+const sshLatencyWarn = time.Second
+
+// Verify runs end-to-end checks against the running container so automation
+// can confirm it is usable before handing it to an agent: the SSH round
+// trip, fetching from and pushing to the git remote of each repository, the
+// base and task branches in the container, and the display and Tailscale
+// when enabled. It never modifies anything. The container's State must be
+// set, as done by [Client.List].
+func (c *Container) Verify(ctx context.Context) []Check {
+	if c.State != "running" {
+		return []Check{{Name: "container", Status: CheckFail, Detail: "state " + c.State, Fix: "md resume"}}
+	}
+	if err := c.checkContainerState(ctx); err != nil {
+		return []Check{{Name: "container", Status: CheckFail, Detail: err.Error(), Fix: "md purge, then md start"}}
+	}
+	checks := []Check{{Name: "container", Status: CheckOK, Detail: "running"}}
+	ssh := c.verifySSH(ctx)
+	checks = append(checks, ssh)
+	if ssh.Status == CheckFail {
+		// Everything else goes through SSH.
+		return checks
+	}
+	if c.MountSource.ownsGit() {
+		for _, r := range c.Repos {
+			checks = append(checks, c.verifyRepo(ctx, r)...)
+		}
+	}
+	if c.Display {
+		checks = append(checks, c.verifyDisplay(ctx))
+	}
+	if c.Tailscale {
+		checks = append(checks, c.verifyTailscale(ctx))
+	}
+	return checks
+}
+
+// verifySSH measures the median SSH round trip.
+func (c *Container) verifySSH(ctx context.Context) Check {
+	chk := Check{Name: "ssh"}
+	args := c.SSHCommand(c.Name, "true")
+	d := make([]time.Duration, 0, verifySSHRuns)
+	for range verifySSHRuns {
+		start := time.Now()
+		if out, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput(); err != nil {
+			chk.Status = CheckFail
+			chk.Detail = strings.TrimSpace(fmt.Sprintf("%v\n%s", err, out))
+			chk.Fix = "md logs --service sshd"
+			return chk
+		}
+		d = append(d, time.Since(start))
+	}
+	m := median(d)
+	chk.Status = CheckOK
+	chk.Detail = fmt.Sprintf("round trip %s", m.Round(time.Millisecond))
+	if m > sshLatencyWarn {
+		chk.Status = CheckWarn
+		chk.Fix = "start the container with md --control-master start to share connections"
+	}
+	return chk
+}
+
+// verifyRepo checks the git remote of r both ways and the branches in the
+// container.
+func (c *Container) verifyRepo(ctx context.Context, r Repo) []Check {
+	name := r.Name()
+	fetch := Check{Name: "git-fetch " + name, Status: CheckOK}
+	refs := map[string]string{}
+	out, err := gitutil.RunGit(ctx, r.GitRoot, "ls-remote", c.Name, "refs/heads/base", "refs/heads/"+r.Branch)
+	if err != nil {
+		fetch.Status = CheckFail
+		fetch.Detail = err.Error()
+		fetch.Fix = "git remote get-url " + c.Name
+	} else {
+		refs = parseLsRemote(out)
+		fetch.Detail = fmt.Sprintf("%d refs", len(refs))
+	}
+
+	// A dry run negotiates with receive-pack without updating anything.
+	push := Check{Name: "git-push " + name, Status: CheckOK}
+	if _, err := gitutil.RunGit(ctx, r.GitRoot, "push", "--dry-run", "-q", c.Name, "HEAD:refs/md/verify"); err != nil {
+		push.Status = CheckFail
+		push.Detail = err.Error()
+		push.Fix = "git remote get-url " + c.Name
+	}
+
+	script := "cd ~/src/" + shellQuote(name) + " && " +
+		`echo "head $(git symbolic-ref -q --short HEAD)"; ` +
+		`git merge-base --is-ancestor base HEAD 2>/dev/null && echo contains; true`
+	out, err = runCmd(ctx, "", c.SSHCommand(c.Name, script))
+	if err != nil {
+		return []Check{fetch, push, {Name: "branches " + name, Status: CheckFail, Detail: err.Error()}}
+	}
+	hostHasBase := false
+	if base := refs["refs/heads/base"]; base != "" {
+		_, err := gitutil.RunGit(ctx, r.GitRoot, "cat-file", "-e", base+"^{commit}")
+		hostHasBase = err == nil
+	}
+	return []Check{fetch, push, branchCheck(r, refs, out, hostHasBase)}
+}
+
+// parseLsRemote parses git ls-remote output into a map of ref to SHA.
+func parseLsRemote(out string) map[string]string {
+	refs := map[string]string{}
+	for line := range strings.SplitSeq(out, "\n") {
+		if sha, ref, ok := strings.Cut(line, "\t"); ok {
+			refs[ref] = sha
+		}
+	}
+	return refs
+}
+
+// branchCheck checks the container's branches of r: refs are the
+// container's from git ls-remote, out is the output of the script run in the
+// container by verifyRepo and hostHasBase reports whether the host has the
+// base commit.
+func branchCheck(r Repo, refs map[string]string, out string, hostHasBase bool) Check {
+	chk := Check{Name: "branches " + r.Name()}
+	var head string
+	contains := false
+	for line := range strings.SplitSeq(out, "\n") {
+		if v, ok := strings.CutPrefix(line, "head "); ok {
+			head = v
+		} else if line == "contains" {
+			contains = true
+		}
+	}
+	base, branch := refs["refs/heads/base"], refs["refs/heads/"+r.Branch]
+	switch {
+	case base == "":
+		chk.Status = CheckFail
+		chk.Detail = "no base branch in the container"
+		chk.Fix = "md push"
+	case branch == "":
+		chk.Status = CheckFail
+		chk.Detail = fmt.Sprintf("no branch %s in the container", r.Branch)
+		chk.Fix = "md push"
+	case head != r.Branch:
+		chk.Status = CheckFail
+		chk.Detail = fmt.Sprintf("HEAD is %q, want %s", head, r.Branch)
+		chk.Fix = "git switch " + r.Branch + " in the container"
+	case !contains:
+		chk.Status = CheckWarn
+		chk.Detail = fmt.Sprintf("%s doesn't contain base %s: it was rebased or reset in the container", r.Branch, shortSHA(base))
+		chk.Fix = "md push"
+	case !hostHasBase:
+		chk.Status = CheckWarn
+		chk.Detail = fmt.Sprintf("base %s is unknown on the host", shortSHA(base))
+		chk.Fix = "md push"
+	default:
+		chk.Status = CheckOK
+		chk.Detail = fmt.Sprintf("%s at %s, base %s", r.Branch, shortSHA(branch), shortSHA(base))
+	}
+	return chk
+}
+
+// shortSHA abbreviates a commit SHA for display.
+func shortSHA(sha string) string {
+	if len(sha) > 12 {
+		return sha[:12]
+	}
+	return sha
+}
+
+// verifyDisplay checks that each virtual display's server runs.
+func (c *Container) verifyDisplay(ctx context.Context) Check {
+	chk := Check{Name: "display"}
+	n := max(1, c.Displays)
+	var script strings.Builder
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&script, `pgrep -f "^Xvnc :%d " >/dev/null || echo %d; `, i, i)
+	}
+	out, err := runCmd(ctx, "", c.SSHCommand(c.Name, script.String()))
+	switch {
+	case err != nil:
+		chk.Status = CheckFail
+		chk.Detail = err.Error()
+	case out != "":
+		chk.Status = CheckFail
+		chk.Detail = "not running: display " + strings.Join(strings.Fields(out), ", ")
+		chk.Fix = "md logs --service xvnc"
+	default:
+		chk.Status = CheckOK
+		chk.Detail = strconv.Itoa(n) + " running"
+	}
+	return chk
+}
+
+// verifyTailscale checks that the container is connected to the tailnet.
+func (c *Container) verifyTailscale(ctx context.Context) Check {
+	chk := Check{Name: "tailscale"}
+	out, err := runCmd(ctx, "", []string{c.Runtime, "exec", c.Name, "tailscale", "status", "--json"})
+	var status tailscaleStatus
+	if err == nil {
+		err = json.Unmarshal([]byte(out), &status)
+	}
+	switch {
+	case err != nil:
+		chk.Status = CheckFail
+		chk.Detail = err.Error()
+		chk.Fix = "md logs --service tailscaled"
+	case status.BackendState != "Running":
+		chk.Status = CheckFail
+		chk.Detail = "state " + status.BackendState
+		chk.Fix = "md logs --service tailscaled"
+	default:
+		chk.Status = CheckOK
+		chk.Detail = strings.TrimSuffix(status.Self.DNSName, ".")
+	}
+	return chk
+}
@@ -0,0 +1,50 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"maps"
+	"strings"
+	"testing"
+)
+
+func TestParseLsRemote(t *testing.T) {
+	got := parseLsRemote("1111111111111111111111111111111111111111\trefs/heads/base\n2222222222222222222222222222222222222222\trefs/heads/main\n")
+	want := map[string]string{
+		"refs/heads/base": "1111111111111111111111111111111111111111",
+		"refs/heads/main": "2222222222222222222222222222222222222222",
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestBranchCheck(t *testing.T) {
+	r := Repo{GitRoot: "/src/repo", Branch: "main"}
+	base := strings.Repeat("1", 40)
+	refs := map[string]string{"refs/heads/base": base, "refs/heads/main": strings.Repeat("2", 40)}
+	for _, tc := range []struct {
+		name        string
+		refs        map[string]string
+		out         string
+		hostHasBase bool
+		status      string
+		detail      string
+	}{
+		{"ok", refs, "head main\ncontains", true, CheckOK, "main at 222222222222, base 111111111111"},
+		{"no_base", map[string]string{"refs/heads/main": base}, "head main", true, CheckFail, "no base branch in the container"},
+		{"no_branch", map[string]string{"refs/heads/base": base}, "head main", true, CheckFail, "no branch main in the container"},
+		{"detached", refs, "head \ncontains", true, CheckFail, `HEAD is "", want main`},
+		{"reset", refs, "head main", true, CheckWarn, "main doesn't contain base 111111111111: it was rebased or reset in the container"},
+		{"host_gc", refs, "head main\ncontains", false, CheckWarn, "base 111111111111 is unknown on the host"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			chk := branchCheck(r, tc.refs, tc.out, tc.hostHasBase)
+			if chk.Name != "branches repo" || chk.Status != tc.status || chk.Detail != tc.detail {
+				t.Errorf("got %+v", chk)
+			}
+		})
+	}
+}
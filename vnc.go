@@ -0,0 +1,92 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// vncPasswordLen is the length of the generated VNC passwords. VNC
+// authentication only uses the first 8 characters of a password.
+const vncPasswordLen = 8
+
+// newVNCPassword returns a random VNC password.
+func newVNCPassword() string {
+	// Unambiguous characters, as the password may be typed from md vnc's
+	// output.
+	const alphabet = "abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	// Bytes past the last multiple of len(alphabet) are rejected so every
+	// character is equally likely.
+	const limit = 256 - 256%len(alphabet)
+	var buf [vncPasswordLen]byte
+	var b [1]byte
+	for i := 0; i < len(buf); {
+		_, _ = rand.Read(b[:])
+		if int(b[0]) < limit {
+			buf[i] = alphabet[int(b[0])%len(alphabet)]
+			i++
+		}
+	}
+	return string(buf[:])
+}
+
+// vncPasswordPath is where the password of the container's displays is kept
+// on the host.
+func (c *Container) vncPasswordPath() string {
+	return filepath.Join(c.XDGStateHome, "md", "vnc", c.Name+".passwd")
+}
+
+// writeVNCPassword records the password of the container's displays.
+func (c *Container) writeVNCPassword(password string) error {
+	p := c.vncPasswordPath()
+	if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(p, []byte(password+"\n"), 0o600)
+}
+
+// vncPasswordScript writes its stdin to the file vnc-start.sh waits for,
+// readable by root only. An image built before MD_VNC_AUTH would ignore it
+// and leave the displays open, so it is refused.
+const vncPasswordScript = "if ! grep -q MD_VNC_AUTH /root/vnc-start.sh; then echo 'the image predates VNC passwords sent by md; rebuild it with md build-image or pull a newer one' >&2; exit 1; fi; " +
+	"umask 077 && mkdir -p /root/.vnc && cat >/root/.vnc/md-password.tmp && mv /root/.vnc/md-password.tmp /root/.vnc/md-password"
+
+// sendVNCPassword gives the password of the displays to the container, whose
+// vnc-start.sh waits for it on every start. It goes through exec's stdin, so
+// it is in neither the container's configuration nor any process' arguments
+// or environment.
+func (c *Container) sendVNCPassword(ctx context.Context) error {
+	password, err := c.VNCPassword()
+	if err != nil || password == "" {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, c.Runtime, "exec", "-i", "-u", "root", c.Name, "sh", "-c", vncPasswordScript)
+	cmd.Stdin = strings.NewReader(password + "\n")
+	cmd.WaitDelay = cmdWaitDelay
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("sending the VNC password: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// VNCPassword returns the password of the container's VNC displays, also
+// asked by RDP clients. It returns "" without error for a container started
+// before md generated passwords, whose displays accept any connection.
+func (c *Container) VNCPassword() (string, error) {
+	data, err := os.ReadFile(c.vncPasswordPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("reading VNC password: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
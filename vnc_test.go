@@ -0,0 +1,81 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestVNCPassword(t *testing.T) {
+	c := &Container{Client: &Client{XDGStateHome: t.TempDir()}, Name: "md-repo-main"}
+	if got, err := c.VNCPassword(); err != nil || got != "" {
+		t.Fatalf("no password: %q, %v", got, err)
+	}
+	want := newVNCPassword()
+	if len(want) != vncPasswordLen || strings.ContainsAny(want, "0O1lI") {
+		t.Fatalf("bad password %q", want)
+	}
+	if other := newVNCPassword(); other == want {
+		t.Errorf("same password twice: %q", want)
+	}
+	if err := c.writeVNCPassword(want); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := c.VNCPassword(); err != nil || got != want {
+		t.Errorf("got %q, %v, want %q", got, err, want)
+	}
+	if runtime.GOOS == "windows" {
+		return
+	}
+	fi, err := os.Stat(c.vncPasswordPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o600 {
+		t.Errorf("mode = %v", fi.Mode())
+	}
+}
+
+func TestSendVNCPassword(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	tmp := t.TempDir()
+	// The engine records its arguments, then its stdin.
+	out := filepath.Join(tmp, "engine.out")
+	engine := filepath.Join(tmp, "engine")
+	if err := os.WriteFile(engine, []byte("#!/bin/sh\necho \"$@\" > "+out+"\ncat >> "+out+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	c := &Container{Client: &Client{Runtime: engine, XDGStateHome: tmp}, Name: "md-repo-main"}
+	// Without a password, nothing is sent.
+	if err := c.sendVNCPassword(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(out); err == nil {
+		t.Fatal("sent without a password")
+	}
+	if err := c.writeVNCPassword("s3cretpw"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.sendVNCPassword(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args, stdin, _ := strings.Cut(string(b), "\n")
+	if strings.Contains(args, "s3cretpw") || !strings.HasPrefix(args, "exec -i -u root md-repo-main sh -c ") {
+		t.Errorf("args: %q", args)
+	}
+	if stdin != "s3cretpw\n" {
+		t.Errorf("stdin: %q", stdin)
+	}
+}
@@ -0,0 +1,205 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// cacheWarmer downloads a repository's dependencies into the host
+// directories of a well-known cache.
+type cacheWarmer struct {
+	// tool is the host command it runs.
+	tool string
+	// needs are the files, relative to the repository's root, the command
+	// needs.
+	needs []string
+	// isolate runs the command in a temporary directory holding a copy of
+	// needs, for package managers that would otherwise write the installed
+	// packages in the checkout.
+	isolate bool
+	// cmd returns the command line and its added environment, given the
+	// cache's host directories and the directory it runs in.
+	cmd func(dirs []string, dir string) (args, env []string)
+}
+
+// cacheWarmers are the well-known caches [Client.WarmCaches] can fill.
+var cacheWarmers = map[string]cacheWarmer{
+	"cargo": {
+		tool:  "cargo",
+		needs: []string{"Cargo.toml"},
+		cmd: func(dirs []string, dir string) ([]string, []string) {
+			args := []string{"cargo", "fetch"}
+			if _, err := os.Stat(filepath.Join(dir, "Cargo.lock")); err == nil {
+				args = append(args, "--locked")
+			}
+			// The registry and git caches are in $CARGO_HOME.
+			return args, []string{"CARGO_HOME=" + filepath.Dir(dirs[0])}
+		},
+	},
+	"go-mod": {
+		tool:  "go",
+		needs: []string{"go.mod"},
+		cmd: func(dirs []string, _ string) ([]string, []string) {
+			return []string{"go", "mod", "download"}, []string{"GOMODCACHE=" + dirs[0], "GOFLAGS=-mod=mod"}
+		},
+	},
+	"npm": {
+		tool:    "npm",
+		needs:   []string{"package.json", "package-lock.json"},
+		isolate: true,
+		cmd: func(dirs []string, _ string) ([]string, []string) {
+			return []string{"npm", "ci", "--ignore-scripts", "--no-audit", "--no-fund", "--cache", dirs[0]}, nil
+		},
+	},
+	"pnpm": {
+		tool:    "pnpm",
+		needs:   []string{"pnpm-lock.yaml"},
+		isolate: true,
+		cmd: func(dirs []string, _ string) ([]string, []string) {
+			return []string{"pnpm", "fetch", "--ignore-scripts", "--store-dir", dirs[0]}, nil
+		},
+	},
+}
+
+// Cache warming outcomes, see [WarmResult].
+const (
+	WarmDone    = "warmed"
+	WarmSkipped = "skipped"
+	WarmFailed  = "failed"
+)
+
+// WarmResult is the outcome of warming a cache.
+type WarmResult struct {
+	Cache string `json:"cache"`
+	// Status is [WarmDone], [WarmSkipped] or [WarmFailed].
+	Status string `json:"status"`
+	// Reason explains a skip or a failure.
+	Reason   string        `json:"reason,omitempty"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// WarmableCaches returns the names of the well-known caches
+// [Client.WarmCaches] can fill, sorted.
+func WarmableCaches() []string {
+	return slices.Sorted(maps.Keys(cacheWarmers))
+}
+
+// WarmCaches downloads the dependencies of the repository at gitRoot into
+// the host directories of the well-known caches names, so the next image
+// build copies a hot cache into the container. Empty names warms the caches
+// the repository uses ([DetectCaches]) that can be warmed. It runs the host's
+// toolchain (go mod download, npm ci, pnpm fetch, cargo fetch) with its
+// output on stderr; a cache whose tool isn't installed is skipped. npm and
+// pnpm run in a copy of their lockfiles, so the checkout isn't modified.
+//
+// A failing cache doesn't stop the others; the error is only for invalid
+// names.
+func (c *Client) WarmCaches(ctx context.Context, stdout, stderr io.Writer, gitRoot string, names []string) ([]WarmResult, error) {
+	if len(names) == 0 {
+		for _, name := range DetectCaches(gitRoot) {
+			if _, ok := cacheWarmers[name]; ok {
+				names = append(names, name)
+			}
+		}
+	}
+	for _, name := range names {
+		if _, ok := cacheWarmers[name]; !ok {
+			return nil, fmt.Errorf("cache %q can't be warmed; supported: %v", name, WarmableCaches())
+		}
+	}
+	results := make([]WarmResult, 0, len(names))
+	for _, name := range names {
+		_, _ = fmt.Fprintf(stdout, "- Warming %s ...\n", name)
+		r := c.warmCache(ctx, stderr, gitRoot, name)
+		switch r.Status {
+		case WarmDone:
+			_, _ = fmt.Fprintf(stdout, "- Warmed %s in %s\n", name, r.Duration.Round(100*time.Millisecond))
+		default:
+			_, _ = fmt.Fprintf(stdout, "- %s %s: %s\n", r.Status, name, r.Reason)
+		}
+		results = append(results, r)
+		if ctx.Err() != nil {
+			return results, ctx.Err()
+		}
+	}
+	return results, nil
+}
+
+func (c *Client) warmCache(ctx context.Context, stderr io.Writer, gitRoot, name string) WarmResult {
+	w := cacheWarmers[name]
+	r := WarmResult{Cache: name, Status: WarmSkipped}
+	for _, f := range w.needs {
+		if _, err := os.Stat(filepath.Join(gitRoot, f)); err != nil {
+			r.Reason = "no " + f
+			return r
+		}
+	}
+	if _, err := exec.LookPath(w.tool); err != nil {
+		r.Reason = w.tool + " is not installed on the host"
+		return r
+	}
+	var dirs []string
+	for _, m := range WellKnownCaches[name] {
+		dirs = append(dirs, filepath.FromSlash(resolveHostPath(m.HostPath, c.Home)))
+	}
+	dir := gitRoot
+	if w.isolate {
+		tmp, err := os.MkdirTemp("", "md-warm-*")
+		if err != nil {
+			return WarmResult{Cache: name, Status: WarmFailed, Reason: err.Error()}
+		}
+		defer func() { _ = os.RemoveAll(tmp) }()
+		for _, f := range w.needs {
+			if err := copyFile(filepath.Join(gitRoot, f), filepath.Join(tmp, f)); err != nil {
+				return WarmResult{Cache: name, Status: WarmFailed, Reason: err.Error()}
+			}
+		}
+		dir = tmp
+	}
+	args, env := w.cmd(dirs, dir)
+	slog.DebugContext(ctx, "md", "msg", "exec", "cmd", args, "env", env)
+	start := time.Now()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = stderr
+	cmd.Stderr = stderr
+	cmd.WaitDelay = cmdWaitDelay
+	err := cmd.Run()
+	r.Duration = time.Since(start)
+	if err != nil {
+		r.Status = WarmFailed
+		r.Reason = fmt.Sprintf("%s: %v", args[0], err)
+		if errors.Is(ctx.Err(), context.Canceled) {
+			r.Reason = "canceled"
+		}
+		return r
+	}
+	r.Status = WarmDone
+	return r
+}
+
+// copyFile copies the regular file src to dst.
+func copyFile(src, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, 0o600)
+}
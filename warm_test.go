@@ -0,0 +1,74 @@
+// Copyright 2026 Marc-Antoine Ruel. All Rights Reserved. Use of this
+// source code is governed by the Apache v2 license that can be found in the
+// LICENSE file.
+
+package md
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestWarmCaches(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell")
+	}
+	bin := t.TempDir()
+	log := filepath.Join(t.TempDir(), "log")
+	// Fake toolchains record how they were run; npm writes in its directory
+	// like npm ci does.
+	for _, tool := range []string{"go", "npm"} {
+		script := "#!/bin/sh\necho \"" + tool + " $* GOMODCACHE=$GOMODCACHE\" >>" + log + "\n"
+		if tool == "npm" {
+			script += ": >node_modules\n"
+		}
+		if err := os.WriteFile(filepath.Join(bin, tool), []byte(script), 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", bin)
+	root := t.TempDir()
+	for _, f := range []string{"go.mod", "package.json", "package-lock.json", "Cargo.toml"} {
+		if err := os.WriteFile(filepath.Join(root, f), nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := &Client{Home: "/home/me"}
+
+	got, err := c.WarmCaches(t.Context(), io.Discard, io.Discard, root, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var status []string
+	for _, r := range got {
+		status = append(status, r.Cache+" "+r.Status+" "+r.Reason)
+	}
+	want := []string{"cargo skipped cargo is not installed on the host", "go-mod warmed ", "npm warmed "}
+	if !slices.Equal(status, want) {
+		t.Errorf("got %q\nwant %q", status, want)
+	}
+	data, _ := os.ReadFile(log)
+	wantLog := "go mod download GOMODCACHE=/home/me/go/pkg/mod\nnpm ci --ignore-scripts --no-audit --no-fund --cache /home/me/.npm GOMODCACHE=\n"
+	if string(data) != wantLog {
+		t.Errorf("got %q\nwant %q", data, wantLog)
+	}
+	if _, err := os.Stat(filepath.Join(root, "node_modules")); err == nil {
+		t.Error("npm ran in the checkout")
+	}
+
+	if err := os.Remove(filepath.Join(root, "package-lock.json")); err != nil {
+		t.Fatal(err)
+	}
+	got, err = c.WarmCaches(t.Context(), io.Discard, io.Discard, root, []string{"npm"})
+	if err != nil || len(got) != 1 || got[0].Status != WarmSkipped || got[0].Reason != "no package-lock.json" {
+		t.Errorf("got %+v, %v", got, err)
+	}
+	if _, err := c.WarmCaches(t.Context(), io.Discard, io.Discard, root, []string{"pip"}); err == nil || !strings.Contains(err.Error(), "can't be warmed") {
+		t.Errorf("got %v", err)
+	}
+}